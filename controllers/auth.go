@@ -1,11 +1,20 @@
 package controllers
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
+	"fmt"
+	"log/slog"
 	"net/http"
+	"os"
+	"regexp"
+	"strings"
 	"time"
 	"workbench/internal"
 	"workbench/models"
+	"workbench/services"
 
 	"github.com/The-Skyscape/devtools/pkg/application"
 	"github.com/The-Skyscape/devtools/pkg/authentication"
@@ -35,10 +44,63 @@ type AuthController struct {
 func (c *AuthController) Setup(app *application.App) {
 	c.Controller.Controller.Setup(app)
 
-	// Register only the POST handlers for authentication
+	// Register only the POST handlers for authentication. They stay open in
+	// recovery mode: signing in and out changes no workbench data
 	http.HandleFunc("POST /_auth/signup", c.handleSignup)
 	http.HandleFunc("POST /_auth/signin", c.handleSignin)
 	http.HandleFunc("POST /_auth/signout", c.handleSignout)
+
+	// The recovery console only exists when requested at startup
+	if internal.RecoveryRequested() {
+		c.startRecovery(app)
+	} else {
+		internal.ClearRecoveryConsumed()
+	}
+}
+
+// startRecovery enters recovery mode: prints a one-time code to the logs,
+// where only someone with host access can read it, and registers the
+// /recovery routes. The rest of the app is read-only until it's used.
+func (c *AuthController) startRecovery(app *application.App) {
+	if c.Collection.Users.Count("") == 0 {
+		slog.Warn("recovery code is set but no user exists yet - sign up instead", "env", internal.RecoveryEnvVar)
+		return
+	}
+	if internal.RecoveryConsumedEarlier() {
+		slog.Warn("recovery code was already used - remove it and restart", "env", internal.RecoveryEnvVar)
+		return
+	}
+
+	code, err := internal.StartRecovery(time.Now())
+	if err != nil {
+		slog.Error("failed to start recovery console", "error", err)
+		return
+	}
+
+	// The code is written to standard error directly rather than logged,
+	// so it stays out of the records GET /api/logs serves.
+	banner := "==================================================\n"
+	fmt.Fprint(os.Stderr, banner,
+		" RECOVERY MODE - the workbench is read-only\n",
+		fmt.Sprintf(" Open /recovery and enter this code within %s:\n", internal.RecoveryCodeTTL),
+		"     "+code+"\n",
+		fmt.Sprintf(" Remove %s after resetting your password.\n", internal.RecoveryEnvVar),
+		banner)
+
+	http.Handle("GET /recovery", recoveryOpen(app.Serve("recovery.html", c.Optional)))
+	http.Handle("POST /recovery", recoveryOpen(http.HandlerFunc(c.handleRecovery)))
+}
+
+// recoveryOpen serves the /recovery routes only until the code is used,
+// after which they're not found.
+func recoveryOpen(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !internal.InRecoveryMode() {
+			http.NotFound(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
 }
 
 // Handle prepares the controller for request-specific operations.
@@ -47,6 +109,153 @@ func (c AuthController) Handle(req *http.Request) application.Handler {
 	return &c
 }
 
+// Required is the bouncer for signed-in routes. While in recovery mode it
+// only lets reads through, so nothing changes until the password is reset.
+func (c *AuthController) Required(app *application.App, w http.ResponseWriter, r *http.Request) bool {
+	assignRequestID(w, r)
+	if !recoveryAllows(w, r) {
+		return false
+	}
+	if !c.Controller.Required(app, w, r) {
+		return false
+	}
+	return c.sessionAllows(app, w, r)
+}
+
+// Optional is the bouncer for routes open to signed-out visitors. Like
+// Required, it only lets reads through in recovery mode, so push webhooks
+// can't start pulls either.
+func (c *AuthController) Optional(app *application.App, w http.ResponseWriter, r *http.Request) bool {
+	assignRequestID(w, r)
+	if !recoveryAllows(w, r) {
+		return false
+	}
+	return c.Controller.Optional(app, w, r)
+}
+
+// sessionAllows refuses a signed-in request whose session was revoked,
+// clearing its cookie and answering as the auth controller does for
+// signed-out requests. Cookies from before the session cookie's name was
+// learned aren't checked.
+func (c *AuthController) sessionAllows(app *application.App, w http.ResponseWriter, r *http.Request) bool {
+	name := internal.SessionCookie()
+	cookie, err := r.Cookie(name)
+	if name == "" || err != nil {
+		return true
+	}
+	_, err = internal.CheckSession(cookie.Value, authClient(r))
+	if !errors.Is(err, internal.ErrSessionRevoked) {
+		if err != nil {
+			slog.Error("failed to check session", "error", err)
+		}
+		return true
+	}
+
+	http.SetCookie(w, &http.Cookie{Name: name, Value: "", Path: "/", MaxAge: -1})
+	c.Controller.Required(app, w, withoutCookie(r, name))
+	return false
+}
+
+// withoutCookie returns a copy of r without the named cookie.
+func withoutCookie(r *http.Request, name string) *http.Request {
+	stripped := r.Clone(r.Context())
+	stripped.Header.Del("Cookie")
+	for _, other := range r.Cookies() {
+		if other.Name != name {
+			stripped.AddCookie(other)
+		}
+	}
+	return stripped
+}
+
+// RequiredOrToken is the bouncer for JSON API routes scripts can call.
+// A request with an Authorization bearer token is let through when it's
+// the current API token and refused with a 401 otherwise; requests without
+// one must be signed in, as with Required.
+func (c *AuthController) RequiredOrToken(app *application.App, w http.ResponseWriter, r *http.Request) bool {
+	assignRequestID(w, r)
+	token, ok := bearerToken(r)
+	if !ok {
+		return c.Required(app, w, r)
+	}
+	if !internal.CheckAPIToken(token) {
+		writeAPIError(w, http.StatusUnauthorized, "invalid_token", "the API token is not valid or was revoked")
+		return false
+	}
+	return recoveryAllows(w, r)
+}
+
+// requestIDHeader carries a request's ID in both directions: from a
+// reverse proxy that assigned one, and back to the client.
+const requestIDHeader = "X-Request-ID"
+
+// validRequestID matches request IDs taken from a proxy.
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// assignRequestID gives a request an ID and sends it as the response's
+// X-Request-ID header. The bouncers call it, so every routed request has
+// one. A well-formed ID from a reverse proxy, trusted as in clientIP, is
+// kept so its logs and ours match; otherwise a random one is made.
+func assignRequestID(w http.ResponseWriter, r *http.Request) {
+	if w.Header().Get(requestIDHeader) != "" {
+		return
+	}
+	id := r.Header.Get(requestIDHeader)
+	if !validRequestID.MatchString(id) || !fromProxy(r) {
+		b := make([]byte, 8)
+		rand.Read(b)
+		id = hex.EncodeToString(b)
+	}
+	w.Header().Set(requestIDHeader, id)
+}
+
+// requestContext returns r's context carrying its request ID, for
+// operations whose log lines, including the commands they run in the
+// coder container, should be matched to the request.
+func requestContext(w http.ResponseWriter, r *http.Request) context.Context {
+	return services.WithRequestID(r.Context(), w.Header().Get(requestIDHeader))
+}
+
+// recoveryAllows rejects requests that could change state while in
+// recovery mode, writing a 503. Reads are let through unless they open a
+// shell or the editor in the coder container.
+func recoveryAllows(w http.ResponseWriter, r *http.Request) bool {
+	if !internal.InRecoveryMode() {
+		return true
+	}
+	if (r.Method == http.MethodGet || r.Method == http.MethodHead) && !reachesCoder(r) {
+		return true
+	}
+	http.Error(w, "Workbench is in recovery mode and read-only until the password is reset", http.StatusServiceUnavailable)
+	return false
+}
+
+// coderPrefixes are the GET routes into a writable shell or editor in the
+// coder container: the terminal and the VS Code proxy.
+var coderPrefixes = []string{"/api/terminal", "/coder/"}
+
+// reachesCoder reports whether a read would open a WebSocket or reach
+// the coder container through one of coderPrefixes. The VS Code proxy
+// strips its prefix before its bouncer runs, so the request URI is
+// checked as well as the path.
+func reachesCoder(r *http.Request) bool {
+	if headerHasToken(r.Header, "Upgrade", "websocket") {
+		return true
+	}
+	for _, prefix := range coderPrefixes {
+		if strings.HasPrefix(r.URL.Path, prefix) || strings.HasPrefix(r.RequestURI, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// InRecoveryMode reports whether the recovery console is waiting for its code.
+// Template usage: {{if auth.InRecoveryMode}}...{{end}}
+func (c *AuthController) InRecoveryMode() bool {
+	return internal.InRecoveryMode()
+}
+
 // handleSignup handles the signup form submission (single user only)
 func (c *AuthController) handleSignup(w http.ResponseWriter, r *http.Request) {
 	// Check if a user already exists (single-user system)
@@ -56,20 +265,23 @@ func (c *AuthController) handleSignup(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Rate limiting check
-	clientIP := r.RemoteAddr // Simple IP for single-user system
-	if !internal.AuthRateLimiter.Allow(clientIP + ":signup") {
-		c.RenderError(w, r, errors.New("too many attempts. Please wait a minute and try again"))
+	if key := remoteKey(r) + ":signup"; !internal.AuthRateLimiter.Allow(key) {
+		c.RenderError(w, r, rateLimitError("too many attempts", key))
 		return
 	}
 
-	c.Controller.HandleSignup(w, r)
+	result := &authResultWriter{ResponseWriter: w}
+	c.Controller.HandleSignup(result, r)
+	if c.Collection.Users.Count("") > 0 {
+		internal.RecordAuthEvent(internal.AuthSignup, r.FormValue("handle"), authClient(r))
+		result.startSession(r)
+	}
 }
 
 // handleSignin processes signin form submission with rate limiting
 func (c *AuthController) handleSignin(w http.ResponseWriter, r *http.Request) {
-	// Rate limiting check - 5 attempts per minute per IP
-	clientIP := r.RemoteAddr // Simple IP for single-user system
-	if !internal.AuthRateLimiter.Allow(clientIP + ":signin") {
+	// Rate limiting check - 5 attempts per minute per IP, then lockouts
+	if key := remoteKey(r) + ":signin"; !internal.AuthRateLimiter.Allow(key) {
 		go models.Activities.Insert(&models.Activity{
 			Type:        "signin_rate_limited",
 			Repository:  "",
@@ -78,14 +290,119 @@ func (c *AuthController) handleSignin(w http.ResponseWriter, r *http.Request) {
 			Timestamp:   time.Now(),
 		})
 
-		c.RenderError(w, r, errors.New("too many signin attempts. Please wait a minute and try again"))
+		c.RenderError(w, r, rateLimitError("too many signin attempts", key))
+		return
+	}
+
+	result := &authResultWriter{ResponseWriter: w}
+	c.Controller.HandleSignin(result, r)
+	if result.signedIn() {
+		internal.RecordAuthEvent(internal.AuthSignin, r.FormValue("handle"), authClient(r))
+		result.startSession(r)
+	} else {
+		internal.RecordAuthEvent(internal.AuthSigninFailed, "", authClient(r))
+	}
+}
+
+// rateLimitError explains when key, refused by AuthRateLimiter, may try
+// again.
+func rateLimitError(reason, key string) error {
+	status := internal.AuthRateLimiter.Status(key)
+	if status.Locked() {
+		return fmt.Errorf("%s. Locked until %s", reason, status.LockedUntil.Format("15:04"))
+	}
+	return fmt.Errorf("%s. Please wait a minute and try again", reason)
+}
+
+// handleRecovery redeems the recovery code and sets a new password,
+// rate limited like signin.
+func (c *AuthController) handleRecovery(w http.ResponseWriter, r *http.Request) {
+	if key := remoteKey(r) + ":recovery"; !internal.AuthRateLimiter.Allow(key) {
+		c.RenderError(w, r, rateLimitError("too many recovery attempts", key))
+		return
+	}
+
+	if r.FormValue("password") != r.FormValue("confirm") {
+		c.RenderError(w, r, errors.New("passwords do not match"))
+		return
+	}
+
+	if err := internal.RecoverPassword(r.FormValue("code"), r.FormValue("password"), clientIP(r)); err != nil {
+		c.RenderError(w, r, err)
 		return
 	}
 
-	c.Controller.HandleSignin(w, r)
+	c.Render(w, r, "recovery-success.html", nil)
 }
 
 // handleSignout processes signout
 func (c *AuthController) handleSignout(w http.ResponseWriter, r *http.Request) {
+	handle := ""
+	if user := c.Handle(r).(*AuthController).CurrentUser(); user != nil {
+		handle = user.Handle
+	}
+	if cookie, err := r.Cookie(internal.SessionCookie()); err == nil {
+		internal.EndSession(cookie.Value)
+	}
 	c.Controller.HandleSignout(w, r)
+	internal.RecordAuthEvent(internal.AuthSignout, handle, authClient(r))
+}
+
+// authClient describes where an authentication request came from, for
+// the audit log.
+func authClient(r *http.Request) internal.AuthClient {
+	return internal.AuthClient{IP: clientIP(r), UserAgent: r.UserAgent()}
+}
+
+// authResultWriter remembers the status the embedded auth controller
+// responds with, to tell whether a signin or signup worked.
+type authResultWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *authResultWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *authResultWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// signedIn reports whether the response started a session: it didn't
+// fail and set a cookie that isn't being deleted. Rejected credentials
+// render an error without one.
+func (w *authResultWriter) signedIn() bool {
+	return w.status < http.StatusBadRequest && w.authCookie() != nil
+}
+
+// authCookie returns the cookie the response signed in with, or nil.
+func (w *authResultWriter) authCookie() *http.Cookie {
+	for _, cookie := range (&http.Response{Header: w.Header()}).Cookies() {
+		if cookie.Value != "" && cookie.MaxAge >= 0 {
+			return cookie
+		}
+	}
+	return nil
+}
+
+// startSession records the session the response signed in with.
+func (w *authResultWriter) startSession(r *http.Request) {
+	cookie := w.authCookie()
+	if cookie == nil {
+		return
+	}
+	expires := cookie.Expires
+	if cookie.MaxAge > 0 {
+		expires = time.Now().Add(time.Duration(cookie.MaxAge) * time.Second)
+	}
+	if err := internal.StartSession(cookie.Name, cookie.Value, expires, authClient(r)); err != nil {
+		slog.Error("failed to start session", "error", err)
+	}
 }
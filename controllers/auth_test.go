@@ -0,0 +1,164 @@
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+	"workbench/internal"
+
+	"github.com/The-Skyscape/devtools/pkg/testutils"
+)
+
+func TestRecoveryModeIsReadOnly(t *testing.T) {
+	t.Cleanup(internal.ResetRecoveryForTesting)
+
+	allows := func(method string) (bool, int) {
+		w := httptest.NewRecorder()
+		ok := recoveryAllows(w, httptest.NewRequest(method, "/repos/pull/api", nil))
+		return ok, w.Code
+	}
+	terminal := func() *http.Request {
+		r := httptest.NewRequest("GET", "/api/terminal?cols=80&rows=24", nil)
+		r.Header.Set("Connection", "Upgrade")
+		r.Header.Set("Upgrade", "websocket")
+		return r
+	}
+
+	// Normal operation
+	ok, _ := allows("POST")
+	testutils.AssertEqual(t, true, ok)
+	testutils.AssertEqual(t, true, recoveryAllows(httptest.NewRecorder(), terminal()))
+
+	_, err := internal.StartRecovery(time.Now())
+	testutils.AssertEqual(t, nil, err)
+
+	testCases := []struct {
+		method   string
+		allowed  bool
+		expected int
+	}{
+		{"GET", true, http.StatusOK},
+		{"HEAD", true, http.StatusOK},
+		{"POST", false, http.StatusServiceUnavailable},
+		{"DELETE", false, http.StatusServiceUnavailable},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.method, func(t *testing.T) {
+			ok, code := allows(tc.method)
+			testutils.AssertEqual(t, tc.allowed, ok)
+			testutils.AssertEqual(t, tc.expected, code)
+		})
+	}
+
+	// Reads that reach a shell or the editor in the coder container are
+	// refused like writes
+	coder := httptest.NewRequest("GET", "/coder/", nil)
+	coder.URL.Path = "" // As http.StripPrefix leaves it
+	upgrade := terminal()
+	upgrade.URL.Path, upgrade.RequestURI = "/apps/3000/", "/apps/3000/"
+	for name, r := range map[string]*http.Request{
+		"terminal": terminal(),
+		"coder":    coder,
+		"upgrade":  upgrade,
+	} {
+		t.Run(name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			testutils.AssertEqual(t, false, recoveryAllows(w, r))
+			testutils.AssertEqual(t, http.StatusServiceUnavailable, w.Code)
+		})
+	}
+}
+
+func TestRecoveryModeRefusesWebhooks(t *testing.T) {
+	t.Cleanup(internal.ResetRecoveryForTesting)
+	_, err := internal.StartRecovery(time.Now())
+	testutils.AssertEqual(t, nil, err)
+
+	// Webhooks are served with Optional, which must refuse them before
+	// they reach the handler
+	w := httptest.NewRecorder()
+	ok := (&AuthController{}).Optional(nil, w, httptest.NewRequest("POST", "/hooks/git/api", nil))
+	testutils.AssertEqual(t, false, ok)
+	testutils.AssertEqual(t, http.StatusServiceUnavailable, w.Code)
+}
+
+func TestRecoveryRoutesCloseOnceUsed(t *testing.T) {
+	t.Cleanup(internal.ResetRecoveryForTesting)
+
+	served := 0
+	handler := recoveryOpen(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { served++ }))
+	status := func() int {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/recovery", nil))
+		return w.Code
+	}
+
+	code, err := internal.StartRecovery(time.Now())
+	testutils.AssertEqual(t, nil, err)
+	testutils.AssertEqual(t, http.StatusOK, status())
+	testutils.AssertEqual(t, 1, served)
+
+	err = internal.CurrentRecovery().Redeem(code, time.Now(), func() error { return nil })
+	testutils.AssertEqual(t, nil, err)
+	testutils.AssertEqual(t, http.StatusNotFound, status())
+	testutils.AssertEqual(t, 1, served)
+}
+
+func TestClientIP(t *testing.T) {
+	testCases := []struct {
+		name      string
+		remote    string
+		forwarded string
+		expected  string
+	}{
+		{"direct", "203.0.113.7:51234", "", "203.0.113.7"},
+		{"spoofed header ignored", "203.0.113.7:51234", "198.51.100.1", "203.0.113.7"},
+		{"behind proxy", "127.0.0.1:40000", "198.51.100.1", "198.51.100.1"},
+		{"proxy appends", "10.0.0.2:40000", "192.0.2.9, 198.51.100.1", "198.51.100.1"},
+		{"proxy without header", "10.0.0.2:40000", "", "10.0.0.2"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/_auth/signin", nil)
+			r.RemoteAddr = tc.remote
+			if tc.forwarded != "" {
+				r.Header.Set("X-Forwarded-For", tc.forwarded)
+			}
+			testutils.AssertEqual(t, tc.expected, clientIP(r))
+		})
+	}
+}
+
+func TestAuthResultWriterSignedIn(t *testing.T) {
+	testCases := []struct {
+		name     string
+		respond  func(w http.ResponseWriter)
+		signedIn bool
+	}{
+		{"session cookie", func(w http.ResponseWriter) {
+			http.SetCookie(w, &http.Cookie{Name: "workbench", Value: "jwt", MaxAge: 3600})
+			w.Header().Set("HX-Refresh", "true")
+		}, true},
+		{"error rendered", func(w http.ResponseWriter) {
+			w.Write([]byte("invalid credentials"))
+		}, false},
+		{"cookie cleared", func(w http.ResponseWriter) {
+			http.SetCookie(w, &http.Cookie{Name: "workbench", MaxAge: -1})
+		}, false},
+		{"failed status", func(w http.ResponseWriter) {
+			http.SetCookie(w, &http.Cookie{Name: "workbench", Value: "jwt"})
+			w.WriteHeader(http.StatusUnauthorized)
+		}, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := &authResultWriter{ResponseWriter: httptest.NewRecorder()}
+			tc.respond(w)
+			testutils.AssertEqual(t, tc.signedIn, w.signedIn())
+		})
+	}
+}
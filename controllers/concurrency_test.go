@@ -0,0 +1,223 @@
+package controllers
+
+import (
+	"html/template"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"workbench/internal"
+	"workbench/internal/i18n"
+
+	"github.com/The-Skyscape/devtools/pkg/containers"
+	"github.com/The-Skyscape/devtools/pkg/testutils"
+)
+
+// fakeCollector publishes a new sample on every collect, like the
+// background collector.
+type fakeCollector struct {
+	mu      sync.Mutex
+	current *containers.SystemStats
+}
+
+func (f *fakeCollector) Start() {}
+
+func (f *fakeCollector) GetCurrent() (*containers.SystemStats, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.current, nil
+}
+
+func (f *fakeCollector) collect(i int) {
+	stats := &containers.SystemStats{}
+	stats.CPU.UsagePercent = float64(i % 100)
+	stats.Memory.UsedPercent = float64(i % 100)
+	stats.LoadAverage.Load1 = float64(i%100) / 10
+	f.mu.Lock()
+	f.current = stats
+	f.mu.Unlock()
+}
+
+// TestStressDashboardHelpers hammers the dashboard's template helpers from
+// many goroutines while background collection and API accounting run.
+// Run with the race detector: go test -race -run Stress ./...
+func TestStressDashboardHelpers(t *testing.T) {
+	fakeAPILimits(t, internal.APILimits{PerMinute: 6000, Burst: 100}, 1024)
+	collector := &fakeCollector{}
+	collector.collect(0)
+	monitoring := &MonitoringController{collector: collector, start: new(sync.Once)}
+
+	stop := make(chan struct{})
+	var background sync.WaitGroup
+	background.Add(2)
+	go func() {
+		defer background.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+				collector.collect(i)
+			}
+		}
+	}()
+	go func() {
+		defer background.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				apiLimiter.Allow("session", internal.APIRead, time.Now())
+			}
+		}
+	}()
+
+	const workers, iterations = 50, 200
+	var wg sync.WaitGroup
+	errs := make(chan string, workers)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < iterations; i++ {
+				req := httptest.NewRequest("GET", "/partials/stats", nil)
+				c := monitoring.Handle(req).(*MonitoringController)
+				if c.Request != req {
+					errs <- "request leaked between controller copies"
+					return
+				}
+
+				stats := c.GetSystemStats()
+				if stats.CPU.UsagePercent != stats.Memory.UsedPercent {
+					errs <- "garbled stats sample"
+					return
+				}
+				// Mutating the returned stats must not affect other callers
+				stats.CPU.UsagePercent = -1
+
+				if cpu := c.GetCPUUsage(); cpu < 0 || cpu >= 100 {
+					errs <- "shared stats were mutated"
+					return
+				}
+				c.GetMemoryUsage()
+				c.GetLoadAverage()
+				c.GetSystemInfo()
+				c.GetAPIUsage()
+				c.GetVolumeStatus()
+			}
+		}()
+	}
+	wg.Wait()
+	close(stop)
+	background.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Fatal(err)
+	}
+}
+
+// TestStressRequestScopedHelpers checks helpers read only their own
+// request when copies are handled concurrently.
+func TestStressRequestScopedHelpers(t *testing.T) {
+	workbench := &WorkbenchController{}
+
+	var wg sync.WaitGroup
+	mismatches := make(chan int, 100)
+	for w := 0; w < 100; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			group := ""
+			if w%2 == 0 {
+				group = "host"
+			}
+			req := httptest.NewRequest("GET", "/partials/repos?group="+group+"&n="+strconv.Itoa(w), nil)
+			c := workbench.Handle(req).(*WorkbenchController)
+			for i := 0; i < 50; i++ {
+				if c.GroupByHost() != (group == "host") || c.QueryParam("n") != strconv.Itoa(w) {
+					mismatches <- w
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(mismatches)
+
+	testutils.AssertEqual(t, 0, len(mismatches))
+}
+
+// TestStressStatsPartialRender renders the dashboard's stats partial from
+// many goroutines, each through its own controller copy, while samples
+// are published in the background.
+func TestStressStatsPartialRender(t *testing.T) {
+	original := metricsHistory
+	metricsHistory = func(resolution string, since time.Time) ([]internal.MetricPoint, error) {
+		return []internal.MetricPoint{{CPU: 10, Memory: 20}, {CPU: 30, Memory: 40}}, nil
+	}
+	t.Cleanup(func() { metricsHistory = original })
+
+	collector := &fakeCollector{}
+	collector.collect(0)
+	monitoring := &MonitoringController{collector: collector, start: new(sync.Once)}
+
+	stop := make(chan struct{})
+	var background sync.WaitGroup
+	background.Add(1)
+	go func() {
+		defer background.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+				collector.collect(i)
+			}
+		}
+	}()
+
+	const workers, iterations = 20, 50
+	var wg sync.WaitGroup
+	errs := make(chan string, workers)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < iterations; i++ {
+				req := httptest.NewRequest("GET", "/partials/stats", nil)
+				c := monitoring.Handle(req).(*MonitoringController)
+				c.locale = i18n.Resolve("en", "")
+				tmpl, err := template.New("stats-partial.html").Funcs(template.FuncMap{
+					"host":       func() string { return "" },
+					"monitoring": func() *MonitoringController { return c },
+				}).ParseFiles("../views/stats-partial.html", "../views/memory-data.html")
+				if err != nil {
+					errs <- err.Error()
+					return
+				}
+
+				var out strings.Builder
+				if err := tmpl.Execute(&out, nil); err != nil {
+					errs <- err.Error()
+					return
+				}
+				if !strings.Contains(out.String(), "cores") {
+					errs <- "stats partial rendered without system info"
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(stop)
+	background.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,58 @@
+package controllers
+
+import (
+	"net/http"
+	"workbench/internal"
+)
+
+// errorRegion is the element the layout keeps for errors. HTMX requests
+// that fail are swapped into it rather than into their own target, so a
+// form that failed stays on the page to retry.
+const errorRegion = "#error-region"
+
+// errorStatuses maps error codes to the HTTP status they're sent with.
+// Codes not listed are sent as 422: the operation was understood, but
+// refused or failed.
+var errorStatuses = map[string]int{
+	internal.CodeNotFound:        http.StatusNotFound,
+	internal.CodeExists:          http.StatusConflict,
+	internal.CodeInvalidInput:    http.StatusBadRequest,
+	internal.CodeRepoMissing:     http.StatusConflict,
+	internal.CodeConflict:        http.StatusConflict,
+	internal.CodeLocked:          http.StatusLocked,
+	internal.CodeCoderNotRunning: http.StatusServiceUnavailable,
+	internal.CodeNoSpace:         http.StatusInsufficientStorage,
+	internal.CodeNetwork:         http.StatusBadGateway,
+	internal.CodeTimedOut:        http.StatusGatewayTimeout,
+	internal.CodeInternal:        http.StatusInternalServerError,
+}
+
+// errorStatus returns the HTTP status for an error code.
+func errorStatus(code string) int {
+	if status, ok := errorStatuses[code]; ok {
+		return status
+	}
+	return http.StatusUnprocessableEntity
+}
+
+// presentError logs err and returns it presented for error-message.html,
+// after setting the response status for its code. HTMX requests are
+// retargeted to the error region. Callers render the result.
+func presentError(w http.ResponseWriter, r *http.Request, err error) *internal.UserError {
+	presented := internal.PresentError(err)
+	presented.Repo = r.PathValue("name")
+
+	if r.Header.Get("HX-Request") == "true" {
+		w.Header().Set("HX-Retarget", errorRegion)
+		w.Header().Set("HX-Reswap", "innerHTML")
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(errorStatus(presented.Code))
+	return presented
+}
+
+// invalidInput is the error for a request with a missing or malformed
+// value, with a message saying which.
+func invalidInput(message string) error {
+	return &internal.AppError{Code: internal.CodeInvalidInput, UserMessage: message}
+}
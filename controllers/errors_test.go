@@ -0,0 +1,53 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"workbench/internal"
+
+	"github.com/The-Skyscape/devtools/pkg/testutils"
+)
+
+func TestPresentError(t *testing.T) {
+	testCases := []struct {
+		name      string
+		err       error
+		status    int
+		code      string
+		retryable bool
+	}{
+		{"unknown repository", fmt.Errorf("pull: %w", internal.ErrRepoNotFound), http.StatusNotFound, internal.CodeNotFound, false},
+		{"invalid input", invalidInput("Invalid stash index"), http.StatusBadRequest, internal.CodeInvalidInput, false},
+		{"timed out", internal.ErrOperationTimedOut, http.StatusGatewayTimeout, internal.CodeTimedOut, true},
+		{"network", &internal.AppError{Code: internal.CodeNetwork, UserMessage: "network error", Retryable: true}, http.StatusBadGateway, internal.CodeNetwork, true},
+		{"unexpected", &internal.AppError{Code: internal.CodeInternal, Detail: "database is locked"}, http.StatusInternalServerError, internal.CodeInternal, false},
+		{"other failure", errors.New("merge conflicts detected"), http.StatusUnprocessableEntity, internal.CodeFailed, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/repos/pull/api", nil)
+			req.SetPathValue("name", "api")
+			req.Header.Set("HX-Request", "true")
+			w := httptest.NewRecorder()
+
+			presented := presentError(w, req, tc.err)
+			testutils.AssertEqual(t, tc.status, w.Code)
+			testutils.AssertEqual(t, errorRegion, w.Header().Get("HX-Retarget"))
+			testutils.AssertEqual(t, "innerHTML", w.Header().Get("HX-Reswap"))
+			testutils.AssertEqual(t, tc.code, presented.Code)
+			testutils.AssertEqual(t, tc.retryable, presented.Retryable)
+			testutils.AssertEqual(t, "api", presented.Repo)
+		})
+	}
+}
+
+func TestPresentErrorWithoutHTMX(t *testing.T) {
+	w := httptest.NewRecorder()
+	presentError(w, httptest.NewRequest(http.MethodGet, "/", nil), internal.ErrCoderNotRunning)
+	testutils.AssertEqual(t, http.StatusServiceUnavailable, w.Code)
+	testutils.AssertEqual(t, "", w.Header().Get("HX-Retarget"))
+}
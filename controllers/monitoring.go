@@ -1,14 +1,22 @@
 package controllers
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"runtime"
-	"syscall"
+	"strings"
+	"sync"
+	"time"
+	"workbench/internal"
+	"workbench/internal/i18n"
+	"workbench/models"
+	"workbench/services"
 
 	"github.com/The-Skyscape/devtools/pkg/application"
 	"github.com/The-Skyscape/devtools/pkg/containers"
-	"github.com/The-Skyscape/devtools/pkg/database"
 )
 
 // Monitoring is a factory function that returns the controller prefix and instance.
@@ -17,38 +25,100 @@ import (
 func Monitoring() (string, *MonitoringController) {
 	return "monitoring", &MonitoringController{
 		collector: containers.NewCollector(false, 100), // Keep 100 samples
+		start:     new(sync.Once),
 	}
 }
 
+// metricsHistory and metricsRange load persisted metric points. Replaced
+// in tests.
+var (
+	metricsHistory = internal.MetricsHistory
+	metricsRange   = internal.MetricsRange
+)
+
+// statsCollector is the part of containers.Collector the controller uses.
+// Replaced in tests.
+type statsCollector interface {
+	Start()
+	GetCurrent() (*containers.SystemStats, error)
+}
+
 // MonitoringController provides real-time system monitoring capabilities.
 // It tracks CPU usage, memory consumption, disk usage (specifically the data directory),
 // and system load averages. The monitor runs in a background goroutine and maintains
 // a sliding window of samples for trend analysis.
+//
+// Handle returns a copy of the controller for every request, and template
+// helpers run concurrently across copies (HTMX polls several partials at
+// once). The embedded Controller's Request is request-scoped. Every other
+// field is process-shared: set once in Monitoring() and safe for
+// concurrent use. Helpers must not write to shared fields or memoize on
+// the controller; a per-request memo belongs on the copy made in Handle.
 type MonitoringController struct {
 	application.Controller
-	collector *containers.Collector
+
+	// Process-shared
+	collector statsCollector
+	start     *sync.Once // Starts background collection once, even if Setup runs again
+
+	// Per-request, set on the copy made in Handle
+	locale *i18n.Locale // Resolved on first use
 }
 
 // Setup initializes the monitoring controller during application startup.
 // Registers HTMX partial routes for auto-refreshing dashboard components
 // and starts the background system monitor that collects metrics every 2 seconds.
 // Routes registered:
-// - GET /health - Health check endpoint
+// - GET /health - Liveness check, an alias of /health/live
+// - GET /health/live - Liveness check: the process is serving and the data volume is healthy
+// - GET /health/ready - Readiness check of the database, VS Code, and data directory
+// - GET /metrics - Prometheus metrics, behind the metrics token once one is generated
 // - GET /partials/stats - System statistics partial (CPU, memory, disk)
 // - GET /partials/coder-status - VS Code server status partial
+// - GET /partials/metrics-history?range= - Metrics history charts for a range
+// - GET /api/metrics/history - Metrics history as JSON (?range=1h|24h|7d&step=, or ?resolution=raw|minute|hour)
+// - GET /api/coder/health - Coder health checker state as JSON
+// - POST /coder/start - Retry starting the coder container after it failed to start
+// - POST /coder/resume - Start the coder container after an idle pause, then go into the IDE (`next`)
+// - POST /coder/upgrade - Move the coder container to another code-server version (`tag`, default the coder_image_tag setting)
+// - GET /partials/disk-breakdown - Largest directories under the data directory and the coder home directory
+// - GET /api/disk/breakdown - The disk usage breakdown as JSON
+// - GET /api/logs - The last 500 log records, newest first (?request_id=, ?level=)
+// - GET /api/system/migrations - The database migrations and when each was applied
+// - POST /monitoring/thresholds - Save the CPU, memory, and disk alert thresholds
 func (c *MonitoringController) Setup(app *application.App) {
 	c.Controller.Setup(app)
 
 	auth := app.Use("auth").(*AuthController)
 
 	http.Handle("GET /health", app.ProtectFunc(c.healthCheck, auth.Optional))
+	http.Handle("GET /health/live", app.ProtectFunc(c.healthCheck, auth.Optional))
+	http.Handle("GET /health/ready", app.ProtectFunc(c.readinessCheck, auth.Optional))
+	http.Handle("GET /metrics", app.ProtectFunc(c.prometheusMetrics, auth.Optional))
 
 	// Partial routes for HTMX auto-refresh
-	http.Handle("GET /partials/stats", app.Serve("stats-partial.html", auth.Required))
-	http.Handle("GET /partials/coder-status", app.Serve("coder-status-partial.html", auth.Required))
+	http.Handle("GET /partials/stats", timed(internal.LatencyDashboard, app.Serve("stats-partial.html", auth.Required)))
+	http.Handle("GET /partials/coder-status", timed(internal.LatencyDashboard, app.Serve("coder-status-partial.html", auth.Required)))
+	http.Handle("GET /partials/metrics-history", app.Serve("metrics-history.html", auth.Required))
+	http.Handle("GET /partials/disk-breakdown", app.Serve("disk-breakdown.html", auth.Required))
+
+	handleAPI("GET /api/metrics/history", app.ProtectFunc(apiLimit(auth.apiKey, internal.APIRead, c.metricsHistory), auth.Required))
+	handleAPI("GET /api/coder/health", app.ProtectFunc(apiLimit(auth.apiKey, internal.APIRead, c.coderHealth), auth.Required))
+	handleAPI("GET /api/disk/breakdown", app.ProtectFunc(apiLimit(auth.apiKey, internal.APIRead, c.diskBreakdown), auth.Required))
+	handleAPI("GET /api/logs", app.ProtectFunc(apiLimit(auth.apiKey, internal.APIRead, c.recentLogs), auth.Required))
+	handleAPI("GET /api/system/migrations", app.ProtectFunc(apiLimit(auth.apiKey, internal.APIRead, c.listMigrations), auth.Required))
+	http.Handle("POST /monitoring/thresholds", app.ProtectFunc(c.saveThresholds, auth.Required))
+
+	// More specific than the /coder/ proxy, so it's served here
+	http.Handle("POST /coder/start", app.ProtectFunc(c.startCoder, auth.Required))
+	http.Handle("POST /coder/resume", app.ProtectFunc(c.resumeCoder, auth.Required))
+	http.Handle("POST /coder/upgrade", app.ProtectFunc(c.upgradeCoder, auth.Required))
 
-	// Start system monitoring
-	go c.collector.Start()
+	// Start system monitoring and persist downsampled history
+	c.start.Do(func() {
+		go c.collector.Start()
+		go internal.StartMetricsPersistence(c.collector)
+	})
 }
 
 // Handle prepares the controller for request-specific operations.
@@ -64,14 +134,20 @@ func (c MonitoringController) Handle(req *http.Request) application.Handler {
 // ============================================================================
 
 // GetSystemStats returns comprehensive system statistics including CPU, memory,
-// disk usage, and load averages. Returns the most recent sample from the monitor.
+// disk usage, and load averages. Returns a shallow copy of the most recent
+// sample, so scalar fields can't be changed under the collector; any
+// slices or maps inside are still shared and must be treated as read-only.
 // Template usage: {{with monitoring.GetSystemStats}}...{{end}}
 func (c *MonitoringController) GetSystemStats() *containers.SystemStats {
 	if c.collector == nil {
 		return nil
 	}
-	stats, _ := c.collector.GetCurrent()
-	return stats
+	stats, err := c.collector.GetCurrent()
+	if err != nil || stats == nil {
+		return nil
+	}
+	snapshot := *stats
+	return &snapshot
 }
 
 // GetSystemInfo returns static system information like hostname, OS, architecture,
@@ -87,6 +163,50 @@ func (c *MonitoringController) GetSystemInfo() map[string]any {
 	}
 }
 
+// Version returns the workbench build version, set with -ldflags at build
+// time, or "dev".
+// Template usage: {{monitoring.Version}}
+func (c *MonitoringController) Version() string {
+	return internal.Version
+}
+
+// GetCoderHealth returns the coder health checker's latest status.
+// Template usage: {{with monitoring.GetCoderHealth}}{{.Status}}{{end}}
+func (c *MonitoringController) GetCoderHealth() services.CoderHealthStatus {
+	return services.CoderHealth()
+}
+
+// GetCoderStartup returns how far starting the coder container has got:
+// starting, running, or failed with the last error.
+// Template usage: {{with monitoring.GetCoderStartup}}{{.State}}{{end}}
+func (c *MonitoringController) GetCoderStartup() services.CoderStartStatus {
+	return services.CoderState()
+}
+
+// GetCoderStats returns the coder container's own CPU, memory, network,
+// and process usage, or nil when docker can't be asked. A stopped
+// container gives zeroed stats with Running false.
+// Template usage: {{with monitoring.GetCoderStats}}{{if .Running}}...{{end}}{{end}}
+func (c *MonitoringController) GetCoderStats() *services.ContainerStats {
+	stats, err := services.CoderStats()
+	if err != nil {
+		return nil
+	}
+	return &stats
+}
+
+// GetCoderImage returns the code-server version the coder container runs,
+// the one the coder_image_tag setting asks for, and the newest release,
+// or nil when the container can't be inspected.
+// Template usage: {{with monitoring.GetCoderImage}}{{if .UpgradeAvailable}}...{{end}}{{end}}
+func (c *MonitoringController) GetCoderImage() *internal.CoderImageStatus {
+	status, err := internal.GetCoderImageStatus()
+	if err != nil {
+		return nil
+	}
+	return status
+}
+
 // GetCPUUsage returns the current CPU usage as a percentage (0-100).
 // Returns 0 if monitoring data is unavailable.
 // Template usage: {{monitoring.GetCPUUsage}}%
@@ -122,58 +242,416 @@ func (c *MonitoringController) GetLoadAverage() string {
 }
 
 // FormatBytes converts bytes to human-readable format (KB, MB, GB, TB).
-// Uses binary prefixes (1024-based) for accurate representation.
+// Uses binary prefixes (1024-based) and the user's decimal separator.
 // Template usage: {{monitoring.FormatBytes .Memory.Total}}
 func (c *MonitoringController) FormatBytes(bytes uint64) string {
-	const unit = 1024
-	if bytes < unit {
-		return fmt.Sprintf("%d B", bytes)
-	}
-	div, exp := int64(unit), 0
-	for n := bytes / unit; n >= unit; n /= unit {
-		div *= unit
-		exp++
+	return c.displayLocale().FormatBytes(bytes)
+}
+
+// FormatPercent formats a percentage for the user's locale, e.g. "12.5%" or "12,5 %".
+// Template usage: {{monitoring.FormatPercent monitoring.GetCPUUsage}}
+func (c *MonitoringController) FormatPercent(percent float64) string {
+	return c.displayLocale().FormatPercent(percent)
+}
+
+// displayLocale resolves the request's locale once per request.
+func (c *MonitoringController) displayLocale() *i18n.Locale {
+	if c.Request == nil {
+		return requestLocale(nil)
 	}
-	units := []string{"KB", "MB", "GB", "TB", "PB"}
-	if exp >= len(units) {
-		exp = len(units) - 1
+	if c.locale == nil {
+		c.locale = requestLocale(c.Request)
 	}
-	return fmt.Sprintf("%.1f %s", float64(bytes)/float64(div), units[exp])
+	return c.locale
 }
 
-// GetDataDirStats returns disk usage statistics for the persistent data directory.
+// GetDataDirStats returns disk usage statistics for the persistent data directory
+// and any storage locations on other disks, added together.
 // This tracks only data that persists between container restarts (repos, database, etc.),
 // NOT the system disk. Shows used/total space and percentage utilization.
 // Template usage: {{with monitoring.GetDataDirStats}}...{{end}}
 func (c *MonitoringController) GetDataDirStats() map[string]any {
-	dataDir := database.DataDir()
-
-	var stat syscall.Statfs_t
-	err := syscall.Statfs(dataDir, &stat)
+	usage, err := internal.GetStorageUsage()
 	if err != nil {
 		// Return empty stats on error
 		return map[string]any{}
 	}
 
-	// Calculate sizes in bytes
-	total := stat.Blocks * uint64(stat.Bsize)
-	free := stat.Bavail * uint64(stat.Bsize)
-	used := total - free
+	return map[string]any{
+		"Path":        usage.Path,
+		"Total":       usage.Total,
+		"Used":        usage.Used,
+		"Free":        usage.Free,
+		"UsedPercent": usage.UsedPercent,
+	}
+}
+
+// GetDiskBreakdown returns the largest directories and files under the
+// data directory and the coder home directory, measured with du at most
+// every few minutes.
+// Template usage: {{with monitoring.GetDiskBreakdown}}{{range .Entries}}...{{end}}{{end}}
+func (c *MonitoringController) GetDiskBreakdown() *internal.DiskBreakdown {
+	return internal.GetDataDirBreakdown()
+}
+
+// GetAPIUsage returns today's JSON API operation counts per caller, so a
+// runaway script stands out.
+// Template usage: {{range monitoring.GetAPIUsage}}{{.Key}}: {{.Operations}}{{end}}
+func (c *MonitoringController) GetAPIUsage() []internal.APIUsage {
+	return apiLimiter.UsageToday(time.Now())
+}
+
+// GetLatencyStats returns recent response times for the dashboard and
+// for requests proxied to VS Code.
+// Template usage: {{range monitoring.GetLatencyStats}}{{.Bucket}}: {{.P50}}{{end}}
+func (c *MonitoringController) GetLatencyStats() []internal.LatencyStats {
+	return internal.GetLatencyStats()
+}
+
+// GetVolumeStatus returns the data volume watchdog status, used to show
+// the protective mode banner on the dashboard.
+// Template usage: {{with monitoring.GetVolumeStatus}}{{if .Protective}}...{{end}}{{end}}
+func (c *MonitoringController) GetVolumeStatus() internal.VolumeStatus {
+	return internal.GetVolumeStatus()
+}
+
+// GetSparkline returns SVG polyline points for the last hour of a metric
+// ("cpu" or "memory") from the persisted minute tier, so the trend survives
+// restarts. Returns empty string when there isn't enough history yet.
+// Template usage: <polyline points="{{monitoring.GetSparkline "cpu"}}" />
+func (c *MonitoringController) GetSparkline(metric string) string {
+	points, err := metricsHistory("minute", time.Now().Add(-time.Hour))
+	if err != nil {
+		return ""
+	}
 
-	usedPercent := 0.0
-	if total > 0 {
-		usedPercent = float64(used) / float64(total) * 100.0
+	values := make([]float64, len(points))
+	for i, p := range points {
+		if metric == "memory" {
+			values[i] = p.Memory
+		} else {
+			values[i] = p.CPU
+		}
 	}
+	return internal.SparklinePoints(values, 100, 24)
+}
 
-	return map[string]any{
-		"Path":        dataDir,
-		"Total":       total,
-		"Used":        used,
-		"Free":        free,
-		"UsedPercent": usedPercent,
+// GetMetricsHistory returns charts of the metrics history for the range
+// query parameter (1h, 24h, or 7d; 24h by default). Keys: Range, Ranges,
+// Step, CPU and Memory (sparkline points), Latest (the newest point),
+// Error.
+// Template usage: {{with monitoring.GetMetricsHistory}}...{{end}}
+func (c *MonitoringController) GetMetricsHistory() map[string]any {
+	name := "24h"
+	if c.Request != nil {
+		if q := c.Request.URL.Query().Get("range"); q != "" {
+			name = q
+		}
+	}
+	panel := map[string]any{"Range": name, "Ranges": internal.MetricRanges}
+	points, step, err := metricsRange(name, 0, time.Now())
+	if err != nil {
+		panel["Error"] = internal.PresentError(err)
+		return panel
+	}
+
+	cpu := make([]float64, len(points))
+	memory := make([]float64, len(points))
+	for i, p := range points {
+		cpu[i], memory[i] = p.CPU, p.Memory
+	}
+	panel["Step"] = step
+	panel["CPU"] = internal.SparklinePoints(cpu, 100, 24)
+	panel["Memory"] = internal.SparklinePoints(memory, 100, 24)
+	if len(points) > 0 {
+		panel["Latest"] = points[len(points)-1]
+	}
+	return panel
+}
+
+// GetAlertThresholds returns the resource alert thresholds in percent for
+// the preferences form, 0 meaning off. Keys: CPU, Memory, Disk.
+// Template usage: {{with monitoring.GetAlertThresholds}}...{{end}}
+func (c *MonitoringController) GetAlertThresholds() map[string]int {
+	return map[string]int{
+		"CPU":    models.GetIntSetting(internal.CPUAlertKey),
+		"Memory": models.GetIntSetting(internal.MemoryAlertKey),
+		"Disk":   models.GetIntSetting(internal.DiskAlertKey),
 	}
 }
 
+// saveThresholds handles POST /monitoring/thresholds to store the CPU,
+// memory, and disk alert thresholds.
+func (c *MonitoringController) saveThresholds(w http.ResponseWriter, r *http.Request) {
+	settings := map[string]string{}
+	for _, key := range []string{internal.CPUAlertKey, internal.MemoryAlertKey, internal.DiskAlertKey} {
+		// Check every value before saving any
+		value, err := models.NormalizeSetting(key, r.FormValue(key))
+		if err != nil {
+			c.renderError(w, r, &internal.AppError{Code: internal.CodeInvalidInput, UserMessage: err.Error(), Err: err})
+			return
+		}
+		settings[key] = value
+	}
+	for key, value := range settings {
+		if _, err := models.SetSetting(key, value, "user_preference"); err != nil {
+			c.renderError(w, r, &internal.AppError{Code: internal.CodeInternal, UserMessage: "Failed to save alert thresholds", Retryable: true, Err: err})
+			return
+		}
+	}
+
+	c.Refresh(w, r)
+}
+
+// renderError renders a sanitized error message into the error region,
+// like WorkbenchController.renderError.
+func (c *MonitoringController) renderError(w http.ResponseWriter, r *http.Request, err error) {
+	c.Render(w, r, "error-message.html", presentError(w, r, err))
+}
+
+// startCoder handles POST /coder/start from the Failed state of the coder
+// status, trying to start the container again right away.
+func (c *MonitoringController) startCoder(w http.ResponseWriter, r *http.Request) {
+	services.EnsureCoder()
+	c.Refresh(w, r)
+}
+
+// resumeCoder handles POST /coder/resume from the Paused state of the
+// coder status. Waits until VS Code answers, then sends the browser to
+// next, a path on this site, usually the IDE.
+func (c *MonitoringController) resumeCoder(w http.ResponseWriter, r *http.Request) {
+	if err := internal.ResumeCoder(); err != nil {
+		c.renderError(w, r, err)
+		return
+	}
+
+	next := r.FormValue("next")
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		c.Refresh(w, r)
+		return
+	}
+	w.Header().Set("HX-Redirect", next)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// upgradeCoder handles POST /coder/upgrade. Pulling the image and waiting
+// for the new container can take minutes, so the upgrade runs in the
+// background and the partial shows it in progress; its outcome is
+// recorded as an activity.
+func (c *MonitoringController) upgradeCoder(w http.ResponseWriter, r *http.Request) {
+	tag := strings.TrimSpace(r.FormValue("tag"))
+	if tag != "" && !services.ValidCoderImageTag(tag) {
+		c.renderError(w, r, invalidInput(fmt.Sprintf("Invalid image tag %s", tag)))
+		return
+	}
+	if services.CoderUpgrading() {
+		c.renderError(w, r, &internal.AppError{Code: internal.CodeConflict, UserMessage: services.ErrCoderUpgradeRunning.Error(), Err: services.ErrCoderUpgradeRunning})
+		return
+	}
+
+	go func() {
+		if err := internal.UpgradeCoder(tag); err != nil {
+			slog.Error("coder upgrade failed", "tag", tag, "error", err)
+		}
+	}()
+	c.Refresh(w, r)
+}
+
+// metricsHistoryResponse is the body of GET /api/metrics/history: the
+// points with their resolution tier, or with the range and step.
+type metricsHistoryResponse struct {
+	Resolution  string                 `json:"resolution,omitempty" api:"enum=raw|minute|hour"`
+	Range       string                 `json:"range,omitempty" api:"enum=1h|24h|7d"`
+	StepSeconds int                    `json:"step_seconds,omitempty"`
+	Points      []internal.MetricPoint `json:"points"`
+}
+
+// metricsHistory returns metrics history as JSON. With range (1h, 24h, or
+// 7d), points are averaged over step, a duration such as 5m defaulting to
+// one suited to the range. Otherwise the resolution parameter selects the
+// tier: raw (last 10 minutes in memory), minute (the metrics_retention
+// setting), or hour (30 days).
+func (c *MonitoringController) metricsHistory(w http.ResponseWriter, r *http.Request) {
+	if name := r.URL.Query().Get("range"); name != "" {
+		c.metricsHistoryRange(w, r, name)
+		return
+	}
+
+	resolution := r.URL.Query().Get("resolution")
+	var since time.Time
+	switch resolution {
+	case "raw":
+		since = time.Now().Add(-10 * time.Minute)
+	case "hour":
+		since = time.Now().Add(-internal.HourRetention)
+	case "", "minute":
+		resolution = "minute"
+		since = time.Now().Add(-internal.MetricsRetention())
+	default:
+		http.Error(w, "resolution must be raw, minute, or hour", http.StatusBadRequest)
+		return
+	}
+
+	points, err := metricsHistory(resolution, since)
+	if err != nil {
+		http.Error(w, "failed to load metrics history", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, metricsHistoryResponse{Resolution: resolution, Points: points})
+}
+
+// metricsHistoryRange writes the history for a named range, averaged over
+// the step query parameter.
+func (c *MonitoringController) metricsHistoryRange(w http.ResponseWriter, r *http.Request, name string) {
+	var step time.Duration
+	if q := r.URL.Query().Get("step"); q != "" {
+		var err error
+		if step, err = time.ParseDuration(q); err != nil || step <= 0 {
+			writeAPIError(w, http.StatusBadRequest, "invalid_step", "step must be a duration such as 5m or 1h")
+			return
+		}
+	}
+
+	points, step, err := metricsRange(name, step, time.Now())
+	var invalid *internal.InvalidMetricRangeError
+	if errors.As(err, &invalid) {
+		writeAPIError(w, http.StatusBadRequest, "invalid_range", err.Error())
+		return
+	}
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "history_unavailable", "failed to load metrics history")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, metricsHistoryResponse{Range: name, StepSeconds: int(step / time.Second), Points: points})
+}
+
+// coderHealth handles GET /api/coder/health, returning the coder health
+// checker's status, failure count, and last automatic restart.
+func (c *MonitoringController) coderHealth(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(services.CoderHealth())
+}
+
+// diskBreakdown writes the disk usage breakdown as JSON. A scan that timed
+// out still answers 200, with incomplete set.
+func (c *MonitoringController) diskBreakdown(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, internal.GetDataDirBreakdown())
+}
+
+// recentLogs handles GET /api/logs. request_id narrows the records to one
+// request, e.g. from a response's X-Request-ID header, and level (debug,
+// info, warn, or error) to those at or above it.
+func (c *MonitoringController) recentLogs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, internal.RecentLogs(r.URL.Query().Get("request_id"), r.URL.Query().Get("level")))
+}
+
+// listMigrations handles GET /api/system/migrations, listing every
+// database migration in order with whether and when it was applied.
+func (c *MonitoringController) listMigrations(w http.ResponseWriter, r *http.Request) {
+	statuses, err := models.ListMigrations()
+	if err != nil {
+		slog.Error("failed to load applied migrations", "error", err)
+		writeAPIError(w, http.StatusInternalServerError, "migrations_unavailable", "failed to load applied migrations")
+		return
+	}
+	writeJSON(w, http.StatusOK, statuses)
+}
+
+// healthCheck reports liveness as JSON with the build version, uptime,
+// and VS Code health status: "online", or 503 with the watchdog state when
+// the data volume is in protective mode so external monitors can alert on it.
 func (c *MonitoringController) healthCheck(w http.ResponseWriter, r *http.Request) {
-	fmt.Fprint(w, "online")
+	body := map[string]any{
+		"status":         "online",
+		"version":        internal.Version,
+		"uptime_seconds": int64(internal.Uptime() / time.Second),
+		"coder":          services.CoderHealth().Status,
+	}
+	if startup := services.CoderState(); startup.State == services.CoderPaused {
+		// Idle on purpose, not broken
+		body["coder"], body["coder_paused_at"] = services.CoderPaused, startup.PausedAt
+	}
+	status := http.StatusOK
+	if volume := internal.GetVolumeStatus(); volume.Protective() {
+		body["status"], body["reason"] = volume.State, volume.Reason
+		status = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, status, body)
+}
+
+// readinessCheck runs the readiness checks and reports each one's result
+// and latency, with 503 when a required check failed so load balancers and
+// orchestrators hold traffic until the workbench can serve it.
+func (c *MonitoringController) readinessCheck(w http.ResponseWriter, r *http.Request) {
+	report := checkReadiness()
+	status := http.StatusOK
+	if !report.Ready {
+		status = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, status, report)
+}
+
+// checkReadiness runs the readiness checks. Replaced in tests.
+var checkReadiness = internal.CheckReadiness
+
+// prometheusMetrics serves the current sample and counts in the Prometheus
+// text format, for scraping. When the metrics_token setting is set, the
+// scrape must send it as a bearer token.
+func (c *MonitoringController) prometheusMetrics(w http.ResponseWriter, r *http.Request) {
+	token, _ := bearerToken(r)
+	if !checkMetricsToken(token) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="metrics"`)
+		http.Error(w, "missing or invalid metrics token", http.StatusUnauthorized)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	internal.WritePrometheus(w, internal.PrometheusMetrics(c.collector, time.Now()))
+}
+
+// checkMetricsToken checks a scrape's bearer token. Replaced in tests.
+var checkMetricsToken = internal.CheckMetricsToken
+
+// timed records each request's time to first byte in a latency bucket
+// shown on the dashboard. Upgraded connections write no header through
+// the ResponseWriter and aren't recorded.
+func timed(bucket string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(&firstByteWriter{ResponseWriter: w, bucket: bucket, start: time.Now()}, r)
+	})
+}
+
+// firstByteWriter records latency when the response header is written.
+type firstByteWriter struct {
+	http.ResponseWriter
+	bucket   string
+	start    time.Time
+	recorded bool
+}
+
+func (w *firstByteWriter) WriteHeader(code int) {
+	if !w.recorded {
+		w.recorded = true
+		internal.RecordLatency(w.bucket, time.Since(w.start))
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *firstByteWriter) Write(p []byte) (int, error) {
+	if !w.recorded {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController for
+// flushing and hijacking.
+func (w *firstByteWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
 }
@@ -0,0 +1,481 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"workbench/internal"
+	"workbench/models"
+	"workbench/services"
+)
+
+// How an API route authenticates callers, for the OpenAPI document.
+const (
+	apiSession        = "session"          // A signed-in session
+	apiSessionOrToken = "session_or_token" // A session or the API token
+)
+
+// apiRoute describes a JSON API endpoint for the OpenAPI document and the
+// route listing. Request and Response are values of the JSON body types,
+// described from their fields' json tags; an api tag adds "required" and
+// "enum=a|b" to a field. A nil Response means no body.
+type apiRoute struct {
+	Method      string     `json:"method"` // From the pattern
+	Path        string     `json:"path"`
+	Summary     string     `json:"summary"`
+	Description string     `json:"-"`
+	Tag         string     `json:"tag"`
+	Auth        string     `json:"auth"`
+	Params      []apiParam `json:"-"` // Path parameters are described even when not listed
+	Request     any        `json:"-"`
+	Response    any        `json:"-"`
+	Status      int        `json:"-"` // Success status; 200 when zero
+	ContentType string     `json:"-"` // Success content type when the body isn't JSON
+}
+
+// apiParam is a path or query parameter of an API route.
+type apiParam struct {
+	Name        string
+	In          string // "query" (the default) or "path"
+	Type        string // "string" (the default), "integer", or "boolean"
+	Description string
+	Required    bool
+	Enum        []string
+}
+
+// apiRoutes are the routes registered with handleAPI, by pattern.
+var apiRoutes = struct {
+	sync.Mutex
+	routes map[string]*apiRoute
+}{routes: map[string]*apiRoute{}}
+
+// handleAPI registers a JSON API handler on the default mux, as
+// http.Handle does, and describes it in GET /api/openapi.json and GET
+// /api/routes with its entry in apiRouteTable, which it must have. Routes
+// registered with http.Handle still work, but are left out of both.
+func handleAPI(pattern string, handler http.Handler) {
+	route, ok := apiRouteTable()[pattern]
+	if !ok {
+		panic(fmt.Sprintf("API route %q isn't described in apiRouteTable", pattern))
+	}
+	http.DefaultServeMux.Handle(pattern, handler)
+	registerAPIRoute(pattern, route)
+}
+
+// apiRouteTable describes the JSON API routes by pattern. Setup serves
+// each with handleAPI, and the OpenAPI test documents the whole table, so
+// the document it checks is the one the API serves.
+func apiRouteTable() map[string]apiRoute {
+	return map[string]apiRoute{
+		// Repositories, for scripts
+		"GET /api/v1/repos": {
+			Summary: "List repositories with their branch, size, and working tree status", Tag: "repos", Auth: apiSessionOrToken,
+			Response: []*internal.RepoSummary{},
+		},
+		"POST /api/v1/repos": {
+			Summary: "Clone a repository and wait for the clone to finish", Tag: "repos", Auth: apiSessionOrToken,
+			Request: internal.CloneOptions{}, Response: internal.RepoSummary{}, Status: http.StatusCreated,
+		},
+		"POST /api/v1/repos/{name}/pull": {
+			Summary: "Pull a repository", Tag: "repos", Auth: apiSessionOrToken,
+			Response: internal.RepoSummary{},
+		},
+		"DELETE /api/v1/repos/{name}": {
+			Summary: "Delete a repository", Tag: "repos", Auth: apiSessionOrToken,
+			Params: []apiParam{{Name: "archive", Type: "boolean", Description: "Archive the repository to the trash first"}},
+			Status: http.StatusNoContent,
+		},
+		"GET /api/v1/repos/{name}/files": {
+			Summary: "List a directory of a repository, directories first", Tag: "repos", Auth: apiSessionOrToken,
+			Params:   []apiParam{{Name: "path", Description: "Directory relative to the repository root; the root when empty"}},
+			Response: []*internal.RepoEntry{},
+		},
+		"GET /api/v1/repos/{name}/file": {
+			Summary: "Raw content of a repository file", Tag: "repos", Auth: apiSessionOrToken,
+			Description: "X-Binary-File tells binary files apart. Files over 2 MB are refused with 413.",
+			Params:      []apiParam{{Name: "path", Description: "File relative to the repository root", Required: true}},
+			ContentType: "application/octet-stream",
+		},
+		"GET /api/v1/repos/{name}/history": {
+			Summary: "Commits that changed a repository file, newest first, across renames", Tag: "repos", Auth: apiSessionOrToken,
+			Params: []apiParam{
+				{Name: "path", Description: "File relative to the repository root", Required: true},
+				{Name: "limit", Type: "integer", Description: fmt.Sprintf("Commits to return, up to %d", internal.MaxFileHistoryLimit)},
+			},
+			Response: []*internal.FileRevision{},
+		},
+		"GET /api/v1/repos/{name}/blame": {
+			Summary: "Blame of a repository file's lines, grouped by commit", Tag: "repos", Auth: apiSessionOrToken,
+			Params: []apiParam{
+				{Name: "path", Description: "File relative to the repository root", Required: true},
+				{Name: "start", Type: "integer", Description: "First line, from 1"},
+				{Name: "end", Type: "integer", Description: "Last line, inclusive"},
+			},
+			Response: internal.FileBlame{},
+		},
+
+		// Machine-readable descriptions of this table
+		"GET /api/openapi.json": {
+			Summary: "This API as an OpenAPI 3 document", Tag: "meta", Auth: apiSessionOrToken,
+			Response: map[string]any{},
+		},
+		"GET /api/routes": {
+			Summary: "List the API routes described in the OpenAPI document", Tag: "meta", Auth: apiSessionOrToken,
+			Response: []*apiRoute{},
+		},
+
+		// Read-only share links
+		"POST /api/share": {
+			Summary: "Create a read-only link to a file, diff, or README", Tag: "shares", Auth: apiSessionOrToken,
+			Description: "The link is only returned here. It works without signing in until it expires or is revoked, while share_links is on.",
+			Request:     internal.ShareOptions{}, Response: shareLink{}, Status: http.StatusCreated,
+		},
+
+		// Monitoring, session only
+		"GET /api/metrics/history": {
+			Summary: "Metrics history for charts", Tag: "metrics", Auth: apiSession,
+			Description: "With range, points are averaged over step. Otherwise resolution selects the tier: raw (the last 10 minutes), minute, or hour.",
+			Params: []apiParam{
+				{Name: "range", Enum: []string{"1h", "24h", "7d"}},
+				{Name: "step", Description: "Duration to average points over, such as 5m; one suited to the range when empty"},
+				{Name: "resolution", Enum: []string{"raw", "minute", "hour"}, Description: "Used without range; minute when empty"},
+			},
+			Response: metricsHistoryResponse{},
+		},
+		"GET /api/coder/health": {
+			Summary: "Coder health checker state", Tag: "metrics", Auth: apiSession,
+			Response: services.CoderHealthStatus{},
+		},
+		"GET /api/disk/breakdown": {
+			Summary: "Disk usage breakdown of the data directory", Tag: "metrics", Auth: apiSession,
+			Response: internal.DiskBreakdown{},
+		},
+		"GET /api/logs": {
+			Summary: "The most recent log records, newest first", Tag: "metrics", Auth: apiSession,
+			Params: []apiParam{
+				{Name: "request_id", Description: "Only records of this request, from a response's X-Request-ID header"},
+				{Name: "level", Enum: []string{"debug", "info", "warn", "error"}, Description: "Only records at or above this level"},
+			},
+			Response: []internal.LogRecord{},
+		},
+		"GET /api/system/migrations": {
+			Summary: "The database migrations and whether each was applied", Tag: "system", Auth: apiSession,
+			Response: []models.MigrationStatus{},
+		},
+	}
+}
+
+// registerAPIRoute records the description of the route for pattern,
+// which must have a method, replacing any earlier one.
+func registerAPIRoute(pattern string, route apiRoute) {
+	method, path, found := strings.Cut(pattern, " ")
+	if !found || strings.HasPrefix(path, " ") {
+		panic(fmt.Sprintf("API route %q needs a method", pattern))
+	}
+	route.Method, route.Path = method, path
+
+	apiRoutes.Lock()
+	defer apiRoutes.Unlock()
+	apiRoutes.routes[pattern] = &route
+}
+
+// listAPIRoutes returns the registered API routes by path, then method.
+func listAPIRoutes() []*apiRoute {
+	apiRoutes.Lock()
+	defer apiRoutes.Unlock()
+	routes := make([]*apiRoute, 0, len(apiRoutes.routes))
+	for _, route := range apiRoutes.routes {
+		routes = append(routes, route)
+	}
+	slices.SortFunc(routes, func(a, b *apiRoute) int {
+		if c := strings.Compare(a.Path, b.Path); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Method, b.Method)
+	})
+	return routes
+}
+
+// openAPISpec handles GET /api/openapi.json, describing the registered
+// API routes as an OpenAPI 3 document.
+func (c *WorkbenchController) openAPISpec(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, openAPIDocument(listAPIRoutes(), internal.SessionCookie()))
+}
+
+// listRoutesAPI handles GET /api/routes, listing the registered API
+// routes' method, path, summary, tag, and authentication for debugging.
+func (c *WorkbenchController) listRoutesAPI(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, listAPIRoutes())
+}
+
+// openAPIVersion is the version of the OpenAPI specification the document
+// follows.
+const openAPIVersion = "3.0.3"
+
+// pathParams matches the wildcards of a route pattern's path.
+var pathParams = regexp.MustCompile(`\{([^}]*)\}`)
+
+// openAPIDocument builds the OpenAPI document for routes. Sessions are
+// described as the cookie named sessionCookie, "workbench" when empty.
+func openAPIDocument(routes []*apiRoute, sessionCookie string) map[string]any {
+	if sessionCookie == "" {
+		sessionCookie = "workbench"
+	}
+	schemas := &openAPISchemas{schemas: map[string]map[string]any{}, types: map[string]reflect.Type{}}
+	errorSchema := schemas.of(reflect.TypeFor[apiError]())
+
+	paths := map[string]map[string]any{}
+	for _, route := range routes {
+		// {path...} matches the rest of the path, which OpenAPI can't express
+		path := strings.ReplaceAll(route.Path, "...}", "}")
+		if paths[path] == nil {
+			paths[path] = map[string]any{}
+		}
+
+		status := route.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		success := map[string]any{"description": http.StatusText(status)}
+		switch {
+		case route.ContentType != "":
+			success["content"] = map[string]any{route.ContentType: map[string]any{
+				"schema": map[string]any{"type": "string", "format": "binary"},
+			}}
+		case route.Response != nil:
+			success["content"] = map[string]any{"application/json": map[string]any{
+				"schema": schemas.of(reflect.TypeOf(route.Response)),
+			}}
+		}
+
+		operation := map[string]any{
+			"operationId": operationID(route.Method, path),
+			"summary":     route.Summary,
+			"parameters":  openAPIParams(path, route.Params),
+			"responses": map[string]any{
+				fmt.Sprint(status): success,
+				"default": map[string]any{
+					"description": "Error",
+					"content":     map[string]any{"application/json": map[string]any{"schema": errorSchema}},
+				},
+			},
+		}
+		if route.Description != "" {
+			operation["description"] = route.Description
+		}
+		if route.Tag != "" {
+			operation["tags"] = []string{route.Tag}
+		}
+		if route.Request != nil {
+			operation["requestBody"] = map[string]any{
+				"required": true,
+				"content": map[string]any{"application/json": map[string]any{
+					"schema": schemas.of(reflect.TypeOf(route.Request)),
+				}},
+			}
+		}
+		switch route.Auth {
+		case apiSession:
+			operation["security"] = []map[string][]string{{"session": {}}}
+		case apiSessionOrToken:
+			operation["security"] = []map[string][]string{{"session": {}}, {"token": {}}}
+		}
+		paths[path][strings.ToLower(route.Method)] = operation
+	}
+
+	return map[string]any{
+		"openapi": openAPIVersion,
+		"info": map[string]any{
+			"title":   "Workbench API",
+			"version": internal.Version,
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas": schemas.schemas,
+			"securitySchemes": map[string]any{
+				"session": map[string]any{"type": "apiKey", "in": "cookie", "name": sessionCookie},
+				"token":   map[string]any{"type": "http", "scheme": "bearer", "description": "The API token from Settings"},
+			},
+		},
+	}
+}
+
+// operationID names an operation for generated clients, such as
+// getApiV1ReposNameFiles for GET /api/v1/repos/{name}/files.
+func operationID(method, path string) string {
+	id := strings.ToLower(method)
+	words := strings.FieldsFunc(path, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for _, word := range words {
+		id += strings.ToUpper(word[:1]) + word[1:]
+	}
+	return id
+}
+
+// openAPIParams describes a route's parameters, with every wildcard in
+// path as a required path parameter, described by the parameter of its
+// name if there is one.
+func openAPIParams(path string, params []apiParam) []map[string]any {
+	byName := map[string]apiParam{}
+	for _, param := range params {
+		byName[param.Name] = param
+	}
+
+	var described []map[string]any
+	for _, match := range pathParams.FindAllStringSubmatch(path, -1) {
+		param := byName[match[1]]
+		param.Name, param.In, param.Required = match[1], "path", true
+		described = append(described, openAPIParam(param))
+		delete(byName, match[1])
+	}
+	for _, param := range params {
+		if _, ok := byName[param.Name]; ok && param.In != "path" {
+			described = append(described, openAPIParam(param))
+		}
+	}
+	if described == nil {
+		return []map[string]any{}
+	}
+	return described
+}
+
+func openAPIParam(param apiParam) map[string]any {
+	in, kind := param.In, param.Type
+	if in == "" {
+		in = "query"
+	}
+	if kind == "" {
+		kind = "string"
+	}
+	schema := map[string]any{"type": kind}
+	if len(param.Enum) > 0 {
+		schema["enum"] = param.Enum
+	}
+	described := map[string]any{"name": param.Name, "in": in, "required": param.Required, "schema": schema}
+	if param.Description != "" {
+		described["description"] = param.Description
+	}
+	return described
+}
+
+// openAPISchemas collects the component schemas of named struct types,
+// which operations refer to by name.
+type openAPISchemas struct {
+	schemas map[string]map[string]any
+	types   map[string]reflect.Type // The type each name was given to
+}
+
+// of returns the schema for values of type t, adding named struct types
+// to the components and referring to them.
+func (s *openAPISchemas) of(t reflect.Type) map[string]any {
+	if t == reflect.TypeFor[time.Time]() {
+		return map[string]any{"type": "string", "format": "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		schema := s.of(t.Elem())
+		if _, ref := schema["$ref"]; ref {
+			return map[string]any{"allOf": []any{schema}, "nullable": true}
+		}
+		schema["nullable"] = true
+		return schema
+	case reflect.Struct:
+		if t.Name() == "" {
+			return s.object(t)
+		}
+		name, known := s.name(t)
+		if !known {
+			s.types[name] = t // First, for types that refer to themselves
+			s.schemas[name] = s.object(t)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + name}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]any{"type": "string", "format": "byte"}
+		}
+		return map[string]any{"type": "array", "items": s.of(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": s.of(t.Elem())}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint64:
+		return map[string]any{"type": "integer", "format": "int64"}
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return map[string]any{"type": "integer", "format": "int32"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	default:
+		return map[string]any{} // Any value
+	}
+}
+
+// name returns the component name for a named struct type, capitalized
+// and qualified by its package when another package's type has the name,
+// and whether the type was already added.
+func (s *openAPISchemas) name(t reflect.Type) (string, bool) {
+	name := strings.ToUpper(t.Name()[:1]) + t.Name()[1:]
+	if other, taken := s.types[name]; taken && other != t {
+		pkg := t.PkgPath()[strings.LastIndex(t.PkgPath(), "/")+1:]
+		name = strings.ToUpper(pkg[:1]) + pkg[1:] + name
+	}
+	return name, s.types[name] == t
+}
+
+// object describes a struct's JSON fields as encoding/json encodes them,
+// with embedded structs' fields inline.
+func (s *openAPISchemas) object(t reflect.Type) map[string]any {
+	properties := map[string]any{}
+	var required []string
+	s.fields(t, properties, &required)
+	schema := map[string]any{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+func (s *openAPISchemas) fields(t reflect.Type, properties map[string]any, required *[]string) {
+	for i := range t.NumField() {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if field.Anonymous && name == "" {
+			embedded := field.Type
+			if embedded.Kind() == reflect.Pointer {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				s.fields(embedded, properties, required)
+				continue
+			}
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		schema := s.of(field.Type)
+		for _, option := range strings.Split(field.Tag.Get("api"), ",") {
+			switch {
+			case option == "required":
+				*required = append(*required, name)
+			case strings.HasPrefix(option, "enum="):
+				schema["enum"] = strings.Split(strings.TrimPrefix(option, "enum="), "|")
+			}
+		}
+		properties[name] = schema
+	}
+}
@@ -0,0 +1,164 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"workbench/internal"
+
+	"github.com/The-Skyscape/devtools/pkg/testutils"
+	"github.com/getkin/kin-openapi/openapi3"
+)
+
+// fakeAPIRoutes replaces the registered API routes for a test.
+func fakeAPIRoutes(t *testing.T) {
+	original := apiRoutes.routes
+	apiRoutes.routes = map[string]*apiRoute{}
+	t.Cleanup(func() { apiRoutes.routes = original })
+}
+
+// registerAPIRouteTable describes every route of apiRouteTable, as
+// Setup's handleAPI calls do, since Setup can't run without the app.
+func registerAPIRouteTable() {
+	for pattern, route := range apiRouteTable() {
+		registerAPIRoute(pattern, route)
+	}
+}
+
+func TestOpenAPIDocumentIsValid(t *testing.T) {
+	fakeAPIRoutes(t)
+	registerAPIRouteTable()
+
+	w := httptest.NewRecorder()
+	writeJSON(w, http.StatusOK, openAPIDocument(listAPIRoutes(), ""))
+	spec, err := openapi3.NewLoader().LoadFromData(w.Body.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := spec.Validate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	var doc map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	// Spot checks of what the types and registrations describe
+	paths := doc["paths"].(map[string]any)
+	testutils.AssertEqual(t, len(apiRouteTable()), len(listAPIRoutes()))
+	clone := paths["/api/v1/repos"].(map[string]any)["post"].(map[string]any)
+	testutils.AssertEqual(t, "postApiV1Repos", clone["operationId"])
+	testutils.AssertEqual(t, true, clone["responses"].(map[string]any)["201"] != nil)
+	testutils.AssertEqual(t, 2, len(clone["security"].([]any)))
+
+	schemas := doc["components"].(map[string]any)["schemas"].(map[string]any)
+	options := schemas["CloneOptions"].(map[string]any)
+	testutils.AssertEqual(t, []any{"url"}, options["required"])
+	testutils.AssertEqual(t, nil, options["properties"].(map[string]any)["Wait"])
+	summary := schemas["RepoSummary"].(map[string]any)["properties"].(map[string]any)
+	testutils.AssertEqual(t, map[string]any{"type": "integer", "format": "int64", "nullable": true}, summary["size"])
+	testutils.AssertEqual(t, "clean", summary["status"].(map[string]any)["enum"].([]any)[0])
+	revision := schemas["FileRevision"].(map[string]any)["properties"].(map[string]any)
+	testutils.AssertEqual(t, map[string]any{"type": "string", "format": "date-time"}, revision["date"])
+
+	files := paths["/api/v1/repos/{name}/files"].(map[string]any)["get"].(map[string]any)
+	testutils.AssertEqual(t, []any{
+		map[string]any{"name": "name", "in": "path", "required": true, "schema": map[string]any{"type": "string"}},
+		map[string]any{"name": "path", "in": "query", "required": false, "description": "Directory relative to the repository root; the root when empty",
+			"schema": map[string]any{"type": "string"}},
+	}, files["parameters"])
+}
+
+func TestOpenAPIDocumentOfRestWildcard(t *testing.T) {
+	doc := openAPIDocument([]*apiRoute{{Method: "GET", Path: "/api/trash/{file...}", Summary: "Download an archive", ContentType: "application/gzip"}}, "")
+	operation := doc["paths"].(map[string]map[string]any)["/api/trash/{file}"]["get"].(map[string]any)
+	testutils.AssertEqual(t, "getApiTrashFile", operation["operationId"])
+	testutils.AssertEqual(t, "path", operation["parameters"].([]map[string]any)[0]["in"])
+}
+
+// TestAPIRouteTableIsServed checks that Setup serves every route of
+// apiRouteTable with handleAPI, so the document describes what is served.
+func TestAPIRouteTableIsServed(t *testing.T) {
+	files, err := filepath.Glob("*.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := map[string]bool{}
+	fset := token.NewFileSet()
+	for _, file := range files {
+		if strings.HasSuffix(file, "_test.go") {
+			continue
+		}
+		parsed, err := parser.ParseFile(fset, file, nil, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ast.Inspect(parsed, func(n ast.Node) bool {
+			call, ok := n.(*ast.CallExpr)
+			if !ok || len(call.Args) == 0 {
+				return true
+			}
+			if fun, ok := call.Fun.(*ast.Ident); !ok || fun.Name != "handleAPI" {
+				return true
+			}
+			if lit, ok := call.Args[0].(*ast.BasicLit); ok {
+				pattern, _ := strconv.Unquote(lit.Value)
+				served[pattern] = true
+			}
+			return true
+		})
+	}
+
+	table := apiRouteTable()
+	for pattern := range table {
+		if !served[pattern] {
+			t.Errorf("%q is described but not served with handleAPI", pattern)
+		}
+	}
+	for pattern := range served {
+		if _, ok := table[pattern]; !ok {
+			t.Errorf("%q is served with handleAPI but not described in apiRouteTable", pattern)
+		}
+	}
+}
+
+func TestListAPIRoutes(t *testing.T) {
+	fakeAPIRoutes(t)
+	registerAPIRoute("POST /api/v1/repos", apiRoute{Summary: "Clone a repository", Tag: "repos", Auth: apiSessionOrToken})
+	registerAPIRoute("GET /api/v1/repos", apiRoute{Summary: "List repositories", Tag: "repos", Auth: apiSessionOrToken})
+	registerAPIRoute("GET /api/logs", apiRoute{Summary: "Logs", Auth: apiSession, Response: []internal.LogRecord{}})
+
+	w := httptest.NewRecorder()
+	(&WorkbenchController{}).listRoutesAPI(w, httptest.NewRequest("GET", "/api/routes", nil))
+	testutils.AssertEqual(t, http.StatusOK, w.Code)
+	testutils.AssertEqual(t, `[{"method":"GET","path":"/api/logs","summary":"Logs","tag":"","auth":"session"},`+
+		`{"method":"GET","path":"/api/v1/repos","summary":"List repositories","tag":"repos","auth":"session_or_token"},`+
+		`{"method":"POST","path":"/api/v1/repos","summary":"Clone a repository","tag":"repos","auth":"session_or_token"}]`,
+		strings.TrimSpace(w.Body.String()))
+}
+
+func TestOpenAPISchemaOfRecursiveType(t *testing.T) {
+	type node struct {
+		Name     string    `json:"name"`
+		Children []*node   `json:"children,omitempty"`
+		Seen     time.Time `json:"seen"`
+		hidden   bool
+	}
+	schemas := &openAPISchemas{schemas: map[string]map[string]any{}, types: map[string]reflect.Type{}}
+	testutils.AssertEqual(t, map[string]any{"$ref": "#/components/schemas/Node"}, schemas.of(reflect.TypeFor[node]()))
+	properties := schemas.schemas["Node"]["properties"].(map[string]any)
+	testutils.AssertEqual(t, 3, len(properties))
+	testutils.AssertEqual(t, map[string]any{"type": "array", "items": map[string]any{
+		"allOf": []any{map[string]any{"$ref": "#/components/schemas/Node"}}, "nullable": true,
+	}}, properties["children"])
+}
@@ -0,0 +1,97 @@
+package controllers
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// registeredPatterns returns the pattern of every http.Handle,
+// http.HandleFunc, and handleAPI call in the given files, with where it was made.
+// Routes are registered on the default mux in Setup, which can't run
+// without the app, so the patterns are read from the source.
+func registeredPatterns(t *testing.T, files []string) map[string]string {
+	t.Helper()
+	patterns := map[string]string{}
+	fset := token.NewFileSet()
+	for _, file := range files {
+		parsed, err := parser.ParseFile(fset, file, nil, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ast.Inspect(parsed, func(n ast.Node) bool {
+			call, ok := n.(*ast.CallExpr)
+			if !ok || len(call.Args) == 0 {
+				return true
+			}
+			if !isRouteRegistration(call.Fun) {
+				return true
+			}
+			where := fset.Position(call.Pos()).String()
+			lit, ok := call.Args[0].(*ast.BasicLit)
+			if !ok || lit.Kind != token.STRING {
+				t.Errorf("%s: route pattern is not a string literal", where)
+				return true
+			}
+			pattern, _ := strconv.Unquote(lit.Value)
+			if earlier, ok := patterns[pattern]; ok {
+				t.Errorf("%s: %q is already registered at %s", where, pattern, earlier)
+			}
+			patterns[pattern] = where
+			return true
+		})
+	}
+	return patterns
+}
+
+// isRouteRegistration reports whether fun is http.Handle,
+// http.HandleFunc, or handleAPI.
+func isRouteRegistration(fun ast.Expr) bool {
+	switch fun := fun.(type) {
+	case *ast.Ident:
+		return fun.Name == "handleAPI"
+	case *ast.SelectorExpr:
+		pkg, ok := fun.X.(*ast.Ident)
+		return ok && pkg.Name == "http" && (fun.Sel.Name == "Handle" || fun.Sel.Name == "HandleFunc")
+	}
+	return false
+}
+
+func TestRoutesDoNotConflict(t *testing.T) {
+	files, err := filepath.Glob("*.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	files = append(files, filepath.Join("..", "main.go"))
+	var sources []string
+	for _, file := range files {
+		if !strings.HasSuffix(file, "_test.go") {
+			sources = append(sources, file)
+		}
+	}
+
+	patterns := registeredPatterns(t, sources)
+	if len(patterns) == 0 {
+		t.Fatal("no routes found")
+	}
+
+	// A fresh mux panics on patterns that conflict, as the default mux
+	// would at startup
+	mux := http.NewServeMux()
+	for pattern, where := range patterns {
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					t.Errorf("%s: %v", where, fmt.Sprint(r))
+				}
+			}()
+			mux.Handle(pattern, http.NotFoundHandler())
+		}()
+	}
+}
@@ -0,0 +1,262 @@
+package controllers
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WebSocket opcodes (RFC 6455).
+const (
+	wsContinuation = 0x0
+	wsText         = 0x1
+	wsBinary       = 0x2
+	wsClose        = 0x8
+	wsPing         = 0x9
+	wsPong         = 0xA
+)
+
+// WebSocket close codes sent to the browser.
+const (
+	wsNormalClosure = 1000
+	wsPolicy        = 1008
+	wsInternalError = 1011
+	wsTryAgainLater = 1013
+)
+
+// wsMaxMessage caps a message from the browser. Typed input and control
+// frames are small; a paste bigger than this ends the connection.
+const wsMaxMessage = 1 << 20
+
+// wsWriteTimeout bounds a write to the browser, so a connection that went
+// away without closing is noticed instead of blocking.
+const wsWriteTimeout = 10 * time.Second
+
+// wsAcceptGUID is mixed into Sec-WebSocket-Accept (RFC 6455 section 4.2.2).
+const wsAcceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// wsConn is a server side WebSocket connection. Reads must come from one
+// goroutine; writes may come from any.
+type wsConn struct {
+	conn        net.Conn
+	reader      *bufio.Reader
+	readTimeout time.Duration // Longest wait for each frame; 0 waits forever
+
+	writeMu sync.Mutex
+	closed  bool // A close frame was sent
+}
+
+// acceptWebSocket upgrades a request to a WebSocket connection. Requests
+// from a page on another site are refused, as browsers let any page open
+// a WebSocket with the user's cookies. On error a response was written.
+func acceptWebSocket(w http.ResponseWriter, r *http.Request) (*wsConn, error) {
+	if !headerHasToken(r.Header, "Connection", "upgrade") || !headerHasToken(r.Header, "Upgrade", "websocket") {
+		http.Error(w, "expected a WebSocket upgrade", http.StatusBadRequest)
+		return nil, errors.New("not a WebSocket upgrade")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "unsupported WebSocket version", http.StatusUpgradeRequired)
+		return nil, errors.New("unsupported WebSocket version")
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		http.Error(w, "invalid Sec-WebSocket-Key", http.StatusBadRequest)
+		return nil, errors.New("invalid Sec-WebSocket-Key")
+	}
+	if !sameOrigin(r) {
+		http.Error(w, "cross-origin WebSocket refused", http.StatusForbidden)
+		return nil, errors.New("cross-origin WebSocket")
+	}
+
+	conn, rw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		http.Error(w, "WebSockets are not supported here", http.StatusInternalServerError)
+		return nil, fmt.Errorf("failed to take over the connection: %w", err)
+	}
+	sum := sha1.Sum([]byte(key + wsAcceptGUID))
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n")
+	if id := w.Header().Get(requestIDHeader); id != "" {
+		rw.WriteString(requestIDHeader + ": " + id + "\r\n")
+	}
+	rw.WriteString("Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n\r\n")
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &wsConn{conn: conn, reader: rw.Reader}, nil
+}
+
+// headerHasToken reports whether a comma-separated header lists token.
+func headerHasToken(h http.Header, name, token string) bool {
+	for _, value := range h.Values(name) {
+		for _, part := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// sameOrigin reports whether a request's Origin, when it has one, is the
+// host it was sent to.
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+// ReadMessage returns the next text or binary message, answering pings on
+// the way. It returns io.EOF once the browser closes the connection.
+func (c *wsConn) ReadMessage() (opcode byte, message []byte, err error) {
+	for {
+		final, op, payload, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+		switch op {
+		case wsPing:
+			if err := c.writeFrame(wsPong, payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case wsPong:
+			continue
+		case wsClose:
+			c.Close(wsNormalClosure, "")
+			return 0, nil, io.EOF
+		case wsText, wsBinary:
+			if opcode != 0 {
+				return 0, nil, errors.New("new message inside a fragmented one")
+			}
+			opcode = op
+		case wsContinuation:
+			if opcode == 0 {
+				return 0, nil, errors.New("continuation without a message")
+			}
+		default:
+			return 0, nil, fmt.Errorf("unknown opcode %d", op)
+		}
+		if len(message)+len(payload) > wsMaxMessage {
+			c.Close(wsPolicy, "message too big")
+			return 0, nil, errors.New("message too big")
+		}
+		message = append(message, payload...)
+		if final {
+			return opcode, message, nil
+		}
+	}
+}
+
+// readFrame reads one frame, unmasking its payload.
+func (c *wsConn) readFrame() (final bool, opcode byte, payload []byte, err error) {
+	if c.readTimeout > 0 {
+		c.conn.SetReadDeadline(time.Now().Add(c.readTimeout))
+	}
+	var header [2]byte
+	if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+		return false, 0, nil, err
+	}
+	final = header[0]&0x80 != 0
+	opcode = header[0] & 0x0F
+	if header[1]&0x80 == 0 {
+		return false, 0, nil, errors.New("unmasked frame from the browser")
+	}
+
+	length := uint64(header[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.reader, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.reader, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if length > wsMaxMessage {
+		c.Close(wsPolicy, "message too big")
+		return false, 0, nil, errors.New("frame too big")
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(c.reader, mask[:]); err != nil {
+		return false, 0, nil, err
+	}
+	payload = make([]byte, length)
+	if _, err := io.ReadFull(c.reader, payload); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return final, opcode, payload, nil
+}
+
+// WriteMessage sends a text or binary message as one frame.
+func (c *wsConn) WriteMessage(opcode byte, message []byte) error {
+	return c.writeFrame(opcode, message)
+}
+
+// writeFrame sends an unmasked frame, as servers do.
+func (c *wsConn) writeFrame(opcode byte, payload []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closed {
+		return net.ErrClosed
+	}
+
+	header := []byte{0x80 | opcode}
+	switch n := len(payload); {
+	case n < 126:
+		header = append(header, byte(n))
+	case n <= 0xFFFF:
+		header = append(header, 126)
+		header = binary.BigEndian.AppendUint16(header, uint16(n))
+	default:
+		header = append(header, 127)
+		header = binary.BigEndian.AppendUint64(header, uint64(n))
+	}
+	c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	if _, err := c.conn.Write(append(header, payload...)); err != nil {
+		return err
+	}
+	if opcode == wsClose {
+		c.closed = true
+	}
+	return nil
+}
+
+// Close sends a close frame with a code and a reason the browser can show,
+// then closes the connection. It is safe to call more than once.
+func (c *wsConn) Close(code int, reason string) error {
+	if len(reason) > 123 { // Control frames carry at most 125 bytes
+		reason = reason[:123]
+	}
+	c.writeFrame(wsClose, append(binary.BigEndian.AppendUint16(nil, uint16(code)), reason...))
+	return c.conn.Close()
+}
+
+// SetReadTimeout sets how long ReadMessage waits for each frame, pongs
+// included, before giving up on the connection.
+func (c *wsConn) SetReadTimeout(d time.Duration) {
+	c.readTimeout = d
+}
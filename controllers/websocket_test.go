@@ -0,0 +1,123 @@
+package controllers
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/The-Skyscape/devtools/pkg/testutils"
+)
+
+// dialWebSocket opens a WebSocket to url with an Origin header, returning
+// the connection and the handshake response.
+func dialWebSocket(t *testing.T, url, origin string) (net.Conn, *bufio.Reader, *http.Response) {
+	t.Helper()
+	conn, err := net.Dial("tcp", strings.TrimPrefix(url, "http://"))
+	testutils.AssertEqual(t, nil, err)
+	t.Cleanup(func() { conn.Close() })
+
+	host := strings.TrimPrefix(url, "http://")
+	io.WriteString(conn, "GET /ws HTTP/1.1\r\nHost: "+host+"\r\nOrigin: "+origin+"\r\n"+
+		"Upgrade: websocket\r\nConnection: keep-alive, Upgrade\r\n"+
+		"Sec-WebSocket-Version: 13\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n\r\n")
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	testutils.AssertEqual(t, nil, err)
+	return conn, reader, resp
+}
+
+// writeClientFrame sends a masked frame, as browsers do.
+func writeClientFrame(conn net.Conn, final bool, opcode byte, payload []byte) {
+	first := opcode
+	if final {
+		first |= 0x80
+	}
+	frame := []byte{first, 0x80 | byte(len(payload)), 1, 2, 3, 4}
+	for i, b := range payload {
+		frame = append(frame, b^[]byte{1, 2, 3, 4}[i%4])
+	}
+	conn.Write(frame)
+}
+
+// readServerFrame reads an unmasked frame of under 126 bytes.
+func readServerFrame(t *testing.T, r *bufio.Reader) (byte, []byte) {
+	t.Helper()
+	var header [2]byte
+	_, err := io.ReadFull(r, header[:])
+	testutils.AssertEqual(t, nil, err)
+	payload := make([]byte, header[1])
+	io.ReadFull(r, payload)
+	return header[0] & 0x0F, payload
+}
+
+// echoServer echoes WebSocket messages back, upper-cased.
+func echoServer(t *testing.T) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := acceptWebSocket(w, r)
+		if err != nil {
+			return
+		}
+		for {
+			opcode, message, err := ws.ReadMessage()
+			if err != nil {
+				return
+			}
+			ws.WriteMessage(opcode, []byte(strings.ToUpper(string(message))))
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestAcceptWebSocketHandshake(t *testing.T) {
+	server := echoServer(t)
+	_, _, resp := dialWebSocket(t, server.URL, server.URL)
+
+	testutils.AssertEqual(t, http.StatusSwitchingProtocols, resp.StatusCode)
+	// The example key and answer from RFC 6455
+	testutils.AssertEqual(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", resp.Header.Get("Sec-WebSocket-Accept"))
+}
+
+func TestWebSocketRelaysMessages(t *testing.T) {
+	server := echoServer(t)
+	conn, reader, _ := dialWebSocket(t, server.URL, server.URL)
+
+	writeClientFrame(conn, true, wsBinary, []byte("ls -la"))
+	opcode, payload := readServerFrame(t, reader)
+	testutils.AssertEqual(t, byte(wsBinary), opcode)
+	testutils.AssertEqual(t, "LS -LA", string(payload))
+
+	// Fragmented, with a ping in between that's answered first
+	writeClientFrame(conn, false, wsText, []byte("res"))
+	writeClientFrame(conn, true, wsPing, []byte("hi"))
+	writeClientFrame(conn, true, wsContinuation, []byte("ize"))
+	opcode, payload = readServerFrame(t, reader)
+	testutils.AssertEqual(t, byte(wsPong), opcode)
+	testutils.AssertEqual(t, "hi", string(payload))
+	opcode, payload = readServerFrame(t, reader)
+	testutils.AssertEqual(t, byte(wsText), opcode)
+	testutils.AssertEqual(t, "RESIZE", string(payload))
+
+	writeClientFrame(conn, true, wsClose, binary.BigEndian.AppendUint16(nil, wsNormalClosure))
+	opcode, _ = readServerFrame(t, reader)
+	testutils.AssertEqual(t, byte(wsClose), opcode)
+}
+
+func TestAcceptWebSocketRefusesOtherOrigins(t *testing.T) {
+	server := echoServer(t)
+	_, _, resp := dialWebSocket(t, server.URL, "https://evil.example")
+	testutils.AssertEqual(t, http.StatusForbidden, resp.StatusCode)
+}
+
+func TestAcceptWebSocketRefusesPlainRequests(t *testing.T) {
+	server := echoServer(t)
+	resp, err := http.Get(server.URL)
+	testutils.AssertEqual(t, nil, err)
+	resp.Body.Close()
+	testutils.AssertEqual(t, http.StatusBadRequest, resp.StatusCode)
+}
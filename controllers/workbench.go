@@ -1,10 +1,23 @@
 package controllers
 
 import (
-	"log"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
 	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 	"workbench/internal"
+	"workbench/internal/i18n"
+	"workbench/internal/providers"
 	"workbench/models"
 	"workbench/services"
 
@@ -24,8 +37,20 @@ func Workbench() (string, *WorkbenchController) {
 // - VS Code integration via code-server proxy
 // - SSH key management for repository access
 // - Activity logging and display
+//
+// The controller has no process-shared fields: Handle returns a copy per
+// request, and the embedded Controller's Request is request-scoped.
+// Shared state (settings, provider caches, SSH status) lives in internal
+// behind its own locks. Helpers must not memoize on the controller; a
+// per-request memo belongs on the copy made in Handle.
 type WorkbenchController struct {
 	application.Controller
+
+	// Per-request, set on the copy made in Handle
+	locale    *i18n.Locale                  // Resolved on first use
+	timezone  *time.Location                // Resolved on first use
+	repos     map[string]*models.Repository // Loaded on first use, by name
+	locations []*models.StorageLocation     // Loaded on first use; empty, not nil, once loaded
 }
 
 // Setup initializes the workbench controller during application startup.
@@ -34,9 +59,182 @@ type WorkbenchController struct {
 // Routes registered:
 // - GET / - Main dashboard with system stats
 // - POST /repos/clone - Clone a new repository
+// - POST /repos/create - Create a new repository from a project template
 // - POST /repos/pull/{name} - Pull latest changes
-// - POST /repos/delete/{name} - Delete a repository
-// - GET /partials/activity - Activity log partial for HTMX
+// - POST /repos/delete/{name} - Show what deleting a repository would lose; with the token it returns, delete it
+// - POST /repos/rename/{name} - Rename a repository and move its directory
+// - POST /repos/group/{name} - Move a repository into a dashboard group, or out of one
+// - POST /repos/pin/{name} - Pin a repository above the groups, or unpin it
+// - POST /repos/lock/{name} - Lock a repository against deletion, renaming, and destructive git actions, or unlock it
+// - POST /repos/meta/{name} - Edit a repository's description
+// - POST /repos/fetch-metadata/{name} - Fill in a repository's description, language, and stars from its provider
+// - POST /repos/rename-group - Rename a group, moving all of its repositories
+// - POST /repos/group-collapse - Remember whether a group is folded on the dashboard
+// - POST /repos/reclone/{name} - Re-clone a repository whose directory is missing
+// - POST /repos/clone-retry/{name} - Retry a repository's failed clone
+// - POST /repos/clone-dismiss/{name} - Dismiss a failed queued clone, or cancel one that hasn't started
+// - POST /repos/import-list - List repositories to import from pasted URLs or a GitHub/GitLab account
+// - POST /repos/import - Queue clones of the selected repositories
+// - POST /repos/reconcile - Adopt unrecorded repository directories and flag missing ones
+// - POST /repos/export/{name} - Push a repository to a new empty remote
+// - POST /repos/checkout/{name} - Switch a repository to another branch
+// - POST /repos/lfs-install/{name} - Install Git LFS in the coder container, then pull a repository's LFS files
+// - POST /repos/lfs-pull/{name} - Download a repository's LFS files in place of their pointer stubs
+// - GET /repos/edit/{name}?path= - Inline single-file editor
+// - POST /repos/edit/{name} - Commit an inline single-file edit
+// - POST /repos/setup/{name}/{step}/run - Run a detected setup step
+// - POST /repos/setup/{name}/{step}/dismiss - Hide a setup suggestion
+// - POST /repos/setup/{name}/{step}/autorun - Toggle a post-clone hook
+// - POST /repos/prs/{name}/{number}/fetch - Check out a pull request branch
+// - POST /repos/safety/{name}/toggle - Turn safety points on or off
+// - POST /repos/safety/{name}/{id}/restore - Reapply captured uncommitted work
+// - POST /repos/reset/{name} - Hard-reset to the upstream branch
+// - POST /repos/merge-abort/{name} - Abort an in-progress merge, rebase, cherry-pick, or revert
+// - POST /repos/merge-ours/{name} - Resolve every conflict keeping the local changes
+// - POST /repos/merge-theirs/{name} - Resolve every conflict keeping the upstream changes
+// - POST /repos/prune-branches/{name} - Delete merged local branches
+// - POST /repos/revert/{name} - Commit the inverse of the commit sha
+// - POST /repos/cherry-pick/{name} - Apply the commit sha onto the current branch
+// - POST /repos/stash/{name} - Stash uncommitted changes
+// - POST /repos/stash-pop/{name} - Apply and remove a stash
+// - POST /repos/stash-drop/{name} - Delete a stash
+// - POST /repos/tag/{name} - Tag HEAD with an annotated tag and optionally push it
+// - POST /repos/remotes/{name} - Set a remote's URL, adding it if missing, or remove a remote
+// - POST /repos/maintenance/{name} - Preview or run merged branch cleanup and git gc
+// - POST /repos/webhook/{name} - Generate a push webhook URL and secret
+// - POST /repos/run/{name} - Build and run a repository's app container
+// - POST /repos/stop/{name} - Stop and remove a repository's app container
+// - POST /hooks/git/{name} - Receive a GitHub or GitLab push webhook
+// - POST /hosts/pull/{host} - Pull every repository cloned from a host
+// - POST /hosts/autopull/{host} - Pause or resume auto-pull for a host
+// - POST /settings/autopull - Save how often repositories are pulled in the background
+// - POST /settings/missing-repos - Save what pulls do when a repository directory is missing
+// - POST /ssh/check - Warn about cloning from an unverified SSH host
+// - POST /ssh/test/{host} - Test SSH authentication with a Git host
+// - POST /ssh/rotate - Rotate the default SSH key, optionally uploading it to providers
+// - POST /ssh/keys - Generate a named SSH key
+// - POST /ssh/keys/import - Import a named SSH key from its private key
+// - POST /ssh/keys/{name}/hosts - Set the hosts that use a named SSH key
+// - POST /ssh/keys/{name}/delete - Delete a named SSH key no host uses
+// - POST /git-credentials - Save an access token for private HTTPS repositories on a host
+// - POST /git-credentials/{host}/delete - Remove a host's access token
+// - POST /settings/provider-token - Save the API token for a provider host
+// - POST /settings/locale - Save the display locale and timezone preferences
+// - POST /settings/digest - Save activity digest, quiet-hours, and notification channel settings
+// - POST /settings/smtp - Save the SMTP server notification emails are sent through
+// - GET /partials/notifications - Unread count and newest in-app notifications for the header bell
+// - POST /notifications/read/{id} - Mark an in-app notification read
+// - POST /notifications/read-all - Mark every in-app notification read
+// - POST /settings/scaffold/branch - Save the default branch for new projects
+// - POST /settings/scaffold/files - Add or replace an always-include file
+// - POST /settings/scaffold/delete/{id} - Remove an always-include file
+// - GET /partials/scaffold-preview?template= - Files a new project would contain
+// - POST /repos/from-template - Create a project from a starter template, filling in its placeholders
+// - GET /partials/repo-template-fields?template= - Placeholders a starter template declares
+// - POST /settings/repo-templates - Add a starter template repository
+// - POST /settings/repo-templates/refresh/{id} - Read a starter template's template.json again
+// - POST /settings/repo-templates/delete/{id} - Remove a starter template
+// - POST /diagnostics/permissions - Check and repair coder container permissions
+// - GET /partials/repos?group=host - Repository list, optionally grouped by host
+// - GET /partials/activity?page=&per_page=&type=&security=&repository= - Filtered, paginated activity log partial
+// - GET /partials/activity/{id} - Activity detail partial with quick actions
+// - POST /activities/prune - Delete activities older than activity_retention_days now
+// - GET /partials/setup/{name} - Setup suggestions partial for a repository
+// - GET /partials/repo-prs/{name} - Open pull requests partial for a repository
+// - GET /partials/repo-app/{name} - App container status of a repository
+// - GET /partials/repo-safety/{name} - Safety points panel for a repository
+// - GET /partials/repo-status/{name}?compact= - Branch and working tree status of a repository
+// - GET /partials/repo-branches/{name} - Branch dropdown for a repository
+// - GET /partials/repo-merge/{name} - Banner for a merge or rebase stopped by conflicts
+// - GET /partials/repo-commits/{name}?limit=&offset= - Recent commits of a repository
+// - GET /partials/repo-stashes/{name} - Stashes of a repository
+// - GET /partials/repo-diff/{name} - Uncommitted changes of a repository, file by file
+// - GET /partials/repo-tags/{name} - Tags of a repository, newest first
+// - GET /partials/repo-remotes/{name} - Remotes of a repository, origin first
+// - GET /partials/search?q=&more= - Repositories, activities, and settings matching q
+// - GET /partials/clone-progress/{name}?watch= - Progress of a repository's clone
+// - GET /partials/clone-queue - Clones waiting, running, and failed in the clone queue
+// - GET /partials/repo-files/{name}?path= - File tree of a repository directory
+// - GET /partials/repo-file/{name}?path= - Read-only view of a repository file
+// - GET /partials/repo-file-history/{name}?path= - Commits that changed a repository file, across renames
+// - GET /partials/repo-file-blame/{name}?path= - Who last changed each line of a repository file
+// - GET /partials/tasks/{id} - Output of a running or finished background task
+// - POST /repos/move-storage/{name} - Move a repository to another storage location
+// - POST /settings/storage - Add a storage location
+// - POST /settings/storage/delete/{id} - Remove an unused storage location
+// - POST /settings/git-identity - Set the git name and email used for commits
+// - POST /settings/git-identity/accept - Apply the suggested git identity
+// - POST /settings/git-identity/dismiss - Hide the git identity suggestion for good
+// - POST /settings/git-identity/reapply - Set the container's git config back to the saved identity
+// - GET /settings - Every registered setting, searchable by key and category
+// - GET /partials/settings/all?q=&category= - Filtered settings partial
+// - POST /settings/value/{key} - Validate and save one registered setting
+// - POST /templates/create - Snapshot the configuration as a workspace template
+// - POST /templates/import - Save a template downloaded from another instance
+// - GET /templates/download/{id} - Download a workspace template as JSON
+// - POST /templates/preview/{id} - What applying a template would add or change
+// - POST /templates/apply/{id} - Apply a template and show per-item results
+// - POST /templates/delete/{id} - Remove a saved template
+// - GET /partials/repo-sizes - Disk usage of each repository and in total
+// - GET /partials/repo-quota - Banner shown while repositories use their quota and new clones are refused
+// - GET /partials/extensions - Installed and kept VS Code extensions
+// - GET /partials/ports - Port forwards with links to open them
+// - POST /ports - Forward /apps/{prefix}/ to a port in the coder container
+// - POST /ports/{id}/toggle - Pause or resume a port forward
+// - POST /ports/{id}/delete - Remove a port forward
+// - /apps/{prefix}/ - Proxy to a forwarded port, including WebSockets
+// - POST /extensions - Install a VS Code extension (`id`) and keep it installed
+// - POST /extensions/{id}/keep - Reinstall an extension on startup when it's missing
+// - POST /extensions/{id}/forget - Stop reinstalling an extension, leaving it installed
+// - POST /extensions/{id}/uninstall - Uninstall an extension and stop reinstalling it
+// - GET /partials/background-jobs - Background jobs with their schedule and last result
+// - GET /api/jobs - Background jobs with their state and last result as JSON
+// - POST /jobs/run/{name} - Run a background job now
+// - POST /jobs/pause/{name} - Pause or resume a background job's scheduled runs
+// - GET /partials/stats-summary - Activity of the last 7 days, day by day
+// - GET /api/stats/activity?from=&to= - Activity counts per day in the user's timezone as JSON
+// - GET /api/v1/identity?nonce= - Instance ID, public key, and a signature over nonce (no sign-in)
+// - POST /settings/identity/rotate - Replace the instance keypair
+// - GET /api/v1/repos - List repositories as JSON (session or API token)
+// - POST /api/v1/repos - Clone a repository from a JSON body
+// - POST /api/v1/repos/{name}/pull - Pull a repository
+// - DELETE /api/v1/repos/{name}?archive=true - Delete a repository, archiving it to the trash first
+// - GET /api/v1/repos/{name}/files?path= - List a directory of a repository as JSON
+// - GET /api/v1/repos/{name}/file?path= - Raw content of a repository file
+// - GET /api/v1/repos/{name}/history?path=&limit= - Commits that changed a repository file as JSON
+// - GET /api/v1/repos/{name}/blame?path=&start=&end= - Blame of a repository file's lines as JSON
+// - GET /api/openapi.json - The routes registered with handleAPI as an OpenAPI 3 document
+// - GET /api/routes - The routes registered with handleAPI as a JSON list
+// - POST /api/exec - Run a command in the coder container, streaming its output (session only)
+// - GET /api/coder/extensions - Installed and kept VS Code extensions as JSON
+// - POST /api/coder/extensions - Install an extension from {"id"} and keep it installed (session only)
+// - DELETE /api/coder/extensions/{id} - Uninstall an extension and stop reinstalling it (session only)
+// - GET /api/backup?activities=true - Download a tar.gz backup of this instance (session only)
+// - POST /api/restore - Restore a backup archive, reporting each item (session only)
+// - GET /api/activities/export?format=ndjson|csv - Stream the whole activity log (session only)
+// - GET /api/export/activities?format=csv|json&from=&to=&type= - Download the filtered activity log (session only)
+// - GET /api/export/repositories?format=csv|json&from=&to= - Download the repository list (session only)
+// - GET /api/trash - Archives of deleted repositories as JSON (session only)
+// - GET /api/trash/{file} - Download an archive (session only)
+// - POST /api/trash/restore/{file} - Extract an archive into the repos directory and record it again (session only)
+// - POST /settings/api-token - Generate the API token, replacing any previous one
+// - POST /settings/api-token/revoke - Revoke the API token
+// - POST /settings/metrics-token - Generate the bearer token /metrics requires, replacing any previous one
+// - POST /settings/metrics-token/revoke - Remove the metrics token, leaving /metrics open
+// - POST /settings/lockouts/clear - Let a client locked out of signing in (`key`) try again
+// - GET /partials/sessions - Signed-in sessions, the current one marked
+// - POST /auth/sessions/revoke/{id} - Sign out a session; its cookie is refused from the next request
+// - POST /auth/sessions/revoke-others - Sign out every session except the current one
+// - POST /api/share - Create a read-only link to a file, diff, or README from a JSON body (session or API token)
+// - POST /shares/create - Create a share link from the file view or commit list
+// - GET /partials/shares - Share links with their views, expiry, and revoke buttons
+// - POST /shares/revoke/{id} - Revoke a share link
+// - GET /shared/{token} - The shared file, diff, or README, without signing in
+// - GET /help/{kind}?repo= - Troubleshooting steps and live checks for an error kind
+// - POST /diagnostics/coder/restart - Restart the coder container
+// - GET /audit - Access audit page
+// - GET /partials/access-audit - Filterable access records partial
+// - GET /audit/access.csv - Access records CSV export
 // - /coder/* - Proxied VS Code server interface
 func (c *WorkbenchController) Setup(app *application.App) {
 	c.Controller.Setup(app)
@@ -44,24 +242,324 @@ func (c *WorkbenchController) Setup(app *application.App) {
 	auth := app.Use("auth").(*AuthController)
 
 	// Dashboard route
-	http.Handle("/", app.Serve("dashboard.html", auth.Required))
+	http.Handle("/", timed(internal.LatencyDashboard, app.Serve("dashboard.html", auth.Required)))
 
 	// Repository API routes (for dashboard)
 	http.Handle("POST /repos/clone", app.ProtectFunc(c.cloneRepo, auth.Required))
+	http.Handle("POST /repos/create", app.ProtectFunc(c.createRepo, auth.Required))
 	http.Handle("POST /repos/pull/{name}", app.ProtectFunc(c.pullRepo, auth.Required))
 	http.Handle("POST /repos/delete/{name}", app.ProtectFunc(c.deleteRepo, auth.Required))
+	http.Handle("POST /repos/rename/{name}", app.ProtectFunc(c.renameRepo, auth.Required))
+	http.Handle("POST /repos/group/{name}", app.ProtectFunc(c.groupRepo, auth.Required))
+	http.Handle("POST /repos/pin/{name}", app.ProtectFunc(c.pinRepo, auth.Required))
+	http.Handle("POST /repos/lock/{name}", app.ProtectFunc(c.lockRepo, auth.Required))
+	http.Handle("POST /repos/meta/{name}", app.ProtectFunc(c.editRepo, auth.Required))
+	http.Handle("POST /repos/fetch-metadata/{name}", app.ProtectFunc(c.fetchRepoMetadata, auth.Required))
+	http.Handle("POST /repos/rename-group", app.ProtectFunc(c.renameGroup, auth.Required))
+	http.Handle("POST /repos/group-collapse", app.ProtectFunc(c.collapseGroup, auth.Required))
+	http.Handle("POST /repos/reclone/{name}", app.ProtectFunc(c.recloneRepo, auth.Required))
+	http.Handle("POST /repos/clone-retry/{name}", app.ProtectFunc(c.retryClone, auth.Required))
+	http.Handle("POST /repos/clone-dismiss/{name}", app.ProtectFunc(c.dismissClone, auth.Required))
+	http.Handle("POST /repos/import-list", app.ProtectFunc(c.listImports, auth.Required))
+	http.Handle("POST /repos/import", app.ProtectFunc(c.importRepos, auth.Required))
+	http.Handle("POST /repos/reconcile", app.ProtectFunc(c.reconcileRepos, auth.Required))
+
+	http.Handle("POST /repos/export/{name}", app.ProtectFunc(c.exportRepo, auth.Required))
+	http.Handle("POST /repos/checkout/{name}", app.ProtectFunc(c.checkoutBranch, auth.Required))
+
+	// Git LFS
+	http.Handle("POST /repos/lfs-install/{name}", app.ProtectFunc(c.installLFS, auth.Required))
+	http.Handle("POST /repos/lfs-pull/{name}", app.ProtectFunc(c.pullLFS, auth.Required))
+
+	// Inline single-file edits
+	http.Handle("GET /repos/edit/{name}", app.Serve("edit-file.html", auth.Required))
+	http.Handle("POST /repos/edit/{name}", app.ProtectFunc(c.commitEdit, auth.Required))
+
+	// Post-clone setup suggestions
+	http.Handle("POST /repos/setup/{name}/{step}/run", app.ProtectFunc(c.runSetupStep, auth.Required))
+	http.Handle("POST /repos/setup/{name}/{step}/dismiss", app.ProtectFunc(c.dismissSetupStep, auth.Required))
+	http.Handle("POST /repos/setup/{name}/{step}/autorun", app.ProtectFunc(c.toggleSetupHook, auth.Required))
+
+	// Provider pull requests
+	http.Handle("POST /repos/prs/{name}/{number}/fetch", app.ProtectFunc(c.fetchPullRequest, auth.Required))
+	http.Handle("POST /settings/provider-token", app.ProtectFunc(c.saveProviderToken, auth.Required))
+
+	// Safety points and the destructive actions they protect
+	http.Handle("POST /repos/safety/{name}/toggle", app.ProtectFunc(c.toggleSafety, auth.Required))
+	http.Handle("POST /repos/safety/{name}/{id}/restore", app.ProtectFunc(c.restoreSafetyPoint, auth.Required))
+	http.Handle("POST /repos/reset/{name}", app.ProtectFunc(c.resetToUpstream, auth.Required))
+	http.Handle("POST /repos/merge-abort/{name}", app.ProtectFunc(c.abortMerge, auth.Required))
+	http.Handle("POST /repos/merge-ours/{name}", app.ProtectFunc(c.resolveMerge(internal.MergeOurs), auth.Required))
+	http.Handle("POST /repos/merge-theirs/{name}", app.ProtectFunc(c.resolveMerge(internal.MergeTheirs), auth.Required))
+	http.Handle("POST /repos/prune-branches/{name}", app.ProtectFunc(c.cleanupBranches, auth.Required))
+	http.Handle("POST /repos/revert/{name}", app.ProtectFunc(c.applyCommit(internal.RevertCommit), auth.Required))
+	http.Handle("POST /repos/cherry-pick/{name}", app.ProtectFunc(c.applyCommit(internal.CherryPickCommit), auth.Required))
+
+	// Stashes
+	http.Handle("POST /repos/stash/{name}", app.ProtectFunc(c.stashChanges, auth.Required))
+	http.Handle("POST /repos/stash-pop/{name}", app.ProtectFunc(c.popStash, auth.Required))
+	http.Handle("POST /repos/stash-drop/{name}", app.ProtectFunc(c.dropStash, auth.Required))
+
+	// Tags and releases
+	http.Handle("POST /repos/tag/{name}", app.ProtectFunc(c.createTag, auth.Required))
+
+	// Remotes
+	http.Handle("POST /repos/remotes/{name}", app.ProtectFunc(c.editRemote, auth.Required))
+
+	// Merged branch cleanup and garbage collection
+	http.Handle("POST /repos/maintenance/{name}", app.ProtectFunc(c.repoMaintenance, auth.Required))
+
+	// Push webhooks from git providers, verified by per-repository secret
+	http.Handle("POST /repos/webhook/{name}", app.ProtectFunc(c.generateWebhook, auth.Required))
+	http.Handle("POST /hooks/git/{name}", app.ProtectFunc(c.gitWebhook, auth.Optional))
+
+	// App containers built from a repository's Dockerfile or dev container
+	http.Handle("POST /repos/run/{name}", app.ProtectFunc(c.runRepoApp, auth.Required))
+	http.Handle("POST /repos/stop/{name}", app.ProtectFunc(c.stopRepoApp, auth.Required))
+
+	// Storage locations
+	http.Handle("POST /repos/move-storage/{name}", app.ProtectFunc(c.moveStorage, auth.Required))
+	http.Handle("POST /settings/storage", app.ProtectFunc(c.addStorageLocation, auth.Required))
+	http.Handle("POST /settings/storage/delete/{id}", app.ProtectFunc(c.deleteStorageLocation, auth.Required))
+
+	// Git identity
+	http.Handle("POST /settings/git-identity", app.ProtectFunc(c.saveGitIdentity, auth.Required))
+	http.Handle("POST /settings/git-identity/accept", app.ProtectFunc(c.acceptGitIdentity, auth.Required))
+	http.Handle("POST /settings/git-identity/dismiss", app.ProtectFunc(c.dismissGitIdentity, auth.Required))
+	http.Handle("POST /settings/git-identity/reapply", app.ProtectFunc(c.reapplyGitIdentity, auth.Required))
+
+	// Settings registry
+	http.Handle("GET /settings", app.Serve("settings.html", auth.Required))
+	http.Handle("GET /partials/settings/all", app.Serve("settings-partial.html", auth.Required))
+	http.Handle("POST /settings/value/{key}", app.ProtectFunc(c.saveSetting, auth.Required))
+
+	// Workspace templates
+	http.Handle("POST /templates/create", app.ProtectFunc(c.createWorkspaceTemplate, auth.Required))
+	http.Handle("POST /templates/import", app.ProtectFunc(c.importWorkspaceTemplate, auth.Required))
+	http.Handle("GET /templates/download/{id}", app.ProtectFunc(c.downloadWorkspaceTemplate, auth.Required))
+	http.Handle("POST /templates/preview/{id}", app.ProtectFunc(c.previewWorkspaceTemplate, auth.Required))
+	http.Handle("POST /templates/apply/{id}", app.ProtectFunc(c.applyWorkspaceTemplate, auth.Required))
+	http.Handle("POST /templates/delete/{id}", app.ProtectFunc(c.deleteWorkspaceTemplate, auth.Required))
+
+	// Per-host bulk actions
+	http.Handle("POST /hosts/pull/{host}", app.ProtectFunc(c.pullHost, auth.Required))
+	http.Handle("POST /hosts/autopull/{host}", app.ProtectFunc(c.toggleAutoPull, auth.Required))
+	http.Handle("POST /settings/autopull", app.ProtectFunc(c.saveAutoPullInterval, auth.Required))
+	http.Handle("POST /settings/missing-repos", app.ProtectFunc(c.saveMissingRepoBehavior, auth.Required))
+
+	// Access auditing (these routes are never recorded themselves)
+	http.Handle("GET /audit", app.Serve("access-audit.html", auth.Required))
+	http.Handle("GET /partials/access-audit", app.Serve("access-audit-partial.html", auth.Required))
+	http.Handle("GET /audit/access.csv", app.ProtectFunc(c.exportAccessRecords, auth.Required))
+
+	// SSH key verification
+	http.Handle("POST /ssh/check", app.ProtectFunc(c.checkSSHHost, auth.Required))
+	http.Handle("POST /ssh/test/{host}", app.ProtectFunc(c.testSSHHost, auth.Required))
+	http.Handle("POST /ssh/rotate", app.ProtectFunc(c.rotateSSHKey, auth.Required))
+
+	// Named SSH keys mapped to hosts
+	http.Handle("POST /ssh/keys", app.ProtectFunc(c.generateSSHKey, auth.Required))
+	http.Handle("POST /ssh/keys/import", app.ProtectFunc(c.importSSHKey, auth.Required))
+	http.Handle("POST /ssh/keys/{name}/hosts", app.ProtectFunc(c.saveSSHKeyHosts, auth.Required))
+	http.Handle("POST /ssh/keys/{name}/delete", app.ProtectFunc(c.deleteSSHKey, auth.Required))
+	http.Handle("POST /git-credentials", app.ProtectFunc(c.saveGitCredential, auth.Required))
+	http.Handle("POST /git-credentials/{host}/delete", app.ProtectFunc(c.deleteGitCredential, auth.Required))
 
 	// Partial routes for HTMX lazy loading
-	http.Handle("GET /partials/activity", app.Serve("activity-log.html", auth.Required))
+	http.Handle("GET /partials/repos", timed(internal.LatencyDashboard, app.Serve("repo-list.html", auth.Required)))
+	http.Handle("GET /partials/activity", timed(internal.LatencyDashboard, app.Serve("activity-log.html", auth.Required)))
+	http.Handle("GET /partials/activity/{id}", app.Serve("activity-detail.html", auth.Required))
+	http.Handle("POST /activities/prune", app.ProtectFunc(c.pruneActivities, auth.Required))
+	http.Handle("GET /partials/setup/{name}", app.Serve("setup-steps.html", auth.Required))
+	http.Handle("GET /partials/repo-prs/{name}", app.Serve("repo-prs.html", auth.Required))
+	http.Handle("GET /partials/repo-app/{name}", app.Serve("repo-app.html", auth.Required))
+	http.Handle("GET /partials/repo-safety/{name}", app.Serve("repo-safety.html", auth.Required))
+	http.Handle("GET /partials/repo-status/{name}", app.Serve("repo-status.html", auth.Required))
+	http.Handle("GET /partials/repo-branches/{name}", app.Serve("repo-branches.html", auth.Required))
+	http.Handle("GET /partials/repo-merge/{name}", app.Serve("repo-merge.html", auth.Required))
+	http.Handle("GET /partials/repo-commits/{name}", app.Serve("repo-commits.html", auth.Required))
+	http.Handle("GET /partials/repo-stashes/{name}", app.Serve("repo-stashes.html", auth.Required))
+	http.Handle("GET /partials/repo-diff/{name}", app.Serve("repo-diff.html", auth.Required))
+	http.Handle("GET /partials/repo-tags/{name}", app.Serve("repo-tags.html", auth.Required))
+	http.Handle("GET /partials/repo-remotes/{name}", app.Serve("repo-remotes.html", auth.Required))
+	http.Handle("GET /partials/search", app.Serve("search-results.html", auth.Required))
+	http.Handle("GET /partials/clone-progress/{name}", app.ProtectFunc(c.cloneProgress, auth.Required))
+	http.Handle("GET /partials/clone-queue", app.Serve("clone-queue.html", auth.Required))
+	http.Handle("GET /partials/repo-files/{name}", app.Serve("repo-files.html", auth.Required))
+	http.Handle("GET /partials/repo-file/{name}", app.Serve("repo-file.html", auth.Required))
+	http.Handle("GET /partials/repo-file-history/{name}", app.Serve("repo-file-history.html", auth.Required))
+	http.Handle("GET /partials/repo-file-blame/{name}", app.Serve("repo-file-blame.html", auth.Required))
+	http.Handle("GET /partials/tasks/{id}", app.ProtectFunc(c.taskOutput, auth.Required))
 
 	// Tour completion endpoint
 	http.Handle("POST /settings/tour-complete", app.ProtectFunc(c.completeTour, auth.Required))
 
-	// Coder proxy route
-	http.Handle("/coder/", http.StripPrefix("/coder/", app.Protect(services.CoderProxy(), auth.Required)))
+	// Display preferences
+	http.Handle("POST /settings/locale", app.ProtectFunc(c.saveLocale, auth.Required))
+	http.Handle("POST /settings/digest", app.ProtectFunc(c.saveDigest, auth.Required))
+	http.Handle("POST /settings/smtp", app.ProtectFunc(c.saveSMTP, auth.Required))
+	http.Handle("GET /partials/notifications", app.Serve("notifications.html", auth.Required))
+	http.Handle("POST /notifications/read/{id}", app.ProtectFunc(c.readNotification, auth.Required))
+	http.Handle("POST /notifications/read-all", app.ProtectFunc(c.readAllNotifications, auth.Required))
+
+	// Project scaffolding
+	http.Handle("POST /settings/scaffold/branch", app.ProtectFunc(c.saveDefaultBranch, auth.Required))
+	http.Handle("POST /settings/scaffold/files", app.ProtectFunc(c.saveScaffoldFile, auth.Required))
+	http.Handle("POST /settings/scaffold/delete/{id}", app.ProtectFunc(c.deleteScaffoldFile, auth.Required))
+	http.Handle("GET /partials/scaffold-preview", app.Serve("scaffold-preview.html", auth.Required))
+
+	// Starter template repositories
+	http.Handle("POST /repos/from-template", app.ProtectFunc(c.createRepoFromTemplate, auth.Required))
+	http.Handle("GET /partials/repo-template-fields", app.Serve("repo-template-fields.html", auth.Required))
+	http.Handle("POST /settings/repo-templates", app.ProtectFunc(c.addRepoTemplate, auth.Required))
+	http.Handle("POST /settings/repo-templates/refresh/{id}", app.ProtectFunc(c.refreshRepoTemplate, auth.Required))
+	http.Handle("POST /settings/repo-templates/delete/{id}", app.ProtectFunc(c.deleteRepoTemplate, auth.Required))
+
+	// Diagnostics
+	http.Handle("POST /diagnostics/permissions", app.ProtectFunc(c.repairPermissions, auth.Required))
+	http.Handle("POST /diagnostics/coder/restart", app.ProtectFunc(c.restartCoder, auth.Required))
+
+	// Repository disk usage, measured lazily since du can take seconds
+	http.Handle("GET /partials/repo-sizes", app.Serve("repo-sizes.html", auth.Required))
+	http.Handle("GET /partials/repo-quota", app.Serve("repo-quota.html", auth.Required))
+
+	// VS Code extensions kept installed across coder volume changes
+	http.Handle("GET /partials/extensions", app.Serve("coder-extensions.html", auth.Required))
+	http.Handle("POST /extensions", app.ProtectFunc(c.installExtension, auth.Required))
+	http.Handle("POST /extensions/{id}/keep", app.ProtectFunc(c.keepExtension, auth.Required))
+	http.Handle("POST /extensions/{id}/forget", app.ProtectFunc(c.forgetExtension, auth.Required))
+	http.Handle("POST /extensions/{id}/uninstall", app.ProtectFunc(c.uninstallExtension, auth.Required))
+
+	// Background jobs
+	http.Handle("GET /partials/background-jobs", app.Serve("background-jobs.html", auth.Required))
+	http.Handle("GET /api/jobs", app.ProtectFunc(apiLimit(auth.apiKey, internal.APIRead, c.listJobs), auth.Required))
+	http.Handle("POST /jobs/run/{name}", app.ProtectFunc(c.runJob, auth.Required))
+	http.Handle("POST /jobs/pause/{name}", app.ProtectFunc(c.pauseJob, auth.Required))
+
+	// Usage statistics
+	http.Handle("GET /partials/stats-summary", app.Serve("stats-summary.html", auth.Required))
+	http.Handle("GET /api/stats/activity", app.ProtectFunc(apiLimit(auth.apiKey, internal.APIRead, c.activityStats), auth.Required))
+
+	// Instance identity for automation to challenge before sending secrets
+	http.Handle("GET /api/v1/identity", app.ProtectFunc(apiLimit(remoteKey, internal.APIRead, c.instanceIdentity), auth.Optional))
+	http.Handle("POST /settings/identity/rotate", app.ProtectFunc(c.rotateIdentity, auth.Required))
+
+	// Repository JSON API for scripts, authenticated by session or API token
+	handleAPI("GET /api/v1/repos", app.ProtectFunc(apiLimit(auth.apiKey, internal.APIRead, c.listReposAPI), auth.RequiredOrToken))
+	handleAPI("POST /api/v1/repos", app.ProtectFunc(apiLimit(auth.apiKey, internal.APIWrite, c.cloneRepoAPI), auth.RequiredOrToken))
+	handleAPI("POST /api/v1/repos/{name}/pull", app.ProtectFunc(apiLimit(auth.apiKey, internal.APIWrite, c.pullRepoAPI), auth.RequiredOrToken))
+	handleAPI("DELETE /api/v1/repos/{name}", app.ProtectFunc(apiLimit(auth.apiKey, internal.APIWrite, c.deleteRepoAPI), auth.RequiredOrToken))
+	handleAPI("GET /api/v1/repos/{name}/files", app.ProtectFunc(apiLimit(auth.apiKey, internal.APIRead, c.listRepoFilesAPI), auth.RequiredOrToken))
+	handleAPI("GET /api/v1/repos/{name}/file", app.ProtectFunc(apiLimit(auth.apiKey, internal.APIRead, c.readRepoFileAPI), auth.RequiredOrToken))
+	handleAPI("GET /api/v1/repos/{name}/history", app.ProtectFunc(apiLimit(auth.apiKey, internal.APIRead, c.fileHistoryAPI), auth.RequiredOrToken))
+	handleAPI("GET /api/v1/repos/{name}/blame", app.ProtectFunc(apiLimit(auth.apiKey, internal.APIRead, c.fileBlameAPI), auth.RequiredOrToken))
+
+	// Machine-readable descriptions of the routes registered with handleAPI
+	handleAPI("GET /api/openapi.json", app.ProtectFunc(apiLimit(auth.apiKey, internal.APIRead, c.openAPISpec), auth.RequiredOrToken))
+	handleAPI("GET /api/routes", app.ProtectFunc(apiLimit(auth.apiKey, internal.APIRead, c.listRoutesAPI), auth.RequiredOrToken))
+	http.Handle("POST /settings/api-token", app.ProtectFunc(c.generateAPIToken, auth.Required))
+
+	// One-off commands in the coder container. Session only: the API token
+	// can't run arbitrary commands.
+	http.Handle("POST /api/exec", app.ProtectFunc(apiLimit(auth.apiKey, internal.APIWrite, c.execCommand), auth.Required))
+	http.Handle("GET /api/terminal", app.ProtectFunc(c.terminal, auth.Required))
 
-	// Ensure SSH key exists
-	c.verifySSHKeys()
+	// Extensions run code in VS Code, so only a session may change them
+	http.Handle("GET /api/coder/extensions", app.ProtectFunc(apiLimit(auth.apiKey, internal.APIRead, c.listExtensionsAPI), auth.RequiredOrToken))
+	http.Handle("POST /api/coder/extensions", app.ProtectFunc(apiLimit(auth.apiKey, internal.APIWrite, c.installExtensionAPI), auth.Required))
+	http.Handle("DELETE /api/coder/extensions/{id}", app.ProtectFunc(apiLimit(auth.apiKey, internal.APIWrite, c.uninstallExtensionAPI), auth.Required))
+
+	// Backups hold SSH keys and tokens, so they're session only too. The
+	// archive can be far larger than api_max_body, so restore caps it itself.
+	http.Handle("GET /api/backup", app.ProtectFunc(c.downloadBackup, auth.Required))
+	http.Handle("POST /api/restore", app.ProtectFunc(c.restoreBackup, auth.Required))
+	http.Handle("GET /api/activities/export", app.ProtectFunc(c.exportActivities, auth.Required))
+	http.Handle("GET /api/export/activities", app.ProtectFunc(c.exportActivities, auth.Required))
+	http.Handle("GET /api/export/repositories", app.ProtectFunc(c.exportRepositories, auth.Required))
+
+	// Archives hold whole working trees, so they're session only too
+	http.Handle("GET /api/trash", app.ProtectFunc(c.listTrash, auth.Required))
+	http.Handle("GET /api/trash/{file}", app.ProtectFunc(c.downloadTrash, auth.Required))
+	http.Handle("POST /api/trash/restore/{file}", app.ProtectFunc(c.restoreTrash, auth.Required))
+	http.Handle("POST /settings/api-token/revoke", app.ProtectFunc(c.revokeAPIToken, auth.Required))
+	http.Handle("POST /settings/metrics-token", app.ProtectFunc(c.generateMetricsToken, auth.Required))
+	http.Handle("POST /settings/metrics-token/revoke", app.ProtectFunc(c.revokeMetricsToken, auth.Required))
+	http.Handle("POST /settings/lockouts/clear", app.ProtectFunc(c.clearLockout, auth.Required))
+	http.Handle("GET /partials/sessions", app.Serve("sessions.html", auth.Required))
+	http.Handle("POST /auth/sessions/revoke/{id}", app.ProtectFunc(c.revokeSession, auth.Required))
+	http.Handle("POST /auth/sessions/revoke-others", app.ProtectFunc(c.revokeOtherSessions, auth.Required))
+
+	// Read-only share links, opened without signing in
+	handleAPI("POST /api/share", app.ProtectFunc(apiLimit(auth.apiKey, internal.APIWrite, c.createShareAPI), auth.RequiredOrToken))
+	http.Handle("POST /shares/create", app.ProtectFunc(c.createShare, auth.Required))
+	http.Handle("GET /partials/shares", app.Serve("shares.html", auth.Required))
+	http.Handle("POST /shares/revoke/{id}", app.ProtectFunc(c.revokeShare, auth.Required))
+	http.Handle("GET /shared/{token}", app.ProtectFunc(apiLimit(remoteKey, internal.APIRead, c.openShare), auth.Optional))
+
+	// Troubleshooting for classified errors
+	http.Handle("GET /help/{kind}", app.ProtectFunc(c.errorHelp, auth.Required))
+
+	// Port forwards to dev servers inside the coder container
+	http.Handle("GET /partials/ports", app.Serve("port-forwards.html", auth.Required))
+	http.Handle("POST /ports", app.ProtectFunc(c.addPortForward, auth.Required))
+	http.Handle("POST /ports/{id}/toggle", app.ProtectFunc(c.togglePortForward, auth.Required))
+	http.Handle("POST /ports/{id}/delete", app.ProtectFunc(c.deletePortForward, auth.Required))
+	http.Handle("/apps/", app.ProtectFunc(c.serveForward, auth.Required))
+
+	// Start the coder container in the background, so a slow Docker
+	// daemon doesn't keep the dashboard from coming up. A new container
+	// runs the coder_image_tag version.
+	services.SetCoderImageTag(internal.CoderImageTag)
+	services.EnsureCoder()
+
+	// Coder proxy route, buffer size from the coder_proxy_buffer setting
+	coder := services.CoderProxy(internal.ProxyBufferSize())
+	http.Handle("/coder/", timed(internal.LatencyCoder, http.StripPrefix("/coder/", app.Protect(c.resumable(coder), auth.Required))))
+
+	// Generate the instance keypair on first boot
+	if err := internal.EnsureInstanceIdentity(); err != nil {
+		slog.Error("failed to set up instance identity", "error", err)
+	}
+
+	// Record hosts for repositories cloned before hosts were tracked
+	internal.BackfillRepositoryHosts()
+
+	// Clones don't survive a restart; mark ones left running as failed
+	internal.FailInterruptedClones()
+
+	// Startup work that runs commands in the coder container waits for it
+	go func() {
+		<-services.CoderReady()
+
+		// Ensure mounted directories are usable by the coder user, then that an SSH key exists
+		c.verifyCoderPermissions()
+		c.verifySSHKeys()
+
+		// Adopt repositories cloned from the terminal and flag ones whose
+		// directory is gone, e.g. after the container got a new volume
+		go func() {
+			if _, err := internal.ReconcileRepositories(); err != nil {
+				slog.Error("failed to reconcile repositories", "error", err)
+			}
+		}()
+
+		// Reinstall kept VS Code extensions lost with the coder volume
+		go func() {
+			if _, _, err := internal.ReconcileExtensions(); err != nil {
+				slog.Error("failed to reconcile VS Code extensions", "error", err)
+			}
+		}()
+	}()
+
+	// Run background jobs: notifications and digests, auto-pull, and
+	// access record and activity retention
+	go internal.StartScheduler()
+
+	// Watch the data volume for remounts and enter protective mode
+	go internal.StartVolumeWatchdog()
+
+	// Restart the coder container when code-server stops answering
+	go internal.StartCoderHealthCheck()
 }
 
 // Handle prepares the controller for request-specific operations.
@@ -73,21 +571,35 @@ func (c WorkbenchController) Handle(req *http.Request) application.Handler {
 	return &c
 }
 
+// verifyCoderPermissions repairs ownership and modes of the directories
+// mounted into the coder container. Unfixable paths are logged and
+// recorded as failure activities.
+func (c *WorkbenchController) verifyCoderPermissions() {
+	issues, err := internal.RepairCoderPermissions()
+	if err != nil {
+		slog.Error("failed to check coder permissions", "error", err)
+		return
+	}
+	for _, issue := range issues {
+		slog.Warn("coder permission problem", "path", issue.Path, "problem", issue.Problem)
+	}
+}
+
 // verifySSHKeys ensures SSH keys exist for Git operations.
 // Called during setup to generate keys if they don't exist.
 // Keys are stored in the container's ~/.ssh directory and persist
 // across restarts via the data volume mount.
 func (c *WorkbenchController) verifySSHKeys() {
 	if internal.HasSSHKey() {
-		log.Println("SSH key already exists")
+		slog.Info("SSH key already exists")
 		return
 	}
 
 	auth := c.Use("auth").(*AuthController)
 	if err := internal.GenerateSSHKeyForUser(auth.CurrentUser()); err != nil {
-		log.Printf("Failed to generate SSH key: %v", err)
+		slog.Error("failed to generate SSH key", "error", err)
 	} else {
-		log.Println("SSH key generated successfully")
+		slog.Info("SSH key generated")
 	}
 }
 
@@ -96,35 +608,176 @@ func (c *WorkbenchController) verifySSHKeys() {
 // ============================================================================
 
 // cloneRepo handles POST /repos/clone to clone a Git repository.
-// Accepts URL (required) and name (optional, auto-detected from URL).
-// Validates the Coder service is running, clones via Git in the container,
-// saves repository metadata to database, and logs the activity.
-// Returns error messages for duplicate names or clone failures.
+// Accepts URL (required), name (optional, auto-detected from URL),
+// storage (optional storage location ID), ssh_key (optional named key
+// to map the URL's host to before cloning), and username and token
+// (optional access token to save for an HTTPS URL's host).
+// Validates the Coder service is running, saves the repository as
+// cloning, and starts the clone in the background; the dashboard row
+// shows its progress. Returns error messages for duplicate names or
+// clones that can't start.
 func (c *WorkbenchController) cloneRepo(w http.ResponseWriter, r *http.Request) {
-	url := r.FormValue("url")
-	name := r.FormValue("name")
+	_, err := internal.Clone(requestContext(w, r), internal.CloneOptions{
+		URL:     r.FormValue("url"),
+		Name:    r.FormValue("name"),
+		Storage: r.FormValue("storage"),
+		SSHKey:  r.FormValue("ssh_key"),
+
+		Username: r.FormValue("username"),
+		Token:    r.FormValue("token"),
+	})
+	if err != nil {
+		c.renderError(w, r, err)
+		return
+	}
+
+	// Refresh the page
+	c.Refresh(w, r)
+}
+
+// retryClone handles POST /repos/clone-retry/{name} to start a failed
+// clone again.
+func (c *WorkbenchController) retryClone(w http.ResponseWriter, r *http.Request) {
+	if err := internal.RetryClone(r.PathValue("name")); err != nil {
+		c.renderError(w, r, err)
+		return
+	}
+
+	w.Header().Set("HX-Trigger", "reposChanged")
+	c.Render(w, r, "error-message.html", nil)
+}
+
+// dismissClone handles POST /repos/clone-dismiss/{name} from the clone
+// queue panel.
+func (c *WorkbenchController) dismissClone(w http.ResponseWriter, r *http.Request) {
+	if err := internal.DismissQueuedClone(r.PathValue("name")); err != nil {
+		c.renderError(w, r, err)
+		return
+	}
+	c.Render(w, r, "clone-queue.html", nil)
+}
+
+// listImports handles POST /repos/import-list from the import dialog.
+// A pasted newline-separated urls list is used when given; otherwise the
+// repositories of the provider account are listed, with the token (or the
+// stored one) and saving it only when save_token is ticked. Renders the
+// repositories as a selection, with ones already cloned left unticked.
+func (c *WorkbenchController) listImports(w http.ResponseWriter, r *http.Request) {
+	var candidates []*internal.ImportCandidate
+	var err error
+	if strings.TrimSpace(r.FormValue("urls")) != "" {
+		candidates, err = internal.ParseImportURLs(r.FormValue("urls"))
+	} else {
+		candidates, err = internal.ListAccountImports(internal.AccountImport{
+			Provider:  r.FormValue("provider"),
+			Account:   r.FormValue("account"),
+			Token:     r.FormValue("token"),
+			SaveToken: r.FormValue("save_token") == "on",
+			SSH:       r.FormValue("url_type") == "ssh",
+		})
+	}
+	if err != nil {
+		c.renderError(w, r, err)
+		return
+	}
+	c.Render(w, r, "import-select.html", candidates)
+}
+
+// importRepos handles POST /repos/import with the selected url values,
+// queueing a clone of each and rendering how each went.
+func (c *WorkbenchController) importRepos(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		c.renderError(w, r, err)
+		return
+	}
+	results, err := internal.QueueImports(r.Form["url"])
+	if err != nil {
+		c.renderError(w, r, err)
+		return
+	}
+
+	w.Header().Set("HX-Trigger", "reposChanged")
+	c.Render(w, r, "import-result.html", results)
+}
 
-	if url == "" {
-		c.Render(w, r, "error-message.html", "Repository URL is required")
+// cloneProgress handles GET /partials/clone-progress/{name}, which a
+// cloning row polls with watch=true. The repository list reloads once a
+// watched clone has finished, so the row shows it ready or failed. A
+// queued clone that failed leaves no record; the clone queue shows why.
+func (c *WorkbenchController) cloneProgress(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	repo, err := models.Repositories.Find("WHERE Name = ?", name)
+	if err != nil {
+		if r.URL.Query().Get("watch") == "true" {
+			w.Header().Set("HX-Trigger", "reposChanged")
+		}
+		c.Render(w, r, "error-message.html", "Repository not found - it may have been removed")
 		return
 	}
 
-	// Make sure coder is running
+	if r.URL.Query().Get("watch") == "true" && !repo.IsCloning() {
+		w.Header().Set("HX-Trigger", "reposChanged")
+	}
+	c.Render(w, r, "clone-progress.html", map[string]any{
+		"Name":     repo.Name,
+		"Cloning":  repo.IsCloning(),
+		"Failed":   repo.IsCloneFailed(),
+		"Error":    repo.CloneError,
+		"Progress": internal.GetCloneProgress(repo.Name),
+	})
+}
+
+// createRepo handles POST /repos/create to start a new project from a
+// template. Accepts name and template (defaults to "empty").
+func (c *WorkbenchController) createRepo(w http.ResponseWriter, r *http.Request) {
+	template := r.FormValue("template")
+	if template == "" {
+		template = "empty"
+	}
+
 	if !services.Coder.IsRunning() {
-		c.Render(w, r, "error-message.html", "Coder service is not running")
+		c.renderError(w, r, internal.ErrCoderNotRunning)
 		return
 	}
 
-	// Use internal package for business logic
-	if err := internal.CloneRepository(url, name); err != nil {
-		c.Render(w, r, "error-message.html", err)
+	if err := internal.CreateRepositoryFromTemplate(r.FormValue("name"), template); err != nil {
+		c.renderError(w, r, err)
 		return
 	}
 
-	// Refresh the page
 	c.Refresh(w, r)
 }
 
+// createRepoFromTemplate handles POST /repos/from-template to start a new
+// project from a starter template repository. Accepts template, name, and
+// var_<placeholder> for each placeholder the template declares. Reports
+// the placeholders left unfilled, if any.
+func (c *WorkbenchController) createRepoFromTemplate(w http.ResponseWriter, r *http.Request) {
+	if !services.Coder.IsRunning() {
+		c.renderError(w, r, internal.ErrCoderNotRunning)
+		return
+	}
+
+	r.ParseForm()
+	variables := map[string]string{}
+	for key, values := range r.PostForm {
+		if name, ok := strings.CutPrefix(key, "var_"); ok && len(values) > 0 {
+			variables[name] = strings.TrimSpace(values[0])
+		}
+	}
+
+	result, err := internal.CreateFromTemplate(r.FormValue("template"), r.FormValue("name"), variables)
+	if err != nil {
+		c.renderError(w, r, err)
+		return
+	}
+	if len(result.Unfilled) == 0 && len(result.Skipped) == 0 {
+		c.Refresh(w, r)
+		return
+	}
+	c.Render(w, r, "repo-template-result.html", result)
+}
+
 // pullRepo handles POST /repos/pull/{name} to update a repository.
 // Executes git pull in the repository directory within the Coder container.
 // Updates the last pulled timestamp in the database and logs the activity.
@@ -132,121 +785,3323 @@ func (c *WorkbenchController) cloneRepo(w http.ResponseWriter, r *http.Request)
 func (c *WorkbenchController) pullRepo(w http.ResponseWriter, r *http.Request) {
 	name := r.PathValue("name")
 
-	if err := internal.PullRepository(name); err != nil {
-		c.Render(w, r, "error-message.html", err)
+	if err := internal.PullRepository(r.Context(), name); err != nil {
+		var missing *internal.MissingRepoError
+		if errors.As(err, &missing) && missing.Prompt {
+			c.Render(w, r, "repo-missing.html", missing)
+			return
+		}
+		c.renderError(w, r, err)
+		return
+	}
+
+	c.Refresh(w, r)
+}
+
+// recloneRepo handles POST /repos/reclone/{name} to re-clone a repository
+// whose directory is missing.
+func (c *WorkbenchController) recloneRepo(w http.ResponseWriter, r *http.Request) {
+	if err := internal.RecloneRepository(r.PathValue("name")); err != nil {
+		c.renderError(w, r, err)
 		return
 	}
 
 	c.Refresh(w, r)
 }
 
-// deleteRepo handles POST /repos/delete/{name} to remove a repository.
-// Deletes both the repository directory from the filesystem and its
-// database record. This action is permanent and cannot be undone.
-// Logs the deletion activity for audit purposes.
+// reconcileRepos handles POST /repos/reconcile, showing what was adopted,
+// flagged missing, or left alone. The repository list reloads when a
+// record changed.
+func (c *WorkbenchController) reconcileRepos(w http.ResponseWriter, r *http.Request) {
+	result, err := internal.ReconcileRepositories()
+	if err != nil {
+		c.renderError(w, r, err)
+		return
+	}
+	if result.Changed() {
+		w.Header().Set("HX-Trigger", "reposChanged")
+	}
+	c.Render(w, r, "reconcile-result.html", result)
+}
+
+// deleteRepo handles POST /repos/delete/{name} to remove a repository, in
+// two steps. Without a token it renders what would be lost, uncommitted
+// changes and unpushed commits, with a token that confirms the deletion
+// for two minutes. Posting again with that token deletes both the
+// repository directory and its database record. With archive=true the
+// working tree is archived to the trash first; otherwise this cannot be
+// undone. Logs the deletion activity for audit purposes.
 func (c *WorkbenchController) deleteRepo(w http.ResponseWriter, r *http.Request) {
 	name := r.PathValue("name")
 
-	if err := internal.DeleteRepository(name); err != nil {
-		c.Render(w, r, "error-message.html", err)
+	token := r.FormValue("token")
+	if token == "" {
+		summary, err := internal.PrepareDeletion(name)
+		if err != nil {
+			c.renderError(w, r, err)
+			return
+		}
+		c.Render(w, r, "delete-confirm.html", summary)
+		return
+	}
+
+	if err := internal.ConfirmDeletion(name, token); err != nil {
+		c.renderError(w, r, err)
+		return
+	}
+	if err := internal.DeleteRepository(r.Context(), name, r.FormValue("archive") == "true"); err != nil {
+		c.renderError(w, r, err)
 		return
 	}
 
 	c.Refresh(w, r)
 }
 
-// completeTour handles POST /settings/tour-complete to save tour preference.
-// Stores a setting indicating the user has completed or skipped the tour.
-// This prevents the tour from showing on subsequent visits.
-func (c *WorkbenchController) completeTour(w http.ResponseWriter, r *http.Request) {
-	// Save the tour completion setting
-	if _, err := models.SetSetting("tour_completed", "true", "user_preference"); err != nil {
-		log.Printf("Failed to save tour preference: %v", err)
+// renameRepo handles POST /repos/rename/{name} to rename a repository.
+// Accepts new_name. Moves the directory to match; local branches and
+// uncommitted work are kept.
+func (c *WorkbenchController) renameRepo(w http.ResponseWriter, r *http.Request) {
+	if err := internal.RenameRepository(r.PathValue("name"), r.FormValue("new_name")); err != nil {
+		c.renderError(w, r, err)
+		return
 	}
 
-	// Check if user wants to never show again
-	if r.FormValue("dont_show") == "true" {
-		if _, err := models.SetSetting("tour_never_show", "true", "user_preference"); err != nil {
-			log.Printf("Failed to save tour never show preference: %v", err)
-		}
+	c.Refresh(w, r)
+}
+
+// groupRepo handles POST /repos/group/{name}. Accepts group; empty takes
+// the repository out of its group.
+func (c *WorkbenchController) groupRepo(w http.ResponseWriter, r *http.Request) {
+	if err := internal.SetRepositoryGroup(r.PathValue("name"), r.FormValue("group")); err != nil {
+		c.renderError(w, r, err)
+		return
 	}
 
-	go models.Activities.Insert(&models.Activity{
-		Type:        "tour_complete",
-		Repository:  "",
-		Description: "Tour completed",
-		Author:      "",
-		Timestamp:   time.Now(),
-	})
+	w.Header().Set("HX-Trigger", "reposChanged")
+	c.Render(w, r, "error-message.html", nil)
+}
 
-	// Return empty response for HTMX
-	w.WriteHeader(http.StatusOK)
+// pinRepo handles POST /repos/pin/{name}. Accepts pinned ("true" to pin).
+func (c *WorkbenchController) pinRepo(w http.ResponseWriter, r *http.Request) {
+	if err := internal.SetRepositoryPinned(r.PathValue("name"), r.FormValue("pinned") == "true"); err != nil {
+		c.renderError(w, r, err)
+		return
+	}
+
+	w.Header().Set("HX-Trigger", "reposChanged")
+	c.Render(w, r, "error-message.html", nil)
 }
 
-// ============================================================================
-// Template Helper Methods - Accessible in views as {{workbench.MethodName}}
-// ============================================================================
+// lockRepo handles POST /repos/lock/{name}. Accepts locked ("true" to
+// lock). Locked repositories refuse deletion, renaming, and destructive
+// git actions.
+func (c *WorkbenchController) lockRepo(w http.ResponseWriter, r *http.Request) {
+	if err := internal.SetRepositoryLocked(r.PathValue("name"), r.FormValue("locked") == "true"); err != nil {
+		c.renderError(w, r, err)
+		return
+	}
+
+	w.Header().Set("HX-Trigger", "reposChanged")
+	c.Render(w, r, "error-message.html", nil)
+}
+
+// editRepo handles POST /repos/meta/{name}. Accepts description, where
+// an empty one clears it; fields not sent are left as they are.
+func (c *WorkbenchController) editRepo(w http.ResponseWriter, r *http.Request) {
+	var edit internal.RepoEdit
+	if err := r.ParseForm(); err != nil {
+		c.renderError(w, r, err)
+		return
+	}
+	if _, ok := r.PostForm["description"]; ok {
+		description := r.PostForm.Get("description")
+		edit.Description = &description
+	}
+	if err := internal.EditRepository(r.PathValue("name"), edit); err != nil {
+		c.renderError(w, r, err)
+		return
+	}
+
+	w.Header().Set("HX-Trigger", "reposChanged")
+	c.Render(w, r, "error-message.html", nil)
+}
+
+// fetchRepoMetadata handles POST /repos/fetch-metadata/{name}, reading
+// the repository's metadata from its provider again even if it was read
+// today. A provider that can't be reached leaves the card as it was.
+func (c *WorkbenchController) fetchRepoMetadata(w http.ResponseWriter, r *http.Request) {
+	if err := internal.FetchRepositoryMetadata(r.PathValue("name"), true); err != nil {
+		c.renderError(w, r, err)
+		return
+	}
+
+	w.Header().Set("HX-Trigger", "reposChanged")
+	c.Render(w, r, "error-message.html", nil)
+}
+
+// renameGroup handles POST /repos/rename-group. Accepts group and
+// new_group; every repository in the group moves to the new name.
+func (c *WorkbenchController) renameGroup(w http.ResponseWriter, r *http.Request) {
+	if err := internal.RenameRepositoryGroup(r.FormValue("group"), r.FormValue("new_group")); err != nil {
+		c.renderError(w, r, err)
+		return
+	}
+
+	w.Header().Set("HX-Trigger", "reposChanged")
+	c.Render(w, r, "error-message.html", nil)
+}
+
+// collapseGroup handles POST /repos/group-collapse, sent when a group's
+// section is folded or unfolded. Accepts group and collapsed.
+func (c *WorkbenchController) collapseGroup(w http.ResponseWriter, r *http.Request) {
+	if err := internal.SetRepoGroupCollapsed(r.FormValue("group"), r.FormValue("collapsed") == "true"); err != nil {
+		c.renderError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
 
-// GetRecentActivity returns the 20 most recent activity log entries.
-// Used in templates to display user actions and system events.
-// Ordered by creation time descending (newest first).
-// Template usage: {{range workbench.GetRecentActivity}}...{{end}}
-func (c *WorkbenchController) GetRecentActivity() []*models.Activity {
-	activities, err := models.Activities.Search("ORDER BY CreatedAt DESC LIMIT 20")
+// pullHost handles POST /hosts/pull/{host} to pull every repository
+// cloned from a host in the background. Renders the task's progress;
+// failures are listed but don't stop the others.
+func (c *WorkbenchController) pullHost(w http.ResponseWriter, r *http.Request) {
+	task, err := internal.StartHostPull(r.PathValue("host"))
 	if err != nil {
-		log.Printf("Failed to fetch activities: %v", err)
+		c.renderError(w, r, err)
+		return
 	}
-	return activities
+
+	c.Render(w, r, "task-output.html", task.Status())
 }
 
-// GetRepositories returns all cloned repositories alphabetically sorted.
-// Used in dashboard to display repository list with actions.
-// Template usage: {{range workbench.GetRepositories}}...{{end}}
-func (c *WorkbenchController) GetRepositories() []*models.Repository {
-	repos, _ := models.Repositories.Search("ORDER BY Name ASC")
-	return repos
+// toggleAutoPull handles POST /hosts/autopull/{host} to pause or resume
+// automatic pulls for a host. Accepts paused ("true" to pause).
+func (c *WorkbenchController) toggleAutoPull(w http.ResponseWriter, r *http.Request) {
+	if err := internal.SetAutoPullPaused(r.PathValue("host"), r.FormValue("paused") == "true"); err != nil {
+		c.renderError(w, r, err)
+		return
+	}
+
+	c.Refresh(w, r)
 }
 
-// HasRepositories returns true if at least one repository is cloned.
-// Used for conditional rendering in templates to show empty state or list.
-// Template usage: {{if workbench.HasRepositories}}...{{else}}...{{end}}
-func (c *WorkbenchController) HasRepositories() bool {
-	count := models.Repositories.Count("")
-	return count > 0
+// instanceIdentity handles GET /api/v1/identity. Returns the instance ID,
+// public key, fingerprint, and version as JSON, plus an ed25519 signature
+// over internal.IdentityMessage when a nonce query parameter is given.
+func (c *WorkbenchController) instanceIdentity(w http.ResponseWriter, r *http.Request) {
+	identity, err := internal.GetInstanceIdentity(r.URL.Query().Get("nonce"))
+	if errors.Is(err, internal.ErrInvalidNonce) {
+		writeAPIError(w, http.StatusBadRequest, "invalid_nonce", err.Error())
+		return
+	}
+	if err != nil {
+		slog.Error("failed to load instance identity", "error", err)
+		writeAPIError(w, http.StatusInternalServerError, "identity_unavailable", "instance identity is unavailable")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(identity)
 }
 
-// IsCoderRunning returns true if the VS Code server container is active.
-// Used to conditionally enable/disable IDE features in the UI.
-// Template usage: {{if workbench.IsCoderRunning}}...{{end}}
-func (c *WorkbenchController) IsCoderRunning() bool {
-	return services.Coder.IsRunning()
+// listReposAPI handles GET /api/v1/repos, returning every repository with
+// its URL, branch, size, and working tree status.
+func (c *WorkbenchController) listReposAPI(w http.ResponseWriter, r *http.Request) {
+	repos, err := internal.ListRepositorySummaries()
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "list_failed", internal.PresentError(err).Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, repos)
 }
 
-// GetPublicKey returns the SSH public key for repository authentication.
-// Used in clone modal to allow users to copy key for Git server setup.
-// Returns empty string if key doesn't exist or can't be read.
-// Template usage: {{workbench.GetPublicKey}}
-func (c *WorkbenchController) GetPublicKey() string {
-	key, err := internal.GetPublicKey()
+// cloneRepoAPI handles POST /api/v1/repos. Accepts a JSON body with url
+// (required), name, storage, and ssh_key, as the clone form does, and
+// returns the new repository with 201.
+func (c *WorkbenchController) cloneRepoAPI(w http.ResponseWriter, r *http.Request) {
+	var opts internal.CloneOptions
+	if !decodeJSON(w, r, &opts) {
+		return
+	}
+	opts.Wait = true
+	name, err := internal.Clone(requestContext(w, r), opts)
 	if err != nil {
-		return ""
+		writeRepoAPIError(w, err)
+		return
 	}
-	return key
+	c.writeRepoSummary(w, http.StatusCreated, name)
 }
 
-// FormatActivityTime converts UTC timestamps to user's local timezone.
-// Detects timezone from request headers or defaults to UTC.
-// Returns human-readable format like "Jan 2, 3:04 PM".
-// Template usage: {{workbench.FormatActivityTime .CreatedAt}}
-func (c *WorkbenchController) FormatActivityTime(t time.Time) string {
-	tzHeader := c.Header.Get("X-User-Timezone")
-	loc, err := time.LoadLocation(tzHeader)
+// pullRepoAPI handles POST /api/v1/repos/{name}/pull, returning the
+// repository after the pull.
+func (c *WorkbenchController) pullRepoAPI(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if err := internal.PullRepository(r.Context(), name); err != nil {
+		writeRepoAPIError(w, err)
+		return
+	}
+	c.writeRepoSummary(w, http.StatusOK, name)
+}
+
+// deleteRepoAPI handles DELETE /api/v1/repos/{name}?archive=true,
+// answering 204.
+func (c *WorkbenchController) deleteRepoAPI(w http.ResponseWriter, r *http.Request) {
+	if err := internal.DeleteRepository(r.Context(), r.PathValue("name"), r.URL.Query().Get("archive") == "true"); err != nil {
+		writeRepoAPIError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// execCommand handles POST /api/exec, running {command, workdir,
+// timeout_seconds} in the coder container. Output streams back as chunked
+// plain text while the command runs, followed by a line with the exit
+// code, which is also sent as the X-Exit-Code trailer.
+func (c *WorkbenchController) execCommand(w http.ResponseWriter, r *http.Request) {
+	if !requireJSON(w, r) {
+		return
+	}
+	var body struct {
+		Command        string `json:"command"`
+		Workdir        string `json:"workdir"`
+		TimeoutSeconds int    `json:"timeout_seconds"`
+	}
+	if !decodeJSON(w, r, &body) {
+		return
+	}
+
+	out := &streamWriter{w: w}
+	w.Header().Set("Trailer", "X-Exit-Code")
+	outcome, err := internal.RunExec(r.Context(), internal.ExecRequest{
+		Command: body.Command,
+		Workdir: body.Workdir,
+		Timeout: time.Duration(body.TimeoutSeconds) * time.Second,
+	}, out)
+	if err != nil {
+		// Refused before anything ran, so nothing has been written
+		w.Header().Del("Trailer")
+		if errors.Is(err, internal.ErrCoderNotRunning) {
+			writeAPIError(w, http.StatusServiceUnavailable, "coder_not_running", internal.PresentError(err).Error())
+			return
+		}
+		writeAPIError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+
+	switch {
+	case outcome.TimedOut:
+		fmt.Fprintf(out, "\n[killed: timed out after %s]\n", outcome.Duration.Round(time.Second))
+	default:
+		fmt.Fprintf(out, "\n[exit code %d]\n", outcome.ExitCode)
+	}
+	w.Header().Set("X-Exit-Code", strconv.Itoa(outcome.ExitCode))
+}
+
+// terminalPingInterval is how often an open terminal's socket is pinged.
+// A browser that answers nothing for two intervals is taken to be gone.
+const terminalPingInterval = 30 * time.Second
+
+// terminalControl is a text message on the terminal socket.
+type terminalControl struct {
+	Type string `json:"type"` // "resize"
+	Cols int    `json:"cols"`
+	Rows int    `json:"rows"`
+}
+
+// terminal handles GET /api/terminal?cols=&rows=, a WebSocket relaying a
+// login shell in the coder container. Binary messages are typed into the
+// shell and its output comes back as binary messages; text messages are
+// control frames, {"type":"resize","cols":120,"rows":40}. The socket
+// closes with the reason when the shell exits, idles out, or its session
+// signs out, and the shell ends when the socket does.
+func (c *WorkbenchController) terminal(w http.ResponseWriter, r *http.Request) {
+	ws, err := acceptWebSocket(w, r)
 	if err != nil {
-		log.Printf("Invalid timezone %s: %v", tzHeader, err)
-		loc = time.UTC
+		return
+	}
+	cols, rows := 80, 24
+	if n, err := strconv.Atoi(r.URL.Query().Get("cols")); err == nil {
+		cols = n
 	}
+	if n, err := strconv.Atoi(r.URL.Query().Get("rows")); err == nil {
+		rows = n
+	}
+	term, err := internal.OpenTerminal(currentSessionID(r), cols, rows)
+	if errors.Is(err, internal.ErrTerminalLimit) {
+		ws.Close(wsTryAgainLater, err.Error())
+		return
+	}
+	if err != nil {
+		ws.Close(wsInternalError, internal.PresentError(err).Error())
+		return
+	}
+	slog.InfoContext(requestContext(w, r), "terminal opened", "terminal", term.ID)
+	relayTerminal(ws, term)
+}
+
+// relayTerminal copies between a terminal and its socket until either
+// ends, then closes both.
+func relayTerminal(ws *wsConn, term *internal.Terminal) {
+	defer term.Close("the browser disconnected")
+
+	go func() {
+		buf := make([]byte, 32<<10)
+		for {
+			n, err := term.Read(buf)
+			if n > 0 && ws.WriteMessage(wsBinary, buf[:n]) != nil {
+				term.Close("the browser disconnected")
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	go func() {
+		ticker := time.NewTicker(terminalPingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-term.Closed():
+				ws.Close(wsNormalClosure, "Terminal closed: "+term.Reason())
+				return
+			case <-ticker.C:
+				if ws.writeFrame(wsPing, nil) != nil {
+					term.Close("the browser disconnected")
+				}
+			}
+		}
+	}()
+
+	ws.SetReadTimeout(2 * terminalPingInterval)
+	for {
+		opcode, message, err := ws.ReadMessage()
+		if err != nil {
+			return
+		}
+		switch opcode {
+		case wsBinary:
+			term.Write(message)
+		case wsText:
+			var control terminalControl
+			if json.Unmarshal(message, &control) == nil && control.Type == "resize" {
+				term.Resize(control.Cols, control.Rows)
+			}
+		}
+	}
+}
+
+// downloadBackup handles GET /api/backup?activities=true. Secrets are
+// included, encrypted, only when an X-Backup-Passphrase header is sent.
+func (c *WorkbenchController) downloadBackup(w http.ResponseWriter, r *http.Request) {
+	var archive bytes.Buffer
+	err := internal.CreateBackup(&archive, internal.BackupOptions{
+		Passphrase: r.Header.Get("X-Backup-Passphrase"),
+		Activities: r.URL.Query().Get("activities") == "true",
+	})
+	if err != nil {
+		writeAPIError(w, http.StatusUnprocessableEntity, "backup_failed", internal.PresentError(err).Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="workbench-backup-%s.tar.gz"`, time.Now().UTC().Format("20060102-150405")))
+	w.Header().Set("Content-Length", strconv.Itoa(archive.Len()))
+	archive.WriteTo(w)
+}
+
+// exportActivities handles GET /api/activities/export and GET
+// /api/export/activities, streaming the activity log as newline-delimited
+// JSON (the default) or CSV, e.g. to archive it before pruning or hand it
+// to an auditor. Accepts from and to (YYYY-MM-DD), the activity log's
+// type, security, and repository filters, and bom=true for Excel.
+func (c *WorkbenchController) exportActivities(w http.ResponseWriter, r *http.Request) {
+	c.streamExport(w, r, "activities", internal.ExportActivities)
+}
+
+// exportRepositories handles GET /api/export/repositories, streaming the
+// repositories as newline-delimited JSON or CSV. Accepts format, bom, and
+// from and to, which filter by when a repository was added.
+func (c *WorkbenchController) exportRepositories(w http.ResponseWriter, r *http.Request) {
+	c.streamExport(w, r, "repositories", internal.ExportRepositories)
+}
+
+// streamExport writes an export as a download and records who took it
+// and how many rows it held.
+func (c *WorkbenchController) streamExport(w http.ResponseWriter, r *http.Request, what string, export func(io.Writer, internal.ExportQuery) (int, error)) {
+	q, err := internal.ParseExportQuery(r.URL.Query())
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_export", err.Error())
+		return
+	}
+
+	contentType := "application/x-ndjson"
+	if q.Format == internal.ExportCSV {
+		contentType = "text/csv; charset=utf-8"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="workbench-%s-%s.%s"`, what, time.Now().UTC().Format("20060102-150405"), q.Format))
+	rows, err := export(w, q)
+	if err != nil {
+		slog.WarnContext(requestContext(w, r), "export stopped", "export", what, "error", err) // Headers are sent; the download ends early
+	}
+
+	by := ""
+	if user := c.Use("auth").(*AuthController).Handle(r).(*AuthController).CurrentUser(); user != nil {
+		by = user.Email
+	}
+	internal.RecordExport(what, q.Format, rows, by)
+}
+
+// listTrash handles GET /api/trash, listing archived repositories oldest
+// first.
+func (c *WorkbenchController) listTrash(w http.ResponseWriter, r *http.Request) {
+	archives, err := internal.ListTrash()
+	if err != nil {
+		writeAPIError(w, http.StatusUnprocessableEntity, "operation_failed", internal.PresentError(err).Error())
+		return
+	}
+	if archives == nil {
+		archives = []*internal.TrashArchive{}
+	}
+	writeJSON(w, http.StatusOK, archives)
+}
+
+// downloadTrash handles GET /api/trash/{file}, streaming the archive out
+// of the coder container.
+func (c *WorkbenchController) downloadTrash(w http.ResponseWriter, r *http.Request) {
+	archive, content, err := internal.OpenTrash(r.PathValue("file"))
+	if err != nil {
+		writeRepoAPIError(w, err)
+		return
+	}
+	defer content.Close()
+
+	requester, requesterType := c.accessRequester(r)
+	recordAccess("archive", archive.Repository, archive.File, uint64(archive.Size), requester, requesterType, clientIP(r))
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, archive.File))
+	w.Header().Set("Content-Length", strconv.FormatInt(archive.Size, 10))
+	if _, err := io.Copy(w, content); err != nil {
+		slog.WarnContext(requestContext(w, r), "trash download stopped", "error", err) // Headers are sent; the download ends early
+	}
+}
+
+// restoreTrash handles POST /api/trash/restore/{file}, returning the
+// restored repository with 201.
+func (c *WorkbenchController) restoreTrash(w http.ResponseWriter, r *http.Request) {
+	name, err := internal.RestoreTrash(r.PathValue("file"))
+	if err != nil {
+		writeRepoAPIError(w, err)
+		return
+	}
+	c.writeRepoSummary(w, http.StatusCreated, name)
+}
+
+// pruneActivities handles POST /activities/prune, deleting activities past
+// the retention without waiting for the daily job.
+func (c *WorkbenchController) pruneActivities(w http.ResponseWriter, r *http.Request) {
+	if _, err := internal.PruneActivities(time.Now()); err != nil {
+		c.renderError(w, r, err)
+		return
+	}
+	c.Refresh(w, r)
+}
+
+// restoreBackup handles POST /api/restore with a backup archive as the
+// body, and the X-Backup-Passphrase header for its secrets. The response
+// lists every item as restored, skipped, or failed.
+func (c *WorkbenchController) restoreBackup(w http.ResponseWriter, r *http.Request) {
+	// Cross-site forms can't send these content types
+	switch mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType {
+	case "application/gzip", "application/x-gzip", "application/octet-stream":
+	default:
+		writeAPIError(w, http.StatusUnsupportedMediaType, "unsupported_media_type", "send the backup archive as application/gzip")
+		return
+	}
+	if r.ContentLength > internal.MaxBackupSize {
+		writeBodyTooLarge(w, internal.MaxBackupSize)
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, internal.MaxBackupSize))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeBodyTooLarge(w, tooLarge.Limit)
+		return
+	}
+
+	changes, err := internal.RestoreBackup(bytes.NewReader(body), r.Header.Get("X-Backup-Passphrase"))
+	if err != nil {
+		switch {
+		case errors.Is(err, internal.ErrBackupPassphrase):
+			writeAPIError(w, http.StatusBadRequest, "wrong_passphrase", err.Error())
+		case errors.Is(err, internal.ErrInvalidBackup):
+			writeAPIError(w, http.StatusBadRequest, "invalid_backup", err.Error())
+		default:
+			writeAPIError(w, http.StatusUnprocessableEntity, "restore_failed", internal.PresentError(err).Error())
+		}
+		return
+	}
+	writeJSON(w, http.StatusOK, restoreSummary(changes))
+}
+
+// restoreItem is one item of a restore summary.
+type restoreItem struct {
+	Kind   string `json:"kind"`
+	Item   string `json:"item"`
+	Status string `json:"status"` // restored, skipped, or failed
+	Note   string `json:"note,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// restoreSummary counts and lists the items of a restore.
+func restoreSummary(changes []*internal.TemplateChange) map[string]any {
+	counts := map[string]int{"restored": 0, "skipped": 0, "failed": 0}
+	items := []restoreItem{}
+	for _, change := range changes {
+		item := restoreItem{Kind: change.Kind, Item: change.Item, Status: "restored", Note: change.Note, Error: change.Error}
+		switch {
+		case change.Failed():
+			item.Status = "failed"
+		case change.Action == internal.ChangeSkip:
+			item.Status = "skipped"
+		}
+		counts[item.Status]++
+		items = append(items, item)
+	}
+	return map[string]any{
+		"restored": counts["restored"],
+		"skipped":  counts["skipped"],
+		"failed":   counts["failed"],
+		"items":    items,
+	}
+}
+
+// listExtensionsAPI handles GET /api/coder/extensions, listing installed
+// and kept extensions with the last install error of kept ones that
+// aren't installed.
+func (c *WorkbenchController) listExtensionsAPI(w http.ResponseWriter, r *http.Request) {
+	extensions, err := internal.GetExtensions()
+	if err != nil {
+		writeAPIError(w, http.StatusServiceUnavailable, "coder_not_running", err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, extensions)
+}
+
+// installExtensionAPI handles POST /api/coder/extensions with {"id"}. The
+// extension is kept even when installing fails, so startup retries it.
+func (c *WorkbenchController) installExtensionAPI(w http.ResponseWriter, r *http.Request) {
+	if !requireJSON(w, r) {
+		return
+	}
+	var body struct {
+		ID string `json:"id"`
+	}
+	if !decodeJSON(w, r, &body) {
+		return
+	}
+	if !services.ValidExtensionID(strings.TrimSpace(body.ID)) {
+		writeAPIError(w, http.StatusBadRequest, "invalid_request", "id must be an extension ID such as golang.go")
+		return
+	}
+	if err := internal.InstallDesiredExtension(body.ID); err != nil {
+		writeAPIError(w, http.StatusUnprocessableEntity, "install_failed", err.Error())
+		return
+	}
+	c.listExtensionsAPI(w, r)
+}
+
+// uninstallExtensionAPI handles DELETE /api/coder/extensions/{id}.
+func (c *WorkbenchController) uninstallExtensionAPI(w http.ResponseWriter, r *http.Request) {
+	if !services.ValidExtensionID(r.PathValue("id")) {
+		writeAPIError(w, http.StatusBadRequest, "invalid_request", "not an extension ID")
+		return
+	}
+	if err := internal.UninstallExtension(r.PathValue("id")); err != nil {
+		writeAPIError(w, http.StatusUnprocessableEntity, "uninstall_failed", err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// streamWriter writes a plain-text response, flushing each write so the
+// client sees output as it is produced.
+type streamWriter struct {
+	w       http.ResponseWriter
+	started bool
+}
+
+func (s *streamWriter) Write(p []byte) (int, error) {
+	if !s.started {
+		s.started = true
+		s.w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		s.w.Header().Set("X-Content-Type-Options", "nosniff")
+		s.w.WriteHeader(http.StatusOK)
+	}
+	n, err := s.w.Write(p)
+	http.NewResponseController(s.w).Flush()
+	return n, err
+}
+
+// listRepoFilesAPI handles GET /api/v1/repos/{name}/files?path=, listing
+// a directory as name, type, size, and modified.
+func (c *WorkbenchController) listRepoFilesAPI(w http.ResponseWriter, r *http.Request) {
+	name, path := r.PathValue("name"), r.URL.Query().Get("path")
+	entries, err := internal.ListRepoFiles(name, path)
+	if err != nil {
+		writeRepoFileAPIError(w, err)
+		return
+	}
+	requester, requesterType := c.accessRequester(r)
+	recordAccess("list", name, path, 0, requester, requesterType, clientIP(r))
+	writeJSON(w, http.StatusOK, entries)
+}
+
+// readRepoFile reads a repository file for the API. Replaced in tests.
+var readRepoFile = internal.ReadRepoFile
+
+// readRepoFileAPI handles GET /api/v1/repos/{name}/file?path=, returning
+// the file's raw content. X-Binary-File tells binary files apart.
+func (c *WorkbenchController) readRepoFileAPI(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	file, err := readRepoFile(name, r.URL.Query().Get("path"), internal.MaxRepoFileSize)
+	if err != nil {
+		writeRepoFileAPIError(w, err)
+		return
+	}
+	requester, requesterType := c.accessRequester(r)
+	recordAccess("file", name, file.Path, uint64(len(file.Content)), requester, requesterType, clientIP(r))
+	w.Header().Set("Content-Type", repoFileContentType(file))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "sandbox")
+	w.Header().Set("X-Binary-File", strconv.FormatBool(file.Binary))
+	w.Write(file.Content)
+}
+
+// fileHistoryAPI handles GET /api/v1/repos/{name}/history?path=&limit=,
+// listing the commits that changed a file, newest first. Entries of
+// commits that renamed it carry renamed_from.
+func (c *WorkbenchController) fileHistoryAPI(w http.ResponseWriter, r *http.Request) {
+	name, path := r.PathValue("name"), r.URL.Query().Get("path")
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	history, err := internal.GetFileHistory(name, path, limit)
+	if err != nil {
+		writeRepoFileAPIError(w, err)
+		return
+	}
+	requester, requesterType := c.accessRequester(r)
+	recordAccess("history", name, path, 0, requester, requesterType, clientIP(r))
+	writeJSON(w, http.StatusOK, history)
+}
+
+// fileBlameAPI handles GET /api/v1/repos/{name}/blame?path=&start=&end=,
+// returning the file's lines grouped into hunks by the commit that last
+// changed them. start and end are optional line numbers.
+func (c *WorkbenchController) fileBlameAPI(w http.ResponseWriter, r *http.Request) {
+	start, end, err := lineRange(r.URL.Query().Get("start"), r.URL.Query().Get("end"))
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_input", err.Error())
+		return
+	}
+	name := r.PathValue("name")
+	blame, err := internal.GetFileBlame(name, r.URL.Query().Get("path"), start, end)
+	if err != nil {
+		writeRepoFileAPIError(w, err)
+		return
+	}
+	var size uint64
+	for _, hunk := range blame.Hunks {
+		for _, line := range hunk.Lines {
+			size += uint64(len(line))
+		}
+	}
+	requester, requesterType := c.accessRequester(r)
+	recordAccess("blame", name, blame.Path, size, requester, requesterType, clientIP(r))
+	writeJSON(w, http.StatusOK, blame)
+}
+
+// lineRange parses optional start and end line numbers, 0 when empty.
+func lineRange(start, end string) (int, int, error) {
+	var lines [2]int
+	for i, value := range []string{start, end} {
+		if value == "" {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			return 0, 0, fmt.Errorf("start and end must be line numbers, from 1")
+		}
+		lines[i] = n
+	}
+	return lines[0], lines[1], nil
+}
+
+// repoFileContentType guesses a repository file's Content-Type. Text is
+// served as plain text, and binary files keep their type only when
+// browsers can't run script from it, so nothing in a repository runs on
+// the workbench's origin.
+func repoFileContentType(file *internal.RepoFile) string {
+	if !file.Binary {
+		if path.Ext(file.Path) == ".json" {
+			return "application/json"
+		}
+		return "text/plain; charset=utf-8"
+	}
+	contentType := mime.TypeByExtension(path.Ext(file.Path))
+	if contentType == "" {
+		contentType = http.DetectContentType(file.Content)
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case mediaType == "image/svg+xml":
+		return "application/octet-stream"
+	case strings.HasPrefix(mediaType, "image/"), strings.HasPrefix(mediaType, "audio/"), strings.HasPrefix(mediaType, "video/"),
+		strings.HasPrefix(mediaType, "font/"), mediaType == "application/pdf", mediaType == "application/zip", mediaType == "application/gzip":
+		return mediaType
+	default:
+		return "application/octet-stream"
+	}
+}
+
+// writeRepoFileAPIError writes a file browser error: 400 for a path
+// outside the repository, 404 for a missing one, 413 for a file over
+// the size cap, 422 for blame of a binary file, and otherwise as
+// writeRepoAPIError does.
+func writeRepoFileAPIError(w http.ResponseWriter, err error) {
+	var tooLarge *internal.FileTooLargeError
+	switch {
+	case errors.Is(err, internal.ErrInvalidRepoPath):
+		writeAPIError(w, http.StatusBadRequest, "invalid_path", err.Error())
+	case errors.Is(err, internal.ErrRepoPathNotFound):
+		writeAPIError(w, http.StatusNotFound, "not_found", err.Error())
+	case errors.As(err, &tooLarge):
+		writeAPIError(w, http.StatusRequestEntityTooLarge, "file_too_large", err.Error())
+	case errors.Is(err, internal.ErrBlameBinary):
+		writeAPIError(w, http.StatusUnprocessableEntity, "blame_not_available", err.Error())
+	default:
+		writeRepoAPIError(w, err)
+	}
+}
+
+func (c *WorkbenchController) writeRepoSummary(w http.ResponseWriter, status int, name string) {
+	summary, err := internal.GetRepositorySummary(name)
+	if err != nil {
+		writeRepoAPIError(w, err)
+		return
+	}
+	writeJSON(w, status, summary)
+}
+
+// generateAPIToken handles POST /settings/api-token to create a new API
+// token, replacing any previous one. The token is shown once.
+func (c *WorkbenchController) generateAPIToken(w http.ResponseWriter, r *http.Request) {
+	token, err := internal.GenerateAPIToken()
+	if err != nil {
+		c.renderError(w, r, err)
+		return
+	}
+	c.Render(w, r, "api-token.html", token)
+}
+
+// revokeAPIToken handles POST /settings/api-token/revoke.
+func (c *WorkbenchController) revokeAPIToken(w http.ResponseWriter, r *http.Request) {
+	if err := internal.RevokeAPIToken(); err != nil {
+		c.renderError(w, r, err)
+		return
+	}
+	c.Render(w, r, "api-token.html", "")
+}
+
+// generateMetricsToken handles POST /settings/metrics-token to create a
+// new metrics token, replacing any previous one. The token is shown once.
+func (c *WorkbenchController) generateMetricsToken(w http.ResponseWriter, r *http.Request) {
+	token, err := internal.GenerateMetricsToken()
+	if err != nil {
+		c.renderError(w, r, err)
+		return
+	}
+	c.Render(w, r, "metrics-token.html", token)
+}
+
+// revokeMetricsToken handles POST /settings/metrics-token/revoke.
+func (c *WorkbenchController) revokeMetricsToken(w http.ResponseWriter, r *http.Request) {
+	if err := internal.RevokeMetricsToken(); err != nil {
+		c.renderError(w, r, err)
+		return
+	}
+	c.Render(w, r, "metrics-token.html", "")
+}
+
+// clearLockout handles POST /settings/lockouts/clear. Accepts key.
+func (c *WorkbenchController) clearLockout(w http.ResponseWriter, r *http.Request) {
+	message := ""
+	if err := internal.ClearAuthLockout(r.FormValue("key")); err != nil {
+		message = err.Error()
+	}
+	c.Render(w, r, "signin-lockouts.html", message)
+}
+
+// revokeSession handles POST /auth/sessions/revoke/{id}. The session list
+// reloads on success.
+func (c *WorkbenchController) revokeSession(w http.ResponseWriter, r *http.Request) {
+	if err := internal.RevokeSession(r.PathValue("id")); err != nil {
+		c.renderError(w, r, err)
+		return
+	}
+	w.Header().Set("HX-Trigger", "sessionsChanged")
+	c.Render(w, r, "error-message.html", nil)
+}
+
+// shareLink is a new share link as POST /api/share returns it. The URL
+// isn't stored, so it can't be shown again.
+type shareLink struct {
+	ID         string    `json:"id"`
+	URL        string    `json:"url"`
+	Type       string    `json:"type" api:"enum=file|diff|readme"`
+	Repository string    `json:"repository"`
+	Path       string    `json:"path,omitempty"`
+	Ref        string    `json:"ref"` // Commit ids: one, or A..B for a diff range
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+func newShareLink(r *http.Request, share *models.Share, token string) shareLink {
+	return shareLink{
+		ID:         share.ID,
+		URL:        requestOrigin(r) + "/shared/" + token,
+		Type:       share.ResourceType,
+		Repository: share.Repository,
+		Path:       share.Path,
+		Ref:        share.RefRange,
+		ExpiresAt:  share.ExpiresAt,
+	}
+}
+
+// createShareAPI handles POST /api/share. Accepts a JSON body with type
+// (file, diff, or readme), repository, path, ref, and expires_in_hours,
+// and returns the link with 201.
+func (c *WorkbenchController) createShareAPI(w http.ResponseWriter, r *http.Request) {
+	if !requireJSON(w, r) {
+		return
+	}
+	var opts internal.ShareOptions
+	if !decodeJSON(w, r, &opts) {
+		return
+	}
+	share, token, err := internal.CreateShare(opts)
+	if err != nil {
+		writeRepoAPIError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, newShareLink(r, share, token))
+}
+
+// createShare handles POST /shares/create from the file view and commit
+// list, with the same fields as POST /api/share as form values, and shows
+// the link once.
+func (c *WorkbenchController) createShare(w http.ResponseWriter, r *http.Request) {
+	hours, _ := strconv.Atoi(r.FormValue("expires_in_hours"))
+	share, token, err := internal.CreateShare(internal.ShareOptions{
+		Type:       r.FormValue("type"),
+		Repository: r.FormValue("repository"),
+		Path:       r.FormValue("path"),
+		Ref:        r.FormValue("ref"),
+		ExpiresIn:  hours,
+	})
+	if err != nil {
+		c.renderError(w, r, err)
+		return
+	}
+	w.Header().Set("HX-Trigger", "sharesChanged")
+	c.Render(w, r, "share-link.html", newShareLink(r, share, token))
+}
+
+// revokeShare handles POST /shares/revoke/{id}. The share list reloads on
+// success.
+func (c *WorkbenchController) revokeShare(w http.ResponseWriter, r *http.Request) {
+	if err := internal.RevokeShare(r.PathValue("id")); err != nil {
+		c.renderError(w, r, err)
+		return
+	}
+	w.Header().Set("HX-Trigger", "sharesChanged")
+	c.Render(w, r, "error-message.html", nil)
+}
+
+// openShare handles GET /shared/{token}, showing the shared resource on
+// a page of its own, with no way into the rest of the workbench. Links
+// that don't work get the same 404 page whatever the reason.
+func (c *WorkbenchController) openShare(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.Header().Set("X-Robots-Tag", "noindex, nofollow")
+	w.Header().Set("X-Frame-Options", "DENY")
+	view, err := internal.OpenShare(r.PathValue("token"))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		c.Render(w, r, "shared-missing.html", nil)
+		return
+	}
+	path, size := view.Path, uint64(len(view.Content))
+	if path == "" {
+		path = view.RefRange
+	}
+	if view.Diff != nil {
+		size = sectionBytes(view.Diff)
+	}
+	recordAccess("share", view.Repository, path, size, view.ID, "share", clientIP(r))
+	c.Render(w, r, "shared.html", view)
+}
+
+// revokeOtherSessions handles POST /auth/sessions/revoke-others, signing
+// out everywhere but the session making the request.
+func (c *WorkbenchController) revokeOtherSessions(w http.ResponseWriter, r *http.Request) {
+	if _, err := internal.RevokeOtherSessions(currentSessionID(r)); err != nil {
+		c.renderError(w, r, err)
+		return
+	}
+	w.Header().Set("HX-Trigger", "sessionsChanged")
+	c.Render(w, r, "error-message.html", nil)
+}
+
+// currentSessionID returns the recorded session of the request's auth
+// cookie, or "".
+func currentSessionID(r *http.Request) string {
+	cookie, err := r.Cookie(internal.SessionCookie())
+	if err != nil {
+		return ""
+	}
+	return internal.CurrentSessionID(cookie.Value)
+}
+
+// rotateIdentity handles POST /settings/identity/rotate to replace the
+// instance keypair from the preferences.
+func (c *WorkbenchController) rotateIdentity(w http.ResponseWriter, r *http.Request) {
+	identity, err := internal.RotateInstanceIdentity()
+	if err != nil {
+		c.renderError(w, r, err)
+		return
+	}
+	c.Render(w, r, "instance-identity.html", identity)
+}
+
+// listJobs handles GET /api/jobs, listing every background job in
+// registration order.
+func (c *WorkbenchController) listJobs(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, internal.GetJobStatuses())
+}
+
+// statsDate is the format of the dates /api/stats/activity accepts.
+const statsDate = "2006-01-02"
+
+// activityStats handles GET /api/stats/activity, counting activities per
+// day from the from date through the to date, both included and formatted
+// like 2026-01-31. Days start at midnight in the user's timezone. to
+// defaults to today and from to the 6 days before to.
+func (c *WorkbenchController) activityStats(w http.ResponseWriter, r *http.Request) {
+	loc := requestTimezone(r)
+	to, from := time.Now().In(loc), time.Time{}
+	for _, param := range []struct {
+		name string
+		date *time.Time
+	}{{"to", &to}, {"from", &from}} {
+		value := r.URL.Query().Get(param.name)
+		if value == "" {
+			continue
+		}
+		parsed, err := time.ParseInLocation(statsDate, value, loc)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, internal.CodeInvalidInput, param.name+" must be a date like 2026-01-31")
+			return
+		}
+		*param.date = parsed
+	}
+	if from.IsZero() {
+		from = to.AddDate(0, 0, -6)
+	}
+
+	stats, err := internal.GetActivityStats(from, to)
+	if err != nil {
+		writeRepoAPIError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, stats)
+}
+
+// runJob handles POST /jobs/run/{name} from the background jobs panel.
+func (c *WorkbenchController) runJob(w http.ResponseWriter, r *http.Request) {
+	if err := internal.RunJobNow(r.PathValue("name")); err != nil {
+		c.renderError(w, r, err)
+		return
+	}
+	c.Render(w, r, "background-jobs.html", nil)
+}
+
+// pauseJob handles POST /jobs/pause/{name} to pause or resume a background
+// job. Accepts paused ("true" to pause).
+func (c *WorkbenchController) pauseJob(w http.ResponseWriter, r *http.Request) {
+	if err := internal.SetJobPaused(r.PathValue("name"), r.FormValue("paused") == "true"); err != nil {
+		c.renderError(w, r, err)
+		return
+	}
+	c.Render(w, r, "background-jobs.html", nil)
+}
+
+// saveAutoPullInterval handles POST /settings/autopull. Accepts interval
+// in minutes (0 turns auto-pull off).
+func (c *WorkbenchController) saveAutoPullInterval(w http.ResponseWriter, r *http.Request) {
+	minutes, err := strconv.Atoi(r.FormValue("interval"))
+	if err != nil {
+		c.renderError(w, r, invalidInput("Invalid auto-pull interval"))
+		return
+	}
+	if err := internal.SetAutoPullInterval(minutes); err != nil {
+		c.renderError(w, r, err)
+		return
+	}
+
+	c.Refresh(w, r)
+}
+
+// saveMissingRepoBehavior handles POST /settings/missing-repos. Accepts
+// behavior: auto-reclone, prompt, or fail.
+func (c *WorkbenchController) saveMissingRepoBehavior(w http.ResponseWriter, r *http.Request) {
+	if err := internal.SetMissingRepoBehavior(r.FormValue("behavior")); err != nil {
+		c.renderError(w, r, err)
+		return
+	}
+
+	c.Refresh(w, r)
+}
+
+// exportRepo handles POST /repos/export/{name} to mirror a repository to a
+// newly created remote. Accepts url (required) and update_origin ("true"
+// to point origin at the destination afterwards). Renders per-ref results.
+func (c *WorkbenchController) exportRepo(w http.ResponseWriter, r *http.Request) {
+	destination := r.FormValue("url")
+	if destination == "" {
+		c.renderError(w, r, invalidInput("Destination URL is required"))
+		return
+	}
+
+	result, err := internal.ExportRepository(r.PathValue("name"), destination, r.FormValue("update_origin") == "true")
+	if err != nil {
+		c.renderError(w, r, err)
+		return
+	}
+
+	c.Render(w, r, "export-result.html", result)
+}
+
+// checkoutBranch handles POST /repos/checkout/{name} to switch a
+// repository to another branch. Accepts branch.
+func (c *WorkbenchController) checkoutBranch(w http.ResponseWriter, r *http.Request) {
+	if err := internal.CheckoutBranch(r.PathValue("name"), r.FormValue("branch")); err != nil {
+		c.renderError(w, r, err)
+		return
+	}
+
+	c.Refresh(w, r)
+}
+
+// commitEdit handles POST /repos/edit/{name} to commit an inline edit.
+// Accepts path, content, base_hash, message, and push ("true" to push).
+// Renders the changes made in the meantime if HEAD moved since the file
+// was opened.
+func (c *WorkbenchController) commitEdit(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	path := r.FormValue("path")
+
+	err := internal.CommitFileEdit(name, path, r.FormValue("content"), r.FormValue("base_hash"),
+		r.FormValue("message"), r.FormValue("push") == "true")
+
+	var conflict *internal.EditConflictError
+	if errors.As(err, &conflict) {
+		// The conflict view shows the upstream diff of the file
+		requester, requesterType := c.accessRequester(r)
+		recordAccess("diff", name, path, uint64(len(conflict.Diff)), requester, requesterType, clientIP(r))
+		c.Render(w, r, "edit-conflict.html", conflict)
+		return
+	}
+	if err != nil {
+		c.renderError(w, r, err)
+		return
+	}
+
+	c.Render(w, r, "edit-success.html", path)
+}
+
+// runSetupStep handles POST /repos/setup/{name}/{step}/run to start a
+// detected setup step as a background task. Steps only ever run from this
+// explicit user action. Renders the task output, which polls until done.
+func (c *WorkbenchController) runSetupStep(w http.ResponseWriter, r *http.Request) {
+	task, err := internal.RunSetupStep(r.PathValue("name"), r.PathValue("step"))
+	if err != nil {
+		c.renderError(w, r, err)
+		return
+	}
+
+	c.Render(w, r, "task-output.html", task.Status())
+}
+
+// installLFS handles POST /repos/lfs-install/{name} to install Git LFS
+// in the coder container and then pull the repository's LFS files, as a
+// background task. Renders the task output, which polls until done.
+func (c *WorkbenchController) installLFS(w http.ResponseWriter, r *http.Request) {
+	task, err := internal.InstallLFS(r.PathValue("name"))
+	if err != nil {
+		c.renderError(w, r, err)
+		return
+	}
+
+	c.Render(w, r, "task-output.html", task.Status())
+}
+
+// pullLFS handles POST /repos/lfs-pull/{name} to run git lfs pull as a
+// background task. Renders the task output with git lfs's progress.
+func (c *WorkbenchController) pullLFS(w http.ResponseWriter, r *http.Request) {
+	task, err := internal.PullLFS(r.PathValue("name"))
+	if err != nil {
+		c.renderError(w, r, err)
+		return
+	}
+
+	c.Render(w, r, "task-output.html", task.Status())
+}
+
+// taskOutput handles GET /partials/tasks/{id} to poll a background task's
+// output. The partial stops polling once the task has finished.
+func (c *WorkbenchController) taskOutput(w http.ResponseWriter, r *http.Request) {
+	task := internal.GetTask(r.PathValue("id"))
+	if task == nil {
+		c.renderError(w, r, &internal.AppError{Code: internal.CodeNotFound, UserMessage: "Task not found - it may have finished a while ago"})
+		return
+	}
+
+	status := task.Status()
+	if status.Done && task.RefreshRepos {
+		// Reload the repository list so pulled rows show their new status
+		w.Header().Set("HX-Trigger", "reposChanged")
+	}
+	c.Render(w, r, "task-output.html", status)
+}
+
+// dismissSetupStep handles POST /repos/setup/{name}/{step}/dismiss.
+// Returns an empty response so HTMX removes the suggestion.
+func (c *WorkbenchController) dismissSetupStep(w http.ResponseWriter, r *http.Request) {
+	if err := internal.DismissSetupStep(r.PathValue("name"), r.PathValue("step")); err != nil {
+		c.renderError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// toggleSetupHook handles POST /repos/setup/{name}/{step}/autorun to
+// register or remove a setup step as a post-clone hook.
+func (c *WorkbenchController) toggleSetupHook(w http.ResponseWriter, r *http.Request) {
+	enabled := r.FormValue("enabled") == "true"
+	if err := internal.SetPostCloneHook(r.PathValue("name"), r.PathValue("step"), enabled); err != nil {
+		c.renderError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// fetchPullRequest handles POST /repos/prs/{name}/{number}/fetch to fetch
+// a pull request head into a local branch and check it out.
+func (c *WorkbenchController) fetchPullRequest(w http.ResponseWriter, r *http.Request) {
+	number, err := strconv.Atoi(r.PathValue("number"))
+	if err != nil || number <= 0 {
+		c.renderError(w, r, invalidInput("Invalid pull request number"))
+		return
+	}
+
+	if _, err := providers.FetchPullRequest(r.PathValue("name"), number); err != nil {
+		c.renderError(w, r, err)
+		return
+	}
+
+	c.Refresh(w, r)
+}
+
+// toggleSafety handles POST /repos/safety/{name}/toggle to turn safety
+// points on or off for a repository. Accepts enabled ("true" to enable).
+func (c *WorkbenchController) toggleSafety(w http.ResponseWriter, r *http.Request) {
+	if err := internal.SetSafetyEnabled(r.PathValue("name"), r.FormValue("enabled") == "true"); err != nil {
+		c.renderError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// restoreSafetyPoint handles POST /repos/safety/{name}/{id}/restore to
+// apply captured work onto the current tree. Conflicts are reported and
+// nothing is forced.
+func (c *WorkbenchController) restoreSafetyPoint(w http.ResponseWriter, r *http.Request) {
+	if err := internal.RestoreSafetyPoint(r.PathValue("name"), r.PathValue("id")); err != nil {
+		c.renderError(w, r, err)
+		return
+	}
+
+	c.Render(w, r, "safety-restored.html", r.PathValue("name"))
+}
+
+// resetToUpstream handles POST /repos/reset/{name} to hard-reset the
+// current branch to its upstream, e.g. after a force-push.
+func (c *WorkbenchController) resetToUpstream(w http.ResponseWriter, r *http.Request) {
+	if err := internal.ResetToUpstream(r.PathValue("name")); err != nil {
+		c.renderError(w, r, err)
+		return
+	}
+
+	c.Refresh(w, r)
+}
+
+// abortMerge handles POST /repos/merge-abort/{name} to abandon a merge
+// or rebase left in progress by a conflicted pull.
+func (c *WorkbenchController) abortMerge(w http.ResponseWriter, r *http.Request) {
+	if err := internal.AbortMerge(r.PathValue("name")); err != nil {
+		c.renderError(w, r, err)
+		return
+	}
+
+	c.Refresh(w, r)
+}
+
+// resolveMerge returns the handler of POST /repos/merge-ours/{name} or
+// /repos/merge-theirs/{name}, resolving every conflict to one side.
+func (c *WorkbenchController) resolveMerge(side string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := internal.ResolveMerge(r.PathValue("name"), side); err != nil {
+			c.renderError(w, r, err)
+			return
+		}
+
+		c.Refresh(w, r)
+	}
+}
+
+// cleanupBranches handles POST /repos/prune-branches/{name} to delete
+// local branches already merged into the current branch.
+func (c *WorkbenchController) cleanupBranches(w http.ResponseWriter, r *http.Request) {
+	if err := internal.CleanupMergedBranches(r.PathValue("name")); err != nil {
+		c.renderError(w, r, err)
+		return
+	}
+
+	c.Refresh(w, r)
+}
+
+// applyCommit returns the handler of POST /repos/revert/{name} or
+// /repos/cherry-pick/{name}. Accepts sha, a full or abbreviated commit
+// id. When conflicts stop it, the repository list is reloaded so its
+// merge banner shows.
+func (c *WorkbenchController) applyCommit(apply func(repoName, sha string) (string, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, err := apply(r.PathValue("name"), r.FormValue("sha")); err != nil {
+			var conflict *internal.CommitConflictError
+			if errors.As(err, &conflict) {
+				w.Header().Set("HX-Trigger", "reposChanged")
+			}
+			c.renderError(w, r, err)
+			return
+		}
+
+		c.Refresh(w, r)
+	}
+}
+
+// stashChanges handles POST /repos/stash/{name} to stash a repository's
+// uncommitted changes, untracked files included. Accepts message.
+func (c *WorkbenchController) stashChanges(w http.ResponseWriter, r *http.Request) {
+	if err := internal.StashChanges(r.PathValue("name"), r.FormValue("message")); err != nil {
+		c.renderError(w, r, err)
+		return
+	}
+
+	c.Refresh(w, r)
+}
+
+// createTag handles POST /repos/tag/{name} to tag HEAD. Accepts tag,
+// message (the tag name when empty), and push=true to push it to origin.
+func (c *WorkbenchController) createTag(w http.ResponseWriter, r *http.Request) {
+	push := r.FormValue("push") == "true"
+	if err := internal.CreateTag(r.PathValue("name"), r.FormValue("tag"), r.FormValue("message"), push); err != nil {
+		c.renderError(w, r, err)
+		return
+	}
+
+	c.Refresh(w, r)
+}
+
+// editRemote handles POST /repos/remotes/{name}. Accepts action ("set"
+// or "remove"), remote, and url for set. Setting a missing remote adds it.
+func (c *WorkbenchController) editRemote(w http.ResponseWriter, r *http.Request) {
+	name, remote := r.PathValue("name"), r.FormValue("remote")
+	var err error
+	switch r.FormValue("action") {
+	case "set":
+		err = internal.SetRemoteURL(name, remote, r.FormValue("url"))
+	case "remove":
+		err = internal.RemoveRemote(name, remote)
+	default:
+		c.renderError(w, r, invalidInput("Unknown remote action"))
+		return
+	}
+	if err != nil {
+		c.renderError(w, r, err)
+		return
+	}
+
+	c.Refresh(w, r)
+}
+
+// repoMaintenance handles POST /repos/maintenance/{name}. Without an
+// action it shows which merged branches would be deleted, the loose
+// objects, and the .git size. action=prune deletes the merged branches,
+// keeping those matching the comma-separated exclude patterns; branches
+// must list exactly the branches that were shown, or the new list is
+// shown to confirm instead. action=gc runs git gc.
+func (c *WorkbenchController) repoMaintenance(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	exclude := internal.SplitBranchPatterns(r.FormValue("exclude"))
+
+	switch r.FormValue("action") {
+	case "", "prune":
+		report, err := internal.GetMaintenanceReport(name, exclude...)
+		if err != nil {
+			c.renderError(w, r, err)
+			return
+		}
+		changed := r.FormValue("action") == "prune" && !slices.Equal(report.Merged, r.Form["branches"])
+		if r.FormValue("action") == "" || changed {
+			c.Render(w, r, "repo-maintenance.html", map[string]any{
+				"Report":  report,
+				"Exclude": strings.Join(exclude, ", "),
+				"Changed": changed,
+			})
+			return
+		}
+		result, err := internal.PruneMergedBranches(name, exclude)
+		if err != nil {
+			c.renderError(w, r, err)
+			return
+		}
+		c.Render(w, r, "repo-maintenance-result.html", result)
+	case "gc":
+		result, err := internal.RunGarbageCollection(name)
+		if err != nil {
+			c.renderError(w, r, err)
+			return
+		}
+		c.Render(w, r, "repo-maintenance-result.html", result)
+	default:
+		c.renderError(w, r, invalidInput("Unknown maintenance action"))
+	}
+}
+
+// popStash handles POST /repos/stash-pop/{name} to apply a stash and
+// remove it from the list. Accepts index.
+func (c *WorkbenchController) popStash(w http.ResponseWriter, r *http.Request) {
+	index, err := strconv.Atoi(r.FormValue("index"))
+	if err != nil {
+		c.renderError(w, r, invalidInput("Invalid stash index"))
+		return
+	}
+	if err := internal.PopStash(r.PathValue("name"), index); err != nil {
+		c.renderError(w, r, err)
+		return
+	}
+
+	c.Refresh(w, r)
+}
+
+// dropStash handles POST /repos/stash-drop/{name} to delete a stash
+// without applying it. Accepts index.
+func (c *WorkbenchController) dropStash(w http.ResponseWriter, r *http.Request) {
+	index, err := strconv.Atoi(r.FormValue("index"))
+	if err != nil {
+		c.renderError(w, r, invalidInput("Invalid stash index"))
+		return
+	}
+	if err := internal.DropStash(r.PathValue("name"), index); err != nil {
+		c.renderError(w, r, err)
+		return
+	}
+
+	c.Refresh(w, r)
+}
+
+// generateWebhook handles POST /repos/webhook/{name} to create a new
+// webhook secret for a repository. Renders the URL and secret to paste
+// into the git provider.
+func (c *WorkbenchController) generateWebhook(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	secret, err := internal.GenerateWebhookSecret(name)
+	if err != nil {
+		c.renderError(w, r, err)
+		return
+	}
+
+	c.Render(w, r, "webhook-secret.html", map[string]string{
+		"URL":    requestOrigin(r) + "/hooks/git/" + url.PathEscape(name),
+		"Secret": secret,
+	})
+}
+
+// gitWebhook handles POST /hooks/git/{name}, a push webhook from GitHub
+// (X-Hub-Signature-256) or GitLab (X-Gitlab-Token). Queues a pull when the
+// pushed branch is checked out. Deliveries failing verification get 401,
+// and 429 once a client has sent too many of them.
+func (c *WorkbenchController) gitWebhook(w http.ResponseWriter, r *http.Request) {
+	key := remoteKey(r) + ":webhook"
+	if internal.WebhookRateLimiter.Blocked(key) {
+		writeAPIError(w, http.StatusTooManyRequests, "rate_limited", "too many invalid webhook deliveries, retry in a minute")
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, internal.WebhookMaxBody))
+	if err != nil {
+		writeBodyTooLarge(w, internal.WebhookMaxBody)
+		return
+	}
+
+	event := r.Header.Get("X-GitHub-Event")
+	if event == "" {
+		event = r.Header.Get("X-Gitlab-Event")
+	}
+	result, err := internal.HandleWebhook(r.PathValue("name"), internal.WebhookDelivery{
+		Event:       event,
+		Signature:   r.Header.Get("X-Hub-Signature-256"),
+		GitLabToken: r.Header.Get("X-Gitlab-Token"),
+		Body:        body,
+	})
+	if errors.Is(err, internal.ErrWebhookUnauthorized) {
+		internal.WebhookRateLimiter.Allow(key)
+		writeAPIError(w, http.StatusUnauthorized, "invalid_signature", err.Error())
+		return
+	}
+	if err != nil {
+		writeRepoAPIError(w, err)
+		return
+	}
+
+	status := http.StatusOK
+	if result.Status == internal.WebhookQueued {
+		status = http.StatusAccepted
+	}
+	writeJSON(w, status, result)
+}
+
+// requestOrigin returns the scheme and host the request was made to,
+// honoring X-Forwarded-Proto from a TLS-terminating proxy.
+func requestOrigin(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// saveProviderToken handles POST /settings/provider-token to store the
+// personal access token for a provider host. Accepts host and token (empty
+// to remove access).
+func (c *WorkbenchController) saveProviderToken(w http.ResponseWriter, r *http.Request) {
+	if err := providers.SetToken(r.FormValue("host"), r.FormValue("token")); err != nil {
+		c.renderError(w, r, err)
+		return
+	}
+
+	c.Refresh(w, r)
+}
+
+// exportAccessRecords handles GET /audit/access.csv to download access
+// records matching the same repo and date filters as the audit view.
+func (c *WorkbenchController) exportAccessRecords(w http.ResponseWriter, r *http.Request) {
+	repo, from, to := accessFilters(r)
+	records, err := internal.SearchAccessRecords(repo, from, to)
+	if err != nil {
+		http.Error(w, "Failed to load access records", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", `attachment; filename="access-audit.csv"`)
+	if err := internal.WriteCSV(w, internal.AccessRecordsCSV(records)); err != nil {
+		slog.Error("failed to write access audit CSV", "error", err)
+	}
+}
+
+// accessFilters parses the repo, from, and to (YYYY-MM-DD, inclusive)
+// query parameters used by the access audit view and export.
+func accessFilters(r *http.Request) (repo string, from, to time.Time) {
+	query := r.URL.Query()
+	repo = query.Get("repo")
+	from, _ = time.Parse("2006-01-02", query.Get("from"))
+	if t, err := time.Parse("2006-01-02", query.Get("to")); err == nil {
+		to = t.Add(24 * time.Hour)
+	}
+	return repo, from, to
+}
+
+// checkSSHHost handles POST /ssh/check while the clone URL is typed.
+// Renders a warning for SSH URLs whose host has never authenticated
+// successfully; HTTPS URLs and verified hosts render nothing.
+func (c *WorkbenchController) checkSSHHost(w http.ResponseWriter, r *http.Request) {
+	host := internal.SSHHost(r.FormValue("url"))
+	if host == "" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	status := internal.GetSSHHostStatus(host)
+	if status.Verified {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	c.Render(w, r, "ssh-warning.html", status)
+}
+
+// testSSHHost handles POST /ssh/test/{host} to verify the SSH key works.
+func (c *WorkbenchController) testSSHHost(w http.ResponseWriter, r *http.Request) {
+	host := r.PathValue("host")
+	if err := internal.TestSSHConnection(host); err != nil {
+		c.renderError(w, r, err)
+		return
+	}
+
+	c.Render(w, r, "ssh-warning.html", internal.GetSSHHostStatus(host))
+}
+
+// rotateSSHKey handles POST /ssh/rotate. Accepts github_token and
+// gitlab_token to upload the new key with, and save_tokens to keep them
+// as the providers' access tokens. Renders the result per provider.
+func (c *WorkbenchController) rotateSSHKey(w http.ResponseWriter, r *http.Request) {
+	email := "user@workbench.local"
+	if user := c.Use("auth").(*AuthController).Handle(r).(*AuthController).CurrentUser(); user != nil && user.Email != "" {
+		email = user.Email
+	}
+	rotation, err := internal.RotateSSHKey(email, internal.SSHRotateOptions{
+		Tokens: map[providers.Kind]string{
+			providers.GitHub: r.FormValue("github_token"),
+			providers.GitLab: r.FormValue("gitlab_token"),
+		},
+		SaveTokens: r.FormValue("save_tokens") == "on",
+		Force:      r.FormValue("force") == "on",
+	})
+	if err != nil {
+		c.renderError(w, r, err)
+		return
+	}
+	c.Render(w, r, "ssh-rotate-result.html", rotation)
+}
+
+// generateSSHKey handles POST /ssh/keys to create a named key pair.
+// Accepts name and email (defaults to the signed-in user's email).
+func (c *WorkbenchController) generateSSHKey(w http.ResponseWriter, r *http.Request) {
+	email := strings.TrimSpace(r.FormValue("email"))
+	if email == "" {
+		if user := c.Use("auth").(*AuthController).Handle(r).(*AuthController).CurrentUser(); user != nil {
+			email = user.Email
+		}
+	}
+	if _, err := internal.GenerateNamedSSHKey(r.FormValue("name"), email); err != nil {
+		c.renderError(w, r, err)
+		return
+	}
+	c.Refresh(w, r)
+}
+
+// importSSHKey handles POST /ssh/keys/import. Accepts name and
+// private_key.
+func (c *WorkbenchController) importSSHKey(w http.ResponseWriter, r *http.Request) {
+	if _, err := internal.ImportNamedSSHKey(r.FormValue("name"), r.FormValue("private_key")); err != nil {
+		c.renderError(w, r, err)
+		return
+	}
+	c.Refresh(w, r)
+}
+
+// saveSSHKeyHosts handles POST /ssh/keys/{name}/hosts. Accepts hosts,
+// separated by commas or spaces; empty unmaps the key.
+func (c *WorkbenchController) saveSSHKeyHosts(w http.ResponseWriter, r *http.Request) {
+	if err := internal.SetSSHKeyHosts(r.PathValue("name"), r.FormValue("hosts")); err != nil {
+		c.renderError(w, r, err)
+		return
+	}
+	c.Refresh(w, r)
+}
+
+// installExtension handles POST /extensions. Accepts id.
+func (c *WorkbenchController) installExtension(w http.ResponseWriter, r *http.Request) {
+	if err := internal.InstallDesiredExtension(r.FormValue("id")); err != nil {
+		c.renderError(w, r, err)
+		return
+	}
+	c.Refresh(w, r)
+}
+
+// addPortForward handles POST /ports. Accepts name, port, and prefix
+// (empty to derive one from the name).
+func (c *WorkbenchController) addPortForward(w http.ResponseWriter, r *http.Request) {
+	if _, err := internal.AddPortForward(r.FormValue("name"), r.FormValue("port"), r.FormValue("prefix")); err != nil {
+		c.renderError(w, r, err)
+		return
+	}
+	c.Refresh(w, r)
+}
+
+// togglePortForward handles POST /ports/{id}/toggle.
+func (c *WorkbenchController) togglePortForward(w http.ResponseWriter, r *http.Request) {
+	if err := internal.TogglePortForward(r.PathValue("id")); err != nil {
+		c.renderError(w, r, err)
+		return
+	}
+	c.Refresh(w, r)
+}
+
+// deletePortForward handles POST /ports/{id}/delete.
+func (c *WorkbenchController) deletePortForward(w http.ResponseWriter, r *http.Request) {
+	if err := internal.DeletePortForward(r.PathValue("id")); err != nil {
+		c.renderError(w, r, err)
+		return
+	}
+	c.Refresh(w, r)
+}
+
+// resumable serves the IDE through next, except that while the coder
+// container is paused, opening a page of it shows how to resume it
+// instead of the proxy's 503.
+func (c *WorkbenchController) resumable(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || !services.CoderIsPaused() || !strings.Contains(r.Header.Get("Accept"), "text/html") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		c.Render(w, r, "coder-paused.html", r.RequestURI)
+	})
+}
+
+// serveForward handles /apps/{prefix}/... by proxying to the forward's
+// port with the prefix stripped, which dev servers see as
+// X-Forwarded-Prefix. The workbench's session cookie isn't passed on.
+func (c *WorkbenchController) serveForward(w http.ResponseWriter, r *http.Request) {
+	prefix, rest, found := strings.Cut(strings.TrimPrefix(r.URL.Path, "/apps/"), "/")
+	if prefix == "" {
+		http.NotFound(w, r)
+		return
+	}
+	if !found {
+		// Relative asset URLs only resolve under the trailing slash
+		target := prefix + "/"
+		if r.URL.RawQuery != "" {
+			target += "?" + r.URL.RawQuery
+		}
+		http.Redirect(w, r, target, http.StatusFound)
+		return
+	}
+
+	forward, err := internal.EnabledPortForward(prefix)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	proxied := withoutCookie(r, internal.SessionCookie())
+	proxied.URL.Path = "/" + rest
+	proxied.URL.RawPath = ""
+	proxied.Header.Set("X-Forwarded-Prefix", "/apps/"+prefix)
+	services.PortProxy(forward.TargetPort).ServeHTTP(w, proxied)
+}
+
+// keepExtension handles POST /extensions/{id}/keep.
+func (c *WorkbenchController) keepExtension(w http.ResponseWriter, r *http.Request) {
+	if err := internal.KeepExtension(r.PathValue("id")); err != nil {
+		c.renderError(w, r, err)
+		return
+	}
+	c.Refresh(w, r)
+}
+
+// forgetExtension handles POST /extensions/{id}/forget.
+func (c *WorkbenchController) forgetExtension(w http.ResponseWriter, r *http.Request) {
+	if err := internal.ForgetExtension(r.PathValue("id")); err != nil {
+		c.renderError(w, r, err)
+		return
+	}
+	c.Refresh(w, r)
+}
+
+// uninstallExtension handles POST /extensions/{id}/uninstall.
+func (c *WorkbenchController) uninstallExtension(w http.ResponseWriter, r *http.Request) {
+	if err := internal.UninstallExtension(r.PathValue("id")); err != nil {
+		c.renderError(w, r, err)
+		return
+	}
+	c.Refresh(w, r)
+}
+
+// deleteSSHKey handles POST /ssh/keys/{name}/delete.
+func (c *WorkbenchController) deleteSSHKey(w http.ResponseWriter, r *http.Request) {
+	if err := internal.DeleteSSHKey(r.PathValue("name")); err != nil {
+		c.renderError(w, r, err)
+		return
+	}
+	c.Refresh(w, r)
+}
+
+// saveGitCredential handles POST /git-credentials. Accepts host,
+// username (optional), and token.
+func (c *WorkbenchController) saveGitCredential(w http.ResponseWriter, r *http.Request) {
+	if err := internal.SetGitCredential(r.FormValue("host"), r.FormValue("username"), r.FormValue("token")); err != nil {
+		c.renderError(w, r, err)
+		return
+	}
+	c.Refresh(w, r)
+}
+
+// deleteGitCredential handles POST /git-credentials/{host}/delete.
+func (c *WorkbenchController) deleteGitCredential(w http.ResponseWriter, r *http.Request) {
+	if err := internal.DeleteGitCredential(r.PathValue("host")); err != nil {
+		c.renderError(w, r, err)
+		return
+	}
+	c.Refresh(w, r)
+}
+
+// completeTour handles POST /settings/tour-complete to save tour preference.
+// Stores a setting indicating the user has completed or skipped the tour.
+// This prevents the tour from showing on subsequent visits.
+func (c *WorkbenchController) completeTour(w http.ResponseWriter, r *http.Request) {
+	// Save the tour completion setting
+	if _, err := models.SetSetting("tour_completed", "true", "user_preference"); err != nil {
+		slog.Error("failed to save tour preference", "error", err)
+	}
+
+	// Check if user wants to never show again
+	if r.FormValue("dont_show") == "true" {
+		if _, err := models.SetSetting("tour_never_show", "true", "user_preference"); err != nil {
+			slog.Error("failed to save tour never show preference", "error", err)
+		}
+	}
+
+	go models.Activities.Insert(&models.Activity{
+		Type:        "tour_complete",
+		Repository:  "",
+		Description: "Tour completed",
+		Author:      "",
+		Timestamp:   time.Now(),
+	})
+
+	// Return empty response for HTMX
+	w.WriteHeader(http.StatusOK)
+}
+
+// saveLocale handles POST /settings/locale to store the display locale
+// and display_timezone. An empty locale clears the override so
+// Accept-Language is used again; an empty timezone follows the browser.
+func (c *WorkbenchController) saveLocale(w http.ResponseWriter, r *http.Request) {
+	locale := r.FormValue("locale")
+	if locale != "" && i18n.Lookup(locale) == nil {
+		c.renderError(w, r, invalidInput("Unsupported locale"))
+		return
+	}
+	timezone, err := models.NormalizeSetting("display_timezone", r.FormValue("display_timezone"))
+	if err != nil {
+		c.renderError(w, r, &internal.AppError{Code: internal.CodeInvalidInput, UserMessage: err.Error(), Err: err})
+		return
+	}
+
+	if _, err := models.SetSetting("locale", locale, "user_preference"); err != nil {
+		c.renderError(w, r, &internal.AppError{Code: internal.CodeInternal, UserMessage: "Failed to save locale preference", Retryable: true, Err: err})
+		return
+	}
+	if _, err := models.SetSetting("display_timezone", timezone, "user_preference"); err != nil {
+		c.renderError(w, r, &internal.AppError{Code: internal.CodeInternal, UserMessage: "Failed to save timezone preference", Retryable: true, Err: err})
+		return
+	}
+
+	c.Refresh(w, r)
+}
+
+// saveDigest handles POST /settings/digest to store the activity digest
+// mode, delivery time, quiet-hours window, and which notification
+// channels are on. Each channel checkbox is followed by a hidden "false",
+// so an unticked one is saved off rather than reset to its default. An
+// empty notify_webhook_secret keeps the saved one unless
+// remove_webhook_secret is set.
+func (c *WorkbenchController) saveDigest(w http.ResponseWriter, r *http.Request) {
+	settings := map[string]string{}
+	for _, key := range []string{"activity_digest", "activity_digest_time", "digest_quiet_start", "digest_quiet_end", "timezone",
+		"notify_webhook_url", "notify_webhook_secret", "notify_in_app", "notify_webhook", "notify_email"} {
+		if key == "notify_webhook_secret" && r.FormValue(key) == "" && r.FormValue("remove_webhook_secret") != "true" {
+			continue
+		}
+		// Check every value before saving any
+		value, err := models.NormalizeSetting(key, r.FormValue(key))
+		if err != nil {
+			c.renderError(w, r, &internal.AppError{Code: internal.CodeInvalidInput, UserMessage: err.Error(), Err: err})
+			return
+		}
+		settings[key] = value
+	}
+	for key, value := range settings {
+		if _, err := models.SetSetting(key, value, "user_preference"); err != nil {
+			c.renderError(w, r, &internal.AppError{Code: internal.CodeInternal, UserMessage: "Failed to save digest settings", Retryable: true, Err: err})
+			return
+		}
+	}
+
+	c.Refresh(w, r)
+}
+
+// saveSMTP handles POST /settings/smtp from the preferences dialog.
+// Accepts smtp_host, smtp_port, smtp_username, smtp_password (keeping the
+// saved one when empty), smtp_from, and notify_email_to.
+func (c *WorkbenchController) saveSMTP(w http.ResponseWriter, r *http.Request) {
+	port, _ := strconv.Atoi(r.FormValue("smtp_port"))
+	err := internal.SaveSMTPSettings(internal.SMTPSettings{
+		Host:     r.FormValue("smtp_host"),
+		Port:     port,
+		Username: r.FormValue("smtp_username"),
+		Password: r.FormValue("smtp_password"),
+		From:     r.FormValue("smtp_from"),
+		To:       r.FormValue("notify_email_to"),
+	})
+	if err != nil {
+		c.renderError(w, r, err)
+		return
+	}
+	c.Refresh(w, r)
+}
+
+// readNotification handles POST /notifications/read/{id} from the header
+// bell, rendering the bell again.
+func (c *WorkbenchController) readNotification(w http.ResponseWriter, r *http.Request) {
+	if err := internal.MarkNotificationRead(r.PathValue("id")); err != nil {
+		c.renderError(w, r, err)
+		return
+	}
+	c.Render(w, r, "notifications.html", nil)
+}
+
+// readAllNotifications handles POST /notifications/read-all.
+func (c *WorkbenchController) readAllNotifications(w http.ResponseWriter, r *http.Request) {
+	if err := internal.MarkAllNotificationsRead(); err != nil {
+		c.renderError(w, r, err)
+		return
+	}
+	c.Render(w, r, "notifications.html", nil)
+}
+
+// saveSetting handles POST /settings/value/{key} from the settings page.
+// Accepts value. Renders the setting's row again, with the rule it broke
+// when the value is rejected.
+func (c *WorkbenchController) saveSetting(w http.ResponseWriter, r *http.Request) {
+	entry, err := internal.SaveSetting(r.PathValue("key"), r.FormValue("value"))
+	if err != nil {
+		c.renderError(w, r, err)
+		return
+	}
+	c.Render(w, r, "setting-row.html", entry)
+}
+
+// saveDefaultBranch handles POST /settings/scaffold/branch.
+func (c *WorkbenchController) saveDefaultBranch(w http.ResponseWriter, r *http.Request) {
+	if err := internal.SetDefaultBranch(r.FormValue("branch")); err != nil {
+		c.renderError(w, r, err)
+		return
+	}
+	c.Refresh(w, r)
+}
+
+// saveScaffoldFile handles POST /settings/scaffold/files. Accepts path,
+// content, and template (empty to include the file in every template).
+func (c *WorkbenchController) saveScaffoldFile(w http.ResponseWriter, r *http.Request) {
+	if err := internal.SaveScaffoldFile(r.FormValue("path"), r.FormValue("content"), r.FormValue("template")); err != nil {
+		c.renderError(w, r, err)
+		return
+	}
+	c.Refresh(w, r)
+}
+
+// deleteScaffoldFile handles POST /settings/scaffold/delete/{id}.
+func (c *WorkbenchController) deleteScaffoldFile(w http.ResponseWriter, r *http.Request) {
+	if err := internal.DeleteScaffoldFile(r.PathValue("id")); err != nil {
+		c.renderError(w, r, err)
+		return
+	}
+	c.Refresh(w, r)
+}
+
+// moveStorage handles POST /repos/move-storage/{name} to move a repository
+// to another storage location in the background. Accepts storage (empty
+// for the standard repos directory). Renders the task's progress.
+func (c *WorkbenchController) moveStorage(w http.ResponseWriter, r *http.Request) {
+	task, err := internal.StartStorageMove(r.PathValue("name"), r.FormValue("storage"))
+	if err != nil {
+		c.renderError(w, r, err)
+		return
+	}
+
+	c.Render(w, r, "task-output.html", task.Status())
+}
+
+// runRepoApp handles POST /repos/run/{name} to build or pull a
+// repository's image and run it in the background. Renders the task
+// output, which polls until done.
+func (c *WorkbenchController) runRepoApp(w http.ResponseWriter, r *http.Request) {
+	task, err := internal.RunRepoApp(r.PathValue("name"))
+	if err != nil {
+		c.renderError(w, r, err)
+		return
+	}
+
+	c.Render(w, r, "task-output.html", task.Status())
+}
+
+// stopRepoApp handles POST /repos/stop/{name} to stop and remove a
+// repository's app container.
+func (c *WorkbenchController) stopRepoApp(w http.ResponseWriter, r *http.Request) {
+	if err := internal.StopRepoApp(r.PathValue("name")); err != nil {
+		c.renderError(w, r, err)
+		return
+	}
+
+	w.Header().Set("HX-Trigger", "reposChanged")
+	c.Render(w, r, "error-message.html", nil)
+}
+
+// addStorageLocation handles POST /settings/storage. Accepts name,
+// container_path, and host_path (notes only).
+func (c *WorkbenchController) addStorageLocation(w http.ResponseWriter, r *http.Request) {
+	if _, err := internal.AddStorageLocation(r.FormValue("name"), r.FormValue("container_path"), r.FormValue("host_path")); err != nil {
+		c.renderError(w, r, err)
+		return
+	}
+	c.Refresh(w, r)
+}
+
+// addRepoTemplate handles POST /settings/repo-templates. Accepts name,
+// source_url, branch, and description.
+func (c *WorkbenchController) addRepoTemplate(w http.ResponseWriter, r *http.Request) {
+	_, err := internal.AddRepoTemplate(r.FormValue("name"), r.FormValue("source_url"), r.FormValue("branch"), r.FormValue("description"))
+	if err != nil {
+		c.renderError(w, r, err)
+		return
+	}
+	c.Refresh(w, r)
+}
+
+// refreshRepoTemplate handles POST /settings/repo-templates/refresh/{id}.
+func (c *WorkbenchController) refreshRepoTemplate(w http.ResponseWriter, r *http.Request) {
+	if err := internal.RefreshRepoTemplate(r.PathValue("id")); err != nil {
+		c.renderError(w, r, err)
+		return
+	}
+	c.Refresh(w, r)
+}
+
+// deleteRepoTemplate handles POST /settings/repo-templates/delete/{id}.
+func (c *WorkbenchController) deleteRepoTemplate(w http.ResponseWriter, r *http.Request) {
+	if err := internal.DeleteRepoTemplate(r.PathValue("id")); err != nil {
+		c.renderError(w, r, err)
+		return
+	}
+	c.Refresh(w, r)
+}
+
+// deleteStorageLocation handles POST /settings/storage/delete/{id}.
+func (c *WorkbenchController) deleteStorageLocation(w http.ResponseWriter, r *http.Request) {
+	if err := internal.DeleteStorageLocation(r.PathValue("id")); err != nil {
+		c.renderError(w, r, err)
+		return
+	}
+	c.Refresh(w, r)
+}
+
+// saveGitIdentity handles POST /settings/git-identity. Accepts name and
+// email.
+func (c *WorkbenchController) saveGitIdentity(w http.ResponseWriter, r *http.Request) {
+	if err := internal.ConfigureGitUser(r.FormValue("name"), r.FormValue("email")); err != nil {
+		c.renderError(w, r, err)
+		return
+	}
+	c.Refresh(w, r)
+}
+
+// acceptGitIdentity handles POST /settings/git-identity/accept from the
+// dashboard banner.
+func (c *WorkbenchController) acceptGitIdentity(w http.ResponseWriter, r *http.Request) {
+	if err := internal.AcceptGitIdentity(); err != nil {
+		c.renderError(w, r, err)
+		return
+	}
+	c.Refresh(w, r)
+}
+
+// dismissGitIdentity handles POST /settings/git-identity/dismiss so the
+// banner never returns.
+func (c *WorkbenchController) dismissGitIdentity(w http.ResponseWriter, r *http.Request) {
+	if err := internal.DismissGitIdentity(); err != nil {
+		c.renderError(w, r, err)
+		return
+	}
+	c.Refresh(w, r)
+}
+
+// reapplyGitIdentity handles POST /settings/git-identity/reapply when the
+// container's git config has drifted from the saved identity.
+func (c *WorkbenchController) reapplyGitIdentity(w http.ResponseWriter, r *http.Request) {
+	if err := internal.ReapplyGitIdentity(); err != nil {
+		c.renderError(w, r, err)
+		return
+	}
+	c.Refresh(w, r)
+}
+
+// maxTemplateUpload caps imported template documents, which can carry up
+// to MaxScaffoldFiles scaffold files.
+const maxTemplateUpload = 8 << 20
+
+// createWorkspaceTemplate handles POST /templates/create. Accepts name.
+func (c *WorkbenchController) createWorkspaceTemplate(w http.ResponseWriter, r *http.Request) {
+	if _, err := internal.CreateWorkspaceTemplate(r.FormValue("name")); err != nil {
+		c.renderError(w, r, err)
+		return
+	}
+	c.Refresh(w, r)
+}
+
+// importWorkspaceTemplate handles POST /templates/import with the
+// downloaded JSON document uploaded as the document file.
+func (c *WorkbenchController) importWorkspaceTemplate(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxTemplateUpload)
+	file, _, err := r.FormFile("document")
+	if err != nil {
+		c.renderError(w, r, invalidInput("Choose a template file to import"))
+		return
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		c.renderError(w, r, &internal.AppError{Code: internal.CodeInvalidInput, UserMessage: "Failed to read template file", Err: err})
+		return
+	}
+	if _, err := internal.ImportWorkspaceTemplate(data); err != nil {
+		c.renderError(w, r, err)
+		return
+	}
+	c.Refresh(w, r)
+}
+
+// downloadWorkspaceTemplate handles GET /templates/download/{id}.
+func (c *WorkbenchController) downloadWorkspaceTemplate(w http.ResponseWriter, r *http.Request) {
+	template, err := internal.GetWorkspaceTemplate(r.PathValue("id"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.json"`, templateFilename(template.Name)))
+	io.WriteString(w, template.Document)
+}
+
+// templateFilename turns a template name into a safe download filename.
+func templateFilename(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' {
+			return r
+		}
+		return '-'
+	}, name)
+	return "workspace-" + strings.Trim(name, "-")
+}
+
+// previewWorkspaceTemplate handles POST /templates/preview/{id} to list
+// what applying a template would add, change, or skip before confirming.
+func (c *WorkbenchController) previewWorkspaceTemplate(w http.ResponseWriter, r *http.Request) {
+	template, changes, err := internal.PreviewWorkspaceTemplate(r.PathValue("id"))
+	if err != nil {
+		c.renderError(w, r, err)
+		return
+	}
+	c.Render(w, r, "workspace-template-changes.html", map[string]any{
+		"Template": template,
+		"Changes":  changes,
+	})
+}
+
+// applyWorkspaceTemplate handles POST /templates/apply/{id}. Renders the
+// result of every change, including failures.
+func (c *WorkbenchController) applyWorkspaceTemplate(w http.ResponseWriter, r *http.Request) {
+	template, changes, err := internal.ApplyWorkspaceTemplate(r.PathValue("id"))
+	if err != nil {
+		c.renderError(w, r, err)
+		return
+	}
+	c.Render(w, r, "workspace-template-changes.html", map[string]any{
+		"Template": template,
+		"Changes":  changes,
+		"Applied":  true,
+	})
+}
+
+// deleteWorkspaceTemplate handles POST /templates/delete/{id}.
+func (c *WorkbenchController) deleteWorkspaceTemplate(w http.ResponseWriter, r *http.Request) {
+	if err := internal.DeleteWorkspaceTemplate(r.PathValue("id")); err != nil {
+		c.renderError(w, r, err)
+		return
+	}
+	c.Refresh(w, r)
+}
+
+// repairPermissions handles POST /diagnostics/permissions to check and
+// repair ownership of the directories mounted into the coder container.
+func (c *WorkbenchController) repairPermissions(w http.ResponseWriter, r *http.Request) {
+	issues, err := internal.RepairCoderPermissions()
+	if err != nil {
+		c.renderError(w, r, err)
+		return
+	}
+	c.Render(w, r, "permissions-result.html", issues)
+}
+
+// renderError renders a sanitized error message with a correlation ID
+// into the error region, with the status its code calls for. The original
+// error is logged in full under that ID and never reaches the HTML.
+// Errors with a troubleshooting page link to it, about the repository in
+// the path if there is one.
+func (c *WorkbenchController) renderError(w http.ResponseWriter, r *http.Request, err error) {
+	c.Render(w, r, "error-message.html", presentError(w, r, err))
+}
+
+// errorHelp handles GET /help/{kind}?repo= to show troubleshooting steps
+// with live checks. Records nothing, so it's always safe to open.
+func (c *WorkbenchController) errorHelp(w http.ResponseWriter, r *http.Request) {
+	help, err := internal.GetHelpContext(r.PathValue("kind"), r.URL.Query().Get("repo"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	c.Render(w, r, "error-help.html", help)
+}
+
+// restartCoder handles POST /diagnostics/coder/restart from the
+// coder-down troubleshooting page.
+func (c *WorkbenchController) restartCoder(w http.ResponseWriter, r *http.Request) {
+	if err := internal.RestartCoder(); err != nil {
+		c.renderError(w, r, err)
+		return
+	}
+	c.Refresh(w, r)
+}
+
+// timezoneCookie holds the browser's timezone, set by the layout's script.
+const timezoneCookie = "tz"
+
+// requestTimezone resolves the timezone timestamps are shown in for a
+// request: the "display_timezone" preference, then the tz cookie, then the
+// X-User-Timezone header htmx requests carry, then UTC.
+func requestTimezone(r *http.Request) *time.Location {
+	preference, _ := models.GetSetting("display_timezone")
+	if r == nil {
+		return i18n.ResolveTimezone(preference, "", "")
+	}
+	var cookie string
+	if c, err := r.Cookie(timezoneCookie); err == nil {
+		cookie, _ = url.QueryUnescape(c.Value)
+	}
+	return i18n.ResolveTimezone(preference, cookie, r.Header.Get("X-User-Timezone"))
+}
+
+// requestLocale resolves the display locale for a request.
+// The "locale" user preference overrides the browser's Accept-Language.
+func requestLocale(r *http.Request) *i18n.Locale {
+	preference, _ := models.GetSetting("locale")
+	if r == nil {
+		return i18n.Resolve(preference, "")
+	}
+	return i18n.Resolve(preference, r.Header.Get("Accept-Language"))
+}
+
+// ============================================================================
+// Template Helper Methods - Accessible in views as {{workbench.MethodName}}
+// ============================================================================
+
+// GetActivityPage returns a page of the activity log, newest first,
+// filtered by the page, per_page, type, and repository query parameters.
+// When digest mode is on, routine background events are hidden unless
+// the request asks for them with ?all=true or filters by their type.
+// Template usage: {{with workbench.GetActivityPage}}...{{end}}
+func (c *WorkbenchController) GetActivityPage() *internal.ActivityPage {
+	q := internal.ParseActivityQuery(c.URL.Query())
+	page, err := internal.GetActivityPage(q)
+	if err != nil {
+		slog.Error("failed to fetch activities", "error", err)
+		return &internal.ActivityPage{ActivityQuery: q}
+	}
+	return page
+}
+
+// GetActivityTypes returns the distinct activity types recorded, for the
+// activity log's type filter.
+// Template usage: {{range workbench.GetActivityTypes}}...{{end}}
+func (c *WorkbenchController) GetActivityTypes() []string {
+	types, err := models.ActivityTypes()
+	if err != nil {
+		slog.Error("failed to fetch activity types", "error", err)
+	}
+	return types
+}
+
+// HidesRoutineActivity returns true if routine events are being left out
+// of the activity view because digest mode summarizes them.
+// Template usage: {{if workbench.HidesRoutineActivity}}...{{end}}
+func (c *WorkbenchController) HidesRoutineActivity() bool {
+	if c.QueryParam("all") == "true" || c.QueryParam("type") != "" {
+		return false
+	}
+	return internal.HideRoutineActivity()
+}
+
+// GetActivity returns the activity named by the {id} path parameter.
+// Template usage: {{with workbench.GetActivity}}...{{end}}
+func (c *WorkbenchController) GetActivity() *models.Activity {
+	activity, err := models.Activities.Get(c.PathValue("id"))
+	if err != nil {
+		return nil
+	}
+	return activity
+}
+
+// GetActivityActions returns the quick actions for an activity entry,
+// rendered as buttons, and whether the repository it refers to has since
+// been deleted.
+// Template usage: {{template "activity-actions.html" (workbench.GetActivityActions .)}}
+func (c *WorkbenchController) GetActivityActions(activity *models.Activity) map[string]any {
+	actions, deleted := internal.ActivityActions(activity, c.repositoriesByName())
+	return map[string]any{
+		"Actions":     actions,
+		"RepoDeleted": deleted,
+	}
+}
+
+// GetActivityMenu returns the same quick actions as GetActivityActions,
+// rendered as menu items for a feed row's dropdown.
+// Template usage: {{template "activity-actions.html" (workbench.GetActivityMenu .)}}
+func (c *WorkbenchController) GetActivityMenu(activity *models.Activity) map[string]any {
+	menu := c.GetActivityActions(activity)
+	menu["Menu"] = true
+	return menu
+}
+
+// repositoriesByName loads the repositories once per request, so actions
+// for every row of the feed share one query.
+func (c *WorkbenchController) repositoriesByName() map[string]*models.Repository {
+	if c.repos != nil {
+		return c.repos
+	}
+	repos, err := internal.RepositoriesByName()
+	if err != nil {
+		slog.Error("failed to load repositories", "error", err)
+	}
+	if c.Request != nil {
+		c.repos = repos // Only request copies are memoized
+	}
+	return repos
+}
+
+// GetRepoStatus returns the branch and working tree status of the
+// repository named in the request path. Keys: Name, Status, Error, and
+// Repo when the directory is missing.
+// Template usage: {{with workbench.GetRepoStatus}}...{{end}}
+func (c *WorkbenchController) GetRepoStatus() map[string]any {
+	name := c.PathValue("name")
+	panel := map[string]any{"Name": name}
+	status, err := internal.GetRepoStatus(name)
+	if err != nil {
+		panel["Error"] = internal.PresentError(err)
+		return panel
+	}
+	panel["Status"] = status
+	if status.Missing {
+		panel["Repo"], _ = models.Repositories.Find("WHERE Name = ?", name)
+	}
+	return panel
+}
+
+// GetMergeState returns the merge or rebase in progress in the
+// repository named in the request path. Keys: Name, State, Error.
+// Template usage: {{with workbench.GetMergeState}}...{{end}}
+func (c *WorkbenchController) GetMergeState() map[string]any {
+	name := c.PathValue("name")
+	panel := map[string]any{"Name": name}
+	state, err := internal.GetMergeState(name)
+	if err != nil {
+		panel["Error"] = internal.PresentError(err)
+		return panel
+	}
+	panel["State"] = state
+	return panel
+}
+
+// GetRepoBranches returns the branches of the repository named in the
+// request path. Keys: Name, Branches, Error.
+// Template usage: {{with workbench.GetRepoBranches}}...{{end}}
+func (c *WorkbenchController) GetRepoBranches() map[string]any {
+	name := c.PathValue("name")
+	panel := map[string]any{"Name": name}
+	branches, err := internal.ListBranches(name)
+	if err != nil {
+		panel["Error"] = internal.PresentError(err)
+		return panel
+	}
+	panel["Branches"] = branches
+	return panel
+}
+
+// GetRepoStashes returns the stashes of the repository named in the
+// request path, newest first. Keys: Name, Stashes, Error.
+// Template usage: {{with workbench.GetRepoStashes}}...{{end}}
+func (c *WorkbenchController) GetRepoStashes() map[string]any {
+	name := c.PathValue("name")
+	panel := map[string]any{"Name": name}
+	stashes, err := internal.ListStashes(name)
+	if err != nil {
+		panel["Error"] = internal.PresentError(err)
+		return panel
+	}
+	panel["Stashes"] = stashes
+	return panel
+}
+
+// GetRepoDiff returns the uncommitted changes of the repository named in
+// the request path, with each file's staged and unstaged diffs. Keys:
+// Name, Files, MaxKB (the per-file cap), Error.
+// Template usage: {{with workbench.GetRepoDiff}}...{{end}}
+func (c *WorkbenchController) GetRepoDiff() map[string]any {
+	name := c.PathValue("name")
+	maxSize := internal.DiffMaxFileSize()
+	panel := map[string]any{"Name": name, "MaxKB": maxSize / 1024}
+	files, err := internal.GetDiffView(name, maxSize)
+	if err != nil {
+		panel["Error"] = internal.PresentError(err)
+		return panel
+	}
+	requester, ip := c.currentUserHandle(), clientIP(c.Request)
+	for _, file := range files {
+		recordAccess("diff", name, file.Path, diffBytes(file), requester, "session", ip)
+	}
+	panel["Files"] = files
+	return panel
+}
+
+// diffBytes returns the size of the diff lines shown for a file.
+func diffBytes(file *internal.FileDiff) uint64 {
+	var n uint64
+	for _, section := range file.Sections {
+		n += sectionBytes(section)
+	}
+	return n
+}
+
+// sectionBytes returns the size of a diff section's lines.
+func sectionBytes(section *internal.DiffSection) uint64 {
+	var n uint64
+	for _, line := range section.Lines {
+		n += uint64(len(line.Text))
+	}
+	return n
+}
+
+// GetRepoTags returns the tags of the repository named in the request
+// path, newest first. Keys: Name, Tags, Error.
+// Template usage: {{with workbench.GetRepoTags}}...{{end}}
+func (c *WorkbenchController) GetRepoTags() map[string]any {
+	name := c.PathValue("name")
+	panel := map[string]any{"Name": name}
+	tags, err := internal.ListTags(name)
+	if err != nil {
+		panel["Error"] = internal.PresentError(err)
+		return panel
+	}
+	panel["Tags"] = tags
+	return panel
+}
+
+// GetRepoRemotes returns the remotes of the repository named in the
+// request path, origin first. Keys: Name, Remotes, Error.
+// Template usage: {{with workbench.GetRepoRemotes}}...{{end}}
+func (c *WorkbenchController) GetRepoRemotes() map[string]any {
+	name := c.PathValue("name")
+	panel := map[string]any{"Name": name}
+	remotes, err := internal.ListRemotes(name)
+	if err != nil {
+		panel["Error"] = internal.PresentError(err)
+		return panel
+	}
+	panel["Remotes"] = remotes
+	return panel
+}
+
+// Search matches query against repository names, URLs, and descriptions,
+// activity descriptions, and visible setting keys, grouped by category.
+// The category in the more query parameter is shown in full. Keys: Query,
+// Groups (empty for queries under two characters), Error.
+// Template usage: {{with workbench.Search (workbench.QueryParam "q")}}...{{end}}
+func (c *WorkbenchController) Search(query string) map[string]any {
+	panel := map[string]any{"Query": strings.TrimSpace(query)}
+	groups, err := internal.Search(query, c.QueryParam("more"))
+	if err != nil {
+		panel["Error"] = internal.PresentError(err)
+		return panel
+	}
+	panel["Groups"] = groups
+	return panel
+}
+
+// GetRepoFiles returns the directory of the repository named in the
+// request path at the path query parameter, the root by default. Keys:
+// Name, Path ("" at the root), Parent, Entries, Error.
+// Template usage: {{with workbench.GetRepoFiles}}...{{end}}
+func (c *WorkbenchController) GetRepoFiles() map[string]any {
+	name := c.PathValue("name")
+	dir := strings.Trim(path.Clean("/"+c.QueryParam("path")), "/")
+	panel := map[string]any{"Name": name, "Path": dir}
+	if dir != "" {
+		panel["Parent"] = strings.TrimSuffix(path.Dir(dir), ".")
+	}
+	entries, err := internal.ListRepoFiles(name, dir)
+	if err != nil {
+		panel["Error"] = internal.PresentError(err)
+		return panel
+	}
+	panel["Entries"] = entries
+	return panel
+}
+
+// GetRepoFile returns the file of the repository named in the request
+// path at the path query parameter, for reading. Keys: Name, Path, Tab,
+// Content (empty for binary files), Binary, Size, Error.
+// Template usage: {{with workbench.GetRepoFile}}...{{end}}
+func (c *WorkbenchController) GetRepoFile() map[string]any {
+	name := c.PathValue("name")
+	panel := map[string]any{"Name": name, "Path": c.QueryParam("path"), "Tab": "file"}
+	file, err := internal.ReadRepoFile(name, c.QueryParam("path"), internal.MaxRepoFileSize)
+	if err != nil {
+		panel["Error"] = internal.PresentError(err)
+		return panel
+	}
+	recordAccess("file", name, file.Path, uint64(len(file.Content)), c.currentUserHandle(), "session", clientIP(c.Request))
+	panel["Path"] = file.Path
+	panel["Binary"] = file.Binary
+	panel["Size"] = uint64(len(file.Content))
+	if !file.Binary {
+		panel["Content"] = string(file.Content)
+	}
+	return panel
+}
+
+// GetFileHistory returns the commits that changed the file of the
+// repository named in the request path at the path query parameter,
+// newest first. Keys: Name, Path, Tab, Revisions, Error.
+// Template usage: {{with workbench.GetFileHistory}}...{{end}}
+func (c *WorkbenchController) GetFileHistory() map[string]any {
+	name := c.PathValue("name")
+	panel := map[string]any{"Name": name, "Path": c.QueryParam("path"), "Tab": "history"}
+	revisions, err := internal.GetFileHistory(name, c.QueryParam("path"), internal.DefaultFileHistoryLimit)
+	if err != nil {
+		panel["Error"] = internal.PresentError(err)
+		return panel
+	}
+	panel["Revisions"] = revisions
+	return panel
+}
+
+// GetFileBlame returns the blame of the file of the repository named in
+// the request path at the path query parameter. Keys: Name, Path, Tab,
+// Hunks, Error.
+// Template usage: {{with workbench.GetFileBlame}}...{{end}}
+func (c *WorkbenchController) GetFileBlame() map[string]any {
+	name := c.PathValue("name")
+	panel := map[string]any{"Name": name, "Path": c.QueryParam("path"), "Tab": "blame"}
+	blame, err := internal.GetFileBlame(name, c.QueryParam("path"), 0, 0)
+	if err != nil {
+		panel["Error"] = internal.PresentError(err)
+		return panel
+	}
+	panel["Path"] = blame.Path
+	panel["Hunks"] = blame.Hunks
+	return panel
+}
+
+// GetCommitLog returns a page of commits of the repository named in the
+// request path, using the limit and offset query parameters. Keys: Name,
+// Commits, Error, Limit, Offset, PreviousOffset, NextOffset, HasMore.
+// Template usage: {{with workbench.GetCommitLog}}...{{end}}
+func (c *WorkbenchController) GetCommitLog() map[string]any {
+	name := c.PathValue("name")
+	limit, err := strconv.Atoi(c.QueryParam("limit"))
+	if err != nil || limit <= 0 {
+		limit = internal.DefaultCommitLogLimit
+	}
+	limit = min(limit, internal.MaxCommitLogLimit)
+	offset, _ := strconv.Atoi(c.QueryParam("offset"))
+	offset = max(offset, 0)
+
+	panel := map[string]any{
+		"Name":           name,
+		"Limit":          limit,
+		"Offset":         offset,
+		"PreviousOffset": max(offset-limit, 0),
+		"NextOffset":     offset + limit,
+	}
+	commits, hasMore, err := internal.GetCommitLog(name, limit, offset)
+	if err != nil {
+		panel["Error"] = internal.PresentError(err)
+		return panel
+	}
+	panel["HasMore"] = hasMore
+	panel["Commits"] = commits
+	return panel
+}
+
+// GetScaffoldTemplates returns the built-in project templates.
+// Template usage: {{range workbench.GetScaffoldTemplates}}...{{end}}
+func (c *WorkbenchController) GetScaffoldTemplates() []*internal.ScaffoldTemplate {
+	return internal.ScaffoldTemplates()
+}
+
+// GetRepoTemplates returns the starter template repositories.
+// Template usage: {{range workbench.GetRepoTemplates}}...{{end}}
+func (c *WorkbenchController) GetRepoTemplates() []*models.RepoTemplate {
+	templates, err := internal.GetRepoTemplates()
+	if err != nil {
+		slog.Error("failed to load starter templates", "error", err)
+	}
+	return templates
+}
+
+// GetRepoTemplateFields returns the placeholders declared by the starter
+// template named by the ?template= query parameter.
+// Template usage: {{range workbench.GetRepoTemplateFields}}...{{end}}
+func (c *WorkbenchController) GetRepoTemplateFields() []internal.TemplateVariable {
+	template, err := internal.GetRepoTemplate(c.URL.Query().Get("template"))
+	if err != nil {
+		return nil
+	}
+	return internal.RepoTemplateVariables(template)
+}
+
+// GetScaffoldFiles returns the user's always-include and override files.
+// Template usage: {{range workbench.GetScaffoldFiles}}...{{end}}
+func (c *WorkbenchController) GetScaffoldFiles() []*models.ScaffoldFile {
+	files, err := models.ScaffoldFiles.Search("ORDER BY Template ASC, Path ASC")
+	if err != nil {
+		return nil
+	}
+	return files
+}
+
+// GetDefaultBranch returns the branch new projects are created on.
+// Template usage: {{workbench.GetDefaultBranch}}
+func (c *WorkbenchController) GetDefaultBranch() string {
+	return internal.DefaultBranch()
+}
+
+// GetScaffoldPreview returns the files a new project would contain for
+// the ?template= query parameter (defaults to "empty").
+// Template usage: {{range workbench.GetScaffoldPreview}}...{{end}}
+func (c *WorkbenchController) GetScaffoldPreview() []*internal.ScaffoldEntry {
+	template := c.URL.Query().Get("template")
+	if template == "" {
+		template = "empty"
+	}
+	entries, err := internal.ScaffoldPreview(template)
+	if err != nil {
+		return nil
+	}
+	return entries
+}
+
+// GetDigestSettings returns the activity digest and notification channel
+// configuration for the preferences form. Keys: Mode, Time, QuietStart,
+// QuietEnd, Timezone, Webhook, and "true" or "false" for SecretSet, InApp,
+// WebhookOn, and Email. The webhook secret itself is never included.
+// Template usage: {{with workbench.GetDigestSettings}}...{{end}}
+func (c *WorkbenchController) GetDigestSettings() map[string]string {
+	at := models.SettingOrDefault("activity_digest_time")
+	quietStart, _ := models.GetSetting("digest_quiet_start")
+	quietEnd, _ := models.GetSetting("digest_quiet_end")
+	timezone, _ := models.GetSetting("timezone")
+	webhookSecret, _ := models.GetSetting("notify_webhook_secret") // Never sent back, only SecretSet
+
+	return map[string]string{
+		"Mode":       internal.DigestMode(),
+		"Time":       at,
+		"QuietStart": quietStart,
+		"QuietEnd":   quietEnd,
+		"Timezone":   timezone,
+		"Webhook":    internal.NotifyWebhookURL(),
+		"SecretSet":  strconv.FormatBool(webhookSecret != ""),
+		"InApp":      strconv.FormatBool(models.GetBoolSetting("notify_in_app")),
+		"WebhookOn":  strconv.FormatBool(models.GetBoolSetting("notify_webhook")),
+		"Email":      strconv.FormatBool(models.GetBoolSetting("notify_email")),
+	}
+}
+
+// GetSMTPSettings returns the email channel's settings for the
+// preferences form. The password is never included, only PasswordSet.
+// Template usage: {{with workbench.GetSMTPSettings}}{{.Host}}{{end}}
+func (c *WorkbenchController) GetSMTPSettings() internal.SMTPSettings {
+	return internal.GetSMTPSettings()
+}
+
+// GetNotifications returns the header bell's in-app notifications.
+// Keys: Recent (the newest 10), Unread, Error.
+// Template usage: {{with workbench.GetNotifications}}{{.Unread}}{{end}}
+func (c *WorkbenchController) GetNotifications() map[string]any {
+	recent, unread, err := internal.GetNotifications(10)
+	if err != nil {
+		return map[string]any{"Error": internal.PresentError(err)}
+	}
+	return map[string]any{"Recent": recent, "Unread": unread}
+}
+
+// GetWeeklyStats returns the activity of the last 7 days, today included,
+// with days in the user's timezone. Keys: Stats, Error.
+// Template usage: {{with workbench.GetWeeklyStats}}{{range .Stats.Days}}...{{end}}{{end}}
+func (c *WorkbenchController) GetWeeklyStats() map[string]any {
+	today := time.Now().In(c.userTimezone())
+	stats, err := internal.GetActivityStats(today.AddDate(0, 0, -6), today)
+	if err != nil {
+		return map[string]any{"Error": internal.PresentError(err)}
+	}
+	return map[string]any{"Stats": stats}
+}
+
+// GetRepositories returns all cloned repositories in dashboard sections:
+// the pinned ones, then each group, then the ungrouped ones, each sorted
+// alphabetically. With ?repo_group= only that group's repositories are
+// listed.
+// Template usage: {{range workbench.GetRepositories}}{{range .Repos}}...{{end}}{{end}}
+func (c *WorkbenchController) GetRepositories() []*internal.RepoSection {
+	repos, _ := models.Repositories.Search("ORDER BY Name ASC")
+	if group := c.RepositoryGroupFilter(); group != "" {
+		repos = slices.DeleteFunc(repos, func(repo *models.Repository) bool { return repo.GroupName != group })
+	}
+	return internal.SectionRepositories(repos)
+}
+
+// GetRepositoryGroups returns the distinct repository groups, for the
+// group filter and the group picker. Groups disappear with their last
+// repository.
+// Template usage: {{range workbench.GetRepositoryGroups}}...{{end}}
+func (c *WorkbenchController) GetRepositoryGroups() []string {
+	repos, _ := models.Repositories.Search("WHERE GroupName != ''")
+	return internal.RepositoryGroups(repos)
+}
+
+// RepositoryGroupFilter returns the group the repository list is
+// filtered to (?repo_group=), or empty string for every group.
+// Template usage: {{with workbench.RepositoryGroupFilter}}...{{end}}
+func (c *WorkbenchController) RepositoryGroupFilter() string {
+	return c.QueryParam("repo_group")
+}
+
+// ErrorHelp returns the error rendered by error-message.html when it has a
+// troubleshooting page, or nil for plain messages.
+// Template usage: {{with workbench.ErrorHelp .}}{{.HelpURL}}{{end}}
+func (c *WorkbenchController) ErrorHelp(message any) *internal.UserError {
+	if presented, ok := message.(*internal.UserError); ok && presented.HelpURL() != "" {
+		return presented
+	}
+	return nil
+}
+
+// ErrorRetryable reports whether the error rendered by error-message.html
+// offers to send the failed request again. Plain messages never do.
+// Template usage: {{if workbench.ErrorRetryable .}}...{{end}}
+func (c *WorkbenchController) ErrorRetryable(message any) bool {
+	presented, ok := message.(*internal.UserError)
+	return ok && presented.Retryable
+}
+
+// GetStorageLocations returns the configured storage locations, loaded
+// once per request since every repository row offers them.
+// Template usage: {{range workbench.GetStorageLocations}}...{{end}}
+func (c *WorkbenchController) GetStorageLocations() []*models.StorageLocation {
+	if c.locations != nil {
+		return c.locations
+	}
+	locations, err := internal.GetStorageLocations()
+	if err != nil {
+		slog.Error("failed to load storage locations", "error", err)
+	}
+	c.locations = append([]*models.StorageLocation{}, locations...)
+	return c.locations
+}
+
+// GetExtensions returns the installed and kept VS Code extensions. Keys:
+// Extensions, Error.
+// Template usage: {{with workbench.GetExtensions}}...{{end}}
+func (c *WorkbenchController) GetExtensions() map[string]any {
+	panel := map[string]any{}
+	extensions, err := internal.GetExtensions()
+	if err != nil {
+		panel["Error"] = internal.PresentError(err)
+		return panel
+	}
+	panel["Extensions"] = extensions
+	return panel
+}
+
+// GetPortForwards returns the port forwards panel. Keys: Forwards, Error.
+// Template usage: {{with workbench.GetPortForwards}}...{{end}}
+func (c *WorkbenchController) GetPortForwards() map[string]any {
+	panel := map[string]any{}
+	forwards, err := internal.GetPortForwards()
+	if err != nil {
+		panel["Error"] = internal.PresentError(err)
+		return panel
+	}
+	panel["Forwards"] = forwards
+	return panel
+}
+
+// GetSSHKeys returns the named SSH keys with their host mappings.
+// Template usage: {{range workbench.GetSSHKeys}}...{{end}}
+func (c *WorkbenchController) GetSSHKeys() []*models.SSHKey {
+	keys, err := internal.GetSSHKeys()
+	if err != nil {
+		slog.Error("failed to load SSH keys", "error", err)
+	}
+	return keys
+}
+
+// GetGitCredentials returns the hosts with saved access tokens. Tokens
+// stay encrypted.
+// Template usage: {{range workbench.GetGitCredentials}}{{.Host}}{{end}}
+func (c *WorkbenchController) GetGitCredentials() []*models.GitCredential {
+	creds, err := internal.GetGitCredentials()
+	if err != nil {
+		slog.Error("failed to load git credentials", "error", err)
+	}
+	return creds
+}
+
+// GetStorageLocationUsage returns each storage location with the disk
+// usage of the filesystem behind it.
+// Template usage: {{range workbench.GetStorageLocationUsage}}...{{end}}
+func (c *WorkbenchController) GetStorageLocationUsage() []*internal.StorageLocationUsage {
+	return internal.GetStorageLocationUsage()
+}
+
+// GetGitIdentity returns the configured git identity, or nil when commits
+// would be blocked.
+// Template usage: {{with workbench.GetGitIdentity}}{{.Name}}{{end}}
+func (c *WorkbenchController) GetGitIdentity() *internal.GitIdentity {
+	return internal.GetGitIdentity()
+}
+
+// GetGitIdentityStatus returns the saved git identity next to the
+// container's, for spotting drift.
+// Template usage: {{with workbench.GetGitIdentityStatus}}{{if .Drifted}}...{{end}}{{end}}
+func (c *WorkbenchController) GetGitIdentityStatus() *internal.GitIdentityStatus {
+	return internal.CheckGitIdentity()
+}
+
+// GetGitIdentitySuggestion returns the identity inferred after a clone,
+// waiting for the user to confirm it, or nil.
+// Template usage: {{with workbench.GetGitIdentitySuggestion}}{{.String}}{{end}}
+func (c *WorkbenchController) GetGitIdentitySuggestion() *internal.GitIdentity {
+	return internal.PendingGitIdentity()
+}
+
+// GetWorkspaceTemplates returns the saved workspace templates.
+// Template usage: {{range workbench.GetWorkspaceTemplates}}...{{end}}
+func (c *WorkbenchController) GetWorkspaceTemplates() []*models.WorkspaceTemplate {
+	templates, err := internal.GetWorkspaceTemplates()
+	if err != nil {
+		slog.Error("failed to load workspace templates", "error", err)
+	}
+	return templates
+}
+
+// HasAPIToken reports whether an API token has been generated.
+// Template usage: {{if workbench.HasAPIToken}}...{{end}}
+func (c *WorkbenchController) HasAPIToken() bool {
+	return internal.HasAPIToken()
+}
+
+// HasMetricsToken reports whether /metrics requires a bearer token.
+// Template usage: {{if workbench.HasMetricsToken}}...{{end}}
+func (c *WorkbenchController) HasMetricsToken() bool {
+	return internal.HasMetricsToken()
+}
+
+// GetSessions returns the signed-in sessions. Keys: Sessions, CurrentID
+// (the session viewing the page), Error.
+// Template usage: {{with workbench.GetSessions}}...{{end}}
+func (c *WorkbenchController) GetSessions() map[string]any {
+	panel := map[string]any{}
+	sessions, err := internal.GetSessions()
+	if err != nil {
+		panel["Error"] = internal.PresentError(err)
+		return panel
+	}
+	panel["Sessions"] = sessions
+	panel["CurrentID"] = currentSessionID(c.Request)
+	return panel
+}
+
+// GetShares returns the share links, newest first. Keys: Shares, Enabled
+// (the share_links setting), Error.
+// Template usage: {{with workbench.GetShares}}...{{end}}
+func (c *WorkbenchController) GetShares() map[string]any {
+	panel := map[string]any{"Enabled": internal.SharingEnabled()}
+	shares, err := internal.GetShares()
+	if err != nil {
+		panel["Error"] = internal.PresentError(err)
+		return panel
+	}
+	panel["Shares"] = shares
+	return panel
+}
+
+// SharingEnabled reports whether share links can be created, per the
+// share_links setting.
+// Template usage: {{if workbench.SharingEnabled}}...{{end}}
+func (c *WorkbenchController) SharingEnabled() bool {
+	return internal.SharingEnabled()
+}
+
+// ShareStatus returns whether a share link is active, revoked, or
+// expired.
+// Template usage: {{workbench.ShareStatus .}}
+func (c *WorkbenchController) ShareStatus(share *models.Share) string {
+	return internal.ShareStatus(share)
+}
+
+// DescribeShare names what a share link shows, e.g. "main.go at 3f9c2ab".
+// Template usage: {{workbench.DescribeShare .}}
+func (c *WorkbenchController) DescribeShare(share *models.Share) string {
+	return internal.DescribeShare(share)
+}
+
+// GetLockouts returns the clients locked out of signing in, soonest to
+// expire first.
+// Template usage: {{range workbench.GetLockouts}}...{{end}}
+func (c *WorkbenchController) GetLockouts() []*internal.Lockout {
+	return internal.AuthRateLimiter.Lockouts()
+}
+
+// GetInstanceIdentity returns the instance ID and key fingerprint shown
+// in the preferences, or nil when the identity can't be loaded.
+// Template usage: {{template "instance-identity.html" workbench.GetInstanceIdentity}}
+func (c *WorkbenchController) GetInstanceIdentity() *internal.InstanceIdentity {
+	identity, err := internal.GetInstanceIdentity("")
+	if err != nil {
+		slog.Error("failed to load instance identity", "error", err)
+		return nil
+	}
+	return identity
+}
+
+// GetRepositorySizes returns each repository's disk usage, largest
+// first. Repositories whose directory is missing are last, unavailable.
+// Template usage: {{range workbench.GetRepositorySizes}}...{{end}}
+func (c *WorkbenchController) GetRepositorySizes() []*internal.RepoSize {
+	sizes, err := internal.GetRepositorySizes()
+	if err != nil {
+		slog.Error("failed to measure repository sizes", "error", err)
+	}
+	return sizes
+}
+
+// GetRepoQuota returns the repositories' disk usage against the
+// repos_quota_mb setting, or nil when it can't be measured.
+// Template usage: {{with workbench.GetRepoQuota}}{{if .Exceeded}}...{{end}}{{end}}
+func (c *WorkbenchController) GetRepoQuota() *internal.RepoQuota {
+	quota, err := internal.GetRepoQuota()
+	if err != nil {
+		slog.Error("failed to check repository quota", "error", err)
+		return nil
+	}
+	return quota
+}
+
+// GetTotalReposSize returns the disk usage of all repositories together.
+// Template usage: {{monitoring.FormatBytes workbench.GetTotalReposSize}}
+func (c *WorkbenchController) GetTotalReposSize() uint64 {
+	return internal.TotalRepoSize(c.GetRepositorySizes())
+}
+
+// GetCloneQueue returns the clones waiting, running, and failed in the
+// clone queue, oldest first.
+// Template usage: {{range workbench.GetCloneQueue}}...{{end}}
+func (c *WorkbenchController) GetCloneQueue() []internal.QueuedClone {
+	return internal.GetCloneQueue()
+}
+
+// GetBackgroundJobs returns every registered background job with its
+// schedule, state, and last result.
+// Template usage: {{range workbench.GetBackgroundJobs}}...{{end}}
+func (c *WorkbenchController) GetBackgroundJobs() []*internal.JobStatus {
+	return internal.GetJobStatuses()
+}
+
+// GetSettingGroups returns registered settings grouped by category, then
+// saved keys the registry doesn't know, filtered by the request's q and
+// category query parameters.
+// Template usage: {{range workbench.GetSettingGroups}}...{{end}}
+func (c *WorkbenchController) GetSettingGroups() []*internal.SettingGroup {
+	return internal.SettingGroups(c.QueryParam("q"), c.QueryParam("category"))
+}
+
+// GetSettingCategories returns the categories the settings page filters by.
+// Template usage: {{range workbench.GetSettingCategories}}...{{end}}
+func (c *WorkbenchController) GetSettingCategories() []string {
+	return internal.SettingCategories()
+}
+
+// GetMissingRepoBehavior returns what pulls do when a repository
+// directory is missing.
+// Template usage: {{if eq workbench.GetMissingRepoBehavior "prompt"}}selected{{end}}
+func (c *WorkbenchController) GetMissingRepoBehavior() string {
+	return internal.MissingRepoBehavior()
+}
+
+// GetAutoPullInterval returns the background pull interval in minutes,
+// zero when auto-pull is off.
+// Template usage: {{if eq workbench.GetAutoPullInterval 60}}selected{{end}}
+func (c *WorkbenchController) GetAutoPullInterval() int {
+	return int(internal.AutoPullInterval() / time.Minute)
+}
+
+// GroupByHost reports whether the repository list is grouped by host
+// (?group=host).
+// Template usage: {{if workbench.GroupByHost}}...{{end}}
+func (c *WorkbenchController) GroupByHost() bool {
+	return c.QueryParam("group") == "host"
+}
+
+// GetHostGroups returns repositories grouped by host, with the number of
+// repositories, pull errors, and last successful pull per group.
+// Template usage: {{range workbench.GetHostGroups}}...{{end}}
+func (c *WorkbenchController) GetHostGroups() []*internal.HostGroup {
+	groups, err := internal.RepositoriesByHost()
+	if err != nil {
+		slog.Error("failed to group repositories", "error", err)
+	}
+	return groups
+}
+
+// GetSetupSteps returns setup suggestions that haven't been dismissed
+// for the repository named in the request path.
+// Template usage: {{range workbench.GetSetupSteps}}...{{end}}
+func (c *WorkbenchController) GetSetupSteps() []*internal.SetupStep {
+	steps, err := internal.DetectSetupSteps(c.PathValue("name"))
+	if err != nil {
+		return nil
+	}
+
+	var visible []*internal.SetupStep
+	for _, step := range steps {
+		if !step.Dismissed {
+			visible = append(visible, step)
+		}
+	}
+	return visible
+}
+
+// GetEditableFile loads the file named by the ?path= query parameter for
+// the inline editor. Keys: File (*internal.EditableFile) or Error.
+// Template usage: {{with workbench.GetEditableFile}}...{{end}}
+func (c *WorkbenchController) GetEditableFile() map[string]any {
+	path := c.URL.Query().Get("path")
+	if path == "" {
+		return nil
+	}
+
+	file, err := internal.ReadFileForEdit(c.PathValue("name"), path)
+	if err != nil {
+		return map[string]any{"Error": internal.PresentError(err)}
+	}
+
+	recordAccess("edit", file.Repository, file.Path, uint64(len(file.Content)),
+		c.currentUserHandle(), "session", clientIP(c.Request))
+	return map[string]any{"File": file}
+}
+
+// currentUserHandle returns the signed-in user's handle for auditing.
+func (c *WorkbenchController) currentUserHandle() string {
+	auth := c.Use("auth").(*AuthController).Handle(c.Request).(*AuthController)
+	if user := auth.CurrentUser(); user != nil {
+		return user.Handle
+	}
+	return ""
+}
+
+// Access auditing seams. Replaced in tests.
+var (
+	recordAccess  = internal.RecordAccess
+	checkAPIToken = internal.CheckAPIToken
+)
+
+// accessRequester names who made r for the access audit: the API token
+// when r carries it, or else the signed-in user.
+func (c *WorkbenchController) accessRequester(r *http.Request) (requester, requesterType string) {
+	if token, ok := bearerToken(r); ok && checkAPIToken(token) {
+		return "api-token", "token"
+	}
+	return c.Handle(r).(*WorkbenchController).currentUserHandle(), "session"
+}
+
+// GetPullRequests returns the open pull requests panel for the repository
+// named in the request path. Returns nil when the origin host isn't a
+// recognized provider so the panel is hidden entirely.
+// Keys: Provider, PullRequests, NotConfigured, RateLimitReset, Error.
+// Template usage: {{with workbench.GetPullRequests}}...{{end}}
+func (c *WorkbenchController) GetPullRequests() map[string]any {
+	name := c.PathValue("name")
+	remote := providers.RemoteFor(name)
+	if remote == nil {
+		return nil
+	}
+
+	panel := map[string]any{"Provider": remote}
+	prs, err := providers.ListPullRequests(name)
+
+	var rateLimit *providers.RateLimitError
+	switch {
+	case errors.Is(err, providers.ErrNotConfigured):
+		panel["NotConfigured"] = true
+	case errors.As(err, &rateLimit):
+		panel["RateLimitReset"] = c.FormatActivityTime(rateLimit.Reset)
+	case err != nil:
+		panel["Error"] = internal.PresentError(err)
+	default:
+		panel["PullRequests"] = prs
+	}
+	return panel
+}
+
+// GetSafetyPanel returns the safety points panel for the repository named
+// in the request path. Keys: Enabled, Points, Error.
+// Template usage: {{with workbench.GetSafetyPanel}}...{{end}}
+func (c *WorkbenchController) GetSafetyPanel() map[string]any {
+	name := c.PathValue("name")
+	panel := map[string]any{"Enabled": internal.SafetyEnabled(name)}
+
+	points, err := internal.SafetyPoints(name)
+	if err != nil {
+		panel["Error"] = internal.PresentError(err)
+		return panel
+	}
+	panel["Points"] = points
+	return panel
+}
+
+// GetRepoApp returns the app container panel for the repository named in
+// the request path. Keys: Runtime, App, Building, BuildLog, Error.
+// Template usage: {{with workbench.GetRepoApp}}...{{end}}
+func (c *WorkbenchController) GetRepoApp() map[string]any {
+	name := c.PathValue("name")
+	runtime, err := internal.DetectRepoRuntime(name)
+	if err != nil {
+		return map[string]any{"Error": internal.PresentError(err)}
+	}
+	panel := map[string]any{
+		"Runtime":  runtime,
+		"Building": internal.RepoAppBuilding(name),
+		"BuildLog": internal.RepoAppBuildLog(name),
+	}
+
+	app, err := services.AppStatus(name)
+	if err != nil {
+		panel["Error"] = internal.PresentError(err)
+		return panel
+	}
+	panel["App"] = app
+	return panel
+}
+
+// GetAccessRecords returns access records matching the request's
+// repo, from, and to query filters, newest first.
+// Template usage: {{range workbench.GetAccessRecords}}...{{end}}
+func (c *WorkbenchController) GetAccessRecords() []*models.AccessRecord {
+	repo, from, to := accessFilters(c.Request)
+	records, err := internal.SearchAccessRecords(repo, from, to)
+	if err != nil {
+		slog.Error("failed to fetch access records", "error", err)
+	}
+	return records
+}
+
+// QueryParam returns a query parameter from the current request URL.
+// Template usage: {{workbench.QueryParam "repo"}}
+func (c *WorkbenchController) QueryParam(name string) string {
+	if c.Request == nil {
+		return ""
+	}
+	return c.URL.Query().Get(name)
+}
+
+// RepoName returns the repository name from the request path.
+// Template usage: {{workbench.RepoName}}
+func (c *WorkbenchController) RepoName() string {
+	return c.PathValue("name")
+}
+
+// HasRepositories returns true if at least one repository is cloned.
+// Used for conditional rendering in templates to show empty state or list.
+// Template usage: {{if workbench.HasRepositories}}...{{else}}...{{end}}
+func (c *WorkbenchController) HasRepositories() bool {
+	count := models.Repositories.Count("")
+	return count > 0
+}
+
+// MaxTerminals returns how many terminals may be open at once.
+// Template usage: {{workbench.MaxTerminals}}
+func (c *WorkbenchController) MaxTerminals() int {
+	return internal.MaxTerminals()
+}
+
+// IsCoderRunning returns true if the VS Code server container has started
+// and is active. Used to conditionally enable/disable IDE features in the UI.
+// Template usage: {{if workbench.IsCoderRunning}}...{{end}}
+func (c *WorkbenchController) IsCoderRunning() bool {
+	return services.CoderState().State == services.CoderRunning && services.Coder.IsRunning()
+}
+
+// IsGitLFSInstalled returns true if git-lfs is installed and set up in
+// the coder container. Decides whether a repository with LFS pointer
+// stubs is offered an install or only a pull.
+// Template usage: {{if workbench.IsGitLFSInstalled}}...{{end}}
+func (c *WorkbenchController) IsGitLFSInstalled() bool {
+	return internal.GitLFSInstalled()
+}
+
+// GetPublicKey returns the SSH public key for repository authentication.
+// Used in clone modal to allow users to copy key for Git server setup.
+// Returns empty string if key doesn't exist or can't be read.
+// Template usage: {{workbench.GetPublicKey}}
+func (c *WorkbenchController) GetPublicKey() string {
+	key, err := internal.GetPublicKey("")
+	if err != nil {
+		return ""
+	}
+	return key
+}
+
+// GetSSHChecklist returns SSH verification state for known Git providers.
+// Template usage: {{range workbench.GetSSHChecklist}}...{{end}}
+func (c *WorkbenchController) GetSSHChecklist() []*internal.SSHHostStatus {
+	return internal.GetSSHChecklist()
+}
+
+// FormatActivityTime converts UTC timestamps to user's local timezone,
+// as GetUserTimezone resolves it, then formats for the user's locale,
+// e.g. "Jan 2, 3:04 PM" (en) or "02.01. 15:04" (de).
+// Template usage: {{workbench.FormatActivityTime .CreatedAt}}
+func (c *WorkbenchController) FormatActivityTime(t time.Time) string {
+	return c.displayLocale().FormatTime(t.In(c.userTimezone()))
+}
+
+// FormatTimeRelative formats a timestamp relative to now in the user's
+// timezone, e.g. "3 minutes ago" or "yesterday at 4:12 PM", and like
+// FormatActivityTime when it's a week or more ago.
+// Template usage: {{workbench.FormatTimeRelative .CreatedAt}}
+func (c *WorkbenchController) FormatTimeRelative(t time.Time) string {
+	loc := c.userTimezone()
+	return c.displayLocale().FormatRelative(t.In(loc), time.Now().In(loc))
+}
+
+// GetUserTimezone returns the name of the timezone timestamps are shown
+// in, e.g. "Europe/Berlin" or "UTC".
+// Template usage: {{workbench.GetUserTimezone}}
+func (c *WorkbenchController) GetUserTimezone() string {
+	return c.userTimezone().String()
+}
+
+// GetTimezonePreference returns the pinned display timezone, or empty
+// string when it follows the browser.
+// Template usage: <input name="display_timezone" value="{{workbench.GetTimezonePreference}}">
+func (c *WorkbenchController) GetTimezonePreference() string {
+	preference, _ := models.GetSetting("display_timezone")
+	return preference
+}
+
+// userTimezone resolves the request's timezone once per request.
+func (c *WorkbenchController) userTimezone() *time.Location {
+	if c.Request == nil {
+		return requestTimezone(nil)
+	}
+	if c.timezone == nil {
+		c.timezone = requestTimezone(c.Request)
+	}
+	return c.timezone
+}
+
+// GetLocale returns the resolved display locale for the current request.
+// Template usage: {{workbench.GetLocale.Tag}}
+func (c *WorkbenchController) GetLocale() *i18n.Locale {
+	return c.displayLocale()
+}
+
+// displayLocale resolves the request's locale once, so rendering a list
+// of timestamps reads the preference a single time.
+func (c *WorkbenchController) displayLocale() *i18n.Locale {
+	if c.Request == nil {
+		return requestLocale(nil) // Not a request copy; don't memoize
+	}
+	if c.locale == nil {
+		c.locale = requestLocale(c.Request)
+	}
+	return c.locale
+}
+
+// GetLocalePreference returns the saved locale override, or empty string
+// when the locale is negotiated from the browser.
+// Template usage: {{if eq workbench.GetLocalePreference "de"}}selected{{end}}
+func (c *WorkbenchController) GetLocalePreference() string {
+	preference, _ := models.GetSetting("locale")
+	return preference
+}
+
+// GetActivityRetentionDays returns the activity_retention_days setting;
+// 0 keeps activities forever.
+// Template usage: {{workbench.GetActivityRetentionDays}}
+func (c *WorkbenchController) GetActivityRetentionDays() int {
+	return int(internal.ActivityRetention() / (24 * time.Hour))
+}
+
+// GetTheme returns the daisyUI theme from the theme setting.
+// Template usage: <html data-theme="{{workbench.GetTheme}}">
+func (c *WorkbenchController) GetTheme() string {
+	return models.SettingOrDefault("theme")
+}
+
+// GetDefaultStorageID returns the storage location preselected when
+// cloning, or empty for the standard repos directory.
+// Template usage: {{$default := workbench.GetDefaultStorageID}}
+func (c *WorkbenchController) GetDefaultStorageID() string {
+	return internal.DefaultStorageID()
+}
 
-	return t.In(loc).Format("Jan 2, 3:04 PM")
+// GetSupportedLocales returns all locales available for the preference picker.
+// Template usage: {{range workbench.GetSupportedLocales}}...{{end}}
+func (c *WorkbenchController) GetSupportedLocales() []*i18n.Locale {
+	return i18n.Supported()
 }
 
 // ShouldShowTour checks if the tour should be displayed to the user.
@@ -254,12 +4109,10 @@ func (c *WorkbenchController) FormatActivityTime(t time.Time) string {
 // Template usage: {{if workbench.ShouldShowTour}}...{{end}}
 func (c *WorkbenchController) ShouldShowTour() bool {
 	// Check if user explicitly said never show again
-	neverShow, _ := models.GetSetting("tour_never_show")
-	if neverShow == "true" {
+	if models.GetBoolSetting("tour_never_show") {
 		return false
 	}
 
 	// Check if tour was completed
-	completed, _ := models.GetSetting("tour_completed")
-	return completed != "true"
+	return !models.GetBoolSetting("tour_completed")
 }
@@ -0,0 +1,169 @@
+package controllers
+
+import (
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"workbench/internal"
+	"workbench/models"
+
+	"github.com/The-Skyscape/devtools/pkg/testutils"
+)
+
+// renderActivityActions renders the activity actions partial the way the
+// detail view (buttons) or a feed row (menu) includes it.
+func renderActivityActions(t *testing.T, c *WorkbenchController, activity *models.Activity, menu bool) string {
+	tmpl, err := template.New("activity-actions.html").
+		Funcs(template.FuncMap{"host": func() string { return "" }}).
+		ParseFiles("../views/partials/activity-actions.html")
+	if err != nil {
+		t.Fatalf("failed to parse partial: %v", err)
+	}
+
+	data := c.GetActivityActions(activity)
+	if menu {
+		data = c.GetActivityMenu(activity)
+	}
+	var out strings.Builder
+	if err := tmpl.Execute(&out, data); err != nil {
+		t.Fatalf("failed to render partial: %v", err)
+	}
+	return out.String()
+}
+
+var renderedAction = regexp.MustCompile(`<a (href|hx-get|hx-post)="([^"]+)"[^>]*>([^<]+)</a>`)
+
+// actionLinks summarizes the rendered actions as "label attr url" lines.
+func actionLinks(html string) string {
+	var links []string
+	for _, m := range renderedAction.FindAllStringSubmatch(html, -1) {
+		links = append(links, m[3]+" "+m[1]+" "+m[2])
+	}
+	return strings.Join(links, "; ")
+}
+
+func TestActivityActionsRender(t *testing.T) {
+	c := &WorkbenchController{repos: map[string]*models.Repository{
+		"api": {Name: "api", URL: "git@github.com:acme/api.git", LocalPath: "/home/coder/repos/api"},
+		"web": {Name: "web", URL: "https://github.com/acme/web.git", LocalPath: "/home/coder/repos/web"},
+	}}
+
+	testCases := []struct {
+		name     string
+		activity *models.Activity
+		expected string
+		deleted  bool
+	}{
+		{
+			"pull failed",
+			&models.Activity{Type: "repo_pull_failed", Repository: "api"},
+			"Retry pull hx-post /repos/pull/api; View status hx-get /partials/repo-status/api; " +
+				"Open in VS Code href /coder/?folder=%2Fhome%2Fcoder%2Frepos%2Fapi; Test SSH hx-post /ssh/test/github.com",
+			false,
+		},
+		{
+			"clone",
+			&models.Activity{Type: "repo_clone", Repository: "web"},
+			"View repo hx-get /partials/repo-status/web; Open in VS Code href /coder/?folder=%2Fhome%2Fcoder%2Frepos%2Fweb; " +
+				"Setup steps hx-get /partials/setup/web",
+			false,
+		},
+		{
+			"repository deleted",
+			&models.Activity{Type: "repo_pull_failed", Repository: "gone"},
+			"",
+			true,
+		},
+		{
+			"system action",
+			&models.Activity{Type: "coder_permissions_failed"},
+			"Repair permissions hx-post /diagnostics/permissions",
+			false,
+		},
+		{
+			"no actions",
+			&models.Activity{Type: "auth_signin"},
+			"",
+			false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			for _, menu := range []bool{false, true} {
+				html := renderActivityActions(t, c, tc.activity, menu)
+				testutils.AssertEqual(t, tc.expected, actionLinks(html))
+				testutils.AssertEqual(t, tc.deleted, strings.Contains(html, "Repository has since been deleted"))
+				testutils.AssertEqual(t, menu && (tc.expected != "" || tc.deleted), strings.Contains(html, "<li"))
+				testutils.AssertEqual(t, !menu && tc.expected != "", strings.Contains(html, "btn-xs"))
+			}
+		})
+	}
+}
+
+func TestErrorMessageOffersHelp(t *testing.T) {
+	c := &WorkbenchController{}
+	tmpl, err := template.New("error-message.html").
+		Funcs(template.FuncMap{
+			"host":      func() string { return "" },
+			"workbench": func() *WorkbenchController { return c },
+		}).
+		ParseFiles("../views/partials/error-message.html")
+	if err != nil {
+		t.Fatalf("failed to parse partial: %v", err)
+	}
+
+	render := func(data any) string {
+		var out strings.Builder
+		if err := tmpl.Execute(&out, data); err != nil {
+			t.Fatalf("failed to render partial: %v", err)
+		}
+		return out.String()
+	}
+
+	html := render(&internal.UserError{Message: "authentication failed", Ref: "a1b2c3", Kind: internal.ErrorKindAuth, Repo: "api"})
+	testutils.AssertEqual(t, true, strings.Contains(html, `hx-get="/help/auth?repo=api"`))
+	testutils.AssertEqual(t, true, strings.Contains(html, "authentication failed (error ref: a1b2c3)"))
+
+	html = render(&internal.UserError{Message: "invalid interval", Ref: "a1b2c3"})
+	testutils.AssertEqual(t, false, strings.Contains(html, "Help me fix this"))
+	testutils.AssertEqual(t, false, strings.Contains(html, "data-retry"))
+
+	html = render(&internal.UserError{Message: "network error", Ref: "a1b2c3", Retryable: true})
+	testutils.AssertEqual(t, true, strings.Contains(html, "data-retry"))
+
+	html = render("Repository URL is required")
+	testutils.AssertEqual(t, true, strings.Contains(html, "Repository URL is required"))
+	testutils.AssertEqual(t, false, strings.Contains(html, "Help me fix this"))
+}
+
+func TestRequestTimezone(t *testing.T) {
+	testCases := []struct {
+		name     string
+		cookie   string
+		header   string
+		expected string
+	}{
+		{"cookie", "Europe%2FBerlin", "America/New_York", "Europe/Berlin"},
+		{"unescaped cookie", "Asia/Tokyo", "", "Asia/Tokyo"},
+		{"invalid cookie falls back to header", "Mars%2FOlympus", "America/New_York", "America/New_York"},
+		{"malformed escape", "Europe%2", "", "UTC"},
+		{"nothing", "", "", "UTC"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			if tc.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: timezoneCookie, Value: tc.cookie})
+			}
+			if tc.header != "" {
+				req.Header.Set("X-User-Timezone", tc.header)
+			}
+			testutils.AssertEqual(t, tc.expected, requestTimezone(req).String())
+		})
+	}
+}
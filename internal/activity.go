@@ -0,0 +1,365 @@
+package internal
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"workbench/models"
+)
+
+// Digest modes for the activity_digest setting.
+const (
+	DigestOff    = "off"
+	DigestDaily  = "daily"
+	DigestWeekly = "weekly"
+)
+
+// routineActivityTypes are successful background events that are held for
+// the digest instead of notifying immediately when digest mode is enabled.
+var routineActivityTypes = []string{
+	"repo_autopull",
+	"repo_webhook_pull",
+	"repo_size_snapshot",
+	"maintenance_run",
+}
+
+// criticalActivityTypes always notify immediately, like failures.
+var criticalActivityTypes = []string{
+	"signin_rate_limited",
+	"ratelimit_lockout",
+	"auth_recovery",
+	"volume_suspect",
+	"identity_rotated",
+	"system_alert",
+}
+
+// warningActivityTypes notify like other events but are marked as
+// warnings, because something was wrong even though it was handled.
+var warningActivityTypes = []string{
+	"repo_missing",
+	"repo_recloned",
+	"coder_auto_restart",
+}
+
+// pullActivityTypes count towards "repos pulled" in digests.
+var pullActivityTypes = []string{"repo_pull", "repo_autopull", "repo_webhook_pull"}
+
+// RoutineActivityTypes returns the activity types treated as routine.
+func RoutineActivityTypes() []string {
+	return slices.Clone(routineActivityTypes)
+}
+
+// IsRoutineActivity reports whether an activity is a routine background success.
+func IsRoutineActivity(a *models.Activity) bool {
+	return slices.Contains(routineActivityTypes, a.Type)
+}
+
+// IsFailureActivity reports whether an activity records a failure.
+func IsFailureActivity(a *models.Activity) bool {
+	return strings.HasSuffix(a.Type, "_failed") || strings.HasSuffix(a.Type, "_error")
+}
+
+// IsCriticalActivity reports whether an activity must notify immediately.
+func IsCriticalActivity(a *models.Activity) bool {
+	return IsFailureActivity(a) || slices.Contains(criticalActivityTypes, a.Type)
+}
+
+// NotificationLevel returns "critical", "warning", or "info" for an
+// activity's notification.
+func NotificationLevel(a *models.Activity) string {
+	switch {
+	case IsCriticalActivity(a):
+		return "critical"
+	case slices.Contains(warningActivityTypes, a.Type):
+		return "warning"
+	default:
+		return "info"
+	}
+}
+
+// DigestMode returns the configured activity digest mode (off, daily, weekly).
+func DigestMode() string {
+	return models.SettingOrDefault("activity_digest")
+}
+
+// HideRoutineActivity reports whether routine events should be left out of
+// the default activity view because they are summarized by the digest.
+func HideRoutineActivity() bool {
+	return DigestMode() != DigestOff
+}
+
+// UserLocation returns the user's configured timezone, defaulting to UTC.
+func UserLocation() *time.Location {
+	name, _ := models.GetSetting("timezone")
+	if loc, err := time.LoadLocation(name); err == nil && name != "" {
+		return loc
+	}
+	return time.UTC
+}
+
+// InQuietHours reports whether t falls in the configured quiet-hours window
+// (digest_quiet_start to digest_quiet_end, "HH:MM" in the user's timezone).
+// Windows may wrap past midnight, e.g. 22:00 to 07:00.
+func InQuietHours(t time.Time) bool {
+	start, _ := models.GetSetting("digest_quiet_start")
+	end, _ := models.GetSetting("digest_quiet_end")
+	return inWindow(t.In(UserLocation()), start, end)
+}
+
+// inWindow reports whether the clock time of t is within [start, end).
+func inWindow(t time.Time, start, end string) bool {
+	startMin, ok1 := parseClock(start)
+	endMin, ok2 := parseClock(end)
+	if !ok1 || !ok2 || startMin == endMin {
+		return false
+	}
+
+	now := t.Hour()*60 + t.Minute()
+	if startMin < endMin {
+		return now >= startMin && now < endMin
+	}
+	return now >= startMin || now < endMin
+}
+
+// parseClock parses "HH:MM" into minutes since midnight.
+func parseClock(s string) (int, bool) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, false
+	}
+	return t.Hour()*60 + t.Minute(), true
+}
+
+// ShouldNotifyNow reports whether an activity should be sent to notification
+// channels immediately. Failures and critical events always notify; routine
+// events are held for the digest, and everything else waits out quiet hours.
+func ShouldNotifyNow(a *models.Activity) bool {
+	return shouldNotify(a, DigestMode(), InQuietHours(time.Now()))
+}
+
+func shouldNotify(a *models.Activity, mode string, quiet bool) bool {
+	if IsCriticalActivity(a) {
+		return true
+	}
+	if IsRoutineActivity(a) && mode != DigestOff {
+		return false
+	}
+	return !quiet
+}
+
+// BuildDigest composes a one-line summary of a period's activities,
+// e.g. "Daily digest: 14 repos pulled, 1 failure in api-server, disk at 71%".
+// A negative diskPercent omits disk usage from the summary.
+func BuildDigest(label string, activities []*models.Activity, diskPercent float64) string {
+	pulled := map[string]bool{}
+	cloned := 0
+	failures := 0
+	var failedRepos []string
+
+	for _, a := range activities {
+		switch {
+		case a.Type == "activity_digest":
+			continue
+		case IsFailureActivity(a):
+			failures++
+			repo := a.Repository
+			if repo == "" {
+				repo = "workbench"
+			}
+			if !slices.Contains(failedRepos, repo) {
+				failedRepos = append(failedRepos, repo)
+			}
+		case slices.Contains(pullActivityTypes, a.Type):
+			pulled[a.Repository] = true
+		case a.Type == "repo_clone":
+			cloned++
+		}
+	}
+
+	var parts []string
+	if n := len(pulled); n > 0 {
+		parts = append(parts, plural(n, "repo", "repos")+" pulled")
+	}
+	if cloned > 0 {
+		parts = append(parts, plural(cloned, "repo", "repos")+" cloned")
+	}
+	if failures > 0 {
+		parts = append(parts, fmt.Sprintf("%s in %s", plural(failures, "failure", "failures"), strings.Join(failedRepos, ", ")))
+	}
+	if len(parts) == 0 {
+		parts = append(parts, "no notable activity")
+	}
+	if diskPercent >= 0 {
+		parts = append(parts, fmt.Sprintf("disk at %.0f%%", diskPercent))
+	}
+
+	return label + ": " + strings.Join(parts, ", ")
+}
+
+func plural(n int, singular, pluralForm string) string {
+	if n == 1 {
+		return fmt.Sprintf("%d %s", n, singular)
+	}
+	return fmt.Sprintf("%d %s", n, pluralForm)
+}
+
+// The notifications job checks once a minute for new activities to notify
+// about and whether an activity digest is due and, if so, composes,
+// records and sends it.
+func init() {
+	RegisterJob(JobSpec{
+		Name:        "notifications",
+		Description: "Send notifications and activity digests",
+		Interval:    Every(time.Minute),
+		Priority:    PriorityHigh,
+		Run: func(now time.Time) error {
+			dispatchNotifications(now)
+			sendDigestIfDue(now)
+			return nil
+		},
+	})
+}
+
+// sendDigestIfDue records a digest when the configured time of day has
+// passed and no digest has been sent for the current period.
+func sendDigestIfDue(now time.Time) {
+	mode := DigestMode()
+	if mode == DigestOff {
+		return
+	}
+
+	period, label := 24*time.Hour, "Daily digest"
+	if mode == DigestWeekly {
+		period, label = 7*24*time.Hour, "Weekly digest"
+	}
+
+	at, _ := models.GetSetting("activity_digest_time")
+	minutes, ok := parseClock(at)
+	if !ok {
+		minutes = 8 * 60
+	}
+	local := now.In(UserLocation())
+	scheduled := time.Date(local.Year(), local.Month(), local.Day(), minutes/60, minutes%60, 0, 0, local.Location())
+	if local.Before(scheduled) {
+		return
+	}
+
+	var last time.Time
+	if value, err := models.GetSetting("activity_digest_last"); err == nil {
+		last, _ = time.Parse(time.RFC3339, value)
+	}
+	if last.After(scheduled.Add(-period + time.Hour)) {
+		return
+	}
+
+	since := last
+	if since.IsZero() {
+		since = now.Add(-period)
+	}
+	activities, err := models.Activities.Search("WHERE CreatedAt > ? ORDER BY CreatedAt ASC", since)
+	if err != nil {
+		slog.Error("failed to load activities for digest", "error", err)
+		return
+	}
+
+	diskPercent := -1.0
+	if usage, err := GetStorageUsage(); err == nil {
+		diskPercent = usage.UsedPercent
+	}
+
+	if _, err := models.SetSetting("activity_digest_last", now.UTC().Format(time.RFC3339), "system"); err != nil {
+		slog.Error("failed to record digest time", "error", err)
+		return
+	}
+
+	digest, err := models.Activities.Insert(&models.Activity{
+		Type:        "activity_digest",
+		Repository:  "",
+		Description: BuildDigest(label, activities, diskPercent),
+		Author:      "System",
+		Timestamp:   now,
+	})
+	if err != nil {
+		slog.Error("failed to record digest", "error", err)
+		return
+	}
+
+	// Sent at the time the user picked, even inside quiet hours
+	sendNotification(digest)
+}
+
+// DefaultActivityRetentionDays is how long activities are kept until the
+// activity_retention_days setting says otherwise.
+const DefaultActivityRetentionDays = 90
+
+// ActivityRetention returns how long activities are kept, from the
+// activity_retention_days setting; 0 keeps them forever.
+func ActivityRetention() time.Duration {
+	return models.GetDurationSetting("activity_retention_days")
+}
+
+// The activity pruning job deletes activities older than the retention
+// once a day. It doesn't run on start, so upgrading doesn't delete an
+// existing log before there's a chance to export it or turn retention
+// off; Prune on the Activity Log card applies a retention right away.
+func init() {
+	RegisterJob(JobSpec{
+		Name:        "activity-pruning",
+		Description: "Remove activities older than the retention",
+		Interval:    Every(24 * time.Hour),
+		Jitter:      30 * time.Minute,
+		Priority:    PriorityLow,
+		Run: func(now time.Time) error {
+			_, err := PruneActivities(now)
+			return err
+		},
+	})
+}
+
+// PruneActivities deletes activities created before now minus the
+// retention, in one statement, and records one activity_prune activity
+// saying how many went. Returns how many were deleted; nothing is deleted
+// when the retention is 0.
+func PruneActivities(now time.Time) (int, error) {
+	retention := ActivityRetention()
+	if retention <= 0 {
+		return 0, nil
+	}
+	pruned, err := models.DeleteActivitiesBefore(now.Add(-retention))
+	if err != nil {
+		err = fmt.Errorf("failed to delete expired activities: %w", err)
+	}
+	if pruned > 0 {
+		go models.Activities.Insert(&models.Activity{
+			Type:        "activity_prune",
+			Repository:  "",
+			Description: pruneSummary(pruned, int(retention/(24*time.Hour))),
+			Author:      "System",
+			Timestamp:   now,
+		})
+	}
+	return pruned, err
+}
+
+// pruneSummary describes a prune, e.g. "Pruned 12,403 entries older than
+// 90 days".
+func pruneSummary(pruned, days int) string {
+	noun := "entries"
+	if pruned == 1 {
+		noun = "entry"
+	}
+	return fmt.Sprintf("Pruned %s %s older than %s", groupThousands(pruned), noun, plural(days, "day", "days"))
+}
+
+// groupThousands formats n with commas between groups of three digits,
+// e.g. 12,403.
+func groupThousands(n int) string {
+	s := strconv.Itoa(n)
+	for i := len(s) - 3; i > 0 && s[i-1] != '-'; i -= 3 {
+		s = s[:i] + "," + s[i:]
+	}
+	return s
+}
@@ -0,0 +1,90 @@
+package internal
+
+import (
+	"net/url"
+	"sync"
+	"workbench/models"
+)
+
+// Activity action methods, controlling how a button dispatches.
+const (
+	ActionPost = "post" // HTMX POST to an existing endpoint
+	ActionGet  = "get"  // HTMX GET of a partial
+	ActionLink = "link" // Plain link opened in a new tab
+)
+
+// ActivityAction is a contextual action rendered on an activity entry.
+type ActivityAction struct {
+	Label  string
+	Method string
+	URL    string
+}
+
+// ActivityActionDef declares an action for an activity type. URL builds
+// the endpoint from the activity and its repository; returning empty
+// string hides the action for that entry.
+type ActivityActionDef struct {
+	Label        string
+	Method       string
+	RequiresRepo bool // Hidden, and the entry marked deleted, if the repository is gone
+	URL          func(a *models.Activity, repo *models.Repository) string
+}
+
+var (
+	activityActionsMu sync.RWMutex
+	activityActions   = map[string][]ActivityActionDef{}
+)
+
+// RegisterActivityAction adds an action for an activity type. Features
+// declare the actions for their own activity types from an init function.
+func RegisterActivityAction(activityType string, def ActivityActionDef) {
+	activityActionsMu.Lock()
+	defer activityActionsMu.Unlock()
+	activityActions[activityType] = append(activityActions[activityType], def)
+}
+
+// ActivityActions returns the actions available for an activity, and
+// whether the repository they refer to has since been deleted. repos holds
+// the existing repositories by name, so a feed looks them up only once.
+func ActivityActions(a *models.Activity, repos map[string]*models.Repository) ([]*ActivityAction, bool) {
+	return activityActionsFor(a, repos[a.Repository])
+}
+
+// RepositoriesByName loads every repository, keyed by name.
+func RepositoriesByName() (map[string]*models.Repository, error) {
+	repos, err := models.Repositories.Search("")
+	if err != nil {
+		return nil, err
+	}
+	byName := make(map[string]*models.Repository, len(repos))
+	for _, repo := range repos {
+		byName[repo.Name] = repo
+	}
+	return byName, nil
+}
+
+// activityActionsFor builds the actions for an activity given its
+// repository, or nil if the repository no longer exists.
+func activityActionsFor(a *models.Activity, repo *models.Repository) ([]*ActivityAction, bool) {
+	activityActionsMu.RLock()
+	defs := activityActions[a.Type]
+	activityActionsMu.RUnlock()
+
+	var actions []*ActivityAction
+	deleted := false
+	for _, def := range defs {
+		if def.RequiresRepo && repo == nil {
+			deleted = deleted || a.Repository != ""
+			continue
+		}
+		if u := def.URL(a, repo); u != "" {
+			actions = append(actions, &ActivityAction{Label: def.Label, Method: def.Method, URL: u})
+		}
+	}
+	return actions, deleted
+}
+
+// repoPath escapes a repository name for use in a URL path.
+func repoPath(repo *models.Repository) string {
+	return url.PathEscape(repo.Name)
+}
@@ -0,0 +1,98 @@
+package internal
+
+import (
+	"strings"
+	"testing"
+	"workbench/models"
+
+	"github.com/The-Skyscape/devtools/pkg/testutils"
+)
+
+func actionSummary(actions []*ActivityAction) string {
+	var parts []string
+	for _, action := range actions {
+		parts = append(parts, action.Label+" "+action.Method+" "+action.URL)
+	}
+	return strings.Join(parts, "; ")
+}
+
+func TestActivityActions(t *testing.T) {
+	sshRepo := &models.Repository{Name: "api", URL: "git@github.com:acme/api.git", LocalPath: "/home/coder/repos/api"}
+	httpsRepo := &models.Repository{Name: "web", URL: "https://github.com/acme/web.git", LocalPath: "/home/coder/repos/web"}
+
+	testCases := []struct {
+		name     string
+		activity *models.Activity
+		repo     *models.Repository
+		expected string
+		deleted  bool
+	}{
+		{
+			"pull failed over ssh",
+			&models.Activity{Type: "repo_pull_failed", Repository: "api"},
+			sshRepo,
+			"Retry pull post /repos/pull/api; View status get /partials/repo-status/api; " +
+				"Open in VS Code link /coder/?folder=%2Fhome%2Fcoder%2Frepos%2Fapi; Test SSH post /ssh/test/github.com",
+			false,
+		},
+		{
+			"pull failed over https",
+			&models.Activity{Type: "repo_pull_failed", Repository: "web"},
+			httpsRepo,
+			"Retry pull post /repos/pull/web; View status get /partials/repo-status/web; " +
+				"Open in VS Code link /coder/?folder=%2Fhome%2Fcoder%2Frepos%2Fweb",
+			false,
+		},
+		{
+			"clone",
+			&models.Activity{Type: "repo_clone", Repository: "web"},
+			httpsRepo,
+			"View repo get /partials/repo-status/web; Open in VS Code link /coder/?folder=%2Fhome%2Fcoder%2Frepos%2Fweb; " +
+				"Setup steps get /partials/setup/web",
+			false,
+		},
+		{
+			"repository deleted",
+			&models.Activity{Type: "repo_pull_failed", Repository: "gone"},
+			nil,
+			"",
+			true,
+		},
+		{
+			"system action",
+			&models.Activity{Type: "coder_permissions_failed"},
+			nil,
+			"Repair permissions post /diagnostics/permissions",
+			false,
+		},
+		{
+			"no actions",
+			&models.Activity{Type: "auth_signin"},
+			nil,
+			"",
+			false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			actions, deleted := activityActionsFor(tc.activity, tc.repo)
+			testutils.AssertEqual(t, tc.expected, actionSummary(actions))
+			testutils.AssertEqual(t, tc.deleted, deleted)
+		})
+	}
+}
+
+func TestRegisterActivityAction(t *testing.T) {
+	RegisterActivityAction("test_event", ActivityActionDef{
+		Label:  "Inspect",
+		Method: ActionGet,
+		URL: func(a *models.Activity, repo *models.Repository) string {
+			return "/inspect/" + a.Description
+		},
+	})
+	t.Cleanup(func() { delete(activityActions, "test_event") })
+
+	actions, _ := activityActionsFor(&models.Activity{Type: "test_event", Description: "x"}, nil)
+	testutils.AssertEqual(t, "Inspect get /inspect/x", actionSummary(actions))
+}
@@ -0,0 +1,126 @@
+package internal
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"time"
+	"workbench/models"
+)
+
+// Export formats. json is newline-delimited too, one object a line, so
+// it can be streamed.
+const (
+	ExportNDJSON = "ndjson"
+	ExportJSON   = "json"
+	ExportCSV    = "csv"
+)
+
+// exportBatch is how many rows are read per query while exporting.
+const exportBatch = 500
+
+// exportDate is how from and to are written in export query parameters.
+const exportDate = "2006-01-02"
+
+// ExportQuery selects what an export holds and how it's written.
+type ExportQuery struct {
+	Format     string
+	BOM        bool      // Start a CSV export with a UTF-8 byte order mark, for Excel
+	From       time.Time // Created on or after; zero for no lower bound
+	To         time.Time // Created before; zero for no upper bound
+	Type       string    // Activity type, as the activity log filters it
+	Security   bool      // Only authentication events
+	Repository string
+}
+
+// ParseExportQuery reads format (csv, json, or ndjson, the default), bom,
+// from and to (YYYY-MM-DD, both days included), and the activity log's
+// type, security, and repository filters from query parameters.
+func ParseExportQuery(values url.Values) (ExportQuery, error) {
+	q := ExportQuery{
+		Format:     values.Get("format"),
+		Type:       values.Get("type"),
+		Security:   values.Get("security") == "true",
+		Repository: values.Get("repository"),
+	}
+	switch q.Format {
+	case "":
+		q.Format = ExportNDJSON
+	case ExportNDJSON, ExportJSON, ExportCSV:
+	default:
+		return q, fmt.Errorf("unknown export format %q - use csv or json", q.Format)
+	}
+	q.BOM, _ = strconv.ParseBool(values.Get("bom"))
+
+	var err error
+	if from := values.Get("from"); from != "" {
+		if q.From, err = time.Parse(exportDate, from); err != nil {
+			return q, fmt.Errorf("from must be a date like 2026-01-31")
+		}
+	}
+	if to := values.Get("to"); to != "" {
+		if q.To, err = time.Parse(exportDate, to); err != nil {
+			return q, fmt.Errorf("to must be a date like 2026-01-31")
+		}
+		q.To = q.To.AddDate(0, 0, 1)
+	}
+	if !q.From.IsZero() && !q.To.IsZero() && !q.From.Before(q.To) {
+		return q, fmt.Errorf("from must not be after to")
+	}
+	return q, nil
+}
+
+// activityFilter returns the database filter for the query. Unlike the
+// activity log, routine events are always included.
+func (q ExportQuery) activityFilter() models.ActivityFilter {
+	f := models.ActivityFilter{Type: q.Type, Repository: q.Repository, From: q.From, To: q.To}
+	if q.Security {
+		f.TypePrefix = securityTypePrefix
+	}
+	return f
+}
+
+// activitiesAfter reads the next batch of matching activities in creation
+// order, after the given activity. Replaced in tests.
+var activitiesAfter = func(f models.ActivityFilter, createdAt time.Time, id string, limit int) ([]*models.Activity, error) {
+	where, args := f.Where()
+	return models.Activities.Search(afterClause(where)+" ORDER BY CreatedAt ASC, ID ASC LIMIT ?",
+		append(args, createdAt, createdAt, id, limit)...)
+}
+
+// exportedActivity is one line of a JSON export.
+type exportedActivity struct {
+	ID          string    `json:"id"`
+	Type        string    `json:"type"`
+	Repository  string    `json:"repository"`
+	Description string    `json:"description"`
+	Author      string    `json:"author"`
+	Timestamp   time.Time `json:"timestamp"`
+	CreatedAt   time.Time `json:"created_at"`
+	Metadata    string    `json:"metadata,omitempty"`
+}
+
+// exportColumns is the header row of a CSV export.
+var exportColumns = []string{"id", "type", "repository", "description", "author", "timestamp", "created_at", "metadata"}
+
+// ExportActivities writes the matching activities to w, oldest first, as
+// newline-delimited JSON or CSV, and returns how many it wrote. The log
+// is read in batches, so it's streamed rather than held in memory.
+func ExportActivities(w io.Writer, q ExportQuery) (int, error) {
+	encoder, err := newExportEncoder(w, q, exportColumns)
+	if err != nil {
+		return 0, err
+	}
+	f := q.activityFilter()
+	return exportBatches(encoder,
+		func(after time.Time, afterID string) ([]*models.Activity, error) {
+			return activitiesAfter(f, after, afterID, exportBatch)
+		},
+		func(a *models.Activity) (time.Time, string) { return a.CreatedAt, a.ID },
+		func(a *models.Activity) ([]string, any) {
+			return []string{a.ID, a.Type, a.Repository, a.Description, a.Author,
+					a.Timestamp.UTC().Format(time.RFC3339), a.CreatedAt.UTC().Format(time.RFC3339), a.Metadata},
+				exportedActivity{a.ID, a.Type, a.Repository, a.Description, a.Author, a.Timestamp, a.CreatedAt, a.Metadata}
+		})
+}
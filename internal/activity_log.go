@@ -0,0 +1,126 @@
+package internal
+
+import (
+	"net/url"
+	"strconv"
+	"workbench/models"
+)
+
+// Activity log page sizes.
+const (
+	DefaultActivityPageSize = 20
+	MaxActivityPageSize     = 100
+)
+
+// securityTypePrefix starts the type of every authentication activity,
+// the ones the Security view shows.
+const securityTypePrefix = "auth_"
+
+// ActivityQuery selects a page of the activity log.
+type ActivityQuery struct {
+	Type        string
+	Security    bool   // Only authentication events
+	Repository  string // Deleted repositories still match their history
+	ShowRoutine bool   // Include routine events even when the digest summarizes them
+	Page        int    // From 1
+	PerPage     int
+}
+
+// ParseActivityQuery reads page, per_page, type, security, repository, and
+// all from query parameters. Invalid or out-of-range numbers fall back to the first
+// page and the default page size.
+func ParseActivityQuery(values url.Values) ActivityQuery {
+	q := ActivityQuery{
+		Type:        values.Get("type"),
+		Security:    values.Get("security") == "true",
+		Repository:  values.Get("repository"),
+		ShowRoutine: values.Get("all") == "true",
+		Page:        1,
+		PerPage:     DefaultActivityPageSize,
+	}
+	if page, err := strconv.Atoi(values.Get("page")); err == nil && page > 0 {
+		q.Page = page
+	}
+	if perPage, err := strconv.Atoi(values.Get("per_page")); err == nil && perPage > 0 {
+		q.PerPage = min(perPage, MaxActivityPageSize)
+	}
+	return q
+}
+
+// Encode returns the query string for another page of the same filters.
+func (q ActivityQuery) Encode(page int) string {
+	values := url.Values{}
+	values.Set("page", strconv.Itoa(page))
+	if q.PerPage != DefaultActivityPageSize {
+		values.Set("per_page", strconv.Itoa(q.PerPage))
+	}
+	if q.Type != "" {
+		values.Set("type", q.Type)
+	}
+	if q.Security {
+		values.Set("security", "true")
+	}
+	if q.Repository != "" {
+		values.Set("repository", q.Repository)
+	}
+	if q.ShowRoutine {
+		values.Set("all", "true")
+	}
+	return values.Encode()
+}
+
+// filter returns the database filter for the query. Routine events are
+// left out when hideRoutine is set, unless asked for or filtered by type.
+func (q ActivityQuery) filter(hideRoutine bool) models.ActivityFilter {
+	f := models.ActivityFilter{Type: q.Type, Repository: q.Repository}
+	if q.Security {
+		f.TypePrefix = securityTypePrefix
+	}
+	if hideRoutine && !q.ShowRoutine {
+		f.ExcludeTypes = RoutineActivityTypes()
+	}
+	return f
+}
+
+// ActivityPage is one page of the filtered activity log.
+type ActivityPage struct {
+	ActivityQuery
+	Activities []*models.Activity
+	Total      int
+}
+
+// Pages returns the number of pages, at least 1.
+func (p *ActivityPage) Pages() int {
+	return max((p.Total+p.PerPage-1)/p.PerPage, 1)
+}
+
+// HasPrevious reports whether there is an earlier (newer) page.
+func (p *ActivityPage) HasPrevious() bool {
+	return p.Page > 1
+}
+
+// HasNext reports whether there is a later (older) page.
+func (p *ActivityPage) HasNext() bool {
+	return p.Page < p.Pages()
+}
+
+// PreviousQuery returns the query string of the previous page.
+func (p *ActivityPage) PreviousQuery() string {
+	return p.Encode(p.Page - 1)
+}
+
+// NextQuery returns the query string of the next page.
+func (p *ActivityPage) NextQuery() string {
+	return p.Encode(p.Page + 1)
+}
+
+// GetActivityPage returns a page of the activity log, newest first, with
+// the total count of matching entries for pagination.
+func GetActivityPage(q ActivityQuery) (*ActivityPage, error) {
+	f := q.filter(HideRoutineActivity())
+	activities, err := models.SearchActivities(f, q.PerPage, (q.Page-1)*q.PerPage)
+	if err != nil {
+		return nil, err
+	}
+	return &ActivityPage{ActivityQuery: q, Activities: activities, Total: models.CountActivities(f)}, nil
+}
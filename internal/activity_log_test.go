@@ -0,0 +1,72 @@
+package internal
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/The-Skyscape/devtools/pkg/testutils"
+)
+
+func TestParseActivityQuery(t *testing.T) {
+	tests := []struct {
+		name          string
+		query         string
+		page, perPage int
+	}{
+		{"defaults", "", 1, DefaultActivityPageSize},
+		{"page and size", "page=3&per_page=50", 3, 50},
+		{"size capped", "per_page=5000", 1, MaxActivityPageSize},
+		{"invalid numbers", "page=-2&per_page=abc", 1, DefaultActivityPageSize},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values, _ := url.ParseQuery(tt.query)
+			q := ParseActivityQuery(values)
+			testutils.AssertEqual(t, tt.page, q.Page)
+			testutils.AssertEqual(t, tt.perPage, q.PerPage)
+		})
+	}
+}
+
+func TestActivityQueryEncodeKeepsFilters(t *testing.T) {
+	values, _ := url.ParseQuery("type=repo_pull&repository=old+api&all=true&per_page=50&page=2")
+	q := ParseActivityQuery(values)
+	testutils.AssertEqual(t, "all=true&page=3&per_page=50&repository=old+api&type=repo_pull", q.Encode(3))
+
+	roundTrip, _ := url.ParseQuery(q.Encode(3))
+	testutils.AssertEqual(t, q.Repository, ParseActivityQuery(roundTrip).Repository)
+}
+
+func TestActivityQueryFilter(t *testing.T) {
+	f := ActivityQuery{Repository: "api"}.filter(true)
+	testutils.AssertEqual(t, len(routineActivityTypes), len(f.ExcludeTypes))
+	testutils.AssertEqual(t, "api", f.Repository)
+
+	f = ActivityQuery{ShowRoutine: true}.filter(true)
+	testutils.AssertEqual(t, 0, len(f.ExcludeTypes))
+
+	f = ActivityQuery{}.filter(false)
+	testutils.AssertEqual(t, 0, len(f.ExcludeTypes))
+	testutils.AssertEqual(t, "", f.TypePrefix)
+
+	f = ActivityQuery{Security: true}.filter(false)
+	testutils.AssertEqual(t, "auth_", f.TypePrefix)
+}
+
+func TestActivityPagePages(t *testing.T) {
+	tests := []struct {
+		total, page, pages int
+		previous, next     bool
+	}{
+		{0, 1, 1, false, false},
+		{20, 1, 1, false, false},
+		{21, 1, 2, false, true},
+		{45, 3, 3, true, false},
+	}
+	for _, tt := range tests {
+		p := &ActivityPage{ActivityQuery: ActivityQuery{Page: tt.page, PerPage: 20}, Total: tt.total}
+		testutils.AssertEqual(t, tt.pages, p.Pages())
+		testutils.AssertEqual(t, tt.previous, p.HasPrevious())
+		testutils.AssertEqual(t, tt.next, p.HasNext())
+	}
+}
@@ -0,0 +1,133 @@
+package internal
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+	"workbench/models"
+)
+
+// MaxStatsDays caps how many days activity statistics cover.
+const MaxStatsDays = 92
+
+// topRepositories is how many of the most active repositories are listed.
+const topRepositories = 5
+
+// DayStats counts the activities of one day.
+type DayStats struct {
+	Date         time.Time      `json:"date"` // Midnight starting the day, in the requested timezone
+	Clones       int            `json:"clones"`
+	Pulls        int            `json:"pulls"`
+	Commits      int            `json:"commits"`
+	Failures     int            `json:"failures"`
+	Auth         int            `json:"auth"`
+	Total        int            `json:"total"`
+	Types        map[string]int `json:"types"`
+	Repositories map[string]int `json:"repositories"`
+}
+
+// RepoActivity is how many activities a repository had.
+type RepoActivity struct {
+	Repository string `json:"repository"`
+	Count      int    `json:"count"`
+}
+
+// ActivityStats summarizes the activity log day by day.
+type ActivityStats struct {
+	From            time.Time      `json:"from"` // Midnight starting the first day
+	To              time.Time      `json:"to"`   // Midnight ending the last day
+	Days            []*DayStats    `json:"days"` // Every day in order, with zeros for quiet ones
+	Totals          DayStats       `json:"totals"`
+	TopRepositories []RepoActivity `json:"top_repositories"` // Most active first
+}
+
+// Heat grades a day's activity from 0 (none) to 4 (the busiest day), for
+// heatmap cells.
+func (s *ActivityStats) Heat(day *DayStats) int {
+	busiest := 0
+	for _, d := range s.Days {
+		busiest = max(busiest, d.Total)
+	}
+	if day.Total == 0 || busiest == 0 {
+		return 0
+	}
+	return 1 + 3*(day.Total-1)/max(busiest-1, 1)
+}
+
+// activityCounts counts activities per day, type, and repository.
+// Replaced in tests.
+var activityCounts = models.CountActivitiesBetween
+
+// GetActivityStats counts the activities from the day of from through the
+// day of to, both included, with days starting at midnight in from's
+// location. Counts are grouped by the database; each day is then summed
+// into clones, pulls, commits made in the editor, failures, and
+// authentication events.
+func GetActivityStats(from, to time.Time) (*ActivityStats, error) {
+	loc := from.Location()
+	to = to.In(loc)
+	start := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, loc)
+	last := time.Date(to.Year(), to.Month(), to.Day(), 0, 0, 0, 0, loc)
+	if last.Before(start) {
+		return nil, &repoError{ErrInvalidInput, "from must not be after to"}
+	}
+
+	var bounds []time.Time
+	for day := start; !day.After(last); day = time.Date(start.Year(), start.Month(), start.Day()+len(bounds), 0, 0, 0, 0, loc) {
+		if len(bounds) == MaxStatsDays {
+			return nil, &repoError{ErrInvalidInput, fmt.Sprintf("statistics cover at most %d days", MaxStatsDays)}
+		}
+		bounds = append(bounds, day)
+	}
+	bounds = append(bounds, time.Date(last.Year(), last.Month(), last.Day()+1, 0, 0, 0, 0, loc))
+
+	counts, err := activityCounts(bounds)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count activities: %w", err)
+	}
+
+	stats := &ActivityStats{From: bounds[0], To: bounds[len(bounds)-1]}
+	stats.Totals.Types, stats.Totals.Repositories = map[string]int{}, map[string]int{}
+	for _, day := range bounds[:len(bounds)-1] {
+		stats.Days = append(stats.Days, &DayStats{Date: day, Types: map[string]int{}, Repositories: map[string]int{}})
+	}
+	for _, c := range counts {
+		if c.Bucket < 0 || c.Bucket >= len(stats.Days) {
+			continue
+		}
+		stats.Days[c.Bucket].add(c)
+		stats.Totals.add(c)
+	}
+
+	for repo, count := range stats.Totals.Repositories {
+		stats.TopRepositories = append(stats.TopRepositories, RepoActivity{repo, count})
+	}
+	slices.SortFunc(stats.TopRepositories, func(a, b RepoActivity) int {
+		return cmp.Or(b.Count-a.Count, strings.Compare(a.Repository, b.Repository))
+	})
+	stats.TopRepositories = stats.TopRepositories[:min(len(stats.TopRepositories), topRepositories)]
+	return stats, nil
+}
+
+// add counts one group of activities into the day.
+func (d *DayStats) add(c models.ActivityCount) {
+	d.Total += c.Count
+	d.Types[c.Type] += c.Count
+	if c.Repository != "" {
+		d.Repositories[c.Repository] += c.Count
+	}
+	switch {
+	case IsFailureActivity(&models.Activity{Type: c.Type}):
+		d.Failures += c.Count
+	case strings.HasPrefix(c.Type, securityTypePrefix):
+		d.Auth += c.Count
+	case c.Type == "repo_clone" || c.Type == "repo_recloned":
+		d.Clones += c.Count
+	case c.Type == "repo_pull" || c.Type == "repo_autopull":
+		d.Pulls += c.Count
+	case c.Type == "repo_edit":
+		d.Commits += c.Count
+	}
+}
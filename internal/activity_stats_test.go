@@ -0,0 +1,64 @@
+package internal
+
+import (
+	"errors"
+	"testing"
+	"time"
+	"workbench/models"
+
+	"github.com/The-Skyscape/devtools/pkg/testutils"
+)
+
+func TestGetActivityStats(t *testing.T) {
+	counts := activityCounts
+	t.Cleanup(func() { activityCounts = counts })
+
+	var bounds []time.Time
+	activityCounts = func(b []time.Time) ([]models.ActivityCount, error) {
+		bounds = b
+		return []models.ActivityCount{
+			{Bucket: 0, Type: "repo_clone", Repository: "api", Count: 1},
+			{Bucket: 0, Type: "repo_autopull", Repository: "api", Count: 3},
+			{Bucket: 2, Type: "repo_edit", Repository: "web", Count: 2},
+			{Bucket: 2, Type: "repo_pull_failed", Repository: "api", Count: 1},
+			{Bucket: 2, Type: "auth_login_failed", Count: 2},
+			{Bucket: 2, Type: "auth_login", Count: 1},
+		}, nil
+	}
+
+	// Days follow local midnights across the change to summer time
+	loc, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip("no timezone data")
+	}
+	stats, err := GetActivityStats(time.Date(2026, 3, 28, 15, 0, 0, 0, loc), time.Date(2026, 3, 30, 9, 0, 0, 0, loc))
+	testutils.AssertEqual(t, nil, err)
+	testutils.AssertEqual(t, 4, len(bounds))
+	testutils.AssertEqual(t, 23*time.Hour, bounds[2].Sub(bounds[1]))
+	testutils.AssertEqual(t, time.Date(2026, 3, 31, 0, 0, 0, 0, loc), stats.To)
+
+	testutils.AssertEqual(t, 3, len(stats.Days))
+	testutils.AssertEqual(t, 1, stats.Days[0].Clones)
+	testutils.AssertEqual(t, 3, stats.Days[0].Pulls)
+	testutils.AssertEqual(t, 0, stats.Days[1].Total)
+	testutils.AssertEqual(t, 2, stats.Days[2].Commits)
+	testutils.AssertEqual(t, 3, stats.Days[2].Failures)
+	testutils.AssertEqual(t, 1, stats.Days[2].Auth)
+	testutils.AssertEqual(t, 6, stats.Days[2].Total)
+	testutils.AssertEqual(t, 10, stats.Totals.Total)
+	testutils.AssertEqual(t, []RepoActivity{{"api", 5}, {"web", 2}}, stats.TopRepositories)
+
+	testutils.AssertEqual(t, 4, stats.Heat(stats.Days[2]))
+	testutils.AssertEqual(t, 2, stats.Heat(stats.Days[0]))
+	testutils.AssertEqual(t, 0, stats.Heat(stats.Days[1]))
+}
+
+func TestGetActivityStatsRange(t *testing.T) {
+	day := time.Date(2026, 3, 28, 0, 0, 0, 0, time.UTC)
+
+	_, err := GetActivityStats(day, day.AddDate(0, 0, -1))
+	testutils.AssertEqual(t, true, errors.Is(err, ErrInvalidInput))
+
+	_, err = GetActivityStats(day, day.AddDate(0, 0, MaxStatsDays))
+	testutils.AssertEqual(t, true, errors.Is(err, ErrInvalidInput))
+}
@@ -0,0 +1,137 @@
+package internal
+
+import (
+	"strings"
+	"testing"
+	"time"
+	"workbench/models"
+
+	"github.com/The-Skyscape/devtools/pkg/testutils"
+)
+
+func activity(activityType, repo string) *models.Activity {
+	return &models.Activity{Type: activityType, Repository: repo}
+}
+
+func TestBuildDigestEmptyPeriod(t *testing.T) {
+	testutils.AssertEqual(t, "Daily digest: no notable activity, disk at 71%", BuildDigest("Daily digest", nil, 71.2))
+	testutils.AssertEqual(t, "Daily digest: no notable activity", BuildDigest("Daily digest", nil, -1))
+}
+
+func TestBuildDigestMixedOutcomes(t *testing.T) {
+	activities := []*models.Activity{
+		activity("repo_autopull", "web"),
+		activity("repo_autopull", "web"),
+		activity("repo_webhook_pull", "api-server"),
+		activity("repo_pull", "docs"),
+		activity("repo_pull_failed", "api-server"),
+		activity("repo_clone", "new-project"),
+		activity("activity_digest", ""),
+		activity("tour_complete", ""),
+	}
+
+	testutils.AssertEqual(t,
+		"Weekly digest: 3 repos pulled, 1 repo cloned, 1 failure in api-server, disk at 50%",
+		BuildDigest("Weekly digest", activities, 50))
+}
+
+func TestBuildDigestMultipleFailures(t *testing.T) {
+	activities := []*models.Activity{
+		activity("repo_pull_failed", "api-server"),
+		activity("repo_pull_failed", "api-server"),
+		activity("repo_setup_failed", "web"),
+		activity("backup_error", ""),
+	}
+
+	testutils.AssertEqual(t,
+		"Daily digest: 4 failures in api-server, web, workbench",
+		BuildDigest("Daily digest", activities, -1))
+}
+
+func TestActivityClassification(t *testing.T) {
+	testutils.AssertEqual(t, true, IsRoutineActivity(activity("repo_autopull", "web")))
+	testutils.AssertEqual(t, false, IsRoutineActivity(activity("repo_clone", "web")))
+	testutils.AssertEqual(t, true, IsCriticalActivity(activity("repo_pull_failed", "web")))
+	testutils.AssertEqual(t, true, IsCriticalActivity(activity("signin_rate_limited", "")))
+	testutils.AssertEqual(t, true, IsCriticalActivity(activity("system_alert", "")))
+	testutils.AssertEqual(t, false, IsCriticalActivity(activity("repo_pull", "web")))
+}
+
+func TestInWindow(t *testing.T) {
+	at := func(hour, minute int) time.Time {
+		return time.Date(2024, 1, 1, hour, minute, 0, 0, time.UTC)
+	}
+
+	testutils.AssertEqual(t, true, inWindow(at(23, 0), "22:00", "07:00"))
+	testutils.AssertEqual(t, true, inWindow(at(3, 30), "22:00", "07:00"))
+	testutils.AssertEqual(t, false, inWindow(at(7, 0), "22:00", "07:00"))
+	testutils.AssertEqual(t, true, inWindow(at(12, 0), "09:00", "17:00"))
+	testutils.AssertEqual(t, false, inWindow(at(18, 0), "09:00", "17:00"))
+	testutils.AssertEqual(t, false, inWindow(at(12, 0), "", ""))
+}
+
+func TestNotifierHoldsDuringQuietHours(t *testing.T) {
+	var sent []string
+	original := sendNotification
+	sendNotification = func(a *models.Activity) {
+		sent = append(sent, a.Type)
+	}
+	t.Cleanup(func() { sendNotification = original })
+
+	at := time.Date(2025, 1, 1, 23, 0, 0, 0, time.UTC)
+	record := func(kind string) *models.Activity {
+		at = at.Add(time.Second)
+		a := &models.Activity{Type: kind}
+		a.CreatedAt = at
+		return a
+	}
+
+	n := &notifier{}
+	n.handle([]*models.Activity{
+		record("repo_pull_failed"),
+		record("repo_clone"),
+		record("repo_autopull"),
+		record("activity_digest"),
+	}, DigestDaily, true)
+
+	// Only the failure goes out during quiet hours; routine events are left to the digest
+	testutils.AssertEqual(t, "repo_pull_failed", strings.Join(sent, ","))
+	testutils.AssertEqual(t, at, n.lastSeen)
+
+	// Held events are released once quiet hours end
+	n.handle([]*models.Activity{record("auth_recovery")}, DigestDaily, false)
+	testutils.AssertEqual(t, "repo_pull_failed,repo_clone,auth_recovery", strings.Join(sent, ","))
+	testutils.AssertEqual(t, 0, len(n.held))
+}
+
+func TestNotifierSendsSigninFailureBursts(t *testing.T) {
+	var sent []string
+	original := sendNotification
+	sendNotification = func(a *models.Activity) {
+		sent = append(sent, a.Description)
+	}
+	t.Cleanup(func() { sendNotification = original })
+
+	failed := func() *models.Activity {
+		return &models.Activity{Type: AuthSigninFailed, Description: "Failed sign-in"}
+	}
+	n := &notifier{}
+	n.handle([]*models.Activity{failed(), failed()}, DigestOff, false)
+	testutils.AssertEqual(t, 0, len(sent))
+
+	n.handle([]*models.Activity{failed(), failed(), failed(), {Type: notificationUndelivered}}, DigestOff, false)
+	testutils.AssertEqual(t, 1, len(sent))
+	testutils.AssertEqual(t, "3 failed sign-ins in the last minute", sent[0])
+}
+
+func TestPruneSummary(t *testing.T) {
+	testutils.AssertEqual(t, "Pruned 12,403 entries older than 90 days", pruneSummary(12403, 90))
+	testutils.AssertEqual(t, "Pruned 1 entry older than 1 day", pruneSummary(1, 1))
+}
+
+func TestGroupThousands(t *testing.T) {
+	testCases := map[int]string{0: "0", 999: "999", 1000: "1,000", 12403: "12,403", 1234567: "1,234,567", -4500: "-4,500"}
+	for n, expected := range testCases {
+		testutils.AssertEqual(t, expected, groupThousands(n))
+	}
+}
@@ -0,0 +1,143 @@
+package internal
+
+import (
+	"fmt"
+	"sync"
+	"time"
+	"workbench/models"
+)
+
+// Resource alert settings, in percent; 0 turns an alert off.
+const (
+	CPUAlertKey    = "cpu_alert_percent"
+	MemoryAlertKey = "memory_alert_percent"
+	DiskAlertKey   = "disk_alert_percent"
+)
+
+// Default alert thresholds, in percent.
+const (
+	DefaultCPUAlert    = 90
+	DefaultMemoryAlert = 90
+	DefaultDiskAlert   = 90
+)
+
+const (
+	// alertInterval is how often the latest sample is checked.
+	alertInterval = 30 * time.Second
+
+	// alertSustain is how long a metric must stay over its threshold
+	// before an alert fires, and back under it before recovery is recorded,
+	// so brief spikes don't alert and a breach alerts once.
+	alertSustain = 2 * time.Minute
+
+	// alertHysteresis is how far under the threshold, in points, a metric
+	// must fall to count as recovered, so hovering at the line doesn't flap.
+	alertHysteresis = 5
+)
+
+// alertMetric is a resource that can alert.
+type alertMetric struct {
+	Key   string // Threshold setting
+	Label string
+	Value func(MetricSample) float64
+}
+
+var alertMetrics = []alertMetric{
+	{CPUAlertKey, "CPU", func(s MetricSample) float64 { return s.CPU }},
+	{MemoryAlertKey, "Memory", func(s MetricSample) float64 { return s.Memory }},
+	{DiskAlertKey, "Data disk", func(s MetricSample) float64 { return s.DiskPercent }},
+}
+
+// alertState tracks one metric between checks.
+type alertState struct {
+	firing  bool
+	pending time.Time // When the metric crossed the line it must stay across; zero when it hasn't
+}
+
+// alertEvaluator raises system_alert activities when metrics stay over
+// their thresholds, and again when they recover. The threshold and record
+// hooks are fields so it can be tested without settings or a database.
+type alertEvaluator struct {
+	mu        sync.Mutex
+	states    map[string]*alertState
+	threshold func(key string) int
+	record    func(description string)
+}
+
+var alerts = &alertEvaluator{
+	states:    map[string]*alertState{},
+	threshold: models.GetIntSetting,
+	record: func(description string) {
+		go models.Activities.Insert(&models.Activity{
+			Type:        "system_alert",
+			Description: description,
+			Author:      "System",
+			Timestamp:   time.Now(),
+		})
+	},
+}
+
+// The resource alert job checks the latest sample against the thresholds.
+func init() {
+	RegisterJob(JobSpec{
+		Name:        "resource-alerts",
+		Description: "Alert when CPU, memory, or disk stay over their thresholds",
+		Interval:    Every(alertInterval),
+		Priority:    PriorityHigh,
+		Run:         checkAlerts,
+	})
+}
+
+func checkAlerts(now time.Time) error {
+	samples := RawMetricSamples(now.Add(-alertInterval))
+	if len(samples) == 0 {
+		return nil
+	}
+	alerts.evaluate(samples[len(samples)-1], now)
+	return nil
+}
+
+// evaluate updates each metric's state from a sample, recording an alert
+// when one has stayed over its threshold for alertSustain and a recovery
+// when it has stayed under it for as long.
+func (e *alertEvaluator) evaluate(sample MetricSample, now time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, metric := range alertMetrics {
+		state := e.states[metric.Key]
+		if state == nil {
+			state = &alertState{}
+			e.states[metric.Key] = state
+		}
+		threshold := e.threshold(metric.Key)
+		if threshold <= 0 {
+			// Turned off; a firing alert is dropped without a recovery
+			*state = alertState{}
+			continue
+		}
+
+		value := metric.Value(sample)
+		crossing := value >= float64(threshold)
+		if state.firing {
+			crossing = value < float64(threshold-alertHysteresis)
+		}
+		if !crossing {
+			state.pending = time.Time{}
+			continue
+		}
+		if state.pending.IsZero() {
+			state.pending = now
+		}
+		if now.Sub(state.pending) < alertSustain {
+			continue
+		}
+
+		state.firing, state.pending = !state.firing, time.Time{}
+		if state.firing {
+			e.record(fmt.Sprintf("%s at %.0f%%, over the %d%% alert threshold for %s", metric.Label, value, threshold, alertSustain))
+		} else {
+			e.record(fmt.Sprintf("%s back to %.0f%%, under the %d%% alert threshold", metric.Label, value, threshold))
+		}
+	}
+}
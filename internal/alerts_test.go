@@ -0,0 +1,78 @@
+package internal
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/The-Skyscape/devtools/pkg/testutils"
+)
+
+// testAlerts returns an evaluator with fixed thresholds that collects what
+// it records.
+func testAlerts(thresholds map[string]int) (*alertEvaluator, *[]string) {
+	var recorded []string
+	return &alertEvaluator{
+		states:    map[string]*alertState{},
+		threshold: func(key string) int { return thresholds[key] },
+		record:    func(description string) { recorded = append(recorded, description) },
+	}, &recorded
+}
+
+func TestAlertFiresOncePerSustainedBreach(t *testing.T) {
+	e, recorded := testAlerts(map[string]int{CPUAlertKey: 90})
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	// A spike shorter than alertSustain doesn't alert
+	e.evaluate(MetricSample{CPU: 95}, start)
+	e.evaluate(MetricSample{CPU: 40}, start.Add(time.Minute))
+	testutils.AssertEqual(t, 0, len(*recorded))
+
+	// A sustained breach alerts once, however long it lasts
+	for i := 0; i <= 10; i++ {
+		e.evaluate(MetricSample{CPU: 97}, start.Add(2*time.Minute+time.Duration(i)*alertInterval))
+	}
+	testutils.AssertEqual(t, 1, len(*recorded))
+	testutils.AssertEqual(t, true, strings.HasPrefix((*recorded)[0], "CPU at 97%"))
+}
+
+func TestAlertRecovery(t *testing.T) {
+	e, recorded := testAlerts(map[string]int{MemoryAlertKey: 80})
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	e.evaluate(MetricSample{Memory: 85}, start)
+	e.evaluate(MetricSample{Memory: 85}, start.Add(alertSustain))
+	testutils.AssertEqual(t, 1, len(*recorded))
+
+	// Dipping just under the threshold isn't a recovery
+	e.evaluate(MetricSample{Memory: 78}, start.Add(3*time.Minute))
+	e.evaluate(MetricSample{Memory: 78}, start.Add(10*time.Minute))
+	testutils.AssertEqual(t, 1, len(*recorded))
+
+	e.evaluate(MetricSample{Memory: 60}, start.Add(11*time.Minute))
+	e.evaluate(MetricSample{Memory: 60}, start.Add(11*time.Minute+alertSustain))
+	testutils.AssertEqual(t, 2, len(*recorded))
+	testutils.AssertEqual(t, true, strings.HasPrefix((*recorded)[1], "Memory back to 60%"))
+
+	// A new breach after recovery alerts again
+	e.evaluate(MetricSample{Memory: 90}, start.Add(20*time.Minute))
+	e.evaluate(MetricSample{Memory: 90}, start.Add(20*time.Minute+alertSustain))
+	testutils.AssertEqual(t, 3, len(*recorded))
+}
+
+func TestAlertTurnedOff(t *testing.T) {
+	thresholds := map[string]int{DiskAlertKey: 90}
+	e, recorded := testAlerts(thresholds)
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	e.evaluate(MetricSample{DiskPercent: 95}, start)
+	e.evaluate(MetricSample{DiskPercent: 95}, start.Add(alertSustain))
+	testutils.AssertEqual(t, 1, len(*recorded))
+
+	// Turning an alert off drops it without recording a recovery
+	thresholds[DiskAlertKey] = 0
+	e.evaluate(MetricSample{DiskPercent: 10}, start.Add(5*time.Minute))
+	e.evaluate(MetricSample{DiskPercent: 10}, start.Add(10*time.Minute))
+	testutils.AssertEqual(t, 1, len(*recorded))
+	testutils.AssertEqual(t, false, e.states[DiskAlertKey].firing)
+}
@@ -0,0 +1,245 @@
+package internal
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"workbench/models"
+)
+
+// API scope classes. Reads get a higher rate than writes.
+const (
+	APIRead  = "read"
+	APIWrite = "write"
+)
+
+// Default API limits, used when the corresponding setting is unset.
+const (
+	DefaultAPIReadRate   = 120 // Sustained requests per minute
+	DefaultAPIReadBurst  = 30
+	DefaultAPIWriteRate  = 20
+	DefaultAPIWriteBurst = 5
+	DefaultAPIMaxBody    = 1 << 20 // 1MB
+)
+
+// APILimits are the token-bucket parameters for one scope class.
+type APILimits struct {
+	PerMinute float64 // Sustained refill rate
+	Burst     float64 // Bucket capacity
+}
+
+// APILimitsFor returns the configured limits for a scope class, read from
+// the api_<class>_rate and api_<class>_burst settings.
+func APILimitsFor(class string) APILimits {
+	limits := APILimits{PerMinute: DefaultAPIReadRate, Burst: DefaultAPIReadBurst}
+	if class == APIWrite {
+		limits = APILimits{PerMinute: DefaultAPIWriteRate, Burst: DefaultAPIWriteBurst}
+	}
+	if v := models.GetIntSetting("api_" + class + "_rate"); v > 0 {
+		limits.PerMinute = float64(v)
+	}
+	if v := models.GetIntSetting("api_" + class + "_burst"); v > 0 {
+		limits.Burst = float64(v)
+	}
+	return limits
+}
+
+// APIMaxBodySize returns the maximum accepted JSON request body in bytes.
+func APIMaxBodySize() int64 {
+	return int64(models.GetIntSetting("api_max_body"))
+}
+
+// tokenBucket holds up to Burst tokens, refilled continuously at PerMinute.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// take refills the bucket for the time elapsed since the last request and
+// takes one token. When empty, returns how long until a token is available.
+func (b *tokenBucket) take(limits APILimits, now time.Time) (bool, time.Duration) {
+	rate := limits.PerMinute / 60 // tokens per second
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(limits.Burst, b.tokens+elapsed*rate)
+	}
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	wait := time.Duration((1 - b.tokens) / rate * float64(time.Second))
+	return false, wait
+}
+
+// full reports whether the bucket has refilled to its burst by now, in
+// which case it behaves exactly like a new bucket and can be forgotten.
+func (b *tokenBucket) full(limits APILimits, now time.Time) bool {
+	return b.tokens+now.Sub(b.last).Seconds()*limits.PerMinute/60 >= limits.Burst
+}
+
+// apiLimitsTTL is how long limits read from Settings are reused, so
+// changed settings take effect within a minute without a query per request.
+const apiLimitsTTL = time.Minute
+
+// apiSweepInterval is how often idle buckets and stale reports are evicted.
+const apiSweepInterval = time.Minute
+
+// APILimiter applies token-bucket limits per API key and scope class, and
+// counts operations per key per day. Separate from AuthRateLimiter, which
+// limits authentication attempts by IP.
+type APILimiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	usage     map[string]int       // "day|key" to operation count
+	reported  map[string]time.Time // Last api_rate_limited activity per key
+	lastSweep time.Time
+
+	limitsMu sync.Mutex
+	limits   func(class string) APILimits
+	cached   map[string]cachedLimits
+}
+
+type cachedLimits struct {
+	limits  APILimits
+	expires time.Time
+}
+
+// NewAPILimiter creates a limiter using the given limits lookup.
+func NewAPILimiter(limits func(class string) APILimits) *APILimiter {
+	return &APILimiter{
+		buckets:  map[string]*tokenBucket{},
+		usage:    map[string]int{},
+		reported: map[string]time.Time{},
+		limits:   limits,
+		cached:   map[string]cachedLimits{},
+	}
+}
+
+// limitsFor returns the limits for a scope class, looking them up at most
+// once per apiLimitsTTL. The lookup runs without holding either lock, so
+// a slow Settings read doesn't stall other callers.
+func (l *APILimiter) limitsFor(class string, now time.Time) APILimits {
+	l.limitsMu.Lock()
+	cached, ok := l.cached[class]
+	l.limitsMu.Unlock()
+	if ok && now.Before(cached.expires) {
+		return cached.limits
+	}
+
+	limits := l.limits(class)
+	l.limitsMu.Lock()
+	l.cached[class] = cachedLimits{limits: limits, expires: now.Add(apiLimitsTTL)}
+	l.limitsMu.Unlock()
+	return limits
+}
+
+// DefaultAPILimiter limits the JSON API using limits from Settings.
+var DefaultAPILimiter = NewAPILimiter(APILimitsFor)
+
+// Allow reports whether a request by key in the given scope class may
+// proceed, and if not, how long the caller should wait before retrying.
+// Allowed requests count towards the key's daily operation total.
+func (l *APILimiter) Allow(key, class string, now time.Time) (bool, time.Duration) {
+	limits := l.limitsFor(class, now)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) >= apiSweepInterval {
+		l.sweep(now)
+	}
+
+	bucketKey := class + "|" + key
+	bucket, ok := l.buckets[bucketKey]
+	if !ok {
+		bucket = &tokenBucket{tokens: limits.Burst, last: now}
+		l.buckets[bucketKey] = bucket
+	}
+
+	allowed, wait := bucket.take(limits, now)
+	if allowed {
+		day := now.UTC().Format("2006-01-02")
+		l.usage[day+"|"+key]++
+		// Keep only today's counters
+		for k := range l.usage {
+			if !strings.HasPrefix(k, day+"|") {
+				delete(l.usage, k)
+			}
+		}
+	}
+	return allowed, wait
+}
+
+// sweep forgets buckets that have refilled completely and rate-limit
+// reports old enough not to suppress the next one. Both are recreated
+// as needed, so callers that come and go don't grow the maps forever.
+// Must be called with l.mu held.
+func (l *APILimiter) sweep(now time.Time) {
+	l.lastSweep = now
+	for bucketKey, bucket := range l.buckets {
+		class, _, _ := strings.Cut(bucketKey, "|")
+		l.limitsMu.Lock()
+		cached, ok := l.cached[class]
+		l.limitsMu.Unlock()
+		if ok && bucket.full(cached.limits, now) {
+			delete(l.buckets, bucketKey)
+		}
+	}
+	for key, last := range l.reported {
+		if now.Sub(last) >= time.Minute {
+			delete(l.reported, key)
+		}
+	}
+}
+
+// APIUsage is the number of operations a key performed today.
+type APIUsage struct {
+	Key        string
+	Operations int
+}
+
+// UsageToday returns today's operation counts per key, busiest first.
+func (l *APILimiter) UsageToday(now time.Time) []APIUsage {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	prefix := now.UTC().Format("2006-01-02") + "|"
+	var usage []APIUsage
+	for k, n := range l.usage {
+		if key, ok := strings.CutPrefix(k, prefix); ok {
+			usage = append(usage, APIUsage{Key: key, Operations: n})
+		}
+	}
+	sort.Slice(usage, func(i, j int) bool {
+		if usage[i].Operations != usage[j].Operations {
+			return usage[i].Operations > usage[j].Operations
+		}
+		return usage[i].Key < usage[j].Key
+	})
+	return usage
+}
+
+// RecordRateLimited logs an api_rate_limited activity naming the key, at
+// most once a minute per key so a runaway script doesn't flood the log.
+func (l *APILimiter) RecordRateLimited(key, class string, now time.Time) {
+	l.mu.Lock()
+	last, ok := l.reported[key]
+	if ok && now.Sub(last) < time.Minute {
+		l.mu.Unlock()
+		return
+	}
+	l.reported[key] = now
+	l.mu.Unlock()
+
+	go models.Activities.Insert(&models.Activity{
+		Type:        "api_rate_limited",
+		Repository:  "",
+		Description: fmt.Sprintf("API %s requests rate limited for %s", class, key),
+		Author:      "System",
+		Timestamp:   time.Now(),
+	})
+}
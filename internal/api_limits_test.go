@@ -0,0 +1,116 @@
+package internal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/The-Skyscape/devtools/pkg/testutils"
+)
+
+func fixedLimits(class string) APILimits {
+	if class == APIWrite {
+		return APILimits{PerMinute: 6, Burst: 2}
+	}
+	return APILimits{PerMinute: 60, Burst: 3}
+}
+
+func TestAPILimiterBurstExhaustion(t *testing.T) {
+	l := NewAPILimiter(fixedLimits)
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	for i := 0; i < 3; i++ {
+		ok, _ := l.Allow("token:abc", APIRead, now)
+		testutils.AssertEqual(t, true, ok)
+	}
+	ok, wait := l.Allow("token:abc", APIRead, now)
+	testutils.AssertEqual(t, false, ok)
+	testutils.AssertEqual(t, time.Second, wait)
+
+	// Other keys and scope classes have their own buckets
+	ok, _ = l.Allow("token:def", APIRead, now)
+	testutils.AssertEqual(t, true, ok)
+	ok, _ = l.Allow("token:abc", APIWrite, now)
+	testutils.AssertEqual(t, true, ok)
+}
+
+func TestAPILimiterRefill(t *testing.T) {
+	l := NewAPILimiter(fixedLimits)
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	l.Allow("session", APIWrite, now)
+	l.Allow("session", APIWrite, now)
+	ok, wait := l.Allow("session", APIWrite, now)
+	testutils.AssertEqual(t, false, ok)
+	testutils.AssertEqual(t, 10*time.Second, wait)
+
+	// Writes refill at 6 per minute: one token after 10 seconds
+	ok, _ = l.Allow("session", APIWrite, now.Add(5*time.Second))
+	testutils.AssertEqual(t, false, ok)
+	ok, _ = l.Allow("session", APIWrite, now.Add(10*time.Second))
+	testutils.AssertEqual(t, true, ok)
+
+	// Refill never exceeds the burst
+	later := now.Add(time.Hour)
+	allowed := 0
+	for i := 0; i < 5; i++ {
+		if ok, _ := l.Allow("session", APIWrite, later); ok {
+			allowed++
+		}
+	}
+	testutils.AssertEqual(t, 2, allowed)
+}
+
+func TestAPILimiterUsageToday(t *testing.T) {
+	l := NewAPILimiter(fixedLimits)
+	day := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	l.Allow("token:abc", APIRead, day)
+	l.Allow("token:abc", APIRead, day)
+	l.Allow("session", APIRead, day)
+	l.Allow("token:abc", APIRead, day)
+	l.Allow("token:abc", APIRead, day) // limited, not counted
+
+	usage := l.UsageToday(day)
+	testutils.AssertEqual(t, 2, len(usage))
+	testutils.AssertEqual(t, APIUsage{Key: "token:abc", Operations: 3}, usage[0])
+	testutils.AssertEqual(t, APIUsage{Key: "session", Operations: 1}, usage[1])
+
+	// Counters reset the next day
+	next := day.Add(24 * time.Hour)
+	l.Allow("session", APIRead, next)
+	testutils.AssertEqual(t, 1, len(l.UsageToday(next)))
+	testutils.AssertEqual(t, 0, len(l.UsageToday(day)))
+}
+
+func TestAPILimiterEvictsIdleEntries(t *testing.T) {
+	l := NewAPILimiter(fixedLimits)
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	l.Allow("token:abc", APIRead, now)
+	l.reported["token:abc"] = now
+	testutils.AssertEqual(t, 1, len(l.buckets))
+
+	// Once refilled, the old bucket is indistinguishable from a new one
+	l.Allow("token:def", APIRead, now.Add(2*time.Minute))
+	testutils.AssertEqual(t, 1, len(l.buckets))
+	testutils.AssertEqual(t, 0, len(l.reported))
+	_, ok := l.buckets[APIRead+"|token:def"]
+	testutils.AssertEqual(t, true, ok)
+}
+
+func TestAPILimiterCachesLimits(t *testing.T) {
+	lookups := 0
+	l := NewAPILimiter(func(class string) APILimits {
+		lookups++
+		return fixedLimits(class)
+	})
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	for i := 0; i < 3; i++ {
+		l.Allow("session", APIRead, now.Add(time.Duration(i)*time.Second))
+	}
+	testutils.AssertEqual(t, 1, lookups)
+
+	l.Allow("session", APIRead, now.Add(apiLimitsTTL))
+	testutils.AssertEqual(t, 2, lookups)
+}
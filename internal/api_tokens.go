@@ -0,0 +1,89 @@
+package internal
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"time"
+	"workbench/models"
+)
+
+// apiTokenKey is the setting holding the SHA-256 of the API token. The
+// token itself is only shown once, when it's generated.
+const apiTokenKey = "api_token_hash"
+
+// apiTokenPrefix marks workbench API tokens so they're recognizable in
+// scripts and secret scanners.
+const apiTokenPrefix = "wb_"
+
+var (
+	// storedAPITokenHash and saveAPITokenHash read and write the token hash
+	// setting. Replaced in tests.
+	storedAPITokenHash = func() string {
+		hash, _ := models.GetSetting(apiTokenKey)
+		return hash
+	}
+	saveAPITokenHash = func(hash string) error {
+		_, err := models.SetSetting(apiTokenKey, hash, "api")
+		return err
+	}
+)
+
+// HasAPIToken reports whether an API token has been generated.
+func HasAPIToken() bool {
+	return storedAPITokenHash() != ""
+}
+
+// GenerateAPIToken creates a new API token, replacing any previous one,
+// and returns it. Only its hash is stored, so it can't be shown again.
+func GenerateAPIToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate API token: %w", err)
+	}
+	token := apiTokenPrefix + hex.EncodeToString(b)
+	if err := saveAPITokenHash(hashAPIToken(token)); err != nil {
+		return "", fmt.Errorf("failed to save API token: %w", err)
+	}
+
+	go models.Activities.Insert(&models.Activity{
+		Type:        "api_token_create",
+		Repository:  "",
+		Description: "Generated a new API token",
+		Author:      "System",
+		Timestamp:   time.Now(),
+	})
+	return token, nil
+}
+
+// RevokeAPIToken removes the API token, so requests using it are refused.
+func RevokeAPIToken() error {
+	if err := saveAPITokenHash(""); err != nil {
+		return fmt.Errorf("failed to revoke API token: %w", err)
+	}
+
+	go models.Activities.Insert(&models.Activity{
+		Type:        "api_token_revoke",
+		Repository:  "",
+		Description: "Revoked the API token",
+		Author:      "System",
+		Timestamp:   time.Now(),
+	})
+	return nil
+}
+
+// CheckAPIToken reports whether token is the current API token.
+func CheckAPIToken(token string) bool {
+	stored := storedAPITokenHash()
+	if stored == "" || token == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(hashAPIToken(token)), []byte(stored)) == 1
+}
+
+func hashAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
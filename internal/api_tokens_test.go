@@ -0,0 +1,40 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/The-Skyscape/devtools/pkg/testutils"
+)
+
+// fakeAPITokenStore keeps the token hash in memory for the duration of a test.
+func fakeAPITokenStore(t *testing.T, hash string) {
+	originalStored, originalSave := storedAPITokenHash, saveAPITokenHash
+	storedAPITokenHash = func() string { return hash }
+	saveAPITokenHash = func(h string) error { hash = h; return nil }
+	t.Cleanup(func() { storedAPITokenHash, saveAPITokenHash = originalStored, originalSave })
+}
+
+func TestCheckAPIToken(t *testing.T) {
+	token := apiTokenPrefix + "0123456789abcdef"
+
+	testCases := []struct {
+		name     string
+		stored   string
+		token    string
+		expected bool
+	}{
+		{"matching token", hashAPIToken(token), token, true},
+		{"wrong token", hashAPIToken(token), apiTokenPrefix + "fedcba9876543210", false},
+		{"empty token", hashAPIToken(token), "", false},
+		{"no token generated", "", token, false},
+		{"stored hash given as token", hashAPIToken(token), hashAPIToken(token), false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			fakeAPITokenStore(t, tc.stored)
+			testutils.AssertEqual(t, tc.expected, CheckAPIToken(tc.token))
+			testutils.AssertEqual(t, tc.stored != "", HasAPIToken())
+		})
+	}
+}
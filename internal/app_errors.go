@@ -0,0 +1,78 @@
+package internal
+
+import "errors"
+
+// Error codes reported with failed requests, in the HTML error region and
+// as the "error" field of API responses.
+const (
+	CodeNotFound        = "not_found"
+	CodeExists          = "already_exists"
+	CodeInvalidInput    = "invalid_input"
+	CodeRepoMissing     = "repo_missing"
+	CodeCoderNotRunning = "coder_not_running"
+	CodeNoSpace         = "insufficient_storage"
+	CodeAuthFailed      = "auth_failed"
+	CodeNetwork         = "network_error"
+	CodeTimedOut        = "timed_out"
+	CodeConflict        = "conflict"
+	CodeLocked          = "locked"
+	CodeFailed          = "operation_failed"
+	CodeInternal        = "internal_error"
+)
+
+// genericErrorMessage is shown for errors with nothing safe to tell the
+// user; the error ref leads to the detail in the log.
+const genericErrorMessage = "something went wrong - check the logs for the error ref"
+
+// AppError is an error with a message written for the user, kept apart
+// from the detail that explains it in the log. Detail may hold raw command
+// output, paths, or anything else that's never shown. Error returns the
+// user message, so callers that format the error keep working.
+type AppError struct {
+	Code        string // One of the Code constants
+	UserMessage string // Safe to show; empty shows a generic message
+	Detail      string // Logged only, e.g. git's output
+	Retryable   bool   // Trying the same request again may succeed
+	Err         error  // Underlying cause, for errors.Is and errors.As
+}
+
+func (e *AppError) Error() string {
+	if e.UserMessage == "" {
+		return genericErrorMessage
+	}
+	return e.UserMessage
+}
+
+func (e *AppError) Unwrap() error { return e.Err }
+
+// ErrorCode returns the code for an error: an AppError's own code, or one
+// derived from the sentinel it matches, and CodeFailed for anything else
+// an operation refused or failed at.
+func ErrorCode(err error) string {
+	var app *AppError
+	var missing *MissingRepoError
+	switch {
+	case errors.As(err, &app) && app.Code != "":
+		return app.Code
+	case errors.Is(err, ErrRepoNotFound):
+		return CodeNotFound
+	case errors.Is(err, ErrRepoExists), errors.Is(err, ErrTagExists):
+		return CodeExists
+	case errors.Is(err, ErrInvalidInput):
+		return CodeInvalidInput
+	case errors.As(err, &missing):
+		return CodeRepoMissing
+	case errors.Is(err, ErrCoderNotRunning):
+		return CodeCoderNotRunning
+	case errors.Is(err, ErrNoSpace):
+		return CodeNoSpace
+	}
+	return CodeFailed
+}
+
+// IsRetryable reports whether trying the request that failed with err
+// again may succeed.
+func IsRetryable(err error) bool {
+	var app *AppError
+	return errors.As(err, &app) && app.Retryable
+}
@@ -0,0 +1,58 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/The-Skyscape/devtools/pkg/testutils"
+)
+
+func TestErrorCode(t *testing.T) {
+	testCases := []struct {
+		name string
+		err  error
+		code string
+	}{
+		{"app error", fmt.Errorf("export: %w", &AppError{Code: CodeAuthFailed, UserMessage: "authentication failed"}), CodeAuthFailed},
+		{"not found", repoNotFound("api"), CodeNotFound},
+		{"invalid input", ValidateRepoName("-rf"), CodeInvalidInput},
+		{"tag exists", &repoError{ErrTagExists, "tag 'v1.0.0' already exists in api - pick another name"}, CodeExists},
+		{"missing directory", &MissingRepoError{Repo: "api"}, CodeRepoMissing},
+		{"disk full", fmt.Errorf("clone: %w", ErrNoSpace), CodeNoSpace},
+		{"plain", errors.New("merge conflicts detected"), CodeFailed},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			testutils.AssertEqual(t, tc.code, ErrorCode(tc.err))
+		})
+	}
+}
+
+func TestPresentErrorAppError(t *testing.T) {
+	logged := captureLog(t)
+	err := &AppError{
+		Code:        CodeFailed,
+		UserMessage: "reset failed - git's output is in the activity log",
+		Detail:      "CONFLICT (content): Merge conflict in /home/coder/repos/api/main.go",
+		Err:         errors.New("exit status 1"),
+	}
+
+	presented := PresentError(err)
+	testutils.AssertEqual(t, "reset failed - git's output is in the activity log", presented.Message)
+	testutils.AssertEqual(t, CodeFailed, presented.Code)
+	testutils.AssertEqual(t, false, presented.Retryable)
+	testutils.AssertEqual(t, ErrorKindConflict, presented.Kind)
+	testutils.AssertEqual(t, true, strings.Contains(logged.String(), "Merge conflict in /home/coder/repos/api/main.go"))
+
+	presented = PresentError(fmt.Errorf("pull: %w", ErrOperationTimedOut))
+	testutils.AssertEqual(t, true, presented.Retryable)
+	testutils.AssertEqual(t, CodeTimedOut, presented.Code)
+
+	// With nothing safe to say, the user gets the ref to look up
+	presented = PresentError(&AppError{Code: CodeInternal, Detail: "database is locked"})
+	testutils.AssertEqual(t, genericErrorMessage, presented.Message)
+	testutils.AssertEqual(t, true, strings.Contains(logged.String(), "ref="+presented.Ref))
+}
@@ -0,0 +1,313 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"workbench/models"
+	"workbench/services"
+)
+
+// appLogLines is how many lines of a failed build are kept to show.
+const appLogLines = 50
+
+// composeFiles are the compose file names DetectRepoRuntime looks for.
+var composeFiles = []string{"docker-compose.yml", "docker-compose.yaml"}
+
+// devContainerPath is where VS Code looks for a dev container definition.
+const devContainerPath = ".devcontainer/devcontainer.json"
+
+// RepoRuntime is what a repository has for running it in a container.
+type RepoRuntime struct {
+	Dockerfile   bool          // Dockerfile at the top of the repository
+	Compose      string        // Compose file found, empty when none
+	DevContainer *DevContainer // Nil when there's no devcontainer.json
+}
+
+// DevContainer is the part of devcontainer.json that says how to get an
+// image. Paths are relative to the repository.
+type DevContainer struct {
+	Image      string // Prebuilt image to run, when not built
+	Dockerfile string // Relative to Context
+	Context    string
+}
+
+// Runnable reports whether the workbench can build or pull an image for
+// the repository. Compose projects are detected but not run.
+func (r *RepoRuntime) Runnable() bool {
+	return r.Dockerfile || r.DevContainer != nil
+}
+
+// Source names what a run would use, e.g. "Dockerfile".
+func (r *RepoRuntime) Source() string {
+	switch {
+	case r.Dockerfile:
+		return "Dockerfile"
+	case r.DevContainer != nil:
+		return devContainerPath
+	case r.Compose != "":
+		return r.Compose
+	}
+	return ""
+}
+
+var (
+	// appExec runs detection commands in the coder container. Replaced in tests.
+	appExec = services.CoderExec
+
+	buildingMu sync.Mutex
+	building   = map[string]bool{} // Repositories whose app is being built
+
+	appLogsMu sync.Mutex
+	appLogs   = map[string][]string{} // Last lines of each repository's last failed build
+)
+
+// DetectRepoRuntime reports whether a repository has a Dockerfile, a
+// compose file, or a dev container definition.
+func DetectRepoRuntime(repoName string) (*RepoRuntime, error) {
+	repo, err := models.Repositories.Find("WHERE Name = ?", repoName)
+	if err != nil {
+		return nil, fmt.Errorf("repository '%s' not found", repoName)
+	}
+	return detectRuntime(repo.LocalPath)
+}
+
+func detectRuntime(dir string) (*RepoRuntime, error) {
+	output, err := appExec(fmt.Sprintf(`cd %s || exit 1
+		for f in Dockerfile %s; do [ -f "$f" ] && echo "file $f"; done
+		if [ -f %s ]; then echo devcontainer; cat %[3]s; fi
+		true`, shellQuote(dir), strings.Join(composeFiles, " "), devContainerPath))
+	if err != nil {
+		return nil, fmt.Errorf("failed to look for a Dockerfile in %s", dir)
+	}
+	return parseRuntime(output)
+}
+
+// parseRuntime parses detectRuntime output: a "file name" line per file
+// found, then "devcontainer" and the file's content when there is one.
+func parseRuntime(output string) (*RepoRuntime, error) {
+	runtime := &RepoRuntime{}
+	files, devcontainer, found := strings.Cut(output, "devcontainer\n")
+	for _, line := range strings.Split(files, "\n") {
+		name, ok := strings.CutPrefix(strings.TrimSpace(line), "file ")
+		switch {
+		case !ok:
+		case name == "Dockerfile":
+			runtime.Dockerfile = true
+		case runtime.Compose == "":
+			runtime.Compose = name
+		}
+	}
+	if found {
+		dc, err := parseDevContainer(devcontainer)
+		if err != nil {
+			return nil, err
+		}
+		runtime.DevContainer = dc
+	}
+	return runtime, nil
+}
+
+// jsoncComment matches // and /* */ comments outside strings in
+// devcontainer.json, which allows them; trailingComma matches the
+// trailing commas it also allows.
+var (
+	jsoncComment  = regexp.MustCompile(`("(?:[^"\\]|\\.)*")|//[^\n]*|/\*[\s\S]*?\*/`)
+	trailingComma = regexp.MustCompile(`("(?:[^"\\]|\\.)*")|,(\s*[}\]])`)
+)
+
+// parseDevContainer reads the image or build settings of devcontainer.json.
+func parseDevContainer(content string) (*DevContainer, error) {
+	content = jsoncComment.ReplaceAllString(content, "$1")
+	content = trailingComma.ReplaceAllString(content, "$1$2")
+
+	var doc struct {
+		Image      string `json:"image"`
+		DockerFile string `json:"dockerFile"` // Older spelling
+		Context    string `json:"context"`
+		Build      struct {
+			Dockerfile string `json:"dockerfile"`
+			Context    string `json:"context"`
+		} `json:"build"`
+	}
+	if err := json.Unmarshal([]byte(content), &doc); err != nil {
+		return nil, fmt.Errorf("%s isn't valid JSON: %v", devContainerPath, err)
+	}
+
+	dockerfile, context := doc.Build.Dockerfile, doc.Build.Context
+	if dockerfile == "" {
+		dockerfile, context = doc.DockerFile, doc.Context
+	}
+	if dockerfile == "" {
+		if doc.Image == "" {
+			return nil, fmt.Errorf("%s names neither an image nor a Dockerfile", devContainerPath)
+		}
+		return &DevContainer{Image: doc.Image}, nil
+	}
+
+	// Both are relative to .devcontainer; the Dockerfile must be inside the context
+	context = path.Join(".devcontainer", context)
+	file := path.Join(".devcontainer", dockerfile)
+	if strings.HasPrefix(context, "..") || (context != "." && !strings.HasPrefix(file, context+"/")) {
+		return nil, fmt.Errorf("%s builds from outside the repository or its context, which isn't supported", devContainerPath)
+	}
+	if context != "." {
+		file = strings.TrimPrefix(file, context+"/")
+	}
+	return &DevContainer{Dockerfile: file, Context: context}, nil
+}
+
+// RunRepoApp builds a repository's app image and starts it as
+// workbench-app-{repo} next to the coder container, in the background.
+// Only one build per repository runs at a time. When the build fails, its
+// last lines are kept for RepoAppBuildLog.
+func RunRepoApp(repoName string) (*Task, error) {
+	repo, err := models.Repositories.Find("WHERE Name = ?", repoName)
+	if err != nil {
+		return nil, fmt.Errorf("repository '%s' not found", repoName)
+	}
+	if repo.Missing {
+		return nil, fmt.Errorf("%s is missing - re-clone it first", repo.Name)
+	}
+	if !services.Coder.IsRunning() {
+		return nil, ErrCoderNotRunning
+	}
+	runtime, err := detectRuntime(repo.LocalPath)
+	if err != nil {
+		return nil, err
+	}
+	if !runtime.Runnable() {
+		if runtime.Compose != "" {
+			return nil, fmt.Errorf("%s has %s - compose projects can't be run from the dashboard yet, use docker compose from a terminal on the host", repo.Name, runtime.Compose)
+		}
+		return nil, fmt.Errorf("%s has no Dockerfile or %s", repo.Name, devContainerPath)
+	}
+	hostDir, err := repoHostDir(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	buildingMu.Lock()
+	defer buildingMu.Unlock()
+	if building[repo.Name] {
+		return nil, fmt.Errorf("%s is already being built", repo.Name)
+	}
+	building[repo.Name] = true
+
+	log := &appLog{}
+	task := newTask(repo.Name, "build and run from "+runtime.Source())
+	task.RefreshRepos = true
+	task.start(func(onLine func(string) error) error {
+		return buildAndRunApp(repo, runtime, hostDir, func(line string) error {
+			log.add(line)
+			return onLine(line)
+		})
+	}, func(output string, err error) {
+		buildingMu.Lock()
+		delete(building, repo.Name)
+		buildingMu.Unlock()
+
+		appLogsMu.Lock()
+		if err != nil {
+			appLogs[repo.Name] = log.lines
+		} else {
+			delete(appLogs, repo.Name)
+		}
+		appLogsMu.Unlock()
+
+		activity := &models.Activity{
+			Type:        "repo_app_run",
+			Repository:  repo.Name,
+			Description: fmt.Sprintf("Started %s from its %s", services.AppName(repo.Name), runtime.Source()),
+			Author:      "System",
+			Timestamp:   time.Now(),
+		}
+		if err != nil {
+			activity.Type = "repo_app_run_failed"
+			activity.Description = fmt.Sprintf("Failed to run %s: %v", repo.Name, err)
+		}
+		go models.Activities.Insert(activity)
+	})
+	return task, nil
+}
+
+// buildAndRunApp gets the app image, by building or pulling it, then
+// starts its container.
+func buildAndRunApp(repo *models.Repository, runtime *RepoRuntime, hostDir string, onLine func(string) error) error {
+	var err error
+	switch dc := runtime.DevContainer; {
+	case runtime.Dockerfile:
+		err = services.BuildApp(repo.Name, repo.LocalPath, "Dockerfile", onLine)
+	case dc.Image != "":
+		err = services.PullApp(repo.Name, dc.Image, onLine)
+	default:
+		err = services.BuildApp(repo.Name, path.Join(repo.LocalPath, dc.Context), dc.Dockerfile, onLine)
+	}
+	if err != nil {
+		return fmt.Errorf("build failed: %v", err)
+	}
+
+	onLine("Starting " + services.AppName(repo.Name))
+	return services.RunApp(repo.Name, hostDir)
+}
+
+// repoHostDir returns the host directory of a repository, which the app
+// container mounts.
+func repoHostDir(repo *models.Repository) (string, error) {
+	if hostDir, ok := services.CoderHostPath(repo.LocalPath); ok {
+		return hostDir, nil
+	}
+	if repo.StorageLocationID != "" {
+		location, err := models.StorageLocations.Get(repo.StorageLocationID)
+		if err == nil && location.HostPath != "" {
+			return path.Join(location.HostPath, strings.TrimPrefix(repo.LocalPath, location.ContainerPath)), nil
+		}
+	}
+	return "", fmt.Errorf("the host directory of %s isn't known - set the host path of its storage location", repo.Name)
+}
+
+// StopRepoApp stops and removes a repository's app container.
+func StopRepoApp(repoName string) error {
+	if err := services.StopApp(repoName); err != nil {
+		return err
+	}
+	go models.Activities.Insert(&models.Activity{
+		Type:        "repo_app_stop",
+		Repository:  repoName,
+		Description: fmt.Sprintf("Stopped %s", services.AppName(repoName)),
+		Author:      "System",
+		Timestamp:   time.Now(),
+	})
+	return nil
+}
+
+// RepoAppBuilding reports whether a repository's app is being built.
+func RepoAppBuilding(repoName string) bool {
+	buildingMu.Lock()
+	defer buildingMu.Unlock()
+	return building[repoName]
+}
+
+// RepoAppBuildLog returns the last lines of a repository's last build if
+// it failed, or nil.
+func RepoAppBuildLog(repoName string) []string {
+	appLogsMu.Lock()
+	defer appLogsMu.Unlock()
+	return appLogs[repoName]
+}
+
+// appLog keeps the last appLogLines lines written to it.
+type appLog struct {
+	lines []string
+}
+
+func (l *appLog) add(line string) {
+	l.lines = append(l.lines, line)
+	if len(l.lines) > appLogLines {
+		l.lines = l.lines[len(l.lines)-appLogLines:]
+	}
+}
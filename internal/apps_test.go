@@ -0,0 +1,73 @@
+package internal
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/The-Skyscape/devtools/pkg/testutils"
+)
+
+func TestParseRuntime(t *testing.T) {
+	runtime, err := parseRuntime("file Dockerfile\nfile docker-compose.yml\n")
+	testutils.AssertEqual(t, nil, err)
+	testutils.AssertEqual(t, true, runtime.Dockerfile)
+	testutils.AssertEqual(t, "docker-compose.yml", runtime.Compose)
+	testutils.AssertEqual(t, "Dockerfile", runtime.Source())
+	testutils.AssertEqual(t, true, runtime.DevContainer == nil)
+
+	runtime, err = parseRuntime("file docker-compose.yaml\n")
+	testutils.AssertEqual(t, nil, err)
+	testutils.AssertEqual(t, false, runtime.Runnable())
+
+	runtime, err = parseRuntime("devcontainer\n{\"image\": \"mcr.microsoft.com/devcontainers/go:1\"}\n")
+	testutils.AssertEqual(t, nil, err)
+	testutils.AssertEqual(t, true, runtime.Runnable())
+	testutils.AssertEqual(t, "mcr.microsoft.com/devcontainers/go:1", runtime.DevContainer.Image)
+}
+
+func TestParseDevContainer(t *testing.T) {
+	testCases := []struct {
+		name     string
+		content  string
+		expected string
+		err      string
+	}{
+		{"image with comments", `{
+			// Go toolchain
+			"image": "golang:1.22", /* pinned */
+			"forwardPorts": [8080,],
+		}`, "image golang:1.22", ""},
+		{"build next to it", `{"build": {"dockerfile": "Dockerfile"}}`, "build .devcontainer Dockerfile", ""},
+		{"build from the repository", `{"build": {"dockerfile": "Dockerfile", "context": ".."}}`, "build . .devcontainer/Dockerfile", ""},
+		{"older spelling", `{"dockerFile": "../Dockerfile.dev", "context": ".."}`, "build . Dockerfile.dev", ""},
+		{"url in a string", `{"image": "ghcr.io/acme/dev//x"}`, "image ghcr.io/acme/dev//x", ""},
+		{"outside the repository", `{"build": {"dockerfile": "Dockerfile", "context": "../.."}}`, "", "outside the repository"},
+		{"nothing to run", `{"name": "dev"}`, "", "neither an image nor a Dockerfile"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dc, err := parseDevContainer(tc.content)
+			if tc.err != "" {
+				testutils.AssertEqual(t, true, err != nil && strings.Contains(err.Error(), tc.err))
+				return
+			}
+			testutils.AssertEqual(t, nil, err)
+			summary := "image " + dc.Image
+			if dc.Image == "" {
+				summary = "build " + dc.Context + " " + dc.Dockerfile
+			}
+			testutils.AssertEqual(t, tc.expected, summary)
+		})
+	}
+}
+
+func TestAppLogKeepsLastLines(t *testing.T) {
+	log := &appLog{}
+	for i := range appLogLines + 10 {
+		log.add(fmt.Sprintf("step %d", i))
+	}
+	testutils.AssertEqual(t, appLogLines, len(log.lines))
+	testutils.AssertEqual(t, "step 10", log.lines[0])
+}
@@ -0,0 +1,158 @@
+package internal
+
+import (
+	"fmt"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+	"workbench/models"
+)
+
+// AccessRetention is how long access records are kept before pruning.
+const AccessRetention = 90 * 24 * time.Hour
+
+// accessCoalesceWindow groups repeated reads of the same path by the same
+// requester into a single record.
+const accessCoalesceWindow = time.Minute
+
+type accessKey struct {
+	routeType, repository, path, requester string
+}
+
+var (
+	accessMu     sync.Mutex
+	recentAccess = map[accessKey]*models.AccessRecord{}
+)
+
+// RecordAccess audits a read of repository data. Recording happens in the
+// background like activity logging so reads are never slowed down.
+// Parameters:
+//   - routeType: Read surface (file, list, history, blame, diff, edit, archive, share)
+//   - repository: Repository name
+//   - path: File path or ref that was read
+//   - bytes: Number of bytes returned
+//   - requester: Session user handle, token name, or share token
+//   - requesterType: session, token, or share
+//   - clientIP: Remote address of the requester
+func RecordAccess(routeType, repository, path string, bytes uint64, requester, requesterType, clientIP string) {
+	go recordAccess(&models.AccessRecord{
+		RouteType:     routeType,
+		Repository:    repository,
+		Path:          path,
+		Bytes:         bytes,
+		Requester:     requester,
+		RequesterType: requesterType,
+		ClientIP:      clientIP,
+	}, time.Now().UTC())
+}
+
+// recordAccess inserts a new record, or folds the access into an existing
+// record for the same requester and path seen within the coalesce window.
+func recordAccess(rec *models.AccessRecord, now time.Time) {
+	accessMu.Lock()
+	defer accessMu.Unlock()
+
+	forgetIdleAccess(now)
+	key := accessKey{rec.RouteType, rec.Repository, rec.Path, rec.Requester}
+	if existing, ok := recentAccess[key]; ok && now.Sub(existing.LastAccessAt) < accessCoalesceWindow {
+		existing.Count++
+		existing.Bytes += rec.Bytes
+		existing.LastAccessAt = now
+		if err := models.AccessRecords.Update(existing); err != nil {
+			slog.Error("failed to update access record", "error", err)
+		}
+		return
+	}
+
+	rec.Count = 1
+	rec.Timestamp = now
+	rec.LastAccessAt = now
+	inserted, err := models.AccessRecords.Insert(rec)
+	if err != nil {
+		slog.Error("failed to record access", "error", err)
+		return
+	}
+	recentAccess[key] = inserted
+}
+
+// forgetIdleAccess drops coalescing state for accesses that are past the
+// coalesce window, so the map only holds the last minute of reads.
+// Callers hold accessMu.
+func forgetIdleAccess(now time.Time) {
+	for key, rec := range recentAccess {
+		if now.Sub(rec.LastAccessAt) >= accessCoalesceWindow {
+			delete(recentAccess, key)
+		}
+	}
+}
+
+// SearchAccessRecords returns access records newest first, optionally
+// filtered by repository and a date range. Zero times leave that bound open.
+func SearchAccessRecords(repository string, from, to time.Time) ([]*models.AccessRecord, error) {
+	query, args := "WHERE 1 = 1", []any{}
+	if repository != "" {
+		query += " AND Repository = ?"
+		args = append(args, repository)
+	}
+	if !from.IsZero() {
+		query += " AND Timestamp >= ?"
+		args = append(args, from)
+	}
+	if !to.IsZero() {
+		query += " AND Timestamp < ?"
+		args = append(args, to)
+	}
+	return models.AccessRecords.Search(query+" ORDER BY Timestamp DESC LIMIT 500", args...)
+}
+
+// AccessRecordsCSV converts access records to CSV rows with a header.
+func AccessRecordsCSV(records []*models.AccessRecord) [][]string {
+	rows := [][]string{{"timestamp", "last_access", "route", "repository", "path", "bytes", "count", "requester", "requester_type", "client_ip"}}
+	for _, r := range records {
+		rows = append(rows, []string{
+			r.Timestamp.Format(time.RFC3339),
+			r.LastAccessAt.Format(time.RFC3339),
+			r.RouteType,
+			r.Repository,
+			r.Path,
+			strconv.FormatUint(r.Bytes, 10),
+			strconv.Itoa(r.Count),
+			r.Requester,
+			r.RequesterType,
+			r.ClientIP,
+		})
+	}
+	return rows
+}
+
+// The access pruning job removes access records older than
+// AccessRetention once an hour, and forgets coalescing state for idle
+// requesters.
+func init() {
+	RegisterJob(JobSpec{
+		Name:        "access-pruning",
+		Description: "Remove expired access audit records",
+		Interval:    Every(time.Hour),
+		Jitter:      5 * time.Minute,
+		Priority:    PriorityLow,
+		Run:         pruneAccessRecords,
+	})
+}
+
+func pruneAccessRecords(now time.Time) error {
+	accessMu.Lock()
+	forgetIdleAccess(now)
+	accessMu.Unlock()
+
+	expired, err := models.AccessRecords.Search("WHERE Timestamp < ?", now.Add(-AccessRetention))
+	if err != nil {
+		return fmt.Errorf("failed to load expired access records: %w", err)
+	}
+	for _, rec := range expired {
+		if err := models.AccessRecords.Delete(rec); err != nil {
+			slog.Error("failed to prune access record", "error", err)
+		}
+	}
+	return nil
+}
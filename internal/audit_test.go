@@ -0,0 +1,54 @@
+package internal
+
+import (
+	"testing"
+	"time"
+	"workbench/models"
+
+	"github.com/The-Skyscape/devtools/pkg/testutils"
+)
+
+func TestAccessRecordsCSV(t *testing.T) {
+	ts := time.Date(2024, 3, 5, 14, 7, 0, 0, time.UTC)
+	rows := AccessRecordsCSV([]*models.AccessRecord{{
+		RouteType:     "file",
+		Repository:    "api",
+		Path:          "main.go",
+		Bytes:         1024,
+		Count:         3,
+		Requester:     "admin",
+		RequesterType: "session",
+		ClientIP:      "10.0.0.1",
+		Timestamp:     ts,
+		LastAccessAt:  ts.Add(30 * time.Second),
+	}})
+
+	testutils.AssertEqual(t, 2, len(rows))
+	testutils.AssertEqual(t, "timestamp", rows[0][0])
+	testutils.AssertEqual(t, "2024-03-05T14:07:00Z", rows[1][0])
+	testutils.AssertEqual(t, "2024-03-05T14:07:30Z", rows[1][1])
+	testutils.AssertEqual(t, "main.go", rows[1][4])
+	testutils.AssertEqual(t, "1024", rows[1][5])
+	testutils.AssertEqual(t, "3", rows[1][6])
+}
+
+func TestForgetIdleAccess(t *testing.T) {
+	now := time.Date(2024, 3, 5, 14, 7, 0, 0, time.UTC)
+	idle := accessKey{"file", "api", "main.go", "admin"}
+	recent := accessKey{"file", "api", "go.mod", "admin"}
+
+	accessMu.Lock()
+	defer accessMu.Unlock()
+	saved := recentAccess
+	defer func() { recentAccess = saved }()
+	recentAccess = map[accessKey]*models.AccessRecord{
+		idle:   {LastAccessAt: now.Add(-accessCoalesceWindow)},
+		recent: {LastAccessAt: now.Add(-time.Second)},
+	}
+
+	forgetIdleAccess(now)
+	_, kept := recentAccess[recent]
+	_, leaked := recentAccess[idle]
+	testutils.AssertEqual(t, true, kept)
+	testutils.AssertEqual(t, false, leaked)
+}
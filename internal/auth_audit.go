@@ -0,0 +1,70 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+	"workbench/models"
+)
+
+// Authentication activity types. Failed signins say only "invalid
+// credentials", never which field was wrong or what was typed.
+const (
+	AuthSignin       = "auth_signin"
+	AuthSigninFailed = "auth_signin_failed"
+	AuthSignup       = "auth_signup"
+	AuthSignout      = "auth_signout"
+)
+
+// maxUserAgent is how much of a User-Agent header is kept.
+const maxUserAgent = 200
+
+// AuthClient is where an authentication request came from.
+type AuthClient struct {
+	IP        string `json:"ip"`
+	UserAgent string `json:"user_agent,omitempty"`
+}
+
+// RecordAuthEvent records an authentication activity with the client's
+// address and User-Agent in its metadata. handle is the account involved,
+// empty when it isn't known or, for failed signins, deliberately left out.
+func RecordAuthEvent(eventType, handle string, client AuthClient) {
+	go models.Activities.Insert(authActivity(eventType, handle, client, time.Now()))
+}
+
+// authActivity builds the activity RecordAuthEvent records.
+func authActivity(eventType, handle string, client AuthClient, at time.Time) *models.Activity {
+	client.UserAgent = truncateUserAgent(client.UserAgent)
+	metadata, _ := json.Marshal(client)
+
+	author := handle
+	if author == "" {
+		author = "System"
+	}
+	var description string
+	switch eventType {
+	case AuthSignin:
+		description = fmt.Sprintf("Signed in from %s", client.IP)
+	case AuthSigninFailed:
+		description = fmt.Sprintf("Failed signin from %s: invalid credentials", client.IP)
+	case AuthSignup:
+		description = fmt.Sprintf("Created the admin account from %s", client.IP)
+	case AuthSignout:
+		description = fmt.Sprintf("Signed out from %s", client.IP)
+	}
+	return &models.Activity{
+		Type:        eventType,
+		Description: description,
+		Author:      author,
+		Timestamp:   at,
+		Metadata:    string(metadata),
+	}
+}
+
+// truncateUserAgent keeps the first maxUserAgent bytes of a User-Agent.
+func truncateUserAgent(userAgent string) string {
+	if len(userAgent) > maxUserAgent {
+		return userAgent[:maxUserAgent]
+	}
+	return userAgent
+}
@@ -0,0 +1,29 @@
+package internal
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/The-Skyscape/devtools/pkg/testutils"
+)
+
+func TestAuthActivity(t *testing.T) {
+	at := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	client := AuthClient{IP: "203.0.113.7", UserAgent: "Mozilla/5.0"}
+
+	activity := authActivity(AuthSignin, "ada", client, at)
+	testutils.AssertEqual(t, "Signed in from 203.0.113.7", activity.Description)
+	testutils.AssertEqual(t, "ada", activity.Author)
+	testutils.AssertEqual(t, `{"ip":"203.0.113.7","user_agent":"Mozilla/5.0"}`, activity.Metadata)
+
+	activity = authActivity(AuthSigninFailed, "", client, at)
+	testutils.AssertEqual(t, "Failed signin from 203.0.113.7: invalid credentials", activity.Description)
+	testutils.AssertEqual(t, "System", activity.Author)
+}
+
+func TestAuthActivityTruncatesUserAgent(t *testing.T) {
+	client := AuthClient{IP: "203.0.113.7", UserAgent: strings.Repeat("x", 500)}
+	activity := authActivity(AuthSignout, "ada", client, time.Now())
+	testutils.AssertEqual(t, `{"ip":"203.0.113.7","user_agent":"`+strings.Repeat("x", maxUserAgent)+`"}`, activity.Metadata)
+}
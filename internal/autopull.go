@@ -0,0 +1,95 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+	"workbench/models"
+)
+
+// AutoPullIntervals are the background pull intervals offered in the UI,
+// in minutes. Zero turns auto-pull off, which is the default.
+var AutoPullIntervals = []int{0, 15, 60, 360, 1440}
+
+// AutoPullInterval returns how often repositories are pulled in the
+// background, or zero when auto-pull is off.
+func AutoPullInterval() time.Duration {
+	value, _ := models.GetSetting("autopull_interval")
+	return parseAutoPullInterval(value)
+}
+
+func parseAutoPullInterval(value string) time.Duration {
+	minutes, err := strconv.Atoi(value)
+	if err != nil || minutes <= 0 {
+		return 0
+	}
+	return time.Duration(minutes) * time.Minute
+}
+
+// SetAutoPullInterval saves the background pull interval in minutes, one
+// of AutoPullIntervals.
+func SetAutoPullInterval(minutes int) error {
+	valid := false
+	for _, m := range AutoPullIntervals {
+		valid = valid || m == minutes
+	}
+	if !valid {
+		return fmt.Errorf("invalid auto-pull interval")
+	}
+	if _, err := models.SetSetting("autopull_interval", strconv.Itoa(minutes), "autopull"); err != nil {
+		return fmt.Errorf("failed to save auto-pull interval")
+	}
+	return nil
+}
+
+// Auto-pull runs on the background scheduler every AutoPullInterval,
+// skipping hosts whose auto-pull is paused. While the coder container is
+// paused it skips, or wakes it, per coder_paused_jobs.
+func init() {
+	RegisterJob(JobSpec{
+		Name:        "autopull",
+		Description: "Pull repositories in the background",
+		Interval:    AutoPullInterval,
+		Immediate:   true,
+		Run:         func(time.Time) error { return WithCoderAwake("auto-pull", autoPull) },
+	})
+}
+
+// autoPull pulls every repository due, recording each as repo_autopull.
+// Failures are recorded as repo_pull_failed and don't stop the others.
+func autoPull() error {
+	repos, err := models.Repositories.Search("WHERE URL != '' ORDER BY Name ASC")
+	if err != nil {
+		return fmt.Errorf("failed to load repositories for auto-pull: %w", err)
+	}
+	for _, repo := range autoPullTargets(repos, AutoPullPaused) {
+		pullAndRecord(context.Background(), repo, "repo_autopull")
+	}
+	return nil
+}
+
+// autoPullTargets leaves out local-only repositories, unfinished clones,
+// and those on hosts with auto-pull paused. paused is asked once per host.
+func autoPullTargets(repos []*models.Repository, paused func(host string) bool) []*models.Repository {
+	hostPaused := map[string]bool{}
+	var targets []*models.Repository
+	for _, repo := range repos {
+		if repo.IsLocal() || repo.CloneStatus != models.CloneReady {
+			continue
+		}
+		host := repo.Host
+		if host == "" {
+			host = RepoHost(repo.URL)
+		}
+		p, ok := hostPaused[host]
+		if !ok {
+			p = paused(host)
+			hostPaused[host] = p
+		}
+		if !p {
+			targets = append(targets, repo)
+		}
+	}
+	return targets
+}
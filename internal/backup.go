@@ -0,0 +1,678 @@
+package internal
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+	"workbench/internal/providers"
+	"workbench/models"
+	"workbench/services"
+)
+
+// BackupVersion is the archive format written by CreateBackup. Archives
+// from newer versions are refused.
+const BackupVersion = 1
+
+// MaxBackupSize is the largest archive RestoreBackup accepts, compressed
+// or not.
+const MaxBackupSize = 64 << 20
+
+// MinBackupPassphrase is the shortest passphrase secrets are encrypted
+// with.
+const MinBackupPassphrase = 8
+
+// Files in a backup archive.
+const (
+	backupDocumentFile = "backup.json"
+	backupSecretsFile  = "secrets.enc" // Only with a passphrase
+)
+
+// backupKDFIterations is the PBKDF2-SHA256 work factor for the passphrase.
+const backupKDFIterations = 600_000
+
+// ErrInvalidBackup is returned by RestoreBackup for archives it can't
+// read.
+var ErrInvalidBackup = errors.New("invalid backup")
+
+// ErrBackupPassphrase is returned by RestoreBackup when the passphrase
+// doesn't open the archive's secrets.
+var ErrBackupPassphrase = errors.New("wrong backup passphrase")
+
+// BackupOptions choose what CreateBackup includes.
+type BackupOptions struct {
+	Passphrase string // Encrypts SSH private keys and tokens; empty leaves them out
+	Activities bool
+}
+
+// BackupDocument is backup.json: everything in a backup except secrets.
+// Repository contents aren't included; restoring clones them again.
+type BackupDocument struct {
+	Version     int                `json:"version"`
+	CreatedAt   time.Time          `json:"created_at"`
+	Workspace   *WorkspaceDocument `json:"workspace"` // Repositories, hooks, extensions, scaffold files, and preferences
+	Settings    map[string]string  `json:"settings"`  // Other saved settings, without secrets
+	GitIdentity *GitIdentity       `json:"git_identity,omitempty"`
+	DefaultKey  string             `json:"default_ssh_key,omitempty"` // Public key
+	SSHKeys     []BackupSSHKey     `json:"ssh_keys,omitempty"`
+	Credentials []string           `json:"git_credential_hosts,omitempty"`
+	Secrets     string             `json:"secrets"` // "encrypted" or "excluded"
+	Activities  []BackupActivity   `json:"activities,omitempty"`
+}
+
+// BackupSSHKey is a named SSH key's public half and hosts.
+type BackupSSHKey struct {
+	Name      string `json:"name"`
+	PublicKey string `json:"public_key"`
+	Hosts     string `json:"hosts,omitempty"`
+}
+
+// BackupActivity is an activity log entry.
+type BackupActivity struct {
+	Type        string    `json:"type"`
+	Repository  string    `json:"repository,omitempty"`
+	Description string    `json:"description"`
+	Author      string    `json:"author"`
+	Timestamp   time.Time `json:"timestamp"`
+	Metadata    string    `json:"metadata,omitempty"`
+}
+
+// backupSecrets is secrets.enc before encryption.
+type backupSecrets struct {
+	DefaultKey  string             `json:"default_ssh_key,omitempty"` // Private key
+	SSHKeys     map[string]string  `json:"ssh_keys,omitempty"`        // Private keys by name
+	Credentials []backupCredential `json:"git_credentials,omitempty"`
+	Settings    map[string]string  `json:"settings,omitempty"` // Provider tokens and the notification webhook
+}
+
+type backupCredential struct {
+	Host     string `json:"host"`
+	Username string `json:"username"`
+	Token    string `json:"token"`
+}
+
+// backupState is what a restore compares a backup with.
+type backupState struct {
+	Settings    map[string]string
+	Identity    *GitIdentity
+	DefaultKey  string // Public key
+	SSHKeys     map[string]bool
+	Credentials map[string]bool
+	Activities  map[string]bool // By activityKey
+}
+
+// Reads and writes used by backups. Replaced in tests.
+var (
+	backupExec         = services.CoderExec
+	backupExecWithOpts = services.CoderExecWithOptions
+	backupActivities   = func() ([]*models.Activity, error) {
+		return models.Activities.Search("ORDER BY Timestamp ASC")
+	}
+)
+
+// isSecretSetting reports whether a setting holds a secret, which only
+// goes in a backup's encrypted part.
+func isSecretSetting(key string) bool {
+	switch key {
+	case "notify_webhook_url", notifyWebhookSecretKey, smtpPasswordKey, MetricsTokenKey:
+		return true
+	}
+	return strings.HasPrefix(key, providers.TokenKey(""))
+}
+
+// CreateBackup writes a tar.gz backup of this instance to w and records a
+// backup_create activity.
+func CreateBackup(w io.Writer, opts BackupOptions) error {
+	if opts.Passphrase != "" && len(opts.Passphrase) < MinBackupPassphrase {
+		return fmt.Errorf("backup passphrase must be at least %d characters", MinBackupPassphrase)
+	}
+	workspace, err := SnapshotWorkspace("backup")
+	if err != nil {
+		return err
+	}
+	doc := &BackupDocument{
+		Version:     BackupVersion,
+		CreatedAt:   workspace.CreatedAt,
+		Workspace:   workspace,
+		Settings:    map[string]string{},
+		GitIdentity: GetGitIdentity(),
+		Secrets:     "excluded",
+	}
+	secrets := &backupSecrets{SSHKeys: map[string]string{}, Settings: map[string]string{}}
+
+	settings, err := storedSettings()
+	if err != nil {
+		return fmt.Errorf("failed to load settings")
+	}
+	for _, s := range settings {
+		def := models.LookupSetting(s.Key)
+		switch {
+		case s.Value == "" || def == nil:
+		case isSecretSetting(s.Key):
+			secrets.Settings[s.Key] = s.Value
+		case !def.Hidden && !slices.Contains(templatePreferences, s.Key):
+			doc.Settings[s.Key] = s.Value
+		}
+	}
+
+	if publicKey, err := GetPublicKey(""); err == nil {
+		doc.DefaultKey = publicKey
+		if opts.Passphrase != "" {
+			if secrets.DefaultKey, err = backupExec("cat ~/.ssh/id_ed25519 2>/dev/null || cat ~/.ssh/id_rsa 2>/dev/null"); err != nil {
+				return fmt.Errorf("failed to read the default SSH key")
+			}
+		}
+	}
+	keys, err := GetSSHKeys()
+	if err != nil {
+		return fmt.Errorf("failed to load SSH keys")
+	}
+	for _, key := range keys {
+		doc.SSHKeys = append(doc.SSHKeys, BackupSSHKey{Name: key.Name, PublicKey: key.PublicKey, Hosts: key.Hosts})
+		if opts.Passphrase != "" {
+			if secrets.SSHKeys[key.Name], err = backupExec("cat " + key.KeyPath); err != nil {
+				return fmt.Errorf("failed to read SSH key %s", key.Name)
+			}
+		}
+	}
+
+	creds, err := GetGitCredentials()
+	if err != nil {
+		return err
+	}
+	for _, cred := range creds {
+		doc.Credentials = append(doc.Credentials, cred.Host)
+		if opts.Passphrase != "" {
+			token, err := decryptCredential(cred.Token)
+			if err != nil {
+				return fmt.Errorf("failed to read the access token for %s: %w", cred.Host, err)
+			}
+			secrets.Credentials = append(secrets.Credentials, backupCredential{Host: cred.Host, Username: cred.Username, Token: token})
+		}
+	}
+
+	if opts.Activities {
+		activities, err := backupActivities()
+		if err != nil {
+			return fmt.Errorf("failed to load activities")
+		}
+		for _, a := range activities {
+			doc.Activities = append(doc.Activities, BackupActivity{
+				Type: a.Type, Repository: a.Repository, Description: a.Description,
+				Author: a.Author, Timestamp: a.Timestamp, Metadata: a.Metadata,
+			})
+		}
+	}
+
+	var sealed []byte
+	if opts.Passphrase != "" {
+		doc.Secrets = "encrypted"
+		plain, err := json.Marshal(secrets)
+		if err != nil {
+			return err
+		}
+		if sealed, err = sealBackupSecrets(plain, opts.Passphrase); err != nil {
+			return err
+		}
+	}
+	if err := writeBackupArchive(w, doc, sealed); err != nil {
+		return fmt.Errorf("failed to write backup: %w", err)
+	}
+
+	description := fmt.Sprintf("Created a backup of %d repositories without secrets", len(workspace.Repositories))
+	if opts.Passphrase != "" {
+		description = fmt.Sprintf("Created a backup of %d repositories with encrypted secrets", len(workspace.Repositories))
+	}
+	go models.Activities.Insert(&models.Activity{
+		Type:        "backup_create",
+		Description: description,
+		Author:      "System",
+		Timestamp:   time.Now(),
+	})
+	return nil
+}
+
+// RestoreBackup recreates what a backup holds on this instance and returns
+// every item with its result. Items that already exist are skipped, so
+// restoring the same backup twice changes nothing the second time. An
+// error means the archive was refused and nothing was restored.
+func RestoreBackup(archive io.Reader, passphrase string) ([]*TemplateChange, error) {
+	doc, sealed, err := readBackupArchive(archive)
+	if err != nil {
+		return nil, err
+	}
+	var secrets *backupSecrets
+	if sealed != nil && passphrase != "" {
+		plain, err := openBackupSecrets(sealed, passphrase)
+		if err != nil {
+			return nil, err
+		}
+		secrets = &backupSecrets{}
+		if err := json.Unmarshal(plain, secrets); err != nil {
+			return nil, fmt.Errorf("%w: malformed secrets", ErrInvalidBackup)
+		}
+	}
+
+	current, err := SnapshotWorkspace("current")
+	if err != nil {
+		return nil, err
+	}
+	state, err := currentBackupState()
+	if err != nil {
+		return nil, err
+	}
+
+	// Keys and tokens come first so private repositories can be cloned
+	changes := planBackupRestore(doc, secrets, state)
+	changes = append(changes, PlanWorkspaceTemplate(doc.Workspace, current)...)
+	changes = append(changes, planActivitiesRestore(doc.Activities, state.Activities)...)
+
+	applied, failed := applyChanges(changes)
+	activity := &models.Activity{
+		Type:        "backup_restore",
+		Description: fmt.Sprintf("Restored a backup from %s: %d items", doc.CreatedAt.Format("2006-01-02 15:04 MST"), applied),
+		Author:      "System",
+		Timestamp:   time.Now(),
+	}
+	if failed > 0 {
+		activity.Type = "backup_restore_failed"
+		activity.Description = fmt.Sprintf("Restored a backup from %s: %d items, %d failed", doc.CreatedAt.Format("2006-01-02 15:04 MST"), applied, failed)
+	}
+	go models.Activities.Insert(activity)
+	return changes, nil
+}
+
+// currentBackupState reads what this instance already has.
+func currentBackupState() (*backupState, error) {
+	state := &backupState{
+		Settings:    map[string]string{},
+		Identity:    GetGitIdentity(),
+		SSHKeys:     map[string]bool{},
+		Credentials: map[string]bool{},
+		Activities:  map[string]bool{},
+	}
+	state.DefaultKey, _ = GetPublicKey("")
+
+	settings, err := storedSettings()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load settings")
+	}
+	for _, s := range settings {
+		state.Settings[s.Key] = s.Value
+	}
+	keys, err := GetSSHKeys()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load SSH keys")
+	}
+	for _, key := range keys {
+		state.SSHKeys[key.Name] = true
+	}
+	creds, err := GetGitCredentials()
+	if err != nil {
+		return nil, err
+	}
+	for _, cred := range creds {
+		state.Credentials[cred.Host] = true
+	}
+	activities, err := backupActivities()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load activities")
+	}
+	for _, a := range activities {
+		state.Activities[activityKey(a.Type, a.Description, a.Timestamp)] = true
+	}
+	return state, nil
+}
+
+// planBackupRestore lists restoring a backup's SSH keys, access tokens,
+// git identity, and settings. secrets is nil when the backup has none or
+// they weren't unlocked; each secret is then skipped.
+func planBackupRestore(doc *BackupDocument, secrets *backupSecrets, state *backupState) []*TemplateChange {
+	var changes []*TemplateChange
+	locked := "encrypted - restore with the backup passphrase"
+	if doc.Secrets != "encrypted" {
+		locked = "not in the backup - it was made without a passphrase"
+	}
+
+	if doc.DefaultKey != "" {
+		change := &TemplateChange{Kind: "ssh key", Item: "default"}
+		switch {
+		case sameSSHKey(doc.DefaultKey, state.DefaultKey):
+			change.Action, change.Note = ChangeSkip, "already in use"
+		case secrets == nil || secrets.DefaultKey == "":
+			change.Action, change.Note = ChangeSkip, locked
+		default:
+			change.Action, change.Note = ChangeAdd, "write the default key"
+			if state.DefaultKey != "" {
+				change.Action, change.Note = ChangeUpdate, "replace the current default key, keeping it as *.before-restore"
+			}
+			privateKey, rsa := secrets.DefaultKey, strings.HasPrefix(doc.DefaultKey, "ssh-rsa ")
+			change.apply = func() error { return restoreDefaultSSHKey(privateKey, rsa) }
+		}
+		changes = append(changes, change)
+	}
+
+	for _, key := range doc.SSHKeys {
+		change := &TemplateChange{Kind: "ssh key", Item: key.Name}
+		privateKey := ""
+		if secrets != nil {
+			privateKey = secrets.SSHKeys[key.Name]
+		}
+		switch {
+		case state.SSHKeys[key.Name]:
+			change.Action, change.Note = ChangeSkip, "a key with this name exists"
+		case privateKey == "":
+			change.Action, change.Note = ChangeSkip, locked
+		default:
+			change.Action, change.Note = ChangeAdd, "import"
+			if key.Hosts != "" {
+				change.Note += " for " + key.Hosts
+			}
+			name, hosts := key.Name, key.Hosts
+			change.apply = func() error {
+				if _, err := ImportNamedSSHKey(name, privateKey); err != nil {
+					return err
+				}
+				if hosts == "" {
+					return nil
+				}
+				if err := SetSSHKeyHosts(name, hosts); err != nil {
+					return fmt.Errorf("imported, but failed to map hosts: %w", err)
+				}
+				return nil
+			}
+		}
+		changes = append(changes, change)
+	}
+
+	tokens := map[string]backupCredential{}
+	if secrets != nil {
+		for _, cred := range secrets.Credentials {
+			tokens[cred.Host] = cred
+		}
+	}
+	for _, host := range doc.Credentials {
+		change := &TemplateChange{Kind: "access token", Item: host}
+		cred, ok := tokens[host]
+		switch {
+		case state.Credentials[host]:
+			change.Action, change.Note = ChangeSkip, "a token is already saved"
+		case !ok:
+			change.Action, change.Note = ChangeSkip, locked
+		default:
+			change.Action, change.Note = ChangeAdd, "save for "+cred.Username
+			change.apply = func() error { return SetGitCredential(cred.Host, cred.Username, cred.Token) }
+		}
+		changes = append(changes, change)
+	}
+
+	if id := doc.GitIdentity; id != nil {
+		change := &TemplateChange{Kind: "git identity", Item: id.String()}
+		switch old := state.Identity; {
+		case old != nil && old.Name == id.Name && old.Email == id.Email:
+			change.Action, change.Note = ChangeSkip, "already configured"
+		case old == nil:
+			change.Action, change.Note = ChangeAdd, "set git config user.name and user.email"
+		default:
+			change.Action, change.Note = ChangeUpdate, old.String()+" → "+id.String()
+		}
+		change.apply = func() error { return ConfigureGitUser(id.Name, id.Email) }
+		changes = append(changes, change)
+	}
+
+	for _, key := range slices.Sorted(maps.Keys(doc.Settings)) {
+		changes = append(changes, planSettingRestore(key, doc.Settings[key], state.Settings[key], false))
+	}
+	if secrets != nil {
+		for _, key := range slices.Sorted(maps.Keys(secrets.Settings)) {
+			changes = append(changes, planSettingRestore(key, secrets.Settings[key], state.Settings[key], true))
+		}
+	}
+	return changes
+}
+
+// planSettingRestore plans restoring one setting. Secret values are kept
+// out of the note.
+func planSettingRestore(key, value, old string, secret bool) *TemplateChange {
+	change := &TemplateChange{Kind: "setting", Item: key}
+	def := models.LookupSetting(key)
+	shown, oldShown := value, old
+	if secret {
+		shown, oldShown = "a saved value", "a different value"
+	}
+	switch {
+	case def == nil || (def.Hidden && !secret):
+		change.Action, change.Note = ChangeSkip, "not a setting in this version"
+	case old == value:
+		change.Action, change.Note = ChangeSkip, "already set"
+	case old == "":
+		change.Action, change.Note = ChangeAdd, "set to "+shown
+	default:
+		change.Action, change.Note = ChangeUpdate, oldShown+" → "+shown
+	}
+	if host, ok := strings.CutPrefix(key, providers.TokenKey("")); ok {
+		change.apply = func() error { return providers.SetToken(host, value) }
+		return change
+	}
+	if def != nil && def.Hidden {
+		// Secrets kept off the settings page, which SaveSetting refuses
+		change.apply = func() error {
+			_, err := models.SetSetting(key, value, "system")
+			return err
+		}
+		return change
+	}
+	change.apply = func() error {
+		entry, err := SaveSetting(key, value)
+		if err != nil {
+			return err
+		}
+		if entry.Error != "" {
+			return errors.New(entry.Error)
+		}
+		return nil
+	}
+	return change
+}
+
+// planActivitiesRestore adds the backup's activities this instance doesn't
+// already have, as one item.
+func planActivitiesRestore(activities []BackupActivity, existing map[string]bool) []*TemplateChange {
+	if len(activities) == 0 {
+		return nil
+	}
+	var missing []BackupActivity
+	for _, a := range activities {
+		if !existing[activityKey(a.Type, a.Description, a.Timestamp)] {
+			missing = append(missing, a)
+		}
+	}
+	change := &TemplateChange{Kind: "activity log", Item: fmt.Sprintf("%d entries", len(activities))}
+	if len(missing) == 0 {
+		change.Action, change.Note = ChangeSkip, "already in the activity log"
+		return []*TemplateChange{change}
+	}
+	change.Action, change.Note = ChangeAdd, fmt.Sprintf("add %d missing entries", len(missing))
+	change.apply = func() error {
+		for _, a := range missing {
+			if _, err := models.Activities.Insert(&models.Activity{
+				Type: a.Type, Repository: a.Repository, Description: a.Description,
+				Author: a.Author, Timestamp: a.Timestamp, Metadata: a.Metadata,
+			}); err != nil {
+				return fmt.Errorf("failed to add activities")
+			}
+		}
+		return nil
+	}
+	return []*TemplateChange{change}
+}
+
+// activityKey identifies an activity across instances, where IDs differ.
+func activityKey(kind, description string, at time.Time) string {
+	return kind + "\x00" + description + "\x00" + at.UTC().Format(time.RFC3339Nano)
+}
+
+// sameSSHKey compares public keys by type and key, ignoring comments.
+func sameSSHKey(a, b string) bool {
+	fa, fb := strings.Fields(a), strings.Fields(b)
+	return len(fa) >= 2 && len(fb) >= 2 && fa[0] == fb[0] && fa[1] == fb[1]
+}
+
+// restoreDefaultSSHKey writes privateKey as the default key, moving any
+// existing default key pair aside rather than deleting it.
+func restoreDefaultSSHKey(privateKey string, rsa bool) error {
+	path := "~/.ssh/id_ed25519"
+	if rsa {
+		path = "~/.ssh/id_rsa"
+	}
+	suffix := fmt.Sprintf(".before-restore-%d", time.Now().Unix())
+	cmd := fmt.Sprintf(`mkdir -p ~/.ssh && chmod 700 ~/.ssh && for key in ~/.ssh/id_ed25519 ~/.ssh/id_rsa; do
+		if [ -f "$key" ]; then mv "$key" "$key%[1]s" && { [ ! -f "$key.pub" ] || mv "$key.pub" "$key%[1]s.pub"; }; fi
+	done && umask 077 && cat > %[2]s && ssh-keygen -y -P "" -f %[2]s > %[2]s.pub 2>&1`, suffix, path)
+	privateKey = strings.TrimSpace(strings.ReplaceAll(privateKey, "\r\n", "\n")) + "\n"
+	if result, err := backupExecWithOpts(cmd, services.ExecOptions{Stdin: strings.NewReader(privateKey)}); err != nil {
+		return fmt.Errorf("failed to write the default key: %s", strings.TrimSpace(result.Output))
+	}
+	return enforceSSHKeyModes()
+}
+
+// writeBackupArchive writes backup.json and, when present, the sealed
+// secrets as a tar.gz.
+func writeBackupArchive(w io.Writer, doc *BackupDocument, sealed []byte) error {
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	add := func(name string, content []byte) error {
+		header := &tar.Header{Name: name, Mode: 0600, Size: int64(len(content)), ModTime: doc.CreatedAt}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		_, err := tw.Write(content)
+		return err
+	}
+	if err := add(backupDocumentFile, data); err != nil {
+		return err
+	}
+	if sealed != nil {
+		if err := add(backupSecretsFile, sealed); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// readBackupArchive reads and checks a backup archive, returning its
+// document and sealed secrets, nil when it has none.
+func readBackupArchive(r io.Reader) (*BackupDocument, []byte, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: not a tar.gz archive", ErrInvalidBackup)
+	}
+	tr := tar.NewReader(io.LimitReader(gz, MaxBackupSize))
+
+	var data, sealed []byte
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: %v", ErrInvalidBackup, err)
+		}
+		var content bytes.Buffer
+		if _, err := io.Copy(&content, tr); err != nil {
+			return nil, nil, fmt.Errorf("%w: %v", ErrInvalidBackup, err)
+		}
+		switch header.Name {
+		case backupDocumentFile:
+			data = content.Bytes()
+		case backupSecretsFile:
+			sealed = content.Bytes()
+		}
+	}
+	if data == nil {
+		return nil, nil, fmt.Errorf("%w: %s is missing", ErrInvalidBackup, backupDocumentFile)
+	}
+
+	var doc BackupDocument
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrInvalidBackup, err)
+	}
+	switch {
+	case doc.Version < 1:
+		return nil, nil, fmt.Errorf("%w: missing version", ErrInvalidBackup)
+	case doc.Version > BackupVersion:
+		return nil, nil, fmt.Errorf("%w: version %d is newer than this workbench supports (%d)", ErrInvalidBackup, doc.Version, BackupVersion)
+	case doc.Workspace == nil:
+		return nil, nil, fmt.Errorf("%w: missing workspace", ErrInvalidBackup)
+	}
+	return &doc, sealed, nil
+}
+
+// sealBackupSecrets encrypts data with AES-GCM under a key derived from
+// passphrase, returning the salt, then the nonce, then the ciphertext.
+func sealBackupSecrets(data []byte, passphrase string) ([]byte, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("failed to encrypt secrets: %w", err)
+	}
+	gcm, err := backupCipher(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to encrypt secrets: %w", err)
+	}
+	return gcm.Seal(append(salt, nonce...), nonce, data, nil), nil
+}
+
+// openBackupSecrets decrypts secrets sealed by sealBackupSecrets.
+func openBackupSecrets(sealed []byte, passphrase string) ([]byte, error) {
+	if len(sealed) < 16 {
+		return nil, fmt.Errorf("%w: malformed secrets", ErrInvalidBackup)
+	}
+	gcm, err := backupCipher(passphrase, sealed[:16])
+	if err != nil {
+		return nil, err
+	}
+	rest := sealed[16:]
+	if len(rest) < gcm.NonceSize() {
+		return nil, fmt.Errorf("%w: malformed secrets", ErrInvalidBackup)
+	}
+	plain, err := gcm.Open(nil, rest[:gcm.NonceSize()], rest[gcm.NonceSize():], nil)
+	if err != nil {
+		return nil, ErrBackupPassphrase
+	}
+	return plain, nil
+}
+
+func backupCipher(passphrase string, salt []byte) (cipher.AEAD, error) {
+	key, err := pbkdf2.Key(sha256.New, passphrase, salt, backupKDFIterations, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,147 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"workbench/models"
+	"workbench/services"
+)
+
+// ErrDirtyWorkingTree is returned by CheckoutBranch when uncommitted
+// changes to tracked files would be carried over or overwritten.
+var ErrDirtyWorkingTree = errors.New("uncommitted changes - commit or stash changes first")
+
+// Branch is a branch a repository can be switched to.
+type Branch struct {
+	Name    string // Local name, e.g. "feature/login"
+	Current bool
+	Remote  string // Remote ref, e.g. "origin/feature/login", for branches only on a remote
+}
+
+// branchExec runs git commands in the coder container. Replaced in tests.
+var branchExec = services.CoderExec
+
+// ListBranches returns a repository's local branches followed by branches
+// that exist only on a remote, with the checked-out branch flagged.
+func ListBranches(repoName string) ([]*Branch, error) {
+	repo, err := models.Repositories.Find("WHERE Name = ?", repoName)
+	if err != nil {
+		return nil, fmt.Errorf("repository '%s' not found", repoName)
+	}
+	return listBranches(repo)
+}
+
+func listBranches(repo *models.Repository) ([]*Branch, error) {
+	output, err := branchExec(fmt.Sprintf("cd %s && git branch -a --no-color 2>&1", shellQuote(repo.LocalPath)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list branches: %s", strings.TrimSpace(output))
+	}
+	return parseBranches(output), nil
+}
+
+// parseBranches parses git branch -a output. Remote branches are named
+// without their remote and left out when a local branch has the same
+// name; symbolic refs like origin/HEAD and detached HEADs are skipped.
+func parseBranches(output string) []*Branch {
+	var local, remote []*Branch
+	seen := map[string]bool{}
+	for _, line := range strings.Split(output, "\n") {
+		if len(line) < 3 {
+			continue
+		}
+		current := line[0] == '*'
+		name := strings.TrimSpace(line[2:])
+		if strings.HasPrefix(name, "(") || strings.Contains(name, " -> ") {
+			continue
+		}
+
+		if ref, ok := strings.CutPrefix(name, "remotes/"); ok {
+			_, short, found := strings.Cut(ref, "/")
+			if !found || short == "" {
+				continue
+			}
+			remote = append(remote, &Branch{Name: short, Remote: ref})
+			continue
+		}
+		seen[name] = true
+		local = append(local, &Branch{Name: name, Current: current})
+	}
+
+	for _, branch := range remote {
+		if !seen[branch.Name] {
+			seen[branch.Name] = true
+			local = append(local, branch)
+		}
+	}
+	return local
+}
+
+// CheckoutBranch switches a repository to branch, creating a local
+// tracking branch when it only exists on the remote. Returns
+// ErrDirtyWorkingTree rather than switching with uncommitted changes.
+func CheckoutBranch(repoName, branch string) error {
+	branch = strings.TrimSpace(branch)
+	if !validDefaultBranch(branch) {
+		return fmt.Errorf("invalid branch name")
+	}
+	repo, err := models.Repositories.Find("WHERE Name = ?", repoName)
+	if err != nil {
+		return fmt.Errorf("repository '%s' not found", repoName)
+	}
+
+	switched, err := checkoutBranch(repo, branch)
+	if err != nil || !switched {
+		return err
+	}
+	go models.Activities.Insert(&models.Activity{
+		Type:        "repo_checkout",
+		Repository:  repoName,
+		Description: fmt.Sprintf("Switched %s to branch %s", repoName, branch),
+		Author:      "System",
+		Timestamp:   time.Now(),
+	})
+	return nil
+}
+
+// checkoutBranch switches repo to branch, reporting whether it changed
+// branches; checking out the current branch does nothing.
+func checkoutBranch(repo *models.Repository, branch string) (bool, error) {
+	branches, err := listBranches(repo)
+	if err != nil {
+		return false, err
+	}
+	var target *Branch
+	for _, b := range branches {
+		if b.Name == branch {
+			target = b
+		}
+	}
+	if target == nil {
+		return false, fmt.Errorf("branch '%s' not found in %s", branch, repo.Name)
+	}
+	if target.Current {
+		return false, nil
+	}
+
+	changes, err := branchExec(fmt.Sprintf("cd %s && git status --porcelain --untracked-files=no 2>&1", shellQuote(repo.LocalPath)))
+	if err != nil {
+		return false, fmt.Errorf("failed to read status: %s", strings.TrimSpace(changes))
+	}
+	if strings.TrimSpace(changes) != "" {
+		return false, ErrDirtyWorkingTree
+	}
+
+	cmd := "git checkout " + shellQuote(branch)
+	if target.Remote != "" {
+		cmd = "git checkout --track " + shellQuote(target.Remote)
+	}
+	if output, err := branchExec(fmt.Sprintf("cd %s && %s 2>&1", shellQuote(repo.LocalPath), cmd)); err != nil {
+		if strings.Contains(output, "local changes") || strings.Contains(output, "would be overwritten") {
+			return false, ErrDirtyWorkingTree
+		}
+		return false, fmt.Errorf("failed to check out %s: %s", branch, strings.TrimSpace(output))
+	}
+	return true, nil
+}
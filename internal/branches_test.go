@@ -0,0 +1,109 @@
+package internal
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"workbench/models"
+
+	"github.com/The-Skyscape/devtools/pkg/testutils"
+)
+
+func TestParseBranches(t *testing.T) {
+	output := "  feature/login\n" +
+		"* main\n" +
+		"  remotes/origin/HEAD -> origin/main\n" +
+		"  remotes/origin/main\n" +
+		"  remotes/origin/release/1.0\n" +
+		"  remotes/upstream/docs\n"
+
+	branches := parseBranches(output)
+	testutils.AssertEqual(t, 4, len(branches))
+	testutils.AssertEqual(t, "feature/login", branches[0].Name)
+	testutils.AssertEqual(t, false, branches[0].Current)
+	testutils.AssertEqual(t, "main", branches[1].Name)
+	testutils.AssertEqual(t, true, branches[1].Current)
+	testutils.AssertEqual(t, "", branches[1].Remote)
+	testutils.AssertEqual(t, "release/1.0", branches[2].Name)
+	testutils.AssertEqual(t, "origin/release/1.0", branches[2].Remote)
+	testutils.AssertEqual(t, "upstream/docs", branches[3].Remote)
+}
+
+func TestParseBranchesDetached(t *testing.T) {
+	branches := parseBranches("* (HEAD detached at 1a2b3c4)\n  main\n")
+	testutils.AssertEqual(t, 1, len(branches))
+	testutils.AssertEqual(t, false, branches[0].Current)
+}
+
+// fakeBranchExec answers git commands from a fixed branch list and status,
+// recording the checkout command that was run.
+func fakeBranchExec(t *testing.T, branches, status string, checkoutErr error) *string {
+	t.Helper()
+	var checkout string
+	original := branchExec
+	branchExec = func(cmd string) (string, error) {
+		switch {
+		case strings.Contains(cmd, "git branch -a"):
+			return branches, nil
+		case strings.Contains(cmd, "git status"):
+			return status, nil
+		case strings.Contains(cmd, "git checkout"):
+			checkout = cmd
+			if checkoutErr != nil {
+				return "error: The following untracked working tree files would be overwritten by checkout", checkoutErr
+			}
+			return "", nil
+		}
+		t.Fatalf("unexpected command: %s", cmd)
+		return "", nil
+	}
+	t.Cleanup(func() { branchExec = original })
+	return &checkout
+}
+
+func TestCheckoutBranch(t *testing.T) {
+	repo := &models.Repository{Name: "api", LocalPath: "/home/coder/repos/api"}
+	branches := "* main\n  dev\n  remotes/origin/main\n  remotes/origin/feature\n"
+
+	t.Run("local branch", func(t *testing.T) {
+		checkout := fakeBranchExec(t, branches, "", nil)
+		switched, err := checkoutBranch(repo, "dev")
+		testutils.AssertEqual(t, nil, err)
+		testutils.AssertEqual(t, true, switched)
+		testutils.AssertEqual(t, true, strings.Contains(*checkout, "git checkout 'dev'"))
+	})
+
+	t.Run("remote only creates a tracking branch", func(t *testing.T) {
+		checkout := fakeBranchExec(t, branches, "", nil)
+		_, err := checkoutBranch(repo, "feature")
+		testutils.AssertEqual(t, nil, err)
+		testutils.AssertEqual(t, true, strings.Contains(*checkout, "git checkout --track 'origin/feature'"))
+	})
+
+	t.Run("current branch does nothing", func(t *testing.T) {
+		checkout := fakeBranchExec(t, branches, "", nil)
+		switched, err := checkoutBranch(repo, "main")
+		testutils.AssertEqual(t, nil, err)
+		testutils.AssertEqual(t, false, switched)
+		testutils.AssertEqual(t, "", *checkout)
+	})
+
+	t.Run("dirty tree", func(t *testing.T) {
+		checkout := fakeBranchExec(t, branches, " M README.md\n", nil)
+		_, err := checkoutBranch(repo, "dev")
+		testutils.AssertEqual(t, true, errors.Is(err, ErrDirtyWorkingTree))
+		testutils.AssertEqual(t, "", *checkout)
+	})
+
+	t.Run("checkout refused by git", func(t *testing.T) {
+		fakeBranchExec(t, branches, "", errors.New("exit status 1"))
+		_, err := checkoutBranch(repo, "dev")
+		testutils.AssertEqual(t, true, errors.Is(err, ErrDirtyWorkingTree))
+	})
+
+	t.Run("unknown branch", func(t *testing.T) {
+		fakeBranchExec(t, branches, "", nil)
+		_, err := checkoutBranch(repo, "nope")
+		testutils.AssertEqual(t, "branch 'nope' not found in api", err.Error())
+	})
+}
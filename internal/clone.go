@@ -0,0 +1,255 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"workbench/models"
+	"workbench/services"
+)
+
+// maxCloneOutput caps the clone output kept for classifying a failure.
+// Progress updates aren't kept, only the lines git finishes.
+const maxCloneOutput = 64 * 1024
+
+// CloneProgress is how far a running clone has got, from git's progress
+// output.
+type CloneProgress struct {
+	Phase   string // e.g. "Receiving objects"; empty until git reports one
+	Percent int    // Of the phase
+}
+
+// String describes the progress, e.g. "Receiving objects: 42%".
+func (p *CloneProgress) String() string {
+	if p.Phase == "" {
+		return "Starting clone…"
+	}
+	return fmt.Sprintf("%s: %d%%", p.Phase, p.Percent)
+}
+
+// progressLine matches a git progress update, e.g.
+// "remote: Counting objects:  12% (1/8)" or
+// "Receiving objects:  42% (420/1000), 1.20 MiB | 2.00 MiB/s".
+var progressLine = regexp.MustCompile(`^(?:remote: )?([A-Z][a-z]+(?: [a-z]+)*):\s+(\d{1,3})%`)
+
+// parseCloneProgress reads one line of git clone --progress output.
+func parseCloneProgress(line string) (CloneProgress, bool) {
+	m := progressLine.FindStringSubmatch(strings.TrimSpace(line))
+	if m == nil {
+		return CloneProgress{}, false
+	}
+	percent, _ := strconv.Atoi(m[2])
+	return CloneProgress{Phase: m[1], Percent: min(percent, 100)}, true
+}
+
+// Progress of running clones by repository name.
+var cloneProgress = struct {
+	sync.Mutex
+	byName map[string]*CloneProgress
+}{byName: map[string]*CloneProgress{}}
+
+func setCloneProgress(name string, progress *CloneProgress) {
+	cloneProgress.Lock()
+	defer cloneProgress.Unlock()
+	if progress == nil {
+		delete(cloneProgress.byName, name)
+		return
+	}
+	cloneProgress.byName[name] = progress
+}
+
+// GetCloneProgress returns how far a repository's running clone has got,
+// or nil when none is running.
+func GetCloneProgress(name string) *CloneProgress {
+	cloneProgress.Lock()
+	defer cloneProgress.Unlock()
+	if progress, ok := cloneProgress.byName[name]; ok {
+		copied := *progress
+		return &copied
+	}
+	return nil
+}
+
+// cloneExec runs git clone with args in the coder container, passing each
+// progress update and output line to onLine, and returns the output lines.
+// Cancelling ctx kills the clone. Replaced in tests.
+var cloneExec = func(ctx context.Context, args []string, onLine func(string)) (string, error) {
+	output := services.CoderExecArgsReader(ctx, append([]string{"git", "clone"}, args...)...)
+	defer output.Close()
+
+	var kept bytes.Buffer
+	scanner := bufio.NewScanner(output)
+	scanner.Split(scanProgressLines)
+	for scanner.Scan() {
+		line := scanner.Text()
+		onLine(line)
+		if _, progress := parseCloneProgress(line); !progress && kept.Len() < maxCloneOutput {
+			kept.WriteString(line + "\n")
+		}
+	}
+	return kept.String(), scanner.Err()
+}
+
+// scanProgressLines splits output at newlines and at the carriage returns
+// git ends progress updates with, so each update arrives as it's printed.
+func scanProgressLines(data []byte, atEOF bool) (int, []byte, error) {
+	if i := bytes.IndexAny(data, "\r\n"); i >= 0 {
+		return i + 1, data[:i], nil
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
+
+// Seams for saving clone records. Replaced in tests.
+var (
+	insertRepo = func(repo *models.Repository) (*models.Repository, error) { return models.Repositories.Insert(repo) }
+	updateRepo = func(repo *models.Repository) error { return models.Repositories.Update(repo) }
+	deleteRepo = func(repo *models.Repository) error { return models.Repositories.Delete(repo) }
+)
+
+// runClone clones a repository recorded as CloneCloning into its
+// LocalPath in the background, tracking progress for GetCloneProgress.
+// On success the record becomes ready. On failure the partial directory
+// is removed and the record is kept as CloneFailed with the error, or
+// deleted when keepFailed is false. The returned channel receives the
+// outcome. Cancelling ctx kills the clone, which then fails.
+func runClone(ctx context.Context, repo *models.Repository, keepFailed bool) <-chan error {
+	done := make(chan error, 1)
+	setCloneProgress(repo.Name, &CloneProgress{})
+	go func() {
+		defer setCloneProgress(repo.Name, nil)
+		output, err := cloneExec(ctx, []string{"--progress", "--", repo.URL, repo.LocalPath}, func(line string) {
+			if progress, ok := parseCloneProgress(line); ok {
+				setCloneProgress(repo.Name, &progress)
+			}
+		})
+		if err != nil {
+			err = classifyRemoteError(output, err, "failed to clone repository")
+			slog.WarnContext(ctx, "clone failed", "name", repo.Name, "error", err)
+			finishFailedClone(repo, err, keepFailed)
+			done <- err
+			return
+		}
+		slog.InfoContext(ctx, "cloned repository", "name", repo.Name)
+		done <- finishClone(repo)
+	}()
+	return done
+}
+
+// finishClone marks a cloned repository ready, noting whether it uses
+// Git LFS, and runs what follows a clone: the activity, post-clone hooks,
+// the identity suggestion, and the provider metadata.
+func finishClone(repo *models.Repository) error {
+	repo.CloneStatus, repo.CloneError = models.CloneReady, ""
+	detectLFS(repo)
+	if err := updateRepo(repo); err != nil {
+		return fmt.Errorf("failed to save repository: %w", err)
+	}
+
+	go models.Activities.Insert(&models.Activity{
+		Type:        "repo_clone",
+		Repository:  repo.Name,
+		Description: fmt.Sprintf("Cloned repository %s", repo.Name),
+		Author:      "System",
+		Timestamp:   time.Now(),
+	})
+
+	// Run any setup steps the user registered for this repository
+	go runPostCloneHooks(repo.Name)
+
+	// Offer an identity for the dashboard banner if none is configured
+	go SuggestGitIdentity(repo)
+
+	// Fill in the card from the provider; failures only lose the badges
+	go FetchRepositoryMetadata(repo.Name, false)
+	return nil
+}
+
+// finishFailedClone removes what a failed clone left on disk and records
+// the failure.
+func finishFailedClone(repo *models.Repository, err error, keep bool) {
+	repoExec("rm", "-rf", "--", repo.LocalPath)
+	if keep {
+		repo.CloneStatus, repo.CloneError = models.CloneFailed, err.Error()
+		if err := updateRepo(repo); err != nil {
+			slog.Error("failed to record failed clone", "name", repo.Name, "error", err)
+		}
+	} else if err := deleteRepo(repo); err != nil {
+		slog.Error("failed to remove record of failed clone", "name", repo.Name, "error", err)
+	}
+
+	go models.Activities.Insert(&models.Activity{
+		Type:        "repo_clone_failed",
+		Repository:  repo.Name,
+		Description: fmt.Sprintf("Failed to clone %s: %v", repo.Name, err),
+		Author:      "System",
+		Timestamp:   time.Now(),
+	})
+}
+
+// RetryClone queues the clone of a repository whose clone failed again,
+// to run in the background.
+func RetryClone(name string) error {
+	repo, err := models.Repositories.Find("WHERE Name = ?", name)
+	if err != nil {
+		return repoNotFound(name)
+	}
+	if !repo.IsCloneFailed() {
+		return fmt.Errorf("'%s' didn't fail to clone - there's nothing to retry", name)
+	}
+	if !services.Coder.IsRunning() {
+		return ErrCoderNotRunning
+	}
+	if err := checkCloneSpace(repo.URL, repo.StorageLocationID); err != nil {
+		return err
+	}
+
+	repo.CloneStatus, repo.CloneError = models.CloneCloning, ""
+	if err := updateRepo(repo); err != nil {
+		return fmt.Errorf("failed to save repository: %w", err)
+	}
+	entry := &QueuedClone{Name: repo.Name, URL: repo.URL, Storage: repo.StorageLocationID, Queued: time.Now(), retry: repo}
+	if err := clones.add(entry, cloneWorkers()); err != nil {
+		repo.CloneStatus, repo.CloneError = models.CloneFailed, PresentError(err).Error()
+		updateRepo(repo)
+		return err
+	}
+	return nil
+}
+
+// FailInterruptedClones marks clones that were running when the
+// workbench stopped as failed, so they can be retried or removed.
+func FailInterruptedClones() {
+	repos, err := models.Repositories.Search("WHERE CloneStatus = ?", models.CloneCloning)
+	if err != nil {
+		slog.Error("failed to check for interrupted clones", "error", err)
+		return
+	}
+	for _, repo := range repos {
+		if GetCloneProgress(repo.Name) != nil {
+			continue
+		}
+		finishFailedClone(repo, fmt.Errorf("the workbench restarted before the clone finished"), true)
+	}
+}
+
+// checkCloneReady refuses operations on a repository whose first clone is
+// still running or failed.
+func checkCloneReady(repo *models.Repository) error {
+	switch {
+	case repo.IsCloning():
+		return fmt.Errorf("'%s' is still cloning - wait for it to finish", repo.Name)
+	case repo.IsCloneFailed():
+		return fmt.Errorf("'%s' failed to clone - retry the clone or remove it", repo.Name)
+	}
+	return nil
+}
@@ -0,0 +1,264 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"workbench/models"
+	"workbench/services"
+)
+
+// DefaultCloneWorkers is how many clones run at once when the
+// clone_workers setting is not configured.
+const DefaultCloneWorkers = 2
+
+// States of a queued clone.
+const (
+	CloneQueueWaiting = "waiting"
+	CloneQueueRunning = "running"
+	CloneQueueFailed  = "failed" // Kept with its error until dismissed
+)
+
+// QueuedClone is a clone submitted to the clone queue. Finished clones
+// leave the queue. A background clone that fails once its repository is
+// saved leaves the queue too, its record kept as CloneFailed for the
+// dashboard's card and Retry; one refused before that, e.g. for a name
+// taken since it was queued, stays until dismissed.
+type QueuedClone struct {
+	Name    string
+	URL     string
+	Storage string // Storage location ID
+	Status  string
+	Error   string // Why a failed clone failed
+	Queued  time.Time
+
+	Position int            // 1 for the next clone to start; 0 unless waiting
+	Progress *CloneProgress // Of a running clone, when git has reported any
+
+	requestID string             // Of the request that queued it, for its log lines
+	retry     *models.Repository // The failed clone RetryClone queued again; nil for a new clone
+	ctx       context.Context    // Of a caller waiting for the clone, which cancels it
+	done      chan error         // Receives how the clone went, when a caller waits
+}
+
+// Clone queue seams. Replaced in tests.
+var (
+	cloneWorkers = func() int { return models.GetIntSetting("clone_workers") }
+
+	// queuedClone runs a queued clone to the end, with no deadline: large
+	// repositories take as long as they take. saved reports whether the
+	// repository was recorded before git ran, so a failed background
+	// clone is on its CloneFailed card.
+	queuedClone = func(ctx context.Context, entry *QueuedClone) (saved bool, err error) {
+		if entry.retry != nil {
+			return true, <-runClone(ctx, entry.retry, true)
+		}
+		done, err := startClone(ctx, entry.URL, entry.Name, entry.Storage, entry.done == nil)
+		if err != nil {
+			return false, err
+		}
+		return true, <-done
+	}
+	cloneQueueActivity = func(activity *models.Activity) { go models.Activities.Insert(activity) }
+)
+
+// cloneQueue runs submitted clones in order, at most clone_workers at once,
+// so several pasted URLs don't all hit the disk together. Entries are kept
+// in submission order and looked up by repository name.
+type cloneQueue struct {
+	mu      sync.Mutex
+	entries []*QueuedClone
+	running int
+}
+
+var clones = &cloneQueue{}
+
+// QueueClone adds a clone to the queue and starts it when a worker is
+// free. A URL or name that is already waiting or cloning is rejected; a
+// failed entry with the same name is replaced. The clone's checks and
+// activity logging apply when it starts, logged with the request ID ctx
+// carries.
+func QueueClone(ctx context.Context, url, name, locationID string) error {
+	_, err := submitClone(ctx, url, name, locationID, false)
+	return err
+}
+
+// submitClone queues a clone, with a channel for its outcome when wait is
+// set.
+func submitClone(ctx context.Context, url, name, locationID string, wait bool) (*QueuedClone, error) {
+	if name == "" {
+		name = ParseRepoName(url)
+	}
+	if err := checkRepoQuota(); err != nil {
+		return nil, err
+	}
+	entry := &QueuedClone{Name: name, URL: url, Storage: locationID, Queued: time.Now(), requestID: services.RequestID(ctx)}
+	if wait {
+		entry.ctx, entry.done = ctx, make(chan error, 1)
+	}
+	return entry, clones.add(entry, cloneWorkers())
+}
+
+// waitClone waits for a clone submitted with wait set. Cancelling ctx
+// takes a clone that hasn't started out of the queue and stops one that
+// has.
+func waitClone(ctx context.Context, entry *QueuedClone) error {
+	select {
+	case err := <-entry.done:
+		return err
+	case <-ctx.Done():
+		if clones.cancel(entry) {
+			return ctx.Err()
+		}
+		return <-entry.done
+	}
+}
+
+func (q *cloneQueue) add(entry *QueuedClone, workers int) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, e := range q.entries {
+		if e.Status == CloneQueueFailed {
+			continue
+		}
+		if strings.EqualFold(e.Name, entry.Name) {
+			return &repoError{ErrRepoExists, fmt.Sprintf("'%s' is already being cloned", e.Name)}
+		}
+		if sameRemote(e.URL, entry.URL) {
+			return &repoError{ErrRepoExists, fmt.Sprintf("%s is already being cloned as '%s'", entry.URL, e.Name)}
+		}
+	}
+	q.entries = slices.DeleteFunc(q.entries, func(e *QueuedClone) bool { return strings.EqualFold(e.Name, entry.Name) })
+
+	entry.Status = CloneQueueWaiting
+	q.entries = append(q.entries, entry)
+	q.dispatch(workers)
+	return nil
+}
+
+// dispatch starts waiting clones, oldest first, until workers are busy.
+// Called with q.mu held.
+func (q *cloneQueue) dispatch(workers int) {
+	for _, e := range q.entries {
+		if q.running >= max(workers, 1) {
+			return
+		}
+		if e.Status == CloneQueueWaiting {
+			e.Status = CloneQueueRunning
+			q.running++
+			go q.run(e)
+		}
+	}
+}
+
+// run clones a queued entry, then records how it went and starts the
+// next waiting clone. A waiting caller gets the outcome instead of the
+// queue keeping it.
+func (q *cloneQueue) run(entry *QueuedClone) {
+	ctx := entry.ctx
+	if ctx == nil {
+		ctx = services.WithRequestID(context.Background(), entry.requestID)
+	}
+	saved, err := queuedClone(ctx, entry)
+	workers := cloneWorkers()
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.running--
+	if err != nil && entry.done == nil && !saved {
+		entry.Status, entry.Error = CloneQueueFailed, PresentError(err).Error()
+		// Refused before its record was saved: log it so a restart
+		// doesn't lose it with the queue
+		cloneQueueActivity(&models.Activity{
+			Type:        "repo_clone_failed",
+			Repository:  entry.Name,
+			Description: fmt.Sprintf("Failed to clone %s: %s", entry.Name, entry.Error),
+			Author:      "System",
+			Timestamp:   time.Now(),
+		})
+	} else {
+		q.entries = slices.DeleteFunc(q.entries, func(e *QueuedClone) bool { return e == entry })
+	}
+	if entry.done != nil {
+		entry.done <- err
+	}
+	q.dispatch(workers)
+}
+
+// list returns copies of the entries with waiting positions filled in.
+func (q *cloneQueue) list() []QueuedClone {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	entries := make([]QueuedClone, 0, len(q.entries))
+	position := 0
+	for _, e := range q.entries {
+		entry := *e
+		switch entry.Status {
+		case CloneQueueWaiting:
+			position++
+			entry.Position = position
+		case CloneQueueRunning:
+			entry.Progress = GetCloneProgress(entry.Name)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// cancel takes a waiting entry out of the queue, reporting false once it
+// has started.
+func (q *cloneQueue) cancel(entry *QueuedClone) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if entry.Status != CloneQueueWaiting {
+		return false
+	}
+	q.entries = slices.DeleteFunc(q.entries, func(e *QueuedClone) bool { return e == entry })
+	return true
+}
+
+// dismiss removes a waiting or failed entry. A running clone can't be
+// dismissed.
+func (q *cloneQueue) dismiss(name string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	i := slices.IndexFunc(q.entries, func(e *QueuedClone) bool { return e.Name == name })
+	if i < 0 {
+		return &repoError{ErrRepoNotFound, fmt.Sprintf("no queued clone named '%s'", name)}
+	}
+	entry := q.entries[i]
+	if entry.Status == CloneQueueRunning {
+		return fmt.Errorf("'%s' is cloning - wait for it to finish", name)
+	}
+	q.entries = slices.Delete(q.entries, i, i+1)
+	if entry.done != nil {
+		entry.done <- fmt.Errorf("the clone of '%s' was cancelled before it started", name)
+	}
+	return nil
+}
+
+// GetCloneQueue returns the queued, running, and failed clones in the
+// order they were submitted.
+func GetCloneQueue() []QueuedClone {
+	return clones.list()
+}
+
+// DismissQueuedClone removes a failed clone from the queue, or cancels
+// one that hasn't started.
+func DismissQueuedClone(name string) error {
+	return clones.dismiss(name)
+}
+
+// sameRemote reports whether two remote URLs name the same repository,
+// ignoring case, a trailing slash, and a .git suffix.
+func sameRemote(a, b string) bool {
+	normalize := func(url string) string {
+		url = strings.ToLower(strings.TrimSpace(url))
+		url = strings.TrimSuffix(url, "/")
+		return strings.TrimSuffix(url, ".git")
+	}
+	return normalize(a) == normalize(b)
+}
@@ -0,0 +1,223 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+	"workbench/models"
+
+	"github.com/The-Skyscape/devtools/pkg/testutils"
+)
+
+// fakeQueuedClones replaces the queue's clone with one that reports each
+// start on started and finishes with the error sent on finish[name]. A
+// clone whose error mentions "git" failed after its record was saved.
+func fakeQueuedClones(t *testing.T, workers int) (started chan string, finish map[string]chan error) {
+	originalClone, originalWorkers, originalActivity := queuedClone, cloneWorkers, cloneQueueActivity
+	t.Cleanup(func() {
+		queuedClone, cloneWorkers, cloneQueueActivity = originalClone, originalWorkers, originalActivity
+	})
+
+	started = make(chan string, 10)
+	finish = map[string]chan error{}
+	for _, name := range []string{"one", "two", "three"} {
+		finish[name] = make(chan error, 1)
+	}
+	cloneWorkers = func() int { return workers }
+	queuedClone = func(ctx context.Context, entry *QueuedClone) (bool, error) {
+		started <- entry.Name
+		err := <-finish[entry.Name]
+		return err == nil || strings.Contains(err.Error(), "git"), err
+	}
+	cloneQueueActivity = func(*models.Activity) {}
+	return started, finish
+}
+
+func waitStarted(t *testing.T, started chan string) string {
+	t.Helper()
+	select {
+	case name := <-started:
+		return name
+	case <-time.After(2 * time.Second):
+		t.Fatal("no clone started")
+		return ""
+	}
+}
+
+// waitQueue waits until the queue's entries have the given statuses.
+func waitQueue(t *testing.T, q *cloneQueue, statuses ...string) []QueuedClone {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		entries := q.list()
+		var got []string
+		for _, e := range entries {
+			got = append(got, e.Status)
+		}
+		if strings.Join(got, ",") == strings.Join(statuses, ",") {
+			return entries
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("queue is %v, want %v", got, statuses)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestCloneQueueLimitsWorkers(t *testing.T) {
+	started, finish := fakeQueuedClones(t, 2)
+	q := &cloneQueue{}
+	for _, name := range []string{"one", "two", "three"} {
+		if err := q.add(&QueuedClone{Name: name, URL: "https://github.com/acme/" + name}, 2); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The first two start together, in either order
+	first, second := waitStarted(t, started), waitStarted(t, started)
+	testutils.AssertEqual(t, true, first != second && first != "three" && second != "three")
+	entries := waitQueue(t, q, CloneQueueRunning, CloneQueueRunning, CloneQueueWaiting)
+	testutils.AssertEqual(t, 1, entries[2].Position)
+	testutils.AssertEqual(t, 0, entries[0].Position)
+
+	finish["one"] <- nil
+	testutils.AssertEqual(t, "three", waitStarted(t, started))
+	waitQueue(t, q, CloneQueueRunning, CloneQueueRunning)
+
+	finish["two"] <- nil
+	finish["three"] <- nil
+	waitQueue(t, q)
+}
+
+func TestCloneQueueRejectsDuplicates(t *testing.T) {
+	started, finish := fakeQueuedClones(t, 1)
+	q := &cloneQueue{}
+	if err := q.add(&QueuedClone{Name: "one", URL: "https://github.com/acme/one.git"}, 1); err != nil {
+		t.Fatal(err)
+	}
+	waitStarted(t, started)
+	if err := q.add(&QueuedClone{Name: "two", URL: "https://github.com/acme/two"}, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		url  string
+	}{
+		{"other-name", "https://GitHub.com/acme/one/"},
+		{"another", "https://github.com/acme/two.git"},
+		{"ONE", "https://github.com/acme/three"},
+	}
+	for _, tt := range tests {
+		err := q.add(&QueuedClone{Name: tt.name, URL: tt.url}, 1)
+		testutils.AssertEqual(t, true, errors.Is(err, ErrRepoExists))
+		testutils.AssertEqual(t, true, strings.Contains(err.Error(), "already being cloned"))
+	}
+	waitQueue(t, q, CloneQueueRunning, CloneQueueWaiting)
+
+	finish["one"] <- nil
+	waitStarted(t, started)
+	finish["two"] <- nil
+	waitQueue(t, q)
+}
+
+func TestCloneQueueKeepsFailures(t *testing.T) {
+	started, finish := fakeQueuedClones(t, 1)
+	q := &cloneQueue{}
+	if err := q.add(&QueuedClone{Name: "one", URL: "https://github.com/acme/one"}, 1); err != nil {
+		t.Fatal(err)
+	}
+	waitStarted(t, started)
+	testutils.AssertEqual(t, true, q.dismiss("one") != nil)
+
+	finish["one"] <- errors.New("repository not found")
+	entries := waitQueue(t, q, CloneQueueFailed)
+	testutils.AssertEqual(t, true, strings.Contains(entries[0].Error, "repository not found"))
+
+	// Submitting the same name again replaces the failure
+	if err := q.add(&QueuedClone{Name: "one", URL: "https://github.com/acme/one"}, 1); err != nil {
+		t.Fatal(err)
+	}
+	waitStarted(t, started)
+	finish["one"] <- errors.New("still missing")
+	waitQueue(t, q, CloneQueueFailed)
+
+	if err := q.dismiss("one"); err != nil {
+		t.Fatal(err)
+	}
+	waitQueue(t, q)
+	testutils.AssertEqual(t, true, errors.Is(q.dismiss("one"), ErrRepoNotFound))
+}
+
+func TestCloneQueueLeavesSavedFailuresToTheirRecord(t *testing.T) {
+	started, finish := fakeQueuedClones(t, 1)
+	q := &cloneQueue{}
+	if err := q.add(&QueuedClone{Name: "one", URL: "https://github.com/acme/one"}, 1); err != nil {
+		t.Fatal(err)
+	}
+	waitStarted(t, started)
+
+	// The CloneFailed record shows the failure, with Retry
+	finish["one"] <- errors.New("git clone: repository not found")
+	waitQueue(t, q)
+}
+
+func TestCloneQueueWaitingCaller(t *testing.T) {
+	started, finish := fakeQueuedClones(t, 1)
+	q := &cloneQueue{}
+	if err := q.add(&QueuedClone{Name: "one", URL: "https://github.com/acme/one"}, 1); err != nil {
+		t.Fatal(err)
+	}
+	waitStarted(t, started)
+
+	// Waits its turn behind the running clone
+	ctx := context.Background()
+	waiting := &QueuedClone{Name: "two", URL: "https://github.com/acme/two", ctx: ctx, done: make(chan error, 1)}
+	if err := q.add(waiting, 1); err != nil {
+		t.Fatal(err)
+	}
+	waitQueue(t, q, CloneQueueRunning, CloneQueueWaiting)
+
+	finish["one"] <- nil
+	testutils.AssertEqual(t, "two", waitStarted(t, started))
+	finish["two"] <- errors.New("disk is full")
+	testutils.AssertEqual(t, "disk is full", (<-waiting.done).Error())
+	waitQueue(t, q) // The caller has the error; the queue doesn't keep it
+}
+
+func TestCloneQueueCancelWaiting(t *testing.T) {
+	started, finish := fakeQueuedClones(t, 1)
+	q := &cloneQueue{}
+	if err := q.add(&QueuedClone{Name: "one", URL: "https://github.com/acme/one"}, 1); err != nil {
+		t.Fatal(err)
+	}
+	waitStarted(t, started)
+	waiting := &QueuedClone{Name: "two", URL: "https://github.com/acme/two", done: make(chan error, 1)}
+	if err := q.add(waiting, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	testutils.AssertEqual(t, true, q.cancel(waiting))
+	waitQueue(t, q, CloneQueueRunning)
+	running := q.entries[0]
+	testutils.AssertEqual(t, false, q.cancel(running))
+
+	// Dismissing a waiting clone tells its caller
+	waiting = &QueuedClone{Name: "two", URL: "https://github.com/acme/two", done: make(chan error, 1)}
+	if err := q.add(waiting, 1); err != nil {
+		t.Fatal(err)
+	}
+	testutils.AssertEqual(t, nil, q.dismiss("two"))
+	testutils.AssertEqual(t, true, strings.Contains((<-waiting.done).Error(), "cancelled"))
+
+	finish["one"] <- nil
+	waitQueue(t, q)
+}
+
+func TestSameRemote(t *testing.T) {
+	testutils.AssertEqual(t, true, sameRemote("https://github.com/acme/app.git", "https://GitHub.com/acme/app/"))
+	testutils.AssertEqual(t, true, sameRemote("git@github.com:acme/app.git", "git@github.com:acme/app"))
+	testutils.AssertEqual(t, false, sameRemote("https://github.com/acme/app", "https://github.com/acme/api"))
+}
@@ -0,0 +1,121 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"workbench/internal/i18n"
+	"workbench/internal/providers"
+	"workbench/models"
+)
+
+// DefaultCloneMinFreeMB is how many megabytes a clone leaves free on the
+// disk it clones to unless the clone_min_free_mb setting says otherwise.
+const DefaultCloneMinFreeMB = 1024
+
+// cloneSizeFactor turns the packed size a provider reports into what a
+// clone takes on disk: the pack plus the checked-out files, about as big.
+const cloneSizeFactor = 2
+
+// ErrNoSpace is matched with errors.Is by callers that need to tell a
+// clone refused for disk space from other failures.
+var ErrNoSpace = errors.New("not enough disk space")
+
+// Clone space seams. Replaced in tests.
+var (
+	cloneFreeSpace  = freeSpace
+	cloneRemoteSize = providers.RepositorySize
+	reposTotalSize  = func() (uint64, error) {
+		sizes, err := GetRepositorySizes()
+		return TotalRepoSize(sizes), err
+	}
+	cloneMinFree = func() uint64 { return uint64(models.GetIntSetting("clone_min_free_mb")) << 20 }
+	reposQuota   = func() uint64 { return uint64(models.GetIntSetting("repos_quota_mb")) << 20 }
+)
+
+// RepoQuota is the disk usage of all repositories against the
+// repos_quota_mb setting.
+type RepoQuota struct {
+	Limit    uint64 // Bytes; 0 when there's no quota
+	Used     uint64 // Bytes all repositories use
+	Exceeded bool   // New clones are refused until space is freed
+}
+
+// GetRepoQuota returns the repositories' usage against their quota.
+// Sizes are measured with du and reused for five minutes.
+func GetRepoQuota() (*RepoQuota, error) {
+	quota := &RepoQuota{Limit: reposQuota()}
+	if quota.Limit == 0 {
+		return quota, nil
+	}
+	used, err := reposTotalSize()
+	if err != nil {
+		return nil, err
+	}
+	quota.Used, quota.Exceeded = used, used >= quota.Limit
+	return quota, nil
+}
+
+// checkRepoQuota refuses new clones once repositories use their quota.
+// Pulls and other changes to existing repositories aren't checked.
+func checkRepoQuota() error {
+	quota, err := GetRepoQuota()
+	if err != nil {
+		slog.Warn("failed to check the repository quota", "error", err)
+		return nil
+	}
+	if quota.Exceeded {
+		en := i18n.Lookup("en")
+		return &repoError{ErrNoSpace, fmt.Sprintf("repositories use %s of their %s quota - remove some or raise repos_quota_mb before cloning more",
+			en.FormatBytes(quota.Used), en.FormatBytes(quota.Limit))}
+	}
+	return nil
+}
+
+// checkCloneSpace refuses a clone that would leave less than
+// clone_min_free_mb free where it goes, counting the provider's estimate
+// of the repository's size when the host is GitHub or GitLab. The checks
+// are best effort: when free space can't be read, the clone goes ahead.
+func checkCloneSpace(url, locationID string) error {
+	if err := checkRepoQuota(); err != nil {
+		return err
+	}
+	free, err := cloneFreeSpace(locationID)
+	if err != nil {
+		slog.Warn("failed to check free space before cloning", "error", err)
+		return nil
+	}
+
+	need := cloneMinFree()
+	if size, err := cloneRemoteSize(url); err != nil {
+		slog.Debug("no size estimate for clone", "url", maskURLCredentials(url), "error", err)
+	} else if size > 0 {
+		need += uint64(size) * cloneSizeFactor
+	}
+	if free < need {
+		en := i18n.Lookup("en")
+		return &repoError{ErrNoSpace, fmt.Sprintf("not enough disk space (need ~%s, have %s free)", en.FormatBytes(need), en.FormatBytes(free))}
+	}
+	return nil
+}
+
+// freeSpace returns the free bytes on the disk a storage location is on;
+// the data directory's disk for the standard repos directory.
+func freeSpace(locationID string) (uint64, error) {
+	if locationID == "" {
+		usage, err := GetDataDirUsage()
+		if err != nil {
+			return 0, err
+		}
+		return usage.Free, nil
+	}
+	dir, err := RepoDir(locationID)
+	if err != nil {
+		return 0, err
+	}
+	usage, err := dfUsage(dir)
+	if err != nil {
+		return 0, err
+	}
+	return usage.Free, nil
+}
@@ -0,0 +1,64 @@
+package internal
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/The-Skyscape/devtools/pkg/testutils"
+)
+
+// fakeCloneSpace reports free bytes on every disk, a provider size
+// estimate, and repositories using used of a quota.
+func fakeCloneSpace(t *testing.T, free uint64, estimate int64, used, quota uint64) {
+	freeSpace, remoteSize, total, minFree, limit := cloneFreeSpace, cloneRemoteSize, reposTotalSize, cloneMinFree, reposQuota
+	cloneFreeSpace = func(string) (uint64, error) { return free, nil }
+	cloneRemoteSize = func(string) (int64, error) { return estimate, nil }
+	reposTotalSize = func() (uint64, error) { return used, nil }
+	cloneMinFree = func() uint64 { return 1 << 30 }
+	reposQuota = func() uint64 { return quota }
+	t.Cleanup(func() {
+		cloneFreeSpace, cloneRemoteSize, reposTotalSize, cloneMinFree, reposQuota = freeSpace, remoteSize, total, minFree, limit
+	})
+}
+
+func TestCheckCloneSpace(t *testing.T) {
+	const mb = 1 << 20
+	testCases := []struct {
+		name     string
+		free     uint64
+		estimate int64
+		used     uint64
+		quota    uint64
+		expected string
+	}{
+		{"room", 10 << 30, 500 * mb, 0, 0, ""},
+		{"estimate too big", 800 * mb, 563 * mb, 0, 0, "not enough disk space (need ~2.1 GB, have 800.0 MB free)"},
+		{"below minimum without estimate", 800 * mb, 0, 0, 0, "not enough disk space (need ~1.0 GB, have 800.0 MB free)"},
+		{"under quota", 10 << 30, 0, 900 * mb, 1 << 30, ""},
+		{"over quota", 10 << 30, 0, 1 << 30, 1 << 30,
+			"repositories use 1.0 GB of their 1.0 GB quota - remove some or raise repos_quota_mb before cloning more"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			fakeCloneSpace(t, tc.free, tc.estimate, tc.used, tc.quota)
+			err := checkCloneSpace("https://github.com/acme/api.git", "")
+			if tc.expected == "" {
+				testutils.AssertEqual(t, nil, err)
+				return
+			}
+			testutils.AssertEqual(t, tc.expected, err.Error())
+			testutils.AssertEqual(t, true, errors.Is(err, ErrNoSpace))
+		})
+	}
+}
+
+func TestCheckCloneSpaceIsBestEffort(t *testing.T) {
+	fakeCloneSpace(t, 0, 0, 0, 0)
+	cloneFreeSpace = func(string) (uint64, error) { return 0, errors.New("df failed") }
+	testutils.AssertEqual(t, nil, checkCloneSpace("https://example.com/api.git", ""))
+
+	fakeCloneSpace(t, 2<<30, 0, 0, 0)
+	cloneRemoteSize = func(string) (int64, error) { return 0, errors.New("rate limited") }
+	testutils.AssertEqual(t, nil, checkCloneSpace("https://github.com/acme/api.git", ""))
+}
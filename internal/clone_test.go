@@ -0,0 +1,77 @@
+package internal
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+	"workbench/models"
+
+	"github.com/The-Skyscape/devtools/pkg/testutils"
+)
+
+func TestParseCloneProgress(t *testing.T) {
+	testCases := []struct {
+		line    string
+		phase   string
+		percent int
+		ok      bool
+	}{
+		{"Receiving objects:  42% (420/1000), 1.20 MiB | 2.00 MiB/s", "Receiving objects", 42, true},
+		{"remote: Counting objects: 100% (8/8), done.", "Counting objects", 100, true},
+		{"Resolving deltas:   7% (7/100)", "Resolving deltas", 7, true},
+		{"Cloning into '/home/coder/repos/big'...", "", 0, false},
+		{"fatal: repository not found", "", 0, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.line, func(t *testing.T) {
+			progress, ok := parseCloneProgress(tc.line)
+			testutils.AssertEqual(t, tc.ok, ok)
+			testutils.AssertEqual(t, tc.phase, progress.Phase)
+			testutils.AssertEqual(t, tc.percent, progress.Percent)
+		})
+	}
+}
+
+func TestCloneProgressString(t *testing.T) {
+	testutils.AssertEqual(t, "Starting clone…", (&CloneProgress{}).String())
+	testutils.AssertEqual(t, "Receiving objects: 42%", (&CloneProgress{Phase: "Receiving objects", Percent: 42}).String())
+}
+
+func TestScanProgressLines(t *testing.T) {
+	output := "Cloning into 'big'...\nReceiving objects:  10% (1/10)\rReceiving objects:  50% (5/10)\rReceiving objects: 100% (10/10), done.\nfatal: early EOF"
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	scanner.Split(scanProgressLines)
+
+	var lines []string
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	testutils.AssertEqual(t, 5, len(lines))
+	testutils.AssertEqual(t, "Receiving objects:  50% (5/10)", lines[2])
+	testutils.AssertEqual(t, "fatal: early EOF", lines[4])
+}
+
+func TestGetCloneProgress(t *testing.T) {
+	t.Cleanup(func() { setCloneProgress("big", nil) })
+
+	testutils.AssertEqual(t, true, GetCloneProgress("big") == nil)
+	setCloneProgress("big", &CloneProgress{Phase: "Receiving objects", Percent: 42})
+
+	progress := GetCloneProgress("big")
+	progress.Percent = 99
+	testutils.AssertEqual(t, 42, GetCloneProgress("big").Percent)
+
+	setCloneProgress("big", nil)
+	testutils.AssertEqual(t, true, GetCloneProgress("big") == nil)
+}
+
+func TestCheckCloneReady(t *testing.T) {
+	testutils.AssertEqual(t, nil, checkCloneReady(&models.Repository{Name: "api"}))
+
+	err := checkCloneReady(&models.Repository{Name: "big", CloneStatus: models.CloneCloning})
+	testutils.AssertEqual(t, "'big' is still cloning - wait for it to finish", err.Error())
+
+	err = checkCloneReady(&models.Repository{Name: "big", CloneStatus: models.CloneFailed})
+	testutils.AssertEqual(t, "'big' failed to clone - retry the clone or remove it", err.Error())
+}
@@ -0,0 +1,204 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+	"workbench/models"
+	"workbench/services"
+)
+
+// What background jobs that need the coder container do while it's
+// paused, per the coder_paused_jobs setting.
+const (
+	PausedJobsSkip = "skip" // Wait for the next run after a resume
+	PausedJobsWake = "wake" // Start the container for the job and pause it again after
+)
+
+// coderIdleCheckInterval is how often the coder-idle job looks for an
+// idle container.
+const coderIdleCheckInterval = time.Minute
+
+// coderWakeTimeout bounds waiting for the container to come back up on a
+// resume.
+const coderWakeTimeout = 3 * time.Minute
+
+// Seams for idle pauses. Replaced in tests.
+var (
+	coderIdleTimeout = func() time.Duration { return models.GetDurationSetting("coder_idle_pause") }
+	pausedJobs       = func() string { return models.SettingOrDefault("coder_paused_jobs") }
+	coderPaused      = services.CoderIsPaused
+	coderRunning     = func() bool { return services.CoderState().State == services.CoderRunning }
+	coderLastUsed    = services.CoderLastUsed
+	pauseCoder       = services.PauseCoder
+	resumeCoder      = func() error {
+		services.EnsureCoder()
+		return services.WaitForCoder(coderWakeTimeout)
+	}
+	idleActivity = func(activity *models.Activity) { go models.Activities.Insert(activity) }
+)
+
+var (
+	coderHoldMu sync.Mutex
+	coderHolds  int       // Commands and jobs running in the container, keeping it from pausing
+	coderWoken  time.Time // When a paused container was woken for jobs; zero unless it was
+)
+
+// Idle pauses are checked every minute while coder_idle_pause is set.
+func init() {
+	RegisterJob(JobSpec{
+		Name:        "coder-idle",
+		Description: "Pause the coder container once it sits idle",
+		Interval: func() time.Duration {
+			if coderIdleTimeout() > 0 {
+				return coderIdleCheckInterval
+			}
+			return 0
+		},
+		Priority: PriorityLow,
+		Run:      pauseIdleCoder,
+	})
+}
+
+// holdCoder keeps the coder container from being paused until the
+// returned function is called, for work running in it.
+func holdCoder() (release func()) {
+	coderHoldMu.Lock()
+	coderHolds++
+	coderHoldMu.Unlock()
+	return func() {
+		coderHoldMu.Lock()
+		coderHolds--
+		coderHoldMu.Unlock()
+	}
+}
+
+// pauseIdleCoder pauses the coder container once the IDE, commands, and
+// terminals have left it unused for coder_idle_pause. Open terminals and
+// running commands or jobs keep it up.
+func pauseIdleCoder(now time.Time) error {
+	timeout := coderIdleTimeout()
+	if timeout <= 0 || !coderRunning() || OpenTerminals() > 0 {
+		return nil
+	}
+	idle := now.Sub(coderLastUsed())
+	if idle < timeout {
+		return nil
+	}
+	coderHoldMu.Lock()
+	held := coderHolds > 0
+	coderHoldMu.Unlock()
+	if held {
+		return nil
+	}
+
+	if err := pauseCoder(); err != nil {
+		if errors.Is(err, services.ErrCoderBusy) {
+			return nil // Starting or upgrading; looked at again next minute
+		}
+		return err
+	}
+	idleActivity(&models.Activity{
+		Type:        "coder_pause",
+		Description: fmt.Sprintf("Paused the coder container to save resources after %s idle", idle.Round(time.Minute)),
+		Author:      "System",
+		Timestamp:   now,
+	})
+	return nil
+}
+
+// ResumeCoder starts the coder container after a pause and waits until
+// code-server answers, so the IDE opens straight away. Counts as use, so
+// the idle period starts over.
+func ResumeCoder() error {
+	paused := coderPaused()
+	services.TouchCoder()
+	if err := resumeCoder(); err != nil {
+		return &AppError{Code: CodeCoderNotRunning, UserMessage: "VS Code didn't start - see the container status", Detail: err.Error(), Retryable: true, Err: err}
+	}
+	if paused {
+		idleActivity(&models.Activity{
+			Type:        "coder_resume",
+			Description: "Resumed the paused coder container",
+			Author:      "System",
+			Timestamp:   time.Now(),
+		})
+	}
+	return nil
+}
+
+// WithCoderAwake runs a background job that needs the coder container.
+// While the container is paused the job is skipped, or with
+// coder_paused_jobs set to wake, the container is started for it and
+// paused again once the last such job ends, unless it was used meanwhile.
+func WithCoderAwake(job string, run func() error) error {
+	if coderPaused() && pausedJobs() != PausedJobsWake {
+		slog.Info("skipping job while the coder container is paused", "job", job)
+		return nil
+	}
+
+	release := holdCoder()
+	defer func() {
+		release()
+		repauseCoder(job)
+	}()
+	if coderPaused() {
+		if err := wakeCoder(job); err != nil {
+			return err
+		}
+	}
+	return run()
+}
+
+// wakeCoder starts a paused container for a job. Jobs arriving together
+// wake it once.
+func wakeCoder(job string) error {
+	coderHoldMu.Lock()
+	first := coderWoken.IsZero()
+	if first {
+		coderWoken = time.Now()
+	}
+	coderHoldMu.Unlock()
+
+	if err := resumeCoder(); err != nil {
+		return fmt.Errorf("failed to wake the paused coder container for %s: %w", job, err)
+	}
+	if first {
+		idleActivity(&models.Activity{
+			Type:        "coder_resume",
+			Description: fmt.Sprintf("Woke the paused coder container for %s", job),
+			Author:      "System",
+			Timestamp:   time.Now(),
+		})
+	}
+	return nil
+}
+
+// repauseCoder pauses a container woken for jobs again once the last of
+// them ends, unless the IDE, a command, or a terminal used it meanwhile.
+func repauseCoder(job string) {
+	coderHoldMu.Lock()
+	woken := coderWoken
+	if coderHolds > 0 || woken.IsZero() {
+		coderHoldMu.Unlock()
+		return
+	}
+	coderWoken = time.Time{}
+	coderHoldMu.Unlock()
+
+	if coderLastUsed().After(woken) || OpenTerminals() > 0 {
+		return // In use again; the idle check pauses it when it's done
+	}
+	if err := pauseCoder(); err != nil {
+		slog.Warn("failed to pause the coder container again", "job", job, "error", err)
+		return
+	}
+	idleActivity(&models.Activity{
+		Type:        "coder_pause",
+		Description: fmt.Sprintf("Paused the coder container again after %s", job),
+		Author:      "System",
+		Timestamp:   time.Now(),
+	})
+}
@@ -0,0 +1,156 @@
+package internal
+
+import (
+	"errors"
+	"testing"
+	"time"
+	"workbench/models"
+	"workbench/services"
+
+	"github.com/The-Skyscape/devtools/pkg/testutils"
+)
+
+// coderIdleFake is a coder container idle since lastUsed. Pausing and
+// resuming flip paused, and the activities recorded are collected.
+type coderIdleFake struct {
+	timeout    time.Duration
+	jobs       string
+	paused     bool
+	lastUsed   time.Time
+	resumeErr  error
+	pauses     int
+	resumes    int
+	activities []string
+}
+
+func fakeCoderIdle(t *testing.T, fake *coderIdleFake) *coderIdleFake {
+	timeout, jobs, paused, running, lastUsed, pause, resume, activity := coderIdleTimeout, pausedJobs, coderPaused, coderRunning, coderLastUsed, pauseCoder, resumeCoder, idleActivity
+	t.Cleanup(func() {
+		coderIdleTimeout, pausedJobs, coderPaused, coderRunning, coderLastUsed, pauseCoder, resumeCoder, idleActivity = timeout, jobs, paused, running, lastUsed, pause, resume, activity
+		coderHolds, coderWoken = 0, time.Time{}
+	})
+
+	coderIdleTimeout = func() time.Duration { return fake.timeout }
+	pausedJobs = func() string { return fake.jobs }
+	coderPaused = func() bool { return fake.paused }
+	coderRunning = func() bool { return !fake.paused }
+	coderLastUsed = func() time.Time { return fake.lastUsed }
+	pauseCoder = func() error {
+		if fake.paused {
+			return services.ErrCoderBusy
+		}
+		fake.pauses++
+		fake.paused = true
+		return nil
+	}
+	resumeCoder = func() error {
+		if fake.resumeErr != nil {
+			return fake.resumeErr
+		}
+		fake.resumes++
+		fake.paused = false
+		return nil
+	}
+	idleActivity = func(a *models.Activity) { fake.activities = append(fake.activities, a.Type+": "+a.Description) }
+	return fake
+}
+
+func TestPauseIdleCoder(t *testing.T) {
+	now := time.Date(2026, 10, 16, 3, 0, 0, 0, time.UTC)
+
+	// Off by default
+	fake := fakeCoderIdle(t, &coderIdleFake{lastUsed: now.Add(-48 * time.Hour)})
+	testutils.AssertEqual(t, nil, pauseIdleCoder(now))
+	testutils.AssertEqual(t, 0, fake.pauses)
+
+	// Used recently
+	fake.timeout, fake.lastUsed = time.Hour, now.Add(-59*time.Minute)
+	testutils.AssertEqual(t, nil, pauseIdleCoder(now))
+	testutils.AssertEqual(t, 0, fake.pauses)
+
+	// A command still running
+	fake.lastUsed = now.Add(-90 * time.Minute)
+	release := holdCoder()
+	testutils.AssertEqual(t, nil, pauseIdleCoder(now))
+	testutils.AssertEqual(t, 0, fake.pauses)
+	release()
+
+	testutils.AssertEqual(t, nil, pauseIdleCoder(now))
+	testutils.AssertEqual(t, 1, fake.pauses)
+	testutils.AssertEqual(t, []string{"coder_pause: Paused the coder container to save resources after 1h30m0s idle"}, fake.activities)
+
+	// Already paused
+	testutils.AssertEqual(t, nil, pauseIdleCoder(now.Add(time.Minute)))
+	testutils.AssertEqual(t, 1, fake.pauses)
+}
+
+func TestWithCoderAwakeSkipsWhilePaused(t *testing.T) {
+	fake := fakeCoderIdle(t, &coderIdleFake{jobs: PausedJobsSkip, paused: true})
+
+	ran := false
+	err := WithCoderAwake("auto-pull", func() error {
+		ran = true
+		return nil
+	})
+	testutils.AssertEqual(t, nil, err)
+	testutils.AssertEqual(t, false, ran)
+	testutils.AssertEqual(t, 0, fake.resumes)
+	testutils.AssertEqual(t, true, fake.paused)
+}
+
+func TestWithCoderAwakeWakesAndPausesAgain(t *testing.T) {
+	fake := fakeCoderIdle(t, &coderIdleFake{jobs: PausedJobsWake, paused: true, lastUsed: time.Now().Add(-time.Hour)})
+
+	err := WithCoderAwake("auto-pull", func() error {
+		testutils.AssertEqual(t, false, fake.paused)
+		// A job starting meanwhile runs in the woken container and
+		// doesn't pause it under the first
+		return WithCoderAwake("a webhook pull of api", func() error { return nil })
+	})
+	testutils.AssertEqual(t, nil, err)
+	testutils.AssertEqual(t, 1, fake.resumes)
+	testutils.AssertEqual(t, 1, fake.pauses)
+	testutils.AssertEqual(t, true, fake.paused)
+	testutils.AssertEqual(t, []string{
+		"coder_resume: Woke the paused coder container for auto-pull",
+		"coder_pause: Paused the coder container again after auto-pull",
+	}, fake.activities)
+}
+
+func TestWithCoderAwakeStaysUpWhenUsed(t *testing.T) {
+	fake := fakeCoderIdle(t, &coderIdleFake{jobs: PausedJobsWake, paused: true})
+
+	WithCoderAwake("auto-pull", func() error {
+		fake.lastUsed = time.Now().Add(time.Second) // The IDE was opened meanwhile
+		return nil
+	})
+	testutils.AssertEqual(t, false, fake.paused)
+	testutils.AssertEqual(t, 0, fake.pauses)
+
+	// Running jobs aren't affected when it isn't paused
+	testutils.AssertEqual(t, nil, WithCoderAwake("auto-pull", func() error { return nil }))
+	testutils.AssertEqual(t, 1, fake.resumes)
+}
+
+func TestWithCoderAwakeWakeFailure(t *testing.T) {
+	fake := fakeCoderIdle(t, &coderIdleFake{jobs: PausedJobsWake, paused: true, resumeErr: errors.New("VS Code didn't come up within 3m0s")})
+
+	err := WithCoderAwake("auto-pull", func() error {
+		t.Fatal("the job shouldn't run without the container")
+		return nil
+	})
+	testutils.AssertEqual(t, "failed to wake the paused coder container for auto-pull: VS Code didn't come up within 3m0s", err.Error())
+	testutils.AssertEqual(t, 0, len(fake.activities))
+}
+
+func TestResumeCoder(t *testing.T) {
+	fake := fakeCoderIdle(t, &coderIdleFake{paused: true})
+
+	testutils.AssertEqual(t, nil, ResumeCoder())
+	testutils.AssertEqual(t, []string{"coder_resume: Resumed the paused coder container"}, fake.activities)
+
+	fake.paused, fake.resumeErr = true, errors.New("coder service not ready yet - starting it failed: no such image")
+	err := ResumeCoder()
+	testutils.AssertEqual(t, CodeCoderNotRunning, ErrorCode(err))
+	testutils.AssertEqual(t, 1, len(fake.activities))
+}
@@ -0,0 +1,96 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"time"
+	"workbench/models"
+	"workbench/services"
+)
+
+// CoderImageStatus is the code-server image the coder container runs next
+// to the one the coder_image_tag setting asks for.
+type CoderImageStatus struct {
+	*services.CoderImage
+	Desired   string // Tag from the coder_image_tag setting
+	Mismatch  bool   // The container runs another tag than Desired
+	Upgrading bool   // An upgrade is in progress
+}
+
+// Coder image seams. Replaced in tests.
+var (
+	coderImageInfo    = services.CoderImageInfo
+	coderImageUpgrade = services.UpgradeCoder
+	coderImageDesired = func() string { return models.SettingOrDefault("coder_image_tag") }
+	coderImageSave    = func(tag string) error {
+		_, err := models.SetSetting("coder_image_tag", tag, "user_preference")
+		return err
+	}
+	coderImageActivity = func(activityType, description string) {
+		go models.Activities.Insert(&models.Activity{
+			Type:        activityType,
+			Repository:  "",
+			Description: description,
+			Author:      "System",
+			Timestamp:   time.Now(),
+		})
+	}
+)
+
+// checkCoderImageTag validates the coder_image_tag setting.
+func checkCoderImageTag(value string) error {
+	if !services.ValidCoderImageTag(value) {
+		return fmt.Errorf("invalid image tag %s", value)
+	}
+	return nil
+}
+
+// CoderImageTag returns the code-server tag the coder_image_tag setting
+// asks for, which a newly created coder container runs.
+func CoderImageTag() string {
+	return coderImageDesired()
+}
+
+// GetCoderImageStatus returns the running and desired code-server images
+// and whether a newer release is available.
+func GetCoderImageStatus() (*CoderImageStatus, error) {
+	info, err := coderImageInfo()
+	if err != nil {
+		return nil, err
+	}
+	status := &CoderImageStatus{CoderImage: info, Desired: coderImageDesired(), Upgrading: services.CoderUpgrading()}
+	status.Mismatch = status.Desired != info.Tag
+	return status, nil
+}
+
+// UpgradeCoder moves the coder container to a code-server tag, the
+// coder_image_tag setting when tag is empty, and saves it as the desired
+// tag once the new container answers. Recorded as coder_upgrade, or as
+// coder_upgrade_rolled_back when the new container failed its health
+// check and the previous one was started again, or coder_upgrade_failed.
+func UpgradeCoder(tag string) error {
+	if tag == "" {
+		tag = coderImageDesired()
+	}
+	image := services.CoderImageName(tag)
+
+	previous, err := coderImageUpgrade(tag, services.CoderUpgradeHealthTimeout)
+	var rollback *services.CoderRollbackError
+	switch {
+	case errors.Is(err, services.ErrCoderUpgradeRunning):
+		return err
+	case errors.As(err, &rollback):
+		coderImageActivity("coder_upgrade_rolled_back",
+			fmt.Sprintf("Rolled the coder container back to %s: %s didn't pass its health check (%v)", rollback.Previous, image, rollback.Reason))
+		return err
+	case err != nil:
+		coderImageActivity("coder_upgrade_failed", fmt.Sprintf("Failed to upgrade the coder container to %s: %v", image, err))
+		return err
+	}
+
+	if err := coderImageSave(tag); err != nil {
+		return fmt.Errorf("upgraded to %s but failed to save it as the desired version: %w", image, err)
+	}
+	coderImageActivity("coder_upgrade", fmt.Sprintf("Upgraded the coder container from %s to %s", previous, image))
+	return nil
+}
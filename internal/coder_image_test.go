@@ -0,0 +1,61 @@
+package internal
+
+import (
+	"errors"
+	"testing"
+	"time"
+	"workbench/services"
+
+	"github.com/The-Skyscape/devtools/pkg/testutils"
+)
+
+// fakeCoderUpgrade replaces the upgrade with one returning err, recording
+// the activities and the desired tags saved.
+func fakeCoderUpgrade(t *testing.T, err error) (activities, saved *[]string) {
+	activities, saved = &[]string{}, &[]string{}
+	upgrade, desired, save, activity := coderImageUpgrade, coderImageDesired, coderImageSave, coderImageActivity
+	coderImageUpgrade = func(tag string, timeout time.Duration) (string, error) {
+		return "codercom/code-server:4.96.4", err
+	}
+	coderImageDesired = func() string { return "4.98.0" }
+	coderImageSave = func(tag string) error { *saved = append(*saved, tag); return nil }
+	coderImageActivity = func(activityType, description string) {
+		*activities = append(*activities, activityType+": "+description)
+	}
+	t.Cleanup(func() {
+		coderImageUpgrade, coderImageDesired, coderImageSave, coderImageActivity = upgrade, desired, save, activity
+	})
+	return activities, saved
+}
+
+func TestUpgradeCoder(t *testing.T) {
+	activities, saved := fakeCoderUpgrade(t, nil)
+
+	testutils.AssertEqual(t, nil, UpgradeCoder(""))
+	testutils.AssertEqual(t, []string{"4.98.0"}, *saved)
+	testutils.AssertEqual(t, []string{
+		"coder_upgrade: Upgraded the coder container from codercom/code-server:4.96.4 to codercom/code-server:4.98.0",
+	}, *activities)
+}
+
+func TestUpgradeCoderRolledBack(t *testing.T) {
+	activities, saved := fakeCoderUpgrade(t, &services.CoderRollbackError{
+		Previous: "codercom/code-server:4.96.4",
+		Reason:   errors.New("no answer within 2m0s: connection refused"),
+	})
+
+	err := UpgradeCoder("4.100.0")
+	testutils.AssertEqual(t, true, err != nil)
+	testutils.AssertEqual(t, 0, len(*saved))
+	testutils.AssertEqual(t, []string{
+		"coder_upgrade_rolled_back: Rolled the coder container back to codercom/code-server:4.96.4: " +
+			"codercom/code-server:4.100.0 didn't pass its health check (no answer within 2m0s: connection refused)",
+	}, *activities)
+}
+
+func TestUpgradeCoderAlreadyRunning(t *testing.T) {
+	activities, _ := fakeCoderUpgrade(t, services.ErrCoderUpgradeRunning)
+
+	testutils.AssertEqual(t, services.ErrCoderUpgradeRunning, UpgradeCoder("4.100.0"))
+	testutils.AssertEqual(t, 0, len(*activities))
+}
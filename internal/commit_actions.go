@@ -0,0 +1,169 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"workbench/models"
+	"workbench/services"
+)
+
+// commitIDPattern matches a full or abbreviated commit id: SHA-1 or
+// SHA-256 hex, at least as long as git's shortest abbreviation.
+var commitIDPattern = regexp.MustCompile(`^[0-9a-fA-F]{4,64}$`)
+
+// Seams for reverting and cherry-picking. Replaced in tests.
+var (
+	commitActionExec     = services.CoderExec
+	commitActionActivity = func(activity *models.Activity) { go models.Activities.Insert(activity) }
+)
+
+// CommitConflictError is a revert or cherry-pick stopped by conflicts. The
+// repository is left with the operation in progress, to be resolved or
+// aborted from the merge banner.
+type CommitConflictError struct {
+	Operation  string // RevertInProgress or CherryPickInProgress
+	SHA        string // The commit being applied
+	Conflicted []string
+}
+
+func (e *CommitConflictError) Error() string {
+	return fmt.Sprintf("%s of %s stopped on conflicts in %s - resolve or abort it from the merge banner",
+		e.Operation, shortHash(e.SHA), plural(len(e.Conflicted), "file", "files"))
+}
+
+// ValidateCommitID checks sha looks like a full or abbreviated commit id.
+func ValidateCommitID(sha string) (string, error) {
+	sha = strings.TrimSpace(sha)
+	if !commitIDPattern.MatchString(sha) {
+		return "", &repoError{ErrInvalidInput, "a commit is a full or abbreviated hex id, e.g. 3f9c2ab"}
+	}
+	return strings.ToLower(sha), nil
+}
+
+// RevertCommit commits the inverse of a commit on the current branch and
+// returns the new commit's id.
+func RevertCommit(repoName, sha string) (string, error) {
+	return applyCommit(repoName, sha, RevertInProgress)
+}
+
+// CherryPickCommit applies a commit from any branch onto the current
+// branch and returns the new commit's id. The new commit's message notes
+// where it was picked from.
+func CherryPickCommit(repoName, sha string) (string, error) {
+	return applyCommit(repoName, sha, CherryPickInProgress)
+}
+
+// applyCommit reverts or cherry-picks a commit, refusing while the
+// working tree has uncommitted changes or another operation is in
+// progress. Conflicts leave the operation in progress and return a
+// CommitConflictError. The outcome is recorded as a git_revert or
+// git_cherry_pick activity with both commits in its metadata, or with
+// _failed.
+func applyCommit(repoName, sha, operation string) (string, error) {
+	sha, err := ValidateCommitID(sha)
+	if err != nil {
+		return "", err
+	}
+	repo, err := findRepo(repoName)
+	if err != nil {
+		return "", repoNotFound(repoName)
+	}
+	dir := shellQuote(repo.LocalPath)
+
+	output, err := commitActionExec(fmt.Sprintf("cd %s && git cat-file -e %s 2>/dev/null && git rev-parse --verify -q %s", dir, sha+"^{commit}", sha+"^{commit}"))
+	if err != nil {
+		return "", &repoError{ErrInvalidInput, fmt.Sprintf("commit %s not found in %s", sha, repoName)}
+	}
+	original := strings.TrimSpace(output)
+
+	state, err := mergeState(repo)
+	if err != nil {
+		return "", err
+	}
+	if state.InProgress() {
+		return "", &AppError{Code: CodeConflict, UserMessage: fmt.Sprintf("a %s is in progress in %s - finish or abort it first", state.Operation, repoName)}
+	}
+	if output, err = commitActionExec(fmt.Sprintf("cd %s && git status --porcelain --untracked-files=no 2>&1", dir)); err != nil {
+		return "", fmt.Errorf("failed to read status: %s", strings.TrimSpace(output))
+	}
+	if strings.TrimSpace(output) != "" {
+		return "", ErrDirtyWorkingTree
+	}
+
+	command := "git revert --no-edit " + original
+	if operation == CherryPickInProgress {
+		command = "git cherry-pick -x " + original
+	}
+	activityType := "git_" + strings.ReplaceAll(operation, "-", "_")
+	output, err = commitActionExec(fmt.Sprintf("cd %s && %s 2>&1 && git rev-parse HEAD", dir, command))
+	if err != nil {
+		output = ScrubGitTokens(strings.TrimSpace(output))
+		err = commitActionError(repo, operation, original, output, err)
+		commitActionActivity(&models.Activity{
+			Type:        activityType + "_failed",
+			Repository:  repoName,
+			Description: fmt.Sprintf("Failed to %s %s in %s: %s", operation, shortHash(original), repoName, output),
+			Author:      "System",
+			Timestamp:   time.Now(),
+			Metadata:    commitActionMetadata(original, ""),
+		})
+		return "", err
+	}
+
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	created := strings.TrimSpace(lines[len(lines)-1])
+	description := fmt.Sprintf("Reverted %s in %s as %s", shortHash(original), repoName, shortHash(created))
+	if operation == CherryPickInProgress {
+		description = fmt.Sprintf("Cherry-picked %s in %s as %s", shortHash(original), repoName, shortHash(created))
+	}
+	commitActionActivity(&models.Activity{
+		Type:        activityType,
+		Repository:  repoName,
+		Description: description,
+		Author:      "System",
+		Timestamp:   time.Now(),
+		Metadata:    commitActionMetadata(original, created),
+	})
+	return created, nil
+}
+
+// commitActionError explains a failed revert or cherry-pick. Conflicts
+// are left for the merge banner; anything else that left the operation
+// in progress, such as a pick with nothing left to apply, is aborted so
+// the repository is as it was.
+func commitActionError(repo *models.Repository, operation, sha, output string, err error) error {
+	if strings.Contains(output, "is a merge but no -m option") {
+		return &repoError{ErrInvalidInput, fmt.Sprintf("%s is a merge commit - %s it in VS Code, choosing the parent to keep", shortHash(sha), operation)}
+	}
+	state, stateErr := mergeState(repo)
+	if stateErr == nil && state.Operation == operation && len(state.Conflicted) > 0 {
+		conflict := &CommitConflictError{operation, sha, state.Conflicted}
+		return &AppError{Code: CodeConflict, UserMessage: conflict.Error(), Detail: output, Err: conflict}
+	}
+	if stateErr == nil && state.Operation == operation {
+		commitActionExec(fmt.Sprintf("cd %s && git %s --abort 2>&1", shellQuote(repo.LocalPath), operation))
+	}
+	if strings.Contains(output, "now empty") || strings.Contains(output, "nothing to commit") {
+		return &repoError{ErrInvalidInput, fmt.Sprintf("%s of %s changes nothing on the current branch", operation, shortHash(sha))}
+	}
+	return &AppError{
+		Code:        CodeFailed,
+		UserMessage: fmt.Sprintf("%s failed - git's output is in the activity log", operation),
+		Detail:      output,
+		Err:         err,
+	}
+}
+
+// commitActionMetadata is the Metadata of a revert or cherry-pick
+// activity: the commit applied and the commit it made, when there is one.
+func commitActionMetadata(original, created string) string {
+	metadata := map[string]string{"original": original}
+	if created != "" {
+		metadata["commit"] = created
+	}
+	data, _ := json.Marshal(metadata)
+	return string(data)
+}
@@ -0,0 +1,133 @@
+package internal
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"workbench/models"
+
+	"github.com/The-Skyscape/devtools/pkg/testutils"
+)
+
+const (
+	pickedSHA  = "3f9c2ab8d1e4f5a6b7c8d9e0f1a2b3c4d5e6f7a8"
+	createdSHA = "9a8b7c6d5e4f3a2b1c0d9e8f7a6b5c4d3e2f1a0b"
+)
+
+// commitActionFake is a repository for reverts and cherry-picks. Applying
+// a commit answers with applyOutput and applyErr and leaves afterState as
+// the merge state.
+type commitActionFake struct {
+	status     string // git status --porcelain output
+	applyOut   string
+	applyErr   error
+	afterState string
+	state      string
+	commands   []string
+	activities []*models.Activity
+}
+
+func fakeCommitAction(t *testing.T, fake *commitActionFake) *commitActionFake {
+	find, exec, merge, activity := findRepo, commitActionExec, mergeExec, commitActionActivity
+	t.Cleanup(func() { findRepo, commitActionExec, mergeExec, commitActionActivity = find, exec, merge, activity })
+
+	fake.state = "--\n"
+	findRepo = func(name string) (*models.Repository, error) {
+		if name != mergeRepo.Name {
+			return nil, errors.New("not found")
+		}
+		return mergeRepo, nil
+	}
+	commitActionExec = func(cmd string) (string, error) {
+		fake.commands = append(fake.commands, cmd)
+		switch {
+		case strings.Contains(cmd, "git cat-file -e 3f9c2ab^{commit}"):
+			return pickedSHA + "\n", nil
+		case strings.Contains(cmd, "git cat-file"):
+			return "", errors.New("exit status 1")
+		case strings.Contains(cmd, "git status"):
+			return fake.status, nil
+		case strings.Contains(cmd, "git revert --no-edit"), strings.Contains(cmd, "git cherry-pick -x"):
+			fake.state = fake.afterState
+			return fake.applyOut, fake.applyErr
+		}
+		return "", nil
+	}
+	mergeExec = func(string) (string, error) { return fake.state, nil }
+	commitActionActivity = func(a *models.Activity) { fake.activities = append(fake.activities, a) }
+	return fake
+}
+
+func TestRevertCommit(t *testing.T) {
+	fake := fakeCommitAction(t, &commitActionFake{applyOut: "[main 9a8b7c6] Revert \"Fix the build\"\n 1 file changed\n" + createdSHA + "\n"})
+
+	created, err := RevertCommit("api", " 3F9C2AB ")
+	testutils.AssertEqual(t, nil, err)
+	testutils.AssertEqual(t, createdSHA, created)
+	testutils.AssertEqual(t, true, strings.HasSuffix(fake.commands[2], "git revert --no-edit "+pickedSHA+" 2>&1 && git rev-parse HEAD"))
+	testutils.AssertEqual(t, 1, len(fake.activities))
+	testutils.AssertEqual(t, "git_revert", fake.activities[0].Type)
+	testutils.AssertEqual(t, "Reverted 3f9c2ab in api as 9a8b7c6", fake.activities[0].Description)
+	testutils.AssertEqual(t, `{"commit":"`+createdSHA+`","original":"`+pickedSHA+`"}`, fake.activities[0].Metadata)
+}
+
+func TestCommitActionRefusals(t *testing.T) {
+	testCases := []struct {
+		name   string
+		sha    string
+		status string
+		state  string
+		err    string
+	}{
+		{"not hex", "3f9c2ab; rm -rf /", "", "--\n", "a commit is a full or abbreviated hex id, e.g. 3f9c2ab"},
+		{"too short", "3f9", "", "--\n", "a commit is a full or abbreviated hex id, e.g. 3f9c2ab"},
+		{"unknown commit", "deadbeef", "", "--\n", "commit deadbeef not found in api"},
+		{"dirty", "3f9c2ab", " M main.go\n", "--\n", ErrDirtyWorkingTree.Error()},
+		{"in progress", "3f9c2ab", "", "MERGE_HEAD\n--\n", "a merge is in progress in api - finish or abort it first"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			fake := fakeCommitAction(t, &commitActionFake{status: tc.status})
+			fake.state = tc.state
+
+			_, err := CherryPickCommit("api", tc.sha)
+			testutils.AssertEqual(t, tc.err, err.Error())
+			for _, cmd := range fake.commands {
+				testutils.AssertEqual(t, false, strings.Contains(cmd, "cherry-pick"))
+			}
+			testutils.AssertEqual(t, 0, len(fake.activities))
+		})
+	}
+}
+
+func TestCherryPickConflict(t *testing.T) {
+	fake := fakeCommitAction(t, &commitActionFake{
+		applyOut:   "error: could not apply 3f9c2ab... Fix the build\nCONFLICT (content): Merge conflict in main.go\n",
+		applyErr:   errors.New("exit status 1"),
+		afterState: "CHERRY_PICK_HEAD\n--\nmain.go\n",
+	})
+
+	_, err := CherryPickCommit("api", "3f9c2ab")
+	var conflict *CommitConflictError
+	testutils.AssertEqual(t, true, errors.As(err, &conflict))
+	testutils.AssertEqual(t, []string{"main.go"}, conflict.Conflicted)
+	testutils.AssertEqual(t, CodeConflict, ErrorCode(err))
+	testutils.AssertEqual(t, "cherry-pick of 3f9c2ab stopped on conflicts in 1 file - resolve or abort it from the merge banner", err.Error())
+	// Left in progress for the merge banner
+	testutils.AssertEqual(t, false, strings.Contains(strings.Join(fake.commands, "\n"), "--abort"))
+	testutils.AssertEqual(t, "git_cherry_pick_failed", fake.activities[0].Type)
+	testutils.AssertEqual(t, `{"original":"`+pickedSHA+`"}`, fake.activities[0].Metadata)
+}
+
+func TestCherryPickAlreadyApplied(t *testing.T) {
+	fake := fakeCommitAction(t, &commitActionFake{
+		applyOut:   "The previous cherry-pick is now empty, possibly due to conflict resolution.\n",
+		applyErr:   errors.New("exit status 1"),
+		afterState: "CHERRY_PICK_HEAD\n--\n",
+	})
+
+	_, err := CherryPickCommit("api", "3f9c2ab")
+	testutils.AssertEqual(t, true, errors.Is(err, ErrInvalidInput))
+	testutils.AssertEqual(t, true, strings.HasSuffix(fake.commands[len(fake.commands)-1], "git cherry-pick --abort 2>&1"))
+}
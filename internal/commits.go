@@ -0,0 +1,84 @@
+package internal
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"workbench/models"
+	"workbench/services"
+)
+
+// Commit log page sizes.
+const (
+	DefaultCommitLogLimit = 10
+	MaxCommitLogLimit     = 100
+)
+
+// Commit is one entry of a repository's history.
+type Commit struct {
+	SHA      string
+	ShortSHA string
+	Author   string
+	Email    string
+	Date     time.Time // Committer date
+	Subject  string
+}
+
+// commitFormat separates fields with the unit separator and puts the
+// subject last, so a subject containing the separator stays intact. Log
+// entries are separated by NUL with -z, which a subject can't contain.
+const commitFormat = "%H%x1f%h%x1f%an%x1f%ae%x1f%cI%x1f%s"
+
+// commitExec runs git log in the coder container. Replaced in tests.
+var commitExec = services.CoderExec
+
+// GetCommitLog returns up to limit commits reachable from HEAD, newest
+// first, skipping the first offset, and whether there are older ones. A
+// repository with no commits yet has an empty log rather than an error.
+func GetCommitLog(repoName string, limit, offset int) ([]*Commit, bool, error) {
+	repo, err := models.Repositories.Find("WHERE Name = ?", repoName)
+	if err != nil {
+		return nil, false, fmt.Errorf("repository '%s' not found", repoName)
+	}
+	return commitLog(repo, limit, offset)
+}
+
+func commitLog(repo *models.Repository, limit, offset int) ([]*Commit, bool, error) {
+	if limit <= 0 {
+		limit = DefaultCommitLogLimit
+	}
+	limit = min(limit, MaxCommitLogLimit)
+	offset = max(offset, 0)
+
+	// One extra commit tells whether there is an older page
+	cmd := fmt.Sprintf("cd %s && { git rev-parse -q --verify HEAD >/dev/null || exit 0; } && git log -z --max-count=%d --skip=%d --pretty=format:'%s' 2>&1",
+		shellQuote(repo.LocalPath), limit+1, offset, commitFormat)
+	output, err := commitExec(cmd)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read commit log: %s", strings.TrimSpace(output))
+	}
+	commits := parseCommitLog(output)
+	return commits[:min(len(commits), limit)], len(commits) > limit, nil
+}
+
+// parseCommitLog parses git log -z output in commitFormat. Malformed
+// entries are skipped.
+func parseCommitLog(output string) []*Commit {
+	commits := []*Commit{}
+	for _, entry := range strings.Split(output, "\x00") {
+		fields := strings.SplitN(strings.TrimPrefix(entry, "\n"), "\x1f", 6)
+		if len(fields) != 6 {
+			continue
+		}
+		date, _ := time.Parse(time.RFC3339, fields[4])
+		commits = append(commits, &Commit{
+			SHA:      fields[0],
+			ShortSHA: fields[1],
+			Author:   fields[2],
+			Email:    fields[3],
+			Date:     date,
+			Subject:  fields[5],
+		})
+	}
+	return commits
+}
@@ -0,0 +1,80 @@
+package internal
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+	"workbench/models"
+
+	"github.com/The-Skyscape/devtools/pkg/testutils"
+)
+
+func TestParseCommitLog(t *testing.T) {
+	output := "a1b2c3d4e5\x1fa1b2c3d\x1fJane Doe\x1fjane@example.com\x1f2024-05-01T10:30:00+02:00\x1fFix the build\x00" +
+		"f6e5d4c3b2\x1ff6e5d4c\x1fJohn\x1fjohn@example.com\x1f2024-04-30T08:00:00Z\x1fSplit on \x1f and \x1e safely"
+
+	commits := parseCommitLog(output)
+	testutils.AssertEqual(t, 2, len(commits))
+	testutils.AssertEqual(t, "a1b2c3d4e5", commits[0].SHA)
+	testutils.AssertEqual(t, "a1b2c3d", commits[0].ShortSHA)
+	testutils.AssertEqual(t, "Jane Doe", commits[0].Author)
+	testutils.AssertEqual(t, "jane@example.com", commits[0].Email)
+	testutils.AssertEqual(t, time.Date(2024, 5, 1, 8, 30, 0, 0, time.UTC), commits[0].Date.UTC())
+	testutils.AssertEqual(t, "Fix the build", commits[0].Subject)
+	testutils.AssertEqual(t, "Split on \x1f and \x1e safely", commits[1].Subject)
+}
+
+func TestCommitLogEmptyRepository(t *testing.T) {
+	original := commitExec
+	t.Cleanup(func() { commitExec = original })
+
+	var command string
+	commitExec = func(cmd string) (string, error) {
+		command = cmd
+		return "", nil
+	}
+
+	commits, hasMore, err := commitLog(&models.Repository{Name: "new", LocalPath: "/home/coder/repos/new"}, 500, -3)
+	testutils.AssertEqual(t, nil, err)
+	testutils.AssertEqual(t, 0, len(commits))
+	testutils.AssertEqual(t, true, commits != nil)
+	testutils.AssertEqual(t, false, hasMore)
+	testutils.AssertEqual(t, true, strings.Contains(command, "--max-count=101 --skip=0"))
+}
+
+func TestCommitLogHasMore(t *testing.T) {
+	original := commitExec
+	t.Cleanup(func() { commitExec = original })
+
+	// A history of 150 commits
+	var history []string
+	for i := range 150 {
+		history = append(history, fmt.Sprintf("%040d\x1f%07d\x1fJane\x1fjane@example.com\x1f2024-05-01T10:30:00Z\x1fCommit %d", i, i, i))
+	}
+	commitExec = func(cmd string) (string, error) {
+		var count, skip int
+		fmt.Sscanf(cmd[strings.Index(cmd, "--max-count="):], "--max-count=%d --skip=%d", &count, &skip)
+		page := history[min(skip, len(history)):min(skip+count, len(history))]
+		return strings.Join(page, "\x00"), nil
+	}
+
+	testCases := []struct {
+		limit, offset int
+		want          int
+		hasMore       bool
+	}{
+		{100, 0, 100, true},
+		{100, 50, 100, false},
+		{100, 100, 50, false},
+		{10, 139, 10, true},
+		{10, 140, 10, false},
+	}
+	for _, tc := range testCases {
+		repo := &models.Repository{Name: "api", LocalPath: "/home/coder/repos/api"}
+		commits, hasMore, err := commitLog(repo, tc.limit, tc.offset)
+		testutils.AssertEqual(t, nil, err)
+		testutils.AssertEqual(t, tc.want, len(commits))
+		testutils.AssertEqual(t, tc.hasMore, hasMore)
+	}
+}
@@ -0,0 +1,341 @@
+package internal
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"workbench/models"
+	"workbench/services"
+)
+
+// DefaultDiffMaxFileSize is how much of one file's diff is shown before
+// it's replaced by a link to the editor.
+const DefaultDiffMaxFileSize = 256 << 10
+
+// maxDiffFiles is how many changed files get a diff; the rest are listed
+// without one.
+const maxDiffFiles = 200
+
+// diffMarker starts each file's section in fileDiffScript output. Diff
+// content lines start with a space, +, -, @, or \, so it can't collide
+// with them.
+const diffMarker = "#workbench-diff "
+
+// Diff sections of a changed file.
+const (
+	DiffStaged    = "staged"
+	DiffUnstaged  = "unstaged"
+	DiffUntracked = "untracked"
+)
+
+// ChangedFile is a path with uncommitted changes, as listed by
+// git status --porcelain.
+type ChangedFile struct {
+	Path     string // Repository-relative, the new path of a rename
+	OrigPath string // Path before a rename or copy, else empty
+	Index    string // Staged status code, e.g. "M", "A", "R"; " " for none
+	Worktree string // Unstaged status code; "?" with Index for untracked
+}
+
+// Untracked reports whether git doesn't know the file yet.
+func (f *ChangedFile) Untracked() bool {
+	return f.Index == "?"
+}
+
+// Conflicted reports whether the file has unresolved merge conflicts.
+func (f *ChangedFile) Conflicted() bool {
+	return f.Index == "U" || f.Worktree == "U" || (f.Index == "A" && f.Worktree == "A") || (f.Index == "D" && f.Worktree == "D")
+}
+
+// Staged reports whether the file has changes in the index.
+func (f *ChangedFile) Staged() bool {
+	return !f.Untracked() && !f.Conflicted() && f.Index != " "
+}
+
+// Unstaged reports whether the working tree differs from the index.
+func (f *ChangedFile) Unstaged() bool {
+	return !f.Untracked() && !f.Conflicted() && f.Worktree != " "
+}
+
+// Deleted reports whether the file is gone from the working tree.
+func (f *ChangedFile) Deleted() bool {
+	return f.Worktree == "D" || (f.Index == "D" && f.Worktree == " ")
+}
+
+// Status describes the change in a word, e.g. "modified".
+func (f *ChangedFile) Status() string {
+	code := f.Index
+	if code == " " {
+		code = f.Worktree
+	}
+	switch {
+	case f.Untracked():
+		return "untracked"
+	case f.Conflicted():
+		return "conflicted"
+	case code == "A":
+		return "added"
+	case code == "D":
+		return "deleted"
+	case code == "R":
+		return "renamed"
+	case code == "C":
+		return "copied"
+	case code == "T":
+		return "type changed"
+	}
+	return "modified"
+}
+
+// DiffLine is a line of a unified diff with what kind of line it is, for
+// coloring: "meta" (headers), "hunk", "add", "del", or "context".
+type DiffLine struct {
+	Kind string
+	Text string
+}
+
+// DiffSection is one diff of a changed file: its staged changes, its
+// unstaged changes, or the content of an untracked file.
+type DiffSection struct {
+	Kind     string // DiffStaged, DiffUnstaged, or DiffUntracked
+	Lines    []DiffLine
+	Binary   bool
+	TooLarge bool // Over the diff_max_file_size setting; Lines is empty
+}
+
+// FileDiff is a changed file with its diffs.
+type FileDiff struct {
+	*ChangedFile
+	Sections []*DiffSection
+	Omitted  bool // Past maxDiffFiles, so no diff was read
+}
+
+// diffExec runs diff commands in the coder container, keeping at most
+// maxOutput bytes of output. Replaced in tests.
+var diffExec = func(command string, maxOutput int) (string, error) {
+	result, err := services.CoderExecWithOptions(command, services.ExecOptions{MaxOutput: maxOutput})
+	return result.Output, err
+}
+
+// DiffMaxFileSize returns the diff_max_file_size setting.
+func DiffMaxFileSize() int {
+	return models.GetIntSetting("diff_max_file_size")
+}
+
+// GetChangedFiles lists a repository's uncommitted changes, untracked
+// files included, in git's order.
+func GetChangedFiles(repoName string) ([]*ChangedFile, error) {
+	repo, err := models.Repositories.Find("WHERE Name = ?", repoName)
+	if err != nil {
+		return nil, repoNotFound(repoName)
+	}
+	return changedFiles(repo)
+}
+
+func changedFiles(repo *models.Repository) ([]*ChangedFile, error) {
+	output, err := diffExec(fmt.Sprintf("cd %s && git status --porcelain -z --untracked-files=all 2>&1", shellQuote(repo.LocalPath)), services.DefaultMaxOutput)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read changed files: %s", strings.TrimSpace(output))
+	}
+	return parseChangedFiles(output), nil
+}
+
+// parseChangedFiles parses git status --porcelain -z output: "XY path"
+// entries separated by NULs, where renames and copies are followed by
+// their original path.
+func parseChangedFiles(output string) []*ChangedFile {
+	var files []*ChangedFile
+	entries := strings.Split(output, "\x00")
+	for i := 0; i < len(entries); i++ {
+		entry := entries[i]
+		if len(entry) < 4 || entry[2] != ' ' {
+			continue
+		}
+		file := &ChangedFile{Index: entry[:1], Worktree: entry[1:2], Path: entry[3:]}
+		if (file.Index == "R" || file.Index == "C") && i+1 < len(entries) {
+			i++
+			file.OrigPath = entries[i]
+		}
+		files = append(files, file)
+	}
+	return files
+}
+
+// GetRepoDiff returns the unified diff of a repository's unstaged
+// changes, or its staged changes, limited to path when it isn't empty.
+// Untracked files have no diff here; GetDiffView shows their content.
+func GetRepoDiff(repoName string, staged bool, path string) (string, error) {
+	repo, err := models.Repositories.Find("WHERE Name = ?", repoName)
+	if err != nil {
+		return "", repoNotFound(repoName)
+	}
+	pathspec := ""
+	if path != "" {
+		rel, err := cleanBrowsePath(path)
+		if err != nil {
+			return "", err
+		}
+		pathspec = " -- " + shellQuote(rel)
+	}
+	cached := ""
+	if staged {
+		cached = " --cached"
+	}
+
+	output, err := diffExec(fmt.Sprintf("cd %s && git diff%s -M --no-color --no-ext-diff%s 2>&1", shellQuote(repo.LocalPath), cached, pathspec), services.DefaultMaxOutput)
+	if err != nil {
+		return "", fmt.Errorf("failed to read diff: %s", strings.TrimSpace(output))
+	}
+	return output, nil
+}
+
+// GetDiffView returns every changed file of a repository with its staged
+// and unstaged diffs, read in one pass. Untracked files show their whole
+// content as additions. Each diff is cut off past maxSize bytes and
+// marked TooLarge instead.
+func GetDiffView(repoName string, maxSize int) ([]*FileDiff, error) {
+	repo, err := models.Repositories.Find("WHERE Name = ?", repoName)
+	if err != nil {
+		return nil, repoNotFound(repoName)
+	}
+	files, err := changedFiles(repo)
+	if err != nil {
+		return nil, err
+	}
+	if maxSize <= 0 {
+		maxSize = DefaultDiffMaxFileSize
+	}
+
+	diffs := make([]*FileDiff, len(files))
+	for i, file := range files {
+		diffs[i] = &FileDiff{ChangedFile: file, Omitted: i >= maxDiffFiles}
+	}
+	script, sections := fileDiffScript(files[:min(len(files), maxDiffFiles)], maxSize)
+	if script == "" {
+		return diffs, nil
+	}
+
+	output, err := diffExec(fmt.Sprintf("cd %s && { %s }", shellQuote(repo.LocalPath), script), sections*(maxSize+4096)+4096)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read diff: %s", strings.TrimSpace(output))
+	}
+	for _, section := range parseDiffSections(output, maxSize) {
+		if section.file < len(diffs) {
+			diffs[section.file].Sections = append(diffs[section.file].Sections, section.DiffSection)
+		}
+	}
+	return diffs, nil
+}
+
+// fileDiffScript builds the shell commands printing each file's diffs,
+// each after a "#workbench-diff <index> <kind>" line and cut off one byte
+// past maxSize so oversized ones can be told apart. Untracked files larger
+// than maxSize aren't read at all. Returns the script and its number of
+// sections.
+func fileDiffScript(files []*ChangedFile, maxSize int) (string, int) {
+	var b strings.Builder
+	sections := 0
+	limit := fmt.Sprintf(" 2>&1 | head -c %d; ", maxSize+1)
+	marker := func(i int, kind string) {
+		fmt.Fprintf(&b, "echo '%s%d %s'; ", diffMarker, i, kind)
+		sections++
+	}
+
+	for i, file := range files {
+		path := shellQuote(file.Path)
+		switch {
+		case file.Untracked():
+			marker(i, DiffUntracked)
+			fmt.Fprintf(&b, "if [ $(stat -c %%s -- %[1]s 2>/dev/null || echo 0) -gt %[2]d ]; then echo '# too large'; "+
+				"else git diff --no-index --no-color --no-ext-diff -- /dev/null %[1]s 2>&1 | head -c %[3]d; fi; ", path, maxSize, maxSize+1)
+		case file.Conflicted():
+			marker(i, DiffUnstaged)
+			b.WriteString("git diff --no-color --no-ext-diff -- " + path + limit)
+		default:
+			if file.Staged() {
+				marker(i, DiffStaged)
+				paths := path
+				if file.OrigPath != "" {
+					paths = shellQuote(file.OrigPath) + " " + path
+				}
+				b.WriteString("git diff --cached -M --no-color --no-ext-diff -- " + paths + limit)
+			}
+			if file.Unstaged() {
+				marker(i, DiffUnstaged)
+				b.WriteString("git diff --no-color --no-ext-diff -- " + path + limit)
+			}
+		}
+	}
+	return b.String(), sections
+}
+
+// fileSection is a parsed section and the index of its file.
+type fileSection struct {
+	*DiffSection
+	file int
+}
+
+// parseDiffSections splits fileDiffScript output at its markers and classifies
+// each diff's lines. A section longer than maxSize is marked TooLarge.
+func parseDiffSections(output string, maxSize int) []fileSection {
+	var sections []fileSection
+	var current *fileSection
+	var size int
+	inHunk := false
+
+	finish := func() {
+		if current != nil && size > maxSize {
+			current.TooLarge = true
+			current.Lines = nil
+		}
+	}
+	for _, line := range strings.Split(output, "\n") {
+		if rest, ok := strings.CutPrefix(line, diffMarker); ok {
+			finish()
+			index, kind, _ := strings.Cut(rest, " ")
+			n, err := strconv.Atoi(index)
+			if err != nil {
+				current = nil
+				continue
+			}
+			sections = append(sections, fileSection{DiffSection: &DiffSection{Kind: kind}, file: n})
+			current = &sections[len(sections)-1]
+			size, inHunk = 0, false
+			continue
+		}
+		if current == nil {
+			continue
+		}
+		size += len(line) + 1
+		if line == "# too large" {
+			size = maxSize + 1
+			continue
+		}
+		if current.TooLarge || size > maxSize {
+			continue
+		}
+
+		kind := "context"
+		switch {
+		case strings.HasPrefix(line, "@@"):
+			kind, inHunk = "hunk", true
+		case !inHunk:
+			kind = "meta"
+			if strings.HasPrefix(line, "Binary files ") {
+				current.Binary = true
+			}
+		case strings.HasPrefix(line, "+"):
+			kind = "add"
+		case strings.HasPrefix(line, "-"):
+			kind = "del"
+		case strings.HasPrefix(line, "diff "):
+			kind, inHunk = "meta", false
+		}
+		if line == "" && kind == "context" {
+			continue // The newline ending the diff
+		}
+		current.Lines = append(current.Lines, DiffLine{Kind: kind, Text: line})
+	}
+	finish()
+	return sections
+}
@@ -0,0 +1,115 @@
+package internal
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/The-Skyscape/devtools/pkg/testutils"
+)
+
+func TestParseChangedFiles(t *testing.T) {
+	output := strings.Join([]string{
+		"M  staged.go",
+		" M unstaged.go",
+		"MM both.go",
+		"R  new name.go", "old name.go",
+		"?? notes/todo.md",
+		" D gone.go",
+		"UU conflict.go",
+		"",
+	}, "\x00")
+
+	var summary []string
+	for _, f := range parseChangedFiles(output) {
+		summary = append(summary, f.Path+"|"+f.OrigPath+"|"+f.Status())
+	}
+	testutils.AssertEqual(t, strings.Join([]string{
+		"staged.go||modified",
+		"unstaged.go||modified",
+		"both.go||modified",
+		"new name.go|old name.go|renamed",
+		"notes/todo.md||untracked",
+		"gone.go||deleted",
+		"conflict.go||conflicted",
+	}, ","), strings.Join(summary, ","))
+}
+
+func TestChangedFileSections(t *testing.T) {
+	testCases := []struct {
+		index, worktree    string
+		staged, unstaged   bool
+		untracked, deleted bool
+	}{
+		{"M", " ", true, false, false, false},
+		{" ", "M", false, true, false, false},
+		{"A", "M", true, true, false, false},
+		{"?", "?", false, false, true, false},
+		{" ", "D", false, true, false, true},
+		{"D", " ", true, false, false, true},
+		{"U", "U", false, false, false, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.index+tc.worktree, func(t *testing.T) {
+			f := &ChangedFile{Index: tc.index, Worktree: tc.worktree}
+			testutils.AssertEqual(t, tc.staged, f.Staged())
+			testutils.AssertEqual(t, tc.unstaged, f.Unstaged())
+			testutils.AssertEqual(t, tc.untracked, f.Untracked())
+			testutils.AssertEqual(t, tc.deleted, f.Deleted())
+		})
+	}
+}
+
+func TestFileDiffScript(t *testing.T) {
+	files := []*ChangedFile{
+		{Index: "R", Worktree: "M", Path: "new.go", OrigPath: "old.go"},
+		{Index: "?", Worktree: "?", Path: "it's.txt"},
+	}
+	script, sections := fileDiffScript(files, 100)
+	testutils.AssertEqual(t, 3, sections)
+	testutils.AssertEqual(t, true, strings.Contains(script, "echo '#workbench-diff 0 staged'; git diff --cached -M --no-color --no-ext-diff -- 'old.go' 'new.go' 2>&1 | head -c 101;"))
+	testutils.AssertEqual(t, true, strings.Contains(script, "echo '#workbench-diff 0 unstaged'; git diff --no-color --no-ext-diff -- 'new.go' 2>&1 | head -c 101;"))
+	testutils.AssertEqual(t, true, strings.Contains(script, `-gt 100 ]; then echo '# too large'; else git diff --no-index --no-color --no-ext-diff -- /dev/null 'it'\''s.txt'`))
+}
+
+func TestParseDiffSections(t *testing.T) {
+	output := strings.Join([]string{
+		"#workbench-diff 0 staged",
+		"diff --git a/main.go b/main.go",
+		"index 1111111..2222222 100644",
+		"--- a/main.go",
+		"+++ b/main.go",
+		"@@ -1,2 +1,2 @@",
+		" package main",
+		"-// old",
+		"+// new",
+		"#workbench-diff 1 untracked",
+		"# too large",
+		"#workbench-diff 2 untracked",
+		"diff --git a/logo.png b/logo.png",
+		"new file mode 100644",
+		"Binary files /dev/null and b/logo.png differ",
+		"#workbench-diff 3 unstaged",
+		"diff --git a/big.txt b/big.txt",
+		"@@ -1 +1 @@",
+		"+" + strings.Repeat("x", 200),
+		"",
+	}, "\n")
+
+	sections := parseDiffSections(output, 150)
+	testutils.AssertEqual(t, 4, len(sections))
+
+	var kinds []string
+	for _, line := range sections[0].Lines {
+		kinds = append(kinds, line.Kind)
+	}
+	testutils.AssertEqual(t, "meta,meta,meta,meta,hunk,context,del,add", strings.Join(kinds, ","))
+	testutils.AssertEqual(t, DiffStaged, sections[0].Kind)
+
+	testutils.AssertEqual(t, 1, sections[1].file)
+	testutils.AssertEqual(t, true, sections[1].TooLarge)
+	testutils.AssertEqual(t, true, sections[2].Binary)
+	testutils.AssertEqual(t, false, sections[2].TooLarge)
+	testutils.AssertEqual(t, true, sections[3].TooLarge)
+	testutils.AssertEqual(t, 0, len(sections[3].Lines))
+}
@@ -0,0 +1,291 @@
+package internal
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"io"
+	"os/exec"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"workbench/services"
+
+	"github.com/The-Skyscape/devtools/pkg/database"
+)
+
+// Limits of the disk usage breakdown.
+const (
+	DiskBreakdownTop = 10 // Largest top-level entries listed
+
+	diskBreakdownDepth    = 2 // Levels below each scanned directory du reports
+	diskBreakdownChildren = 3 // Largest entries listed under each top-level one
+	diskBreakdownTTL      = 10 * time.Minute
+	diskBreakdownTimeout  = 30 * time.Second
+)
+
+// CoderHomeDir is the coder container's home directory, holding the
+// repos directory and VS Code's configuration and extensions.
+const CoderHomeDir = "/home/coder"
+
+// coderHomeVolume is the host directory services.Coder mounts as
+// CoderHomeDir.
+const coderHomeVolume = "/mnt/data/services/workbench-coder"
+
+// DiskUsageEntry is a directory or file and how much of the scanned total
+// it takes up.
+type DiskUsageEntry struct {
+	Path     string            `json:"path"`
+	InCoder  bool              `json:"in_coder"` // A path inside the coder container
+	Bytes    uint64            `json:"bytes"`
+	Share    float64           `json:"share"` // Percent of DiskBreakdown.Total
+	Children []*DiskUsageEntry `json:"children,omitempty"`
+}
+
+// DiskBreakdown is what takes up space under the data directory and the
+// coder container's home directory, largest first.
+type DiskBreakdown struct {
+	Total      uint64            `json:"total_bytes"`
+	Entries    []*DiskUsageEntry `json:"entries"`
+	Incomplete bool              `json:"incomplete"` // The scan timed out or failed part way; sizes are of what was read
+	Errors     []string          `json:"errors,omitempty"`
+	Scanned    time.Time         `json:"scanned"`
+}
+
+// diskRoot is a directory the breakdown scans.
+type diskRoot struct {
+	Path    string
+	InCoder bool
+	Exclude string // A directory under Path left out, being scanned as a root of its own
+}
+
+// depth is how many levels below the root du must report to reach
+// Exclude as well as diskBreakdownDepth.
+func (root diskRoot) depth() int {
+	rel, under := strings.CutPrefix(path.Clean(root.Exclude), strings.TrimSuffix(root.Path, "/")+"/")
+	if root.Exclude == "" || !under {
+		return diskBreakdownDepth
+	}
+	return max(diskBreakdownDepth, strings.Count(rel, "/")+1)
+}
+
+var (
+	diskBreakdownMu   sync.Mutex // Guards diskBreakdown and diskBreakdownScan; not held while scanning
+	diskBreakdown     *DiskBreakdown
+	diskBreakdownScan chan struct{} // Closed when the running scan finishes; nil when none runs
+
+	// Seams for the breakdown scan. Replaced in tests.
+	diskRoots = func() []diskRoot {
+		roots := []diskRoot{{Path: database.DataDir()}}
+		if services.Coder.IsRunning() {
+			// The coder home is on the data volume too; count it once
+			roots[0].Exclude = coderHomeVolume
+			roots = append(roots, diskRoot{Path: CoderHomeDir, InCoder: true})
+		}
+		return roots
+	}
+	diskUsage = func(ctx context.Context, root diskRoot) io.ReadCloser {
+		args := []string{"du", "-a", "-x", "-k", "-d", strconv.Itoa(root.depth()), root.Path}
+		if root.InCoder {
+			return services.CoderExecArgsReader(ctx, args...)
+		}
+		return localReader(ctx, args...)
+	}
+)
+
+// GetDataDirBreakdown returns the largest directories and files under the
+// data directory and, while it runs, the coder container's home
+// directory. du is run diskBreakdownDepth levels deep and the result is
+// reused for diskBreakdownTTL. Once it expires the next call starts a
+// scan in the background and gets the previous result meanwhile; only
+// calls before the first scan finishes wait for it. A scan that runs
+// past diskBreakdownTimeout is stopped and what was read so far is
+// returned, marked Incomplete.
+func GetDataDirBreakdown() *DiskBreakdown {
+	diskBreakdownMu.Lock()
+	cached, scan := diskBreakdown, diskBreakdownScan
+	if scan == nil && (cached == nil || time.Since(cached.Scanned) > diskBreakdownTTL) {
+		scan = make(chan struct{})
+		diskBreakdownScan = scan
+		go refreshDiskBreakdown(scan)
+	}
+	diskBreakdownMu.Unlock()
+
+	if cached == nil {
+		<-scan
+		diskBreakdownMu.Lock()
+		cached = diskBreakdown
+		diskBreakdownMu.Unlock()
+	}
+	copied := *cached
+	return &copied
+}
+
+// refreshDiskBreakdown scans the disk and caches the result, closing done
+// when it has.
+func refreshDiskBreakdown(done chan struct{}) {
+	ctx, cancel := context.WithTimeout(context.Background(), diskBreakdownTimeout)
+	defer cancel()
+	breakdown := scanDiskBreakdown(ctx, diskRoots(), time.Now())
+
+	diskBreakdownMu.Lock()
+	diskBreakdown, diskBreakdownScan = breakdown, nil
+	diskBreakdownMu.Unlock()
+	close(done)
+}
+
+// scanDiskBreakdown scans each root at once and ranks what they hold.
+func scanDiskBreakdown(ctx context.Context, roots []diskRoot, now time.Time) *DiskBreakdown {
+	scans := make([]*rootScan, len(roots))
+	var wg sync.WaitGroup
+	for i, root := range roots {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			scans[i] = scanRoot(ctx, root)
+		}()
+	}
+	wg.Wait()
+
+	breakdown := &DiskBreakdown{Scanned: now}
+	var top []*DiskUsageEntry
+	for _, scan := range scans {
+		if !scan.complete {
+			breakdown.Incomplete = true
+		}
+		if scan.err != "" {
+			breakdown.Errors = append(breakdown.Errors, scan.err)
+		}
+		breakdown.Total += scan.total
+		top = append(top, scan.entries...)
+	}
+
+	byBytes := func(a, b *DiskUsageEntry) int {
+		if c := cmp.Compare(b.Bytes, a.Bytes); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Path, b.Path)
+	}
+	slices.SortFunc(top, byBytes)
+	breakdown.Entries = top[:min(len(top), DiskBreakdownTop)]
+	for _, entry := range breakdown.Entries {
+		slices.SortFunc(entry.Children, byBytes)
+		entry.Children = entry.Children[:min(len(entry.Children), diskBreakdownChildren)]
+		entry.Share = diskShare(entry.Bytes, breakdown.Total)
+		for _, child := range entry.Children {
+			child.Share = diskShare(child.Bytes, breakdown.Total)
+		}
+	}
+	return breakdown
+}
+
+// rootScan is what du reported for one root.
+type rootScan struct {
+	entries  []*DiskUsageEntry // Top-level entries, with theirs as Children
+	total    uint64
+	complete bool // du reported the root itself, which it does last
+	err      string
+}
+
+// scanRoot reads du's output for a root. du reports each directory once
+// everything under it is counted, so a scan stopped early still has exact
+// sizes for the entries it reached. The root's Exclude is reported before
+// the directories holding it, so its size is taken off theirs.
+func scanRoot(ctx context.Context, root diskRoot) *rootScan {
+	scan := &rootScan{}
+	output := diskUsage(ctx, root)
+	defer output.Close()
+
+	prefix := strings.TrimSuffix(root.Path, "/") + "/"
+	exclude := ""
+	if root.Exclude != "" {
+		exclude = path.Clean(root.Exclude)
+	}
+	var excluded uint64
+	childrenOf := map[string][]*DiskUsageEntry{} // By top-level name; du lists them first
+	scanner := bufio.NewScanner(output)
+	for scanner.Scan() {
+		size, entryPath, ok := strings.Cut(scanner.Text(), "\t")
+		kb, err := strconv.ParseUint(strings.TrimSpace(size), 10, 64)
+		if !ok || err != nil {
+			continue // du's warnings, e.g. about unreadable directories
+		}
+		entryPath = path.Clean(entryPath)
+		rel, under := strings.CutPrefix(entryPath, prefix)
+		entry := &DiskUsageEntry{Path: entryPath, InCoder: root.InCoder, Bytes: kb * 1024}
+		if exclude != "" {
+			switch {
+			case entryPath == exclude:
+				excluded = entry.Bytes
+				continue
+			case strings.HasPrefix(entryPath, exclude+"/"):
+				continue
+			case strings.HasPrefix(exclude, entryPath+"/"):
+				entry.Bytes -= min(excluded, entry.Bytes)
+			}
+		}
+		switch {
+		case entryPath == path.Clean(root.Path):
+			scan.total, scan.complete = entry.Bytes, true
+		case !under, strings.Count(rel, "/") >= diskBreakdownDepth:
+			continue // Only reported to reach Exclude
+		case !strings.Contains(rel, "/"):
+			entry.Children = childrenOf[rel]
+			scan.entries = append(scan.entries, entry)
+		default:
+			name, _, _ := strings.Cut(rel, "/")
+			childrenOf[name] = append(childrenOf[name], entry)
+		}
+	}
+
+	if !scan.complete {
+		// Only the entries reached are counted
+		for _, entry := range scan.entries {
+			scan.total += entry.Bytes
+		}
+		switch {
+		case ctx.Err() != nil:
+			scan.err = "scanning " + root.Path + " timed out"
+		case scanner.Err() != nil:
+			scan.err = "scanning " + root.Path + " failed: " + scanner.Err().Error()
+		default:
+			scan.err = "scanning " + root.Path + " failed"
+		}
+	}
+	return scan
+}
+
+func diskShare(part, total uint64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(part) / float64(total) * 100
+}
+
+// localReader runs a program on the workbench's own host and streams its
+// standard output, ending with the program. Cancelling ctx kills it.
+func localReader(ctx context.Context, args ...string) io.ReadCloser {
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	output, err := cmd.StdoutPipe()
+	if err == nil {
+		err = cmd.Start()
+	}
+	if err != nil {
+		return io.NopCloser(strings.NewReader(""))
+	}
+	return &commandReader{ReadCloser: output, cmd: cmd}
+}
+
+// commandReader waits for its command when closed.
+type commandReader struct {
+	io.ReadCloser
+	cmd *exec.Cmd
+}
+
+func (r *commandReader) Close() error {
+	r.ReadCloser.Close()
+	return r.cmd.Wait()
+}
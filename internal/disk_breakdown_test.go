@@ -0,0 +1,135 @@
+package internal
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/The-Skyscape/devtools/pkg/testutils"
+)
+
+// fakeDiskUsage replaces du with output per root path. A root without
+// output blocks until the scan is cancelled.
+func fakeDiskUsage(t *testing.T, outputs map[string]string) {
+	original := diskUsage
+	t.Cleanup(func() { diskUsage = original })
+	diskUsage = func(ctx context.Context, root diskRoot) io.ReadCloser {
+		if output, ok := outputs[root.Path]; ok {
+			return io.NopCloser(strings.NewReader(output))
+		}
+		reader, writer := io.Pipe()
+		go func() {
+			writer.Write([]byte("2048\t/slow/big\n"))
+			<-ctx.Done()
+			writer.CloseWithError(ctx.Err())
+		}()
+		return reader
+	}
+}
+
+func TestScanDiskBreakdown(t *testing.T) {
+	fakeDiskUsage(t, map[string]string{
+		"/data": "du: cannot read directory '/data/lost+found': Permission denied\n" +
+			"100\t/data/app.db\n" +
+			"20\t/data/backups/a.tar\n" +
+			"50\t/data/backups/b.tar\n" +
+			"70\t/data/backups\n" +
+			"180\t/data\n",
+		"/home/coder": "300\t/home/coder/repos/api\n" +
+			"400\t/home/coder/repos\n" +
+			"120\t/home/coder/.local\n" +
+			"520\t/home/coder\n",
+	})
+
+	breakdown := scanDiskBreakdown(context.Background(), []diskRoot{{Path: "/data"}, {Path: "/home/coder", InCoder: true}}, time.Now())
+	testutils.AssertEqual(t, false, breakdown.Incomplete)
+	testutils.AssertEqual(t, uint64(700*1024), breakdown.Total)
+	testutils.AssertEqual(t, 4, len(breakdown.Entries))
+
+	repos := breakdown.Entries[0]
+	testutils.AssertEqual(t, "/home/coder/repos", repos.Path)
+	testutils.AssertEqual(t, true, repos.InCoder)
+	testutils.AssertEqual(t, "/home/coder/repos/api", repos.Children[0].Path)
+	testutils.AssertEqual(t, "/home/coder/.local", breakdown.Entries[1].Path)
+	testutils.AssertEqual(t, "/data/app.db", breakdown.Entries[2].Path)
+
+	backups := breakdown.Entries[3]
+	testutils.AssertEqual(t, 10.0, backups.Share)
+	testutils.AssertEqual(t, 2, len(backups.Children))
+	testutils.AssertEqual(t, "/data/backups/b.tar", backups.Children[0].Path)
+}
+
+func TestScanDiskBreakdownTimesOut(t *testing.T) {
+	fakeDiskUsage(t, map[string]string{"/data": "100\t/data/app.db\n100\t/data\n"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	breakdown := scanDiskBreakdown(ctx, []diskRoot{{Path: "/data"}, {Path: "/slow"}}, time.Now())
+	testutils.AssertEqual(t, true, breakdown.Incomplete)
+	testutils.AssertEqual(t, 1, len(breakdown.Errors))
+	testutils.AssertEqual(t, "scanning /slow timed out", breakdown.Errors[0])
+
+	// What was read before the timeout is still listed
+	testutils.AssertEqual(t, 2, len(breakdown.Entries))
+	testutils.AssertEqual(t, "/slow/big", breakdown.Entries[0].Path)
+	testutils.AssertEqual(t, uint64(2148*1024), breakdown.Total)
+}
+
+func TestScanDiskBreakdownExcludesCoderHome(t *testing.T) {
+	fakeDiskUsage(t, map[string]string{
+		"/mnt/data": "100\t/mnt/data/app.db\n" +
+			"400\t/mnt/data/services/workbench-coder/repos\n" +
+			"500\t/mnt/data/services/workbench-coder\n" +
+			"20\t/mnt/data/services/workbench/logs/app.log\n" +
+			"30\t/mnt/data/services/workbench/logs\n" +
+			"40\t/mnt/data/services/workbench\n" +
+			"540\t/mnt/data/services\n" +
+			"640\t/mnt/data\n",
+	})
+
+	root := diskRoot{Path: "/mnt/data", Exclude: "/mnt/data/services/workbench-coder/"}
+	testutils.AssertEqual(t, 2, root.depth())
+	breakdown := scanDiskBreakdown(context.Background(), []diskRoot{root}, time.Now())
+	testutils.AssertEqual(t, uint64(140*1024), breakdown.Total)
+	testutils.AssertEqual(t, 2, len(breakdown.Entries))
+	testutils.AssertEqual(t, "/mnt/data/app.db", breakdown.Entries[0].Path)
+
+	dirs := breakdown.Entries[1]
+	testutils.AssertEqual(t, uint64(40*1024), dirs.Bytes)
+	testutils.AssertEqual(t, 1, len(dirs.Children)) // Nor the levels below diskBreakdownDepth
+	testutils.AssertEqual(t, "/mnt/data/services/workbench", dirs.Children[0].Path)
+
+	// Deep enough to reach a directory further down
+	testutils.AssertEqual(t, 3, diskRoot{Path: "/mnt", Exclude: "/mnt/data/services/workbench-coder"}.depth())
+}
+
+func TestGetDataDirBreakdownServesExpiredScanWhileRescanning(t *testing.T) {
+	roots, usage := diskRoots, diskUsage
+	t.Cleanup(func() {
+		diskRoots, diskUsage = roots, usage
+		diskBreakdown, diskBreakdownScan = nil, nil
+	})
+
+	release := make(chan struct{})
+	diskRoots = func() []diskRoot { return []diskRoot{{Path: "/data"}} }
+	diskUsage = func(ctx context.Context, root diskRoot) io.ReadCloser {
+		<-release
+		return io.NopCloser(strings.NewReader("100\t/data/app.db\n100\t/data\n"))
+	}
+	expired := &DiskBreakdown{Total: 1, Scanned: time.Now().Add(-diskBreakdownTTL - time.Minute)}
+	diskBreakdown = expired
+
+	// Answered from the expired scan without waiting for the new one
+	testutils.AssertEqual(t, uint64(1), GetDataDirBreakdown().Total)
+	diskBreakdownMu.Lock()
+	scan := diskBreakdownScan
+	diskBreakdownMu.Unlock()
+	testutils.AssertEqual(t, true, scan != nil)
+	testutils.AssertEqual(t, uint64(1), GetDataDirBreakdown().Total) // One scan at a time
+
+	close(release)
+	<-scan
+	testutils.AssertEqual(t, uint64(100*1024), GetDataDirBreakdown().Total)
+}
@@ -0,0 +1,246 @@
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+	"unicode/utf8"
+	"workbench/models"
+	"workbench/services"
+)
+
+// MaxEditableFileSize is the largest file that can be edited inline.
+const MaxEditableFileSize = 256 * 1024
+
+// EditableFile is a text file loaded for inline editing.
+type EditableFile struct {
+	Repository string
+	Path       string
+	Content    string
+	BlobHash   string // Blob hash of the file at HEAD, used to detect concurrent changes
+}
+
+// EditConflictError is returned when the file changed at HEAD since it was
+// loaded for editing. Diff shows what changed between the two versions.
+type EditConflictError struct {
+	Diff string
+}
+
+func (e *EditConflictError) Error() string {
+	return "the file was changed since you opened it - review the changes and try again"
+}
+
+// cleanRepoPath validates a repository-relative file path. Paths inside
+// the .git directory (in any letter case, for case-insensitive
+// filesystems) are refused so hooks and config can't be written.
+func cleanRepoPath(p string) (string, error) {
+	p = path.Clean(strings.TrimSpace(p))
+	if p == "." || p == "" || path.IsAbs(p) || p == ".." || strings.HasPrefix(p, "../") {
+		return "", fmt.Errorf("invalid file path")
+	}
+	for _, part := range strings.Split(p, "/") {
+		if strings.EqualFold(part, ".git") {
+			return "", fmt.Errorf("files inside .git can't be edited")
+		}
+	}
+	return p, nil
+}
+
+// ReadFileForEdit loads a text file for inline editing along with its blob
+// hash at HEAD. Binary files, files over MaxEditableFileSize, and files
+// with uncommitted changes are refused.
+func ReadFileForEdit(repoName, filePath string) (*EditableFile, error) {
+	repo, err := models.Repositories.Find("WHERE Name = ?", repoName)
+	if err != nil {
+		return nil, fmt.Errorf("repository '%s' not found", repoName)
+	}
+
+	filePath, err = cleanRepoPath(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkFileClean(repo, filePath); err != nil {
+		return nil, err
+	}
+
+	hash, err := headBlobHash(repo, filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	sizeCmd := fmt.Sprintf("cd %s && git cat-file -s %s", shellQuote(repo.LocalPath), shellQuote(hash))
+	sizeOutput, err := services.CoderExec(sizeCmd)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file size")
+	}
+	var size int
+	fmt.Sscanf(strings.TrimSpace(sizeOutput), "%d", &size)
+	if size > MaxEditableFileSize {
+		return nil, fmt.Errorf("file is too large to edit inline (%d KB limit) - open it in VS Code", MaxEditableFileSize/1024)
+	}
+
+	result, err := services.CoderExecWithOptions(fmt.Sprintf("cd %s && git cat-file blob %s", shellQuote(repo.LocalPath), shellQuote(hash)),
+		services.ExecOptions{MaxOutput: MaxEditableFileSize})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file")
+	}
+	if isBinary(result.Output) {
+		return nil, fmt.Errorf("binary files can't be edited inline - open it in VS Code")
+	}
+
+	return &EditableFile{Repository: repoName, Path: filePath, Content: result.Output, BlobHash: hash}, nil
+}
+
+// CommitFileEdit writes new content for a single file, commits it with the
+// repository's effective git identity, and optionally pushes.
+// Parameters:
+//   - repoName: The repository containing the file
+//   - filePath: Repository-relative path of the file
+//   - content: New file content
+//   - baseHash: Blob hash the edit was based on (from ReadFileForEdit)
+//   - message: Commit message
+//   - push: Push the commit to the upstream branch
+//
+// Returns *EditConflictError if the file changed at HEAD since it was loaded.
+func CommitFileEdit(repoName, filePath, content, baseHash, message string, push bool) error {
+	repo, err := models.Repositories.Find("WHERE Name = ?", repoName)
+	if err != nil {
+		return fmt.Errorf("repository '%s' not found", repoName)
+	}
+
+	filePath, err = cleanRepoPath(filePath)
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(message) == "" {
+		return fmt.Errorf("commit message is required")
+	}
+	if len(content) > MaxEditableFileSize {
+		return fmt.Errorf("file is too large to edit inline")
+	}
+	if isBinary(content) {
+		return fmt.Errorf("binary content can't be committed inline")
+	}
+
+	if err := RequireGitIdentity(repo); err != nil {
+		return err
+	}
+	if err := checkFileClean(repo, filePath); err != nil {
+		return err
+	}
+
+	// Detect concurrent modification against HEAD
+	current, err := headBlobHash(repo, filePath)
+	if err != nil {
+		return err
+	}
+	if current != baseHash {
+		diff, _ := services.CoderExec(fmt.Sprintf("cd %s && git diff %s %s 2>&1", shellQuote(repo.LocalPath), shellQuote(baseHash), shellQuote(current)))
+		return &EditConflictError{Diff: diff}
+	}
+
+	// Keep the file's line endings so the commit doesn't rewrite every line
+	original, err := services.CoderExecWithOptions(fmt.Sprintf("cd %s && git cat-file blob %s", shellQuote(repo.LocalPath), shellQuote(current)),
+		services.ExecOptions{MaxOutput: MaxEditableFileSize})
+	if err != nil {
+		return fmt.Errorf("failed to read file")
+	}
+	content = matchLineEndings(content, strings.Contains(original.Output, "\r\n"))
+
+	// Write the file via stdin so content never touches the command line
+	writeCmd := fmt.Sprintf("cd %s && cat > %s", shellQuote(repo.LocalPath), shellQuote(filePath))
+	if _, err := services.CoderExecWithOptions(writeCmd, services.ExecOptions{Stdin: strings.NewReader(content)}); err != nil {
+		return fmt.Errorf("failed to write file")
+	}
+
+	commitCmd := fmt.Sprintf("cd %s && git add -- %s && git commit -F - -- %s 2>&1", shellQuote(repo.LocalPath), shellQuote(filePath), shellQuote(filePath))
+	result, err := services.CoderExecWithOptions(commitCmd, services.ExecOptions{Stdin: strings.NewReader(message)})
+	if err != nil {
+		if strings.Contains(result.Output, "nothing to commit") || strings.Contains(result.Output, "no changes added") {
+			return fmt.Errorf("no changes to commit")
+		}
+		if strings.Contains(result.Output, "Please tell me who you are") {
+			return ErrGitIdentityMissing
+		}
+		return fmt.Errorf("failed to commit changes")
+	}
+
+	description := fmt.Sprintf("Committed edit to %s in %s", filePath, repoName)
+	if push {
+		output, err := operationExec(fmt.Sprintf("cd %s && git push 2>&1", shellQuote(repo.LocalPath)))
+		if err != nil {
+			return fmt.Errorf("committed locally but push failed: %w", classifyRemoteError(output, err, "remote operation failed"))
+		}
+		description = fmt.Sprintf("Committed and pushed edit to %s in %s", filePath, repoName)
+	}
+
+	go models.Activities.Insert(&models.Activity{
+		Type:        "repo_edit",
+		Repository:  repoName,
+		Description: description,
+		Author:      "System",
+		Timestamp:   time.Now(),
+		Metadata:    fmt.Sprintf(`{"path":%q}`, filePath),
+	})
+
+	return nil
+}
+
+// checkFileClean refuses edits to files with uncommitted changes.
+func checkFileClean(repo *models.Repository, filePath string) error {
+	output, err := services.CoderExec(fmt.Sprintf("cd %s && git status --porcelain -- %s", shellQuote(repo.LocalPath), shellQuote(filePath)))
+	if err != nil {
+		return fmt.Errorf("failed to check file status")
+	}
+	if strings.TrimSpace(output) != "" {
+		return fmt.Errorf("%s has uncommitted changes - commit or discard them in VS Code first", filePath)
+	}
+	return nil
+}
+
+// headBlobHash returns the blob hash of a regular file at HEAD. Symlinks
+// and submodules are refused: writing through a symlink would change its
+// target instead of the link.
+func headBlobHash(repo *models.Repository, filePath string) (string, error) {
+	output, err := services.CoderExec(fmt.Sprintf("cd %s && git ls-tree HEAD -- %s", shellQuote(repo.LocalPath), shellQuote(filePath)))
+	mode, hash, ok := parseLsTree(output)
+	if err != nil || !ok {
+		return "", fmt.Errorf("%s is not tracked at HEAD", filePath)
+	}
+	switch mode {
+	case "120000":
+		return "", fmt.Errorf("%s is a symlink - edit its target instead", filePath)
+	case "160000", "040000":
+		return "", fmt.Errorf("%s is not a file", filePath)
+	}
+	return hash, nil
+}
+
+// parseLsTree reads the mode and object hash from a single
+// `git ls-tree` entry: "<mode> <type> <hash>\t<path>".
+func parseLsTree(output string) (mode, hash string, ok bool) {
+	meta, _, found := strings.Cut(strings.TrimSpace(output), "\t")
+	fields := strings.Fields(meta)
+	if !found || len(fields) != 3 {
+		return "", "", false
+	}
+	return fields[0], fields[2], true
+}
+
+// matchLineEndings converts content, as submitted by a browser (which
+// always sends CRLF), to the line endings of the original file.
+func matchLineEndings(content string, crlf bool) string {
+	content = strings.ReplaceAll(content, "\r\n", "\n")
+	if crlf {
+		content = strings.ReplaceAll(content, "\n", "\r\n")
+	}
+	return content
+}
+
+// isBinary uses Git's heuristic: content with NUL bytes or invalid UTF-8 is binary.
+func isBinary(content string) bool {
+	return bytes.IndexByte([]byte(content), 0) >= 0 || !utf8.ValidString(content)
+}
@@ -0,0 +1,69 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/The-Skyscape/devtools/pkg/testutils"
+)
+
+func TestCleanRepoPath(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected string
+		valid    bool
+	}{
+		{"README.md", "README.md", true},
+		{"docs/../README.md", "README.md", true},
+		{"./src/main.go", "src/main.go", true},
+		{"../secret", "", false},
+		{"/etc/passwd", "", false},
+		{"", "", false},
+		{".", "", false},
+		{".git/hooks/pre-commit", "", false},
+		{"vendor/lib/.git/config", "", false},
+		{".GIT/config", "", false},
+		{"docs/../.git/config", "", false},
+		{".github/workflows/ci.yml", ".github/workflows/ci.yml", true},
+		{".gitignore", ".gitignore", true},
+	}
+
+	for _, tc := range testCases {
+		result, err := cleanRepoPath(tc.input)
+		testutils.AssertEqual(t, tc.valid, err == nil)
+		testutils.AssertEqual(t, tc.expected, result)
+	}
+}
+
+func TestIsBinary(t *testing.T) {
+	testutils.AssertEqual(t, false, isBinary("# Title\n\nSome text\n"))
+	testutils.AssertEqual(t, false, isBinary("héllo wörld"))
+	testutils.AssertEqual(t, true, isBinary("PNG\x00\x01"))
+	testutils.AssertEqual(t, true, isBinary("\xff\xfe"))
+}
+
+func TestParseLsTree(t *testing.T) {
+	testCases := []struct {
+		output string
+		mode   string
+		hash   string
+		ok     bool
+	}{
+		{"100644 blob 3b18e512dba79e4c8300dd08aeb37f8e728b8dad\tREADME.md\n", "100644", "3b18e512dba79e4c8300dd08aeb37f8e728b8dad", true},
+		{"120000 blob a1b2c3\tlink\n", "120000", "a1b2c3", true},
+		{"", "", "", false},
+	}
+
+	for _, tc := range testCases {
+		mode, hash, ok := parseLsTree(tc.output)
+		testutils.AssertEqual(t, tc.ok, ok)
+		testutils.AssertEqual(t, tc.mode, mode)
+		testutils.AssertEqual(t, tc.hash, hash)
+	}
+}
+
+func TestMatchLineEndings(t *testing.T) {
+	testutils.AssertEqual(t, "a\nb\n", matchLineEndings("a\r\nb\r\n", false))
+	testutils.AssertEqual(t, "a\r\nb\r\n", matchLineEndings("a\r\nb\r\n", true))
+	testutils.AssertEqual(t, "a\r\nb\r\n", matchLineEndings("a\nb\n", true))
+	testutils.AssertEqual(t, "a\nb", matchLineEndings("a\nb", false))
+}
@@ -0,0 +1,124 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"path"
+	"strings"
+	"time"
+	"workbench/models"
+	"workbench/services"
+)
+
+// Limits on one-off commands run through the exec API.
+const (
+	DefaultExecTimeout = time.Minute
+	MaxExecTimeout     = 30 * time.Minute
+
+	// maxExecActivityCommand caps how much of a command the activity log
+	// keeps.
+	maxExecActivityCommand = 200
+)
+
+// ExecRequest is a one-off command to run in the coder container.
+type ExecRequest struct {
+	Command string
+	Workdir string        // Relative to DefaultReposDir unless absolute; empty for DefaultReposDir
+	Timeout time.Duration // 0 for DefaultExecTimeout
+}
+
+// ExecOutcome describes how a command finished.
+type ExecOutcome struct {
+	ExitCode int // -1 when the command was killed or didn't report one
+	TimedOut bool
+	Duration time.Duration
+}
+
+var (
+	// execReader starts a command and streams its output. Replaced in tests.
+	execReader = services.CoderExecReader
+
+	// execCoderRunning reports whether commands can run. Replaced in tests.
+	execCoderRunning = func() bool { return services.Coder.IsRunning() }
+)
+
+// RunExec runs a command in the coder container, copying its combined
+// output to out as it is produced, and records a coder_exec activity with
+// the exit code. The command is killed when the timeout passes or ctx is
+// cancelled. An error means the request was refused and nothing ran.
+func RunExec(ctx context.Context, req ExecRequest, out io.Writer) (*ExecOutcome, error) {
+	command := strings.TrimSpace(req.Command)
+	if command == "" {
+		return nil, fmt.Errorf("command is required")
+	}
+	timeout := req.Timeout
+	switch {
+	case timeout == 0:
+		timeout = DefaultExecTimeout
+	case timeout < 0:
+		return nil, fmt.Errorf("timeout must be positive")
+	case timeout > MaxExecTimeout:
+		return nil, fmt.Errorf("timeout can be at most %s", MaxExecTimeout)
+	}
+	dir := execDir(req.Workdir)
+	if !execCoderRunning() {
+		return nil, ErrCoderNotRunning
+	}
+	// Counts as use, and holds off an idle pause while it runs
+	defer services.TouchCoder()
+	defer holdCoder()()
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	started := time.Now()
+	output := execReader(ctx, fmt.Sprintf("cd %s && %s", shellQuote(dir), command))
+	_, err := io.Copy(out, output)
+	output.Close()
+
+	outcome := &ExecOutcome{ExitCode: -1, Duration: time.Since(started)}
+	var exitErr *exec.ExitError
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		outcome.TimedOut = true
+	case err == nil:
+		outcome.ExitCode = 0
+	case errors.As(err, &exitErr):
+		outcome.ExitCode = exitErr.ExitCode()
+	}
+
+	go models.Activities.Insert(&models.Activity{
+		Type:        "coder_exec",
+		Description: execDescription(command, timeout, outcome),
+		Author:      "System",
+		Timestamp:   time.Now(),
+	})
+	return outcome, nil
+}
+
+// execDir resolves a working directory, relative to DefaultReposDir
+// unless absolute.
+func execDir(workdir string) string {
+	workdir = strings.TrimSpace(workdir)
+	if path.IsAbs(workdir) {
+		return path.Clean(workdir)
+	}
+	return path.Join(DefaultReposDir, workdir)
+}
+
+// execDescription summarizes a finished command for the activity log.
+func execDescription(command string, timeout time.Duration, outcome *ExecOutcome) string {
+	if len(command) > maxExecActivityCommand {
+		command = command[:maxExecActivityCommand] + "..."
+	}
+	switch {
+	case outcome.TimedOut:
+		return fmt.Sprintf("Ran `%s` - killed after the %s timeout", command, timeout)
+	case outcome.ExitCode < 0:
+		return fmt.Sprintf("Ran `%s` - stopped before it finished", command)
+	default:
+		return fmt.Sprintf("Ran `%s` - exit code %d", command, outcome.ExitCode)
+	}
+}
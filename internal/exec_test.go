@@ -0,0 +1,117 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/The-Skyscape/devtools/pkg/testutils"
+)
+
+// fakeExec replaces the coder container for RunExec. run writes output and
+// returns the command's error; the command it was given is returned.
+func fakeExec(t *testing.T, run func(ctx context.Context, w io.Writer) error) *string {
+	var command string
+	originalReader, originalRunning := execReader, execCoderRunning
+	execReader = func(ctx context.Context, cmd string) io.ReadCloser {
+		command = cmd
+		reader, writer := io.Pipe()
+		go func() { writer.CloseWithError(run(ctx, writer)) }()
+		return reader
+	}
+	execCoderRunning = func() bool { return true }
+	t.Cleanup(func() { execReader, execCoderRunning = originalReader, originalRunning })
+	return &command
+}
+
+func TestRunExecStreamsOutputAndExitCode(t *testing.T) {
+	exitErr := exec.Command("sh", "-c", "exit 3").Run()
+	command := fakeExec(t, func(ctx context.Context, w io.Writer) error {
+		io.WriteString(w, "--- FAIL: TestLogin\n")
+		return exitErr
+	})
+
+	var out bytes.Buffer
+	outcome, err := RunExec(context.Background(), ExecRequest{Command: "go test ./...", Workdir: "api"}, &out)
+	testutils.AssertEqual(t, nil, err)
+	testutils.AssertEqual(t, 3, outcome.ExitCode)
+	testutils.AssertEqual(t, false, outcome.TimedOut)
+	testutils.AssertEqual(t, "--- FAIL: TestLogin\n", out.String())
+	testutils.AssertEqual(t, "cd '/home/coder/repos/api' && go test ./...", *command)
+}
+
+func TestRunExecSuccess(t *testing.T) {
+	fakeExec(t, func(ctx context.Context, w io.Writer) error { return nil })
+
+	outcome, err := RunExec(context.Background(), ExecRequest{Command: "true"}, io.Discard)
+	testutils.AssertEqual(t, nil, err)
+	testutils.AssertEqual(t, 0, outcome.ExitCode)
+}
+
+func TestRunExecKillsOnTimeout(t *testing.T) {
+	fakeExec(t, func(ctx context.Context, w io.Writer) error {
+		<-ctx.Done()
+		return errors.New("signal: killed")
+	})
+
+	outcome, err := RunExec(context.Background(), ExecRequest{Command: "sleep 600", Timeout: 10 * time.Millisecond}, io.Discard)
+	testutils.AssertEqual(t, nil, err)
+	testutils.AssertEqual(t, true, outcome.TimedOut)
+	testutils.AssertEqual(t, -1, outcome.ExitCode)
+}
+
+func TestRunExecRefusesBadRequests(t *testing.T) {
+	fakeExec(t, func(ctx context.Context, w io.Writer) error {
+		t.Fatal("a refused request ran")
+		return nil
+	})
+
+	testCases := []struct {
+		name string
+		req  ExecRequest
+	}{
+		{"empty command", ExecRequest{Command: "  "}},
+		{"negative timeout", ExecRequest{Command: "ls", Timeout: -time.Second}},
+		{"timeout over the maximum", ExecRequest{Command: "ls", Timeout: MaxExecTimeout + time.Second}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := RunExec(context.Background(), tc.req, io.Discard)
+			testutils.AssertEqual(t, true, err != nil)
+		})
+	}
+
+	execCoderRunning = func() bool { return false }
+	_, err := RunExec(context.Background(), ExecRequest{Command: "ls"}, io.Discard)
+	testutils.AssertEqual(t, ErrCoderNotRunning, err)
+}
+
+func TestExecDir(t *testing.T) {
+	testCases := []struct {
+		workdir  string
+		expected string
+	}{
+		{"", "/home/coder/repos"},
+		{"api", "/home/coder/repos/api"},
+		{"api/cmd/", "/home/coder/repos/api/cmd"},
+		{"/tmp/../home/coder", "/home/coder"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.workdir, func(t *testing.T) {
+			testutils.AssertEqual(t, tc.expected, execDir(tc.workdir))
+		})
+	}
+}
+
+func TestExecDescriptionTruncatesCommand(t *testing.T) {
+	description := execDescription(strings.Repeat("x", 500), time.Minute, &ExecOutcome{ExitCode: 1})
+	testutils.AssertEqual(t, true, strings.HasSuffix(description, "...` - exit code 1"))
+	testutils.AssertEqual(t, true, len(description) < 250)
+}
@@ -0,0 +1,186 @@
+package internal
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"workbench/models"
+)
+
+// WriteCSV writes rows (including any header row) as CSV.
+func WriteCSV(w io.Writer, rows [][]string) error {
+	writer := csv.NewWriter(w)
+	if err := writer.WriteAll(rows); err != nil {
+		return err
+	}
+	return writer.Error()
+}
+
+// exportEncoder writes the rows of an export as CSV or as one JSON
+// object a line.
+type exportEncoder struct {
+	csv  *csv.Writer
+	json *json.Encoder
+}
+
+// newExportEncoder starts an export in the query's format, writing the
+// byte order mark and header row of a CSV export.
+func newExportEncoder(w io.Writer, q ExportQuery, columns []string) (*exportEncoder, error) {
+	switch q.Format {
+	case ExportNDJSON, ExportJSON, "":
+		return &exportEncoder{json: json.NewEncoder(w)}, nil
+	case ExportCSV:
+		if q.BOM {
+			if _, err := io.WriteString(w, "\ufeff"); err != nil {
+				return nil, err
+			}
+		}
+		writer := csv.NewWriter(w)
+		if err := writer.Write(columns); err != nil {
+			return nil, err
+		}
+		return &exportEncoder{csv: writer}, nil
+	}
+	return nil, fmt.Errorf("unknown export format %q - use csv or json", q.Format)
+}
+
+// encode writes one row: its fields to a CSV export, or v to a JSON one.
+func (e *exportEncoder) encode(fields []string, v any) error {
+	if e.csv != nil {
+		return e.csv.Write(fields)
+	}
+	return e.json.Encode(v)
+}
+
+// flush sends buffered CSV rows on to the writer.
+func (e *exportEncoder) flush() error {
+	if e.csv == nil {
+		return nil
+	}
+	e.csv.Flush()
+	return e.csv.Error()
+}
+
+// exportBatches encodes rows read a batch at a time with next, which is
+// given the creation time and ID of the last row of the previous batch,
+// and returns how many rows were written. Each batch is flushed before
+// the next is read.
+func exportBatches[T any](e *exportEncoder, next func(time.Time, string) ([]T, error),
+	key func(T) (time.Time, string), row func(T) ([]string, any)) (int, error) {
+	var after time.Time
+	var afterID string
+	written := 0
+	for {
+		batch, err := next(after, afterID)
+		if err != nil {
+			return written, fmt.Errorf("failed to read export rows: %w", err)
+		}
+		for _, item := range batch {
+			if err := e.encode(row(item)); err != nil {
+				return written, err
+			}
+			written++
+		}
+		if err := e.flush(); err != nil {
+			return written, err
+		}
+		if len(batch) < exportBatch {
+			return written, nil
+		}
+		after, afterID = key(batch[len(batch)-1])
+	}
+}
+
+// afterClause adds the keyset condition of a batched export to a WHERE
+// clause from a filter; its arguments follow the filter's.
+func afterClause(where string) string {
+	after := "(CreatedAt > ? OR (CreatedAt = ? AND ID > ?))"
+	if where == "" {
+		return "WHERE " + after
+	}
+	return where + " AND " + after
+}
+
+// reposAfter reads the next batch of repositories created in [from, to)
+// in creation order, after the given repository. Replaced in tests.
+var reposAfter = func(from, to time.Time, createdAt time.Time, id string, limit int) ([]*models.Repository, error) {
+	var conditions []string
+	var args []any
+	if !from.IsZero() {
+		conditions, args = append(conditions, "CreatedAt >= ?"), append(args, from)
+	}
+	if !to.IsZero() {
+		conditions, args = append(conditions, "CreatedAt < ?"), append(args, to)
+	}
+	where := ""
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+	return models.Repositories.Search(afterClause(where)+" ORDER BY CreatedAt ASC, ID ASC LIMIT ?",
+		append(args, createdAt, createdAt, id, limit)...)
+}
+
+// exportedRepo is one line of a JSON repository export. Webhook secrets
+// and credentials in URLs are left out.
+type exportedRepo struct {
+	Name        string    `json:"name"`
+	URL         string    `json:"url"`
+	Host        string    `json:"host"`
+	Description string    `json:"description"`
+	Private     bool      `json:"private"`
+	LocalPath   string    `json:"local_path"`
+	Storage     string    `json:"storage,omitempty"`
+	Group       string    `json:"group,omitempty"`
+	Pinned      bool      `json:"pinned"`
+	CloneStatus string    `json:"clone_status,omitempty"`
+	Missing     bool      `json:"missing"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// repoExportColumns is the header row of a CSV repository export.
+var repoExportColumns = []string{"name", "url", "host", "description", "private", "local_path", "storage", "group", "pinned", "clone_status", "missing", "created_at"}
+
+// ExportRepositories writes the repositories created in the query's date
+// range to w, oldest first, as newline-delimited JSON or CSV, and returns
+// how many it wrote.
+func ExportRepositories(w io.Writer, q ExportQuery) (int, error) {
+	encoder, err := newExportEncoder(w, q, repoExportColumns)
+	if err != nil {
+		return 0, err
+	}
+	return exportBatches(encoder,
+		func(after time.Time, afterID string) ([]*models.Repository, error) {
+			return reposAfter(q.From, q.To, after, afterID, exportBatch)
+		},
+		func(r *models.Repository) (time.Time, string) { return r.CreatedAt, r.ID },
+		func(r *models.Repository) ([]string, any) {
+			repo := exportedRepo{r.Name, maskURLCredentials(r.URL), r.Host, r.Description, r.IsPrivate, r.LocalPath,
+				r.StorageLocationID, r.GroupName, r.Pinned, r.CloneStatus, r.Missing, r.CreatedAt}
+			return []string{repo.Name, repo.URL, repo.Host, repo.Description, strconv.FormatBool(repo.Private), repo.LocalPath,
+					repo.Storage, repo.Group, strconv.FormatBool(repo.Pinned), repo.CloneStatus, strconv.FormatBool(repo.Missing),
+					repo.CreatedAt.UTC().Format(time.RFC3339)},
+				repo
+		})
+}
+
+// recordExport is replaced in tests.
+var recordExport = func(activity *models.Activity) { go models.Activities.Insert(activity) }
+
+// RecordExport records a download of what ("activities" or
+// "repositories") as an export activity with its row count and who
+// downloaded it.
+func RecordExport(what, format string, rows int, by string) {
+	if by == "" {
+		by = "unknown user"
+	}
+	recordExport(&models.Activity{
+		Type:        "export",
+		Description: fmt.Sprintf("%s exported %d %s as %s", by, rows, what, strings.ToUpper(format)),
+		Author:      by,
+		Timestamp:   time.Now(),
+	})
+}
@@ -0,0 +1,258 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+	"workbench/models"
+	"workbench/services"
+)
+
+// DefaultOperationTimeout bounds long-running Git network operations
+// when the operation_timeout setting (in seconds) is not configured.
+const DefaultOperationTimeout = 10 * time.Minute
+
+// RefPushResult is the outcome of pushing a single ref during an export.
+type RefPushResult struct {
+	Ref     string // Remote ref name, e.g. refs/heads/main
+	Status  string // pushed, up-to-date, rejected, deleted
+	Summary string // Git's summary, e.g. "[new branch]" or the rejection reason
+}
+
+// Failed reports whether the ref was rejected by the destination.
+func (r *RefPushResult) Failed() bool {
+	return r.Status == "rejected"
+}
+
+// ExportResult describes a completed repository export.
+type ExportResult struct {
+	Destination   string
+	Refs          []*RefPushResult
+	OriginUpdated bool
+}
+
+// Failures returns the refs the destination rejected.
+func (r *ExportResult) Failures() []*RefPushResult {
+	var failed []*RefPushResult
+	for _, ref := range r.Refs {
+		if ref.Failed() {
+			failed = append(failed, ref)
+		}
+	}
+	return failed
+}
+
+// ErrOperationTimedOut is returned when a Git network operation exceeds the
+// configured operation timeout. Mirror pushes are idempotent and safe to retry.
+var ErrOperationTimedOut = &AppError{Code: CodeTimedOut, UserMessage: "operation timed out - it is safe to retry", Retryable: true}
+
+// ErrOperationCancelled is returned when the request that started a Git
+// operation went away, e.g. the browser tab was closed, and it was stopped.
+var ErrOperationCancelled = &AppError{Code: CodeTimedOut, UserMessage: "operation cancelled - it is safe to retry", Retryable: true}
+
+// remoteURLPattern accepts HTTPS, HTTP, SSH, git, and scp-style SSH URLs
+// made of characters that are safe to pass to the shell when quoted.
+var remoteURLPattern = regexp.MustCompile(`^((https?|ssh|git)://[A-Za-z0-9._~:@%+/-]+|[A-Za-z0-9._-]+@[A-Za-z0-9.-]+:[A-Za-z0-9._~/+-]+)$`)
+
+// ValidateRemoteURL checks that a Git remote URL is well formed.
+// Used for clone sources and export destinations.
+func ValidateRemoteURL(remoteURL string) error {
+	if !remoteURLPattern.MatchString(remoteURL) {
+		return fmt.Errorf("invalid repository URL - use an HTTPS or SSH Git URL")
+	}
+	return nil
+}
+
+// RemoteHost returns the host of a remote URL without any credentials,
+// suitable for logging, e.g. "github.com".
+func RemoteHost(remoteURL string) string {
+	if u, err := url.Parse(remoteURL); err == nil && u.Host != "" {
+		return u.Hostname()
+	}
+	if at := strings.Index(remoteURL, "@"); at >= 0 {
+		host, _, _ := strings.Cut(remoteURL[at+1:], ":")
+		return host
+	}
+	return ""
+}
+
+// OperationTimeout returns the configured timeout for Git network operations.
+func OperationTimeout() time.Duration {
+	return models.GetDurationSetting("operation_timeout")
+}
+
+// operationExec runs a command that may outlast services.CoderExec's
+// default timeout, such as a copy, an archive, or a git network
+// operation, bounded by OperationTimeout instead.
+func operationExec(command string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), OperationTimeout())
+	defer cancel()
+	result, err := services.CoderExecCtx(ctx, command)
+	return result.Output, err
+}
+
+// operationExecArgs is operationExec for a program and its arguments, run
+// without a shell.
+func operationExecArgs(args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), OperationTimeout())
+	defer cancel()
+	result, err := services.CoderExecArgsCtx(ctx, args...)
+	return result.Output, err
+}
+
+// ExportRepository pushes a repository wholesale to a new, empty remote.
+// Parameters:
+//   - repoName: The repository to export
+//   - destination: The destination remote URL (must have no refs)
+//   - updateOrigin: Point origin at the destination after a successful push
+//
+// The function:
+// 1. Validates the destination URL
+// 2. Verifies the destination is empty (git ls-remote returns no refs)
+// 3. Runs git push --mirror, bounded by the operation timeout
+// 4. Optionally updates origin and the stored repository URL
+// 5. Logs a repo_export activity with the destination host only
+//
+// A failed push, or one with rejected refs, is logged as repo_export_failed.
+//
+// Returns per-ref results; rejected refs are itemized rather than failing
+// the whole export.
+func ExportRepository(repoName, destination string, updateOrigin bool) (*ExportResult, error) {
+	if err := ValidateRemoteURL(destination); err != nil {
+		return nil, err
+	}
+
+	repo, err := models.Repositories.Find("WHERE Name = ?", repoName)
+	if err != nil {
+		return nil, fmt.Errorf("repository '%s' not found", repoName)
+	}
+
+	timeout := int(OperationTimeout().Seconds())
+
+	// Verify the destination exists and is empty
+	cmd := fmt.Sprintf("cd %s && timeout %d git ls-remote %s 2>&1", shellQuote(repo.LocalPath), timeout, shellQuote(destination))
+	output, err := operationExec(cmd)
+	if err != nil {
+		return nil, classifyRemoteError(output, err, "failed to reach the destination repository")
+	}
+	if strings.TrimSpace(output) != "" {
+		return nil, fmt.Errorf("destination repository is not empty - export requires a newly created remote")
+	}
+
+	// Mirror every ref to the destination
+	cmd = fmt.Sprintf("cd %s && timeout %d git push --mirror --porcelain %s 2>&1", shellQuote(repo.LocalPath), timeout, shellQuote(destination))
+	output, err = operationExec(cmd)
+	result := &ExportResult{Destination: RemoteHost(destination), Refs: parsePushPorcelain(output)}
+	if err != nil && len(result.Failures()) == 0 {
+		err = classifyRemoteError(output, err, "failed to export repository")
+		go models.Activities.Insert(&models.Activity{
+			Type:        "repo_export_failed",
+			Repository:  repoName,
+			Description: fmt.Sprintf("Failed to export %s to %s: %v", repoName, result.Destination, err),
+			Author:      "System",
+			Timestamp:   time.Now(),
+		})
+		return nil, err
+	}
+
+	if updateOrigin && len(result.Failures()) == 0 {
+		// Local-only projects have no origin yet
+		cmd = fmt.Sprintf("cd %s && (git remote set-url origin %s 2>/dev/null || git remote add origin %s) 2>&1",
+			shellQuote(repo.LocalPath), shellQuote(destination), shellQuote(destination))
+		if _, err := services.CoderExec(cmd); err != nil {
+			return result, fmt.Errorf("export succeeded but updating origin failed")
+		}
+		repo.URL = destination
+		repo.Host = RepoHost(destination)
+		if err := models.Repositories.Update(repo); err != nil {
+			return result, fmt.Errorf("export succeeded but saving the new origin failed: %w", err)
+		}
+		result.OriginUpdated = true
+	}
+
+	activity := &models.Activity{
+		Type:        "repo_export",
+		Repository:  repoName,
+		Description: fmt.Sprintf("Exported repository %s to %s", repoName, result.Destination),
+		Author:      "System",
+		Timestamp:   time.Now(),
+	}
+	if failures := result.Failures(); len(failures) > 0 {
+		rejected := make([]string, len(failures))
+		for i, ref := range failures {
+			rejected[i] = ref.Ref
+		}
+		activity.Type = "repo_export_failed"
+		activity.Description = fmt.Sprintf("Partially exported %s to %s: %d of %d refs rejected (%s)",
+			repoName, result.Destination, len(failures), len(result.Refs), strings.Join(rejected, ", "))
+	}
+	go models.Activities.Insert(activity)
+
+	return result, nil
+}
+
+// parsePushPorcelain parses `git push --porcelain` output into per-ref results.
+// Ref lines look like "<flag>\t<from>:<to>\t<summary>".
+func parsePushPorcelain(output string) []*RefPushResult {
+	var refs []*RefPushResult
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.SplitN(line, "\t", 3)
+		if len(fields) < 2 || len(fields[0]) != 1 {
+			continue
+		}
+
+		ref := &RefPushResult{Ref: fields[1]}
+		if _, to, ok := strings.Cut(fields[1], ":"); ok {
+			ref.Ref = to
+		}
+		if len(fields) == 3 {
+			ref.Summary = fields[2]
+		}
+
+		switch fields[0] {
+		case "!":
+			ref.Status = "rejected"
+		case "=":
+			ref.Status = "up-to-date"
+		case "-":
+			ref.Status = "deleted"
+		default:
+			ref.Status = "pushed"
+		}
+		refs = append(refs, ref)
+	}
+	return refs
+}
+
+// classifyRemoteError maps Git output from a network operation to a
+// user-friendly error. Clone, export and push share it so the same failure
+// reads the same everywhere; fallback is used when nothing is recognized.
+func classifyRemoteError(output string, err error, fallback string) error {
+	detail := ScrubGitTokens(strings.TrimSpace(output))
+	switch {
+	case errors.Is(err, context.DeadlineExceeded) || strings.Contains(err.Error(), "exit status 124"):
+		return ErrOperationTimedOut
+	case errors.Is(err, context.Canceled):
+		return ErrOperationCancelled
+	case isUnknownRemote(output):
+		return &AppError{Code: CodeNotFound, UserMessage: "remote not found - check the repository's remotes under Remotes", Detail: detail}
+	case strings.Contains(output, "Permission denied") || strings.Contains(output, "Could not read from remote") ||
+		strings.Contains(output, "Authentication failed"):
+		return &AppError{Code: CodeAuthFailed, UserMessage: "authentication failed - for private repos, add your SSH key to the git provider, or save an access token for HTTPS URLs", Detail: detail}
+	case strings.Contains(output, "does not exist") || strings.Contains(output, "not found"):
+		return &AppError{Code: CodeNotFound, UserMessage: "repository not found - check the URL is correct", Detail: detail}
+	case strings.Contains(output, "Could not resolve") || strings.Contains(output, "unable to access"):
+		return &AppError{Code: CodeNetwork, UserMessage: "network error - check your connection and try again", Detail: detail, Retryable: true}
+	default:
+		return &AppError{Code: CodeFailed, UserMessage: fallback, Detail: detail, Err: err}
+	}
+}
+
+// shellQuote wraps a value in single quotes for safe use in a shell command.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
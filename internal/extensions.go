@@ -0,0 +1,220 @@
+package internal
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"workbench/models"
+	"workbench/services"
+)
+
+// desiredExtensionsKey holds the comma-separated extensions kept installed
+// in code-server, so they come back when the coder volume is recreated.
+const desiredExtensionsKey = "desired_extensions"
+
+// ExtensionStatus is an installed or desired VS Code extension.
+type ExtensionStatus struct {
+	ID        string `json:"id"`
+	Version   string `json:"version,omitempty"` // Empty when not installed
+	Installed bool   `json:"installed"`
+	Desired   bool   `json:"desired"`         // Reinstalled on startup when missing
+	Error     string `json:"error,omitempty"` // Why the last install attempt failed
+}
+
+// Code-server calls and the desired list. Replaced in tests.
+var (
+	extensionsInstalled = services.ListInstalledExtensions
+	extensionInstall    = services.InstallExtension
+	extensionUninstall  = services.UninstallExtension
+	desiredExtensions   = func() []string { return settingList(desiredExtensionsKey) }
+	setDesired          = func(ids []string) error {
+		_, err := models.SetSetting(desiredExtensionsKey, strings.Join(ids, ","), "user_preference")
+		return err
+	}
+)
+
+// extensionFailures remembers why desired extensions failed to install,
+// by lowercase ID, until an install succeeds.
+var extensionFailures = struct {
+	sync.Mutex
+	errors map[string]string
+}{errors: map[string]string{}}
+
+func setExtensionFailure(id string, err error) {
+	extensionFailures.Lock()
+	defer extensionFailures.Unlock()
+	if err == nil {
+		delete(extensionFailures.errors, strings.ToLower(id))
+		return
+	}
+	extensionFailures.errors[strings.ToLower(id)] = err.Error()
+}
+
+// GetExtensions returns installed and desired extensions by ID, with the
+// last install error of desired ones that aren't installed.
+func GetExtensions() ([]*ExtensionStatus, error) {
+	installed, err := extensionsInstalled()
+	if err != nil {
+		return nil, err
+	}
+	byID := map[string]*ExtensionStatus{}
+	for _, ext := range installed {
+		byID[strings.ToLower(ext.ID)] = &ExtensionStatus{ID: ext.ID, Version: ext.Version, Installed: true}
+	}
+	for _, id := range desiredExtensions() {
+		status, ok := byID[strings.ToLower(id)]
+		if !ok {
+			status = &ExtensionStatus{ID: id}
+			byID[strings.ToLower(id)] = status
+		}
+		status.Desired = true
+	}
+
+	extensionFailures.Lock()
+	statuses := make([]*ExtensionStatus, 0, len(byID))
+	for key, status := range byID {
+		if !status.Installed {
+			status.Error = extensionFailures.errors[key]
+		}
+		statuses = append(statuses, status)
+	}
+	extensionFailures.Unlock()
+	sort.Slice(statuses, func(i, j int) bool { return strings.ToLower(statuses[i].ID) < strings.ToLower(statuses[j].ID) })
+	return statuses, nil
+}
+
+// InstallDesiredExtension installs an extension and keeps it installed
+// from then on. It stays desired even when installing fails, so startup
+// retries it.
+func InstallDesiredExtension(id string) error {
+	id = strings.TrimSpace(id)
+	if !services.ValidExtensionID(id) {
+		return fmt.Errorf("invalid extension ID '%s' - use publisher.name, e.g. golang.go", id)
+	}
+	if err := KeepExtension(id); err != nil {
+		return err
+	}
+	err := extensionInstall(id)
+	setExtensionFailure(id, err)
+	if err != nil {
+		return err
+	}
+	go models.Activities.Insert(&models.Activity{
+		Type:        "extension_install",
+		Description: fmt.Sprintf("Installed VS Code extension %s", id),
+		Author:      "System",
+		Timestamp:   time.Now(),
+	})
+	return nil
+}
+
+// KeepExtension adds an extension to the desired list without installing
+// it, e.g. one installed from VS Code.
+func KeepExtension(id string) error {
+	if !services.ValidExtensionID(id) {
+		return fmt.Errorf("invalid extension ID '%s' - use publisher.name, e.g. golang.go", id)
+	}
+	desired := desiredExtensions()
+	if slices.ContainsFunc(desired, func(d string) bool { return strings.EqualFold(d, id) }) {
+		return nil
+	}
+	if err := setDesired(append(desired, id)); err != nil {
+		return fmt.Errorf("failed to save desired extensions")
+	}
+	return nil
+}
+
+// ForgetExtension removes an extension from the desired list, leaving it
+// installed.
+func ForgetExtension(id string) error {
+	desired := desiredExtensions()
+	kept := slices.DeleteFunc(slices.Clone(desired), func(d string) bool { return strings.EqualFold(d, id) })
+	if len(kept) == len(desired) {
+		return nil
+	}
+	if err := setDesired(kept); err != nil {
+		return fmt.Errorf("failed to save desired extensions")
+	}
+	setExtensionFailure(id, nil)
+	return nil
+}
+
+// UninstallExtension removes an extension from the desired list and from
+// code-server.
+func UninstallExtension(id string) error {
+	if err := ForgetExtension(id); err != nil {
+		return err
+	}
+	if err := extensionUninstall(id); err != nil {
+		return err
+	}
+	go models.Activities.Insert(&models.Activity{
+		Type:        "extension_uninstall",
+		Description: fmt.Sprintf("Uninstalled VS Code extension %s", id),
+		Author:      "System",
+		Timestamp:   time.Now(),
+	})
+	return nil
+}
+
+// ReconcileExtensions installs every desired extension that isn't
+// installed. Each failure is remembered and reported on its extension, and
+// the rest carry on; failed ones are retried the next time this runs.
+// Records an extension_reconcile activity when anything was attempted.
+func ReconcileExtensions() (installed []string, failed map[string]error, err error) {
+	current, err := extensionsInstalled()
+	if err != nil {
+		return nil, nil, err
+	}
+	have := map[string]bool{}
+	for _, ext := range current {
+		have[strings.ToLower(ext.ID)] = true
+	}
+
+	failed = map[string]error{}
+	for _, id := range desiredExtensions() {
+		if have[strings.ToLower(id)] {
+			setExtensionFailure(id, nil)
+			continue
+		}
+		err := extensionInstall(id)
+		setExtensionFailure(id, err)
+		if err != nil {
+			failed[id] = err
+			continue
+		}
+		installed = append(installed, id)
+	}
+
+	if len(installed)+len(failed) > 0 {
+		activity := &models.Activity{
+			Type:        "extension_reconcile",
+			Description: extensionReconcileDescription(installed, failed),
+			Author:      "System",
+			Timestamp:   time.Now(),
+		}
+		if len(failed) > 0 {
+			activity.Type = "extension_reconcile_failed"
+		}
+		go models.Activities.Insert(activity)
+	}
+	return installed, failed, nil
+}
+
+// extensionReconcileDescription summarizes a reconcile for the activity
+// log, naming the extensions that failed.
+func extensionReconcileDescription(installed []string, failed map[string]error) string {
+	description := fmt.Sprintf("Reinstalled %d missing VS Code extensions", len(installed))
+	if len(failed) > 0 {
+		ids := make([]string, 0, len(failed))
+		for id := range failed {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		description += fmt.Sprintf(", %d failed and will be retried on the next start (%s)", len(failed), strings.Join(ids, ", "))
+	}
+	return description
+}
@@ -0,0 +1,111 @@
+package internal
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"workbench/services"
+
+	"github.com/The-Skyscape/devtools/pkg/testutils"
+)
+
+// fakeExtensions replaces code-server and the desired list. install fails
+// for IDs in failing; installs and uninstalls update installed.
+func fakeExtensions(t *testing.T, installed []string, desired []string, failing map[string]string) (*[]string, *[]string) {
+	originalList, originalInstall, originalUninstall := extensionsInstalled, extensionInstall, extensionUninstall
+	originalDesired, originalSet := desiredExtensions, setDesired
+	extensionsInstalled = func() ([]*services.Extension, error) {
+		var list []*services.Extension
+		for _, id := range installed {
+			list = append(list, &services.Extension{ID: id, Version: "1.0.0"})
+		}
+		return list, nil
+	}
+	extensionInstall = func(id string) error {
+		if reason, ok := failing[id]; ok {
+			return errors.New(reason)
+		}
+		installed = append(installed, id)
+		return nil
+	}
+	extensionUninstall = func(id string) error {
+		installed = removeFold(installed, id)
+		return nil
+	}
+	desiredExtensions = func() []string { return desired }
+	setDesired = func(ids []string) error {
+		desired = ids
+		return nil
+	}
+	t.Cleanup(func() {
+		extensionsInstalled, extensionInstall, extensionUninstall = originalList, originalInstall, originalUninstall
+		desiredExtensions, setDesired = originalDesired, originalSet
+		extensionFailures.errors = map[string]string{}
+	})
+	return &installed, &desired
+}
+
+func removeFold(list []string, id string) []string {
+	var kept []string
+	for _, item := range list {
+		if !strings.EqualFold(item, id) {
+			kept = append(kept, item)
+		}
+	}
+	return kept
+}
+
+func TestReconcileExtensionsInstallsMissing(t *testing.T) {
+	installed, _ := fakeExtensions(t,
+		[]string{"Golang.go"},
+		[]string{"golang.go", "esbenp.prettier-vscode", "acme.nope", "ms-python.python"},
+		map[string]string{"acme.nope": "failed to install acme.nope: Extension 'acme.nope' not found."})
+
+	done, failed, err := ReconcileExtensions()
+	testutils.AssertEqual(t, nil, err)
+	testutils.AssertEqual(t, "esbenp.prettier-vscode,ms-python.python", strings.Join(done, ","))
+	testutils.AssertEqual(t, 1, len(failed))
+	testutils.AssertEqual(t, "Golang.go,esbenp.prettier-vscode,ms-python.python", strings.Join(*installed, ","))
+
+	// The failure shows on its extension until an install succeeds
+	statuses, err := GetExtensions()
+	testutils.AssertEqual(t, nil, err)
+	var summary []string
+	for _, s := range statuses {
+		summary = append(summary, s.ID+":"+s.Error)
+	}
+	testutils.AssertEqual(t, "acme.nope:failed to install acme.nope: Extension 'acme.nope' not found.,esbenp.prettier-vscode:,Golang.go:,ms-python.python:", strings.Join(summary, ","))
+}
+
+func TestReconcileExtensionsNothingToDo(t *testing.T) {
+	fakeExtensions(t, []string{"golang.go"}, []string{"golang.go"}, nil)
+
+	done, failed, err := ReconcileExtensions()
+	testutils.AssertEqual(t, nil, err)
+	testutils.AssertEqual(t, 0, len(done))
+	testutils.AssertEqual(t, 0, len(failed))
+}
+
+func TestInstallDesiredExtensionKeepsFailures(t *testing.T) {
+	_, desired := fakeExtensions(t, nil, nil, map[string]string{"acme.offline": "marketplace unreachable"})
+
+	testutils.AssertEqual(t, true, InstallDesiredExtension("acme.offline") != nil)
+	testutils.AssertEqual(t, nil, InstallDesiredExtension("golang.go"))
+	testutils.AssertEqual(t, nil, InstallDesiredExtension("Golang.go"))
+	testutils.AssertEqual(t, "acme.offline,golang.go", strings.Join(*desired, ","))
+
+	testutils.AssertEqual(t, true, InstallDesiredExtension("not an id") != nil)
+	testutils.AssertEqual(t, 2, len(*desired))
+}
+
+func TestUninstallExtensionForgetsIt(t *testing.T) {
+	installed, desired := fakeExtensions(t, []string{"golang.go", "ms-python.python"}, []string{"golang.go", "ms-python.python"}, nil)
+
+	testutils.AssertEqual(t, nil, ForgetExtension("MS-Python.python"))
+	testutils.AssertEqual(t, "golang.go", strings.Join(*desired, ","))
+	testutils.AssertEqual(t, 2, len(*installed))
+
+	testutils.AssertEqual(t, nil, UninstallExtension("golang.go"))
+	testutils.AssertEqual(t, 0, len(*desired))
+	testutils.AssertEqual(t, "ms-python.python", strings.Join(*installed, ","))
+}
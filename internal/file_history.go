@@ -0,0 +1,220 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"workbench/models"
+	"workbench/services"
+)
+
+// File history page sizes.
+const (
+	DefaultFileHistoryLimit = 20
+	MaxFileHistoryLimit     = 100
+)
+
+// ErrBlameBinary is returned by GetFileBlame for binary files, which
+// have no lines to blame.
+var ErrBlameBinary = errors.New("blame not available for binary files")
+
+// FileRevision is a commit that changed a file, as git log --follow
+// reports it.
+type FileRevision struct {
+	SHA         string    `json:"sha"`
+	ShortSHA    string    `json:"short_sha"`
+	Author      string    `json:"author"`
+	Email       string    `json:"email"`
+	Date        time.Time `json:"date"` // Committer date
+	Subject     string    `json:"subject"`
+	Status      string    `json:"status" api:"enum=A|M|D|R|C"` // As git diff --name-status prints it
+	Path        string    `json:"path"`                        // The file's path in this commit
+	RenamedFrom string    `json:"renamed_from,omitempty"`      // Its path before, when this commit renamed or copied it
+}
+
+// BlameHunk is a run of consecutive lines last changed by one commit.
+type BlameHunk struct {
+	SHA       string    `json:"sha"`
+	Author    string    `json:"author"`
+	Email     string    `json:"email"`
+	Date      time.Time `json:"date"` // Author date
+	Summary   string    `json:"summary"`
+	StartLine int       `json:"start_line"` // First line, from 1
+	EndLine   int       `json:"end_line"`   // Last line, inclusive
+	Lines     []string  `json:"lines"`
+}
+
+// ShortSHA returns the abbreviated commit hash.
+func (h *BlameHunk) ShortSHA() string {
+	return h.SHA[:min(len(h.SHA), 7)]
+}
+
+// Uncommitted reports whether the lines are changed in the working tree
+// and not committed yet.
+func (h *BlameHunk) Uncommitted() bool {
+	return strings.Trim(h.SHA, "0") == ""
+}
+
+// FileBlame is the blame of a file, or of a range of its lines.
+type FileBlame struct {
+	Path  string       `json:"path"`
+	Hunks []*BlameHunk `json:"hunks"`
+}
+
+// GetFileHistory returns up to limit commits that changed a file, newest
+// first, following it across renames. The path is checked as the file
+// browser checks it, so it must exist in the working tree.
+func GetFileHistory(repoName, filePath string, limit int) ([]*FileRevision, error) {
+	repo, err := models.Repositories.Find("WHERE Name = ?", repoName)
+	if err != nil {
+		return nil, repoNotFound(repoName)
+	}
+	return fileHistory(repo, filePath, limit)
+}
+
+func fileHistory(repo *models.Repository, filePath string, limit int) ([]*FileRevision, error) {
+	_, rel, err := resolveRepoPath(repo, filePath)
+	if err != nil {
+		return nil, err
+	}
+	if limit <= 0 {
+		limit = DefaultFileHistoryLimit
+	}
+	limit = min(limit, MaxFileHistoryLimit)
+
+	// %x1e starts each commit; its name-status lines follow on their own
+	cmd := fmt.Sprintf("cd %s && { git rev-parse -q --verify HEAD >/dev/null || exit 0; } && git -c core.quotePath=false log --follow --max-count=%d --name-status --pretty=format:'%%x1e%s' -- %s 2>&1",
+		shellQuote(repo.LocalPath), limit, commitFormat, shellQuote(rel))
+	output, err := filesExec(cmd, services.DefaultMaxOutput)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file history: %s", strings.TrimSpace(output))
+	}
+	return parseFileHistory(output), nil
+}
+
+// parseFileHistory parses git log --name-status output whose entries
+// start with \x1e and a commitFormat line. Malformed entries are skipped.
+func parseFileHistory(output string) []*FileRevision {
+	revisions := []*FileRevision{}
+	for _, entry := range strings.Split(output, "\x1e")[1:] {
+		lines := strings.Split(strings.TrimSpace(entry), "\n")
+		fields := strings.SplitN(lines[0], "\x1f", 6)
+		if len(fields) != 6 {
+			continue
+		}
+		date, _ := time.Parse(time.RFC3339, fields[4])
+		revision := &FileRevision{SHA: fields[0], ShortSHA: fields[1], Author: fields[2], Email: fields[3], Date: date, Subject: fields[5]}
+		for _, line := range lines[1:] {
+			parts := strings.Split(line, "\t")
+			if len(parts) < 2 || parts[0] == "" {
+				continue
+			}
+			revision.Status = parts[0][:1]
+			revision.Path = parts[len(parts)-1]
+			if len(parts) == 3 {
+				revision.RenamedFrom = parts[1]
+			}
+		}
+		revisions = append(revisions, revision)
+	}
+	return revisions
+}
+
+// GetFileBlame returns who last changed each line of a file, coalesced
+// into hunks of consecutive lines from the same commit. start and end
+// (both from 1, inclusive) narrow it to a range of lines; 0 leaves that
+// end open. Lines changed in the working tree are blamed on the
+// all-zero commit, as git blame does.
+func GetFileBlame(repoName, filePath string, start, end int) (*FileBlame, error) {
+	repo, err := models.Repositories.Find("WHERE Name = ?", repoName)
+	if err != nil {
+		return nil, repoNotFound(repoName)
+	}
+	return fileBlame(repo, filePath, start, end)
+}
+
+func fileBlame(repo *models.Repository, filePath string, start, end int) (*FileBlame, error) {
+	_, rel, err := resolveRepoPath(repo, filePath)
+	if err != nil {
+		return nil, err
+	}
+	if start < 0 || end < 0 || (end > 0 && end < max(start, 1)) {
+		return nil, &repoError{ErrInvalidInput, "start and end must be line numbers, with start before end"}
+	}
+
+	lines := ""
+	if start > 0 || end > 0 {
+		lines = fmt.Sprintf("-L %d,", max(start, 1))
+		if end > 0 {
+			lines += strconv.Itoa(end)
+		}
+		lines += " "
+	}
+	cmd := fmt.Sprintf("cd %s && git blame --line-porcelain %s-- %s 2>&1", shellQuote(repo.LocalPath), lines, shellQuote(rel))
+	output, err := filesExec(cmd, services.DefaultMaxOutput)
+	if err != nil {
+		output = strings.TrimSpace(output)
+		if strings.Contains(output, "no such path") {
+			return nil, fmt.Errorf("'%s' isn't committed yet, so it has no blame", rel)
+		}
+		return nil, fmt.Errorf("failed to blame file: %s", strings.TrimPrefix(output, "fatal: "))
+	}
+
+	hunks, content := parseBlame(output)
+	if isBinary(content) {
+		return nil, ErrBlameBinary
+	}
+	return &FileBlame{Path: rel, Hunks: hunks}, nil
+}
+
+// parseBlame parses git blame --line-porcelain output into hunks, and
+// returns the blamed lines joined, to tell binary files apart.
+func parseBlame(output string) ([]*BlameHunk, string) {
+	hunks := []*BlameHunk{}
+	var content strings.Builder
+	var current BlameHunk
+	lineNumber := 0
+	for _, line := range strings.Split(output, "\n") {
+		if text, ok := strings.CutPrefix(line, "\t"); ok {
+			content.WriteString(text + "\n")
+			last := len(hunks) - 1
+			if last >= 0 && hunks[last].SHA == current.SHA && hunks[last].EndLine == lineNumber-1 {
+				hunks[last].EndLine = lineNumber
+				hunks[last].Lines = append(hunks[last].Lines, text)
+				continue
+			}
+			hunk := current
+			hunk.StartLine, hunk.EndLine, hunk.Lines = lineNumber, lineNumber, []string{text}
+			hunks = append(hunks, &hunk)
+			continue
+		}
+
+		key, value, _ := strings.Cut(line, " ")
+		switch key {
+		case "author":
+			current.Author = value
+		case "author-mail":
+			current.Email = strings.Trim(value, "<>")
+		case "author-time":
+			if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+				current.Date = time.Unix(seconds, 0).UTC()
+			}
+		case "summary":
+			current.Summary = value
+		default:
+			// A header: <sha> <original line> <final line> [<lines in group>]
+			fields := strings.Fields(line)
+			if len(fields) >= 3 && len(fields[0]) >= 40 && isHex(fields[0]) {
+				current = BlameHunk{SHA: fields[0]}
+				lineNumber, _ = strconv.Atoi(fields[2])
+			}
+		}
+	}
+	return hunks, content.String()
+}
+
+func isHex(s string) bool {
+	return strings.Trim(strings.ToLower(s), "0123456789abcdef") == ""
+}
@@ -0,0 +1,117 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+	"workbench/models"
+
+	"github.com/The-Skyscape/devtools/pkg/testutils"
+)
+
+func TestParseFileHistory(t *testing.T) {
+	output := "\x1ec3d4e5f\x1fc3d4e5f\x1fAda\x1fada@example.com\x1f2026-02-03T10:00:00Z\x1fTidy up\n\nM\tcmd/server/main.go\n" +
+		"\x1eb2c3d4e\x1fb2c3d4e\x1fGrace\x1fgrace@example.com\x1f2026-02-02T10:00:00Z\x1fMove main into cmd\n\nR092\tmain.go\tcmd/server/main.go\n" +
+		"\x1ea1b2c3d\x1fa1b2c3d\x1fAda\x1fada@example.com\x1f2026-02-01T10:00:00Z\x1fFirst commit\n\nA\tmain.go\n"
+
+	revisions := parseFileHistory(output)
+	testutils.AssertEqual(t, 3, len(revisions))
+	testutils.AssertEqual(t, "Tidy up", revisions[0].Subject)
+	testutils.AssertEqual(t, "M", revisions[0].Status)
+	testutils.AssertEqual(t, "", revisions[0].RenamedFrom)
+	testutils.AssertEqual(t, "R", revisions[1].Status)
+	testutils.AssertEqual(t, "main.go", revisions[1].RenamedFrom)
+	testutils.AssertEqual(t, "cmd/server/main.go", revisions[1].Path)
+	testutils.AssertEqual(t, "main.go", revisions[2].Path)
+	testutils.AssertEqual(t, time.Date(2026, 2, 1, 10, 0, 0, 0, time.UTC), revisions[2].Date)
+
+	testutils.AssertEqual(t, 0, len(parseFileHistory("")))
+}
+
+// blameLine is one line of git blame --line-porcelain output.
+func blameLine(sha string, line int, author, text string) string {
+	return fmt.Sprintf("%s %d %d\nauthor %s\nauthor-mail <%s@example.com>\nauthor-time 1767225600\nauthor-tz +0000\n"+
+		"summary Change by %s\nfilename main.go\n\t%s\n", sha, line, line, author, strings.ToLower(author), author, text)
+}
+
+func TestParseBlame(t *testing.T) {
+	ada, grace := strings.Repeat("a", 40), strings.Repeat("b", 40)
+	output := blameLine(ada, 1, "Ada", "package main") +
+		blameLine(ada, 2, "Ada", "") +
+		blameLine(grace, 3, "Grace", "func main() {") +
+		blameLine(ada, 4, "Ada", "}")
+
+	hunks, content := parseBlame(output)
+	testutils.AssertEqual(t, "package main\n\nfunc main() {\n}\n", content)
+	testutils.AssertEqual(t, 3, len(hunks))
+	testutils.AssertEqual(t, 1, hunks[0].StartLine)
+	testutils.AssertEqual(t, 2, hunks[0].EndLine)
+	testutils.AssertEqual(t, []string{"package main", ""}, hunks[0].Lines)
+	testutils.AssertEqual(t, "Grace", hunks[1].Author)
+	testutils.AssertEqual(t, "grace@example.com", hunks[1].Email)
+	testutils.AssertEqual(t, "Change by Grace", hunks[1].Summary)
+	testutils.AssertEqual(t, "bbbbbbb", hunks[1].ShortSHA())
+	testutils.AssertEqual(t, 4, hunks[2].StartLine)
+	testutils.AssertEqual(t, time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC), hunks[2].Date)
+	testutils.AssertEqual(t, false, hunks[2].Uncommitted())
+	testutils.AssertEqual(t, true, (&BlameHunk{SHA: strings.Repeat("0", 40)}).Uncommitted())
+}
+
+// fakeBlame answers resolveRepoPath for main.go and git blame with output.
+func fakeBlame(t *testing.T, output string, err error) *[]string {
+	var commands []string
+	original := filesExec
+	filesExec = func(command string, maxOutput int) (string, error) {
+		commands = append(commands, command)
+		if strings.HasPrefix(command, "realpath") {
+			return "/home/coder/repos/api\n/home/coder/repos/api/main.go\n", nil
+		}
+		return output, err
+	}
+	t.Cleanup(func() { filesExec = original })
+	return &commands
+}
+
+var blameRepo = &models.Repository{Name: "api", LocalPath: "/home/coder/repos/api"}
+
+func TestGetFileBlame(t *testing.T) {
+	commands := fakeBlame(t, blameLine(strings.Repeat("a", 40), 5, "Ada", "x := 1"), nil)
+
+	blame, err := fileBlame(blameRepo, "main.go", 5, 9)
+	testutils.AssertEqual(t, nil, err)
+	testutils.AssertEqual(t, "main.go", blame.Path)
+	testutils.AssertEqual(t, 5, blame.Hunks[0].StartLine)
+	testutils.AssertEqual(t, "cd '/home/coder/repos/api' && git blame --line-porcelain -L 5,9 -- 'main.go' 2>&1", (*commands)[1])
+
+	_, err = fileBlame(blameRepo, "main.go", 9, 5)
+	testutils.AssertEqual(t, true, errors.Is(err, ErrInvalidInput))
+}
+
+func TestGetFileBlameRefusesBinaryFiles(t *testing.T) {
+	fakeBlame(t, blameLine(strings.Repeat("a", 40), 1, "Ada", "PNG\x00\x01"), nil)
+
+	_, err := fileBlame(blameRepo, "main.go", 0, 0)
+	testutils.AssertEqual(t, ErrBlameBinary, err)
+}
+
+func TestGetFileBlameUncommitted(t *testing.T) {
+	fakeBlame(t, "fatal: no such path 'main.go' in HEAD\n", errors.New("exit status 128"))
+
+	_, err := fileBlame(blameRepo, "main.go", 0, 0)
+	testutils.AssertEqual(t, "'main.go' isn't committed yet, so it has no blame", err.Error())
+}
+
+func TestGetFileHistory(t *testing.T) {
+	commands := fakeBlame(t, "\x1ea1b2c3d\x1fa1b2c3d\x1fAda\x1fada@example.com\x1f2026-02-01T10:00:00Z\x1fFirst commit\n\nA\tmain.go\n", nil)
+
+	revisions, err := fileHistory(blameRepo, "main.go", 500)
+	testutils.AssertEqual(t, nil, err)
+	testutils.AssertEqual(t, 1, len(revisions))
+	testutils.AssertEqual(t, true, strings.Contains((*commands)[1], "git -c core.quotePath=false log --follow --max-count=100 --name-status"))
+	testutils.AssertEqual(t, true, strings.HasSuffix((*commands)[1], "-- 'main.go' 2>&1"))
+
+	_, err = fileHistory(blameRepo, "../other/main.go", 10)
+	testutils.AssertEqual(t, ErrInvalidRepoPath, err)
+}
@@ -0,0 +1,201 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"workbench/models"
+	"workbench/services"
+)
+
+// MaxRepoFileSize is the largest file the file browser reads.
+const MaxRepoFileSize = 2 << 20
+
+// ErrInvalidRepoPath is returned by the file browser for paths that are
+// malformed, inside .git, or lead outside the repository.
+var ErrInvalidRepoPath = errors.New("invalid path - it must be inside the repository and not in .git")
+
+// ErrRepoPathNotFound is returned by the file browser for paths that don't
+// exist.
+var ErrRepoPathNotFound = errors.New("no such file or directory in the repository")
+
+// FileTooLargeError is returned by ReadRepoFile for files over its limit.
+type FileTooLargeError struct {
+	Size  int64
+	Limit int64
+}
+
+func (e *FileTooLargeError) Error() string {
+	return fmt.Sprintf("file is too large to view (%d KB limit) - open it in VS Code", e.Limit/1024)
+}
+
+// RepoEntry is a file or directory in a repository listing.
+type RepoEntry struct {
+	Name     string    `json:"name"`
+	Type     string    `json:"type" api:"enum=file|dir|symlink|other"`
+	Size     uint64    `json:"size"`
+	Modified time.Time `json:"modified"`
+}
+
+// RepoFile is a file read from a repository's working tree.
+type RepoFile struct {
+	Path    string // Repository-relative
+	Content []byte
+	Binary  bool // Has NUL bytes or invalid UTF-8
+}
+
+// filesExec runs commands in the coder container, keeping at most
+// maxOutput bytes of output. Replaced in tests.
+var filesExec = func(command string, maxOutput int) (string, error) {
+	result, err := services.CoderExecWithOptions(command, services.ExecOptions{MaxOutput: maxOutput})
+	return result.Output, err
+}
+
+// ListRepoFiles lists a directory in a repository's working tree,
+// directories first, then by name. An empty path lists the repository
+// root. .git is left out.
+func ListRepoFiles(repoName, dirPath string) ([]*RepoEntry, error) {
+	repo, err := models.Repositories.Find("WHERE Name = ?", repoName)
+	if err != nil {
+		return nil, repoNotFound(repoName)
+	}
+	dir, _, err := resolveRepoPath(repo, dirPath)
+	if err != nil {
+		return nil, err
+	}
+
+	output, err := filesExec(fmt.Sprintf("cd %s && find . -mindepth 1 -maxdepth 1 -printf '%%y\\t%%s\\t%%T@\\t%%f\\n' 2>&1", shellQuote(dir)), services.DefaultMaxOutput)
+	if err != nil {
+		if strings.Contains(output, "Not a directory") {
+			return nil, fmt.Errorf("'%s' is a file, not a directory", dirPath)
+		}
+		return nil, fmt.Errorf("failed to list files: %s", strings.TrimSpace(output))
+	}
+	return parseRepoEntries(output), nil
+}
+
+// parseRepoEntries parses find -printf '%y\t%s\t%T@\t%f\n' output.
+func parseRepoEntries(output string) []*RepoEntry {
+	entries := []*RepoEntry{}
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.SplitN(line, "\t", 4)
+		if len(fields) != 4 || fields[3] == ".git" {
+			continue
+		}
+		entry := &RepoEntry{Name: fields[3], Type: "other"}
+		switch fields[0] {
+		case "f":
+			entry.Type = "file"
+		case "d":
+			entry.Type = "dir"
+		case "l":
+			entry.Type = "symlink"
+		}
+		entry.Size, _ = strconv.ParseUint(fields[1], 10, 64)
+		if seconds, err := strconv.ParseFloat(fields[2], 64); err == nil {
+			entry.Modified = time.Unix(0, int64(seconds*float64(time.Second))).UTC()
+		}
+		entries = append(entries, entry)
+	}
+
+	slices.SortFunc(entries, func(a, b *RepoEntry) int {
+		if (a.Type == "dir") != (b.Type == "dir") {
+			if a.Type == "dir" {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(a.Name, b.Name)
+	})
+	return entries
+}
+
+// ReadRepoFile reads a file from a repository's working tree. Files over
+// maxBytes return *FileTooLargeError without being read.
+func ReadRepoFile(repoName, filePath string, maxBytes int64) (*RepoFile, error) {
+	repo, err := models.Repositories.Find("WHERE Name = ?", repoName)
+	if err != nil {
+		return nil, repoNotFound(repoName)
+	}
+	target, rel, err := resolveRepoPath(repo, filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	output, err := filesExec(fmt.Sprintf("stat -c '%%F\\t%%s' -- %s 2>&1", shellQuote(target)), 1024)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %s", strings.TrimSpace(output))
+	}
+	kind, sizeText, _ := strings.Cut(strings.TrimSpace(output), "\t")
+	if kind != "regular file" && kind != "regular empty file" {
+		return nil, fmt.Errorf("'%s' is a %s, not a file", filePath, kind)
+	}
+	size, _ := strconv.ParseInt(sizeText, 10, 64)
+	if size > maxBytes {
+		return nil, &FileTooLargeError{Size: size, Limit: maxBytes}
+	}
+
+	content, err := filesExec(fmt.Sprintf("cat -- %s", shellQuote(target)), int(maxBytes))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file")
+	}
+	return &RepoFile{Path: rel, Content: []byte(content), Binary: isBinary(content)}, nil
+}
+
+// resolveRepoPath resolves symlinks in a repository-relative path,
+// returning its absolute path in the coder container and its path relative
+// to the repository. Paths that end up outside the repository or inside
+// .git are refused.
+func resolveRepoPath(repo *models.Repository, p string) (string, string, error) {
+	rel, err := cleanBrowsePath(p)
+	if err != nil {
+		return "", "", err
+	}
+
+	output, err := filesExec(fmt.Sprintf("realpath -e -- %s %s 2>&1", shellQuote(repo.LocalPath), shellQuote(path.Join(repo.LocalPath, rel))), 8192)
+	if err != nil {
+		if strings.Contains(output, "No such file") {
+			return "", "", ErrRepoPathNotFound
+		}
+		return "", "", fmt.Errorf("failed to resolve path: %s", strings.TrimSpace(output))
+	}
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	if len(lines) != 2 {
+		return "", "", fmt.Errorf("failed to resolve path")
+	}
+	root, target := lines[0], lines[1]
+	if target == root {
+		return target, ".", nil
+	}
+	inside, ok := strings.CutPrefix(target, root+"/")
+	if !ok {
+		return "", "", ErrInvalidRepoPath
+	}
+	// A symlink could point into .git
+	if _, err := cleanBrowsePath(inside); err != nil {
+		return "", "", err
+	}
+	return target, inside, nil
+}
+
+// cleanBrowsePath validates a repository-relative path for the file
+// browser, returning "." for the repository root.
+func cleanBrowsePath(p string) (string, error) {
+	p = path.Clean(strings.TrimSpace(p))
+	if p == "." {
+		return p, nil
+	}
+	if path.IsAbs(p) || p == ".." || strings.HasPrefix(p, "../") {
+		return "", ErrInvalidRepoPath
+	}
+	for _, part := range strings.Split(p, "/") {
+		if strings.EqualFold(part, ".git") {
+			return "", ErrInvalidRepoPath
+		}
+	}
+	return p, nil
+}
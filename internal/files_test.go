@@ -0,0 +1,103 @@
+package internal
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+	"workbench/models"
+
+	"github.com/The-Skyscape/devtools/pkg/testutils"
+)
+
+func TestCleanBrowsePath(t *testing.T) {
+	testCases := []struct {
+		path     string
+		expected string
+		valid    bool
+	}{
+		{"", ".", true},
+		{" . ", ".", true},
+		{"cmd/server/main.go", "cmd/server/main.go", true},
+		{"docs/../README.md", "README.md", true},
+		{"docs/", "docs", true},
+		{"..", "", false},
+		{"../other-repo/.env", "", false},
+		{"docs/../../etc/passwd", "", false},
+		{"/etc/passwd", "", false},
+		{".git/config", "", false},
+		{"vendor/.GIT/hooks", "", false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.path, func(t *testing.T) {
+			cleaned, err := cleanBrowsePath(tc.path)
+			testutils.AssertEqual(t, tc.expected, cleaned)
+			testutils.AssertEqual(t, tc.valid, err == nil)
+		})
+	}
+}
+
+// fakeRealpath answers resolveRepoPath's realpath with the repository root
+// and target, or fails like realpath -e does for a missing path.
+func fakeRealpath(t *testing.T, root, target string) {
+	original := filesExec
+	filesExec = func(command string, maxOutput int) (string, error) {
+		if target == "" {
+			return "realpath: /home/coder/repos/api/missing: No such file or directory", errors.New("exit status 1")
+		}
+		return root + "\n" + target + "\n", nil
+	}
+	t.Cleanup(func() { filesExec = original })
+}
+
+func TestResolveRepoPath(t *testing.T) {
+	repo := &models.Repository{Name: "api", LocalPath: "/home/coder/repos/api"}
+
+	testCases := []struct {
+		name     string
+		resolved string
+		rel      string
+		err      error
+	}{
+		{"root", "/home/coder/repos/api", ".", nil},
+		{"file", "/home/coder/repos/api/cmd/main.go", "cmd/main.go", nil},
+		{"symlink out of the repository", "/home/coder/.ssh/id_ed25519", "", ErrInvalidRepoPath},
+		{"symlink to a sibling with a shared prefix", "/home/coder/repos/api-secrets/.env", "", ErrInvalidRepoPath},
+		{"symlink into .git", "/home/coder/repos/api/.git/config", "", ErrInvalidRepoPath},
+		{"missing", "", "", ErrRepoPathNotFound},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			fakeRealpath(t, "/home/coder/repos/api", tc.resolved)
+			target, rel, err := resolveRepoPath(repo, "link")
+			testutils.AssertEqual(t, tc.err, err)
+			testutils.AssertEqual(t, tc.rel, rel)
+			if err == nil {
+				testutils.AssertEqual(t, tc.resolved, target)
+			}
+		})
+	}
+}
+
+func TestParseRepoEntries(t *testing.T) {
+	output := strings.Join([]string{
+		"f\t1204\t1767225600.5000000000\tREADME.md",
+		"d\t4096\t1767225600.0000000000\tcmd",
+		"d\t4096\t1767225600.0000000000\t.git",
+		"l\t9\t1767225600.0000000000\tlatest",
+		"f\t0\t1767225600.0000000000\tname\twith tab",
+		"d\t4096\t1767225600.0000000000\tapi",
+		"",
+	}, "\n")
+
+	entries := parseRepoEntries(output)
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Type+":"+e.Name)
+	}
+	testutils.AssertEqual(t, "dir:api,dir:cmd,file:README.md,symlink:latest,file:name\twith tab", strings.Join(names, ","))
+	testutils.AssertEqual(t, uint64(1204), entries[2].Size)
+	testutils.AssertEqual(t, time.Date(2026, 1, 1, 0, 0, 0, 500000000, time.UTC), entries[2].Modified)
+}
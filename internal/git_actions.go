@@ -0,0 +1,89 @@
+package internal
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"workbench/models"
+)
+
+// Recovery actions for a repository stuck after a bad pull or a force-push
+// upstream. Each discards local state, so each runs behind a safety point.
+// Pulls are covered the same way in pullRepository, including rebase pulls
+// configured with pull.rebase.
+
+// ResetToUpstream hard-resets the current branch to its upstream after
+// fetching, discarding local commits and uncommitted changes.
+func ResetToUpstream(repoName string) error {
+	return runGitAction(repoName, "reset", "Reset %s to its upstream branch",
+		"git fetch 2>&1 && git reset --hard @{upstream} 2>&1")
+}
+
+// AbortMerge abandons an in-progress merge, rebase, cherry-pick, or
+// revert, restoring the state before the pull or the commit was applied.
+func AbortMerge(repoName string) error {
+	return runGitAction(repoName, "merge-abort", "Aborted the in-progress merge in %s", abortMergeCommand)
+}
+
+// abortMergeCommand aborts a rebase when one is in progress, then a
+// cherry-pick or revert, and otherwise a merge, checking in the same order
+// as mergeStateScript.
+const abortMergeCommand = `if [ -e "$(git rev-parse --git-path rebase-merge)" ] || [ -e "$(git rev-parse --git-path rebase-apply)" ]; then git rebase --abort 2>&1; ` +
+	`elif [ -e "$(git rev-parse --git-path CHERRY_PICK_HEAD)" ]; then git cherry-pick --abort 2>&1; ` +
+	`elif [ -e "$(git rev-parse --git-path REVERT_HEAD)" ]; then git revert --abort 2>&1; else git merge --abort 2>&1; fi`
+
+// CleanupMergedBranches deletes local branches already merged into the
+// current branch. The current branch is never deleted.
+func CleanupMergedBranches(repoName string) error {
+	return runGitAction(repoName, "branch-cleanup", "Deleted merged branches in %s",
+		"git branch --merged | grep -v '^[*+]' | xargs -r git branch -d 2>&1")
+}
+
+// runGitAction runs a destructive git command in a repository behind a
+// safety point, recording the outcome as a git_<operation> activity, or
+// git_<operation>_failed with git's output. Locked repositories are
+// refused. The error returned on failure only carries git's output as its
+// logged detail.
+func runGitAction(repoName, operation, description, command string) error {
+	repo, err := models.Repositories.Find("WHERE Name = ?", repoName)
+	if err != nil {
+		return repoNotFound(repoName)
+	}
+	if err := checkUnlocked(repo); err != nil {
+		return err
+	}
+
+	var output string
+	err = WithSafetyPoint(repo, operation, func() error {
+		var err error
+		output, err = safetyExec(fmt.Sprintf("cd %s && %s", shellQuote(repo.LocalPath), command))
+		return err
+	})
+
+	activityType := "git_" + strings.ReplaceAll(operation, "-", "_")
+	if err != nil {
+		output = ScrubGitTokens(strings.TrimSpace(output))
+		go models.Activities.Insert(&models.Activity{
+			Type:        activityType + "_failed",
+			Repository:  repoName,
+			Description: fmt.Sprintf("Failed to run %s in %s: %s", operation, repoName, output),
+			Author:      "System",
+			Timestamp:   time.Now(),
+		})
+		return &AppError{
+			Code:        CodeFailed,
+			UserMessage: fmt.Sprintf("%s failed - git's output is in the activity log", operation),
+			Detail:      output,
+			Err:         err,
+		}
+	}
+
+	go models.Activities.Insert(&models.Activity{
+		Type:        activityType,
+		Repository:  repoName,
+		Description: fmt.Sprintf(description, repoName),
+		Author:      "System",
+		Timestamp:   time.Now(),
+	})
+	return nil
+}
@@ -0,0 +1,284 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+	"workbench/internal/providers"
+	"workbench/models"
+	"workbench/services"
+)
+
+// ErrGitIdentityMissing is returned by features that commit when no git
+// identity is configured, rather than committing as the container default.
+var ErrGitIdentityMissing = errors.New("set your git name and email under Preferences before committing")
+
+// Settings for the identity suggestion banner.
+const (
+	gitIdentitySuggestionKey = "git_identity_suggestion" // JSON GitIdentity waiting for confirmation
+	gitIdentityDismissedKey  = "git_identity_dismissed"  // "true" once the banner is dismissed
+)
+
+// Settings keeping the identity last set from the workbench, so it can be
+// compared with the container's git config and applied again.
+const (
+	gitUserNameKey  = "git_user_name"
+	gitUserEmailKey = "git_user_email"
+)
+
+// GitIdentity is a git author name and email.
+type GitIdentity struct {
+	Name   string
+	Email  string
+	Source string `json:",omitempty"` // Where a suggestion came from, e.g. "your GitHub account"
+}
+
+// String formats the identity the way git shows authors.
+func (g *GitIdentity) String() string {
+	return fmt.Sprintf("%s <%s>", g.Name, g.Email)
+}
+
+// identityExec runs git config in the coder container. Replaced in tests.
+var identityExec = services.CoderExec
+
+// GetGitIdentity returns the global git identity in the container, or nil
+// when either the name or email is unset.
+func GetGitIdentity() *GitIdentity {
+	output, err := identityExec("git config --global user.name && git config --global user.email")
+	if err != nil {
+		return nil
+	}
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	if len(lines) != 2 || strings.TrimSpace(lines[0]) == "" || strings.TrimSpace(lines[1]) == "" {
+		return nil
+	}
+	return &GitIdentity{Name: strings.TrimSpace(lines[0]), Email: strings.TrimSpace(lines[1])}
+}
+
+// RequireGitIdentity returns ErrGitIdentityMissing unless the repository
+// has an explicitly configured identity (global or repository-local).
+func RequireGitIdentity(repo *models.Repository) error {
+	output, err := identityExec(fmt.Sprintf("cd %s && git config user.name && git config user.email", shellQuote(repo.LocalPath)))
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	if err != nil || len(lines) != 2 || strings.TrimSpace(lines[0]) == "" || strings.TrimSpace(lines[1]) == "" {
+		return ErrGitIdentityMissing
+	}
+	return nil
+}
+
+// ConfigureGitUser sets the global git identity in the container used for
+// every commit made in VS Code and by the workbench, and saves it so drift
+// can be spotted later. A pending suggestion is cleared.
+func ConfigureGitUser(name, email string) error {
+	name, email = strings.TrimSpace(name), strings.TrimSpace(email)
+	if err := validateGitIdentity(name, email); err != nil {
+		return err
+	}
+
+	cmd := fmt.Sprintf("git config --global user.name %s && git config --global user.email %s 2>&1", shellQuote(name), shellQuote(email))
+	if output, err := identityExec(cmd); err != nil {
+		return fmt.Errorf("failed to set git identity: %s", strings.TrimSpace(output))
+	}
+	if _, err := models.SetSetting(gitUserNameKey, name, "git_identity"); err != nil {
+		slog.Error("failed to save git name", "error", err)
+	}
+	if _, err := models.SetSetting(gitUserEmailKey, email, "git_identity"); err != nil {
+		slog.Error("failed to save git email", "error", err)
+	}
+	if _, err := models.SetSetting(gitIdentitySuggestionKey, "", "git_identity"); err != nil {
+		slog.Error("failed to clear git identity suggestion", "error", err)
+	}
+
+	go models.Activities.Insert(&models.Activity{
+		Type:        "git_identity",
+		Repository:  "",
+		Description: fmt.Sprintf("Set git identity to %s <%s>", name, email),
+		Author:      "System",
+		Timestamp:   time.Now(),
+	})
+	return nil
+}
+
+// StoredGitIdentity returns the identity last set from the workbench, or
+// nil when none has been.
+func StoredGitIdentity() *GitIdentity {
+	name, _ := models.GetSetting(gitUserNameKey)
+	email, _ := models.GetSetting(gitUserEmailKey)
+	if name == "" || email == "" {
+		return nil
+	}
+	return &GitIdentity{Name: name, Email: email}
+}
+
+// GitIdentityStatus compares the stored identity with the container's
+// git config.
+type GitIdentityStatus struct {
+	Stored    *GitIdentity // Nil when none was set from the workbench
+	Container *GitIdentity // Nil when git config has no name or email
+}
+
+// Drifted reports whether the container's git config no longer matches
+// the stored identity, e.g. after git config was run in the terminal or
+// the home volume was replaced.
+func (s *GitIdentityStatus) Drifted() bool {
+	if s.Stored == nil {
+		return false
+	}
+	return s.Container == nil || s.Container.Name != s.Stored.Name || s.Container.Email != s.Stored.Email
+}
+
+// CheckGitIdentity reads the stored and container identities.
+func CheckGitIdentity() *GitIdentityStatus {
+	return &GitIdentityStatus{Stored: StoredGitIdentity(), Container: GetGitIdentity()}
+}
+
+// ReapplyGitIdentity sets the container's git config back to the stored
+// identity.
+func ReapplyGitIdentity() error {
+	identity := StoredGitIdentity()
+	if identity == nil {
+		return fmt.Errorf("no git identity has been saved yet - set your name and email first")
+	}
+	return ConfigureGitUser(identity.Name, identity.Email)
+}
+
+func validateGitIdentity(name, email string) error {
+	if name == "" || email == "" {
+		return fmt.Errorf("git name and email are both required")
+	}
+	if strings.ContainsAny(name+email, "<>\n\r") {
+		return fmt.Errorf("git name and email can't contain angle brackets or line breaks")
+	}
+	if at := strings.Index(email, "@"); at <= 0 || at == len(email)-1 || strings.ContainsAny(email, " \t") {
+		return fmt.Errorf("invalid email address")
+	}
+	return nil
+}
+
+// SuggestGitIdentity infers an identity after a successful clone when none
+// is configured and the suggestion hasn't been dismissed, and keeps it for
+// the dashboard banner. Nothing is applied until the user confirms.
+func SuggestGitIdentity(repo *models.Repository) {
+	if dismissed, _ := models.GetSetting(gitIdentityDismissedKey); dismissed == "true" {
+		return
+	}
+	if PendingGitIdentity() != nil || GetGitIdentity() != nil {
+		return
+	}
+
+	identity := identityFromProvider(repo)
+	if identity == nil {
+		identity = identityFromHistory()
+	}
+	if identity == nil {
+		return
+	}
+
+	data, _ := json.Marshal(identity)
+	if _, err := models.SetSetting(gitIdentitySuggestionKey, string(data), "git_identity"); err != nil {
+		slog.Error("failed to save git identity suggestion", "error", err)
+	}
+}
+
+// identityFromProvider asks the provider API who the configured token
+// belongs to. Returns nil without a token or an email.
+func identityFromProvider(repo *models.Repository) *GitIdentity {
+	remote := providers.ParseRemote(repo.URL)
+	if remote == nil || providers.Token(remote.Host) == "" {
+		return nil
+	}
+	user, err := providers.AuthenticatedUser(remote.Host)
+	if err != nil || user.Name == "" || user.Email == "" {
+		return nil
+	}
+	return &GitIdentity{Name: user.Name, Email: user.Email, Source: "your " + remote.DisplayName() + " account"}
+}
+
+// identityFromHistory finds the most recent commit in any cloned
+// repository authored with an email on the signup email's domain.
+func identityFromHistory() *GitIdentity {
+	users, err := models.Auth.Users.Search("ORDER BY CreatedAt ASC LIMIT 1")
+	if err != nil || len(users) == 0 {
+		return nil
+	}
+	_, domain, ok := strings.Cut(users[0].Email, "@")
+	if !ok || domain == "" {
+		return nil
+	}
+
+	repos, err := models.Repositories.Search("ORDER BY Name ASC")
+	if err != nil {
+		return nil
+	}
+	var logs []string
+	for _, repo := range repos {
+		output, err := identityExec(fmt.Sprintf("cd %s && git log --all -n 200 --format='%%at|%%an|%%ae' 2>/dev/null", shellQuote(repo.LocalPath)))
+		if err == nil {
+			logs = append(logs, output)
+		}
+	}
+	return identityFromLog(strings.Join(logs, "\n"), domain)
+}
+
+// identityFromLog picks the author of the newest commit whose email is on
+// domain from "unix time|name|email" lines.
+func identityFromLog(output, domain string) *GitIdentity {
+	var newest int64
+	var identity *GitIdentity
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.SplitN(strings.TrimSpace(line), "|", 3)
+		if len(fields) != 3 || !strings.EqualFold(emailDomain(fields[2]), domain) {
+			continue
+		}
+		at, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil || at <= newest || validateGitIdentity(fields[1], fields[2]) != nil {
+			continue
+		}
+		newest = at
+		identity = &GitIdentity{Name: fields[1], Email: fields[2], Source: "your recent commits"}
+	}
+	return identity
+}
+
+func emailDomain(email string) string {
+	_, domain, _ := strings.Cut(email, "@")
+	return domain
+}
+
+// PendingGitIdentity returns the identity suggestion waiting for
+// confirmation, or nil.
+func PendingGitIdentity() *GitIdentity {
+	value, _ := models.GetSetting(gitIdentitySuggestionKey)
+	if value == "" {
+		return nil
+	}
+	var identity GitIdentity
+	if err := json.Unmarshal([]byte(value), &identity); err != nil || identity.Name == "" {
+		return nil
+	}
+	return &identity
+}
+
+// AcceptGitIdentity applies the pending suggestion with ConfigureGitUser.
+func AcceptGitIdentity() error {
+	identity := PendingGitIdentity()
+	if identity == nil {
+		return fmt.Errorf("there's no git identity suggestion to accept")
+	}
+	return ConfigureGitUser(identity.Name, identity.Email)
+}
+
+// DismissGitIdentity drops the pending suggestion and stops suggesting.
+func DismissGitIdentity() error {
+	if _, err := models.SetSetting(gitIdentityDismissedKey, "true", "git_identity"); err != nil {
+		return fmt.Errorf("failed to save preference")
+	}
+	if _, err := models.SetSetting(gitIdentitySuggestionKey, "", "git_identity"); err != nil {
+		slog.Error("failed to clear git identity suggestion", "error", err)
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package internal
+
+import (
+	"errors"
+	"testing"
+	"workbench/models"
+
+	"github.com/The-Skyscape/devtools/pkg/testutils"
+)
+
+func TestIdentityFromLog(t *testing.T) {
+	output := "1700000300|CI Bot|ci@builds.example.com\n" +
+		"1700000100|Jane D|jane@corp.com\n" +
+		"1700000200|Jane Doe|jane@Corp.com\n" +
+		"garbage line\n" +
+		"1700000400|Someone Else|someone@gmail.com\n"
+
+	identity := identityFromLog(output, "corp.com")
+	testutils.AssertEqual(t, "Jane Doe <jane@Corp.com>", identity.String())
+	testutils.AssertEqual(t, "your recent commits", identity.Source)
+
+	testutils.AssertEqual(t, true, identityFromLog(output, "other.org") == nil)
+	testutils.AssertEqual(t, true, identityFromLog("", "corp.com") == nil)
+}
+
+func TestValidateGitIdentity(t *testing.T) {
+	testCases := []struct {
+		name     string
+		user     string
+		email    string
+		expected string
+	}{
+		{"valid", "Jane Doe", "jane@corp.com", ""},
+		{"missing name", "", "jane@corp.com", "git name and email are both required"},
+		{"missing email", "Jane Doe", "", "git name and email are both required"},
+		{"no at", "Jane Doe", "jane.corp.com", "invalid email address"},
+		{"no domain", "Jane Doe", "jane@", "invalid email address"},
+		{"space", "Jane Doe", "jane doe@corp.com", "invalid email address"},
+		{"brackets", "Jane <Doe>", "jane@corp.com", "git name and email can't contain angle brackets or line breaks"},
+		{"newline", "Jane\nDoe", "jane@corp.com", "git name and email can't contain angle brackets or line breaks"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			message := ""
+			if err := validateGitIdentity(tc.user, tc.email); err != nil {
+				message = err.Error()
+			}
+			testutils.AssertEqual(t, tc.expected, message)
+		})
+	}
+}
+
+func TestGitIdentityDrifted(t *testing.T) {
+	jane := &GitIdentity{Name: "Jane Doe", Email: "jane@corp.com"}
+	testCases := []struct {
+		name      string
+		stored    *GitIdentity
+		container *GitIdentity
+		expected  bool
+	}{
+		{"in sync", jane, &GitIdentity{Name: "Jane Doe", Email: "jane@corp.com"}, false},
+		{"nothing stored", nil, jane, false},
+		{"unset in container", jane, nil, true},
+		{"other email", jane, &GitIdentity{Name: "Jane Doe", Email: "jane@home.net"}, true},
+		{"other name", jane, &GitIdentity{Name: "J. Doe", Email: "jane@corp.com"}, true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			status := &GitIdentityStatus{Stored: tc.stored, Container: tc.container}
+			testutils.AssertEqual(t, tc.expected, status.Drifted())
+		})
+	}
+}
+
+func TestRequireGitIdentity(t *testing.T) {
+	original := identityExec
+	t.Cleanup(func() { identityExec = original })
+	repo := &models.Repository{Name: "api", LocalPath: "/home/coder/repos/api"}
+
+	identityExec = func(cmd string) (string, error) { return "Jane Doe\njane@corp.com\n", nil }
+	testutils.AssertEqual(t, nil, RequireGitIdentity(repo))
+
+	identityExec = func(cmd string) (string, error) { return "Jane Doe\n", errors.New("exit status 1") }
+	testutils.AssertEqual(t, ErrGitIdentityMissing, RequireGitIdentity(repo))
+}
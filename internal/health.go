@@ -0,0 +1,123 @@
+package internal
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+	"workbench/models"
+	"workbench/services"
+
+	"github.com/The-Skyscape/devtools/pkg/database"
+)
+
+// HealthRequiredChecksKey is the setting listing the readiness checks
+// that must pass for /health/ready to answer 200. The others still run
+// and are reported, but only for information.
+const HealthRequiredChecksKey = "health_required_checks"
+
+// Readiness checks, in the order they are reported.
+const (
+	HealthCheckDatabase = "database"
+	HealthCheckCoder    = "coder"
+	HealthCheckDataDir  = "datadir"
+)
+
+// HealthChecks are the readiness checks /health/ready runs.
+var HealthChecks = []string{HealthCheckDatabase, HealthCheckCoder, HealthCheckDataDir}
+
+// Probes behind the readiness checks. Replaced in tests.
+var (
+	healthDatabase = func() error {
+		_, err := models.Settings.Search("LIMIT 1")
+		return err
+	}
+	healthCoder = func() error {
+		switch status := services.CoderState(); status.State {
+		case services.CoderStarting:
+			return fmt.Errorf("VS Code container is still starting")
+		case services.CoderFailed:
+			return fmt.Errorf("VS Code container failed to start: %s", status.LastError)
+		case services.CoderPaused:
+			return nil // Stopped on purpose after sitting idle; resumes on request
+		}
+		if services.Coder == nil || !services.Coder.IsRunning() {
+			return fmt.Errorf("VS Code container is not running")
+		}
+		return nil
+	}
+	healthDataDir  = func() error { return probeWritable(database.DataDir()) }
+	healthRequired = func() string { return models.SettingOrDefault(HealthRequiredChecksKey) }
+)
+
+// HealthCheckResult is the outcome of one readiness check.
+type HealthCheckResult struct {
+	Name      string  `json:"name"`
+	OK        bool    `json:"ok"`
+	Required  bool    `json:"required"`
+	LatencyMS float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// Readiness is the report /health/ready serves. Ready is false when any
+// required check failed.
+type Readiness struct {
+	Ready  bool                `json:"ready"`
+	Checks []HealthCheckResult `json:"checks"`
+}
+
+// CheckReadiness runs every readiness check, timing each, and marks the
+// ones the health_required_checks setting names as required.
+func CheckReadiness() Readiness {
+	required := ParseHealthChecks(healthRequired())
+	probes := map[string]func() error{
+		HealthCheckDatabase: healthDatabase,
+		HealthCheckCoder:    healthCoder,
+		HealthCheckDataDir:  healthDataDir,
+	}
+
+	report := Readiness{Ready: true}
+	for _, name := range HealthChecks {
+		start := time.Now()
+		err := probes[name]()
+		result := HealthCheckResult{
+			Name:      name,
+			OK:        err == nil,
+			Required:  slices.Contains(required, name),
+			LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+		}
+		if err != nil {
+			result.Error = err.Error()
+			if result.Required {
+				report.Ready = false
+			}
+		}
+		report.Checks = append(report.Checks, result)
+	}
+	return report
+}
+
+// ParseHealthChecks splits a comma-separated list of check names,
+// dropping blanks and names that aren't readiness checks.
+func ParseHealthChecks(value string) []string {
+	var names []string
+	for _, name := range strings.Split(value, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if slices.Contains(HealthChecks, name) && !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// checkHealthChecks validates the health_required_checks setting: every
+// name must be a readiness check, or "none" to make them all informational.
+func checkHealthChecks(value string) error {
+	for _, name := range strings.Split(value, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name != "" && name != "none" && !slices.Contains(HealthChecks, name) {
+			return fmt.Errorf("unknown health check %q", name)
+		}
+	}
+	return nil
+}
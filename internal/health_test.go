@@ -0,0 +1,80 @@
+package internal
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/The-Skyscape/devtools/pkg/testutils"
+)
+
+// fakeHealth replaces the readiness probes until the test ends. Probes
+// named in failing return an error; required is the setting's value.
+func fakeHealth(t *testing.T, required string, failing ...string) {
+	db, coder, dataDir, req := healthDatabase, healthCoder, healthDataDir, healthRequired
+	t.Cleanup(func() { healthDatabase, healthCoder, healthDataDir, healthRequired = db, coder, dataDir, req })
+
+	probe := func(name string) func() error {
+		return func() error {
+			for _, f := range failing {
+				if f == name {
+					return errors.New(name + " down")
+				}
+			}
+			return nil
+		}
+	}
+	healthDatabase = probe(HealthCheckDatabase)
+	healthCoder = probe(HealthCheckCoder)
+	healthDataDir = probe(HealthCheckDataDir)
+	healthRequired = func() string { return required }
+}
+
+func TestCheckReadiness(t *testing.T) {
+	tests := []struct {
+		name     string
+		required string
+		failing  []string
+		ready    bool
+	}{
+		{"all pass", "database,coder,datadir", nil, true},
+		{"required fails", "database,coder,datadir", []string{HealthCheckCoder}, false},
+		{"informational fails", "database,datadir", []string{HealthCheckCoder}, true},
+		{"none required", "none", []string{HealthCheckDatabase, HealthCheckDataDir}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeHealth(t, tt.required, tt.failing...)
+			report := CheckReadiness()
+			testutils.AssertEqual(t, tt.ready, report.Ready)
+			testutils.AssertEqual(t, len(HealthChecks), len(report.Checks))
+			for i, check := range report.Checks {
+				testutils.AssertEqual(t, HealthChecks[i], check.Name)
+				testutils.AssertEqual(t, check.Error == "", check.OK)
+			}
+		})
+	}
+}
+
+func TestCheckReadinessReportsFailure(t *testing.T) {
+	fakeHealth(t, "coder", HealthCheckDataDir)
+	report := CheckReadiness()
+
+	datadir := report.Checks[2]
+	testutils.AssertEqual(t, HealthCheckDataDir, datadir.Name)
+	testutils.AssertEqual(t, false, datadir.OK)
+	testutils.AssertEqual(t, false, datadir.Required)
+	testutils.AssertEqual(t, "datadir down", datadir.Error)
+	testutils.AssertEqual(t, true, report.Checks[1].Required)
+}
+
+func TestParseHealthChecks(t *testing.T) {
+	got := ParseHealthChecks(" Coder, database,,bogus,coder ")
+	testutils.AssertEqual(t, 2, len(got))
+	testutils.AssertEqual(t, HealthCheckCoder, got[0])
+	testutils.AssertEqual(t, HealthCheckDatabase, got[1])
+	testutils.AssertEqual(t, 0, len(ParseHealthChecks("none")))
+
+	testutils.AssertEqual(t, nil, checkHealthChecks("database, datadir"))
+	testutils.AssertEqual(t, nil, checkHealthChecks("none"))
+	testutils.AssertEqual(t, true, checkHealthChecks("database,commander") != nil)
+}
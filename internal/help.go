@@ -0,0 +1,197 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+	"workbench/models"
+	"workbench/services"
+)
+
+// Error kinds with a troubleshooting page at GET /help/{kind}.
+const (
+	ErrorKindAuth      = "auth"
+	ErrorKindConflict  = "conflict"
+	ErrorKindCoderDown = "coder-down"
+)
+
+// ErrCoderNotRunning is returned by operations that need the coder container.
+var ErrCoderNotRunning = errors.New("coder service is not running")
+
+// ClassifyError returns the kind of error a message describes, or empty
+// string when there's no troubleshooting page for it.
+func ClassifyError(message string) string {
+	message = strings.ToLower(message)
+	switch {
+	case strings.Contains(message, "authentication failed"):
+		return ErrorKindAuth
+	case strings.Contains(message, "merge conflict"), strings.Contains(message, "conflict ("):
+		return ErrorKindConflict
+	case strings.Contains(message, "coder service not running"), strings.Contains(message, "coder service is not running"),
+		strings.Contains(message, "coder service not initialized"), strings.Contains(message, "coder service not ready"):
+		return ErrorKindCoderDown
+	default:
+		return ""
+	}
+}
+
+// HelpContext holds the live checks shown on a troubleshooting page.
+// Only the fields for its Kind are filled in.
+type HelpContext struct {
+	Kind string
+	Repo string // Repository the error was about, if any
+
+	// Authentication failures
+	HasSSHKey  bool
+	SSHHost    string         // Empty for HTTPS remotes or without a repository
+	HostStatus *SSHHostStatus // Nil without an SSH host
+	HTTPS      bool           // The remote uses HTTPS, so SSH keys don't apply
+
+	// Merge conflicts
+	RepoPath        string
+	ConflictedFiles []string
+	ConflictError   string // Set when the files couldn't be listed
+
+	// Coder not running
+	Coder      *services.ContainerState
+	CoderError string // Set when the container couldn't be inspected
+}
+
+// Live checks run when a help page renders. Replaced in tests.
+var (
+	helpHasSSHKey  = HasSSHKey
+	helpSSHStatus  = readSSHHostStatus
+	helpExec       = services.CoderExec
+	helpCoderState = services.CoderContainerState
+	helpFindRepo   = func(name string) (*models.Repository, error) {
+		return models.Repositories.Find("WHERE Name = ?", name)
+	}
+)
+
+// GetHelpContext runs the live checks for an error kind, optionally about
+// a repository. Nothing is recorded or changed, so help is always safe to
+// open.
+func GetHelpContext(kind, repoName string) (*HelpContext, error) {
+	var repo *models.Repository
+	if repoName != "" {
+		found, err := helpFindRepo(repoName)
+		if err != nil {
+			return nil, fmt.Errorf("repository '%s' not found", repoName)
+		}
+		repo = found
+	}
+	return buildHelpContext(kind, repo)
+}
+
+func buildHelpContext(kind string, repo *models.Repository) (*HelpContext, error) {
+	help := &HelpContext{Kind: kind}
+	if repo != nil {
+		help.Repo = repo.Name
+	}
+
+	switch kind {
+	case ErrorKindAuth:
+		help.HasSSHKey = helpHasSSHKey()
+		if repo != nil {
+			help.SSHHost = SSHHost(repo.URL)
+			help.HTTPS = strings.HasPrefix(repo.URL, "https://") || strings.HasPrefix(repo.URL, "http://")
+		}
+		if help.SSHHost != "" {
+			help.HostStatus = helpSSHStatus(help.SSHHost)
+		}
+
+	case ErrorKindConflict:
+		if repo == nil {
+			break
+		}
+		help.RepoPath = repo.LocalPath
+		files, err := ConflictedFiles(repo)
+		if err != nil {
+			help.ConflictError = err.Error()
+		}
+		help.ConflictedFiles = files
+
+	case ErrorKindCoderDown:
+		state, err := helpCoderState()
+		if err != nil {
+			help.CoderError = err.Error()
+		}
+		help.Coder = state
+
+	default:
+		return nil, fmt.Errorf("no help for '%s' errors", kind)
+	}
+	return help, nil
+}
+
+// ConflictedFiles lists the unmerged paths in a repository, relative to
+// its root.
+func ConflictedFiles(repo *models.Repository) ([]string, error) {
+	output, err := helpExec(fmt.Sprintf("cd %s && git diff --name-only --diff-filter=U 2>&1", shellQuote(repo.LocalPath)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list conflicted files: %s", strings.TrimSpace(output))
+	}
+
+	var files []string
+	for _, line := range strings.Split(output, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			files = append(files, line)
+		}
+	}
+	return files, nil
+}
+
+// CoderFolderURL returns the VS Code link opening the repository folder.
+func (h *HelpContext) CoderFolderURL() string {
+	return "/coder/?folder=" + url.QueryEscape(h.RepoPath)
+}
+
+// RestartCoder restarts the coder container from its troubleshooting page,
+// recording the attempt as coder_restart or coder_restart_failed.
+func RestartCoder() error {
+	if err := services.CoderRestart(); err != nil {
+		go models.Activities.Insert(&models.Activity{
+			Type:        "coder_restart_failed",
+			Repository:  "",
+			Description: fmt.Sprintf("Failed to restart the coder container: %v", err),
+			Author:      "System",
+			Timestamp:   time.Now(),
+		})
+		return fmt.Errorf("failed to restart the coder container: %w", err)
+	}
+
+	go models.Activities.Insert(&models.Activity{
+		Type:        "coder_restart",
+		Repository:  "",
+		Description: "Restarted the coder container",
+		Author:      "System",
+		Timestamp:   time.Now(),
+	})
+	return nil
+}
+
+// StartCoderHealthCheck checks code-server every
+// services.CoderHealthInterval as the coder-health-check loop job,
+// recording automatic restarts as coder_auto_restart or
+// coder_restart_failed. Runs until the process exits.
+func StartCoderHealthCheck() {
+	services.SetCoderHealthRecorder(func(activityType, description string) {
+		go models.Activities.Insert(&models.Activity{
+			Type:        activityType,
+			Repository:  "",
+			Description: description,
+			Author:      "System",
+			Timestamp:   time.Now(),
+		})
+	})
+
+	job := RegisterLoop("coder-health-check", "Check VS Code answers and restart it when it stops", services.CoderHealthInterval)
+	ticker := time.NewTicker(services.CoderHealthInterval)
+	for now := range ticker.C {
+		if job.RunStarted() {
+			job.RunFinished(services.CheckCoderHealth(now))
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package internal
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"workbench/models"
+	"workbench/services"
+
+	"github.com/The-Skyscape/devtools/pkg/testutils"
+)
+
+// fakeHelpChecks replaces the live checks for the duration of a test.
+func fakeHelpChecks(t *testing.T) {
+	hasKey, status, exec, state := helpHasSSHKey, helpSSHStatus, helpExec, helpCoderState
+	t.Cleanup(func() {
+		helpHasSSHKey, helpSSHStatus, helpExec, helpCoderState = hasKey, status, exec, state
+	})
+}
+
+func TestClassifyError(t *testing.T) {
+	testCases := []struct {
+		message  string
+		expected string
+	}{
+		{"authentication failed - for private repos, add your SSH key to the git provider", ErrorKindAuth},
+		{"authentication failed - check your SSH key is added to the git provider", ErrorKindAuth},
+		{"merge conflicts detected - resolve manually in VS Code", ErrorKindConflict},
+		{"reset failed: CONFLICT (content): Merge conflict in main.go", ErrorKindConflict},
+		{ErrCoderNotRunning.Error(), ErrorKindCoderDown},
+		{"coder service not initialized", ErrorKindCoderDown},
+		{"coder service not ready yet - the VS Code server is still starting", ErrorKindCoderDown},
+		{"repository not found - check the URL is correct", ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.message, func(t *testing.T) {
+			testutils.AssertEqual(t, tc.expected, ClassifyError(tc.message))
+		})
+	}
+}
+
+func TestPresentErrorLinksHelp(t *testing.T) {
+	presented := PresentError(errors.New("merge conflicts detected - resolve manually in VS Code"))
+	testutils.AssertEqual(t, ErrorKindConflict, presented.Kind)
+	testutils.AssertEqual(t, "/help/conflict", presented.HelpURL())
+
+	presented.Repo = "my api"
+	testutils.AssertEqual(t, "/help/conflict?repo=my+api", presented.HelpURL())
+
+	testutils.AssertEqual(t, "", PresentError(errors.New("invalid interval")).HelpURL())
+}
+
+func TestHelpContextAuth(t *testing.T) {
+	fakeHelpChecks(t)
+	helpHasSSHKey = func() bool { return true }
+	helpSSHStatus = func(host string) *SSHHostStatus { return &SSHHostStatus{Host: host, Name: "GitHub"} }
+
+	help, err := buildHelpContext(ErrorKindAuth, &models.Repository{Name: "api", URL: "git@github.com:acme/api.git"})
+	testutils.AssertEqual(t, nil, err)
+	testutils.AssertEqual(t, true, help.HasSSHKey)
+	testutils.AssertEqual(t, "github.com", help.SSHHost)
+	testutils.AssertEqual(t, false, help.HostStatus.Verified)
+	testutils.AssertEqual(t, false, help.HTTPS)
+
+	help, err = buildHelpContext(ErrorKindAuth, &models.Repository{Name: "web", URL: "https://github.com/acme/web.git"})
+	testutils.AssertEqual(t, nil, err)
+	testutils.AssertEqual(t, true, help.HTTPS)
+	testutils.AssertEqual(t, true, help.HostStatus == nil)
+
+	// Without a repository only the key is checked
+	helpHasSSHKey = func() bool { return false }
+	help, err = buildHelpContext(ErrorKindAuth, nil)
+	testutils.AssertEqual(t, nil, err)
+	testutils.AssertEqual(t, false, help.HasSSHKey)
+	testutils.AssertEqual(t, "", help.SSHHost)
+}
+
+func TestHelpContextConflict(t *testing.T) {
+	fakeHelpChecks(t)
+	var command string
+	helpExec = func(cmd string) (string, error) {
+		command = cmd
+		return "main.go\ndocs/README.md\n", nil
+	}
+
+	repo := &models.Repository{Name: "api", LocalPath: "/home/coder/repos/api"}
+	help, err := buildHelpContext(ErrorKindConflict, repo)
+	testutils.AssertEqual(t, nil, err)
+	testutils.AssertEqual(t, "main.go,docs/README.md", strings.Join(help.ConflictedFiles, ","))
+	testutils.AssertEqual(t, "/coder/?folder=%2Fhome%2Fcoder%2Frepos%2Fapi", help.CoderFolderURL())
+	testutils.AssertEqual(t, true, strings.Contains(command, "--diff-filter=U"))
+
+	helpExec = func(cmd string) (string, error) { return "fatal: not a git repository", errors.New("exit status 128") }
+	help, err = buildHelpContext(ErrorKindConflict, repo)
+	testutils.AssertEqual(t, nil, err)
+	testutils.AssertEqual(t, "failed to list conflicted files: fatal: not a git repository", help.ConflictError)
+}
+
+func TestHelpContextCoderDown(t *testing.T) {
+	fakeHelpChecks(t)
+	helpCoderState = func() (*services.ContainerState, error) {
+		return &services.ContainerState{Status: "exited", ExitCode: 137}, nil
+	}
+
+	help, err := buildHelpContext(ErrorKindCoderDown, nil)
+	testutils.AssertEqual(t, nil, err)
+	testutils.AssertEqual(t, "exited", help.Coder.Status)
+	testutils.AssertEqual(t, 137, help.Coder.ExitCode)
+
+	helpCoderState = func() (*services.ContainerState, error) { return nil, errors.New("docker is unavailable") }
+	help, err = buildHelpContext(ErrorKindCoderDown, nil)
+	testutils.AssertEqual(t, nil, err)
+	testutils.AssertEqual(t, "docker is unavailable", help.CoderError)
+}
+
+func TestHelpContextUnknownKind(t *testing.T) {
+	_, err := buildHelpContext("disk-full", nil)
+	testutils.AssertEqual(t, "no help for 'disk-full' errors", err.Error())
+}
@@ -0,0 +1,249 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+	"workbench/models"
+)
+
+// OtherHost groups repositories whose URL has no recognizable host,
+// such as local-only projects.
+const OtherHost = "other"
+
+// RepoHost derives the Git host from a clone URL, without user or port.
+// Handles https:// and ssh:// URLs, scp-style URLs (git@host:user/repo),
+// and ports (ssh://git@host:2222/repo). Returns OtherHost when no host
+// can be parsed.
+func RepoHost(rawURL string) string {
+	rawURL = strings.TrimSpace(rawURL)
+	var host string
+	if strings.Contains(rawURL, "://") {
+		if u, err := url.Parse(rawURL); err == nil {
+			host = u.Hostname()
+		}
+	} else if before, _, found := strings.Cut(rawURL, ":"); found && !strings.Contains(before, "/") {
+		// scp-style: [user@]host:path
+		if at := strings.LastIndex(before, "@"); at >= 0 {
+			before = before[at+1:]
+		}
+		host = before
+	}
+
+	host = strings.ToLower(host)
+	if host == "" || !validHost.MatchString(host) {
+		return OtherHost
+	}
+	return host
+}
+
+// BackfillRepositoryHosts sets Host on repositories cloned before hosts
+// were recorded. Safe to run on every startup.
+func BackfillRepositoryHosts() {
+	repos, err := models.Repositories.Search("WHERE Host = '' OR Host IS NULL")
+	if err != nil {
+		slog.Error("failed to load repositories for host backfill", "error", err)
+		return
+	}
+	for _, repo := range repos {
+		repo.Host = RepoHost(repo.URL)
+		if err := models.Repositories.Update(repo); err != nil {
+			slog.Error("failed to backfill host", "name", repo.Name, "error", err)
+		}
+	}
+}
+
+// RepoPullStatus is the outcome of a repository's most recent pulls.
+type RepoPullStatus struct {
+	Failed   bool      // The latest pull attempt failed
+	LastPull time.Time // Last successful pull, zero if never
+}
+
+// HostGroup is the set of repositories cloned from one host.
+type HostGroup struct {
+	Host           string
+	Repos          []*models.Repository
+	Errors         int       // Repositories whose latest pull failed, not counting missing ones
+	Missing        int       // Repositories whose directory is missing
+	LastPull       time.Time // Most recent successful pull across the group
+	SSH            bool      // At least one repository is cloned over SSH
+	AutoPullPaused bool
+}
+
+// RepositoriesByHost groups all repositories by host, with aggregate pull
+// status for each group.
+func RepositoriesByHost() ([]*HostGroup, error) {
+	repos, err := models.Repositories.Search("ORDER BY Name ASC")
+	if err != nil {
+		return nil, fmt.Errorf("failed to load repositories")
+	}
+	return groupRepositories(repos, loadPullStatuses(), AutoPullPaused), nil
+}
+
+// loadPullStatuses reads every repository's pull history from the
+// activity log in one query: the latest success and the latest failure
+// for each repository.
+func loadPullStatuses() map[string]RepoPullStatus {
+	types := "'" + strings.Join(pullActivityTypes, "','") + "','repo_pull_failed'"
+	latest, err := models.Activities.Search(`WHERE ID IN (
+		SELECT ID FROM (
+			SELECT ID, ROW_NUMBER() OVER (
+				PARTITION BY Repository, Type = 'repo_pull_failed' ORDER BY Timestamp DESC) AS Rank
+			FROM activities WHERE Type IN (` + types + `)
+		) WHERE Rank = 1)`)
+	if err != nil {
+		slog.Error("failed to load pull history", "error", err)
+	}
+	return pullStatuses(latest)
+}
+
+// pullStatuses folds pull activities into a status per repository. The
+// latest pull failed if a failure is newer than every success.
+func pullStatuses(activities []*models.Activity) map[string]RepoPullStatus {
+	statuses := map[string]RepoPullStatus{}
+	failures := map[string]time.Time{}
+	for _, a := range activities {
+		status := statuses[a.Repository]
+		if a.Type == "repo_pull_failed" {
+			if a.Timestamp.After(failures[a.Repository]) {
+				failures[a.Repository] = a.Timestamp
+			}
+		} else if a.Timestamp.After(status.LastPull) {
+			status.LastPull = a.Timestamp
+		}
+		statuses[a.Repository] = status
+	}
+	for name, failed := range failures {
+		status := statuses[name]
+		status.Failed = failed.After(status.LastPull)
+		statuses[name] = status
+	}
+	return statuses
+}
+
+// groupRepositories buckets repositories by Host, deriving it from the URL
+// when not yet recorded. Groups are sorted by host with OtherHost last.
+func groupRepositories(repos []*models.Repository, statuses map[string]RepoPullStatus, paused func(host string) bool) []*HostGroup {
+	groups := map[string]*HostGroup{}
+	for _, repo := range repos {
+		host := repo.Host
+		if host == "" {
+			host = RepoHost(repo.URL)
+		}
+
+		group, ok := groups[host]
+		if !ok {
+			group = &HostGroup{Host: host, AutoPullPaused: paused(host)}
+			groups[host] = group
+		}
+		group.Repos = append(group.Repos, repo)
+		if SSHHost(repo.URL) != "" {
+			group.SSH = true
+		}
+
+		status := statuses[repo.Name]
+		if repo.Missing {
+			group.Missing++
+		} else if status.Failed {
+			group.Errors++
+		}
+		if status.LastPull.After(group.LastPull) {
+			group.LastPull = status.LastPull
+		}
+	}
+
+	sorted := make([]*HostGroup, 0, len(groups))
+	for _, group := range groups {
+		sorted = append(sorted, group)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if (sorted[i].Host == OtherHost) != (sorted[j].Host == OtherHost) {
+			return sorted[j].Host == OtherHost
+		}
+		return sorted[i].Host < sorted[j].Host
+	})
+	return sorted
+}
+
+// pullRepo pulls one repository. Replaced in tests.
+var pullRepo = func(name string) error { return PullRepository(context.Background(), name) }
+
+// StartHostPull pulls every repository cloned from a host as a background
+// task, continuing past failures and reporting each result as it goes.
+// Local-only repositories have nothing to pull and are skipped.
+func StartHostPull(host string) (*Task, error) {
+	repos, err := models.Repositories.Search("WHERE Host = ? AND URL != '' ORDER BY Name ASC", host)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load repositories for %s", host)
+	}
+	if len(repos) == 0 {
+		return nil, fmt.Errorf("no repositories to pull on %s", host)
+	}
+
+	task := newTask("", "pull all on "+host)
+	task.RefreshRepos = true
+	task.start(func(onLine func(string) error) error {
+		return pullAll(host, repos, onLine)
+	}, nil)
+	return task, nil
+}
+
+// pullAll pulls each repository in turn, writing one line per result.
+// Returns an error summarizing failures.
+func pullAll(host string, repos []*models.Repository, onLine func(string) error) error {
+	var failed []string
+	for _, repo := range repos {
+		if err := pullRepo(repo.Name); err != nil {
+			failed = append(failed, repo.Name)
+			onLine(fmt.Sprintf("%s: %v", repo.Name, err))
+			continue
+		}
+		onLine(repo.Name + ": pulled")
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("pulled %d of %d repositories on %s; failed: %s",
+			len(repos)-len(failed), len(repos), host, strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// AutoPullPaused reports whether automatic pulls are paused for a host.
+// Background pulls must check this before pulling a repository.
+func AutoPullPaused(host string) bool {
+	value, _ := models.GetSetting(autoPullPausedKey(host))
+	return value == "true"
+}
+
+// SetAutoPullPaused pauses or resumes automatic pulls for a host, e.g.
+// while the provider has an outage.
+func SetAutoPullPaused(host string, paused bool) error {
+	if host != OtherHost && !validHost.MatchString(host) {
+		return fmt.Errorf("invalid host: %s", host)
+	}
+
+	if _, err := models.SetSetting(autoPullPausedKey(host), fmt.Sprint(paused), "autopull"); err != nil {
+		return fmt.Errorf("failed to save auto-pull setting")
+	}
+
+	activityType, action := "autopull_resumed", "Resumed"
+	if paused {
+		activityType, action = "autopull_paused", "Paused"
+	}
+	go models.Activities.Insert(&models.Activity{
+		Type:        activityType,
+		Repository:  "",
+		Description: fmt.Sprintf("%s auto-pull for %s", action, host),
+		Author:      "System",
+		Timestamp:   time.Now(),
+	})
+	return nil
+}
+
+func autoPullPausedKey(host string) string {
+	return "autopull_paused:" + host
+}
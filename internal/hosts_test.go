@@ -0,0 +1,152 @@
+package internal
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+	"workbench/models"
+
+	"github.com/The-Skyscape/devtools/pkg/testutils"
+)
+
+func TestRepoHost(t *testing.T) {
+	testCases := []struct {
+		url      string
+		expected string
+	}{
+		{"https://github.com/user/repo.git", "github.com"},
+		{"https://GitHub.com/user/repo", "github.com"},
+		{"https://gitlab.example.com:8443/group/sub/repo.git", "gitlab.example.com"},
+		{"https://token@codeberg.org/user/repo.git", "codeberg.org"},
+		{"ssh://git@gitlab.example.com/group/repo.git", "gitlab.example.com"},
+		{"ssh://git@gitlab.example.com:2222/group/repo.git", "gitlab.example.com"},
+		{"git@github.com:user/repo.git", "github.com"},
+		{"gitlab.example.com:group/repo.git", "gitlab.example.com"},
+		{"  git@codeberg.org:user/repo.git  ", "codeberg.org"},
+		{"", OtherHost},
+		{"/home/coder/repos/local", OtherHost},
+		{"file:///srv/git/repo.git", OtherHost},
+		{"not a url", OtherHost},
+		{"git@bad host:user/repo", OtherHost},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.url, func(t *testing.T) {
+			testutils.AssertEqual(t, tc.expected, RepoHost(tc.url))
+		})
+	}
+}
+
+func groupSummary(groups []*HostGroup) string {
+	var parts []string
+	for _, g := range groups {
+		var names []string
+		for _, r := range g.Repos {
+			names = append(names, r.Name)
+		}
+		parts = append(parts, g.Host+"="+strings.Join(names, ","))
+	}
+	return strings.Join(parts, " ")
+}
+
+func TestGroupRepositories(t *testing.T) {
+	older := time.Date(2025, 1, 1, 9, 0, 0, 0, time.UTC)
+	newer := time.Date(2025, 1, 2, 9, 0, 0, 0, time.UTC)
+
+	repos := []*models.Repository{
+		{Name: "api", URL: "git@github.com:acme/api.git", Host: "github.com"},
+		{Name: "docs", URL: "https://codeberg.org/acme/docs.git", Host: "codeberg.org"},
+		{Name: "local", Host: OtherHost},
+		{Name: "gone", URL: "git@github.com:acme/gone.git", Host: "github.com", Missing: true},
+		{Name: "web", URL: "https://github.com/acme/web.git"}, // not yet backfilled
+		{Name: "weird", URL: "???"},
+	}
+	statuses := map[string]RepoPullStatus{
+		"api":  {Failed: true, LastPull: older},
+		"gone": {Failed: true, LastPull: older},
+		"web":  {LastPull: newer},
+	}
+	paused := func(host string) bool { return host == "codeberg.org" }
+
+	groups := groupRepositories(repos, statuses, paused)
+	testutils.AssertEqual(t, "codeberg.org=docs github.com=api,gone,web other=local,weird", groupSummary(groups))
+
+	codeberg, github, other := groups[0], groups[1], groups[2]
+	testutils.AssertEqual(t, true, codeberg.AutoPullPaused)
+	testutils.AssertEqual(t, true, codeberg.LastPull.IsZero())
+	testutils.AssertEqual(t, false, codeberg.SSH)
+
+	testutils.AssertEqual(t, 1, github.Errors) // Missing repositories are counted separately
+	testutils.AssertEqual(t, 1, github.Missing)
+	testutils.AssertEqual(t, newer, github.LastPull)
+	testutils.AssertEqual(t, true, github.SSH)
+	testutils.AssertEqual(t, false, github.AutoPullPaused)
+
+	testutils.AssertEqual(t, 0, other.Errors)
+}
+
+func TestPullStatuses(t *testing.T) {
+	t0 := time.Date(2025, 1, 1, 9, 0, 0, 0, time.UTC)
+	activities := []*models.Activity{
+		{Type: "repo_pull", Repository: "api", Timestamp: t0},
+		{Type: "repo_pull_failed", Repository: "api", Timestamp: t0.Add(time.Hour)},
+		{Type: "repo_pull_failed", Repository: "web", Timestamp: t0},
+		{Type: "repo_autopull", Repository: "web", Timestamp: t0.Add(time.Hour)},
+		{Type: "repo_pull_failed", Repository: "docs", Timestamp: t0},
+	}
+
+	statuses := pullStatuses(activities)
+	testutils.AssertEqual(t, RepoPullStatus{Failed: true, LastPull: t0}, statuses["api"])
+	testutils.AssertEqual(t, RepoPullStatus{Failed: false, LastPull: t0.Add(time.Hour)}, statuses["web"])
+	testutils.AssertEqual(t, RepoPullStatus{Failed: true}, statuses["docs"])
+}
+
+func TestPullAll(t *testing.T) {
+	original := pullRepo
+	pullRepo = func(name string) error {
+		if name == "web" {
+			return errors.New("authentication failed")
+		}
+		return nil
+	}
+	t.Cleanup(func() { pullRepo = original })
+
+	var lines []string
+	repos := []*models.Repository{{Name: "api"}, {Name: "web"}, {Name: "docs"}}
+	err := pullAll("github.com", repos, func(line string) error {
+		lines = append(lines, line)
+		return nil
+	})
+
+	testutils.AssertEqual(t, "api: pulled; web: authentication failed; docs: pulled", strings.Join(lines, "; "))
+	testutils.AssertEqual(t, "pulled 2 of 3 repositories on github.com; failed: web", err.Error())
+}
+
+func TestAutoPullTargets(t *testing.T) {
+	repos := []*models.Repository{
+		{Name: "api", URL: "git@github.com:acme/api.git", Host: "github.com"},
+		{Name: "docs", URL: "https://codeberg.org/acme/docs.git", Host: "codeberg.org"},
+		{Name: "local", Host: OtherHost},
+		{Name: "web", URL: "https://github.com/acme/web.git"},
+		{Name: "big", URL: "https://github.com/acme/big.git", Host: "github.com", CloneStatus: models.CloneCloning},
+	}
+	asked := 0
+	paused := func(host string) bool {
+		asked++
+		return host == "codeberg.org"
+	}
+
+	var names []string
+	for _, repo := range autoPullTargets(repos, paused) {
+		names = append(names, repo.Name)
+	}
+	testutils.AssertEqual(t, "api,web", strings.Join(names, ","))
+	testutils.AssertEqual(t, 2, asked)
+}
+
+func TestParseAutoPullInterval(t *testing.T) {
+	testutils.AssertEqual(t, time.Duration(0), parseAutoPullInterval(""))
+	testutils.AssertEqual(t, time.Duration(0), parseAutoPullInterval("-5"))
+	testutils.AssertEqual(t, time.Hour, parseAutoPullInterval("60"))
+}
@@ -0,0 +1,98 @@
+package internal
+
+import (
+	"sort"
+	"sync"
+	"time"
+	"workbench/models"
+)
+
+// Latency buckets shown in the dashboard's response time panel.
+const (
+	LatencyDashboard = "dashboard" // Dashboard page and the partials it polls
+	LatencyCoder     = "coder"     // Requests proxied to VS Code
+)
+
+// latencySamples is how many recent requests each bucket keeps.
+const latencySamples = 500
+
+// LatencyStats summarizes recent time-to-first-byte for a bucket.
+type LatencyStats struct {
+	Bucket   string
+	Requests int64 // Since startup
+	P50      time.Duration
+	P95      time.Duration
+}
+
+type latencyWindow struct {
+	samples []time.Duration // Ring buffer of the most recent requests
+	next    int
+	total   int64
+}
+
+var latencies = struct {
+	sync.Mutex
+	buckets map[string]*latencyWindow
+}{buckets: map[string]*latencyWindow{}}
+
+// RecordLatency records how long a request took to start responding.
+func RecordLatency(bucket string, d time.Duration) {
+	latencies.Lock()
+	defer latencies.Unlock()
+
+	window := latencies.buckets[bucket]
+	if window == nil {
+		window = &latencyWindow{}
+		latencies.buckets[bucket] = window
+	}
+	if len(window.samples) < latencySamples {
+		window.samples = append(window.samples, d)
+	} else {
+		window.samples[window.next] = d
+		window.next = (window.next + 1) % latencySamples
+	}
+	window.total++
+}
+
+// GetLatencyStats returns the dashboard and coder buckets, in that order,
+// omitting buckets without requests yet.
+func GetLatencyStats() []LatencyStats {
+	latencies.Lock()
+	defer latencies.Unlock()
+
+	var stats []LatencyStats
+	for _, bucket := range []string{LatencyDashboard, LatencyCoder} {
+		window := latencies.buckets[bucket]
+		if window == nil {
+			continue
+		}
+		sorted := append([]time.Duration(nil), window.samples...)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+		stats = append(stats, LatencyStats{
+			Bucket:   bucket,
+			Requests: window.total,
+			P50:      percentile(sorted, 50),
+			P95:      percentile(sorted, 95),
+		})
+	}
+	return stats
+}
+
+// percentile returns the nearest-rank percentile of sorted durations,
+// rounded for display.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1].Round(100 * time.Microsecond)
+}
+
+// ProxyBufferSize returns the coder proxy's response buffer size in bytes
+// from the coder_proxy_buffer setting.
+func ProxyBufferSize() int {
+	return models.GetIntSetting("coder_proxy_buffer")
+}
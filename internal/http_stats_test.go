@@ -0,0 +1,33 @@
+package internal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/The-Skyscape/devtools/pkg/testutils"
+)
+
+func TestLatencyStats(t *testing.T) {
+	for i := 1; i <= 100; i++ {
+		RecordLatency(LatencyCoder, time.Duration(i)*time.Millisecond)
+	}
+	RecordLatency(LatencyDashboard, 3*time.Millisecond)
+
+	stats := GetLatencyStats()
+	testutils.AssertEqual(t, 2, len(stats))
+	testutils.AssertEqual(t, LatencyDashboard, stats[0].Bucket)
+	testutils.AssertEqual(t, 3*time.Millisecond, stats[0].P95)
+
+	coder := stats[1]
+	testutils.AssertEqual(t, int64(100), coder.Requests)
+	testutils.AssertEqual(t, 50*time.Millisecond, coder.P50)
+	testutils.AssertEqual(t, 95*time.Millisecond, coder.P95)
+
+	// Only the most recent requests count toward percentiles
+	for i := 0; i < latencySamples; i++ {
+		RecordLatency(LatencyCoder, time.Millisecond)
+	}
+	coder = GetLatencyStats()[1]
+	testutils.AssertEqual(t, int64(100+latencySamples), coder.Requests)
+	testutils.AssertEqual(t, time.Millisecond, coder.P95)
+}
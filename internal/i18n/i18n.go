@@ -0,0 +1,224 @@
+// Package i18n provides locale-aware formatting for dates and numbers.
+// Locales are resolved from an explicit user preference or the browser's
+// Accept-Language header. Only formatting is localized today; each locale
+// carries a message table so UI strings can be translated later without
+// changing how callers obtain a Locale.
+package i18n
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Locale describes how dates and numbers are presented for a language.
+type Locale struct {
+	Tag           string            // Primary language subtag, e.g. "en", "de"
+	Name          string            // Display name for preference pickers
+	TimeLayout    string            // Go layout for timestamps (day/month order, 12h vs 24h)
+	ClockLayout   string            // Go layout for a time of day, in relative timestamps
+	Decimal       string            // Decimal separator for fractional numbers
+	PercentSuffix string            // Suffix appended to percentages, e.g. "%" or " %"
+	Messages      map[string]string // Message translations, keyed by message ID
+}
+
+// DefaultTag is the locale used when nothing better can be negotiated.
+const DefaultTag = "en"
+
+// locales holds every supported locale keyed by language tag.
+var locales = map[string]*Locale{
+	"en": {Tag: "en", Name: "English", TimeLayout: "Jan 2, 3:04 PM", ClockLayout: "3:04 PM", Decimal: ".", PercentSuffix: "%"},
+	"de": {Tag: "de", Name: "Deutsch", TimeLayout: "02.01. 15:04", ClockLayout: "15:04", Decimal: ",", PercentSuffix: " %"},
+	"fr": {Tag: "fr", Name: "Français", TimeLayout: "02/01 15:04", ClockLayout: "15:04", Decimal: ",", PercentSuffix: " %"},
+	"es": {Tag: "es", Name: "Español", TimeLayout: "02/01 15:04", ClockLayout: "15:04", Decimal: ",", PercentSuffix: " %"},
+}
+
+// Supported returns all supported locales ordered by tag.
+func Supported() []*Locale {
+	result := make([]*Locale, 0, len(locales))
+	for _, l := range locales {
+		result = append(result, l)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Tag < result[j].Tag })
+	return result
+}
+
+// Lookup returns the locale for a language tag such as "de" or "de-AT".
+// Returns nil if the language is not supported.
+func Lookup(tag string) *Locale {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	if i := strings.IndexAny(tag, "-_"); i >= 0 {
+		tag = tag[:i]
+	}
+	return locales[tag]
+}
+
+// Resolve picks the locale for a request. An explicit preference wins,
+// then the highest weighted supported language in Accept-Language,
+// falling back to English.
+func Resolve(preference, acceptLanguage string) *Locale {
+	if l := Lookup(preference); l != nil {
+		return l
+	}
+	return ParseAcceptLanguage(acceptLanguage)
+}
+
+// maxTimezoneLength bounds timezone names taken from a cookie or header;
+// the longest IANA names are about 30 characters.
+const maxTimezoneLength = 64
+
+// ResolveTimezone picks the timezone timestamps are shown in: an explicit
+// preference wins, then the browser's timezone from the tz cookie, then
+// the X-User-Timezone header. Empty and unknown names are skipped,
+// falling back to UTC.
+func ResolveTimezone(preference, cookie, header string) *time.Location {
+	for _, name := range []string{preference, cookie, header} {
+		name = strings.TrimSpace(name)
+		if name == "" || len(name) > maxTimezoneLength {
+			continue
+		}
+		if loc, err := time.LoadLocation(name); err == nil {
+			return loc
+		}
+	}
+	return time.UTC
+}
+
+// ParseAcceptLanguage negotiates a locale from an Accept-Language header
+// value like "de-DE,de;q=0.9,en;q=0.8". Entries are weighted by their q
+// value and the first supported language wins.
+func ParseAcceptLanguage(header string) *Locale {
+	type candidate struct {
+		tag string
+		q   float64
+	}
+
+	var candidates []candidate
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		if fields[0] == "" {
+			continue
+		}
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if v, ok := strings.CutPrefix(param, "q="); ok {
+				if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+		candidates = append(candidates, candidate{fields[0], q})
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].q > candidates[j].q })
+	for _, c := range candidates {
+		if c.q <= 0 {
+			continue
+		}
+		if l := Lookup(c.tag); l != nil {
+			return l
+		}
+	}
+	return locales[DefaultTag]
+}
+
+// FormatTime formats a timestamp using the locale's layout.
+// The caller is responsible for converting to the user's timezone first.
+func (l *Locale) FormatTime(t time.Time) string {
+	return t.Format(l.TimeLayout)
+}
+
+// relativeDays is how many calendar days back FormatRelative names a
+// weekday before switching to FormatTime.
+const relativeDays = 7
+
+// FormatRelative formats a timestamp relative to now, e.g. "just now",
+// "3 minutes ago", "2 hours ago", "yesterday at 4:12 PM", or "Monday at
+// 4:12 PM", and with FormatTime when it's a week or more ago or in the
+// future. Days are calendar days in t's location, so a day a DST change
+// makes 23 or 25 hours long still counts as one. The caller converts t
+// and now to the user's timezone first.
+func (l *Locale) FormatRelative(t, now time.Time) string {
+	elapsed := now.Sub(t)
+	switch {
+	case elapsed < -time.Minute:
+		return l.FormatTime(t)
+	case elapsed < time.Minute:
+		return l.Message("just now")
+	case elapsed < time.Hour:
+		return l.plural(int(elapsed/time.Minute), "1 minute ago", "%d minutes ago")
+	}
+
+	days := calendarDay(now.In(t.Location())) - calendarDay(t)
+	switch {
+	case days == 0:
+		return l.plural(int(elapsed/time.Hour), "1 hour ago", "%d hours ago")
+	case days == 1:
+		return fmt.Sprintf(l.Message("yesterday at %s"), t.Format(l.ClockLayout))
+	case days < relativeDays:
+		return fmt.Sprintf(l.Message("%s at %s"), l.Message(t.Weekday().String()), t.Format(l.ClockLayout))
+	default:
+		return l.FormatTime(t)
+	}
+}
+
+// plural formats n with the singular message for 1 and plural otherwise.
+func (l *Locale) plural(n int, singular, plural string) string {
+	if n == 1 {
+		return l.Message(singular)
+	}
+	return fmt.Sprintf(l.Message(plural), n)
+}
+
+// calendarDay numbers the date of t in its own location, so subtracting
+// two gives the calendar days between them.
+func calendarDay(t time.Time) int {
+	y, m, d := t.Date()
+	return int(time.Date(y, m, d, 0, 0, 0, 0, time.UTC).Unix() / 86400)
+}
+
+// FormatDecimal formats a number with the given precision and the
+// locale's decimal separator.
+func (l *Locale) FormatDecimal(v float64, precision int) string {
+	s := strconv.FormatFloat(v, 'f', precision, 64)
+	if l.Decimal != "." {
+		s = strings.Replace(s, ".", l.Decimal, 1)
+	}
+	return s
+}
+
+// FormatPercent formats a percentage with one decimal place, e.g. "12.5%" or "12,5 %".
+func (l *Locale) FormatPercent(v float64) string {
+	return l.FormatDecimal(v, 1) + l.PercentSuffix
+}
+
+// FormatBytes converts bytes to human-readable format (KB, MB, GB, TB).
+// Uses binary prefixes (1024-based) and the locale's decimal separator.
+func (l *Locale) FormatBytes(bytes uint64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	div, exp := uint64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	units := []string{"KB", "MB", "GB", "TB", "PB"}
+	if exp >= len(units) {
+		exp = len(units) - 1
+	}
+	return l.FormatDecimal(float64(bytes)/float64(div), 1) + " " + units[exp]
+}
+
+// Message returns the translation for a message ID, or the ID itself
+// when the locale has no translation for it.
+func (l *Locale) Message(id string) string {
+	if msg, ok := l.Messages[id]; ok {
+		return msg
+	}
+	return id
+}
@@ -0,0 +1,164 @@
+package i18n
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/The-Skyscape/devtools/pkg/testutils"
+)
+
+func TestParseAcceptLanguage(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected string
+	}{
+		{"", "en"},
+		{"de-DE,de;q=0.9,en;q=0.8", "de"},
+		{"fr-CA", "fr"},
+		{"es", "es"},
+		{"ja,de;q=0.5", "de"},
+		{"en;q=0.3,fr;q=0.7", "fr"},
+		{"de;q=0,es;q=0.2", "es"},
+		{"ja,zh", "en"},
+	}
+
+	for _, tc := range testCases {
+		testutils.AssertEqual(t, tc.expected, ParseAcceptLanguage(tc.input).Tag)
+	}
+}
+
+func TestResolvePreferenceOverridesHeader(t *testing.T) {
+	testutils.AssertEqual(t, "es", Resolve("es", "de-DE").Tag)
+	testutils.AssertEqual(t, "de", Resolve("", "de-DE").Tag)
+	testutils.AssertEqual(t, "de", Resolve("xx", "de-DE").Tag)
+}
+
+func TestFormatTime(t *testing.T) {
+	ts := time.Date(2024, time.March, 5, 14, 7, 0, 0, time.UTC)
+	testCases := []struct {
+		tag      string
+		expected string
+	}{
+		{"en", "Mar 5, 2:07 PM"},
+		{"de", "05.03. 14:07"},
+		{"fr", "05/03 14:07"},
+		{"es", "05/03 14:07"},
+	}
+
+	for _, tc := range testCases {
+		testutils.AssertEqual(t, tc.expected, Lookup(tc.tag).FormatTime(ts))
+	}
+}
+
+func TestResolveTimezone(t *testing.T) {
+	testCases := []struct {
+		name       string
+		preference string
+		cookie     string
+		header     string
+		expected   string
+	}{
+		{"preference wins", "Asia/Tokyo", "Europe/Berlin", "America/New_York", "Asia/Tokyo"},
+		{"cookie before header", "", "Europe/Berlin", "America/New_York", "Europe/Berlin"},
+		{"header", "", "", "America/New_York", "America/New_York"},
+		{"nothing", "", "", "", "UTC"},
+		{"unknown cookie", "", "Mars/Olympus", "America/New_York", "America/New_York"},
+		{"path in cookie", "", "../../etc/passwd", "", "UTC"},
+		{"absolute path in cookie", "", "/etc/localtime", "", "UTC"},
+		{"overlong cookie", "", "Europe/" + strings.Repeat("x", 100), "", "UTC"},
+		{"padded cookie", "", " Europe/Berlin ", "", "Europe/Berlin"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			testutils.AssertEqual(t, tc.expected, ResolveTimezone(tc.preference, tc.cookie, tc.header).String())
+		})
+	}
+}
+
+func TestFormatRelative(t *testing.T) {
+	berlin, _ := time.LoadLocation("Europe/Berlin")
+	now := time.Date(2024, time.June, 12, 16, 30, 0, 0, berlin) // A Wednesday
+	testCases := []struct {
+		tag      string
+		t        time.Time
+		expected string
+	}{
+		{"en", now.Add(-20 * time.Second), "just now"},
+		{"en", now.Add(-time.Minute), "1 minute ago"},
+		{"en", now.Add(-3 * time.Minute), "3 minutes ago"},
+		{"en", now.Add(-2 * time.Hour), "2 hours ago"},
+		{"en", time.Date(2024, time.June, 12, 0, 5, 0, 0, berlin), "16 hours ago"},
+		{"en", time.Date(2024, time.June, 11, 23, 50, 0, 0, berlin), "yesterday at 11:50 PM"},
+		{"en", time.Date(2024, time.June, 11, 16, 12, 0, 0, berlin), "yesterday at 4:12 PM"},
+		{"de", time.Date(2024, time.June, 11, 16, 12, 0, 0, berlin), "yesterday at 16:12"},
+		{"en", time.Date(2024, time.June, 10, 9, 0, 0, 0, berlin), "Monday at 9:00 AM"},
+		{"en", time.Date(2024, time.June, 6, 9, 0, 0, 0, berlin), "Thursday at 9:00 AM"},
+		{"en", time.Date(2024, time.June, 5, 9, 0, 0, 0, berlin), "Jun 5, 9:00 AM"},
+		{"en", now.Add(time.Hour), "Jun 12, 5:30 PM"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.expected, func(t *testing.T) {
+			testutils.AssertEqual(t, tc.expected, Lookup(tc.tag).FormatRelative(tc.t, now))
+		})
+	}
+}
+
+func TestFormatRelativeAcrossDST(t *testing.T) {
+	newYork, _ := time.LoadLocation("America/New_York")
+	en := Lookup("en")
+
+	// Clocks sprang forward at 2 AM on March 10, 2024: 10 PM on the 9th to
+	// 10 PM on the 10th is 23 hours but still yesterday
+	springNow := time.Date(2024, time.March, 10, 22, 0, 0, 0, newYork)
+	testutils.AssertEqual(t, "yesterday at 10:00 PM", en.FormatRelative(time.Date(2024, time.March, 9, 22, 0, 0, 0, newYork), springNow))
+	// 1:30 AM EST to 3:30 AM EDT is one hour
+	testutils.AssertEqual(t, "1 hour ago", en.FormatRelative(time.Date(2024, time.March, 10, 1, 30, 0, 0, newYork),
+		time.Date(2024, time.March, 10, 3, 30, 0, 0, newYork)))
+
+	// Clocks fell back at 2 AM on November 3, 2024: 11 PM on the 2nd to
+	// 10:30 PM on the 3rd is 24.5 hours but still yesterday
+	fallNow := time.Date(2024, time.November, 3, 22, 30, 0, 0, newYork)
+	testutils.AssertEqual(t, "yesterday at 11:00 PM", en.FormatRelative(time.Date(2024, time.November, 2, 23, 0, 0, 0, newYork), fallNow))
+	// 12:30 AM to 1:30 AM EST, after the clocks fell back, is two hours
+	testutils.AssertEqual(t, "2 hours ago", en.FormatRelative(time.Date(2024, time.November, 3, 0, 30, 0, 0, newYork),
+		time.Date(2024, time.November, 3, 1, 30, 0, 0, newYork).Add(time.Hour)))
+
+	// A UTC timestamp just after midnight UTC is still the day before in New York
+	utc := time.Date(2024, time.November, 4, 0, 30, 0, 0, time.UTC).In(newYork)
+	testutils.AssertEqual(t, "yesterday at 7:30 PM", en.FormatRelative(utc, fallNow.Add(24*time.Hour)))
+}
+
+func TestFormatBytes(t *testing.T) {
+	testCases := []struct {
+		tag      string
+		bytes    uint64
+		expected string
+	}{
+		{"en", 512, "512 B"},
+		{"en", 1536, "1.5 KB"},
+		{"en", 1610612736, "1.5 GB"},
+		{"de", 512, "512 B"},
+		{"de", 1536, "1,5 KB"},
+		{"de", 1610612736, "1,5 GB"},
+		{"fr", 1572864, "1,5 MB"},
+		{"es", 1099511627776, "1,0 TB"},
+	}
+
+	for _, tc := range testCases {
+		testutils.AssertEqual(t, tc.expected, Lookup(tc.tag).FormatBytes(tc.bytes))
+	}
+}
+
+func TestFormatPercent(t *testing.T) {
+	testutils.AssertEqual(t, "12.5%", Lookup("en").FormatPercent(12.46))
+	testutils.AssertEqual(t, "12,5 %", Lookup("de").FormatPercent(12.46))
+	testutils.AssertEqual(t, "0,0 %", Lookup("fr").FormatPercent(0))
+	testutils.AssertEqual(t, "100,0 %", Lookup("es").FormatPercent(100))
+}
+
+func TestMessageFallsBackToID(t *testing.T) {
+	testutils.AssertEqual(t, "repo_clone", Lookup("de").Message("repo_clone"))
+}
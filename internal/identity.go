@@ -0,0 +1,250 @@
+package internal
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+	"workbench/models"
+
+	"github.com/The-Skyscape/devtools/pkg/database"
+)
+
+// Version is the workbench release, set at build time with
+// -ldflags "-X workbench/internal.Version=v1.2.3".
+var Version = "dev"
+
+// startedAt is when the process started, for Uptime.
+var startedAt = time.Now()
+
+// Uptime returns how long the workbench process has been running.
+func Uptime() time.Duration {
+	return time.Since(startedAt)
+}
+
+// Nonce limits for identity challenges, in bytes of the nonce string.
+const (
+	MinIdentityNonce = 16
+	MaxIdentityNonce = 256
+)
+
+// identityFile holds the instance keypair in the data directory, outside
+// the coder container's home, readable only by the workbench.
+const identityFile = "instance_identity.json"
+
+// identityContext prefixes every signed challenge so the endpoint can't
+// be used to sign arbitrary data.
+const identityContext = "workbench-identity-v1"
+
+// ErrInvalidNonce is returned for challenge nonces outside the length limits.
+var ErrInvalidNonce = fmt.Errorf("nonce must be %d to %d bytes", MinIdentityNonce, MaxIdentityNonce)
+
+// ErrIdentityMismatch is returned by VerifyInstanceIdentity when the key
+// isn't the pinned one, e.g. after rotation or instance replacement.
+var ErrIdentityMismatch = errors.New("identity does not match the pinned fingerprint")
+
+// InstanceIdentity is what GET /api/v1/identity returns. Signature, when
+// a nonce was given, is ed25519 over IdentityMessage(InstanceID, Nonce).
+type InstanceIdentity struct {
+	InstanceID  string `json:"instance_id"`
+	PublicKey   string `json:"public_key"`  // Base64 ed25519 public key
+	Fingerprint string `json:"fingerprint"` // SHA256:<base64> of the public key, for pinning
+	Version     string `json:"version"`
+	Nonce       string `json:"nonce,omitempty"`
+	Signature   string `json:"signature,omitempty"` // Base64
+}
+
+// storedIdentity is the identity file's contents.
+type storedIdentity struct {
+	InstanceID string    `json:"instance_id"`
+	PrivateKey string    `json:"private_key"` // Base64 ed25519 seed
+	Created    time.Time `json:"created"`
+}
+
+var (
+	identityMu sync.Mutex
+	identity   *storedIdentity // Loaded on first use
+
+	// identityPath locates the identity file; tests use a temporary directory.
+	identityPath = func() string { return filepath.Join(database.DataDir(), identityFile) }
+)
+
+// IdentityMessage returns the bytes signed for a challenge, so callers
+// can verify a signature without reimplementing the format.
+func IdentityMessage(instanceID, nonce string) []byte {
+	return []byte(identityContext + "\n" + instanceID + "\n" + nonce)
+}
+
+// Fingerprint returns the SHA256:<base64> fingerprint of a public key.
+func Fingerprint(key ed25519.PublicKey) string {
+	sum := sha256.Sum256(key)
+	return "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:])
+}
+
+// EnsureInstanceIdentity loads the instance keypair, generating it on
+// first boot.
+func EnsureInstanceIdentity() error {
+	identityMu.Lock()
+	defer identityMu.Unlock()
+	_, err := loadIdentity()
+	return err
+}
+
+// loadIdentity returns the cached identity, reading or creating the file.
+// Callers hold identityMu.
+func loadIdentity() (*storedIdentity, error) {
+	if identity != nil {
+		return identity, nil
+	}
+
+	data, err := os.ReadFile(identityPath())
+	if errors.Is(err, os.ErrNotExist) {
+		id := make([]byte, 16)
+		rand.Read(id)
+		stored := &storedIdentity{InstanceID: hex.EncodeToString(id), Created: time.Now().UTC()}
+		if err := writeIdentity(stored, newIdentityKey()); err != nil {
+			return nil, err
+		}
+		identity = stored
+		return identity, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read instance identity: %w", err)
+	}
+
+	var stored storedIdentity
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, fmt.Errorf("instance identity file is corrupt: %w", err)
+	}
+	if seed, err := base64.StdEncoding.DecodeString(stored.PrivateKey); err != nil || len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("instance identity file is corrupt: bad private key")
+	}
+	identity = &stored
+	return identity, nil
+}
+
+func newIdentityKey() ed25519.PrivateKey {
+	_, key, _ := ed25519.GenerateKey(rand.Reader)
+	return key
+}
+
+// writeIdentity saves the identity with a new key, replacing the file
+// atomically so a crash never leaves a half-written key.
+func writeIdentity(stored *storedIdentity, key ed25519.PrivateKey) error {
+	stored.PrivateKey = base64.StdEncoding.EncodeToString(key.Seed())
+	data, err := json.MarshalIndent(stored, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	path := identityPath()
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("failed to save instance identity: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to save instance identity: %w", err)
+	}
+	return nil
+}
+
+func (s *storedIdentity) key() ed25519.PrivateKey {
+	seed, _ := base64.StdEncoding.DecodeString(s.PrivateKey)
+	return ed25519.NewKeyFromSeed(seed)
+}
+
+// GetInstanceIdentity returns the instance identity, signing nonce when
+// one is given. Nonces must be MinIdentityNonce to MaxIdentityNonce bytes.
+func GetInstanceIdentity(nonce string) (*InstanceIdentity, error) {
+	if nonce != "" && (len(nonce) < MinIdentityNonce || len(nonce) > MaxIdentityNonce) {
+		return nil, ErrInvalidNonce
+	}
+
+	identityMu.Lock()
+	stored, err := loadIdentity()
+	identityMu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	key := stored.key()
+	public := key.Public().(ed25519.PublicKey)
+	result := &InstanceIdentity{
+		InstanceID:  stored.InstanceID,
+		PublicKey:   base64.StdEncoding.EncodeToString(public),
+		Fingerprint: Fingerprint(public),
+		Version:     Version,
+	}
+	if nonce != "" {
+		result.Nonce = nonce
+		result.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(key, IdentityMessage(stored.InstanceID, nonce)))
+	}
+	return result, nil
+}
+
+// VerifyInstanceIdentity checks a challenge response the way automation
+// should: the public key matches the pinned fingerprint, and the
+// signature covers the nonce that was sent.
+func VerifyInstanceIdentity(response *InstanceIdentity, pinnedFingerprint, nonce string) error {
+	public, err := base64.StdEncoding.DecodeString(response.PublicKey)
+	if err != nil || len(public) != ed25519.PublicKeySize {
+		return fmt.Errorf("invalid public key")
+	}
+	if Fingerprint(public) != pinnedFingerprint {
+		return ErrIdentityMismatch
+	}
+	signature, err := base64.StdEncoding.DecodeString(response.Signature)
+	if err != nil || !ed25519.Verify(public, IdentityMessage(response.InstanceID, nonce), signature) {
+		return fmt.Errorf("signature does not verify for this nonce")
+	}
+	return nil
+}
+
+// RotateInstanceIdentity replaces the instance keypair, keeping the
+// instance ID. Automation pinned to the old fingerprint stops trusting
+// the instance until it is re-pinned, so the rotation is recorded as a
+// critical activity and sent to the notification webhook.
+func RotateInstanceIdentity() (*InstanceIdentity, error) {
+	previous, err := rotateIdentityKey()
+	if err != nil {
+		return nil, err
+	}
+	current, err := GetInstanceIdentity("")
+	if err != nil {
+		return nil, err
+	}
+	go models.Activities.Insert(&models.Activity{
+		Type:        "identity_rotated",
+		Repository:  "",
+		Description: fmt.Sprintf("Instance key rotated from %s to %s - re-pin automation that verifies this workbench", previous, current.Fingerprint),
+		Author:      "System",
+		Timestamp:   time.Now(),
+	})
+	return current, nil
+}
+
+// rotateIdentityKey saves a new keypair and returns the old fingerprint.
+func rotateIdentityKey() (string, error) {
+	identityMu.Lock()
+	defer identityMu.Unlock()
+	stored, err := loadIdentity()
+	if err != nil {
+		return "", err
+	}
+	previous := Fingerprint(stored.key().Public().(ed25519.PublicKey))
+	rotated := *stored
+	if err := writeIdentity(&rotated, newIdentityKey()); err != nil {
+		return "", err
+	}
+	identity = &rotated
+	return previous, nil
+}
@@ -0,0 +1,108 @@
+package internal
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/The-Skyscape/devtools/pkg/testutils"
+)
+
+// fakeIdentityDir keeps the instance identity in a temporary data
+// directory for the duration of a test.
+func fakeIdentityDir(t *testing.T) string {
+	path := filepath.Join(t.TempDir(), identityFile)
+	original := identityPath
+	identityPath = func() string { return path }
+	identity = nil
+	t.Cleanup(func() {
+		identityPath = original
+		identity = nil
+	})
+	return path
+}
+
+func TestInstanceIdentitySignsNonce(t *testing.T) {
+	path := fakeIdentityDir(t)
+	testutils.AssertEqual(t, nil, EnsureInstanceIdentity())
+
+	info, err := os.Stat(path)
+	testutils.AssertEqual(t, nil, err)
+	testutils.AssertEqual(t, os.FileMode(0o600), info.Mode().Perm())
+
+	nonce := "c2f1e0a9-4b7d-4c55-9d1e-8f3a2b6c7d10"
+	response, err := GetInstanceIdentity(nonce)
+	testutils.AssertEqual(t, nil, err)
+	testutils.AssertEqual(t, true, strings.HasPrefix(response.Fingerprint, "SHA256:"))
+	testutils.AssertEqual(t, nil, VerifyInstanceIdentity(response, response.Fingerprint, nonce))
+
+	// A replayed response doesn't answer a different challenge
+	err = VerifyInstanceIdentity(response, response.Fingerprint, "a-different-nonce-value")
+	testutils.AssertEqual(t, "signature does not verify for this nonce", err.Error())
+
+	forged := *response
+	forged.InstanceID = "0123456789abcdef0123456789abcdef"
+	testutils.AssertEqual(t, false, VerifyInstanceIdentity(&forged, response.Fingerprint, nonce) == nil)
+
+	// The same identity is loaded again after a restart
+	identity = nil
+	reloaded, err := GetInstanceIdentity("")
+	testutils.AssertEqual(t, nil, err)
+	testutils.AssertEqual(t, response.InstanceID, reloaded.InstanceID)
+	testutils.AssertEqual(t, response.Fingerprint, reloaded.Fingerprint)
+	testutils.AssertEqual(t, "", reloaded.Signature)
+}
+
+func TestInstanceIdentityNonceLimits(t *testing.T) {
+	fakeIdentityDir(t)
+
+	testCases := []struct {
+		name   string
+		nonce  string
+		signed bool
+		err    error
+	}{
+		{"no nonce", "", false, nil},
+		{"too short", strings.Repeat("n", MinIdentityNonce-1), false, ErrInvalidNonce},
+		{"shortest", strings.Repeat("n", MinIdentityNonce), true, nil},
+		{"longest", strings.Repeat("n", MaxIdentityNonce), true, nil},
+		{"too long", strings.Repeat("n", MaxIdentityNonce+1), false, ErrInvalidNonce},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			response, err := GetInstanceIdentity(tc.nonce)
+			testutils.AssertEqual(t, tc.err, err)
+			if err == nil {
+				testutils.AssertEqual(t, tc.signed, response.Signature != "")
+			}
+		})
+	}
+}
+
+func TestRotationInvalidatesPins(t *testing.T) {
+	fakeIdentityDir(t)
+	nonce := strings.Repeat("ab", 16)
+
+	before, err := GetInstanceIdentity(nonce)
+	testutils.AssertEqual(t, nil, err)
+	pinned := before.Fingerprint
+
+	previous, err := rotateIdentityKey()
+	testutils.AssertEqual(t, nil, err)
+	testutils.AssertEqual(t, pinned, previous)
+
+	after, err := GetInstanceIdentity(nonce)
+	testutils.AssertEqual(t, nil, err)
+	testutils.AssertEqual(t, before.InstanceID, after.InstanceID)
+	testutils.AssertEqual(t, false, after.Fingerprint == pinned)
+	testutils.AssertEqual(t, true, errors.Is(VerifyInstanceIdentity(after, pinned, nonce), ErrIdentityMismatch))
+	testutils.AssertEqual(t, nil, VerifyInstanceIdentity(after, after.Fingerprint, nonce))
+
+	// The rotated key is what's loaded after a restart
+	identity = nil
+	reloaded, _ := GetInstanceIdentity("")
+	testutils.AssertEqual(t, after.Fingerprint, reloaded.Fingerprint)
+}
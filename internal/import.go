@@ -0,0 +1,179 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+	"workbench/internal/providers"
+	"workbench/models"
+)
+
+// MaxImportRepos caps how many repositories one import can queue.
+const MaxImportRepos = 200
+
+// ImportCandidate is a repository offered for import, from a pasted URL
+// list or a provider account listing.
+type ImportCandidate struct {
+	Name        string
+	URL         string
+	Description string
+	Private     bool
+	Size        uint64 // Estimated size in bytes; 0 when unknown
+	Exists      string // Name of the local repository it matches; empty when new
+}
+
+// ImportResult is how queueing one selected repository went.
+type ImportResult struct {
+	Name  string
+	URL   string
+	Error string // Why it wasn't queued; empty when it was
+}
+
+// AccountImport asks for the repositories of a provider account.
+type AccountImport struct {
+	Provider  string // "github" or "gitlab"
+	Account   string
+	Token     string // Uses the stored token for the provider's host when empty
+	SaveToken bool   // Store Token for future imports and pull request panels
+	SSH       bool   // Offer SSH URLs rather than HTTPS ones
+}
+
+// Import seams. Replaced in tests.
+var (
+	listAccount = providers.ListAccountRepositories
+	storedToken = providers.Token
+	saveToken   = providers.SetToken
+	localRepos  = func() ([]*models.Repository, error) { return models.Repositories.Search("") }
+	queueImport = func(url string) (string, error) { return Clone(context.Background(), CloneOptions{URL: url}) }
+)
+
+// ParseImportURLs reads a newline-separated list of repository URLs.
+// Blank lines and lines starting with # are skipped, and a URL listed
+// twice, over HTTPS or SSH, is offered once. Returns an error naming the first invalid line.
+func ParseImportURLs(text string) ([]*ImportCandidate, error) {
+	var candidates []*ImportCandidate
+	for i, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := ValidateRemoteURL(line); err != nil {
+			return nil, &repoError{ErrInvalidInput, fmt.Sprintf("line %d: %s", i+1, err)}
+		}
+		if slices.ContainsFunc(candidates, func(c *ImportCandidate) bool { return sameRepository(c.URL, line) }) {
+			continue
+		}
+		candidates = append(candidates, &ImportCandidate{Name: ParseRepoName(line), URL: line})
+	}
+	if len(candidates) == 0 {
+		return nil, &repoError{ErrInvalidInput, "paste at least one repository URL"}
+	}
+	return checkImportCandidates(candidates)
+}
+
+// ListAccountImports lists the repositories of a GitHub or GitLab
+// account for import. The token is only stored when req.SaveToken is
+// set, and only once the listing has worked with it.
+func ListAccountImports(req AccountImport) ([]*ImportCandidate, error) {
+	if strings.TrimSpace(req.Account) == "" {
+		return nil, &repoError{ErrInvalidInput, "enter an account name or paste repository URLs"}
+	}
+	kind, err := providers.ParseKind(req.Provider)
+	if err != nil {
+		return nil, &repoError{ErrInvalidInput, err.Error()}
+	}
+	token := strings.TrimSpace(req.Token)
+	if token == "" {
+		token = storedToken(kind.Host())
+	}
+
+	listed, err := listAccount(kind, req.Account, token)
+	var rateLimit *providers.RateLimitError
+	if errors.As(err, &rateLimit) {
+		return nil, fmt.Errorf("API rate limited, try again in %d minutes", rateLimit.MinutesLeft(time.Now()))
+	}
+	if err != nil {
+		return nil, err
+	}
+	if req.SaveToken && strings.TrimSpace(req.Token) != "" {
+		if err := saveToken(kind.Host(), req.Token); err != nil {
+			return nil, err
+		}
+	}
+	if len(listed) == 0 {
+		return nil, fmt.Errorf("%s has no repositories to import", req.Account)
+	}
+
+	candidates := make([]*ImportCandidate, 0, len(listed))
+	for _, repo := range listed {
+		url := repo.HTTPSURL
+		if req.SSH && repo.SSHURL != "" {
+			url = repo.SSHURL
+		}
+		candidates = append(candidates, &ImportCandidate{
+			Name: repo.Name, URL: url, Description: repo.Description, Private: repo.Private, Size: uint64(max(repo.Size, 0)),
+		})
+	}
+	return checkImportCandidates(candidates)
+}
+
+// checkImportCandidates marks the candidates that match a local
+// repository by name or remote, so they start unselected.
+func checkImportCandidates(candidates []*ImportCandidate) ([]*ImportCandidate, error) {
+	repos, err := localRepos()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load repositories: %w", err)
+	}
+	for _, c := range candidates {
+		for _, repo := range repos {
+			if strings.EqualFold(repo.Name, c.Name) || (repo.URL != "" && sameRepository(repo.URL, c.URL)) {
+				c.Exists = repo.Name
+				break
+			}
+		}
+	}
+	return candidates, nil
+}
+
+// QueueImports queues a clone for each selected URL, through Clone so the
+// usual URL, name, and duplicate checks apply, and reports how each went.
+// One failure doesn't stop the rest.
+func QueueImports(urls []string) ([]*ImportResult, error) {
+	var selected []string
+	for _, url := range urls {
+		if url = strings.TrimSpace(url); url != "" {
+			selected = append(selected, url)
+		}
+	}
+	if len(selected) == 0 {
+		return nil, &repoError{ErrInvalidInput, "select at least one repository to import"}
+	}
+	if len(selected) > MaxImportRepos {
+		return nil, &repoError{ErrInvalidInput, fmt.Sprintf("import at most %d repositories at once", MaxImportRepos)}
+	}
+
+	results := make([]*ImportResult, 0, len(selected))
+	for _, url := range selected {
+		result := &ImportResult{Name: ParseRepoName(url), URL: url}
+		if name, err := queueImport(url); err != nil {
+			result.Error = PresentError(err).Message
+		} else {
+			result.Name = name
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// sameRepository reports whether two remote URLs name the same
+// repository, also matching the HTTPS and SSH URLs of a known provider.
+func sameRepository(a, b string) bool {
+	if sameRemote(a, b) {
+		return true
+	}
+	ra, rb := providers.ParseRemote(a), providers.ParseRemote(b)
+	return ra != nil && rb != nil && ra.Host == rb.Host && strings.EqualFold(ra.Path(), rb.Path())
+}
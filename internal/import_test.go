@@ -0,0 +1,117 @@
+package internal
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+	"workbench/internal/providers"
+	"workbench/models"
+
+	"github.com/The-Skyscape/devtools/pkg/testutils"
+)
+
+// fakeImports serves local repositories from repos and the account
+// listing from listed, and records saved tokens and queued URLs until the
+// test ends. Queueing a URL containing "fail" fails.
+func fakeImports(t *testing.T, repos []*models.Repository, listed []*providers.AccountRepo, listErr error) (saved, queued *[]string) {
+	list, stored, save, local, queue := listAccount, storedToken, saveToken, localRepos, queueImport
+	t.Cleanup(func() {
+		listAccount, storedToken, saveToken, localRepos, queueImport = list, stored, save, local, queue
+	})
+
+	saved, queued = &[]string{}, &[]string{}
+	localRepos = func() ([]*models.Repository, error) { return repos, nil }
+	storedToken = func(host string) string { return "stored-" + host }
+	saveToken = func(host, token string) error {
+		*saved = append(*saved, host+"="+token)
+		return nil
+	}
+	listAccount = func(kind providers.Kind, account, token string) ([]*providers.AccountRepo, error) {
+		*queued = append(*queued, "list:"+token)
+		return listed, listErr
+	}
+	queueImport = func(url string) (string, error) {
+		if strings.Contains(url, "fail") {
+			return "", &repoError{ErrRepoExists, "repository already exists"}
+		}
+		*queued = append(*queued, url)
+		return ParseRepoName(url), nil
+	}
+	return saved, queued
+}
+
+func TestParseImportURLs(t *testing.T) {
+	fakeImports(t, []*models.Repository{{Name: "API", URL: "https://github.com/other/api"}}, nil, nil)
+
+	candidates, err := ParseImportURLs("# work\nhttps://github.com/acme/api.git\n\n  git@github.com:acme/web.git  \nhttps://github.com/acme/web.git\nhttps://github.com/acme/web\n")
+	testutils.AssertEqual(t, nil, err)
+	testutils.AssertEqual(t, 2, len(candidates))
+	testutils.AssertEqual(t, "api", candidates[0].Name)
+	testutils.AssertEqual(t, "API", candidates[0].Exists)
+	testutils.AssertEqual(t, "web", candidates[1].Name)
+	testutils.AssertEqual(t, "", candidates[1].Exists)
+
+	_, err = ParseImportURLs("https://github.com/acme/api\nnot a url")
+	testutils.AssertEqual(t, true, errors.Is(err, ErrInvalidInput))
+	testutils.AssertEqual(t, true, strings.Contains(err.Error(), "line 2"))
+
+	_, err = ParseImportURLs("\n# nothing\n")
+	testutils.AssertEqual(t, true, errors.Is(err, ErrInvalidInput))
+}
+
+func TestListAccountImports(t *testing.T) {
+	repos := []*models.Repository{{Name: "renamed", URL: "https://github.com/acme/tools.git"}}
+	listed := []*providers.AccountRepo{
+		{Name: "api", HTTPSURL: "https://github.com/acme/api.git", SSHURL: "git@github.com:acme/api.git", Size: 2048},
+		{Name: "tools", HTTPSURL: "https://github.com/acme/tools.git", SSHURL: "git@github.com:acme/tools.git"},
+	}
+	saved, calls := fakeImports(t, repos, listed, nil)
+
+	candidates, err := ListAccountImports(AccountImport{Provider: "github", Account: "acme", SSH: true})
+	testutils.AssertEqual(t, nil, err)
+	testutils.AssertEqual(t, "list:stored-github.com", (*calls)[0])
+	testutils.AssertEqual(t, "git@github.com:acme/api.git", candidates[0].URL)
+	testutils.AssertEqual(t, uint64(2048), candidates[0].Size)
+	testutils.AssertEqual(t, "renamed", candidates[1].Exists)
+	testutils.AssertEqual(t, 0, len(*saved))
+
+	// A given token is only kept when asked to
+	_, err = ListAccountImports(AccountImport{Provider: "github", Account: "acme", Token: "secret"})
+	testutils.AssertEqual(t, nil, err)
+	testutils.AssertEqual(t, "list:secret", (*calls)[1])
+	testutils.AssertEqual(t, 0, len(*saved))
+
+	_, err = ListAccountImports(AccountImport{Provider: "github", Account: "acme", Token: "secret", SaveToken: true})
+	testutils.AssertEqual(t, nil, err)
+	testutils.AssertEqual(t, 1, len(*saved))
+	testutils.AssertEqual(t, "github.com=secret", (*saved)[0])
+
+	_, err = ListAccountImports(AccountImport{Provider: "bitbucket", Account: "acme"})
+	testutils.AssertEqual(t, true, errors.Is(err, ErrInvalidInput))
+}
+
+func TestListAccountImportsRateLimited(t *testing.T) {
+	limited := &providers.RateLimitError{Reset: time.Now().Add(9*time.Minute + 30*time.Second)}
+	saved, _ := fakeImports(t, nil, nil, limited)
+
+	_, err := ListAccountImports(AccountImport{Provider: "gitlab", Account: "acme", Token: "secret", SaveToken: true})
+	testutils.AssertEqual(t, "API rate limited, try again in 10 minutes", err.Error())
+	testutils.AssertEqual(t, 0, len(*saved))
+}
+
+func TestQueueImports(t *testing.T) {
+	_, queued := fakeImports(t, nil, nil, nil)
+
+	results, err := QueueImports([]string{"https://github.com/acme/api", " ", "https://github.com/acme/fail", "https://github.com/acme/web"})
+	testutils.AssertEqual(t, nil, err)
+	testutils.AssertEqual(t, 3, len(results))
+	testutils.AssertEqual(t, "", results[0].Error)
+	testutils.AssertEqual(t, "fail", results[1].Name)
+	testutils.AssertEqual(t, "repository already exists", results[1].Error)
+	testutils.AssertEqual(t, "web", results[2].Name)
+	testutils.AssertEqual(t, 2, len(*queued))
+
+	_, err = QueueImports(nil)
+	testutils.AssertEqual(t, true, errors.Is(err, ErrInvalidInput))
+}
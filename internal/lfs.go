@@ -0,0 +1,178 @@
+package internal
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+	"workbench/models"
+	"workbench/services"
+)
+
+// Seams for Git LFS. Replaced in tests.
+var (
+	lfsExec      = operationExec // ls-files walks the whole tree
+	lfsInstalled = services.GitLFSInstalled
+	lfsInstall   = services.InstallGitLFS
+	lfsActivity  = func(activity *models.Activity) { go models.Activities.Insert(activity) }
+)
+
+var (
+	lfsInstallMu  sync.Mutex
+	lfsInstalling bool // Only one install runs at a time
+)
+
+// GitLFSInstalled reports whether git-lfs is installed and set up in the
+// coder container.
+func GitLFSInstalled() bool {
+	return lfsInstalled()
+}
+
+// detectLFS reads whether a repository tracks files with Git LFS, from
+// any .gitattributes in it, and whether any of them are still pointer
+// stubs, and reports whether either changed. While git-lfs isn't
+// installed nothing can have downloaded them, so stubs are assumed. A
+// failed check leaves the repository as it was.
+func detectLFS(repo *models.Repository) bool {
+	dir := shellQuote(repo.LocalPath)
+	output, err := lfsExec(fmt.Sprintf("cd %s && (git ls-files -z -- '*.gitattributes' | xargs -0 -r grep -l 'filter=lfs' -- || true)", dir))
+	if err != nil {
+		return false
+	}
+
+	lfs, stubs := strings.TrimSpace(output) != "", false
+	if lfs && !lfsInstalled() {
+		stubs = true
+	} else if lfs {
+		if output, err = lfsExec(fmt.Sprintf("cd %s && git lfs ls-files 2>&1", dir)); err != nil {
+			return false
+		}
+		stubs = hasLFSStubs(output)
+	}
+
+	changed := repo.LFS != lfs || repo.LFSStubs != stubs
+	repo.LFS, repo.LFSStubs = lfs, stubs
+	return changed
+}
+
+// hasLFSStubs reports whether git lfs ls-files lists a file that is only
+// a pointer: "oid - path" where a downloaded file is "oid * path".
+func hasLFSStubs(output string) bool {
+	for _, line := range strings.Split(output, "\n") {
+		if _, rest, ok := strings.Cut(strings.TrimSpace(line), " "); ok && strings.HasPrefix(rest, "- ") {
+			return true
+		}
+	}
+	return false
+}
+
+// refreshLFS detects Git LFS in a repository after a pull or an LFS pull,
+// saving what changed. Failures only leave the badge stale, so they are
+// logged.
+func refreshLFS(repo *models.Repository) {
+	if !detectLFS(repo) {
+		return
+	}
+	if err := updateRepo(repo); err != nil {
+		slog.Warn("failed to record Git LFS status", "name", repo.Name, "error", err)
+	}
+}
+
+// PullLFS downloads a repository's LFS files in the background with git
+// lfs pull, replacing pointer stubs. Progress is captured as task output.
+func PullLFS(name string) (*Task, error) {
+	repo, err := lfsRepo(name)
+	if err != nil {
+		return nil, err
+	}
+	if !lfsInstalled() {
+		return nil, &AppError{Code: CodeFailed, UserMessage: "Git LFS isn't installed in the coder container - install it first"}
+	}
+
+	task := newTask(name, "git lfs pull")
+	task.RefreshRepos = true
+	task.start(func(onLine func(string) error) error {
+		return lfsPull(repo, onLine)
+	}, func(output string, err error) {
+		finishLFS(name, "repo_lfs_pull", "Pulled LFS files in "+name, "Failed to pull LFS files in "+name, output, err)
+	})
+	return task, nil
+}
+
+// InstallLFS installs git-lfs in the coder container in the background,
+// then pulls the LFS files of the repository that needed it.
+func InstallLFS(name string) (*Task, error) {
+	repo, err := lfsRepo(name)
+	if err != nil {
+		return nil, err
+	}
+
+	lfsInstallMu.Lock()
+	defer lfsInstallMu.Unlock()
+	if lfsInstalling {
+		return nil, &AppError{Code: CodeConflict, UserMessage: "Git LFS is already being installed"}
+	}
+	lfsInstalling = true
+
+	task := newTask(name, "install git-lfs && git lfs pull")
+	task.RefreshRepos = true
+	task.start(func(onLine func(string) error) error {
+		if err := lfsInstall(onLine); err != nil {
+			return err
+		}
+		return lfsPull(repo, onLine)
+	}, func(output string, err error) {
+		lfsInstallMu.Lock()
+		lfsInstalling = false
+		lfsInstallMu.Unlock()
+		finishLFS(name, "lfs_install", "Installed Git LFS and pulled LFS files in "+name,
+			"Failed to install Git LFS and pull LFS files in "+name, output, err)
+	})
+	return task, nil
+}
+
+// lfsRepo finds a repository whose LFS files can be pulled.
+func lfsRepo(name string) (*models.Repository, error) {
+	repo, err := findRepo(name)
+	if err != nil {
+		return nil, repoNotFound(name)
+	}
+	if err := checkCloneReady(repo); err != nil {
+		return nil, err
+	}
+	if repo.IsLocal() {
+		return nil, fmt.Errorf("'%s' has no remote to pull LFS files from", name)
+	}
+	return repo, nil
+}
+
+// lfsPull runs git lfs pull, forcing its progress meter on and splitting
+// the meter's carriage-return updates into lines as they come.
+func lfsPull(repo *models.Repository, onLine func(string) error) error {
+	return taskExec(fmt.Sprintf("set -o pipefail; cd %s && GIT_LFS_FORCE_PROGRESS=1 git lfs pull 2>&1 | stdbuf -oL tr '\\r' '\\n'",
+		shellQuote(repo.LocalPath)), onLine)
+}
+
+// finishLFS re-detects LFS once an install or pull ends, so the badge
+// clears, and records the outcome as activityType, or as
+// activityType_failed with the last line of output.
+func finishLFS(name, activityType, done, failed, output string, err error) {
+	if repo, findErr := findRepo(name); findErr == nil {
+		refreshLFS(repo)
+	}
+
+	activity := &models.Activity{
+		Type:        activityType,
+		Repository:  name,
+		Description: done,
+		Author:      "System",
+		Timestamp:   time.Now(),
+	}
+	if err != nil {
+		lines := strings.Split(strings.TrimSpace(output), "\n")
+		activity.Type = activityType + "_failed"
+		activity.Description = fmt.Sprintf("%s: %s", failed, ScrubGitTokens(lines[len(lines)-1]))
+	}
+	lfsActivity(activity)
+}
@@ -0,0 +1,141 @@
+package internal
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"workbench/models"
+
+	"github.com/The-Skyscape/devtools/pkg/testutils"
+)
+
+// lfsFake is a repository using Git LFS. Pulling downloads its files.
+type lfsFake struct {
+	repo       *models.Repository
+	installed  bool
+	attributes string // grep -l output naming .gitattributes with filter=lfs
+	files      string // git lfs ls-files output
+	pullErr    error
+	saves      int
+	commands   []string
+	activities []*models.Activity
+}
+
+func fakeLFS(t *testing.T, fake *lfsFake) *lfsFake {
+	find, update, exec, installed, install, task, activity := findRepo, updateRepo, lfsExec, lfsInstalled, lfsInstall, taskExec, lfsActivity
+	t.Cleanup(func() {
+		findRepo, updateRepo, lfsExec, lfsInstalled, lfsInstall, taskExec, lfsActivity = find, update, exec, installed, install, task, activity
+	})
+
+	if fake.repo == nil {
+		fake.repo = &models.Repository{Name: "assets", URL: "https://github.com/acme/assets.git", LocalPath: "/home/coder/repos/assets"}
+	}
+	findRepo = func(name string) (*models.Repository, error) {
+		if name != fake.repo.Name {
+			return nil, errors.New("not found")
+		}
+		return fake.repo, nil
+	}
+	updateRepo = func(*models.Repository) error {
+		fake.saves++
+		return nil
+	}
+	lfsExec = func(cmd string) (string, error) {
+		if strings.Contains(cmd, "git lfs ls-files") {
+			return fake.files, nil
+		}
+		return fake.attributes, nil
+	}
+	lfsInstalled = func() bool { return fake.installed }
+	lfsInstall = func(onLine func(string) error) error {
+		fake.installed = true
+		return onLine("Git LFS initialized.")
+	}
+	taskExec = func(cmd string, onLine func(string) error) error {
+		fake.commands = append(fake.commands, cmd)
+		if fake.pullErr != nil {
+			onLine("batch response: Repository or object not found")
+			return fake.pullErr
+		}
+		onLine("Downloading LFS objects: 100% (2/2), 4.2 MB | 3.1 MB/s")
+		fake.files = strings.ReplaceAll(fake.files, " - ", " * ")
+		return nil
+	}
+	lfsActivity = func(a *models.Activity) { fake.activities = append(fake.activities, a) }
+	return fake
+}
+
+func TestDetectLFS(t *testing.T) {
+	testCases := []struct {
+		name       string
+		installed  bool
+		attributes string
+		files      string
+		lfs        bool
+		stubs      bool
+	}{
+		{"no lfs", true, "", "", false, false},
+		{"not installed", false, ".gitattributes\n", "", true, true},
+		{"pointer stubs", true, ".gitattributes\nassets/.gitattributes\n", "4d7a214614 * logo.png\n9e2f0c1a77 - video.mp4\n", true, true},
+		{"downloaded", true, ".gitattributes\n", "4d7a214614 * logo.png\n", true, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			fake := fakeLFS(t, &lfsFake{installed: tc.installed, attributes: tc.attributes, files: tc.files})
+
+			testutils.AssertEqual(t, tc.lfs, detectLFS(fake.repo))
+			testutils.AssertEqual(t, tc.lfs, fake.repo.LFS)
+			testutils.AssertEqual(t, tc.stubs, fake.repo.LFSStubs)
+			// Detecting again changes nothing
+			testutils.AssertEqual(t, false, detectLFS(fake.repo))
+		})
+	}
+}
+
+func TestPullLFS(t *testing.T) {
+	fake := fakeLFS(t, &lfsFake{installed: true, attributes: ".gitattributes\n", files: "9e2f0c1a77 - video.mp4\n"})
+	detectLFS(fake.repo)
+
+	task, err := PullLFS("assets")
+	testutils.AssertEqual(t, nil, err)
+	testutils.AssertEqual(t, nil, task.Wait())
+	testutils.AssertEqual(t, true, task.RefreshRepos)
+	testutils.AssertEqual(t, "Downloading LFS objects: 100% (2/2), 4.2 MB | 3.1 MB/s\n", task.Status().Output)
+	testutils.AssertEqual(t, true, strings.Contains(fake.commands[0], "cd '/home/coder/repos/assets' && GIT_LFS_FORCE_PROGRESS=1 git lfs pull"))
+	testutils.AssertEqual(t, false, fake.repo.LFSStubs)
+	testutils.AssertEqual(t, 1, fake.saves)
+	testutils.AssertEqual(t, "repo_lfs_pull", fake.activities[0].Type)
+
+	fake.pullErr = errors.New("exit status 2")
+	task, _ = PullLFS("assets")
+	task.Wait()
+	testutils.AssertEqual(t, "repo_lfs_pull_failed", fake.activities[1].Type)
+	testutils.AssertEqual(t, "Failed to pull LFS files in assets: batch response: Repository or object not found", fake.activities[1].Description)
+}
+
+func TestPullLFSRefusals(t *testing.T) {
+	fake := fakeLFS(t, &lfsFake{})
+
+	_, err := PullLFS("assets")
+	testutils.AssertEqual(t, "Git LFS isn't installed in the coder container - install it first", err.Error())
+	_, err = PullLFS("missing")
+	testutils.AssertEqual(t, true, errors.Is(err, ErrRepoNotFound))
+	fake.repo.URL = ""
+	_, err = InstallLFS("assets")
+	testutils.AssertEqual(t, "'assets' has no remote to pull LFS files from", err.Error())
+	testutils.AssertEqual(t, 0, len(fake.commands))
+}
+
+func TestInstallLFS(t *testing.T) {
+	fake := fakeLFS(t, &lfsFake{attributes: ".gitattributes\n", files: "9e2f0c1a77 - video.mp4\n"})
+	detectLFS(fake.repo)
+	testutils.AssertEqual(t, true, fake.repo.LFSStubs)
+
+	task, err := InstallLFS("assets")
+	testutils.AssertEqual(t, nil, err)
+	testutils.AssertEqual(t, nil, task.Wait())
+	testutils.AssertEqual(t, "Git LFS initialized.\nDownloading LFS objects: 100% (2/2), 4.2 MB | 3.1 MB/s\n", task.Status().Output)
+	testutils.AssertEqual(t, false, fake.repo.LFSStubs)
+	testutils.AssertEqual(t, "lfs_install", fake.activities[0].Type)
+}
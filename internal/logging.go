@@ -0,0 +1,141 @@
+package internal
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"workbench/services"
+)
+
+// Environment variables configuring the log, read once at startup.
+const (
+	LogFormatEnvVar = "LOG_FORMAT" // text (the default) or json
+	LogLevelEnvVar  = "LOG_LEVEL"  // debug, info (the default), warn, or error
+)
+
+// MaxLogRecords is how many recent log records RecentLogs keeps.
+const MaxLogRecords = 500
+
+// LogRecord is a log line kept for GET /api/logs.
+type LogRecord struct {
+	Time      time.Time         `json:"time"`
+	Level     string            `json:"level"`
+	Message   string            `json:"message"`
+	RequestID string            `json:"request_id,omitempty"`
+	Fields    map[string]string `json:"fields,omitempty"`
+}
+
+// recentLogs is a ring buffer of the last MaxLogRecords records.
+var recentLogs = struct {
+	sync.Mutex
+	records []LogRecord
+	next    int
+}{}
+
+// SetupLogging makes the default slog logger write to standard error, as
+// text or JSON per LOG_FORMAT. Lines printed with the log package go
+// through it too, so there is one log. Every record also carries the
+// request ID of the context it was logged with, if any, and is kept for
+// RecentLogs.
+func SetupLogging() {
+	slog.SetDefault(slog.New(newLogHandler(os.Stderr, os.Getenv(LogFormatEnvVar), os.Getenv(LogLevelEnvVar))))
+}
+
+func newLogHandler(w io.Writer, format, level string) slog.Handler {
+	var threshold slog.Level
+	if err := threshold.UnmarshalText([]byte(level)); err != nil {
+		threshold = slog.LevelInfo
+	}
+	options := &slog.HandlerOptions{Level: threshold}
+	if strings.EqualFold(format, "json") {
+		return &logHandler{next: slog.NewJSONHandler(w, options)}
+	}
+	return &logHandler{next: slog.NewTextHandler(w, options)}
+}
+
+// logHandler adds the request ID to records and keeps them for
+// RecentLogs before passing them on.
+type logHandler struct {
+	next  slog.Handler
+	attrs []slog.Attr // From WithAttrs, for RecentLogs
+}
+
+func (h *logHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *logHandler) Handle(ctx context.Context, r slog.Record) error {
+	id := services.RequestID(ctx)
+	if id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	keepLogRecord(h.logRecord(r, id))
+	return h.next.Handle(ctx, r)
+}
+
+func (h *logHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &logHandler{next: h.next.WithAttrs(attrs), attrs: append(slices.Clip(h.attrs), attrs...)}
+}
+
+func (h *logHandler) WithGroup(name string) slog.Handler {
+	return &logHandler{next: h.next.WithGroup(name), attrs: h.attrs}
+}
+
+func (h *logHandler) logRecord(r slog.Record, requestID string) LogRecord {
+	record := LogRecord{Time: r.Time, Level: r.Level.String(), Message: strings.TrimSpace(r.Message), RequestID: requestID}
+	add := func(a slog.Attr) bool {
+		if a.Key == "request_id" {
+			return true
+		}
+		if record.Fields == nil {
+			record.Fields = map[string]string{}
+		}
+		record.Fields[a.Key] = a.Value.String()
+		return true
+	}
+	for _, a := range h.attrs {
+		add(a)
+	}
+	r.Attrs(add)
+	return record
+}
+
+func keepLogRecord(record LogRecord) {
+	recentLogs.Lock()
+	defer recentLogs.Unlock()
+	if len(recentLogs.records) < MaxLogRecords {
+		recentLogs.records = append(recentLogs.records, record)
+		return
+	}
+	recentLogs.records[recentLogs.next] = record
+	recentLogs.next = (recentLogs.next + 1) % MaxLogRecords
+}
+
+// RecentLogs returns the most recent log records, newest first. A
+// non-empty requestID keeps only that request's records, and level (e.g.
+// "warn") only records at or above it.
+func RecentLogs(requestID, level string) []LogRecord {
+	var threshold slog.Level
+	if err := threshold.UnmarshalText([]byte(level)); err != nil {
+		threshold = slog.LevelDebug
+	}
+
+	recentLogs.Lock()
+	defer recentLogs.Unlock()
+	records := []LogRecord{}
+	n := len(recentLogs.records)
+	for i := range n {
+		record := recentLogs.records[(recentLogs.next+n-1-i)%n]
+		var recordLevel slog.Level
+		recordLevel.UnmarshalText([]byte(record.Level))
+		if (requestID == "" || record.RequestID == requestID) && recordLevel >= threshold {
+			records = append(records, record)
+		}
+	}
+	return records
+}
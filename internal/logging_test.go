@@ -0,0 +1,63 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+	"workbench/services"
+
+	"github.com/The-Skyscape/devtools/pkg/testutils"
+)
+
+// resetRecentLogs empties the log ring buffer until the test ends.
+func resetRecentLogs(t *testing.T) {
+	recentLogs.Lock()
+	original, next := recentLogs.records, recentLogs.next
+	recentLogs.records, recentLogs.next = nil, 0
+	recentLogs.Unlock()
+	t.Cleanup(func() {
+		recentLogs.Lock()
+		recentLogs.records, recentLogs.next = original, next
+		recentLogs.Unlock()
+	})
+}
+
+func TestLogHandlerAddsRequestIDs(t *testing.T) {
+	resetRecentLogs(t)
+	var out bytes.Buffer
+	logger := slog.New(newLogHandler(&out, "json", "")).With("component", "clone")
+
+	ctx := services.WithRequestID(context.Background(), "4f2a9c")
+	logger.InfoContext(ctx, "cloning repository", "name", "api")
+	logger.Debug("coder exec") // Below the default level
+	logger.Warn("clone failed", "name", "web")
+
+	testutils.AssertEqual(t, true, strings.Contains(out.String(), `"request_id":"4f2a9c"`))
+	testutils.AssertEqual(t, false, strings.Contains(out.String(), "coder exec"))
+
+	records := RecentLogs("", "")
+	testutils.AssertEqual(t, 2, len(records))
+	testutils.AssertEqual(t, "clone failed", records[0].Message)
+	testutils.AssertEqual(t, "WARN", records[0].Level)
+	testutils.AssertEqual(t, "4f2a9c", records[1].RequestID)
+	testutils.AssertEqual(t, "api", records[1].Fields["name"])
+	testutils.AssertEqual(t, "clone", records[1].Fields["component"])
+
+	testutils.AssertEqual(t, 1, len(RecentLogs("4f2a9c", "")))
+	testutils.AssertEqual(t, 1, len(RecentLogs("", "warn")))
+}
+
+func TestRecentLogsKeepsTheLatest(t *testing.T) {
+	resetRecentLogs(t)
+	logger := slog.New(newLogHandler(&bytes.Buffer{}, "text", "debug"))
+	for i := range MaxLogRecords + 20 {
+		logger.Debug("tick", "i", i)
+	}
+
+	records := RecentLogs("", "")
+	testutils.AssertEqual(t, MaxLogRecords, len(records))
+	testutils.AssertEqual(t, "519", records[0].Fields["i"])
+	testutils.AssertEqual(t, "20", records[MaxLogRecords-1].Fields["i"])
+}
@@ -0,0 +1,259 @@
+package internal
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"workbench/internal/i18n"
+	"workbench/models"
+)
+
+// DefaultProtectedBranches are never deleted as merged branches unless
+// the maintenance_protected_branches setting says otherwise.
+const DefaultProtectedBranches = "main,master,release/*"
+
+// Maintenance seams. gc can take minutes on a big repository, so it's
+// bounded by the operation timeout. Replaced in tests.
+var (
+	maintenanceExec      = operationExec
+	maintenanceGCExec    = operationExec
+	maintenanceProtected = ProtectedBranchPatterns
+	maintenanceBranch    = DefaultBranch
+	maintenanceActivity  = func(repoName, description string) {
+		go models.Activities.Insert(&models.Activity{
+			Type:        "repo_maintenance",
+			Repository:  repoName,
+			Description: description,
+			Author:      "System",
+			Timestamp:   time.Now(),
+		})
+	}
+)
+
+// MaintenanceReport is what a repository's maintenance would clean up.
+type MaintenanceReport struct {
+	Repository   string
+	Target       string   // Ref branches are merged into, e.g. "origin/main"
+	Current      string   // Checked-out branch, never deleted
+	Merged       []string // Merged branches PruneMergedBranches deletes
+	Protected    []string // Merged branches kept for being current, the default, or protected
+	LooseObjects int
+	GitSize      uint64 // Bytes used by the .git directory
+}
+
+// MaintenanceResult is what a maintenance action cleaned up.
+type MaintenanceResult struct {
+	Repository string
+	Deleted    []string          // Branches removed
+	Failed     map[string]string // Branches git refused to remove, with why
+	Before     uint64            // .git size before, in bytes
+	After      uint64            // .git size after, in bytes
+}
+
+// Reclaimed returns the bytes the action freed in the .git directory.
+func (r *MaintenanceResult) Reclaimed() uint64 {
+	if r.After >= r.Before {
+		return 0
+	}
+	return r.Before - r.After
+}
+
+// ProtectedBranchPatterns returns the branch patterns that are never
+// deleted as merged, from the maintenance_protected_branches setting.
+func ProtectedBranchPatterns() []string {
+	return SplitBranchPatterns(models.SettingOrDefault("maintenance_protected_branches"))
+}
+
+// SplitBranchPatterns splits comma-separated branch patterns, such as
+// "main, release/*".
+func SplitBranchPatterns(value string) []string {
+	var patterns []string
+	for _, p := range strings.Split(value, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			patterns = append(patterns, p)
+		}
+	}
+	return patterns
+}
+
+// checkBranchPatterns validates branch patterns like release/*.
+func checkBranchPatterns(value string) error {
+	for _, p := range SplitBranchPatterns(value) {
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("invalid pattern %s", p)
+		}
+	}
+	return nil
+}
+
+// matchesBranchPattern reports whether branch matches any pattern; * in a
+// pattern doesn't match a slash, so release/* matches release/1.0 only.
+func matchesBranchPattern(branch string, patterns []string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, branch); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// GetMaintenanceReport lists a repository's local branches already merged
+// into its default branch, its loose objects, and the size of its .git
+// directory. Merged branches matching excludePatterns are listed as
+// protected, as PruneMergedBranches would keep them.
+func GetMaintenanceReport(repoName string, excludePatterns ...string) (*MaintenanceReport, error) {
+	repo, err := models.Repositories.Find("WHERE Name = ?", repoName)
+	if err != nil {
+		return nil, repoNotFound(repoName)
+	}
+	return maintenanceReport(repo, excludePatterns)
+}
+
+func maintenanceReport(repo *models.Repository, excludePatterns []string) (*MaintenanceReport, error) {
+	if err := checkBranchPatterns(strings.Join(excludePatterns, ",")); err != nil {
+		return nil, err
+	}
+	dir := shellQuote(repo.LocalPath)
+
+	target, err := mergeTarget(dir)
+	if err != nil {
+		return nil, err
+	}
+	current, _ := maintenanceExec(fmt.Sprintf("cd %s && git branch --show-current 2>/dev/null", dir))
+	merged, err := maintenanceExec(fmt.Sprintf("cd %s && git branch --merged %s --format='%%(refname:short)' 2>&1", dir, shellQuote(target)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list merged branches: %s", strings.TrimSpace(merged))
+	}
+
+	report := &MaintenanceReport{Repository: repo.Name, Target: target, Current: strings.TrimSpace(current)}
+	defaultName := strings.TrimPrefix(target, "origin/")
+	patterns := append(maintenanceProtected(), excludePatterns...)
+	for _, branch := range strings.Split(merged, "\n") {
+		branch = strings.TrimSpace(branch)
+		switch {
+		case branch == "" || strings.HasPrefix(branch, "("):
+			continue
+		case branch == report.Current || branch == defaultName || matchesBranchPattern(branch, patterns):
+			report.Protected = append(report.Protected, branch)
+		default:
+			report.Merged = append(report.Merged, branch)
+		}
+	}
+
+	objects, _ := maintenanceExec(fmt.Sprintf("cd %s && git count-objects -v 2>/dev/null", dir))
+	report.LooseObjects = parseLooseObjects(objects)
+	report.GitSize = gitDirSize(dir)
+	return report, nil
+}
+
+// mergeTarget returns the ref merged branches are checked against: the
+// branch origin/HEAD points to, or else the first local branch of the
+// default branch setting, main, and master that exists.
+func mergeTarget(dir string) (string, error) {
+	if output, err := maintenanceExec(fmt.Sprintf("cd %s && git symbolic-ref --quiet --short refs/remotes/origin/HEAD 2>/dev/null", dir)); err == nil && strings.TrimSpace(output) != "" {
+		return strings.TrimSpace(output), nil
+	}
+	for _, branch := range []string{maintenanceBranch(), "main", "master"} {
+		if _, err := maintenanceExec(fmt.Sprintf("cd %s && git rev-parse --verify --quiet refs/heads/%s", dir, shellQuote(branch))); err == nil {
+			return branch, nil
+		}
+	}
+	return "", fmt.Errorf("couldn't tell the repository's default branch - set origin/HEAD with git remote set-head origin --auto")
+}
+
+// parseLooseObjects reads the loose object count from git count-objects -v.
+func parseLooseObjects(output string) int {
+	for _, line := range strings.Split(output, "\n") {
+		if value, ok := strings.CutPrefix(line, "count: "); ok {
+			n, _ := strconv.Atoi(strings.TrimSpace(value))
+			return n
+		}
+	}
+	return 0
+}
+
+// gitDirSize returns the size of the .git directory in dir, or 0 when du
+// fails.
+func gitDirSize(dir string) uint64 {
+	output, err := maintenanceExec(fmt.Sprintf("du -sk %s/.git 2>/dev/null", dir))
+	if err != nil {
+		return 0
+	}
+	fields := strings.Fields(output)
+	if len(fields) == 0 {
+		return 0
+	}
+	kb, _ := strconv.ParseUint(fields[0], 10, 64)
+	return kb * 1024
+}
+
+// PruneMergedBranches deletes the local branches GetMaintenanceReport
+// lists as merged, keeping those matching excludePatterns as well as the
+// current, default, and protected branches. git branch -d refuses any
+// branch that isn't merged after all; those are returned as failed.
+func PruneMergedBranches(repoName string, excludePatterns []string) (*MaintenanceResult, error) {
+	repo, err := models.Repositories.Find("WHERE Name = ?", repoName)
+	if err != nil {
+		return nil, repoNotFound(repoName)
+	}
+	return pruneMergedBranches(repo, excludePatterns)
+}
+
+func pruneMergedBranches(repo *models.Repository, excludePatterns []string) (*MaintenanceResult, error) {
+	report, err := maintenanceReport(repo, excludePatterns)
+	if err != nil {
+		return nil, err
+	}
+	dir := shellQuote(repo.LocalPath)
+
+	result := &MaintenanceResult{Repository: repo.Name, Failed: map[string]string{}, Before: report.GitSize}
+	for _, branch := range report.Merged {
+		if output, err := maintenanceExec(fmt.Sprintf("cd %s && git branch -d -- %s 2>&1", dir, shellQuote(branch))); err != nil {
+			result.Failed[branch] = lastLine(output)
+			continue
+		}
+		result.Deleted = append(result.Deleted, branch)
+	}
+	result.After = gitDirSize(dir)
+
+	if len(result.Deleted) > 0 || len(result.Failed) > 0 {
+		description := fmt.Sprintf("Deleted %d merged branches from %s", len(result.Deleted), repo.Name)
+		if len(result.Deleted) > 0 {
+			description += ": " + strings.Join(result.Deleted, ", ")
+		}
+		if len(result.Failed) > 0 {
+			failed := make([]string, 0, len(result.Failed))
+			for branch := range result.Failed {
+				failed = append(failed, branch)
+			}
+			sort.Strings(failed)
+			description += fmt.Sprintf("; kept %s, which git refused to delete", strings.Join(failed, ", "))
+		}
+		description += fmt.Sprintf(", reclaiming %s", i18n.Lookup("en").FormatBytes(result.Reclaimed()))
+		maintenanceActivity(repo.Name, description)
+	}
+	return result, nil
+}
+
+// RunGarbageCollection repacks a repository with git gc --aggressive and
+// removes unreachable objects right away, reporting the space reclaimed.
+func RunGarbageCollection(repoName string) (*MaintenanceResult, error) {
+	repo, err := models.Repositories.Find("WHERE Name = ?", repoName)
+	if err != nil {
+		return nil, repoNotFound(repoName)
+	}
+	dir := shellQuote(repo.LocalPath)
+
+	result := &MaintenanceResult{Repository: repo.Name, Before: gitDirSize(dir)}
+	if output, err := maintenanceGCExec(fmt.Sprintf("cd %s && git gc --aggressive --prune=now --quiet 2>&1", dir)); err != nil {
+		return nil, fmt.Errorf("git gc failed: %s", lastLine(output))
+	}
+	result.After = gitDirSize(dir)
+
+	maintenanceActivity(repo.Name, fmt.Sprintf("Collected garbage in %s, reclaiming %s (.git is now %s)",
+		repo.Name, i18n.Lookup("en").FormatBytes(result.Reclaimed()), i18n.Lookup("en").FormatBytes(result.After)))
+	return result, nil
+}
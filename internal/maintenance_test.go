@@ -0,0 +1,93 @@
+package internal
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"workbench/models"
+
+	"github.com/The-Skyscape/devtools/pkg/testutils"
+)
+
+// fakeMaintenance scripts a repository on feature/api with branches
+// merged into origin/main, recording the activities maintenance records.
+func fakeMaintenance(t *testing.T) (*scriptedExec, *[]string) {
+	script := fakeScript(t, &maintenanceExec)
+	script.on("symbolic-ref", "origin/main\n", nil).
+		on("--show-current", "feature/api\n", nil).
+		on("--merged", "feature/api\nfix/typo\nmain\nmaster\nrelease/1.0\nrelease/1.0/hotfix\nspike/old\n", nil).
+		on("count-objects", "count: 1342\nsize: 5120\nin-pack: 9000\n", nil).
+		on("du -sk", "20480\t/home/coder/repos/api/.git\n", nil)
+
+	return script, fakeMaintenanceSettings(t)
+}
+
+// fakeMaintenanceSettings uses the default protected branches and main
+// for new projects, returning the activities maintenance records.
+func fakeMaintenanceSettings(t *testing.T) *[]string {
+	var activities []string
+	protected, branch, activity := maintenanceProtected, maintenanceBranch, maintenanceActivity
+	maintenanceProtected = func() []string { return SplitBranchPatterns(DefaultProtectedBranches) }
+	maintenanceBranch = func() string { return "main" }
+	maintenanceActivity = func(repoName, description string) { activities = append(activities, description) }
+	t.Cleanup(func() { maintenanceProtected, maintenanceBranch, maintenanceActivity = protected, branch, activity })
+	return &activities
+}
+
+func TestMaintenanceReport(t *testing.T) {
+	fakeMaintenance(t)
+	repo := &models.Repository{Name: "api", LocalPath: "/home/coder/repos/api"}
+
+	report, err := maintenanceReport(repo, nil)
+	testutils.AssertEqual(t, nil, err)
+	testutils.AssertEqual(t, "origin/main", report.Target)
+	testutils.AssertEqual(t, "feature/api", report.Current)
+	// release/* doesn't reach into release/1.0/hotfix
+	testutils.AssertEqual(t, []string{"fix/typo", "release/1.0/hotfix", "spike/old"}, report.Merged)
+	testutils.AssertEqual(t, []string{"feature/api", "main", "master", "release/1.0"}, report.Protected)
+	testutils.AssertEqual(t, 1342, report.LooseObjects)
+	testutils.AssertEqual(t, uint64(20480*1024), report.GitSize)
+
+	report, err = maintenanceReport(repo, []string{"spike/*", "release/*/*"})
+	testutils.AssertEqual(t, nil, err)
+	testutils.AssertEqual(t, []string{"fix/typo"}, report.Merged)
+
+	_, err = maintenanceReport(repo, []string{"fix/[typo"})
+	testutils.AssertEqual(t, "invalid pattern fix/[typo", err.Error())
+}
+
+func TestMaintenanceReportFallsBackToLocalDefaultBranch(t *testing.T) {
+	fakeMaintenanceSettings(t)
+	script := fakeScript(t, &maintenanceExec)
+	script.on("symbolic-ref", "", errors.New("exit status 1")).
+		on("refs/heads/'main'", "", errors.New("exit status 1")).
+		on("--merged", "master\nold\n", nil)
+
+	report, err := maintenanceReport(&models.Repository{Name: "api", LocalPath: "/repos/api"}, nil)
+	testutils.AssertEqual(t, nil, err)
+	testutils.AssertEqual(t, "master", report.Target)
+	testutils.AssertEqual(t, true, script.ran("git branch --merged 'master'"))
+	testutils.AssertEqual(t, []string{"old"}, report.Merged)
+}
+
+func TestPruneMergedBranches(t *testing.T) {
+	script, activities := fakeMaintenance(t)
+	script.on("git branch -d -- 'spike/old'", "error: the branch 'spike/old' is not fully merged.", errors.New("exit status 1"))
+
+	result, err := pruneMergedBranches(&models.Repository{Name: "api", LocalPath: "/home/coder/repos/api"}, nil)
+	testutils.AssertEqual(t, nil, err)
+	testutils.AssertEqual(t, []string{"fix/typo", "release/1.0/hotfix"}, result.Deleted)
+	testutils.AssertEqual(t, "error: the branch 'spike/old' is not fully merged.", result.Failed["spike/old"])
+	for _, protected := range []string{"feature/api", "main", "master", "release/1.0"} {
+		testutils.AssertEqual(t, false, script.ran("git branch -d -- '"+protected+"'"))
+	}
+	testutils.AssertEqual(t, 1, len(*activities))
+	testutils.AssertEqual(t, true, strings.HasPrefix((*activities)[0],
+		"Deleted 2 merged branches from api: fix/typo, release/1.0/hotfix; kept spike/old, which git refused to delete"))
+}
+
+func TestCheckBranchPatterns(t *testing.T) {
+	testutils.AssertEqual(t, nil, checkBranchPatterns(DefaultProtectedBranches))
+	testutils.AssertEqual(t, nil, checkBranchPatterns(""))
+	testutils.AssertEqual(t, true, checkBranchPatterns("main, release/[") != nil)
+}
@@ -0,0 +1,201 @@
+package internal
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"workbench/models"
+	"workbench/services"
+)
+
+// Operations a conflicted pull, cherry-pick, or revert can leave in
+// progress.
+const (
+	MergeInProgress      = "merge"
+	RebaseInProgress     = "rebase"
+	CherryPickInProgress = "cherry-pick"
+	RevertInProgress     = "revert"
+)
+
+// Sides a conflict can be resolved to. Ours is always the local work and
+// theirs the changes pulled from upstream, or the commit being picked or
+// reverted, whichever way git names them.
+const (
+	MergeOurs   = "ours"
+	MergeTheirs = "theirs"
+)
+
+// MergeState is a merge, rebase, cherry-pick, or revert stopped part way
+// by conflicts.
+type MergeState struct {
+	Operation  string   // One of the InProgress constants, or empty
+	Conflicted []string // Unmerged paths, relative to the repository root
+}
+
+// InProgress reports whether an operation is waiting to be finished or
+// aborted.
+func (s *MergeState) InProgress() bool {
+	return s.Operation != ""
+}
+
+// FromPull reports whether the operation in progress was started by a
+// pull rather than by a cherry-pick or revert.
+func (s *MergeState) FromPull() bool {
+	return s.Operation == MergeInProgress || s.Operation == RebaseInProgress
+}
+
+// mergeExec runs merge state checks in the coder container. Replaced in
+// tests.
+var mergeExec = services.CoderExec
+
+// mergeActivity records a refused resolution. Replaced in tests.
+var mergeActivity = func(activity *models.Activity) { go models.Activities.Insert(activity) }
+
+// GetMergeState reports whether a repository has a merge, rebase,
+// cherry-pick, or revert in progress and which files are still conflicted.
+func GetMergeState(repoName string) (*MergeState, error) {
+	repo, err := models.Repositories.Find("WHERE Name = ?", repoName)
+	if err != nil {
+		return nil, repoNotFound(repoName)
+	}
+	return mergeState(repo)
+}
+
+// mergeStateScript prints which of git's merge, rebase, cherry-pick, and
+// revert state files exist, then a separator and the unmerged paths. A
+// rebase also leaves CHERRY_PICK_HEAD while it stops on a commit, so
+// rebase is checked last.
+const mergeStateScript = `for f in CHERRY_PICK_HEAD REVERT_HEAD MERGE_HEAD rebase-merge rebase-apply; do [ ! -e "$(git rev-parse --git-path $f)" ] || echo $f; done; echo --; git -c core.quotePath=false diff --name-only --diff-filter=U 2>&1`
+
+func mergeState(repo *models.Repository) (*MergeState, error) {
+	output, err := mergeExec(fmt.Sprintf("cd %s && %s", shellQuote(repo.LocalPath), mergeStateScript))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read merge state: %s", strings.TrimSpace(output))
+	}
+
+	state := &MergeState{}
+	markers, files, _ := strings.Cut(output, "--\n")
+	for _, marker := range strings.Fields(markers) {
+		switch marker {
+		case "CHERRY_PICK_HEAD":
+			state.Operation = CherryPickInProgress
+		case "REVERT_HEAD":
+			state.Operation = RevertInProgress
+		case "MERGE_HEAD":
+			state.Operation = MergeInProgress
+		case "rebase-merge", "rebase-apply":
+			state.Operation = RebaseInProgress
+		}
+	}
+	for _, line := range strings.Split(files, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			state.Conflicted = append(state.Conflicted, line)
+		}
+	}
+	return state, nil
+}
+
+// ResolveMerge resolves every conflicted file of an in-progress merge,
+// rebase, cherry-pick, or revert by checking out one side, stages them,
+// and finishes it: a merge is committed with a message listing the files,
+// and the others continue with the commit's own message. It's refused when any conflicted file no
+// longer has its conflict markers, since that file was edited by hand
+// and checking out a side would throw the edits away. Runs behind a
+// safety point and is recorded as git_merge_ours or git_merge_theirs.
+func ResolveMerge(repoName, side string) error {
+	if side != MergeOurs && side != MergeTheirs {
+		return &repoError{ErrInvalidInput, fmt.Sprintf("can't resolve conflicts to '%s' - use ours or theirs", side)}
+	}
+	repo, err := models.Repositories.Find("WHERE Name = ?", repoName)
+	if err != nil {
+		return repoNotFound(repoName)
+	}
+
+	command, description, err := planResolution(repo, side)
+	if err != nil {
+		mergeActivity(&models.Activity{
+			Type:        "git_merge_" + side + "_refused",
+			Repository:  repoName,
+			Description: fmt.Sprintf("Refused to resolve the conflicts in %s keeping %s: %v", repoName, sideName(side), err),
+			Author:      "System",
+			Timestamp:   time.Now(),
+		})
+		return err
+	}
+	return runGitAction(repoName, "merge-"+side, description, command)
+}
+
+// planResolution checks a repository's conflicts can be resolved to side
+// and returns the command doing it and its activity description, with
+// %s left for the repository name.
+func planResolution(repo *models.Repository, side string) (string, string, error) {
+	state, err := mergeState(repo)
+	if err != nil {
+		return "", "", err
+	}
+	if !state.InProgress() || len(state.Conflicted) == 0 {
+		return "", "", fmt.Errorf("no conflicts to resolve in %s", repo.Name)
+	}
+	edited, err := handEditedFiles(repo, state.Conflicted)
+	if err != nil {
+		return "", "", err
+	}
+	if len(edited) > 0 {
+		return "", "", fmt.Errorf("%s edited by hand - finish resolving in VS Code, or abort", strings.Join(edited, ", "))
+	}
+
+	// While rebasing, git's ours is upstream and theirs the local commit
+	checkout := side
+	if state.Operation == RebaseInProgress {
+		checkout = map[string]string{MergeOurs: MergeTheirs, MergeTheirs: MergeOurs}[side]
+	}
+	quoted := make([]string, len(state.Conflicted))
+	for i, file := range state.Conflicted {
+		quoted[i] = shellQuote(file)
+	}
+	paths := strings.Join(quoted, " ")
+
+	command := fmt.Sprintf("git checkout --%s -- %s 2>&1 && git add -- %s 2>&1", checkout, paths, paths)
+	if !state.FromPull() || state.Operation == RebaseInProgress {
+		command += fmt.Sprintf(" && GIT_EDITOR=true git %s --continue 2>&1", state.Operation)
+	} else {
+		message := fmt.Sprintf("Resolve merge conflicts keeping %s\n\nResolved from the workbench by taking %s side of:\n- %s",
+			sideName(side), side, strings.Join(state.Conflicted, "\n- "))
+		command += " && git commit -q -m " + shellQuote(message) + " 2>&1"
+	}
+	description := fmt.Sprintf("Resolved %s in %%s keeping %s",
+		plural(len(state.Conflicted), "conflicted file", "conflicted files"), sideName(side))
+	return command, description, nil
+}
+
+// handEditedFiles returns the conflicted files that still exist but no
+// longer have both a start and an end conflict marker. A file that never
+// had markers, such as one deleted on the other side, is counted too, so
+// those are left to be resolved by hand.
+func handEditedFiles(repo *models.Repository, files []string) ([]string, error) {
+	quoted := make([]string, len(files))
+	for i, file := range files {
+		quoted[i] = shellQuote(file)
+	}
+	script := fmt.Sprintf(`for f in %s; do [ ! -e "$f" ] || { grep -q -e '^<<<<<<<' -- "$f" && grep -q -e '^>>>>>>>' -- "$f"; } || printf '%%s\n' "$f"; done`,
+		strings.Join(quoted, " "))
+	output, err := mergeExec(fmt.Sprintf("cd %s && %s", shellQuote(repo.LocalPath), script))
+	if err != nil {
+		return nil, fmt.Errorf("failed to check conflicted files: %s", strings.TrimSpace(output))
+	}
+	var edited []string
+	for _, line := range strings.Split(output, "\n") {
+		if line != "" {
+			edited = append(edited, line)
+		}
+	}
+	return edited, nil
+}
+
+// sideName describes a resolution side for activity descriptions.
+func sideName(side string) string {
+	if side == MergeOurs {
+		return "the local changes"
+	}
+	return "the upstream changes"
+}
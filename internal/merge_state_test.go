@@ -0,0 +1,100 @@
+package internal
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"workbench/models"
+
+	"github.com/The-Skyscape/devtools/pkg/testutils"
+)
+
+// fakeMerge answers the merge state check with state and the conflict
+// marker check with edited, recording the commands run.
+func fakeMerge(t *testing.T, state, edited string) *[]string {
+	var commands []string
+	original := mergeExec
+	mergeExec = func(command string) (string, error) {
+		commands = append(commands, command)
+		if strings.Contains(command, "grep -q") {
+			return edited, nil
+		}
+		return state, nil
+	}
+	t.Cleanup(func() { mergeExec = original })
+	return &commands
+}
+
+var mergeRepo = &models.Repository{Name: "api", LocalPath: "/home/coder/repos/api"}
+
+func TestMergeState(t *testing.T) {
+	testCases := []struct {
+		name       string
+		output     string
+		operation  string
+		conflicted string
+	}{
+		{"clean", "--\n", "", ""},
+		{"merge", "MERGE_HEAD\n--\nmain.go\ndocs/README.md\n", MergeInProgress, "main.go,docs/README.md"},
+		{"rebase", "rebase-merge\n--\nmain.go\n", RebaseInProgress, "main.go"},
+		{"resolved merge", "MERGE_HEAD\n--\n", MergeInProgress, ""},
+		{"cherry-pick", "CHERRY_PICK_HEAD\n--\nmain.go\n", CherryPickInProgress, "main.go"},
+		{"revert", "REVERT_HEAD\n--\nmain.go\n", RevertInProgress, "main.go"},
+		{"rebase stopped on a commit", "CHERRY_PICK_HEAD\nrebase-merge\n--\nmain.go\n", RebaseInProgress, "main.go"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			fakeMerge(t, tc.output, "")
+			state, err := mergeState(mergeRepo)
+			testutils.AssertEqual(t, nil, err)
+			testutils.AssertEqual(t, tc.operation, state.Operation)
+			testutils.AssertEqual(t, tc.operation != "", state.InProgress())
+			testutils.AssertEqual(t, tc.conflicted, strings.Join(state.Conflicted, ","))
+		})
+	}
+
+	original := mergeExec
+	mergeExec = func(string) (string, error) { return "fatal: not a git repository", errors.New("exit status 128") }
+	t.Cleanup(func() { mergeExec = original })
+	_, err := mergeState(mergeRepo)
+	testutils.AssertEqual(t, "failed to read merge state: fatal: not a git repository", err.Error())
+}
+
+func TestPlanResolutionMerge(t *testing.T) {
+	commands := fakeMerge(t, "MERGE_HEAD\n--\nmain.go\ndocs/README.md\n", "")
+
+	command, description, err := planResolution(mergeRepo, MergeOurs)
+	testutils.AssertEqual(t, nil, err)
+	testutils.AssertEqual(t, true, strings.HasPrefix(command, "git checkout --ours -- 'main.go' 'docs/README.md' 2>&1 && git add -- 'main.go' 'docs/README.md' 2>&1 && git commit -q -m 'Resolve merge conflicts keeping the local changes"))
+	testutils.AssertEqual(t, true, strings.Contains(command, "\n- main.go\n- docs/README.md'"))
+	testutils.AssertEqual(t, "Resolved 2 conflicted files in %s keeping the local changes", description)
+	testutils.AssertEqual(t, true, strings.Contains((*commands)[1], "for f in 'main.go' 'docs/README.md'; do"))
+}
+
+func TestPlanResolutionRebaseSwapsSides(t *testing.T) {
+	fakeMerge(t, "rebase-apply\n--\nmain.go\n", "")
+
+	command, description, err := planResolution(mergeRepo, MergeTheirs)
+	testutils.AssertEqual(t, nil, err)
+	testutils.AssertEqual(t, "git checkout --ours -- 'main.go' 2>&1 && git add -- 'main.go' 2>&1 && GIT_EDITOR=true git rebase --continue 2>&1", command)
+	testutils.AssertEqual(t, "Resolved 1 conflicted file in %s keeping the upstream changes", description)
+}
+
+func TestPlanResolutionCherryPickContinues(t *testing.T) {
+	fakeMerge(t, "CHERRY_PICK_HEAD\n--\nmain.go\n", "")
+
+	command, _, err := planResolution(mergeRepo, MergeTheirs)
+	testutils.AssertEqual(t, nil, err)
+	testutils.AssertEqual(t, "git checkout --theirs -- 'main.go' 2>&1 && git add -- 'main.go' 2>&1 && GIT_EDITOR=true git cherry-pick --continue 2>&1", command)
+}
+
+func TestPlanResolutionRefusals(t *testing.T) {
+	fakeMerge(t, "MERGE_HEAD\n--\nmain.go\ndocs/README.md\n", "docs/README.md\n")
+	_, _, err := planResolution(mergeRepo, MergeTheirs)
+	testutils.AssertEqual(t, "docs/README.md edited by hand - finish resolving in VS Code, or abort", err.Error())
+
+	fakeMerge(t, "--\n", "")
+	_, _, err = planResolution(mergeRepo, MergeOurs)
+	testutils.AssertEqual(t, "no conflicts to resolve in api", err.Error())
+}
@@ -0,0 +1,479 @@
+package internal
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"workbench/models"
+
+	"github.com/The-Skyscape/devtools/pkg/containers"
+)
+
+// Metric retention for each storage tier. Raw samples live only in memory;
+// the minute tier is kept for the metrics_retention setting.
+const (
+	DefaultMetricsRetention = 7 * 24 * time.Hour
+	HourRetention           = 30 * 24 * time.Hour
+
+	// MaxMetricRows caps rows in the hour tier; the oldest rows are pruned
+	// first. The minute tier's cap follows its retention.
+	MaxMetricRows = 5000
+
+	// MaxMetricPoints caps the points a history query may return.
+	MaxMetricPoints = 2500
+
+	rawSampleInterval = 2 * time.Second
+	rawSampleWindow   = 10 * time.Minute
+)
+
+// MetricSample is a single raw system metrics reading.
+type MetricSample struct {
+	At          time.Time
+	CPU         float64
+	Memory      float64
+	Load        float64
+	DiskPercent float64
+	DataUsed    uint64 // Data directory bytes used
+}
+
+var (
+	rawMu      sync.Mutex
+	rawSamples []MetricSample
+)
+
+// RawMetricSamples returns the in-memory raw samples newer than since.
+func RawMetricSamples(since time.Time) []MetricSample {
+	rawMu.Lock()
+	defer rawMu.Unlock()
+
+	var result []MetricSample
+	for _, s := range rawSamples {
+		if s.At.After(since) {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+// StatsSource provides the latest system stats sample, such as a
+// containers.Collector.
+type StatsSource interface {
+	GetCurrent() (*containers.SystemStats, error)
+}
+
+// StartMetricsPersistence samples the collector every two seconds, writes a
+// one-minute downsample for each completed minute, and rolls completed hours
+// up from the minute tier. Tolerant of restarts: each bucket is derived from
+// whatever samples or minute rows exist, and buckets already stored are skipped.
+// Nothing is written while the data volume is in protective mode. Samples
+// are buffered in memory, so the database sees one write per minute;
+// pruning runs as the metrics-pruning background job. Shown in the jobs
+// panel as metrics-collector, where pausing it stops sampling.
+func StartMetricsPersistence(collector StatsSource) {
+	job := RegisterLoop("metrics-collector", "Sample CPU, memory, and disk use and store history", rawSampleInterval)
+	ticker := time.NewTicker(rawSampleInterval)
+	lastMinute := time.Now().UTC().Truncate(time.Minute)
+
+	for now := range ticker.C {
+		if !job.RunStarted() {
+			continue
+		}
+		now = now.UTC()
+		stats, err := collector.GetCurrent()
+		if err != nil {
+			err = fmt.Errorf("failed to read system stats: %w", err)
+		}
+		if err == nil && stats != nil {
+			sample := MetricSample{
+				At:     now,
+				CPU:    stats.CPU.UsagePercent,
+				Memory: stats.Memory.UsedPercent,
+				Load:   stats.LoadAverage.Load1,
+			}
+			if usage, err := GetStorageUsage(); err == nil {
+				sample.DiskPercent = usage.UsedPercent
+				sample.DataUsed = usage.Used
+			}
+			addRawSample(sample)
+		}
+
+		minute := now.Truncate(time.Minute)
+		if minute.After(lastMinute) && !BackgroundJobsPaused() {
+			persistMinute(lastMinute)
+			if minute.Truncate(time.Hour).After(lastMinute.Truncate(time.Hour)) {
+				persistHour(lastMinute.Truncate(time.Hour))
+			}
+			lastMinute = minute
+		}
+		job.RunFinished(err)
+	}
+}
+
+func addRawSample(sample MetricSample) {
+	rawMu.Lock()
+	defer rawMu.Unlock()
+
+	rawSamples = append(rawSamples, sample)
+	cutoff := sample.At.Add(-rawSampleWindow)
+	for len(rawSamples) > 0 && rawSamples[0].At.Before(cutoff) {
+		rawSamples = rawSamples[1:]
+	}
+}
+
+// persistMinute stores the downsample for a completed minute bucket.
+func persistMinute(bucket time.Time) {
+	if models.MetricMinutes.Count("WHERE Bucket = ?", bucket) > 0 {
+		return
+	}
+
+	row := DownsampleMinute(RawMetricSamples(bucket.Add(-time.Nanosecond)), bucket)
+	if row == nil {
+		return
+	}
+	if _, err := models.MetricMinutes.Insert(row); err != nil {
+		slog.Error("failed to persist minute metrics", "error", err)
+	}
+}
+
+// persistHour stores the rollup for a completed hour bucket.
+func persistHour(bucket time.Time) {
+	if models.MetricHours.Count("WHERE Bucket = ?", bucket) > 0 {
+		return
+	}
+
+	minutes, err := models.MetricMinutes.Search("WHERE Bucket >= ? AND Bucket < ? ORDER BY Bucket ASC", bucket, bucket.Add(time.Hour))
+	if err != nil {
+		slog.Error("failed to load minute metrics for rollup", "error", err)
+		return
+	}
+
+	row := RollupHour(minutes, bucket)
+	if row == nil {
+		return
+	}
+	if _, err := models.MetricHours.Insert(row); err != nil {
+		slog.Error("failed to persist hourly metrics", "error", err)
+	}
+}
+
+// DownsampleMinute derives the minute row for bucket from the raw samples
+// that fall inside it. Returns nil if no samples exist for the minute,
+// e.g. because the workbench was not running.
+func DownsampleMinute(samples []MetricSample, bucket time.Time) *models.MetricMinute {
+	row := &models.MetricMinute{Bucket: bucket}
+	end := bucket.Add(time.Minute)
+
+	var last time.Time
+	for _, s := range samples {
+		if s.At.Before(bucket) || !s.At.Before(end) {
+			continue
+		}
+		row.Samples++
+		row.CPUAvg += s.CPU
+		row.MemoryAvg += s.Memory
+		row.LoadAvg += s.Load
+		row.CPUMax = max(row.CPUMax, s.CPU)
+		row.MemoryMax = max(row.MemoryMax, s.Memory)
+		row.LoadMax = max(row.LoadMax, s.Load)
+		if !s.At.Before(last) {
+			last = s.At
+			row.DiskPercent = s.DiskPercent
+			row.DataUsed = s.DataUsed
+		}
+	}
+
+	if row.Samples == 0 {
+		return nil
+	}
+	n := float64(row.Samples)
+	row.CPUAvg /= n
+	row.MemoryAvg /= n
+	row.LoadAvg /= n
+	return row
+}
+
+// RollupHour derives the hourly row for bucket from the minute rows inside
+// it. Averages are weighted by each minute's sample count so partial
+// minutes around restarts don't skew the result. Returns nil if no minute
+// rows exist for the hour.
+func RollupHour(minutes []*models.MetricMinute, bucket time.Time) *models.MetricHour {
+	row := &models.MetricHour{Bucket: bucket}
+	end := bucket.Add(time.Hour)
+
+	var last time.Time
+	for _, m := range minutes {
+		if m.Bucket.Before(bucket) || !m.Bucket.Before(end) || m.Samples == 0 {
+			continue
+		}
+		w := float64(m.Samples)
+		row.Samples += m.Samples
+		row.CPUAvg += m.CPUAvg * w
+		row.MemoryAvg += m.MemoryAvg * w
+		row.LoadAvg += m.LoadAvg * w
+		row.CPUMax = max(row.CPUMax, m.CPUMax)
+		row.MemoryMax = max(row.MemoryMax, m.MemoryMax)
+		row.LoadMax = max(row.LoadMax, m.LoadMax)
+		if !m.Bucket.Before(last) {
+			last = m.Bucket
+			row.DiskPercent = m.DiskPercent
+			row.DataUsed = m.DataUsed
+		}
+	}
+
+	if row.Samples == 0 {
+		return nil
+	}
+	n := float64(row.Samples)
+	row.CPUAvg /= n
+	row.MemoryAvg /= n
+	row.LoadAvg /= n
+	return row
+}
+
+// planPrune returns the indexes of rows to delete, given row buckets sorted
+// oldest first: everything older than the retention window, plus the
+// oldest remaining rows beyond maxRows.
+func planPrune(buckets []time.Time, now time.Time, retention time.Duration, maxRows int) []int {
+	cutoff := now.Add(-retention)
+
+	var prune []int
+	kept := len(buckets)
+	for i, b := range buckets {
+		if b.Before(cutoff) || kept > maxRows {
+			prune = append(prune, i)
+			kept--
+		}
+	}
+	return prune
+}
+
+// MetricsRetention returns how long minute metrics are kept, from the
+// metrics_retention setting.
+func MetricsRetention() time.Duration {
+	if d := models.GetDurationSetting("metrics_retention"); d > 0 {
+		return d
+	}
+	return DefaultMetricsRetention
+}
+
+// The metrics pruning job enforces retention once an hour, and on start
+// so a shorter retention applies right away.
+func init() {
+	RegisterJob(JobSpec{
+		Name:        "metrics-pruning",
+		Description: "Remove metrics history older than the retention",
+		Interval:    Every(time.Hour),
+		Jitter:      5 * time.Minute,
+		Priority:    PriorityLow,
+		Immediate:   true,
+		Run:         pruneMetrics,
+	})
+}
+
+// pruneMetrics enforces retention and row caps on both persisted tiers.
+func pruneMetrics(now time.Time) error {
+	retention := MetricsRetention()
+	minutes, err := models.MetricMinutes.Search("ORDER BY Bucket ASC")
+	if err != nil {
+		return fmt.Errorf("failed to load minute metrics: %w", err)
+	}
+	buckets := make([]time.Time, len(minutes))
+	for i, m := range minutes {
+		buckets[i] = m.Bucket
+	}
+	for _, i := range planPrune(buckets, now, retention, int(retention/time.Minute)) {
+		if err := models.MetricMinutes.Delete(minutes[i]); err != nil {
+			slog.Error("failed to prune minute metrics", "error", err)
+		}
+	}
+
+	hours, err := models.MetricHours.Search("ORDER BY Bucket ASC")
+	if err != nil {
+		return fmt.Errorf("failed to load hourly metrics: %w", err)
+	}
+	buckets = make([]time.Time, len(hours))
+	for i, h := range hours {
+		buckets[i] = h.Bucket
+	}
+	for _, i := range planPrune(buckets, now, HourRetention, MaxMetricRows) {
+		if err := models.MetricHours.Delete(hours[i]); err != nil {
+			slog.Error("failed to prune hourly metrics", "error", err)
+		}
+	}
+	return nil
+}
+
+// MetricPoint is a single point in a metrics history series.
+type MetricPoint struct {
+	At          time.Time `json:"at"`
+	CPU         float64   `json:"cpu"`
+	CPUMax      float64   `json:"cpu_max"`
+	Memory      float64   `json:"memory"`
+	MemoryMax   float64   `json:"memory_max"`
+	Load        float64   `json:"load"`
+	DiskPercent float64   `json:"disk_percent"`
+	DataUsed    uint64    `json:"data_used_bytes"`
+}
+
+// MetricsHistory returns metrics since the given time at the requested
+// resolution: "raw" (in-memory 2s samples), "minute", or "hour".
+func MetricsHistory(resolution string, since time.Time) ([]MetricPoint, error) {
+	var points []MetricPoint
+
+	switch resolution {
+	case "raw":
+		for _, s := range RawMetricSamples(since) {
+			points = append(points, MetricPoint{At: s.At, CPU: s.CPU, CPUMax: s.CPU, Memory: s.Memory,
+				MemoryMax: s.Memory, Load: s.Load, DiskPercent: s.DiskPercent, DataUsed: s.DataUsed})
+		}
+	case "hour":
+		hours, err := models.MetricHours.Search("WHERE Bucket >= ? ORDER BY Bucket ASC", since)
+		if err != nil {
+			return nil, err
+		}
+		for _, h := range hours {
+			points = append(points, MetricPoint{At: h.Bucket, CPU: h.CPUAvg, CPUMax: h.CPUMax, Memory: h.MemoryAvg,
+				MemoryMax: h.MemoryMax, Load: h.LoadAvg, DiskPercent: h.DiskPercent, DataUsed: h.DataUsed})
+		}
+	default:
+		minutes, err := models.MetricMinutes.Search("WHERE Bucket >= ? ORDER BY Bucket ASC", since)
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range minutes {
+			points = append(points, MetricPoint{At: m.Bucket, CPU: m.CPUAvg, CPUMax: m.CPUMax, Memory: m.MemoryAvg,
+				MemoryMax: m.MemoryMax, Load: m.LoadAvg, DiskPercent: m.DiskPercent, DataUsed: m.DataUsed})
+		}
+	}
+
+	sort.Slice(points, func(i, j int) bool { return points[i].At.Before(points[j].At) })
+	return points, nil
+}
+
+// MetricRange is a span of history a chart can show, with the step its
+// points are averaged over by default.
+type MetricRange struct {
+	Name string
+	Span time.Duration
+	Step time.Duration
+}
+
+// MetricRanges are the ranges accepted by MetricsRange, shortest first.
+var MetricRanges = []MetricRange{
+	{"1h", time.Hour, time.Minute},
+	{"24h", 24 * time.Hour, 10 * time.Minute},
+	{"7d", 7 * 24 * time.Hour, time.Hour},
+}
+
+// InvalidMetricRangeError is returned by MetricsRange for a range or step
+// it doesn't accept.
+type InvalidMetricRangeError struct {
+	Reason string
+}
+
+func (e *InvalidMetricRangeError) Error() string {
+	return e.Reason
+}
+
+// MetricsRange returns history for a named range ending at now, averaged
+// into buckets of step; zero uses the range's default step. Steps of an
+// hour or more read the hour tier. Returns the step used.
+func MetricsRange(name string, step time.Duration, now time.Time) ([]MetricPoint, time.Duration, error) {
+	i := slices.IndexFunc(MetricRanges, func(r MetricRange) bool { return r.Name == name })
+	if i < 0 {
+		return nil, 0, &InvalidMetricRangeError{"range must be 1h, 24h, or 7d"}
+	}
+	r := MetricRanges[i]
+	if step == 0 {
+		step = r.Step
+	}
+	if err := checkMetricStep(r, step); err != nil {
+		return nil, 0, err
+	}
+
+	resolution := "minute"
+	if step >= time.Hour && step%time.Hour == 0 {
+		resolution = "hour"
+	}
+	points, err := metricsHistoryFor(resolution, now.Add(-r.Span))
+	if err != nil {
+		return nil, 0, err
+	}
+	return BucketMetricPoints(points, step), step, nil
+}
+
+// metricsHistoryFor loads persisted history. Replaced in tests.
+var metricsHistoryFor = MetricsHistory
+
+// checkMetricStep rejects steps finer than the minute tier or too fine to
+// keep a range's points under MaxMetricPoints.
+func checkMetricStep(r MetricRange, step time.Duration) error {
+	if step < time.Minute || step%time.Minute != 0 {
+		return &InvalidMetricRangeError{"step must be a whole number of minutes, such as 5m"}
+	}
+	if r.Span/step > MaxMetricPoints {
+		smallest := (r.Span/MaxMetricPoints + time.Minute - 1).Truncate(time.Minute)
+		return &InvalidMetricRangeError{fmt.Sprintf("step is too small for %s - use %s or more", r.Name, smallest)}
+	}
+	return nil
+}
+
+// BucketMetricPoints averages points, sorted oldest first, into buckets of
+// step aligned to the Unix epoch. Maximums are kept, and disk usage is the
+// last reading in each bucket. Empty buckets are left out, so gaps while
+// the workbench was down stay visible.
+func BucketMetricPoints(points []MetricPoint, step time.Duration) []MetricPoint {
+	result := []MetricPoint{}
+	var n float64
+	for _, p := range points {
+		bucket := p.At.Truncate(step)
+		if len(result) == 0 || !result[len(result)-1].At.Equal(bucket) {
+			finishMetricBucket(result, n)
+			result = append(result, MetricPoint{At: bucket})
+			n = 0
+		}
+		b := &result[len(result)-1]
+		n++
+		b.CPU += p.CPU
+		b.Memory += p.Memory
+		b.Load += p.Load
+		b.CPUMax = max(b.CPUMax, p.CPUMax)
+		b.MemoryMax = max(b.MemoryMax, p.MemoryMax)
+		b.DiskPercent = p.DiskPercent
+		b.DataUsed = p.DataUsed
+	}
+	finishMetricBucket(result, n)
+	return result
+}
+
+// finishMetricBucket turns the last bucket's sums into averages.
+func finishMetricBucket(points []MetricPoint, n float64) {
+	if len(points) == 0 || n == 0 {
+		return
+	}
+	b := &points[len(points)-1]
+	b.CPU /= n
+	b.Memory /= n
+	b.Load /= n
+}
+
+// SparklinePoints scales values into an SVG polyline "points" attribute for
+// a width x height box, with 0-100 mapped bottom to top. Returns an empty
+// string when there are fewer than two values to draw.
+func SparklinePoints(values []float64, width, height float64) string {
+	if len(values) < 2 {
+		return ""
+	}
+
+	step := width / float64(len(values)-1)
+	points := make([]string, len(values))
+	for i, v := range values {
+		v = min(max(v, 0), 100)
+		points[i] = fmt.Sprintf("%.1f,%.1f", float64(i)*step, height-v/100*height)
+	}
+	return strings.Join(points, " ")
+}
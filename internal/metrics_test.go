@@ -0,0 +1,202 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+	"workbench/models"
+
+	"github.com/The-Skyscape/devtools/pkg/testutils"
+)
+
+var metricsBase = time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+func sample(offset time.Duration, cpu float64) MetricSample {
+	return MetricSample{At: metricsBase.Add(offset), CPU: cpu, Memory: cpu / 2, Load: cpu / 100, DiskPercent: cpu}
+}
+
+func TestDownsampleMinute(t *testing.T) {
+	samples := []MetricSample{
+		sample(-2*time.Second, 99), // previous minute
+		sample(0, 10),
+		sample(2*time.Second, 30),
+		sample(4*time.Second, 20),
+		sample(time.Minute, 99), // next minute
+	}
+
+	row := DownsampleMinute(samples, metricsBase)
+	testutils.AssertEqual(t, 3, row.Samples)
+	testutils.AssertEqual(t, 20.0, row.CPUAvg)
+	testutils.AssertEqual(t, 30.0, row.CPUMax)
+	testutils.AssertEqual(t, 10.0, row.MemoryAvg)
+	testutils.AssertEqual(t, 15.0, row.MemoryMax)
+	testutils.AssertEqual(t, 20.0, row.DiskPercent)
+}
+
+func TestDownsampleMinuteGap(t *testing.T) {
+	// The process was down for this minute, so no row is derived.
+	samples := []MetricSample{sample(-time.Minute, 50), sample(2*time.Minute, 50)}
+	testutils.AssertEqual(t, true, DownsampleMinute(samples, metricsBase) == nil)
+}
+
+func TestRollupHour(t *testing.T) {
+	minutes := []*models.MetricMinute{
+		{Bucket: metricsBase, Samples: 30, CPUAvg: 10, CPUMax: 40, DiskPercent: 50},
+		{Bucket: metricsBase.Add(time.Minute), Samples: 10, CPUAvg: 50, CPUMax: 90, DiskPercent: 55},
+		{Bucket: metricsBase.Add(time.Hour), Samples: 30, CPUAvg: 100, CPUMax: 100}, // next hour
+	}
+
+	row := RollupHour(minutes, metricsBase)
+	testutils.AssertEqual(t, 40, row.Samples)
+	testutils.AssertEqual(t, 20.0, row.CPUAvg) // (30*10 + 10*50) / 40
+	testutils.AssertEqual(t, 90.0, row.CPUMax)
+	testutils.AssertEqual(t, 55.0, row.DiskPercent)
+}
+
+func TestRollupAcrossRestart(t *testing.T) {
+	// Samples before a restart, nothing for two minutes, then a partial
+	// minute after the restart. Each minute is derived independently and
+	// the hour weights by the samples actually collected.
+	before := []MetricSample{sample(0, 20), sample(2*time.Second, 20)}
+	after := []MetricSample{sample(3*time.Minute+58*time.Second, 80)}
+
+	var minutes []*models.MetricMinute
+	for i := 0; i < 4; i++ {
+		bucket := metricsBase.Add(time.Duration(i) * time.Minute)
+		raw := before
+		if i >= 2 {
+			raw = after
+		}
+		if row := DownsampleMinute(raw, bucket); row != nil {
+			minutes = append(minutes, row)
+		}
+	}
+	testutils.AssertEqual(t, 2, len(minutes))
+
+	row := RollupHour(minutes, metricsBase)
+	testutils.AssertEqual(t, 3, row.Samples)
+	testutils.AssertEqual(t, 40.0, row.CPUAvg)
+	testutils.AssertEqual(t, 80.0, row.CPUMax)
+}
+
+func TestRollupHourEmpty(t *testing.T) {
+	testutils.AssertEqual(t, true, RollupHour(nil, metricsBase) == nil)
+}
+
+func TestPlanPrune(t *testing.T) {
+	buckets := []time.Time{
+		metricsBase.Add(-50 * time.Hour),
+		metricsBase.Add(-3 * time.Hour),
+		metricsBase.Add(-2 * time.Hour),
+		metricsBase.Add(-time.Hour),
+	}
+
+	testCases := []struct {
+		name     string
+		maxRows  int
+		expected string
+	}{
+		{"retention only", 10, "[0]"},
+		{"row cap prunes oldest first", 2, "[0 1]"},
+		{"row cap of one", 1, "[0 1 2]"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			testutils.AssertEqual(t, tc.expected, fmt.Sprint(planPrune(buckets, metricsBase, 48*time.Hour, tc.maxRows)))
+		})
+	}
+}
+
+func TestSparklinePoints(t *testing.T) {
+	testutils.AssertEqual(t, "", SparklinePoints([]float64{50}, 100, 24))
+	testutils.AssertEqual(t, "0.0,24.0 50.0,12.0 100.0,0.0", SparklinePoints([]float64{0, 50, 150}, 100, 24))
+}
+
+func TestBucketMetricPoints(t *testing.T) {
+	points := []MetricPoint{
+		{At: metricsBase, CPU: 10, CPUMax: 20, Memory: 40, DiskPercent: 50, DataUsed: 100},
+		{At: metricsBase.Add(time.Minute), CPU: 30, CPUMax: 60, Memory: 60, DiskPercent: 51, DataUsed: 110},
+		{At: metricsBase.Add(4 * time.Minute), CPU: 50, CPUMax: 50, Memory: 10, DiskPercent: 52, DataUsed: 120},
+		// Nothing for 5-10 minutes: the workbench was down
+		{At: metricsBase.Add(10 * time.Minute), CPU: 90, CPUMax: 95, Memory: 90, DiskPercent: 53, DataUsed: 130},
+	}
+
+	buckets := BucketMetricPoints(points, 5*time.Minute)
+	testutils.AssertEqual(t, 2, len(buckets))
+	testutils.AssertEqual(t, metricsBase, buckets[0].At)
+	testutils.AssertEqual(t, 30.0, buckets[0].CPU)
+	testutils.AssertEqual(t, 60.0, buckets[0].CPUMax)
+	testutils.AssertEqual(t, 110.0/3, buckets[0].Memory)
+	testutils.AssertEqual(t, 52.0, buckets[0].DiskPercent)
+	testutils.AssertEqual(t, uint64(120), buckets[0].DataUsed)
+	testutils.AssertEqual(t, metricsBase.Add(10*time.Minute), buckets[1].At)
+	testutils.AssertEqual(t, 90.0, buckets[1].CPU)
+}
+
+func TestBucketMetricPointsEmpty(t *testing.T) {
+	buckets := BucketMetricPoints(nil, time.Minute)
+	testutils.AssertEqual(t, true, buckets != nil)
+	testutils.AssertEqual(t, 0, len(buckets))
+}
+
+func TestMetricsRange(t *testing.T) {
+	var gotResolution string
+	var gotSince time.Time
+	original := metricsHistoryFor
+	metricsHistoryFor = func(resolution string, since time.Time) ([]MetricPoint, error) {
+		gotResolution, gotSince = resolution, since
+		return []MetricPoint{{At: metricsBase, CPU: 10}, {At: metricsBase.Add(time.Minute), CPU: 20}}, nil
+	}
+	t.Cleanup(func() { metricsHistoryFor = original })
+
+	testCases := []struct {
+		name       string
+		step       time.Duration
+		resolution string
+		usedStep   time.Duration
+		points     int
+	}{
+		{"1h", 0, "minute", time.Minute, 2},
+		{"24h", 0, "minute", 10 * time.Minute, 1},
+		{"7d", 0, "hour", time.Hour, 1},
+		{"7d", 5 * time.Minute, "minute", 5 * time.Minute, 1},
+		{"24h", 2 * time.Hour, "hour", 2 * time.Hour, 1},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("%s/%s", tc.name, tc.step), func(t *testing.T) {
+			points, step, err := MetricsRange(tc.name, tc.step, metricsBase)
+			testutils.AssertEqual(t, nil, err)
+			testutils.AssertEqual(t, tc.resolution, gotResolution)
+			testutils.AssertEqual(t, tc.usedStep, step)
+			testutils.AssertEqual(t, tc.points, len(points))
+			i := slices.IndexFunc(MetricRanges, func(r MetricRange) bool { return r.Name == tc.name })
+			testutils.AssertEqual(t, metricsBase.Add(-MetricRanges[i].Span), gotSince)
+		})
+	}
+}
+
+func TestMetricsRangeRejectsInvalidQueries(t *testing.T) {
+	testCases := []struct {
+		name     string
+		step     time.Duration
+		expected string
+	}{
+		{"30d", 0, "range must be 1h, 24h, or 7d"},
+		{"1h", 30 * time.Second, "step must be a whole number of minutes, such as 5m"},
+		{"1h", 90 * time.Second, "step must be a whole number of minutes, such as 5m"},
+		{"7d", 4 * time.Minute, "step is too small for 7d - use 5m0s or more"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("%s/%s", tc.name, tc.step), func(t *testing.T) {
+			_, _, err := MetricsRange(tc.name, tc.step, metricsBase)
+			var invalid *InvalidMetricRangeError
+			testutils.AssertEqual(t, true, errors.As(err, &invalid))
+			testutils.AssertEqual(t, tc.expected, err.Error())
+		})
+	}
+}
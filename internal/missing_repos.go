@@ -0,0 +1,201 @@
+package internal
+
+import (
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"workbench/models"
+)
+
+// Values for the missing_repo_behavior setting: what a pull does when the
+// repository's directory is gone.
+const (
+	MissingRepoReclone = "auto-reclone" // Re-clone it and notify (the default)
+	MissingRepoPrompt  = "prompt"       // Ask before re-cloning
+	MissingRepoFail    = "fail"         // Fail the pull
+)
+
+// volumeSuspectCount repositories found missing within volumeSuspectWindow
+// suggest the data volume failed rather than individual deletions.
+const (
+	volumeSuspectCount  = 3
+	volumeSuspectWindow = time.Hour
+)
+
+// MissingRepoError is returned by pulls when the repository directory is
+// missing and missing_repo_behavior is prompt or fail.
+type MissingRepoError struct {
+	Repo   string
+	Path   string
+	Prompt bool // Offer to re-clone instead of reporting a failure
+}
+
+func (e *MissingRepoError) Error() string {
+	return fmt.Sprintf("the directory for %s is missing (%s) - re-clone it, or check the data volume if other repositories are missing too", e.Repo, e.Path)
+}
+
+// MissingRepoBehavior returns the configured missing_repo_behavior.
+func MissingRepoBehavior() string {
+	return models.SettingOrDefault("missing_repo_behavior")
+}
+
+// SetMissingRepoBehavior saves what pulls do with missing repositories.
+func SetMissingRepoBehavior(behavior string) error {
+	switch behavior {
+	case MissingRepoReclone, MissingRepoPrompt, MissingRepoFail:
+	default:
+		return fmt.Errorf("invalid missing repository behavior")
+	}
+	if _, err := models.SetSetting("missing_repo_behavior", behavior, "user_preference"); err != nil {
+		return fmt.Errorf("failed to save preference")
+	}
+	return nil
+}
+
+// missingTracker notices several repositories going missing close
+// together, which points at the data volume.
+type missingTracker struct {
+	mu        sync.Mutex
+	seen      map[string]time.Time // Repository name to when it was found missing
+	escalated time.Time            // Last volume suspicion, at most once per window
+}
+
+var missingRepos = &missingTracker{seen: map[string]time.Time{}}
+
+// record notes a repository found missing and returns the repositories
+// missing within the window when they reach volumeSuspectCount and no
+// suspicion was raised within the window.
+func (t *missingTracker) record(name string, now time.Time) []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.seen[name] = now
+	var recent []string
+	for repo, at := range t.seen {
+		if now.Sub(at) > volumeSuspectWindow {
+			delete(t.seen, repo)
+			continue
+		}
+		recent = append(recent, repo)
+	}
+	if len(recent) < volumeSuspectCount || (!t.escalated.IsZero() && now.Sub(t.escalated) < volumeSuspectWindow) {
+		return nil
+	}
+	t.escalated = now
+	sort.Strings(recent)
+	return recent
+}
+
+// repoMissingActivity records a repository found missing, notifying the
+// first time it is flagged, and escalates when several go missing within
+// an hour whatever missing_repo_behavior is. context says where it was
+// noticed, e.g. "during a pull".
+func repoMissingActivity(repo *models.Repository, flag bool, context string) {
+	if flag && !repo.Missing {
+		repo.Missing = true
+		if err := models.Repositories.Update(repo); err != nil {
+			slog.Error("failed to flag repository as missing", "name", repo.Name, "error", err)
+		}
+		go models.Activities.Insert(&models.Activity{
+			Type:        "repo_missing",
+			Repository:  repo.Name,
+			Description: fmt.Sprintf("Repository %s is missing from %s (%s)", repo.Name, repo.LocalPath, context),
+			Author:      "System",
+			Timestamp:   time.Now(),
+		})
+	}
+
+	if recent := missingRepos.record(repo.Name, time.Now()); recent != nil {
+		go models.Activities.Insert(&models.Activity{
+			Type:       "volume_suspect",
+			Repository: "",
+			Description: fmt.Sprintf("%d repositories went missing within an hour (%s) - the data volume may have failed",
+				len(recent), strings.Join(recent, ", ")),
+			Author:    "System",
+			Timestamp: time.Now(),
+		})
+	}
+}
+
+// handleMissingRepository applies missing_repo_behavior to a pull whose
+// repository directory is gone.
+func handleMissingRepository(repo *models.Repository) error {
+	behavior := MissingRepoBehavior()
+	if behavior != MissingRepoReclone {
+		repoMissingActivity(repo, true, "found during a pull")
+		return &MissingRepoError{Repo: repo.Name, Path: repo.LocalPath, Prompt: behavior == MissingRepoPrompt}
+	}
+
+	slog.Warn("repository directory missing, attempting to re-clone", "name", repo.Name)
+	repoMissingActivity(repo, false, "found during a pull")
+	if err := recloneRepository(repo); err != nil {
+		return err
+	}
+	go models.Activities.Insert(&models.Activity{
+		Type:        "repo_recloned",
+		Repository:  repo.Name,
+		Description: fmt.Sprintf("Re-cloned %s because its directory was missing", repo.Name),
+		Author:      "System",
+		Timestamp:   time.Now(),
+	})
+	return nil
+}
+
+// RecloneRepository re-clones a repository whose directory is missing,
+// at the user's request.
+func RecloneRepository(repoName string) error {
+	repo, err := models.Repositories.Find("WHERE Name = ?", repoName)
+	if err != nil {
+		return fmt.Errorf("repository '%s' not found", repoName)
+	}
+	if repo.IsLocal() {
+		return fmt.Errorf("'%s' has no remote to re-clone from", repoName)
+	}
+	if _, err := repoExec("test", "-d", repo.LocalPath); err == nil {
+		return fmt.Errorf("%s is no longer missing - pull it instead", repoName)
+	}
+
+	if err := recloneRepository(repo); err != nil {
+		return err
+	}
+	go models.Activities.Insert(&models.Activity{
+		Type:        "repo_clone",
+		Repository:  repo.Name,
+		Description: fmt.Sprintf("Re-cloned missing repository %s", repo.Name),
+		Author:      "System",
+		Timestamp:   time.Now(),
+	})
+	return nil
+}
+
+// recloneRepository clones a repository back into its recorded path,
+// clears its Missing flag, and runs its post-clone hooks.
+func recloneRepository(repo *models.Repository) error {
+	repoExec("mkdir", "-p", "--", filepath.Dir(repo.LocalPath))
+	output, err := recloneExec("git", "clone", "--", repo.URL, repo.LocalPath)
+	if err != nil {
+		return classifyRemoteError(output, err, "repository directory was missing and re-clone failed")
+	}
+	clearMissing(repo)
+	go runPostCloneHooks(repo.Name)
+	return nil
+}
+
+// recloneExec runs the re-clone, which may outlast a plain command.
+// Replaced in tests.
+var recloneExec = operationExecArgs
+
+// clearMissing removes the Missing flag once the directory is back.
+func clearMissing(repo *models.Repository) {
+	if !repo.Missing {
+		return
+	}
+	repo.Missing = false
+	if err := models.Repositories.Update(repo); err != nil {
+		slog.Error("failed to clear missing flag", "name", repo.Name, "error", err)
+	}
+}
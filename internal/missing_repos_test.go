@@ -0,0 +1,86 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+	"workbench/models"
+
+	"github.com/The-Skyscape/devtools/pkg/testutils"
+)
+
+func TestMissingTrackerSuspectsVolume(t *testing.T) {
+	tracker := &missingTracker{seen: map[string]time.Time{}}
+	t0 := time.Date(2026, 10, 1, 9, 0, 0, 0, time.UTC)
+
+	testutils.AssertEqual(t, true, tracker.record("api", t0) == nil)
+	testutils.AssertEqual(t, true, tracker.record("api", t0.Add(time.Minute)) == nil) // Same repository again
+	testutils.AssertEqual(t, true, tracker.record("web", t0.Add(10*time.Minute)) == nil)
+
+	recent := tracker.record("docs", t0.Add(20*time.Minute))
+	testutils.AssertEqual(t, "api,docs,web", strings.Join(recent, ","))
+
+	// Raised once per window
+	testutils.AssertEqual(t, true, tracker.record("cli", t0.Add(30*time.Minute)) == nil)
+
+	// Discoveries spread over more than an hour don't add up
+	spread := &missingTracker{seen: map[string]time.Time{}}
+	spread.record("api", t0)
+	spread.record("web", t0.Add(50*time.Minute))
+	testutils.AssertEqual(t, true, spread.record("docs", t0.Add(70*time.Minute)) == nil)
+}
+
+func TestMissingRepoError(t *testing.T) {
+	var err error = fmt.Errorf("pull: %w", &MissingRepoError{Repo: "api", Path: "/home/coder/repos/api", Prompt: true})
+
+	var missing *MissingRepoError
+	testutils.AssertEqual(t, true, errors.As(err, &missing))
+	testutils.AssertEqual(t, true, missing.Prompt)
+	testutils.AssertEqual(t, "", ClassifyError(missing.Error()))
+}
+
+func TestNotificationLevel(t *testing.T) {
+	testCases := []struct {
+		activityType string
+		expected     string
+	}{
+		{"volume_suspect", "critical"},
+		{"repo_pull_failed", "critical"},
+		{"repo_missing", "warning"},
+		{"repo_recloned", "warning"},
+		{"repo_pull", "info"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.activityType, func(t *testing.T) {
+			testutils.AssertEqual(t, tc.expected, NotificationLevel(&models.Activity{Type: tc.activityType}))
+		})
+	}
+}
+
+func TestRecloneRepositoryFailure(t *testing.T) {
+	testCases := []struct {
+		name     string
+		output   string
+		expected string
+	}{
+		{"auth", "git@github.com: Permission denied (publickey).\nfatal: Could not read from remote repository.", "authentication failed"},
+		{"gone", "remote: Repository not found.", "repository not found"},
+		{"unknown", "fatal: early EOF", "repository directory was missing and re-clone failed"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dirs := fakeArgsScript(t, &repoExec)
+			fakeArgsScript(t, &recloneExec).on("git clone", tc.output, errors.New("exit status 128"))
+			repo := &models.Repository{Name: "api", URL: "git@github.com:acme/api.git", LocalPath: "/home/coder/repos/api", Missing: true}
+
+			err := recloneRepository(repo)
+			testutils.AssertEqual(t, true, err != nil && strings.HasPrefix(err.Error(), tc.expected))
+			testutils.AssertEqual(t, true, dirs.ran("mkdir -p -- /home/coder/repos"))
+			testutils.AssertEqual(t, true, repo.Missing) // Still missing until a clone succeeds
+		})
+	}
+}
@@ -0,0 +1,40 @@
+package internal
+
+import (
+	"syscall"
+
+	"github.com/The-Skyscape/devtools/pkg/database"
+)
+
+// DataDirUsage describes disk usage of the persistent data directory.
+type DataDirUsage struct {
+	Path        string
+	Total       uint64
+	Used        uint64
+	Free        uint64
+	UsedPercent float64
+}
+
+// GetDataDirUsage returns disk usage for the persistent data directory.
+// This tracks only data that persists between container restarts
+// (repos, database, etc.), NOT the system disk.
+func GetDataDirUsage() (*DataDirUsage, error) {
+	dataDir := database.DataDir()
+
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dataDir, &stat); err != nil {
+		return nil, err
+	}
+
+	// Calculate sizes in bytes
+	usage := &DataDirUsage{
+		Path:  dataDir,
+		Total: stat.Blocks * uint64(stat.Bsize),
+		Free:  stat.Bavail * uint64(stat.Bsize),
+	}
+	usage.Used = usage.Total - usage.Free
+	if usage.Total > 0 {
+		usage.UsedPercent = float64(usage.Used) / float64(usage.Total) * 100.0
+	}
+	return usage, nil
+}
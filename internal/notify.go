@@ -0,0 +1,114 @@
+package internal
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+	"workbench/models"
+)
+
+// maxHeldNotifications bounds notifications held during quiet hours; the
+// oldest are dropped first (they remain in the activity log).
+const maxHeldNotifications = 100
+
+// signinBurst is how many failed sign-ins in one minute are notified, as
+// one notification. Fewer are left to the activity log.
+const signinBurst = 3
+
+// NotifyWebhookURL returns the URL notifications are posted to as JSON,
+// or empty string when there is no webhook.
+func NotifyWebhookURL() string {
+	url, _ := models.GetSetting("notify_webhook_url")
+	return url
+}
+
+// Notification is what the notification channels send for an activity;
+// the webhook posts it as JSON.
+type Notification struct {
+	Type        string    `json:"type"`
+	Repository  string    `json:"repository,omitempty"`
+	Description string    `json:"description"`
+	Critical    bool      `json:"critical"`
+	Level       string    `json:"level"` // critical, warning, or info
+	Timestamp   time.Time `json:"timestamp"`
+}
+
+// sendNotification hands an activity to the notification channels.
+// Replaced in tests.
+var sendNotification = Notify
+
+// notifier decides, for each new activity, whether to send it now, hold
+// it until quiet hours end, or leave it to the digest.
+type notifier struct {
+	mu       sync.Mutex
+	lastSeen time.Time          // Activities up to here have been handled
+	held     []*models.Activity // Waiting out quiet hours, oldest first
+}
+
+var notifications = &notifier{lastSeen: time.Now()}
+
+// dispatchNotifications sends activities recorded since the last run,
+// releasing held ones once quiet hours are over. Called by the digest
+// scheduler every minute, so critical events go out within a minute.
+func dispatchNotifications(now time.Time) {
+	notifications.mu.Lock()
+	since := notifications.lastSeen
+	notifications.mu.Unlock()
+
+	activities, err := models.Activities.Search("WHERE CreatedAt > ? ORDER BY CreatedAt ASC", since)
+	if err != nil {
+		slog.Error("failed to load activities for notifications", "error", err)
+		return
+	}
+
+	notifications.handle(activities, DigestMode(), InQuietHours(now))
+}
+
+// handle sends or holds each activity. Routine events under digest mode
+// are dropped here because the digest summarizes them, and failed
+// sign-ins are only sent as a burst.
+func (n *notifier) handle(activities []*models.Activity, mode string, quiet bool) {
+	n.mu.Lock()
+	var send []*models.Activity
+	if !quiet {
+		send, n.held = n.held, nil
+	}
+	var signinFailures []*models.Activity
+	for _, a := range activities {
+		switch {
+		case a.Type == "activity_digest":
+			// Sent when composed
+		case a.Type == notificationUndelivered:
+			// Sending it could fail the same way again
+		case a.Type == AuthSigninFailed:
+			signinFailures = append(signinFailures, a)
+		case shouldNotify(a, mode, quiet):
+			send = append(send, a)
+		case IsRoutineActivity(a) && mode != DigestOff:
+			// Summarized by the digest
+		default:
+			n.held = append(n.held, a)
+		}
+	}
+	if len(signinFailures) >= signinBurst {
+		last := signinFailures[len(signinFailures)-1]
+		send = append(send, &models.Activity{
+			Type:        AuthSigninFailed,
+			Description: fmt.Sprintf("%d failed sign-ins in the last minute", len(signinFailures)),
+			Author:      "System",
+			Timestamp:   last.Timestamp,
+		})
+	}
+	if over := len(n.held) - maxHeldNotifications; over > 0 {
+		n.held = n.held[over:]
+	}
+	if len(activities) > 0 {
+		n.lastSeen = activities[len(activities)-1].CreatedAt
+	}
+	n.mu.Unlock()
+
+	for _, a := range send {
+		sendNotification(a)
+	}
+}
@@ -0,0 +1,378 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+	"workbench/models"
+)
+
+// Notification delivery limits.
+const (
+	notifyAttempts = 3 // Tries per channel before a notification is recorded as undelivered
+
+	// maxInAppNotifications caps stored in-app notifications; the oldest
+	// are pruned first.
+	maxInAppNotifications = 200
+
+	// notificationUndelivered is the activity recorded when a channel gives
+	// up on a notification.
+	notificationUndelivered = "notification_undelivered"
+)
+
+// Settings of the notification channels kept out of the settings page.
+const (
+	notifyWebhookSecretKey = "notify_webhook_secret"
+	smtpPasswordKey        = "smtp_password" // Encrypted like git credential tokens
+)
+
+// NotifyChannel is one way notifications go out. Send is retried with
+// backoff when it fails.
+type NotifyChannel struct {
+	Name    string
+	Enabled func() bool
+	Send    func(n *Notification) error
+}
+
+// Notification channels and delivery seams. Replaced in tests.
+var (
+	notifyChannels = []*NotifyChannel{
+		{Name: "in-app", Enabled: func() bool { return models.GetBoolSetting("notify_in_app") }, Send: saveInAppNotification},
+		{Name: "webhook", Enabled: webhookChannelEnabled, Send: postWebhookNotification},
+		{Name: "email", Enabled: emailChannelEnabled, Send: emailNotification},
+	}
+
+	notifyBackoff = 30 * time.Second // Wait before the first retry, doubled for each after
+
+	recordUndelivered = func(channel string, n *Notification, err error) {
+		models.Activities.Insert(&models.Activity{
+			Type:        notificationUndelivered,
+			Repository:  n.Repository,
+			Description: fmt.Sprintf("Couldn't deliver the %s notification by %s after %d attempts: %v", n.Type, channel, notifyAttempts, err),
+			Author:      "System",
+			Timestamp:   time.Now(),
+		})
+	}
+)
+
+var notifyClient = &http.Client{Timeout: 10 * time.Second}
+
+// Notify sends an activity to every enabled notification channel. Each
+// channel delivers in the background, retrying a failed send with
+// backoff, so the code that raised the event never waits on a slow or
+// unreachable channel.
+func Notify(a *models.Activity) {
+	n := &Notification{
+		Type:        a.Type,
+		Repository:  a.Repository,
+		Description: a.Description,
+		Critical:    IsCriticalActivity(a),
+		Level:       NotificationLevel(a),
+		Timestamp:   a.Timestamp,
+	}
+	for _, channel := range notifyChannels {
+		if channel.Enabled() {
+			go deliverNotification(channel, n)
+		}
+	}
+}
+
+// deliverNotification sends n by one channel, trying notifyAttempts times
+// and recording a notification_undelivered activity when every try fails.
+func deliverNotification(channel *NotifyChannel, n *Notification) error {
+	wait := notifyBackoff
+	var err error
+	for attempt := 1; attempt <= notifyAttempts; attempt++ {
+		if err = channel.Send(n); err == nil {
+			return nil
+		}
+		slog.Warn("failed to send notification", "type", n.Type, "channel", channel.Name, "attempt", attempt, "error", err)
+		if attempt < notifyAttempts {
+			time.Sleep(wait)
+			wait *= 2
+		}
+	}
+	recordUndelivered(channel.Name, n, err)
+	return err
+}
+
+// saveInAppNotification stores a notification for the header's bell,
+// pruning the oldest beyond maxInAppNotifications.
+func saveInAppNotification(n *Notification) error {
+	if _, err := models.Notifications.Insert(&models.Notification{
+		Type:        n.Type,
+		Repository:  n.Repository,
+		Description: n.Description,
+		Level:       n.Level,
+		Timestamp:   n.Timestamp,
+	}); err != nil {
+		return fmt.Errorf("failed to save notification: %w", err)
+	}
+
+	if over := models.Notifications.Count("") - maxInAppNotifications; over > 0 {
+		oldest, err := models.Notifications.Search("ORDER BY CreatedAt ASC LIMIT ?", over)
+		if err != nil {
+			return nil // Pruned next time
+		}
+		for _, old := range oldest {
+			models.Notifications.Delete(old)
+		}
+	}
+	return nil
+}
+
+// GetNotifications returns the newest in-app notifications, up to limit,
+// and how many are unread.
+func GetNotifications(limit int) ([]*models.Notification, int, error) {
+	recent, err := models.Notifications.Search("ORDER BY CreatedAt DESC LIMIT ?", limit)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to load notifications")
+	}
+	return recent, models.Notifications.Count("WHERE Read = ?", false), nil
+}
+
+// MarkNotificationRead marks one in-app notification read.
+func MarkNotificationRead(id string) error {
+	n, err := models.Notifications.Get(id)
+	if err != nil || n == nil {
+		return &repoError{ErrRepoNotFound, "notification not found"}
+	}
+	if n.Read {
+		return nil
+	}
+	n.Read = true
+	if err := models.Notifications.Update(n); err != nil {
+		return fmt.Errorf("failed to save notification")
+	}
+	return nil
+}
+
+// MarkAllNotificationsRead marks every unread in-app notification read.
+func MarkAllNotificationsRead() error {
+	unread, err := models.Notifications.Search("WHERE Read = ?", false)
+	if err != nil {
+		return fmt.Errorf("failed to load notifications")
+	}
+	for _, n := range unread {
+		n.Read = true
+		if err := models.Notifications.Update(n); err != nil {
+			return fmt.Errorf("failed to save notification")
+		}
+	}
+	return nil
+}
+
+func webhookChannelEnabled() bool {
+	return models.GetBoolSetting("notify_webhook") && NotifyWebhookURL() != ""
+}
+
+// postWebhookNotification posts n as JSON to the notification webhook.
+// With notify_webhook_secret set, the body is signed with HMAC-SHA256 in
+// X-Workbench-Signature-256, as "sha256=" and the hex digest.
+func postWebhookNotification(n *Notification) error {
+	body, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, NotifyWebhookURL(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if secret, _ := models.GetSetting(notifyWebhookSecretKey); secret != "" {
+		req.Header.Set("X-Workbench-Signature-256", signNotification(secret, body))
+	}
+
+	resp, err := notifyClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach notification webhook: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("notification webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// signNotification returns the X-Workbench-Signature-256 value of body.
+func signNotification(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// SMTPSettings configures the email notification channel. Password is
+// write-only: it's stored encrypted and never read back into a form.
+type SMTPSettings struct {
+	Host        string
+	Port        int
+	Username    string
+	Password    string // Keeps the saved password when empty
+	From        string
+	To          string
+	PasswordSet bool // A password is saved
+}
+
+// GetSMTPSettings returns the email channel's settings without the
+// password.
+func GetSMTPSettings() SMTPSettings {
+	host, _ := models.GetSetting("smtp_host")
+	username, _ := models.GetSetting("smtp_username")
+	from, _ := models.GetSetting("smtp_from")
+	to, _ := models.GetSetting("notify_email_to")
+	password, _ := models.GetSetting(smtpPasswordKey)
+	return SMTPSettings{
+		Host: host, Port: models.GetIntSetting("smtp_port"), Username: username,
+		From: from, To: to, PasswordSet: password != "",
+	}
+}
+
+// SaveSMTPSettings checks and saves the email channel's settings. The
+// password is encrypted with the server secret before it's stored.
+func SaveSMTPSettings(s SMTPSettings) error {
+	s.Host = strings.TrimSpace(s.Host)
+	if s.Host != "" && !validCredentialHost.MatchString(s.Host) {
+		return &repoError{ErrInvalidInput, "invalid SMTP host - use a hostname such as smtp.example.com"}
+	}
+
+	port := "" // The default port
+	if s.Port != 0 {
+		port = strconv.Itoa(s.Port)
+	}
+	values := map[string]string{
+		"smtp_host":       s.Host,
+		"smtp_port":       port,
+		"smtp_username":   strings.TrimSpace(s.Username),
+		"smtp_from":       strings.TrimSpace(s.From),
+		"notify_email_to": strings.TrimSpace(s.To),
+	}
+	for key, value := range values {
+		// Check every value before saving any
+		normalized, err := models.NormalizeSetting(key, value)
+		if err != nil {
+			return &repoError{ErrInvalidInput, err.Error()}
+		}
+		values[key] = normalized
+	}
+	if s.Password != "" {
+		sealed, err := encryptCredential(s.Password)
+		if err != nil {
+			return err
+		}
+		values[smtpPasswordKey] = sealed
+	}
+	for key, value := range values {
+		if _, err := models.SetSetting(key, value, "user_preference"); err != nil {
+			return fmt.Errorf("failed to save email settings")
+		}
+	}
+	return nil
+}
+
+func emailChannelEnabled() bool {
+	s := GetSMTPSettings()
+	return models.GetBoolSetting("notify_email") && s.Host != "" && s.From != "" && s.To != ""
+}
+
+// emailNotification sends n as a plain text email with the configured
+// SMTP server, using STARTTLS when the server offers it.
+func emailNotification(n *Notification) error {
+	s := GetSMTPSettings()
+	password := ""
+	if sealed, _ := models.GetSetting(smtpPasswordKey); sealed != "" {
+		var err error
+		if password, err = decryptCredential(sealed); err != nil {
+			return fmt.Errorf("failed to read the SMTP password: %w", err)
+		}
+	}
+	return sendMail(s, password, notificationEmail(s.From, s.To, n))
+}
+
+// notificationEmail composes the message for n. Header values have line
+// breaks removed so a description can't add headers.
+func notificationEmail(from, to string, n *Notification) []byte {
+	oneLine := strings.NewReplacer("\r", " ", "\n", " ")
+	subject := []rune(fmt.Sprintf("[Workbench] %s: %s", n.Level, oneLine.Replace(n.Description)))
+	if len(subject) > 120 {
+		subject = append(subject[:117], []rune("...")...)
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", oneLine.Replace(from))
+	fmt.Fprintf(&msg, "To: %s\r\n", oneLine.Replace(to))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", string(subject)))
+	fmt.Fprintf(&msg, "Date: %s\r\n", n.Timestamp.Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n")
+	fmt.Fprintf(&msg, "%s\r\n\r\nEvent: %s\r\n", n.Description, n.Type)
+	if n.Repository != "" {
+		fmt.Fprintf(&msg, "Repository: %s\r\n", n.Repository)
+	}
+	fmt.Fprintf(&msg, "Time: %s\r\n", n.Timestamp.UTC().Format(time.RFC3339))
+	return msg.Bytes()
+}
+
+// sendMail delivers msg like smtp.SendMail, but gives up on a server that
+// doesn't answer within notifyClient's timeout.
+var sendMail = func(s SMTPSettings, password string, msg []byte) error {
+	addr := net.JoinHostPort(s.Host, strconv.Itoa(s.Port))
+	conn, err := net.DialTimeout("tcp", addr, notifyClient.Timeout)
+	if err != nil {
+		return fmt.Errorf("failed to reach SMTP server: %w", err)
+	}
+	conn.SetDeadline(time.Now().Add(notifyClient.Timeout))
+	client, err := smtp.NewClient(conn, s.Host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to reach SMTP server: %w", err)
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: s.Host}); err != nil {
+			return fmt.Errorf("SMTP STARTTLS failed: %w", err)
+		}
+	}
+	if s.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", s.Username, password, s.Host)); err != nil {
+			return fmt.Errorf("SMTP server rejected the login: %w", err)
+		}
+	}
+	from, err := mail.ParseAddress(s.From)
+	if err != nil {
+		return fmt.Errorf("invalid sender address: %w", err)
+	}
+	to, err := mail.ParseAddress(s.To)
+	if err != nil {
+		return fmt.Errorf("invalid recipient address: %w", err)
+	}
+	if err := client.Mail(from.Address); err != nil {
+		return err
+	}
+	if err := client.Rcpt(to.Address); err != nil {
+		return err
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
@@ -0,0 +1,97 @@
+package internal
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+	"workbench/models"
+
+	"github.com/The-Skyscape/devtools/pkg/testutils"
+)
+
+// fakeNotifyChannels replaces the channels with ones that report each
+// send on sent, failing while fail returns true, and records undelivered
+// notifications until the test ends.
+func fakeNotifyChannels(t *testing.T, channels ...*NotifyChannel) *[]string {
+	originalChannels, originalBackoff, originalRecord := notifyChannels, notifyBackoff, recordUndelivered
+	t.Cleanup(func() {
+		notifyChannels, notifyBackoff, recordUndelivered = originalChannels, originalBackoff, originalRecord
+	})
+
+	var undelivered []string
+	notifyChannels = channels
+	notifyBackoff = time.Millisecond
+	recordUndelivered = func(channel string, n *Notification, err error) {
+		undelivered = append(undelivered, channel+": "+err.Error())
+	}
+	return &undelivered
+}
+
+func TestDeliverNotificationRetries(t *testing.T) {
+	attempts := 0
+	flaky := &NotifyChannel{Name: "webhook", Send: func(n *Notification) error {
+		attempts++
+		if attempts < notifyAttempts {
+			return errors.New("connection refused")
+		}
+		return nil
+	}}
+	undelivered := fakeNotifyChannels(t, flaky)
+
+	testutils.AssertEqual(t, nil, deliverNotification(flaky, &Notification{Type: "system_alert"}))
+	testutils.AssertEqual(t, notifyAttempts, attempts)
+	testutils.AssertEqual(t, 0, len(*undelivered))
+
+	down := &NotifyChannel{Name: "email", Send: func(n *Notification) error { return errors.New("connection refused") }}
+	testutils.AssertEqual(t, true, deliverNotification(down, &Notification{Type: "system_alert"}) != nil)
+	testutils.AssertEqual(t, 1, len(*undelivered))
+	testutils.AssertEqual(t, "email: connection refused", (*undelivered)[0])
+}
+
+func TestNotifySendsToEnabledChannels(t *testing.T) {
+	sent := make(chan string, 2)
+	channel := func(name string, enabled bool) *NotifyChannel {
+		return &NotifyChannel{
+			Name:    name,
+			Enabled: func() bool { return enabled },
+			Send: func(n *Notification) error {
+				sent <- name + ":" + n.Level
+				return nil
+			},
+		}
+	}
+	fakeNotifyChannels(t, channel("in-app", true), channel("webhook", false), channel("email", true))
+
+	Notify(&models.Activity{Type: "repo_pull_failed", Repository: "api", Description: "Pull failed"})
+	var got []string
+	for range 2 {
+		select {
+		case name := <-sent:
+			got = append(got, name)
+		case <-time.After(2 * time.Second):
+			t.Fatal("notification not delivered")
+		}
+	}
+	testutils.AssertEqual(t, true, strings.Contains(strings.Join(got, ","), "in-app:critical"))
+	testutils.AssertEqual(t, true, strings.Contains(strings.Join(got, ","), "email:critical"))
+	testutils.AssertEqual(t, 0, len(sent))
+}
+
+func TestSignNotification(t *testing.T) {
+	// echo -n '{"type":"x"}' | openssl dgst -sha256 -hmac secret
+	testutils.AssertEqual(t, "sha256=22a8c72ea6fe36a487a09ca44bee1e45cdcccbc3819b29637173955cd33df498", signNotification("secret", []byte(`{"type":"x"}`)))
+}
+
+func TestNotificationEmail(t *testing.T) {
+	n := &Notification{
+		Type: "system_alert", Level: "critical", Description: "Disk at 95%\r\nBcc: someone@example.com",
+		Timestamp: time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC),
+	}
+	msg := string(notificationEmail("Workbench <wb@example.com>", "me@example.com", n))
+	headers, body, _ := strings.Cut(msg, "\r\n\r\n")
+
+	testutils.AssertEqual(t, false, strings.Contains(headers, "\r\nBcc:"))
+	testutils.AssertEqual(t, true, strings.Contains(headers, "Subject: [Workbench] critical: Disk at 95%"))
+	testutils.AssertEqual(t, true, strings.Contains(body, "Event: system_alert"))
+}
@@ -0,0 +1,193 @@
+package internal
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"workbench/models"
+	"workbench/services"
+)
+
+// CoderUID is the user the coder container runs as. Mounted directories
+// must be owned by this UID for SSH and Git to work.
+const CoderUID = 1000
+
+func init() {
+	RegisterActivityAction("coder_permissions_failed", ActivityActionDef{
+		Label:  "Repair permissions",
+		Method: ActionPost,
+		URL: func(a *models.Activity, repo *models.Repository) string {
+			return "/diagnostics/permissions"
+		},
+	})
+}
+
+// permissionRule describes the ownership and mode a path inside the coder
+// container must have.
+type permissionRule struct {
+	Path      string
+	Mode      uint32 // Required mode (exact), or mode applied when repairing
+	Exact     bool   // Mode must match exactly, as ssh requires for keys
+	Optional  bool   // Missing paths are not reported
+	Recursive bool   // Repair also applies below the path, undoing an old chmod -R 777
+}
+
+// permissionRules lists the mounted paths ssh and git depend on.
+var permissionRules = []permissionRule{
+	{Path: "/home/.ssh", Mode: 0700, Exact: true, Optional: true},
+	{Path: "/home/coder/.ssh", Mode: 0700, Exact: true, Optional: true},
+	{Path: "/home/coder/.ssh/id_ed25519", Mode: 0600, Exact: true, Optional: true},
+	{Path: "/home/coder/.ssh/id_rsa", Mode: 0600, Exact: true, Optional: true},
+	{Path: "/home/coder/repos", Mode: 0755, Recursive: true},
+	{Path: "/home/coder/.config", Mode: 0700, Recursive: true},
+}
+
+// PermissionIssue is a path with the wrong owner or mode.
+type PermissionIssue struct {
+	Path    string
+	Problem string
+	UID     int
+	Mode    string // Current mode in octal, e.g. "777"
+	Missing bool
+	rule    permissionRule
+}
+
+// fileStat is a parsed line of stat output.
+type fileStat struct {
+	UID  int
+	Mode uint32
+}
+
+// statCommand prints "path|uid|mode" for each path. Missing paths are
+// reported by stat on stderr and simply absent from the parsed output.
+func statCommand(rules []permissionRule) string {
+	paths := make([]string, len(rules))
+	for i, rule := range rules {
+		paths[i] = shellQuote(rule.Path)
+	}
+	return "stat -c '%n|%u|%a' " + strings.Join(paths, " ") + " 2>/dev/null"
+}
+
+// parseStatOutput parses the output of statCommand.
+func parseStatOutput(output string) map[string]fileStat {
+	stats := map[string]fileStat{}
+	for _, line := range strings.Split(output, "\n") {
+		parts := strings.Split(strings.TrimSpace(line), "|")
+		if len(parts) != 3 {
+			continue
+		}
+		uid, err := strconv.Atoi(parts[1])
+		if err != nil {
+			continue
+		}
+		mode, err := strconv.ParseUint(parts[2], 8, 32)
+		if err != nil {
+			continue
+		}
+		stats[parts[0]] = fileStat{UID: uid, Mode: uint32(mode)}
+	}
+	return stats
+}
+
+// checkPermissions compares stat results against the rules. Non-exact
+// rules require the owner to have full access and forbid world-writable
+// modes, so a blanket 777 is reported.
+func checkPermissions(rules []permissionRule, stats map[string]fileStat) []*PermissionIssue {
+	var issues []*PermissionIssue
+	for _, rule := range rules {
+		stat, ok := stats[rule.Path]
+		if !ok {
+			if !rule.Optional {
+				issues = append(issues, &PermissionIssue{Path: rule.Path, Problem: "missing", Missing: true, rule: rule})
+			}
+			continue
+		}
+
+		issue := &PermissionIssue{Path: rule.Path, UID: stat.UID, Mode: fmt.Sprintf("%o", stat.Mode), rule: rule}
+		switch {
+		case stat.UID != CoderUID:
+			issue.Problem = fmt.Sprintf("owned by UID %d instead of %d", stat.UID, CoderUID)
+		case rule.Exact && stat.Mode != rule.Mode:
+			issue.Problem = fmt.Sprintf("mode %o instead of %o", stat.Mode, rule.Mode)
+		case !rule.Exact && stat.Mode&0700 != 0700:
+			issue.Problem = fmt.Sprintf("mode %o does not give the owner full access", stat.Mode)
+		case !rule.Exact && stat.Mode&0002 != 0:
+			issue.Problem = fmt.Sprintf("mode %o is world-writable", stat.Mode)
+		default:
+			continue
+		}
+		issues = append(issues, issue)
+	}
+	return issues
+}
+
+// repairCommand returns the targeted chown/chmod that fixes an issue. For
+// recursive rules, everything below the path is also chowned and loses
+// group and world write, which older installs granted with chmod -R 777.
+func repairCommand(issue *PermissionIssue) string {
+	path := shellQuote(issue.Path)
+	if issue.Missing {
+		return fmt.Sprintf("mkdir -p %s && chown %d:%d %s && chmod %o %s", path, CoderUID, CoderUID, path, issue.rule.Mode, path)
+	}
+	if issue.rule.Recursive {
+		return fmt.Sprintf("chown -R %d:%d %s && chmod -R go-w %s && chmod %o %s", CoderUID, CoderUID, path, path, issue.rule.Mode, path)
+	}
+	return fmt.Sprintf("chown %d:%d %s && chmod %o %s", CoderUID, CoderUID, path, issue.rule.Mode, path)
+}
+
+// CheckCoderPermissions inspects ownership and modes of the mounted SSH,
+// repository, and config directories from inside the coder container.
+func CheckCoderPermissions() ([]*PermissionIssue, error) {
+	result, err := services.CoderExecWithOptions(statCommand(permissionRules), services.ExecOptions{User: "root"})
+	stats := parseStatOutput(result.Output)
+	if err != nil && len(stats) == 0 {
+		return nil, fmt.Errorf("failed to inspect coder permissions: %w", err)
+	}
+	return checkPermissions(permissionRules, stats), nil
+}
+
+// RepairCoderPermissions fixes ownership and modes with targeted chown and
+// chmod as root, then re-checks. Anything that couldn't be fixed is
+// recorded as a failure activity with the path and its current mode, and
+// returned to the caller.
+func RepairCoderPermissions() ([]*PermissionIssue, error) {
+	issues, err := CheckCoderPermissions()
+	if err != nil || len(issues) == 0 {
+		return issues, err
+	}
+
+	for _, issue := range issues {
+		services.CoderExecWithOptions(repairCommand(issue), services.ExecOptions{User: "root"})
+	}
+
+	remaining, err := CheckCoderPermissions()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, issue := range remaining {
+		description := fmt.Sprintf("Could not fix %s: %s", issue.Path, issue.Problem)
+		if !issue.Missing {
+			description += fmt.Sprintf(" (uid %d, mode %s)", issue.UID, issue.Mode)
+		}
+		go models.Activities.Insert(&models.Activity{
+			Type:        "coder_permissions_failed",
+			Repository:  "",
+			Description: description,
+			Author:      "System",
+			Timestamp:   time.Now(),
+		})
+	}
+
+	if fixed := len(issues) - len(remaining); fixed > 0 {
+		go models.Activities.Insert(&models.Activity{
+			Type:        "coder_permissions_repaired",
+			Repository:  "",
+			Description: fmt.Sprintf("Repaired ownership or permissions on %d path(s) in the coder container", fixed),
+			Author:      "System",
+			Timestamp:   time.Now(),
+		})
+	}
+	return remaining, nil
+}
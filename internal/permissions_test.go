@@ -0,0 +1,88 @@
+package internal
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/The-Skyscape/devtools/pkg/testutils"
+)
+
+func problems(issues []*PermissionIssue) string {
+	var lines []string
+	for _, issue := range issues {
+		lines = append(lines, issue.Path+": "+issue.Problem)
+	}
+	return strings.Join(lines, "; ")
+}
+
+func TestCheckPermissions(t *testing.T) {
+	testCases := []struct {
+		name     string
+		output   string
+		expected string
+	}{
+		{
+			"good",
+			"/home/.ssh|1000|700\n/home/coder/.ssh|1000|700\n/home/coder/.ssh/id_ed25519|1000|600\n" +
+				"/home/coder/repos|1000|755\n/home/coder/.config|1000|700\n",
+			"",
+		},
+		{
+			"root-owned ssh mount",
+			"/home/.ssh|0|700\n/home/coder/repos|1000|755\n/home/coder/.config|1000|755\n",
+			"/home/.ssh: owned by UID 0 instead of 1000",
+		},
+		{
+			"loose private key",
+			"/home/coder/.ssh/id_rsa|1000|644\n/home/coder/repos|1000|755\n/home/coder/.config|1000|755\n",
+			"/home/coder/.ssh/id_rsa: mode 644 instead of 600",
+		},
+		{
+			"blanket 777",
+			"/home/coder/repos|1000|777\n/home/coder/.config|1000|777\n",
+			"/home/coder/repos: mode 777 is world-writable; /home/coder/.config: mode 777 is world-writable",
+		},
+		{
+			"owner without write",
+			"/home/coder/repos|1000|555\n/home/coder/.config|1000|755\n",
+			"/home/coder/repos: mode 555 does not give the owner full access",
+		},
+		{
+			"missing repos directory",
+			"/home/coder/.config|1000|755\n",
+			"/home/coder/repos: missing",
+		},
+		{
+			"garbage output",
+			"stat: cannot stat '/home/.ssh': No such file or directory\n/home/coder/repos|x|755\n",
+			"/home/coder/repos: missing; /home/coder/.config: missing",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			issues := checkPermissions(permissionRules, parseStatOutput(tc.output))
+			testutils.AssertEqual(t, tc.expected, problems(issues))
+		})
+	}
+}
+
+func TestRepairCommand(t *testing.T) {
+	issues := checkPermissions(permissionRules, parseStatOutput("/home/.ssh|0|755\n/home/coder/.config|1000|755\n"))
+	testutils.AssertEqual(t, 2, len(issues))
+	testutils.AssertEqual(t, "chown 1000:1000 '/home/.ssh' && chmod 700 '/home/.ssh'", repairCommand(issues[0]))
+	testutils.AssertEqual(t, "mkdir -p '/home/coder/repos' && chown 1000:1000 '/home/coder/repos' && chmod 755 '/home/coder/repos'",
+		repairCommand(issues[1]))
+}
+
+func TestRepairCommandRecursive(t *testing.T) {
+	issues := checkPermissions(permissionRules, parseStatOutput("/home/coder/repos|1000|755\n/home/coder/.config|1000|777\n"))
+	testutils.AssertEqual(t, 1, len(issues))
+	testutils.AssertEqual(t, "chown -R 1000:1000 '/home/coder/.config' && chmod -R go-w '/home/coder/.config' && chmod 700 '/home/coder/.config'",
+		repairCommand(issues[0]))
+}
+
+func TestStatCommand(t *testing.T) {
+	cmd := statCommand([]permissionRule{{Path: "/home/.ssh"}, {Path: "/home/coder/repos"}})
+	testutils.AssertEqual(t, "stat -c '%n|%u|%a' '/home/.ssh' '/home/coder/repos' 2>/dev/null", cmd)
+}
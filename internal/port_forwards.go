@@ -0,0 +1,115 @@
+package internal
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"workbench/models"
+)
+
+// coderPort is code-server's own port, already reachable at /coder/.
+const coderPort = 8080
+
+// validPathPrefix is a forward's URL segment under /apps/.
+var validPathPrefix = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,39}$`)
+
+// prefixInvalid matches runs of what a path prefix can't contain, when
+// one is made from a forward's name.
+var prefixInvalid = regexp.MustCompile(`[^a-z0-9]+`)
+
+// portForwardByPrefix looks up a forward for a proxied request. Replaced
+// in tests.
+var portForwardByPrefix = func(prefix string) (*models.PortForward, error) {
+	return models.PortForwards.Find("WHERE PathPrefix = ?", prefix)
+}
+
+// GetPortForwards returns the port forwards by path prefix.
+func GetPortForwards() ([]*models.PortForward, error) {
+	return models.PortForwards.Search("ORDER BY PathPrefix ASC")
+}
+
+// AddPortForward saves an enabled forward from /apps/{prefix}/ to a port
+// in the coder container. An empty prefix is taken from the name, or the
+// port when there's no name.
+func AddPortForward(name, port, prefix string) (*models.PortForward, error) {
+	forward, err := newPortForward(name, port, prefix)
+	if err != nil {
+		return nil, err
+	}
+	if models.PortForwards.Count("WHERE PathPrefix = ?", forward.PathPrefix) > 0 {
+		return nil, fmt.Errorf("/apps/%s/ is already forwarded - pick another prefix", forward.PathPrefix)
+	}
+	forward, err = models.PortForwards.Insert(forward)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save port forward")
+	}
+	return forward, nil
+}
+
+// newPortForward validates a forward before it's saved.
+func newPortForward(name, port, prefix string) (*models.PortForward, error) {
+	name = strings.TrimSpace(name)
+	target, err := strconv.Atoi(strings.TrimSpace(port))
+	if err != nil || target < 1 || target > 65535 {
+		return nil, fmt.Errorf("port must be a number from 1 to 65535")
+	}
+	if target == coderPort {
+		return nil, fmt.Errorf("port %d is VS Code itself - open it from the dashboard instead", coderPort)
+	}
+
+	prefix = strings.ToLower(strings.Trim(strings.TrimSpace(prefix), "/"))
+	if prefix == "" {
+		prefix = prefixInvalid.ReplaceAllString(strings.ToLower(name), "-")
+		prefix = strings.Trim(prefix[:min(len(prefix), 40)], "-")
+	}
+	if prefix == "" {
+		prefix = strconv.Itoa(target)
+	}
+	if !validPathPrefix.MatchString(prefix) {
+		return nil, fmt.Errorf("prefix must be lowercase letters, digits, and dashes, e.g. web")
+	}
+	if name == "" {
+		name = prefix
+	}
+	return &models.PortForward{Name: name, TargetPort: target, PathPrefix: prefix, Enabled: true}, nil
+}
+
+// DeletePortForward removes a port forward. Whatever listens on its port
+// keeps running.
+func DeletePortForward(id string) error {
+	forward, err := models.PortForwards.Get(id)
+	if err != nil {
+		return fmt.Errorf("port forward not found")
+	}
+	if err := models.PortForwards.Delete(forward); err != nil {
+		return fmt.Errorf("failed to delete port forward")
+	}
+	return nil
+}
+
+// TogglePortForward pauses or resumes a port forward.
+func TogglePortForward(id string) error {
+	forward, err := models.PortForwards.Get(id)
+	if err != nil {
+		return fmt.Errorf("port forward not found")
+	}
+	forward.Enabled = !forward.Enabled
+	if err := models.PortForwards.Update(forward); err != nil {
+		return fmt.Errorf("failed to save port forward")
+	}
+	return nil
+}
+
+// EnabledPortForward returns the forward for a path prefix, or an error
+// when there is none or it's paused.
+func EnabledPortForward(prefix string) (*models.PortForward, error) {
+	forward, err := portForwardByPrefix(prefix)
+	if err != nil || forward == nil {
+		return nil, fmt.Errorf("nothing is forwarded at /apps/%s/ - add a port forward on the dashboard", prefix)
+	}
+	if !forward.Enabled {
+		return nil, fmt.Errorf("the port forward at /apps/%s/ is paused - resume it on the dashboard", prefix)
+	}
+	return forward, nil
+}
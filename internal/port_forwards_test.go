@@ -0,0 +1,65 @@
+package internal
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"workbench/models"
+
+	"github.com/The-Skyscape/devtools/pkg/testutils"
+)
+
+func TestNewPortForward(t *testing.T) {
+	testCases := []struct {
+		name, port, prefix string
+		want               string // "name port prefix", or the start of the error
+	}{
+		{"Web UI", "5173", "", "Web UI 5173 web-ui"},
+		{"", " 3000 ", "", "3000 3000 3000"},
+		{"API", "8000", "/Backend/", "API 8000 backend"},
+		{"", "5173", "has space", "prefix must be"},
+		{"", "0", "", "port must be"},
+		{"", "70000", "", "port must be"},
+		{"", "http", "", "port must be"},
+		{"", "8080", "", "port 8080 is VS Code"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name+tc.port, func(t *testing.T) {
+			forward, err := newPortForward(tc.name, tc.port, tc.prefix)
+			got := ""
+			if err != nil {
+				got = err.Error()
+			} else {
+				got = fmt.Sprintf("%s %d %s", forward.Name, forward.TargetPort, forward.PathPrefix)
+				testutils.AssertEqual(t, true, forward.Enabled)
+			}
+			testutils.AssertEqual(t, true, strings.HasPrefix(got, tc.want))
+		})
+	}
+}
+
+func TestEnabledPortForward(t *testing.T) {
+	original := portForwardByPrefix
+	t.Cleanup(func() { portForwardByPrefix = original })
+	forwards := map[string]*models.PortForward{
+		"web": {PathPrefix: "web", TargetPort: 5173, Enabled: true},
+		"api": {PathPrefix: "api", TargetPort: 8000},
+	}
+	portForwardByPrefix = func(prefix string) (*models.PortForward, error) {
+		if forward, ok := forwards[prefix]; ok {
+			return forward, nil
+		}
+		return nil, fmt.Errorf("not found")
+	}
+
+	forward, err := EnabledPortForward("web")
+	testutils.AssertEqual(t, nil, err)
+	testutils.AssertEqual(t, 5173, forward.TargetPort)
+
+	_, err = EnabledPortForward("api")
+	testutils.AssertEqual(t, "the port forward at /apps/api/ is paused - resume it on the dashboard", err.Error())
+
+	_, err = EnabledPortForward("docs")
+	testutils.AssertEqual(t, "nothing is forwarded at /apps/docs/ - add a port forward on the dashboard", err.Error())
+}
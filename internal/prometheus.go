@@ -0,0 +1,249 @@
+package internal
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"workbench/models"
+	"workbench/services"
+)
+
+// MetricsTokenKey is the setting holding the bearer token /metrics
+// requires; empty leaves /metrics open, like /health. It's a secret, so
+// it's only set by GenerateMetricsToken and only shown then.
+const MetricsTokenKey = "metrics_token"
+
+var (
+	// storedMetricsToken and saveMetricsToken read and write the metrics
+	// token setting. Replaced in tests.
+	storedMetricsToken = func() string { return models.SettingOrDefault(MetricsTokenKey) }
+	saveMetricsToken   = func(token string) error {
+		_, err := models.SetSetting(MetricsTokenKey, token, "api")
+		return err
+	}
+	metricsTokenActivity = func(activityType, description string) {
+		go models.Activities.Insert(&models.Activity{
+			Type:        activityType,
+			Repository:  "",
+			Description: description,
+			Author:      "System",
+			Timestamp:   time.Now(),
+		})
+	}
+)
+
+// HasMetricsToken reports whether /metrics requires a bearer token.
+func HasMetricsToken() bool {
+	return storedMetricsToken() != ""
+}
+
+// GenerateMetricsToken creates a new metrics token, replacing any
+// previous one, and returns it for the Prometheus scrape config.
+func GenerateMetricsToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate metrics token: %w", err)
+	}
+	token := hex.EncodeToString(b)
+	if err := saveMetricsToken(token); err != nil {
+		return "", fmt.Errorf("failed to save metrics token: %w", err)
+	}
+	metricsTokenActivity("metrics_token_create", "Generated a new metrics token")
+	return token, nil
+}
+
+// RevokeMetricsToken removes the metrics token, leaving /metrics open.
+func RevokeMetricsToken() error {
+	if err := saveMetricsToken(""); err != nil {
+		return fmt.Errorf("failed to revoke metrics token: %w", err)
+	}
+	metricsTokenActivity("metrics_token_revoke", "Revoked the metrics token; /metrics is open")
+	return nil
+}
+
+// Metric types in the Prometheus text format.
+const (
+	PromGauge   = "gauge"
+	PromCounter = "counter"
+)
+
+// PromMetric is a metric family in the Prometheus text format: one HELP
+// and TYPE line, then a sample per label set.
+type PromMetric struct {
+	Name    string
+	Help    string
+	Type    string
+	Samples []PromSample
+}
+
+// PromSample is one value of a metric and its labels.
+type PromSample struct {
+	Labels map[string]string
+	Value  float64
+}
+
+// WritePrometheus writes metrics in the Prometheus text exposition format,
+// version 0.0.4. Labels are written sorted by name. Metrics without samples
+// are left out.
+func WritePrometheus(w io.Writer, metrics []PromMetric) error {
+	out := bufio.NewWriter(w)
+	for _, m := range metrics {
+		if len(m.Samples) == 0 {
+			continue
+		}
+		fmt.Fprintf(out, "# HELP %s %s\n", m.Name, promHelpEscaper.Replace(m.Help))
+		fmt.Fprintf(out, "# TYPE %s %s\n", m.Name, m.Type)
+		for _, s := range m.Samples {
+			out.WriteString(m.Name)
+			writePromLabels(out, s.Labels)
+			out.WriteString(" " + formatPromValue(s.Value) + "\n")
+		}
+	}
+	return out.Flush()
+}
+
+var (
+	promHelpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	promLabelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func writePromLabels(out *bufio.Writer, labels map[string]string) {
+	if len(labels) == 0 {
+		return
+	}
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	out.WriteString("{")
+	for i, name := range names {
+		if i > 0 {
+			out.WriteString(",")
+		}
+		out.WriteString(name + `="` + promLabelEscaper.Replace(labels[name]) + `"`)
+	}
+	out.WriteString("}")
+}
+
+func formatPromValue(v float64) string {
+	switch {
+	case math.IsNaN(v):
+		return "NaN"
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	if v == math.Trunc(v) && math.Abs(v) < 1e15 {
+		// Byte counts read better without an exponent
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// CheckMetricsToken reports whether a scrape may read /metrics: always
+// when no metrics_token is set, otherwise only with that bearer token.
+func CheckMetricsToken(token string) bool {
+	want := storedMetricsToken()
+	if want == "" {
+		return true
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(want)) == 1
+}
+
+// Sources for PrometheusMetrics. Replaced in tests.
+var (
+	promRepositoryCount = func() int { return models.Repositories.Count("") }
+	promCoderRunning    = func() bool { return services.Coder != nil && services.Coder.IsRunning() }
+	promStorageUsage    = GetStorageUsage
+	promActivities      = func(since time.Time) ([]*models.Activity, error) {
+		return models.Activities.Search("WHERE CreatedAt >= ?", since)
+	}
+)
+
+// PrometheusMetrics gathers the current system sample from source, which
+// may have none yet, with repository, VS Code, activity, and request
+// metrics.
+func PrometheusMetrics(source StatsSource, now time.Time) []PromMetric {
+	gauge := func(name, help string, value float64) PromMetric {
+		return PromMetric{Name: name, Help: help, Type: PromGauge, Samples: []PromSample{{Value: value}}}
+	}
+
+	var metrics []PromMetric
+	if stats, err := source.GetCurrent(); err == nil && stats != nil {
+		metrics = append(metrics,
+			gauge("workbench_cpu_usage_percent", "CPU usage of the host, 0-100.", stats.CPU.UsagePercent),
+			gauge("workbench_memory_used_bytes", "Memory in use on the host.", float64(stats.Memory.Used)),
+			gauge("workbench_memory_total_bytes", "Total memory of the host.", float64(stats.Memory.Total)),
+			gauge("workbench_load1", "One-minute load average of the host.", stats.LoadAverage.Load1),
+		)
+	}
+	if usage, err := promStorageUsage(); err == nil {
+		metrics = append(metrics,
+			gauge("workbench_datadir_used_bytes", "Bytes used on the disks holding the data directory and storage locations.", float64(usage.Used)),
+			gauge("workbench_datadir_total_bytes", "Size of the disks holding the data directory and storage locations.", float64(usage.Total)),
+		)
+	}
+
+	coderRunning := 0.0
+	if promCoderRunning() {
+		coderRunning = 1
+	}
+	metrics = append(metrics,
+		gauge("workbench_repositories_total", "Repositories managed by the workbench.", float64(promRepositoryCount())),
+		gauge("workbench_coder_running", "Whether the VS Code container is running (1) or not (0).", coderRunning),
+		recentActivityMetric(now),
+		httpRequestMetric(),
+	)
+	return metrics
+}
+
+// recentActivityMetric counts the last day's activities by type. The log
+// is pruned, so these are gauges over a sliding window, not counters.
+func recentActivityMetric(now time.Time) PromMetric {
+	metric := PromMetric{
+		Name: "workbench_activities_recent",
+		Help: "Activities recorded in the last 24 hours, by type.",
+		Type: PromGauge,
+	}
+	activities, err := promActivities(now.Add(-24 * time.Hour))
+	if err != nil {
+		return metric
+	}
+	counts := map[string]int{}
+	for _, a := range activities {
+		counts[a.Type]++
+	}
+	types := make([]string, 0, len(counts))
+	for t := range counts {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	for _, t := range types {
+		metric.Samples = append(metric.Samples, PromSample{Labels: map[string]string{"type": t}, Value: float64(counts[t])})
+	}
+	return metric
+}
+
+// httpRequestMetric counts requests since startup in each latency bucket.
+func httpRequestMetric() PromMetric {
+	metric := PromMetric{
+		Name: "workbench_http_requests_total",
+		Help: "Requests served since startup, by area: the dashboard or the VS Code proxy.",
+		Type: PromCounter,
+	}
+	for _, stats := range GetLatencyStats() {
+		metric.Samples = append(metric.Samples, PromSample{Labels: map[string]string{"area": stats.Bucket}, Value: float64(stats.Requests)})
+	}
+	return metric
+}
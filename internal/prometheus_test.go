@@ -0,0 +1,125 @@
+package internal
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"testing"
+	"time"
+	"workbench/models"
+
+	"github.com/The-Skyscape/devtools/pkg/containers"
+	"github.com/The-Skyscape/devtools/pkg/testutils"
+)
+
+func TestWritePrometheus(t *testing.T) {
+	var out strings.Builder
+	err := WritePrometheus(&out, []PromMetric{
+		{Name: "workbench_load1", Help: "Load with a \\ and\na newline.", Type: PromGauge, Samples: []PromSample{{Value: 0.5}}},
+		{Name: "workbench_empty", Help: "Left out.", Type: PromGauge},
+		{Name: "workbench_things_total", Help: "Things.", Type: PromCounter, Samples: []PromSample{
+			{Labels: map[string]string{"type": `say "hi"`, "area": "a\\b\nc"}, Value: 3},
+			{Labels: map[string]string{"type": "big"}, Value: 12345678901},
+			{Labels: map[string]string{"type": "small"}, Value: 0.000012},
+			{Labels: map[string]string{"type": "nan"}, Value: math.NaN()},
+		}},
+	})
+
+	testutils.AssertEqual(t, nil, err)
+	testutils.AssertEqual(t, `# HELP workbench_load1 Load with a \\ and\na newline.
+# TYPE workbench_load1 gauge
+workbench_load1 0.5
+# HELP workbench_things_total Things.
+# TYPE workbench_things_total counter
+workbench_things_total{area="a\\b\nc",type="say \"hi\""} 3
+workbench_things_total{type="big"} 12345678901
+workbench_things_total{type="small"} 1.2e-05
+workbench_things_total{type="nan"} NaN
+`, out.String())
+}
+
+// fakePrometheusSources serves PrometheusMetrics' sources for a test.
+func fakePrometheusSources(t *testing.T, activities []*models.Activity) {
+	repos, coder, storage, recent := promRepositoryCount, promCoderRunning, promStorageUsage, promActivities
+	t.Cleanup(func() {
+		promRepositoryCount, promCoderRunning, promStorageUsage, promActivities = repos, coder, storage, recent
+	})
+
+	promRepositoryCount = func() int { return 4 }
+	promCoderRunning = func() bool { return true }
+	promStorageUsage = func() (*DataDirUsage, error) { return &DataDirUsage{Used: 300, Total: 1000}, nil }
+	promActivities = func(since time.Time) ([]*models.Activity, error) { return activities, nil }
+}
+
+type stubStats struct{ stats *containers.SystemStats }
+
+func (s stubStats) GetCurrent() (*containers.SystemStats, error) { return s.stats, nil }
+
+func TestPrometheusMetrics(t *testing.T) {
+	fakePrometheusSources(t, []*models.Activity{{Type: "repo_pull"}, {Type: "repo_clone"}, {Type: "repo_pull"}})
+	stats := &containers.SystemStats{}
+	stats.CPU.UsagePercent = 12.5
+	stats.Memory.Used, stats.Memory.Total = 2<<30, 8<<30
+	stats.LoadAverage.Load1 = 0.75
+
+	var out strings.Builder
+	WritePrometheus(&out, PrometheusMetrics(stubStats{stats}, time.Now()))
+	for _, line := range []string{
+		"workbench_cpu_usage_percent 12.5",
+		"workbench_memory_used_bytes 2147483648",
+		"workbench_memory_total_bytes 8589934592",
+		"workbench_load1 0.75",
+		"workbench_datadir_used_bytes 300",
+		"workbench_datadir_total_bytes 1000",
+		"workbench_repositories_total 4",
+		"workbench_coder_running 1",
+		`workbench_activities_recent{type="repo_clone"} 1`,
+		`workbench_activities_recent{type="repo_pull"} 2`,
+		"# TYPE workbench_activities_recent gauge",
+	} {
+		testutils.AssertEqual(t, true, strings.Contains(out.String(), line+"\n"))
+	}
+}
+
+func TestPrometheusMetricsWithoutSample(t *testing.T) {
+	fakePrometheusSources(t, nil)
+	promStorageUsage = func() (*DataDirUsage, error) { return nil, errors.New("statfs failed") }
+
+	var out strings.Builder
+	WritePrometheus(&out, PrometheusMetrics(stubStats{}, time.Now()))
+	testutils.AssertEqual(t, false, strings.Contains(out.String(), "workbench_cpu_usage_percent"))
+	testutils.AssertEqual(t, false, strings.Contains(out.String(), "workbench_datadir_used_bytes"))
+	testutils.AssertEqual(t, false, strings.Contains(out.String(), "workbench_activities_recent"))
+	testutils.AssertEqual(t, true, strings.Contains(out.String(), "workbench_repositories_total 4\n"))
+}
+
+func TestMetricsToken(t *testing.T) {
+	stored, save, activity := storedMetricsToken, saveMetricsToken, metricsTokenActivity
+	t.Cleanup(func() { storedMetricsToken, saveMetricsToken, metricsTokenActivity = stored, save, activity })
+	token := ""
+	var activities []string
+	storedMetricsToken = func() string { return token }
+	saveMetricsToken = func(value string) error {
+		token = value
+		return nil
+	}
+	metricsTokenActivity = func(activityType, description string) { activities = append(activities, activityType) }
+
+	testutils.AssertEqual(t, false, HasMetricsToken())
+	testutils.AssertEqual(t, true, CheckMetricsToken(""))
+
+	generated, err := GenerateMetricsToken()
+	testutils.AssertEqual(t, nil, err)
+	testutils.AssertEqual(t, 64, len(generated))
+	testutils.AssertEqual(t, true, HasMetricsToken())
+	testutils.AssertEqual(t, true, CheckMetricsToken(generated))
+	testutils.AssertEqual(t, false, CheckMetricsToken(""))
+	testutils.AssertEqual(t, false, CheckMetricsToken(generated[1:]))
+
+	testutils.AssertEqual(t, nil, RevokeMetricsToken())
+	testutils.AssertEqual(t, true, CheckMetricsToken(""))
+	testutils.AssertEqual(t, "metrics_token_create,metrics_token_revoke", strings.Join(activities, ","))
+
+	// Kept out of a backup's plain part
+	testutils.AssertEqual(t, true, isSecretSetting(MetricsTokenKey))
+}
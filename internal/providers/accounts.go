@@ -0,0 +1,240 @@
+package providers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// maxAccountPages caps how many pages of an account's repositories are
+// read, at 100 a page.
+const maxAccountPages = 20
+
+// gitlabAPI is the GitLab API base URL. Replaced in tests.
+var gitlabAPI = "https://gitlab.com/api/v4"
+
+// AccountRepo is a repository listed from a provider account.
+type AccountRepo struct {
+	Name        string
+	Path        string // e.g. "user/repo"
+	Description string
+	HTTPSURL    string
+	SSHURL      string
+	Private     bool
+	Size        int64 // Estimated size in bytes; 0 when the provider doesn't say
+}
+
+// Host returns the provider host of a kind, e.g. "github.com".
+func (k Kind) Host() string {
+	if k == GitLab {
+		return "gitlab.com"
+	}
+	return "github.com"
+}
+
+// ParseKind reads a provider name as sent by a form.
+func ParseKind(name string) (Kind, error) {
+	switch Kind(strings.ToLower(strings.TrimSpace(name))) {
+	case GitHub:
+		return GitHub, nil
+	case GitLab:
+		return GitLab, nil
+	}
+	return "", fmt.Errorf("unsupported provider: %s", name)
+}
+
+// validAccount matches GitHub and GitLab user, organization, and group
+// names.
+var validAccount = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,99}$`)
+
+// ListAccountRepositories lists the repositories of a user or
+// organization, following pagination. With a token, private repositories
+// the token can see are included and GitLab reports sizes. Returns
+// *RateLimitError if the API limit is hit.
+func ListAccountRepositories(kind Kind, account, token string) ([]*AccountRepo, error) {
+	account = strings.TrimSpace(account)
+	if !validAccount.MatchString(account) {
+		return nil, fmt.Errorf("invalid account name: %q", account)
+	}
+
+	var repos []*AccountRepo
+	var err error
+	if kind == GitLab {
+		repos, err = listGitLabProjects(account, token)
+	} else {
+		repos, err = listGitHubRepos(account, token)
+	}
+	var status *statusError
+	if errors.As(err, &status) && status.Code == http.StatusNotFound {
+		return nil, fmt.Errorf("no account named %s on %s", account, kind.Host())
+	}
+	return repos, err
+}
+
+// githubRepo is a repository in a GitHub repository listing.
+type githubRepo struct {
+	Name        string `json:"name"`
+	FullName    string `json:"full_name"`
+	Description string `json:"description"`
+	CloneURL    string `json:"clone_url"`
+	SSHURL      string `json:"ssh_url"`
+	Private     bool   `json:"private"`
+	Size        int64  `json:"size"` // KB
+	Owner       struct {
+		Login string `json:"login"`
+	} `json:"owner"`
+}
+
+func listGitHubRepos(account, token string) ([]*AccountRepo, error) {
+	headers := map[string]string{"Accept": "application/vnd.github+json"}
+	endpoint := fmt.Sprintf("%s/users/%s/repos?per_page=100&type=owner", githubAPI, url.PathEscape(account))
+	if token != "" {
+		// The token's own listing includes private repositories, in its
+		// organizations too; it's filtered to the account below
+		headers["Authorization"] = "Bearer " + token
+		endpoint = githubAPI + "/user/repos?per_page=100&affiliation=owner,organization_member"
+	}
+
+	listed, err := getPages[githubRepo](endpoint, headers)
+	if err != nil {
+		return nil, err
+	}
+	var repos []*AccountRepo
+	for _, r := range listed {
+		if token != "" && !strings.EqualFold(r.Owner.Login, account) {
+			continue
+		}
+		repos = append(repos, &AccountRepo{
+			Name: r.Name, Path: r.FullName, Description: r.Description,
+			HTTPSURL: r.CloneURL, SSHURL: r.SSHURL, Private: r.Private, Size: r.Size * 1024,
+		})
+	}
+	return repos, nil
+}
+
+// gitlabProject is a project in a GitLab project listing.
+type gitlabProject struct {
+	Path              string `json:"path"`
+	PathWithNamespace string `json:"path_with_namespace"`
+	Description       string `json:"description"`
+	HTTPURL           string `json:"http_url_to_repo"`
+	SSHURL            string `json:"ssh_url_to_repo"`
+	Visibility        string `json:"visibility"`
+	Statistics        *struct {
+		RepositorySize int64 `json:"repository_size"`
+	} `json:"statistics"` // Only with a token that can see them
+}
+
+func listGitLabProjects(account, token string) ([]*AccountRepo, error) {
+	headers := map[string]string{}
+	endpoint := fmt.Sprintf("%s/users/%s/projects?per_page=100", gitlabAPI, url.PathEscape(account))
+	if token != "" {
+		headers["PRIVATE-TOKEN"] = token
+		endpoint += "&statistics=true"
+	}
+
+	listed, err := getPages[gitlabProject](endpoint, headers)
+	var status *statusError
+	if errors.As(err, &status) && status.Code == http.StatusNotFound {
+		// Not a user; try it as a group, with its subgroups' projects
+		groupEndpoint := fmt.Sprintf("%s/groups/%s/projects?per_page=100&include_subgroups=true", gitlabAPI, url.PathEscape(account))
+		if token != "" {
+			groupEndpoint += "&statistics=true"
+		}
+		listed, err = getPages[gitlabProject](groupEndpoint, headers)
+	}
+	if err != nil {
+		return nil, err
+	}
+	repos := make([]*AccountRepo, 0, len(listed))
+	for _, p := range listed {
+		repo := &AccountRepo{
+			Name: p.Path, Path: p.PathWithNamespace, Description: p.Description,
+			HTTPSURL: p.HTTPURL, SSHURL: p.SSHURL, Private: p.Visibility != "public",
+		}
+		if p.Statistics != nil {
+			repo.Size = p.Statistics.RepositorySize
+		}
+		repos = append(repos, repo)
+	}
+	return repos, nil
+}
+
+// RepositorySize returns a provider's estimate of a repository's size in
+// bytes, from its clone URL, using the host's token when one is set.
+// Returns 0 without an error for hosts that aren't providers and when
+// the provider doesn't say, as GitLab doesn't without a token.
+func RepositorySize(rawURL string) (int64, error) {
+	remote := ParseRemote(rawURL)
+	if remote == nil {
+		return 0, nil
+	}
+	token := Token(remote.Host)
+
+	if remote.Kind == GitLab {
+		headers := map[string]string{}
+		if token != "" {
+			headers["PRIVATE-TOKEN"] = token
+		}
+		var project gitlabProject
+		endpoint := fmt.Sprintf("https://%s/api/v4/projects/%s?statistics=true", remote.Host, url.PathEscape(remote.Path()))
+		if err := getJSON(endpoint, headers, &project); err != nil {
+			return 0, err
+		}
+		if project.Statistics == nil {
+			return 0, nil
+		}
+		return project.Statistics.RepositorySize, nil
+	}
+
+	headers := map[string]string{"Accept": "application/vnd.github+json"}
+	if token != "" {
+		headers["Authorization"] = "Bearer " + token
+	}
+	var repo githubRepo
+	if err := getJSON(fmt.Sprintf("%s/repos/%s/%s", githubAPI, url.PathEscape(remote.Owner), url.PathEscape(remote.Name)), headers, &repo); err != nil {
+		return 0, err
+	}
+	return repo.Size * 1024, nil
+}
+
+// getPages requests endpoint and then each rel="next" page, up to
+// maxAccountPages, and returns the items of every page. Next links to
+// another host aren't followed, so the token isn't sent there.
+func getPages[T any](endpoint string, headers map[string]string) ([]T, error) {
+	start, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	var all []T
+	for page := 0; endpoint != "" && page < maxAccountPages; page++ {
+		var items []T
+		h, err := sendJSON(http.MethodGet, endpoint, headers, nil, &items)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, items...)
+
+		endpoint = nextPage(h.Get("Link"))
+		if next, err := url.Parse(endpoint); err != nil || next.Host != start.Host {
+			endpoint = ""
+		}
+	}
+	return all, nil
+}
+
+// linkNext matches the next page in a Link header, e.g.
+// <https://api.github.com/user/repos?page=2>; rel="next".
+var linkNext = regexp.MustCompile(`<([^>]+)>;\s*rel="next"`)
+
+// nextPage returns the next page URL from a Link header, or empty string
+// on the last page.
+func nextPage(link string) string {
+	if m := linkNext.FindStringSubmatch(link); m != nil {
+		return m[1]
+	}
+	return ""
+}
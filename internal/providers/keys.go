@@ -0,0 +1,37 @@
+package providers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// UploadSSHKey adds a public key to the account behind token, titled
+// title, through the provider's user keys API. The token needs the
+// write:public_key scope on GitHub or api on GitLab; it isn't stored.
+func UploadSSHKey(kind Kind, token, title, publicKey string) error {
+	token = strings.TrimSpace(token)
+	if token == "" {
+		return ErrNotConfigured
+	}
+	body := map[string]string{"title": title, "key": strings.TrimSpace(publicKey)}
+
+	endpoint, headers := githubAPI+"/user/keys", map[string]string{"Authorization": "Bearer " + token}
+	if kind == GitLab {
+		endpoint, headers = gitlabAPI+"/user/keys", map[string]string{"PRIVATE-TOKEN": token}
+	}
+	var key struct {
+		ID int64 `json:"id"`
+	}
+	err := doJSON(http.MethodPost, endpoint, headers, body, &key)
+	var status *statusError
+	if errors.As(err, &status) {
+		switch status.Code {
+		case http.StatusBadRequest, http.StatusUnprocessableEntity:
+			return errors.New("the provider refused the key - it may already be on an account")
+		case http.StatusForbidden, http.StatusNotFound:
+			return errors.New("the access token can't add SSH keys - it needs the write:public_key (GitHub) or api (GitLab) scope")
+		}
+	}
+	return err
+}
@@ -0,0 +1,88 @@
+package providers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// Metadata describes a repository as its provider lists it.
+type Metadata struct {
+	Description   string
+	DefaultBranch string
+	Language      string // Primary language; empty when the provider doesn't detect one
+	Stars         int
+}
+
+// RepositoryMetadata reads a repository's description, default branch,
+// primary language, and star count from its provider. Public repositories
+// are read without a token; if that finds nothing, the request is retried
+// with the host's stored token so private ones can be read too. Returns
+// nil for origins that aren't a recognized provider.
+func RepositoryMetadata(rawURL string) (*Metadata, error) {
+	remote := ParseRemote(rawURL)
+	if remote == nil {
+		return nil, nil
+	}
+	meta, err := repositoryMetadata(remote, "")
+	var status *statusError
+	if errors.As(err, &status) && status.Code == http.StatusNotFound {
+		if token := Token(remote.Host); token != "" {
+			return repositoryMetadata(remote, token)
+		}
+	}
+	return meta, err
+}
+
+// githubRepoMeta is the part of a GitHub repository read for metadata.
+type githubRepoMeta struct {
+	Description   string `json:"description"`
+	DefaultBranch string `json:"default_branch"`
+	Language      string `json:"language"`
+	Stars         int    `json:"stargazers_count"`
+}
+
+// gitlabProjectMeta is the part of a GitLab project read for metadata.
+type gitlabProjectMeta struct {
+	Description   string `json:"description"`
+	DefaultBranch string `json:"default_branch"`
+	Stars         int    `json:"star_count"`
+}
+
+func repositoryMetadata(remote *Remote, token string) (*Metadata, error) {
+	if remote.Kind == GitLab {
+		headers := map[string]string{}
+		if token != "" {
+			headers["PRIVATE-TOKEN"] = token
+		}
+		endpoint := fmt.Sprintf("%s/projects/%s", gitlabAPI, url.PathEscape(remote.Path()))
+		var project gitlabProjectMeta
+		if err := getJSON(endpoint, headers, &project); err != nil {
+			return nil, err
+		}
+		meta := &Metadata{Description: project.Description, DefaultBranch: project.DefaultBranch, Stars: project.Stars}
+
+		// GitLab lists languages by share; a failure only loses the badge
+		var languages map[string]float64
+		if err := getJSON(endpoint+"/languages", headers, &languages); err == nil {
+			share := 0.0
+			for language, percent := range languages {
+				if percent > share || (percent == share && language < meta.Language) {
+					meta.Language, share = language, percent
+				}
+			}
+		}
+		return meta, nil
+	}
+
+	headers := map[string]string{"Accept": "application/vnd.github+json"}
+	if token != "" {
+		headers["Authorization"] = "Bearer " + token
+	}
+	var repo githubRepoMeta
+	if err := getJSON(fmt.Sprintf("%s/repos/%s/%s", githubAPI, url.PathEscape(remote.Owner), url.PathEscape(remote.Name)), headers, &repo); err != nil {
+		return nil, err
+	}
+	return &Metadata{Description: repo.Description, DefaultBranch: repo.DefaultBranch, Language: repo.Language, Stars: repo.Stars}, nil
+}
@@ -0,0 +1,564 @@
+// Package providers integrates with Git hosting provider APIs (GitHub, GitLab)
+// for the repositories cloned into the workbench. Provider access uses
+// personal access tokens stored in settings, one per host.
+package providers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"workbench/models"
+	"workbench/services"
+)
+
+// Kind identifies a supported provider API.
+type Kind string
+
+const (
+	GitHub Kind = "github"
+	GitLab Kind = "gitlab"
+)
+
+// Remote is a repository origin resolved to a provider.
+type Remote struct {
+	Kind  Kind
+	Host  string // e.g. "github.com"
+	Owner string // Owner or group path, e.g. "user" or "group/subgroup"
+	Name  string // Repository name without .git
+}
+
+// Path returns the full repository path, e.g. "user/repo".
+func (r *Remote) Path() string {
+	return r.Owner + "/" + r.Name
+}
+
+// DisplayName returns the provider's display name.
+func (r *Remote) DisplayName() string {
+	if r.Kind == GitLab {
+		return "GitLab"
+	}
+	return "GitHub"
+}
+
+// knownHosts maps recognized hosts to their provider API.
+var knownHosts = map[string]Kind{
+	"github.com": GitHub,
+	"gitlab.com": GitLab,
+}
+
+// ParseRemote resolves an origin URL (HTTPS or SSH) to a provider remote.
+// Returns nil if the host isn't a recognized provider.
+func ParseRemote(rawURL string) *Remote {
+	rawURL = strings.TrimSpace(rawURL)
+	rawURL = strings.TrimSuffix(strings.TrimSuffix(rawURL, "/"), ".git")
+
+	var host, path string
+	if u, err := url.Parse(rawURL); err == nil && u.Host != "" {
+		host, path = u.Hostname(), strings.TrimPrefix(u.Path, "/")
+	} else if at := strings.Index(rawURL, "@"); at >= 0 {
+		host, path, _ = strings.Cut(rawURL[at+1:], ":")
+	}
+
+	kind, ok := knownHosts[strings.ToLower(host)]
+	if !ok {
+		return nil
+	}
+
+	i := strings.LastIndex(path, "/")
+	if i <= 0 || i == len(path)-1 {
+		return nil
+	}
+	return &Remote{Kind: kind, Host: strings.ToLower(host), Owner: path[:i], Name: path[i+1:]}
+}
+
+// Token returns the personal access token configured for a host,
+// or empty string if the provider is not configured.
+func Token(host string) string {
+	token, _ := models.GetSetting(TokenKey(host))
+	return token
+}
+
+// SetToken stores the personal access token for a recognized provider
+// host; an empty token removes access. Cached listings for the host are
+// dropped so the next render uses the new token.
+func SetToken(host, token string) error {
+	host = strings.ToLower(strings.TrimSpace(host))
+	if _, ok := knownHosts[host]; !ok {
+		return fmt.Errorf("unsupported provider host: %s", host)
+	}
+
+	if _, err := models.SetSetting(TokenKey(host), strings.TrimSpace(token), "provider_token"); err != nil {
+		return fmt.Errorf("failed to save access token")
+	}
+
+	cacheMu.Lock()
+	clear(cache)
+	cacheMu.Unlock()
+	return nil
+}
+
+// TokenKey returns the settings key holding the token for a host.
+func TokenKey(host string) string {
+	return "provider_token:" + host
+}
+
+// PullRequest is an open pull request (GitHub) or merge request (GitLab).
+type PullRequest struct {
+	Number    int
+	Title     string
+	Author    string
+	URL       string
+	CIStatus  string // success, failure, pending, or empty if unknown
+	CreatedAt time.Time
+}
+
+// Age returns how long the pull request has been open, e.g. "3d" or "5h".
+func (pr *PullRequest) Age() string {
+	d := time.Since(pr.CreatedAt)
+	if d >= 24*time.Hour {
+		return fmt.Sprintf("%dd", int(d.Hours()/24))
+	}
+	if d >= time.Hour {
+		return fmt.Sprintf("%dh", int(d.Hours()))
+	}
+	return fmt.Sprintf("%dm", int(d.Minutes()))
+}
+
+// ErrNotConfigured is returned when no token is configured for the provider.
+var ErrNotConfigured = fmt.Errorf("provider not configured")
+
+// RateLimitError is returned when the provider API rate limit is exhausted.
+type RateLimitError struct {
+	Reset time.Time
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("API rate limit exceeded, resets at %s", e.Reset.Format("15:04"))
+}
+
+// MinutesLeft returns the whole minutes until the limit resets, at
+// least 1.
+func (e *RateLimitError) MinutesLeft(now time.Time) int {
+	return max(1, int(math.Ceil(e.Reset.Sub(now).Minutes())))
+}
+
+// cacheTTL is how long pull request listings are cached per repository.
+const cacheTTL = 5 * time.Minute
+
+type cacheEntry struct {
+	prs     []*PullRequest
+	fetched time.Time
+}
+
+var (
+	cacheMu sync.Mutex
+	cache   = map[string]cacheEntry{}
+	client  = &http.Client{Timeout: 15 * time.Second}
+
+	// githubAPI is the GitHub API base URL. Replaced in tests.
+	githubAPI = "https://api.github.com"
+)
+
+// RemoteFor resolves a cloned repository's origin to a provider remote.
+// Returns nil if the repository is unknown or its host isn't recognized.
+func RemoteFor(repoName string) *Remote {
+	repo, err := models.Repositories.Find("WHERE Name = ?", repoName)
+	if err != nil {
+		return nil
+	}
+	return ParseRemote(repo.URL)
+}
+
+// ListPullRequests returns open pull requests for a repository's origin.
+// Results are cached for five minutes. Returns ErrNotConfigured if no
+// token is set for the host and *RateLimitError if the API limit is hit.
+func ListPullRequests(repoName string) ([]*PullRequest, error) {
+	remote := RemoteFor(repoName)
+	if remote == nil {
+		return nil, fmt.Errorf("repository '%s' is not hosted on a recognized provider", repoName)
+	}
+
+	token := Token(remote.Host)
+	if token == "" {
+		return nil, ErrNotConfigured
+	}
+
+	cacheMu.Lock()
+	entry, ok := cache[repoName]
+	cacheMu.Unlock()
+	if ok && time.Since(entry.fetched) < cacheTTL {
+		return clonePullRequests(entry.prs), nil
+	}
+
+	var prs []*PullRequest
+	var err error
+	switch remote.Kind {
+	case GitHub:
+		prs, err = listGitHubPulls(remote, token)
+	case GitLab:
+		prs, err = listGitLabMergeRequests(remote, token)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	cacheMu.Lock()
+	cache[repoName] = cacheEntry{prs: clonePullRequests(prs), fetched: time.Now()}
+	cacheMu.Unlock()
+	return prs, nil
+}
+
+// clonePullRequests copies the slice and each pull request, so callers
+// can't modify cached entries through the pointers.
+func clonePullRequests(prs []*PullRequest) []*PullRequest {
+	clone := make([]*PullRequest, len(prs))
+	for i, pr := range prs {
+		copied := *pr
+		clone[i] = &copied
+	}
+	return clone
+}
+
+// FetchPullRequest fetches a pull request's head into a local pr-N
+// (GitHub) or mr-N (GitLab) branch and checks it out.
+func FetchPullRequest(repoName string, number int) (string, error) {
+	repo, err := models.Repositories.Find("WHERE Name = ?", repoName)
+	if err != nil {
+		return "", fmt.Errorf("repository '%s' not found", repoName)
+	}
+	remote := ParseRemote(repo.URL)
+	if remote == nil {
+		return "", fmt.Errorf("repository '%s' is not hosted on a recognized provider", repoName)
+	}
+
+	branch := fmt.Sprintf("pr-%d", number)
+	// Forced, so fetching again after the author force-pushed updates the branch
+	refspec := fmt.Sprintf("+pull/%d/head:%s", number, branch)
+	if remote.Kind == GitLab {
+		branch = fmt.Sprintf("mr-%d", number)
+		refspec = fmt.Sprintf("+merge-requests/%d/head:%s", number, branch)
+	}
+
+	// A network fetch, bounded like the other git network operations
+	// rather than by CoderExec's two minutes
+	ctx, cancel := context.WithTimeout(context.Background(), models.GetDurationSetting("operation_timeout"))
+	defer cancel()
+	cmd := fmt.Sprintf("cd %s && git fetch origin %s 2>&1 && git checkout %s 2>&1",
+		services.ShellQuote(repo.LocalPath), services.ShellQuote(refspec), services.ShellQuote(branch))
+	if result, err := services.CoderExecCtx(ctx, cmd); err != nil {
+		output := result.Output
+		if strings.Contains(output, "local changes") {
+			return "", fmt.Errorf("uncommitted changes - commit or stash them first")
+		}
+		return "", fmt.Errorf("failed to fetch pull request #%d", number)
+	}
+	return branch, nil
+}
+
+// User is the account a provider access token belongs to.
+type User struct {
+	Name  string
+	Email string // Empty when the account keeps its email private
+}
+
+// AuthenticatedUser returns the account behind the token configured for a
+// host. Returns ErrNotConfigured if no token is set.
+func AuthenticatedUser(host string) (*User, error) {
+	host = strings.ToLower(host)
+	kind, ok := knownHosts[host]
+	if !ok {
+		return nil, fmt.Errorf("unsupported provider host: %s", host)
+	}
+	token := Token(host)
+	if token == "" {
+		return nil, ErrNotConfigured
+	}
+
+	if kind == GitLab {
+		var user struct {
+			Name        string `json:"name"`
+			CommitEmail string `json:"commit_email"`
+			Email       string `json:"email"`
+		}
+		if err := getJSON("https://"+host+"/api/v4/user", map[string]string{"PRIVATE-TOKEN": token}, &user); err != nil {
+			return nil, err
+		}
+		if user.CommitEmail == "" {
+			user.CommitEmail = user.Email
+		}
+		return &User{Name: user.Name, Email: user.CommitEmail}, nil
+	}
+	return githubUser(token)
+}
+
+// githubUser reads the token's account, falling back to its primary
+// verified email when the profile email is private.
+func githubUser(token string) (*User, error) {
+	headers := map[string]string{"Authorization": "Bearer " + token}
+	var user struct {
+		Login string `json:"login"`
+		Name  string `json:"name"`
+		Email string `json:"email"`
+	}
+	if err := getJSON(githubAPI+"/user", headers, &user); err != nil {
+		return nil, err
+	}
+	if user.Name == "" {
+		user.Name = user.Login
+	}
+	if user.Email != "" {
+		return &User{Name: user.Name, Email: user.Email}, nil
+	}
+
+	// Needs the user:email scope; without it the email stays unknown
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+	if err := getJSON(githubAPI+"/user/emails", headers, &emails); err == nil {
+		for _, e := range emails {
+			if e.Primary && e.Verified {
+				user.Email = e.Email
+			}
+		}
+	}
+	return &User{Name: user.Name, Email: user.Email}, nil
+}
+
+// getJSON performs an authenticated API request and decodes the response.
+func getJSON(endpoint string, headers map[string]string, v any) error {
+	return doJSON(http.MethodGet, endpoint, headers, nil, v)
+}
+
+// doJSON performs an authenticated API request with an optional JSON body
+// and decodes the response.
+func doJSON(method, endpoint string, headers map[string]string, body any, v any) error {
+	_, err := sendJSON(method, endpoint, headers, body, v)
+	return err
+}
+
+// sendJSON is doJSON that also returns the response headers, for
+// pagination links.
+func sendJSON(method, endpoint string, headers map[string]string, body any, v any) (http.Header, error) {
+	var payload io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		payload = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, endpoint, payload)
+	if err != nil {
+		return nil, err
+	}
+	for k, val := range headers {
+		req.Header.Set(k, val)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach provider API: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusTooManyRequests ||
+		(resp.StatusCode == http.StatusForbidden && resp.Header.Get("X-RateLimit-Remaining") == "0") {
+		return nil, &RateLimitError{Reset: parseReset(resp.Header)}
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		return nil, fmt.Errorf("provider rejected the access token")
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return nil, &statusError{Code: resp.StatusCode, Status: resp.Status}
+	}
+	return resp.Header, json.NewDecoder(resp.Body).Decode(v)
+}
+
+// statusError is an unexpected response status from a provider API.
+type statusError struct {
+	Code   int
+	Status string
+}
+
+func (e *statusError) Error() string {
+	return "provider API returned " + e.Status
+}
+
+// parseReset reads the rate limit reset time from GitHub or GitLab headers.
+func parseReset(h http.Header) time.Time {
+	for _, key := range []string{"X-RateLimit-Reset", "RateLimit-Reset"} {
+		if epoch, err := strconv.ParseInt(h.Get(key), 10, 64); err == nil {
+			return time.Unix(epoch, 0)
+		}
+	}
+	return time.Now().Add(time.Minute)
+}
+
+// githubPullsQuery lists open pull requests with the CI state of each
+// head commit, so a listing is one API request however many are open.
+const githubPullsQuery = `query($owner: String!, $name: String!) {
+  repository(owner: $owner, name: $name) {
+    pullRequests(states: OPEN, first: 30, orderBy: {field: CREATED_AT, direction: DESC}) {
+      nodes {
+        number
+        title
+        url
+        createdAt
+        author { login }
+        commits(last: 1) { nodes { commit { statusCheckRollup { state } } } }
+      }
+    }
+  }
+}`
+
+func listGitHubPulls(remote *Remote, token string) ([]*PullRequest, error) {
+	headers := map[string]string{"Authorization": "Bearer " + token}
+	request := map[string]any{
+		"query":     githubPullsQuery,
+		"variables": map[string]string{"owner": remote.Owner, "name": remote.Name},
+	}
+
+	var response struct {
+		Data struct {
+			Repository *struct {
+				PullRequests struct {
+					Nodes []struct {
+						Number    int       `json:"number"`
+						Title     string    `json:"title"`
+						URL       string    `json:"url"`
+						CreatedAt time.Time `json:"createdAt"`
+						Author    *struct {
+							Login string `json:"login"`
+						} `json:"author"`
+						Commits struct {
+							Nodes []struct {
+								Commit struct {
+									StatusCheckRollup *struct {
+										State string `json:"state"`
+									} `json:"statusCheckRollup"`
+								} `json:"commit"`
+							} `json:"nodes"`
+						} `json:"commits"`
+					} `json:"nodes"`
+				} `json:"pullRequests"`
+			} `json:"repository"`
+		} `json:"data"`
+		Errors []struct {
+			Type    string `json:"type"`
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if err := doJSON(http.MethodPost, githubAPI+"/graphql", headers, request, &response); err != nil {
+		return nil, err
+	}
+	if len(response.Errors) > 0 {
+		if response.Errors[0].Type == "RATE_LIMITED" {
+			return nil, &RateLimitError{Reset: time.Now().Add(time.Minute)}
+		}
+		return nil, fmt.Errorf("provider API error: %s", response.Errors[0].Message)
+	}
+	if response.Data.Repository == nil {
+		return nil, fmt.Errorf("repository %s not found on %s", remote.Path(), remote.Host)
+	}
+
+	nodes := response.Data.Repository.PullRequests.Nodes
+	prs := make([]*PullRequest, 0, len(nodes))
+	for _, p := range nodes {
+		pr := &PullRequest{
+			Number:    p.Number,
+			Title:     p.Title,
+			URL:       p.URL,
+			CreatedAt: p.CreatedAt,
+		}
+		if p.Author != nil {
+			pr.Author = p.Author.Login
+		}
+		if commits := p.Commits.Nodes; len(commits) > 0 && commits[0].Commit.StatusCheckRollup != nil {
+			pr.CIStatus = githubCIStatus(commits[0].Commit.StatusCheckRollup.State)
+		}
+		prs = append(prs, pr)
+	}
+	return prs, nil
+}
+
+// githubCIStatus maps GitHub's status check rollup states onto the
+// success/failure/pending vocabulary.
+func githubCIStatus(state string) string {
+	switch state {
+	case "SUCCESS":
+		return "success"
+	case "FAILURE", "ERROR":
+		return "failure"
+	case "":
+		return ""
+	default:
+		return "pending"
+	}
+}
+
+func listGitLabMergeRequests(remote *Remote, token string) ([]*PullRequest, error) {
+	headers := map[string]string{"PRIVATE-TOKEN": token}
+
+	var mrs []struct {
+		IID       int       `json:"iid"`
+		Title     string    `json:"title"`
+		WebURL    string    `json:"web_url"`
+		CreatedAt time.Time `json:"created_at"`
+		Author    struct {
+			Username string `json:"username"`
+		} `json:"author"`
+		HeadPipeline *struct {
+			Status string `json:"status"`
+		} `json:"head_pipeline"`
+	}
+	endpoint := fmt.Sprintf("https://%s/api/v4/projects/%s/merge_requests?state=opened&per_page=30",
+		remote.Host, url.PathEscape(remote.Path()))
+	if err := getJSON(endpoint, headers, &mrs); err != nil {
+		return nil, err
+	}
+
+	prs := make([]*PullRequest, 0, len(mrs))
+	for _, mr := range mrs {
+		pr := &PullRequest{
+			Number:    mr.IID,
+			Title:     mr.Title,
+			Author:    mr.Author.Username,
+			URL:       mr.WebURL,
+			CreatedAt: mr.CreatedAt,
+		}
+		if mr.HeadPipeline != nil {
+			pr.CIStatus = gitlabCIStatus(mr.HeadPipeline.Status)
+		}
+		prs = append(prs, pr)
+	}
+	return prs, nil
+}
+
+// gitlabCIStatus maps GitLab pipeline states onto GitHub's status vocabulary.
+func gitlabCIStatus(status string) string {
+	switch status {
+	case "success":
+		return "success"
+	case "failed", "canceled":
+		return "failure"
+	case "":
+		return ""
+	default:
+		return "pending"
+	}
+}
@@ -0,0 +1,276 @@
+package providers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/The-Skyscape/devtools/pkg/testutils"
+)
+
+func TestParseRemote(t *testing.T) {
+	testCases := []struct {
+		input string
+		kind  Kind
+		path  string
+	}{
+		{"https://github.com/user/repo.git", GitHub, "user/repo"},
+		{"https://github.com/user/repo", GitHub, "user/repo"},
+		{"git@github.com:user/repo.git", GitHub, "user/repo"},
+		{"ssh://git@github.com/user/repo.git", GitHub, "user/repo"},
+		{"https://gitlab.com/group/subgroup/project.git", GitLab, "group/subgroup/project"},
+		{"git@gitlab.com:group/project.git", GitLab, "group/project"},
+	}
+
+	for _, tc := range testCases {
+		remote := ParseRemote(tc.input)
+		if remote == nil {
+			t.Fatalf("expected remote for %s", tc.input)
+		}
+		testutils.AssertEqual(t, tc.kind, remote.Kind)
+		testutils.AssertEqual(t, tc.path, remote.Path())
+	}
+}
+
+func TestParseRemoteUnrecognized(t *testing.T) {
+	for _, input := range []string{
+		"https://bitbucket.org/team/project.git",
+		"git@git.example.com:user/repo.git",
+		"https://github.com/",
+		"",
+	} {
+		testutils.AssertEqual(t, true, ParseRemote(input) == nil)
+	}
+}
+
+func TestGitLabCIStatus(t *testing.T) {
+	testutils.AssertEqual(t, "success", gitlabCIStatus("success"))
+	testutils.AssertEqual(t, "failure", gitlabCIStatus("failed"))
+	testutils.AssertEqual(t, "pending", gitlabCIStatus("running"))
+	testutils.AssertEqual(t, "", gitlabCIStatus(""))
+}
+
+func TestListGitHubPullsSingleRequest(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		testutils.AssertEqual(t, "/graphql", r.URL.Path)
+		testutils.AssertEqual(t, "Bearer secret", r.Header.Get("Authorization"))
+		w.Write([]byte(`{"data": {"repository": {"pullRequests": {"nodes": [
+			{"number": 7, "title": "Add cache", "url": "https://github.com/user/repo/pull/7", "createdAt": "2025-01-01T00:00:00Z",
+			 "author": {"login": "octocat"}, "commits": {"nodes": [{"commit": {"statusCheckRollup": {"state": "FAILURE"}}}]}},
+			{"number": 8, "title": "Docs", "url": "https://github.com/user/repo/pull/8", "createdAt": "2025-01-02T00:00:00Z",
+			 "author": null, "commits": {"nodes": [{"commit": {"statusCheckRollup": null}}]}}
+		]}}}}`))
+	}))
+	defer server.Close()
+
+	original := githubAPI
+	githubAPI = server.URL
+	defer func() { githubAPI = original }()
+
+	prs, err := listGitHubPulls(&Remote{Kind: GitHub, Host: "github.com", Owner: "user", Name: "repo"}, "secret")
+	testutils.AssertEqual(t, nil, err)
+	testutils.AssertEqual(t, 1, requests)
+	testutils.AssertEqual(t, 2, len(prs))
+	testutils.AssertEqual(t, "octocat", prs[0].Author)
+	testutils.AssertEqual(t, "failure", prs[0].CIStatus)
+	testutils.AssertEqual(t, "", prs[1].Author)
+	testutils.AssertEqual(t, "", prs[1].CIStatus)
+}
+
+func TestGitHubUserFallsBackToPrimaryEmail(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/user":
+			w.Write([]byte(`{"login": "jdoe", "name": "Jane Doe", "email": null}`))
+		case "/user/emails":
+			w.Write([]byte(`[{"email": "old@example.com", "primary": false, "verified": true},
+				{"email": "jane@corp.com", "primary": true, "verified": true}]`))
+		default:
+			t.Errorf("unexpected request to %s", r.URL.Path)
+		}
+	}))
+	defer server.Close()
+
+	original := githubAPI
+	githubAPI = server.URL
+	defer func() { githubAPI = original }()
+
+	user, err := githubUser("secret")
+	testutils.AssertEqual(t, nil, err)
+	testutils.AssertEqual(t, "Jane Doe", user.Name)
+	testutils.AssertEqual(t, "jane@corp.com", user.Email)
+}
+
+func TestGitHubCIStatus(t *testing.T) {
+	testutils.AssertEqual(t, "success", githubCIStatus("SUCCESS"))
+	testutils.AssertEqual(t, "failure", githubCIStatus("ERROR"))
+	testutils.AssertEqual(t, "pending", githubCIStatus("EXPECTED"))
+	testutils.AssertEqual(t, "", githubCIStatus(""))
+}
+
+func TestClonePullRequests(t *testing.T) {
+	cached := []*PullRequest{{Number: 1, Title: "Fix"}}
+	clone := clonePullRequests(cached)
+	clone[0].Title = "Changed"
+	testutils.AssertEqual(t, "Fix", cached[0].Title)
+}
+
+func TestListAccountRepositoriesFollowsPages(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		testutils.AssertEqual(t, "/users/octocat/repos", r.URL.Path)
+		if r.URL.Query().Get("page") == "2" {
+			w.Write([]byte(`[{"name": "tools", "full_name": "octocat/tools", "clone_url": "https://github.com/octocat/tools.git", "size": 2}]`))
+			return
+		}
+		w.Header().Set("Link", `<`+server.URL+`/users/octocat/repos?page=2>; rel="next", <`+server.URL+`/users/octocat/repos?page=2>; rel="last"`)
+		w.Write([]byte(`[{"name": "hello", "full_name": "octocat/hello", "clone_url": "https://github.com/octocat/hello.git",
+			"ssh_url": "git@github.com:octocat/hello.git", "size": 10}]`))
+	}))
+	defer server.Close()
+
+	original := githubAPI
+	githubAPI = server.URL
+	defer func() { githubAPI = original }()
+
+	repos, err := ListAccountRepositories(GitHub, "octocat", "")
+	testutils.AssertEqual(t, nil, err)
+	testutils.AssertEqual(t, 2, len(repos))
+	testutils.AssertEqual(t, "octocat/hello", repos[0].Path)
+	testutils.AssertEqual(t, "git@github.com:octocat/hello.git", repos[0].SSHURL)
+	testutils.AssertEqual(t, int64(10*1024), repos[0].Size)
+	testutils.AssertEqual(t, "tools", repos[1].Name)
+}
+
+func TestListAccountRepositoriesErrors(t *testing.T) {
+	reset := time.Now().Add(90 * time.Second)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "limited") {
+			w.Header().Set("X-RateLimit-Remaining", "0")
+			w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(reset.Unix(), 10))
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	defer server.Close()
+
+	original := githubAPI
+	githubAPI = server.URL
+	defer func() { githubAPI = original }()
+
+	_, err := ListAccountRepositories(GitHub, "nobody", "")
+	testutils.AssertEqual(t, "no account named nobody on github.com", err.Error())
+
+	_, err = ListAccountRepositories(GitHub, "limited", "")
+	var limited *RateLimitError
+	testutils.AssertEqual(t, true, errors.As(err, &limited))
+	testutils.AssertEqual(t, 2, limited.MinutesLeft(time.Now()))
+
+	_, err = ListAccountRepositories(GitHub, "../admin", "")
+	testutils.AssertEqual(t, true, err != nil)
+}
+
+func TestListAccountRepositoriesGitLabGroup(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/groups/acme/projects" {
+			http.NotFound(w, r)
+			return
+		}
+		testutils.AssertEqual(t, "secret", r.Header.Get("PRIVATE-TOKEN"))
+		w.Write([]byte(`[{"path": "api", "path_with_namespace": "acme/api", "visibility": "private",
+			"http_url_to_repo": "https://gitlab.com/acme/api.git", "statistics": {"repository_size": 4096}}]`))
+	}))
+	defer server.Close()
+
+	original := gitlabAPI
+	gitlabAPI = server.URL
+	defer func() { gitlabAPI = original }()
+
+	repos, err := ListAccountRepositories(GitLab, "acme", "secret")
+	testutils.AssertEqual(t, nil, err)
+	testutils.AssertEqual(t, 1, len(repos))
+	testutils.AssertEqual(t, true, repos[0].Private)
+	testutils.AssertEqual(t, int64(4096), repos[0].Size)
+}
+
+func TestNextPage(t *testing.T) {
+	testutils.AssertEqual(t, "https://api.github.com/user/repos?page=3",
+		nextPage(`<https://api.github.com/user/repos?page=1>; rel="prev", <https://api.github.com/user/repos?page=3>; rel="next"`))
+	testutils.AssertEqual(t, "", nextPage(`<https://api.github.com/user/repos?page=1>; rel="first"`))
+	testutils.AssertEqual(t, "", nextPage(""))
+}
+
+func TestUploadSSHKey(t *testing.T) {
+	status := http.StatusCreated
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		testutils.AssertEqual(t, http.MethodPost, r.Method)
+		testutils.AssertEqual(t, "/user/keys", r.URL.Path)
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+		testutils.AssertEqual(t, "ssh-ed25519 AAAAC3 dev@example.com", body["key"])
+		testutils.AssertEqual(t, "Workbench", body["title"])
+		w.WriteHeader(status)
+		w.Write([]byte(`{"id": 42}`))
+	}))
+	defer server.Close()
+
+	github, gitlab := githubAPI, gitlabAPI
+	githubAPI, gitlabAPI = server.URL, server.URL
+	defer func() { githubAPI, gitlabAPI = github, gitlab }()
+
+	testutils.AssertEqual(t, nil, UploadSSHKey(GitHub, "secret", "Workbench", "ssh-ed25519 AAAAC3 dev@example.com\n"))
+	testutils.AssertEqual(t, nil, UploadSSHKey(GitLab, "secret", "Workbench", "ssh-ed25519 AAAAC3 dev@example.com"))
+
+	status = http.StatusUnprocessableEntity
+	err := UploadSSHKey(GitHub, "secret", "Workbench", "ssh-ed25519 AAAAC3 dev@example.com")
+	testutils.AssertEqual(t, "the provider refused the key - it may already be on an account", err.Error())
+
+	testutils.AssertEqual(t, ErrNotConfigured, UploadSSHKey(GitHub, " ", "Workbench", "ssh-ed25519 AAAAC3"))
+}
+
+func TestRepositoryMetadata(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.EscapedPath() {
+		case "/repos/user/repo":
+			testutils.AssertEqual(t, "", r.Header.Get("Authorization"))
+			w.Write([]byte(`{"description": "A web API", "default_branch": "main", "language": "Go", "stargazers_count": 42}`))
+		case "/projects/acme%2Fapi":
+			testutils.AssertEqual(t, "secret", r.Header.Get("PRIVATE-TOKEN"))
+			w.Write([]byte(`{"description": "Billing", "default_branch": "trunk", "star_count": 3}`))
+		case "/projects/acme%2Fapi/languages":
+			w.Write([]byte(`{"Ruby": 71.5, "JavaScript": 28.5}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	github, gitlab := githubAPI, gitlabAPI
+	githubAPI, gitlabAPI = server.URL, server.URL
+	defer func() { githubAPI, gitlabAPI = github, gitlab }()
+
+	meta, err := repositoryMetadata(&Remote{Kind: GitHub, Host: "github.com", Owner: "user", Name: "repo"}, "")
+	testutils.AssertEqual(t, nil, err)
+	testutils.AssertEqual(t, Metadata{Description: "A web API", DefaultBranch: "main", Language: "Go", Stars: 42}, *meta)
+
+	meta, err = repositoryMetadata(&Remote{Kind: GitLab, Host: "gitlab.com", Owner: "acme", Name: "api"}, "secret")
+	testutils.AssertEqual(t, nil, err)
+	testutils.AssertEqual(t, Metadata{Description: "Billing", DefaultBranch: "trunk", Language: "Ruby", Stars: 3}, *meta)
+
+	_, err = repositoryMetadata(&Remote{Kind: GitHub, Host: "github.com", Owner: "user", Name: "private"}, "")
+	var status *statusError
+	testutils.AssertEqual(t, true, errors.As(err, &status))
+	testutils.AssertEqual(t, http.StatusNotFound, status.Code)
+
+	meta, err = RepositoryMetadata("https://example.com/user/repo.git")
+	testutils.AssertEqual(t, nil, err)
+	testutils.AssertEqual(t, true, meta == nil)
+}
@@ -1,16 +1,70 @@
 package internal
 
 import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sort"
 	"sync"
 	"time"
+	"workbench/models"
 )
 
-// Simple in-memory rate limiter for authentication attempts
+// strikeMemory is how long a key's strikes count towards a penalty after
+// its last one.
+const strikeMemory = 24 * time.Hour
+
+// authLockoutsKey holds AuthRateLimiter's lockouts as JSON, so a restart
+// doesn't hand a locked out client a fresh set of attempts.
+const authLockoutsKey = "ratelimit_lockouts:auth"
+
+// rateLimitNow is the clock rate limiters use. Replaced in tests.
+var rateLimitNow = time.Now
+
+// Simple in-memory rate limiter for authentication attempts, with optional
+// escalating lockouts for keys that keep hitting the limit
 type RateLimiter struct {
 	attempts map[string][]time.Time
 	mu       sync.Mutex
 	limit    int
 	window   time.Duration
+
+	strikes   int             // Windows over the limit before the first penalty, 0 for none
+	penalties []time.Duration // Lockout per penalty; the last repeats
+	store     LockoutStore    // Nil keeps lockouts in memory only
+	lockouts  map[string]*Lockout
+	loaded    bool
+}
+
+// Lockout is a key's record of exceeding its limit.
+type Lockout struct {
+	Key        string    `json:"key"`
+	Strikes    int       `json:"strikes"` // Windows in which the limit was exceeded
+	LastStrike time.Time `json:"last_strike"`
+	Until      time.Time `json:"until"` // Zero when the key was never locked
+}
+
+// Locked reports whether the lockout is in force at now.
+func (l *Lockout) Locked(now time.Time) bool {
+	return now.Before(l.Until)
+}
+
+// RateLimitStatus is what a key has left.
+type RateLimitStatus struct {
+	Remaining   int       // Attempts left in the current window
+	LockedUntil time.Time // Zero unless the key is locked out
+}
+
+// Locked reports whether the key is locked out rather than just out of
+// attempts for the window.
+func (s RateLimitStatus) Locked() bool {
+	return !s.LockedUntil.IsZero()
+}
+
+// LockoutStore persists lockouts across restarts.
+type LockoutStore interface {
+	LoadLockouts() (map[string]*Lockout, error)
+	SaveLockouts(map[string]*Lockout) error
 }
 
 // NewRateLimiter creates a new rate limiter
@@ -25,25 +79,36 @@ func NewRateLimiter(limit int, window time.Duration) *RateLimiter {
 	return rl
 }
 
+// WithPenalties locks a key out once it has exceeded the limit in strikes
+// windows: for the first penalty, then for each following one on every
+// further window over the limit, repeating the last.
+func (rl *RateLimiter) WithPenalties(strikes int, penalties ...time.Duration) *RateLimiter {
+	rl.strikes = strikes
+	rl.penalties = penalties
+	return rl
+}
+
+// WithStore keeps lockouts in store, loaded on first use.
+func (rl *RateLimiter) WithStore(store LockoutStore) *RateLimiter {
+	rl.store = store
+	return rl
+}
+
 // Allow checks if an attempt is allowed for the given key
 func (rl *RateLimiter) Allow(key string) bool {
 	rl.mu.Lock()
 	defer rl.mu.Unlock()
 
-	now := time.Now()
-	cutoff := now.Add(-rl.window)
-
-	// Remove old attempts
-	var recent []time.Time
-	for _, t := range rl.attempts[key] {
-		if t.After(cutoff) {
-			recent = append(recent, t)
-		}
+	now := rateLimitNow()
+	if lockout := rl.lockout(key); lockout != nil && lockout.Locked(now) {
+		return false
 	}
+	recent := rl.recent(key, now)
 
 	// Check if under limit
 	if len(recent) >= rl.limit {
 		rl.attempts[key] = recent
+		rl.strike(key, now)
 		return false
 	}
 
@@ -53,29 +118,211 @@ func (rl *RateLimiter) Allow(key string) bool {
 	return true
 }
 
+// Blocked reports whether key has used up its attempts in the current
+// window or is locked out, without recording an attempt
+func (rl *RateLimiter) Blocked(key string) bool {
+	status := rl.Status(key)
+	return status.Remaining == 0 || status.Locked()
+}
+
+// Status reports the attempts key has left in the current window and when
+// its lockout ends, without recording an attempt.
+func (rl *RateLimiter) Status(key string) RateLimitStatus {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := rateLimitNow()
+	if lockout := rl.lockout(key); lockout != nil && lockout.Locked(now) {
+		return RateLimitStatus{LockedUntil: lockout.Until}
+	}
+	return RateLimitStatus{Remaining: max(rl.limit-len(rl.recent(key, now)), 0)}
+}
+
+// Lockouts returns the keys locked out now, soonest to expire first.
+func (rl *RateLimiter) Lockouts() []*Lockout {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	rl.load()
+	now := rateLimitNow()
+	var locked []*Lockout
+	for _, lockout := range rl.lockouts {
+		if lockout.Locked(now) {
+			copied := *lockout
+			locked = append(locked, &copied)
+		}
+	}
+	sort.Slice(locked, func(i, j int) bool { return locked[i].Until.Before(locked[j].Until) })
+	return locked
+}
+
+// Clear forgets key's attempts, strikes, and lockout. Reports whether the
+// key was locked out.
+func (rl *RateLimiter) Clear(key string) bool {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	lockout := rl.lockout(key)
+	delete(rl.attempts, key)
+	if lockout == nil {
+		return false
+	}
+	delete(rl.lockouts, key)
+	rl.save()
+	return lockout.Locked(rateLimitNow())
+}
+
+// recent returns key's attempts within the window. Callers hold mu.
+func (rl *RateLimiter) recent(key string, now time.Time) []time.Time {
+	cutoff := now.Add(-rl.window)
+	var recent []time.Time
+	for _, t := range rl.attempts[key] {
+		if t.After(cutoff) {
+			recent = append(recent, t)
+		}
+	}
+	return recent
+}
+
+// lockout returns key's record, or nil. Callers hold mu.
+func (rl *RateLimiter) lockout(key string) *Lockout {
+	rl.load()
+	return rl.lockouts[key]
+}
+
+// strike counts a window in which key exceeded the limit, at most once per
+// window, and locks it out once it has enough. Callers hold mu.
+func (rl *RateLimiter) strike(key string, now time.Time) {
+	if rl.strikes == 0 {
+		return
+	}
+	lockout := rl.lockout(key)
+	switch {
+	case lockout == nil || now.Sub(lockout.LastStrike) > strikeMemory:
+		lockout = &Lockout{Key: key}
+		rl.lockouts[key] = lockout
+	case now.Sub(lockout.LastStrike) < rl.window:
+		return
+	}
+	lockout.Strikes++
+	lockout.LastStrike = now
+	if penalty := lockout.Strikes - rl.strikes; penalty >= 0 && len(rl.penalties) > 0 {
+		lockout.Until = now.Add(rl.penalties[min(penalty, len(rl.penalties)-1)])
+		// The lockout replaces the window, so attempts start over after it
+		delete(rl.attempts, key)
+		go models.Activities.Insert(&models.Activity{
+			Type:        "ratelimit_lockout",
+			Description: "Locked out " + key + " until " + lockout.Until.Format("15:04") + " after repeated attempts",
+			Author:      "System",
+			Timestamp:   now,
+		})
+	}
+	rl.save()
+}
+
+// load reads lockouts from the store the first time they're needed, since
+// limiters are created before the database is open. Callers hold mu.
+func (rl *RateLimiter) load() {
+	if rl.loaded {
+		return
+	}
+	rl.loaded = true
+	rl.lockouts = map[string]*Lockout{}
+	if rl.store == nil {
+		return
+	}
+	lockouts, err := rl.store.LoadLockouts()
+	if err != nil {
+		slog.Error("failed to load rate limit lockouts", "error", err)
+		return
+	}
+	for key, lockout := range lockouts {
+		lockout.Key = key
+		rl.lockouts[key] = lockout
+	}
+}
+
+// save writes lockouts to the store, if any. Callers hold mu.
+func (rl *RateLimiter) save() {
+	if rl.store == nil {
+		return
+	}
+	if err := rl.store.SaveLockouts(rl.lockouts); err != nil {
+		slog.Error("failed to save rate limit lockouts", "error", err)
+	}
+}
+
 // cleanup removes old entries to prevent memory growth
 func (rl *RateLimiter) cleanup() {
 	ticker := time.NewTicker(5 * time.Minute)
 	for range ticker.C {
 		rl.mu.Lock()
-		cutoff := time.Now().Add(-rl.window)
-		for key, attempts := range rl.attempts {
-			var recent []time.Time
-			for _, t := range attempts {
-				if t.After(cutoff) {
-					recent = append(recent, t)
-				}
-			}
-			if len(recent) == 0 {
+		now := rateLimitNow()
+		for key := range rl.attempts {
+			if recent := rl.recent(key, now); len(recent) == 0 {
 				delete(rl.attempts, key)
 			} else {
 				rl.attempts[key] = recent
 			}
 		}
+		expired := false
+		for key, lockout := range rl.lockouts {
+			if !lockout.Locked(now) && now.Sub(lockout.LastStrike) > strikeMemory {
+				delete(rl.lockouts, key)
+				expired = true
+			}
+		}
+		if expired {
+			rl.save()
+		}
 		rl.mu.Unlock()
 	}
 }
 
+// ClearAuthLockout lets a client locked out of signing in try again, e.g.
+// the owner after mistyping the password on another device.
+func ClearAuthLockout(key string) error {
+	if !AuthRateLimiter.Clear(key) {
+		return fmt.Errorf("%s is not locked out", key)
+	}
+	go models.Activities.Insert(&models.Activity{
+		Type:        "ratelimit_clear",
+		Description: "Cleared the lockout of " + key,
+		Author:      "System",
+		Timestamp:   time.Now(),
+	})
+	return nil
+}
+
+// settingLockoutStore keeps lockouts as JSON in a hidden setting.
+type settingLockoutStore string
+
+func (key settingLockoutStore) LoadLockouts() (map[string]*Lockout, error) {
+	lockouts := map[string]*Lockout{}
+	value, err := models.GetSetting(string(key))
+	if err != nil || value == "" {
+		return lockouts, nil
+	}
+	return lockouts, json.Unmarshal([]byte(value), &lockouts)
+}
+
+func (key settingLockoutStore) SaveLockouts(lockouts map[string]*Lockout) error {
+	data, err := json.Marshal(lockouts)
+	if err != nil {
+		return err
+	}
+	_, err = models.SetSetting(string(key), string(data), "system")
+	return err
+}
+
 // AuthRateLimiter is the global rate limiter for authentication
-// Allows 5 attempts per minute per IP
-var AuthRateLimiter = NewRateLimiter(5, time.Minute)
+// Allows 5 attempts per minute per IP. A client over the limit in 3
+// windows within a day is locked out for 15 minutes, then an hour each
+// further time; lockouts survive restarts.
+var AuthRateLimiter = NewRateLimiter(5, time.Minute).
+	WithPenalties(3, 15*time.Minute, time.Hour).
+	WithStore(settingLockoutStore(authLockoutsKey))
+
+// WebhookRateLimiter limits webhook deliveries with bad signatures
+// Allows 10 failures per minute per IP
+var WebhookRateLimiter = NewRateLimiter(10, time.Minute)
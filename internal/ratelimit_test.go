@@ -0,0 +1,118 @@
+package internal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/The-Skyscape/devtools/pkg/testutils"
+)
+
+// memoryLockoutStore stands in for the settings table.
+type memoryLockoutStore struct {
+	saved map[string]*Lockout
+}
+
+func (s *memoryLockoutStore) LoadLockouts() (map[string]*Lockout, error) {
+	return s.saved, nil
+}
+
+func (s *memoryLockoutStore) SaveLockouts(lockouts map[string]*Lockout) error {
+	s.saved = map[string]*Lockout{}
+	for key, lockout := range lockouts {
+		copied := *lockout
+		s.saved[key] = &copied
+	}
+	return nil
+}
+
+// fakeRateLimitClock sets the rate limiter clock for the duration of a test
+// and returns a func that moves it on.
+func fakeRateLimitClock(t *testing.T) (advance func(time.Duration)) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	clock := rateLimitNow
+	t.Cleanup(func() { rateLimitNow = clock })
+	rateLimitNow = func() time.Time { return now }
+	return func(d time.Duration) { now = now.Add(d) }
+}
+
+// exceed uses up key's attempts and makes one more.
+func exceed(rl *RateLimiter, key string) bool {
+	for range rl.limit {
+		rl.Allow(key)
+	}
+	return rl.Allow(key)
+}
+
+func TestRateLimiterStatus(t *testing.T) {
+	fakeRateLimitClock(t)
+	rl := &RateLimiter{attempts: map[string][]time.Time{}, limit: 3, window: time.Minute}
+
+	testutils.AssertEqual(t, 3, rl.Status("ip").Remaining)
+	rl.Allow("ip")
+	testutils.AssertEqual(t, 2, rl.Status("ip").Remaining)
+	testutils.AssertEqual(t, false, rl.Status("ip").Locked())
+}
+
+func TestRateLimiterEscalatesPenalties(t *testing.T) {
+	advance := fakeRateLimitClock(t)
+	rl := (&RateLimiter{attempts: map[string][]time.Time{}, limit: 2, window: time.Minute}).
+		WithPenalties(3, 15*time.Minute, time.Hour)
+	start := rateLimitNow()
+
+	// Two windows over the limit only cost the window
+	for range 2 {
+		testutils.AssertEqual(t, false, exceed(rl, "ip"))
+		testutils.AssertEqual(t, false, rl.Status("ip").Locked())
+		advance(time.Minute)
+	}
+	testutils.AssertEqual(t, true, rl.Allow("ip"))
+
+	// Repeated attempts in one window count once
+	rl.Allow("ip")
+	rl.Allow("ip")
+	rl.Allow("ip")
+	testutils.AssertEqual(t, start.Add(2*time.Minute+15*time.Minute), rl.Status("ip").LockedUntil)
+	testutils.AssertEqual(t, 3, rl.Lockouts()[0].Strikes)
+
+	advance(10 * time.Minute)
+	testutils.AssertEqual(t, false, rl.Allow("ip"))
+	testutils.AssertEqual(t, true, rl.Blocked("ip"))
+
+	// Served: attempts start over, and the next window over the limit costs an hour
+	advance(5 * time.Minute)
+	testutils.AssertEqual(t, true, rl.Allow("ip"))
+	exceed(rl, "ip")
+	testutils.AssertEqual(t, rateLimitNow().Add(time.Hour), rl.Status("ip").LockedUntil)
+	testutils.AssertEqual(t, false, rl.Blocked("other"))
+}
+
+func TestRateLimiterForgetsOldStrikes(t *testing.T) {
+	advance := fakeRateLimitClock(t)
+	rl := (&RateLimiter{attempts: map[string][]time.Time{}, limit: 1, window: time.Minute}).
+		WithPenalties(2, 15*time.Minute)
+
+	exceed(rl, "ip")
+	advance(strikeMemory + time.Minute)
+	exceed(rl, "ip")
+	testutils.AssertEqual(t, false, rl.Status("ip").Locked())
+}
+
+func TestRateLimiterPersistsLockouts(t *testing.T) {
+	advance := fakeRateLimitClock(t)
+	store := &memoryLockoutStore{}
+	rl := (&RateLimiter{attempts: map[string][]time.Time{}, limit: 1, window: time.Minute}).
+		WithPenalties(1, 15*time.Minute).WithStore(store)
+	exceed(rl, "ip:10.0.0.1:signin")
+
+	// A restarted process starts with no attempts but keeps the lockout
+	advance(time.Minute)
+	restarted := (&RateLimiter{attempts: map[string][]time.Time{}, limit: 1, window: time.Minute}).
+		WithPenalties(1, 15*time.Minute).WithStore(store)
+	testutils.AssertEqual(t, false, restarted.Allow("ip:10.0.0.1:signin"))
+	testutils.AssertEqual(t, "ip:10.0.0.1:signin", restarted.Lockouts()[0].Key)
+
+	testutils.AssertEqual(t, true, restarted.Clear("ip:10.0.0.1:signin"))
+	testutils.AssertEqual(t, 0, len(store.saved))
+	testutils.AssertEqual(t, true, restarted.Allow("ip:10.0.0.1:signin"))
+	testutils.AssertEqual(t, false, restarted.Clear("ip:10.0.0.1:signin"))
+}
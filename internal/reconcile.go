@@ -0,0 +1,270 @@
+package internal
+
+import (
+	"fmt"
+	"log/slog"
+	"path"
+	"strings"
+	"sync"
+	"time"
+	"workbench/models"
+	"workbench/services"
+)
+
+// reconcileSettle is how long an unrecorded directory must go unchanged
+// before it is adopted, so a clone or storage move still in progress isn't
+// recorded twice.
+const reconcileSettle = 2 * time.Minute
+
+// ReconcileResult is what ReconcileRepositories found and did. Entries are
+// repository names, or directory paths for directories not adopted.
+type ReconcileResult struct {
+	Adopted []string // Directories recorded as repositories
+	Missing []string // Repositories whose directory is gone, now flagged Missing
+	Found   []string // Repositories flagged Missing whose directory is back
+	NotGit  []string // Directories that aren't git repositories, left alone
+	Busy    []string // Directories changed too recently to adopt
+	Failed  []string // "path: reason" for directories that couldn't be adopted
+}
+
+// Changed reports whether reconciling changed any repository record.
+func (r *ReconcileResult) Changed() bool {
+	return len(r.Adopted)+len(r.Missing)+len(r.Found) > 0
+}
+
+// reconcileRoot is a directory repositories are cloned into.
+type reconcileRoot struct {
+	Path       string
+	LocationID string // Empty for DefaultReposDir
+}
+
+// diskRepoDir is a directory found directly under a reconcileRoot.
+type diskRepoDir struct {
+	Root   reconcileRoot
+	Path   string
+	Git    bool
+	Remote string // origin's URL, empty when there is none
+}
+
+// reconcilePlan is how the directories on disk differ from the database.
+type reconcilePlan struct {
+	Adopt   []diskRepoDir
+	Missing []*models.Repository
+	Found   []*models.Repository
+	NotGit  []string
+	Failed  []string
+}
+
+var (
+	reconcileMu sync.Mutex // One reconciliation at a time
+
+	// Replaced in tests
+	reconcileExec  = services.CoderExec
+	reconcileRepos = func() ([]*models.Repository, error) {
+		return models.Repositories.Search("ORDER BY Name ASC")
+	}
+	reconcileRoots = func() ([]reconcileRoot, error) {
+		locations, err := GetStorageLocations()
+		if err != nil {
+			return nil, fmt.Errorf("failed to load storage locations")
+		}
+		roots := []reconcileRoot{{Path: DefaultReposDir}}
+		for _, location := range locations {
+			roots = append(roots, reconcileRoot{Path: location.ContainerPath, LocationID: location.ID})
+		}
+		return roots, nil
+	}
+)
+
+// ReconcileRepositories compares the repositories directory and every
+// storage location with the recorded repositories. Git directories with no
+// record are adopted, using origin as their URL; recorded repositories
+// whose directory is gone are flagged Missing, so they show the Missing
+// badge and Re-clone button. Other directories are listed but left alone.
+// The outcome is recorded as a single repo_reconcile activity.
+func ReconcileRepositories() (*ReconcileResult, error) {
+	reconcileMu.Lock()
+	defer reconcileMu.Unlock()
+
+	if !services.Coder.IsRunning() {
+		return nil, ErrCoderNotRunning
+	}
+	roots, err := reconcileRoots()
+	if err != nil {
+		return nil, err
+	}
+	dirs, err := scanRepoDirs(roots)
+	if err != nil {
+		return nil, err
+	}
+	repos, err := reconcileRepos()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load repositories")
+	}
+
+	plan := planReconcile(roots, dirs, repos)
+	result := &ReconcileResult{NotGit: plan.NotGit, Failed: plan.Failed}
+	for _, dir := range plan.Adopt {
+		if busy, err := recentlyChanged(dir.Path); err != nil || busy {
+			result.Busy = append(result.Busy, dir.Path)
+			continue
+		}
+		repo, err := adoptRepository(dir)
+		if err != nil {
+			result.Failed = append(result.Failed, dir.Path+": "+err.Error())
+			continue
+		}
+		result.Adopted = append(result.Adopted, repo.Name)
+	}
+	for _, repo := range plan.Missing {
+		repo.Missing = true
+		if err := models.Repositories.Update(repo); err != nil {
+			slog.Error("failed to flag repository as missing", "name", repo.Name, "error", err)
+			continue
+		}
+		result.Missing = append(result.Missing, repo.Name)
+	}
+	for _, repo := range plan.Found {
+		clearMissing(repo)
+		result.Found = append(result.Found, repo.Name)
+	}
+
+	go models.Activities.Insert(&models.Activity{
+		Type:        "repo_reconcile",
+		Description: reconcileDescription(result),
+		Author:      "System",
+		Timestamp:   time.Now(),
+	})
+	return result, nil
+}
+
+// scanRepoDirs lists the directories directly under each root, noting
+// which are git repositories and their origin. Roots that don't exist
+// have nothing in them.
+func scanRepoDirs(roots []reconcileRoot) ([]diskRepoDir, error) {
+	var quoted []string
+	byPath := map[string]reconcileRoot{}
+	for _, root := range roots {
+		quoted = append(quoted, shellQuote(root.Path))
+		byPath[path.Clean(root.Path)] = root
+	}
+	cmd := fmt.Sprintf(`for root in %s; do
+		for d in "$root"/*/; do
+			[ -d "$d" ] || continue
+			d="${d%%/}"
+			if [ -e "$d/.git" ]; then
+				printf 'git\t%%s\t%%s\n' "$d" "$(git -C "$d" remote get-url origin 2>/dev/null)"
+			else
+				printf 'dir\t%%s\t\n' "$d"
+			fi
+		done
+	done`, strings.Join(quoted, " "))
+	output, err := reconcileExec(cmd)
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan repository directories: %s", strings.TrimSpace(output))
+	}
+	return parseRepoDirs(output, byPath), nil
+}
+
+// parseRepoDirs parses scanRepoDirs output, one "kind\tpath\tremote"
+// line per directory.
+func parseRepoDirs(output string, roots map[string]reconcileRoot) []diskRepoDir {
+	var dirs []diskRepoDir
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.SplitN(line, "\t", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		p := path.Clean(fields[1])
+		root, ok := roots[path.Dir(p)]
+		if !ok {
+			continue
+		}
+		dirs = append(dirs, diskRepoDir{Root: root, Path: p, Git: fields[0] == "git", Remote: strings.TrimSpace(fields[2])})
+	}
+	return dirs
+}
+
+// planReconcile compares directories found under roots with the recorded
+// repositories. Repositories recorded outside every root are left alone,
+// since their directory wasn't scanned.
+func planReconcile(roots []reconcileRoot, dirs []diskRepoDir, repos []*models.Repository) *reconcilePlan {
+	plan := &reconcilePlan{}
+	scanned := map[string]bool{}
+	for _, root := range roots {
+		scanned[path.Clean(root.Path)] = true
+	}
+	onDisk := map[string]bool{}
+	for _, dir := range dirs {
+		onDisk[dir.Path] = true
+	}
+
+	recorded := map[string]bool{}
+	names := map[string]string{} // Lowercase name to the path using it
+	for _, repo := range repos {
+		p := path.Clean(repo.LocalPath)
+		recorded[p] = true
+		names[strings.ToLower(repo.Name)] = p
+		switch {
+		case !scanned[path.Dir(p)]:
+		case repo.CloneStatus != models.CloneReady: // Cloning, or failed and left for retry
+		case !onDisk[p] && !repo.Missing:
+			plan.Missing = append(plan.Missing, repo)
+		case onDisk[p] && repo.Missing:
+			plan.Found = append(plan.Found, repo)
+		}
+	}
+
+	for _, dir := range dirs {
+		name := path.Base(dir.Path)
+		switch {
+		case recorded[dir.Path]:
+		case !dir.Git:
+			plan.NotGit = append(plan.NotGit, dir.Path)
+		case !validRepoName.MatchString(name):
+			plan.Failed = append(plan.Failed, dir.Path+": not a valid repository name - rename the directory")
+		case names[strings.ToLower(name)] != "":
+			plan.Failed = append(plan.Failed, fmt.Sprintf("%s: the name %s is already used by %s", dir.Path, name, names[strings.ToLower(name)]))
+		default:
+			names[strings.ToLower(name)] = dir.Path
+			plan.Adopt = append(plan.Adopt, dir)
+		}
+	}
+	return plan
+}
+
+// recentlyChanged reports whether anything near the top of dir changed
+// within reconcileSettle, which includes the pack file of a running clone.
+func recentlyChanged(dir string) (bool, error) {
+	output, err := reconcileExec(fmt.Sprintf("find %s -maxdepth 4 -mmin -%d -print -quit 2>/dev/null", shellQuote(dir), int(reconcileSettle/time.Minute)))
+	return strings.TrimSpace(output) != "", err
+}
+
+// adoptRepository records a directory found on disk as a repository.
+func adoptRepository(dir diskRepoDir) (*models.Repository, error) {
+	repo, err := models.Repositories.Insert(&models.Repository{
+		Name:              path.Base(dir.Path),
+		URL:               dir.Remote,
+		LocalPath:         dir.Path,
+		IsPrivate:         strings.Contains(dir.Remote, "git@"),
+		Host:              RepoHost(dir.Remote),
+		StorageLocationID: dir.Root.LocationID,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to save repository")
+	}
+	return repo, nil
+}
+
+// reconcileDescription summarizes a reconciliation for the activity log.
+func reconcileDescription(r *ReconcileResult) string {
+	description := fmt.Sprintf("Reconciled repositories: %d adopted, %d missing, %d found again, %d not git repositories",
+		len(r.Adopted), len(r.Missing), len(r.Found), len(r.NotGit))
+	if len(r.Busy) > 0 {
+		description += fmt.Sprintf(", %d still changing", len(r.Busy))
+	}
+	if len(r.Failed) > 0 {
+		description += fmt.Sprintf(", %d failed", len(r.Failed))
+	}
+	return description
+}
@@ -0,0 +1,86 @@
+package internal
+
+import (
+	"strings"
+	"testing"
+	"workbench/models"
+
+	"github.com/The-Skyscape/devtools/pkg/testutils"
+)
+
+func TestParseRepoDirs(t *testing.T) {
+	roots := map[string]reconcileRoot{
+		"/home/coder/repos": {Path: "/home/coder/repos"},
+		"/mnt/fast":         {Path: "/mnt/fast/", LocationID: "loc1"},
+	}
+	output := strings.Join([]string{
+		"git\t/home/coder/repos/api\tgit@github.com:acme/api.git",
+		"git\t/home/coder/repos/scratch\t",
+		"dir\t/home/coder/repos/downloads\t",
+		"git\t/mnt/fast/web\thttps://gitlab.com/acme/web.git",
+		"git\t/elsewhere/other\t",
+		"",
+	}, "\n")
+
+	dirs := parseRepoDirs(output, roots)
+	var summary []string
+	for _, d := range dirs {
+		summary = append(summary, d.Path+"|"+d.Remote+"|"+d.Root.LocationID)
+	}
+	testutils.AssertEqual(t, strings.Join([]string{
+		"/home/coder/repos/api|git@github.com:acme/api.git|",
+		"/home/coder/repos/scratch||",
+		"/home/coder/repos/downloads||",
+		"/mnt/fast/web|https://gitlab.com/acme/web.git|loc1",
+	}, ","), strings.Join(summary, ","))
+	testutils.AssertEqual(t, false, dirs[2].Git)
+}
+
+func TestPlanReconcile(t *testing.T) {
+	roots := []reconcileRoot{{Path: "/home/coder/repos"}}
+	repos := []*models.Repository{
+		{Name: "api", LocalPath: "/home/coder/repos/api"},
+		{Name: "web", LocalPath: "/home/coder/repos/web"},
+		{Name: "docs", LocalPath: "/home/coder/repos/docs", Missing: true},
+		{Name: "old", LocalPath: "/home/coder/repos/old", Missing: true},
+		{Name: "mounted", LocalPath: "/mnt/unscanned/mounted"},
+	}
+	dirs := []diskRepoDir{
+		{Path: "/home/coder/repos/api", Git: true},
+		{Path: "/home/coder/repos/docs", Git: true},
+		{Path: "/home/coder/repos/cli", Git: true, Remote: "git@github.com:acme/cli.git"},
+		{Path: "/home/coder/repos/API", Git: true},
+		{Path: "/home/coder/repos/my project", Git: true},
+		{Path: "/home/coder/repos/downloads"},
+	}
+
+	plan := planReconcile(roots, dirs, repos)
+	names := func(repos []*models.Repository) string {
+		var list []string
+		for _, repo := range repos {
+			list = append(list, repo.Name)
+		}
+		return strings.Join(list, ",")
+	}
+	var adopt []string
+	for _, d := range plan.Adopt {
+		adopt = append(adopt, d.Path)
+	}
+	testutils.AssertEqual(t, "/home/coder/repos/cli", strings.Join(adopt, ","))
+	testutils.AssertEqual(t, "web", names(plan.Missing)) // old is already flagged
+	testutils.AssertEqual(t, "docs", names(plan.Found))
+	testutils.AssertEqual(t, "/home/coder/repos/downloads", strings.Join(plan.NotGit, ","))
+	testutils.AssertEqual(t, 2, len(plan.Failed))
+	testutils.AssertEqual(t, true, strings.HasPrefix(plan.Failed[0], "/home/coder/repos/API: the name API is already used by /home/coder/repos/api"))
+	testutils.AssertEqual(t, true, strings.HasPrefix(plan.Failed[1], "/home/coder/repos/my project: not a valid repository name"))
+}
+
+func TestReconcileDescription(t *testing.T) {
+	result := &ReconcileResult{Adopted: []string{"cli"}, Missing: []string{"web", "docs"}, NotGit: []string{"/home/coder/repos/downloads"}}
+	testutils.AssertEqual(t, "Reconciled repositories: 1 adopted, 2 missing, 0 found again, 1 not git repositories", reconcileDescription(result))
+	testutils.AssertEqual(t, true, result.Changed())
+
+	result = &ReconcileResult{Busy: []string{"/home/coder/repos/big"}, Failed: []string{"x: y"}}
+	testutils.AssertEqual(t, "Reconciled repositories: 0 adopted, 0 missing, 0 found again, 0 not git repositories, 1 still changing, 1 failed", reconcileDescription(result))
+	testutils.AssertEqual(t, false, result.Changed())
+}
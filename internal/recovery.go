@@ -0,0 +1,246 @@
+package internal
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"workbench/models"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// RecoveryEnvVar enables the recovery console at startup when set to "1".
+const RecoveryEnvVar = "WORKBENCH_RECOVERY"
+
+// RecoveryCodeTTL is how long a recovery code can be redeemed after startup.
+const RecoveryCodeTTL = 15 * time.Minute
+
+// MinPasswordLength matches the sign-in form's minimum.
+const MinPasswordLength = 8
+
+// Recovery code redemption errors.
+var (
+	ErrRecoveryConsumed = errors.New("this recovery code has already been used - restart with " + RecoveryEnvVar + "=1 for a new one")
+	ErrRecoveryExpired  = errors.New("this recovery code has expired - restart with " + RecoveryEnvVar + "=1 for a new one")
+	ErrRecoveryInvalid  = errors.New("invalid recovery code")
+)
+
+// recoveryConsumedKey is the setting recording when a recovery code was
+// used, so restarting with the variable still set doesn't open a new
+// console. It's cleared by the first start without the variable.
+const recoveryConsumedKey = "recovery_consumed_at"
+
+// RecoveryRequested reports whether the recovery console was requested
+// for this run.
+func RecoveryRequested() bool {
+	return os.Getenv(RecoveryEnvVar) == "1"
+}
+
+// RecoveryConsumedEarlier reports whether a recovery code was used on an
+// earlier run that still had RecoveryEnvVar set.
+func RecoveryConsumedEarlier() bool {
+	consumed, _ := models.GetSetting(recoveryConsumedKey)
+	return consumed != ""
+}
+
+// ClearRecoveryConsumed forgets an earlier redemption once the workbench
+// has been started without RecoveryEnvVar, so recovery can be used again.
+func ClearRecoveryConsumed() {
+	if !RecoveryConsumedEarlier() {
+		return
+	}
+	if _, err := models.SetSetting(recoveryConsumedKey, "", "recovery"); err != nil {
+		slog.Error("failed to clear recovery marker", "error", err)
+	}
+}
+
+// markRecoveryConsumed persists that the recovery code was used. Replaced
+// in tests.
+var markRecoveryConsumed = func(now time.Time) error {
+	_, err := models.SetSetting(recoveryConsumedKey, now.Format(time.RFC3339), "recovery")
+	return err
+}
+
+// RecoveryConsole holds the one-time recovery code for a run started in
+// recovery mode. Only a hash of the code is kept in memory.
+type RecoveryConsole struct {
+	mu       sync.Mutex
+	codeHash [sha256.Size]byte
+	expires  time.Time
+	consumed bool
+}
+
+// recovery is the active console, nil outside recovery mode.
+var (
+	recoveryMu sync.RWMutex
+	recovery   *RecoveryConsole
+)
+
+// StartRecovery generates a new recovery code valid for RecoveryCodeTTL
+// and enters recovery mode. The code is returned once, to be printed to
+// the host's logs; it is never stored.
+func StartRecovery(now time.Time) (string, error) {
+	console, code, err := newRecoveryConsole(now)
+	if err != nil {
+		return "", err
+	}
+
+	recoveryMu.Lock()
+	recovery = console
+	recoveryMu.Unlock()
+	return code, nil
+}
+
+func newRecoveryConsole(now time.Time) (*RecoveryConsole, string, error) {
+	b := make([]byte, 10)
+	if _, err := rand.Read(b); err != nil {
+		return nil, "", fmt.Errorf("failed to generate recovery code: %w", err)
+	}
+
+	raw := base32.StdEncoding.EncodeToString(b) // 16 characters
+	code := raw[0:4] + "-" + raw[4:8] + "-" + raw[8:12] + "-" + raw[12:16]
+	return &RecoveryConsole{codeHash: sha256.Sum256([]byte(raw)), expires: now.Add(RecoveryCodeTTL)}, code, nil
+}
+
+// ResetRecoveryForTesting leaves recovery mode without redeeming the code.
+func ResetRecoveryForTesting() {
+	recoveryMu.Lock()
+	recovery = nil
+	recoveryMu.Unlock()
+}
+
+// CurrentRecovery returns the active recovery console, or nil when the
+// app wasn't started in recovery mode.
+func CurrentRecovery() *RecoveryConsole {
+	recoveryMu.RLock()
+	defer recoveryMu.RUnlock()
+	return recovery
+}
+
+// InRecoveryMode reports whether the app is in recovery mode: started with
+// the recovery console and its code not yet used. The rest of the app is
+// read-only while this is true.
+func InRecoveryMode() bool {
+	console := CurrentRecovery()
+	return console != nil && !console.Consumed()
+}
+
+// Consumed reports whether the recovery code has been used.
+func (r *RecoveryConsole) Consumed() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.consumed
+}
+
+// Redeem checks the code and, if valid, runs apply (which sets the new
+// password). The code is consumed only when apply succeeds, and the lock
+// is held throughout so it can't be redeemed twice concurrently.
+func (r *RecoveryConsole) Redeem(code string, now time.Time, apply func() error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.consumed {
+		return ErrRecoveryConsumed
+	}
+	if now.After(r.expires) {
+		return ErrRecoveryExpired
+	}
+
+	normalized := strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(code))
+	hash := sha256.Sum256([]byte(normalized))
+	if subtle.ConstantTimeCompare(hash[:], r.codeHash[:]) != 1 {
+		return ErrRecoveryInvalid
+	}
+
+	if err := apply(); err != nil {
+		return err
+	}
+	r.consumed = true
+	return nil
+}
+
+// RecoverPassword redeems the active recovery code and sets a new password
+// for the existing user, signing out every session. Attempts are recorded
+// as auth_recovery (success) or auth_recovery_failed activities. Both
+// notify immediately: the first is listed as critical, the second like any
+// other failure.
+func RecoverPassword(code, password, remoteAddr string) error {
+	console := CurrentRecovery()
+	if console == nil {
+		return fmt.Errorf("recovery mode is not enabled")
+	}
+	if len(password) < MinPasswordLength {
+		return fmt.Errorf("password must be at least %d characters", MinPasswordLength)
+	}
+
+	revoked := 0
+	err := console.Redeem(code, time.Now(), func() error {
+		if err := setUserPassword(password); err != nil {
+			return err
+		}
+		// Whoever had the password may still be signed in. The password is
+		// changed either way, so a failure here is logged rather than
+		// leaving the console open
+		var err error
+		if revoked, err = revokeAllSessions(); err != nil {
+			slog.Error("failed to sign out sessions after recovery", "error", err)
+		}
+		// The password is already changed, so a lost marker only means a
+		// restart with the variable still set opens a fresh console
+		if err := markRecoveryConsumed(time.Now()); err != nil {
+			slog.Error("failed to record recovery code use", "error", err)
+		}
+		return nil
+	})
+	if err != nil {
+		go models.Activities.Insert(&models.Activity{
+			Type:        "auth_recovery_failed",
+			Repository:  "",
+			Description: fmt.Sprintf("Password recovery attempt from %s failed: %v", remoteAddr, err),
+			Author:      "System",
+			Timestamp:   time.Now(),
+		})
+		return err
+	}
+
+	go models.Activities.Insert(&models.Activity{
+		Type:        "auth_recovery",
+		Repository:  "",
+		Description: fmt.Sprintf("Password was reset with the recovery console from %s, signing out %d sessions", remoteAddr, revoked),
+		Author:      "System",
+		Timestamp:   time.Now(),
+	})
+	return nil
+}
+
+// revokeAllSessions signs out every session once the password is reset.
+// Replaced in tests.
+var revokeAllSessions = func() (int, error) { return revokeSessions("") }
+
+// setUserPassword replaces the single user's password hash. Replaced in tests.
+var setUserPassword = func(password string) error {
+	users, err := models.Auth.Users.Search("ORDER BY CreatedAt ASC LIMIT 1")
+	if err != nil || len(users) == 0 {
+		return fmt.Errorf("no user account exists - sign up instead")
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return fmt.Errorf("failed to hash password")
+	}
+
+	user := users[0]
+	user.PassHash = hash
+	if err := models.Auth.Users.Update(user); err != nil {
+		return fmt.Errorf("failed to save new password")
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package internal
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/The-Skyscape/devtools/pkg/testutils"
+)
+
+func TestRecoveryRedeem(t *testing.T) {
+	start := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	ok := func() error { return nil }
+
+	testCases := []struct {
+		name     string
+		code     func(code string) string
+		at       time.Time
+		expected error
+	}{
+		{"valid", func(c string) string { return c }, start.Add(time.Minute), nil},
+		{"lowercase without dashes", func(c string) string { return strings.ToLower(strings.ReplaceAll(c, "-", "")) }, start, nil},
+		{"at expiry", func(c string) string { return c }, start.Add(RecoveryCodeTTL), nil},
+		{"expired", func(c string) string { return c }, start.Add(RecoveryCodeTTL + time.Second), ErrRecoveryExpired},
+		{"wrong code", func(c string) string { return "AAAA-AAAA-AAAA-AAAA" }, start, ErrRecoveryInvalid},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			console, code, err := newRecoveryConsole(start)
+			testutils.AssertEqual(t, nil, err)
+			testutils.AssertEqual(t, 19, len(code))
+			testutils.AssertEqual(t, tc.expected, console.Redeem(tc.code(code), tc.at, ok))
+			testutils.AssertEqual(t, tc.expected == nil, console.Consumed())
+		})
+	}
+}
+
+func TestRecoveryCodeSingleUse(t *testing.T) {
+	start := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	console, code, _ := newRecoveryConsole(start)
+
+	applied := 0
+	apply := func() error { applied++; return nil }
+	testutils.AssertEqual(t, nil, console.Redeem(code, start, apply))
+	testutils.AssertEqual(t, ErrRecoveryConsumed, console.Redeem(code, start, apply))
+	testutils.AssertEqual(t, 1, applied)
+}
+
+func TestRecoveryCodeNotConsumedOnFailure(t *testing.T) {
+	start := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	console, code, _ := newRecoveryConsole(start)
+
+	failure := errors.New("failed to save new password")
+	testutils.AssertEqual(t, failure, console.Redeem(code, start, func() error { return failure }))
+	testutils.AssertEqual(t, false, console.Consumed())
+	testutils.AssertEqual(t, nil, console.Redeem(code, start, func() error { return nil }))
+}
+
+func TestRecoverPassword(t *testing.T) {
+	var saved string
+	var marked, revokes int
+	original, originalMark, originalRevoke := setUserPassword, markRecoveryConsumed, revokeAllSessions
+	setUserPassword = func(password string) error { saved = password; return nil }
+	markRecoveryConsumed = func(time.Time) error { marked++; return nil }
+	revokeAllSessions = func() (int, error) { revokes++; return 2, nil }
+	t.Cleanup(func() {
+		setUserPassword, markRecoveryConsumed, revokeAllSessions = original, originalMark, originalRevoke
+		ResetRecoveryForTesting()
+	})
+
+	testutils.AssertEqual(t, "recovery mode is not enabled", RecoverPassword("x", "new-password", "test").Error())
+
+	code, err := StartRecovery(time.Now())
+	testutils.AssertEqual(t, nil, err)
+	testutils.AssertEqual(t, true, InRecoveryMode())
+
+	testutils.AssertEqual(t, "password must be at least 8 characters", RecoverPassword(code, "short", "test").Error())
+	testutils.AssertEqual(t, ErrRecoveryInvalid, RecoverPassword("AAAA-AAAA-AAAA-AAAA", "new-password", "test"))
+	testutils.AssertEqual(t, true, InRecoveryMode())
+	testutils.AssertEqual(t, 0, marked)
+	testutils.AssertEqual(t, 0, revokes)
+
+	testutils.AssertEqual(t, nil, RecoverPassword(code, "new-password", "test"))
+	testutils.AssertEqual(t, "new-password", saved)
+	testutils.AssertEqual(t, false, InRecoveryMode())
+	testutils.AssertEqual(t, 1, marked)
+	// Every session is signed out with the reset
+	testutils.AssertEqual(t, 1, revokes)
+
+	// The console refuses to run again once its code is used
+	testutils.AssertEqual(t, ErrRecoveryConsumed, RecoverPassword(code, "another-password", "test"))
+	testutils.AssertEqual(t, "new-password", saved)
+	testutils.AssertEqual(t, 1, marked)
+	testutils.AssertEqual(t, 1, revokes)
+}
@@ -0,0 +1,196 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"workbench/models"
+)
+
+// ErrRemoteNotFound is matched with errors.Is when a repository has no
+// remote of the given name.
+var ErrRemoteNotFound = errors.New("remote not found")
+
+// Remote is a git remote of a repository. Credentials in its URLs are
+// masked.
+type Remote struct {
+	Name     string
+	FetchURL string
+	PushURL  string // Only set when it differs from FetchURL
+}
+
+// validRemoteName matches the remote names the workbench will create:
+// letters, digits, dots, dashes, and underscores.
+var validRemoteName = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9._-]*$`)
+
+// remoteExec runs git commands in the coder container. Replaced in tests.
+var remoteExec = operationExec
+
+// ListRemotes returns a repository's remotes as `git remote -v` lists
+// them, origin first.
+func ListRemotes(repoName string) ([]*Remote, error) {
+	repo, err := models.Repositories.Find("WHERE Name = ?", repoName)
+	if err != nil {
+		return nil, repoNotFound(repoName)
+	}
+
+	output, err := remoteExec(fmt.Sprintf("cd %s && git remote -v 2>&1", shellQuote(repo.LocalPath)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list remotes: %s", strings.TrimSpace(output))
+	}
+	return parseRemotes(output), nil
+}
+
+// parseRemotes parses `git remote -v` output, lines like
+// "origin\thttps://github.com/acme/api.git (fetch)".
+func parseRemotes(output string) []*Remote {
+	remotes := []*Remote{}
+	byName := map[string]*Remote{}
+	for _, line := range strings.Split(output, "\n") {
+		name, rest, ok := strings.Cut(strings.TrimSpace(line), "\t")
+		if !ok {
+			continue
+		}
+		url, kind, _ := strings.Cut(rest, " ")
+		url = maskURLCredentials(url)
+		remote := byName[name]
+		if remote == nil {
+			remote = &Remote{Name: name}
+			byName[name] = remote
+			if name == "origin" {
+				remotes = append([]*Remote{remote}, remotes...)
+			} else {
+				remotes = append(remotes, remote)
+			}
+		}
+		switch kind {
+		case "(fetch)":
+			remote.FetchURL = url
+		case "(push)":
+			remote.PushURL = url
+		}
+	}
+	for _, remote := range remotes {
+		if remote.PushURL == remote.FetchURL {
+			remote.PushURL = ""
+		}
+	}
+	return remotes
+}
+
+// SetRemoteURL points a repository's remote at url, adding the remote
+// when it doesn't exist yet. The URL is checked with the same rules as a
+// clone. Changing origin also updates the stored repository URL and is
+// recorded as a repo_remote_changed activity.
+func SetRemoteURL(repoName, remote, url string) error {
+	repo, err := models.Repositories.Find("WHERE Name = ?", repoName)
+	if err != nil {
+		return repoNotFound(repoName)
+	}
+	remote, url = strings.TrimSpace(remote), strings.TrimSpace(url)
+	if err := checkRemoteName(remote); err != nil {
+		return err
+	}
+	if err := ValidateRemoteURL(url); err != nil {
+		return &repoError{ErrInvalidInput, err.Error()}
+	}
+
+	dir := shellQuote(repo.LocalPath)
+	output, err := remoteExec(fmt.Sprintf("cd %s && git remote get-url %s 2>&1", dir, shellQuote(remote)))
+	oldURL := strings.TrimSpace(output)
+	command := "set-url"
+	if err != nil {
+		if !isUnknownRemote(output) {
+			return fmt.Errorf("failed to read remote '%s': %s", remote, oldURL)
+		}
+		oldURL, command = "", "add"
+	}
+	if output, err := remoteExec(fmt.Sprintf("cd %s && git remote %s %s %s 2>&1", dir, command, shellQuote(remote), shellQuote(url))); err != nil {
+		return fmt.Errorf("failed to set remote '%s': %s", remote, strings.TrimSpace(output))
+	}
+
+	if remote == "origin" && oldURL != url {
+		repo.URL, repo.Host = url, RepoHost(url)
+		if err := models.Repositories.Update(repo); err != nil {
+			return fmt.Errorf("origin was changed but saving the new URL failed: %w", err)
+		}
+		go models.Activities.Insert(originChangedActivity(repoName, oldURL, url))
+	}
+	return nil
+}
+
+// RemoveRemote deletes a repository's remote. Removing origin leaves the
+// repository local only, with nothing to pull from, until origin is set
+// again.
+func RemoveRemote(repoName, remote string) error {
+	repo, err := models.Repositories.Find("WHERE Name = ?", repoName)
+	if err != nil {
+		return repoNotFound(repoName)
+	}
+	remote = strings.TrimSpace(remote)
+	if err := checkRemoteName(remote); err != nil {
+		return err
+	}
+
+	output, err := remoteExec(fmt.Sprintf("cd %s && git remote remove %s 2>&1", shellQuote(repo.LocalPath), shellQuote(remote)))
+	if err != nil {
+		if isUnknownRemote(output) {
+			return &repoError{ErrRemoteNotFound, fmt.Sprintf("%s has no remote named '%s'", repoName, remote)}
+		}
+		return fmt.Errorf("failed to remove remote '%s': %s", remote, strings.TrimSpace(output))
+	}
+
+	if remote == "origin" && repo.URL != "" {
+		oldURL := repo.URL
+		repo.URL, repo.Host = "", ""
+		if err := models.Repositories.Update(repo); err != nil {
+			return fmt.Errorf("origin was removed but saving the repository failed: %w", err)
+		}
+		go models.Activities.Insert(originChangedActivity(repoName, oldURL, ""))
+	}
+	return nil
+}
+
+// originChangedActivity builds the repo_remote_changed activity for a
+// new or removed origin, with both URLs, credentials masked, in its
+// metadata.
+func originChangedActivity(repoName, oldURL, newURL string) *models.Activity {
+	oldURL, newURL = maskURLCredentials(oldURL), maskURLCredentials(newURL)
+	description := fmt.Sprintf("Changed the origin of %s to %s", repoName, newURL)
+	switch {
+	case newURL == "":
+		description = fmt.Sprintf("Removed the origin of %s", repoName)
+	case oldURL == "":
+		description = fmt.Sprintf("Set the origin of %s to %s", repoName, newURL)
+	}
+	metadata, _ := json.Marshal(map[string]string{"old_url": oldURL, "new_url": newURL})
+	return &models.Activity{
+		Type:        "repo_remote_changed",
+		Repository:  repoName,
+		Description: description,
+		Author:      "System",
+		Timestamp:   time.Now(),
+		Metadata:    string(metadata),
+	}
+}
+
+// checkRemoteName rejects remote names git would refuse or that could be
+// mistaken for an option.
+func checkRemoteName(remote string) error {
+	if remote == "" {
+		return &repoError{ErrInvalidInput, "remote name is required"}
+	}
+	if !validRemoteName.MatchString(remote) || strings.HasSuffix(remote, ".lock") || strings.Contains(remote, "..") {
+		return &repoError{ErrInvalidInput, fmt.Sprintf("'%s' isn't a valid remote name - use letters, digits, dots, dashes, and underscores, e.g. upstream", remote)}
+	}
+	return nil
+}
+
+// isUnknownRemote reports whether git output says a remote doesn't
+// exist.
+func isUnknownRemote(output string) bool {
+	return strings.Contains(output, "No such remote") || strings.Contains(output, "does not appear to be a git repository")
+}
@@ -0,0 +1,195 @@
+package internal
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"unicode"
+	"workbench/models"
+)
+
+// MaxRepoGroupLength caps a repository group's name, in characters.
+const MaxRepoGroupLength = 64
+
+// RepoSection is a part of the dashboard's repository list: the pinned
+// repositories, one group, or the ungrouped ones.
+type RepoSection struct {
+	Group     string // Empty for the pinned and ungrouped sections
+	Pinned    bool
+	Collapsed bool // Folded on the dashboard, from the repo_group_collapsed setting
+	Repos     []*models.Repository
+}
+
+// Ungrouped reports whether the section holds repositories with no group.
+func (s *RepoSection) Ungrouped() bool {
+	return !s.Pinned && s.Group == ""
+}
+
+// Seams for repository groups. Replaced in tests.
+var (
+	findRepo     = func(name string) (*models.Repository, error) { return models.Repositories.Find("WHERE Name = ?", name) }
+	groupMembers = func(group string) ([]*models.Repository, error) {
+		return models.Repositories.Search("WHERE GroupName = ? ORDER BY Name ASC", group)
+	}
+	groupCollapsed = func(group string) bool {
+		value, _ := models.GetSetting(repoGroupCollapsedKey(group))
+		return value == "true"
+	}
+)
+
+// SectionRepositories orders repositories for the dashboard: the pinned
+// ones first, then one section per group sorted by name, then the
+// ungrouped ones. Repositories keep their order within a section, and
+// sections with no repositories are left out.
+func SectionRepositories(repos []*models.Repository) []*RepoSection {
+	pinned := &RepoSection{Pinned: true}
+	ungrouped := &RepoSection{}
+	groups := map[string]*RepoSection{}
+	for _, repo := range repos {
+		switch {
+		case repo.Pinned:
+			pinned.Repos = append(pinned.Repos, repo)
+		case repo.GroupName == "":
+			ungrouped.Repos = append(ungrouped.Repos, repo)
+		default:
+			if groups[repo.GroupName] == nil {
+				groups[repo.GroupName] = &RepoSection{Group: repo.GroupName, Collapsed: groupCollapsed(repo.GroupName)}
+			}
+			groups[repo.GroupName].Repos = append(groups[repo.GroupName].Repos, repo)
+		}
+	}
+
+	var sections []*RepoSection
+	if len(pinned.Repos) > 0 {
+		sections = append(sections, pinned)
+	}
+	for _, group := range RepositoryGroups(repos) {
+		if section := groups[group]; section != nil {
+			sections = append(sections, section)
+		}
+	}
+	if len(ungrouped.Repos) > 0 {
+		sections = append(sections, ungrouped)
+	}
+	return sections
+}
+
+// RepositoryGroups returns the distinct groups of the repositories,
+// sorted case-insensitively. A group exists while a repository is in it.
+func RepositoryGroups(repos []*models.Repository) []string {
+	var groups []string
+	for _, repo := range repos {
+		if repo.GroupName != "" && !slices.Contains(groups, repo.GroupName) {
+			groups = append(groups, repo.GroupName)
+		}
+	}
+	slices.SortFunc(groups, func(a, b string) int { return strings.Compare(strings.ToLower(a), strings.ToLower(b)) })
+	return groups
+}
+
+// ValidateRepoGroup trims a group name and checks its length and
+// characters. An empty name means no group.
+func ValidateRepoGroup(group string) (string, error) {
+	group = strings.TrimSpace(group)
+	if len([]rune(group)) > MaxRepoGroupLength {
+		return "", &repoError{ErrInvalidInput, fmt.Sprintf("group names can be at most %d characters", MaxRepoGroupLength)}
+	}
+	if strings.IndexFunc(group, unicode.IsControl) >= 0 {
+		return "", &repoError{ErrInvalidInput, "group names can't contain control characters"}
+	}
+	return group, nil
+}
+
+// SetRepositoryGroup moves a repository into a group, or out of every
+// group when group is empty.
+func SetRepositoryGroup(name, group string) error {
+	group, err := ValidateRepoGroup(group)
+	if err != nil {
+		return err
+	}
+	repo, err := findRepo(name)
+	if err != nil {
+		return repoNotFound(name)
+	}
+	repo.GroupName = group
+	if err := updateRepo(repo); err != nil {
+		return fmt.Errorf("failed to save repository: %w", err)
+	}
+	return nil
+}
+
+// SetRepositoryPinned pins a repository to the top of the dashboard, or
+// unpins it.
+func SetRepositoryPinned(name string, pinned bool) error {
+	repo, err := findRepo(name)
+	if err != nil {
+		return repoNotFound(name)
+	}
+	repo.Pinned = pinned
+	if err := updateRepo(repo); err != nil {
+		return fmt.Errorf("failed to save repository: %w", err)
+	}
+	return nil
+}
+
+// RenameRepositoryGroup moves every repository in a group to a new name,
+// merging into the new group if it already exists. If a repository can't
+// be saved, the ones already moved are put back so the group isn't left
+// split. The collapsed state moves with the group.
+func RenameRepositoryGroup(from, to string) error {
+	to, err := ValidateRepoGroup(to)
+	if err != nil {
+		return err
+	}
+	if to == "" {
+		return &repoError{ErrInvalidInput, "the new group name can't be empty"}
+	}
+	members, err := groupMembers(from)
+	if err != nil {
+		return fmt.Errorf("failed to load group '%s': %w", from, err)
+	}
+	if len(members) == 0 {
+		return &repoError{ErrRepoNotFound, fmt.Sprintf("group '%s' not found", from)}
+	}
+	if from == to {
+		return nil
+	}
+
+	for i, repo := range members {
+		repo.GroupName = to
+		if err := updateRepo(repo); err != nil {
+			for _, moved := range members[:i] {
+				moved.GroupName = from
+				if err := updateRepo(moved); err != nil {
+					slog.Error("failed to move repository back to group", "name", moved.Name, "group", from, "error", err)
+				}
+			}
+			return fmt.Errorf("failed to rename group '%s': %w", from, err)
+		}
+	}
+
+	if groupCollapsed(from) {
+		if err := SetRepoGroupCollapsed(to, true); err != nil {
+			slog.Error("failed to keep group collapsed", "group", to, "error", err)
+		}
+	}
+	return nil
+}
+
+// SetRepoGroupCollapsed remembers whether a group is folded on the
+// dashboard. Open groups report their state each time the list loads, so
+// an unchanged state isn't saved again.
+func SetRepoGroupCollapsed(group string, collapsed bool) error {
+	if groupCollapsed(group) == collapsed {
+		return nil
+	}
+	if _, err := models.SetSetting(repoGroupCollapsedKey(group), fmt.Sprint(collapsed), "repo_group"); err != nil {
+		return fmt.Errorf("failed to save group state: %w", err)
+	}
+	return nil
+}
+
+func repoGroupCollapsedKey(group string) string {
+	return "repo_group_collapsed:" + group
+}
@@ -0,0 +1,148 @@
+package internal
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"workbench/models"
+
+	"github.com/The-Skyscape/devtools/pkg/testutils"
+)
+
+// fakeRepoGroups serves repositories from repos and records saves until
+// the test ends. Saving the repository named failOn fails. Groups named in
+// collapsed are folded.
+func fakeRepoGroups(t *testing.T, repos []*models.Repository, failOn string, collapsed ...string) *[]string {
+	find, members, folded, update := findRepo, groupMembers, groupCollapsed, updateRepo
+	t.Cleanup(func() { findRepo, groupMembers, groupCollapsed, updateRepo = find, members, folded, update })
+
+	var saved []string
+	findRepo = func(name string) (*models.Repository, error) {
+		for _, repo := range repos {
+			if repo.Name == name {
+				return repo, nil
+			}
+		}
+		return nil, errors.New("not found")
+	}
+	groupMembers = func(group string) ([]*models.Repository, error) {
+		var found []*models.Repository
+		for _, repo := range repos {
+			if repo.GroupName == group {
+				found = append(found, repo)
+			}
+		}
+		return found, nil
+	}
+	groupCollapsed = func(group string) bool {
+		for _, c := range collapsed {
+			if c == group {
+				return true
+			}
+		}
+		return false
+	}
+	updateRepo = func(repo *models.Repository) error {
+		if repo.Name == failOn {
+			return errors.New("database is locked")
+		}
+		saved = append(saved, repo.Name+"="+repo.GroupName)
+		return nil
+	}
+	return &saved
+}
+
+func sectionNames(sections []*RepoSection) string {
+	var parts []string
+	for _, s := range sections {
+		label := s.Group
+		switch {
+		case s.Pinned:
+			label = "pinned"
+		case s.Ungrouped():
+			label = "ungrouped"
+		}
+		var names []string
+		for _, repo := range s.Repos {
+			names = append(names, repo.Name)
+		}
+		parts = append(parts, label+":"+strings.Join(names, ","))
+	}
+	return strings.Join(parts, " ")
+}
+
+func TestSectionRepositories(t *testing.T) {
+	fakeRepoGroups(t, nil, "", "work")
+	repos := []*models.Repository{
+		{Name: "api", GroupName: "work"},
+		{Name: "blog"},
+		{Name: "dotfiles", Pinned: true, GroupName: "personal"},
+		{Name: "infra", GroupName: "Ops"},
+		{Name: "site", GroupName: "personal"},
+		{Name: "web", GroupName: "work"},
+	}
+
+	sections := SectionRepositories(repos)
+	testutils.AssertEqual(t, "pinned:dotfiles Ops:infra personal:site work:api,web ungrouped:blog", sectionNames(sections))
+	testutils.AssertEqual(t, true, sections[3].Collapsed)
+	testutils.AssertEqual(t, false, sections[2].Collapsed)
+
+	testutils.AssertEqual(t, "", sectionNames(SectionRepositories(nil)))
+	testutils.AssertEqual(t, "ungrouped:blog", sectionNames(SectionRepositories(repos[1:2])))
+}
+
+func TestRepositoryGroups(t *testing.T) {
+	groups := RepositoryGroups([]*models.Repository{
+		{Name: "a", GroupName: "work"}, {Name: "b"}, {Name: "c", GroupName: "Ops"}, {Name: "d", GroupName: "work"},
+	})
+	testutils.AssertEqual(t, 2, len(groups))
+	testutils.AssertEqual(t, "Ops", groups[0])
+	testutils.AssertEqual(t, "work", groups[1])
+}
+
+func TestValidateRepoGroup(t *testing.T) {
+	group, err := ValidateRepoGroup("  Client work ")
+	testutils.AssertEqual(t, nil, err)
+	testutils.AssertEqual(t, "Client work", group)
+
+	_, err = ValidateRepoGroup(strings.Repeat("g", MaxRepoGroupLength+1))
+	testutils.AssertEqual(t, true, errors.Is(err, ErrInvalidInput))
+	_, err = ValidateRepoGroup("work\nhome")
+	testutils.AssertEqual(t, true, errors.Is(err, ErrInvalidInput))
+}
+
+func TestSetRepositoryGroupAndPin(t *testing.T) {
+	repos := []*models.Repository{{Name: "api"}}
+	saved := fakeRepoGroups(t, repos, "")
+
+	testutils.AssertEqual(t, nil, SetRepositoryGroup("api", " work "))
+	testutils.AssertEqual(t, "work", repos[0].GroupName)
+	testutils.AssertEqual(t, nil, SetRepositoryPinned("api", true))
+	testutils.AssertEqual(t, true, repos[0].Pinned)
+	testutils.AssertEqual(t, 2, len(*saved))
+
+	testutils.AssertEqual(t, true, errors.Is(SetRepositoryGroup("gone", "work"), ErrRepoNotFound))
+	testutils.AssertEqual(t, true, errors.Is(SetRepositoryPinned("gone", true), ErrRepoNotFound))
+}
+
+func TestRenameRepositoryGroup(t *testing.T) {
+	repos := []*models.Repository{{Name: "api", GroupName: "work"}, {Name: "blog"}, {Name: "web", GroupName: "work"}}
+	saved := fakeRepoGroups(t, repos, "")
+
+	testutils.AssertEqual(t, nil, RenameRepositoryGroup("work", "Client"))
+	testutils.AssertEqual(t, "api=Client,web=Client", strings.Join(*saved, ","))
+	testutils.AssertEqual(t, "", repos[1].GroupName)
+
+	testutils.AssertEqual(t, true, errors.Is(RenameRepositoryGroup("work", "Other"), ErrRepoNotFound))
+	testutils.AssertEqual(t, true, errors.Is(RenameRepositoryGroup("Client", " "), ErrInvalidInput))
+}
+
+func TestRenameRepositoryGroupRollsBack(t *testing.T) {
+	repos := []*models.Repository{{Name: "api", GroupName: "work"}, {Name: "web", GroupName: "work"}}
+	saved := fakeRepoGroups(t, repos, "web")
+
+	err := RenameRepositoryGroup("work", "Client")
+	testutils.AssertEqual(t, true, err != nil)
+	testutils.AssertEqual(t, "api=Client,api=work", strings.Join(*saved, ","))
+	testutils.AssertEqual(t, "work", repos[0].GroupName)
+}
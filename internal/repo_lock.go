@@ -0,0 +1,174 @@
+package internal
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"workbench/models"
+)
+
+// ErrRepoLocked is matched with errors.Is for operations refused because
+// the repository is locked.
+var ErrRepoLocked = errors.New("repository is locked")
+
+// deleteTokenTTL is how long a deletion can be confirmed after its summary
+// was shown.
+const deleteTokenTTL = 2 * time.Minute
+
+// deleteTokenKey signs deletion tokens. It's made on start, so restarting
+// the workbench expires every token.
+var deleteTokenKey = func() []byte {
+	key := make([]byte, 32)
+	rand.Read(key)
+	return key
+}()
+
+// Seams for locking and deleting repositories. Replaced in tests.
+var (
+	repoStatus   = readRepoStatus // Status read for the deletion summary
+	lockActivity = func(activity *models.Activity) { go models.Activities.Insert(activity) }
+)
+
+// checkUnlocked refuses destructive operations on a locked repository.
+func checkUnlocked(repo *models.Repository) error {
+	if !repo.Locked {
+		return nil
+	}
+	return &AppError{
+		Code:        CodeLocked,
+		UserMessage: fmt.Sprintf("repository is locked - unlock %s first", repo.Name),
+		Err:         ErrRepoLocked,
+	}
+}
+
+// SetRepositoryLocked locks a repository against deletion, renaming, and
+// destructive git actions, or unlocks it.
+func SetRepositoryLocked(name string, locked bool) error {
+	repo, err := findRepo(name)
+	if err != nil {
+		return repoNotFound(name)
+	}
+	if repo.Locked == locked {
+		return nil
+	}
+	repo.Locked = locked
+	if err := updateRepo(repo); err != nil {
+		return fmt.Errorf("failed to save repository: %w", err)
+	}
+
+	activity := &models.Activity{
+		Type:        "repo_lock",
+		Repository:  name,
+		Description: fmt.Sprintf("Locked repository %s", name),
+		Author:      "System",
+		Timestamp:   time.Now(),
+	}
+	if !locked {
+		activity.Type, activity.Description = "repo_unlock", fmt.Sprintf("Unlocked repository %s", name)
+	}
+	lockActivity(activity)
+	return nil
+}
+
+// DeletionSummary is what deleting a repository would lose, with the
+// token that confirms the deletion.
+type DeletionSummary struct {
+	Name      string
+	Missing   bool // Nothing is left on disk to lose
+	Changed   int  // Uncommitted paths
+	Unpushed  int  // Commits on the current branch its upstream doesn't have
+	Upstream  string
+	Token     string
+	ExpiresAt time.Time
+}
+
+// UnsavedWork reports whether the deletion would lose work that exists
+// nowhere else.
+func (s *DeletionSummary) UnsavedWork() bool {
+	return s.Changed > 0 || s.Unpushed > 0
+}
+
+// PrepareDeletion summarizes what deleting a repository would lose and
+// signs a token that confirms it within two minutes. Locked repositories
+// and clones still running are refused.
+func PrepareDeletion(name string) (*DeletionSummary, error) {
+	repo, err := findRepo(name)
+	if err != nil {
+		return nil, repoNotFound(name)
+	}
+	if err := checkUnlocked(repo); err != nil {
+		return nil, err
+	}
+	if repo.IsCloning() {
+		return nil, fmt.Errorf("'%s' is still cloning - wait for it to finish before removing it", name)
+	}
+
+	summary := &DeletionSummary{Name: repo.Name, Missing: repo.Missing || repo.IsCloneFailed()}
+	if !summary.Missing {
+		status, err := repoStatus(repo)
+		if err != nil {
+			return nil, err
+		}
+		summary.Missing = status.Missing
+		summary.Changed, summary.Unpushed, summary.Upstream = status.Changed, status.Ahead, status.Upstream
+	}
+	summary.ExpiresAt = time.Now().Add(deleteTokenTTL)
+	summary.Token = deleteToken(repo, summary.ExpiresAt)
+	return summary, nil
+}
+
+// ConfirmDeletion checks a token from PrepareDeletion still confirms
+// deleting the repository.
+func ConfirmDeletion(name, token string) error {
+	repo, err := findRepo(name)
+	if err != nil {
+		return repoNotFound(name)
+	}
+	expiry, _, ok := strings.Cut(token, ".")
+	unix, err := strconv.ParseInt(expiry, 10, 64)
+	if !ok || err != nil || !hmac.Equal([]byte(token), []byte(deleteToken(repo, time.Unix(unix, 0)))) {
+		return &repoError{ErrInvalidInput, "the deletion wasn't confirmed - remove the repository again"}
+	}
+	if time.Now().After(time.Unix(unix, 0)) {
+		return &repoError{ErrInvalidInput, "the confirmation expired - remove the repository again to see what would be lost"}
+	}
+	return nil
+}
+
+// deleteToken signs a repository's ID and name with an expiry, so a token
+// can't confirm deleting a different repository, or one recreated under
+// the same name.
+func deleteToken(repo *models.Repository, expires time.Time) string {
+	expiry := strconv.FormatInt(expires.Unix(), 10)
+	mac := hmac.New(sha256.New, deleteTokenKey)
+	fmt.Fprintf(mac, "%s\x00%s\x00%s", repo.ID, repo.Name, expiry)
+	return expiry + "." + hex.EncodeToString(mac.Sum(nil))
+}
+
+// deletionMetadata is the Metadata of a repo_delete activity: the
+// archive's path when one was kept, and whether the repository had
+// uncommitted changes or unpushed commits. A nil status means it couldn't
+// be read.
+func deletionMetadata(archivePath string, status *RepoStatus) string {
+	metadata := map[string]any{}
+	if archivePath != "" {
+		metadata["archive"] = archivePath
+	}
+	if status != nil && !status.Missing {
+		metadata["unpushed_work"] = status.Changed > 0 || status.Ahead > 0
+		metadata["uncommitted"] = status.Changed
+		metadata["unpushed"] = status.Ahead
+	}
+	if len(metadata) == 0 {
+		return ""
+	}
+	data, _ := json.Marshal(metadata)
+	return string(data)
+}
@@ -0,0 +1,132 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+	"workbench/models"
+
+	"github.com/The-Skyscape/devtools/pkg/testutils"
+)
+
+// fakeRepoLock serves repo for lookups and status for its status,
+// returning the saves and activities recorded.
+func fakeRepoLock(t *testing.T, repo *models.Repository, status *RepoStatus) (*int, *[]string) {
+	find, update, readStatus, activity := findRepo, updateRepo, repoStatus, lockActivity
+	t.Cleanup(func() { findRepo, updateRepo, repoStatus, lockActivity = find, update, readStatus, activity })
+
+	var saves int
+	var activities []string
+	findRepo = func(name string) (*models.Repository, error) {
+		if name != repo.Name {
+			return nil, errors.New("not found")
+		}
+		return repo, nil
+	}
+	updateRepo = func(*models.Repository) error {
+		saves++
+		return nil
+	}
+	repoStatus = func(*models.Repository) (*RepoStatus, error) {
+		if status == nil {
+			return nil, errors.New("coder container is not running")
+		}
+		return status, nil
+	}
+	lockActivity = func(a *models.Activity) { activities = append(activities, a.Type) }
+	return &saves, &activities
+}
+
+func TestSetRepositoryLocked(t *testing.T) {
+	repo := &models.Repository{Name: "api"}
+	saves, activities := fakeRepoLock(t, repo, nil)
+
+	testutils.AssertEqual(t, nil, SetRepositoryLocked("api", true))
+	testutils.AssertEqual(t, true, repo.Locked)
+	// Locking again changes nothing
+	testutils.AssertEqual(t, nil, SetRepositoryLocked("api", true))
+	testutils.AssertEqual(t, nil, SetRepositoryLocked("api", false))
+	testutils.AssertEqual(t, false, repo.Locked)
+	testutils.AssertEqual(t, 2, *saves)
+	testutils.AssertEqual(t, []string{"repo_lock", "repo_unlock"}, *activities)
+
+	err := SetRepositoryLocked("missing", true)
+	testutils.AssertEqual(t, true, errors.Is(err, ErrRepoNotFound))
+}
+
+func TestLockedRepositoryRefusesDestructiveOperations(t *testing.T) {
+	repo := &models.Repository{Name: "api", LocalPath: "/home/coder/repos/api", Locked: true}
+	fakeRepoLock(t, repo, &RepoStatus{})
+	commands := fakeRename(t, "", "", nil)
+
+	for name, err := range map[string]error{
+		"delete":     DeleteRepository(context.Background(), "api", false),
+		"rename":     RenameRepository("api", "api-server"),
+		"prepare":    func() error { _, err := PrepareDeletion("api"); return err }(),
+		"drop stash": DropStash("api", 0),
+	} {
+		t.Run(name, func(t *testing.T) {
+			testutils.AssertEqual(t, true, errors.Is(err, ErrRepoLocked))
+			testutils.AssertEqual(t, CodeLocked, ErrorCode(err))
+			testutils.AssertEqual(t, "repository is locked - unlock api first", err.Error())
+		})
+	}
+	testutils.AssertEqual(t, 0, len(*commands))
+	testutils.AssertEqual(t, "api", repo.Name)
+}
+
+func TestPrepareDeletion(t *testing.T) {
+	repo := &models.Repository{Name: "api", LocalPath: "/home/coder/repos/api"}
+	fakeRepoLock(t, repo, &RepoStatus{Branch: "main", Upstream: "origin/main", Ahead: 2, Changed: 3})
+
+	summary, err := PrepareDeletion("api")
+	testutils.AssertEqual(t, nil, err)
+	testutils.AssertEqual(t, 3, summary.Changed)
+	testutils.AssertEqual(t, 2, summary.Unpushed)
+	testutils.AssertEqual(t, "origin/main", summary.Upstream)
+	testutils.AssertEqual(t, true, summary.UnsavedWork())
+	testutils.AssertEqual(t, true, time.Until(summary.ExpiresAt) <= deleteTokenTTL)
+	testutils.AssertEqual(t, nil, ConfirmDeletion("api", summary.Token))
+
+	// Missing repositories have nothing to lose and aren't read
+	repo.Missing = true
+	fakeRepoLock(t, repo, nil)
+	summary, err = PrepareDeletion("api")
+	testutils.AssertEqual(t, nil, err)
+	testutils.AssertEqual(t, true, summary.Missing)
+	testutils.AssertEqual(t, false, summary.UnsavedWork())
+}
+
+func TestConfirmDeletion(t *testing.T) {
+	repo := &models.Repository{Name: "api"}
+	repo.ID = "1"
+	fakeRepoLock(t, repo, &RepoStatus{})
+
+	expired := deleteToken(repo, time.Now().Add(-time.Second))
+	err := ConfirmDeletion("api", expired)
+	testutils.AssertEqual(t, true, errors.Is(err, ErrInvalidInput))
+	testutils.AssertEqual(t, true, strings.Contains(err.Error(), "expired"))
+
+	// A token can't be stretched, or reused for a repository recreated under the same name
+	valid := deleteToken(repo, time.Now().Add(time.Minute))
+	expiry, signature, _ := strings.Cut(valid, ".")
+	stretched := fmt.Sprintf("%s9.%s", expiry, signature)
+	for _, token := range []string{"", "garbage", stretched} {
+		testutils.AssertEqual(t, true, errors.Is(ConfirmDeletion("api", token), ErrInvalidInput))
+	}
+	testutils.AssertEqual(t, nil, ConfirmDeletion("api", valid))
+	repo.ID = "2"
+	testutils.AssertEqual(t, true, errors.Is(ConfirmDeletion("api", valid), ErrInvalidInput))
+}
+
+func TestDeletionMetadata(t *testing.T) {
+	testutils.AssertEqual(t, `{"archive":"/trash/api.tar.gz","uncommitted":0,"unpushed":1,"unpushed_work":true}`,
+		deletionMetadata("/trash/api.tar.gz", &RepoStatus{Ahead: 1}))
+	testutils.AssertEqual(t, `{"uncommitted":0,"unpushed":0,"unpushed_work":false}`, deletionMetadata("", &RepoStatus{}))
+	// Unread or missing, nothing is claimed either way
+	testutils.AssertEqual(t, "", deletionMetadata("", nil))
+	testutils.AssertEqual(t, "", deletionMetadata("", &RepoStatus{Missing: true}))
+}
@@ -0,0 +1,99 @@
+package internal
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+	"unicode"
+	"workbench/internal/providers"
+)
+
+// MaxRepoDescriptionLength is the longest description a repository can
+// have, in characters.
+const MaxRepoDescriptionLength = 500
+
+// repoMetadataTTL is how long metadata read from a provider is kept
+// before it's read again.
+const repoMetadataTTL = 24 * time.Hour
+
+// repoMetadata reads a repository's metadata from its provider. Replaced
+// in tests.
+var repoMetadata = providers.RepositoryMetadata
+
+// ValidateRepoDescription trims a description and folds it onto one line,
+// returning an error if it's too long.
+func ValidateRepoDescription(description string) (string, error) {
+	description = strings.Join(strings.FieldsFunc(description, func(r rune) bool {
+		return unicode.IsSpace(r) || unicode.IsControl(r)
+	}), " ")
+	if len([]rune(description)) > MaxRepoDescriptionLength {
+		return "", &repoError{ErrInvalidInput, fmt.Sprintf("descriptions can be at most %d characters", MaxRepoDescriptionLength)}
+	}
+	return description, nil
+}
+
+// RepoEdit is a change to the metadata shown on a repository's card. Nil
+// fields are left as they are.
+type RepoEdit struct {
+	Description *string // Empty clears it
+}
+
+// EditRepository applies edit to a repository, checking every field
+// before saving any.
+func EditRepository(name string, edit RepoEdit) error {
+	var description string
+	if edit.Description != nil {
+		var err error
+		if description, err = ValidateRepoDescription(*edit.Description); err != nil {
+			return err
+		}
+	}
+	repo, err := findRepo(name)
+	if err != nil {
+		return repoNotFound(name)
+	}
+	if edit.Description != nil {
+		repo.Description = description
+	}
+	if err := updateRepo(repo); err != nil {
+		return fmt.Errorf("failed to save repository: %w", err)
+	}
+	return nil
+}
+
+// FetchRepositoryMetadata fills in a repository's default branch, primary
+// language, and star count from its GitHub or GitLab API. Metadata read
+// within the last day is reused unless force is set. The provider's
+// description only replaces an empty one, or any when forced, since that
+// is the user asking for it. Repositories on other hosts are left alone,
+// and when the provider can't be reached the failure is only logged, so
+// the card keeps what it had.
+func FetchRepositoryMetadata(name string, force bool) error {
+	repo, err := findRepo(name)
+	if err != nil {
+		return repoNotFound(name)
+	}
+	if repo.IsLocal() || (!force && time.Since(repo.LastFetchedMeta) < repoMetadataTTL) {
+		return nil
+	}
+
+	meta, err := repoMetadata(repo.URL)
+	if err != nil {
+		slog.Warn("failed to fetch repository metadata", "name", name, "error", err)
+		return nil
+	}
+	if meta == nil {
+		return nil
+	}
+
+	if description, err := ValidateRepoDescription(meta.Description); err == nil && description != "" && (force || repo.Description == "") {
+		repo.Description = description
+	}
+	repo.DefaultBranch, repo.Language, repo.Stars = meta.DefaultBranch, meta.Language, meta.Stars
+	repo.LastFetchedMeta = time.Now()
+	if err := updateRepo(repo); err != nil {
+		return fmt.Errorf("failed to save repository: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package internal
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+	"workbench/internal/providers"
+	"workbench/models"
+
+	"github.com/The-Skyscape/devtools/pkg/testutils"
+)
+
+// fakeRepoMetadata serves repo for lookups and meta or err from the
+// provider, returning a count of provider requests and saves.
+func fakeRepoMetadata(t *testing.T, repo *models.Repository, meta *providers.Metadata, err error) (*int, *int) {
+	find, update, fetch := findRepo, updateRepo, repoMetadata
+	t.Cleanup(func() { findRepo, updateRepo, repoMetadata = find, update, fetch })
+
+	var fetches, saves int
+	findRepo = func(name string) (*models.Repository, error) {
+		if name != repo.Name {
+			return nil, errors.New("not found")
+		}
+		return repo, nil
+	}
+	updateRepo = func(*models.Repository) error {
+		saves++
+		return nil
+	}
+	repoMetadata = func(string) (*providers.Metadata, error) {
+		fetches++
+		return meta, err
+	}
+	return &fetches, &saves
+}
+
+func TestValidateRepoDescription(t *testing.T) {
+	description, err := ValidateRepoDescription("  A web API\r\nfor billing\t ")
+	testutils.AssertEqual(t, nil, err)
+	testutils.AssertEqual(t, "A web API for billing", description)
+
+	_, err = ValidateRepoDescription(strings.Repeat("é", MaxRepoDescriptionLength+1))
+	testutils.AssertEqual(t, true, errors.Is(err, ErrInvalidInput))
+}
+
+func TestEditRepository(t *testing.T) {
+	repo := &models.Repository{Name: "api", Description: "Old"}
+	_, saves := fakeRepoMetadata(t, repo, nil, nil)
+
+	description := " Billing API "
+	testutils.AssertEqual(t, nil, EditRepository("api", RepoEdit{Description: &description}))
+	testutils.AssertEqual(t, "Billing API", repo.Description)
+	testutils.AssertEqual(t, 1, *saves)
+
+	// Fields left out are unchanged
+	testutils.AssertEqual(t, nil, EditRepository("api", RepoEdit{}))
+	testutils.AssertEqual(t, "Billing API", repo.Description)
+
+	err := EditRepository("missing", RepoEdit{Description: &description})
+	testutils.AssertEqual(t, true, errors.Is(err, ErrRepoNotFound))
+}
+
+func TestFetchRepositoryMetadata(t *testing.T) {
+	meta := &providers.Metadata{Description: "From GitHub", DefaultBranch: "main", Language: "Go", Stars: 42}
+	repo := &models.Repository{Name: "api", URL: "https://github.com/user/api.git", Description: "Mine"}
+	fetches, saves := fakeRepoMetadata(t, repo, meta, nil)
+
+	// Filling in keeps a description the user wrote
+	testutils.AssertEqual(t, nil, FetchRepositoryMetadata("api", false))
+	testutils.AssertEqual(t, "Mine", repo.Description)
+	testutils.AssertEqual(t, "Go", repo.Language)
+	testutils.AssertEqual(t, "main", repo.DefaultBranch)
+	testutils.AssertEqual(t, 42, repo.Stars)
+	testutils.AssertEqual(t, true, time.Since(repo.LastFetchedMeta) < time.Minute)
+
+	// Cached for a day unless forced
+	testutils.AssertEqual(t, nil, FetchRepositoryMetadata("api", false))
+	testutils.AssertEqual(t, 1, *fetches)
+	testutils.AssertEqual(t, nil, FetchRepositoryMetadata("api", true))
+	testutils.AssertEqual(t, 2, *fetches)
+	testutils.AssertEqual(t, "From GitHub", repo.Description)
+	testutils.AssertEqual(t, 2, *saves)
+}
+
+func TestFetchRepositoryMetadataDegrades(t *testing.T) {
+	repo := &models.Repository{Name: "api", URL: "https://github.com/user/api.git", Description: "Mine", Language: "Go"}
+	_, saves := fakeRepoMetadata(t, repo, nil, errors.New("provider API returned 403 Forbidden"))
+
+	testutils.AssertEqual(t, nil, FetchRepositoryMetadata("api", true))
+	testutils.AssertEqual(t, "Mine", repo.Description)
+	testutils.AssertEqual(t, "Go", repo.Language)
+	testutils.AssertEqual(t, 0, *saves)
+
+	// Local repositories and other hosts have nothing to fetch
+	repo.URL = ""
+	fetches, _ := fakeRepoMetadata(t, repo, nil, nil)
+	testutils.AssertEqual(t, nil, FetchRepositoryMetadata("api", true))
+	testutils.AssertEqual(t, 0, *fetches)
+}
@@ -0,0 +1,123 @@
+package internal
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+	"workbench/models"
+)
+
+// repoSizeTTL is how long a measured repository size is reused; du on a
+// large repository takes seconds.
+const repoSizeTTL = 5 * time.Minute
+
+// repoSizeWorkers bounds how many du commands run at once.
+const repoSizeWorkers = 4
+
+// RepoSize is the disk usage of one repository.
+type RepoSize struct {
+	Name      string
+	Bytes     uint64
+	Available bool // False when the directory is missing or du failed
+	Measured  time.Time
+}
+
+var (
+	repoSizesMu sync.Mutex // Held while measuring, so concurrent callers share one run
+	repoSizes   = map[string]*RepoSize{}
+
+	// repoSize measures a repository. Replaced in tests.
+	repoSize = func(repo *models.Repository) (int64, error) { return repo.Size() }
+)
+
+// GetRepositorySizes returns every repository's disk usage, largest first,
+// with unavailable sizes last. Sizes are measured concurrently and reused
+// for repoSizeTTL.
+func GetRepositorySizes() ([]*RepoSize, error) {
+	repos, err := models.Repositories.Search("ORDER BY Name ASC")
+	if err != nil {
+		return nil, fmt.Errorf("failed to load repositories")
+	}
+	return measureRepoSizes(repos, time.Now()), nil
+}
+
+// measureRepoSizes returns sizes for repos, measuring those without a
+// fresh cached size on a bounded pool of workers.
+func measureRepoSizes(repos []*models.Repository, now time.Time) []*RepoSize {
+	repoSizesMu.Lock()
+	defer repoSizesMu.Unlock()
+
+	var stale []*models.Repository
+	for _, repo := range repos {
+		if cached, ok := repoSizes[repo.Name]; !ok || now.Sub(cached.Measured) > repoSizeTTL {
+			stale = append(stale, repo)
+		}
+	}
+
+	measured := make([]*RepoSize, len(stale))
+	work := make(chan int)
+	var wg sync.WaitGroup
+	for range min(repoSizeWorkers, len(stale)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				size := &RepoSize{Name: stale[i].Name, Measured: now}
+				if bytes, err := repoSize(stale[i]); err == nil && bytes >= 0 {
+					size.Bytes, size.Available = uint64(bytes), true
+				}
+				measured[i] = size
+			}
+		}()
+	}
+	for i := range stale {
+		work <- i
+	}
+	close(work)
+	wg.Wait()
+
+	for _, size := range measured {
+		repoSizes[size.Name] = size
+	}
+	current := map[string]bool{}
+	sizes := make([]*RepoSize, 0, len(repos))
+	for _, repo := range repos {
+		current[repo.Name] = true
+		size := *repoSizes[repo.Name]
+		sizes = append(sizes, &size)
+	}
+	for name := range repoSizes {
+		if !current[name] {
+			delete(repoSizes, name) // Deleted repositories
+		}
+	}
+	sort.SliceStable(sizes, func(i, j int) bool {
+		if sizes[i].Available != sizes[j].Available {
+			return sizes[i].Available
+		}
+		return sizes[i].Bytes > sizes[j].Bytes
+	})
+	return sizes
+}
+
+// cachedRepoSize returns the last measured size of a repository, or nil
+// when it hasn't been measured yet.
+func cachedRepoSize(name string) *RepoSize {
+	repoSizesMu.Lock()
+	defer repoSizesMu.Unlock()
+	if cached, ok := repoSizes[name]; ok {
+		size := *cached
+		return &size
+	}
+	return nil
+}
+
+// TotalRepoSize adds up the available sizes.
+func TotalRepoSize(sizes []*RepoSize) uint64 {
+	var total uint64
+	for _, size := range sizes {
+		total += size.Bytes
+	}
+	return total
+}
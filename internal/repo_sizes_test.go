@@ -0,0 +1,69 @@
+package internal
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	"workbench/models"
+
+	"github.com/The-Skyscape/devtools/pkg/testutils"
+)
+
+// fakeRepoSizes serves sizes from a map, failing for names not in it, and
+// counts calls and the most run at once.
+func fakeRepoSizes(t *testing.T, sizes map[string]int64) (calls, peak *int32) {
+	calls, peak = new(int32), new(int32)
+	var running int32
+	var mu sync.Mutex
+
+	original := repoSize
+	repoSizes = map[string]*RepoSize{}
+	t.Cleanup(func() {
+		repoSize = original
+		repoSizes = map[string]*RepoSize{}
+	})
+	repoSize = func(repo *models.Repository) (int64, error) {
+		atomic.AddInt32(calls, 1)
+		now := atomic.AddInt32(&running, 1)
+		mu.Lock()
+		*peak = max(*peak, now)
+		mu.Unlock()
+		time.Sleep(5 * time.Millisecond)
+		atomic.AddInt32(&running, -1)
+
+		size, ok := sizes[repo.Name]
+		if !ok {
+			return 0, errors.New("du: cannot access: No such file or directory")
+		}
+		return size, nil
+	}
+	return calls, peak
+}
+
+func TestMeasureRepoSizes(t *testing.T) {
+	calls, peak := fakeRepoSizes(t, map[string]int64{"a": 10, "b": 300, "c": 20, "d": 5, "e": 40, "f": 1})
+	repos := []*models.Repository{{Name: "a"}, {Name: "b"}, {Name: "c"}, {Name: "d"}, {Name: "e"}, {Name: "f"}, {Name: "gone"}}
+	now := time.Now()
+
+	sizes := measureRepoSizes(repos, now)
+	testutils.AssertEqual(t, 7, len(sizes))
+	testutils.AssertEqual(t, "b", sizes[0].Name)
+	testutils.AssertEqual(t, uint64(300), sizes[0].Bytes)
+	testutils.AssertEqual(t, "f", sizes[5].Name)
+	testutils.AssertEqual(t, "gone", sizes[6].Name)
+	testutils.AssertEqual(t, false, sizes[6].Available)
+	testutils.AssertEqual(t, uint64(376), TotalRepoSize(sizes))
+	testutils.AssertEqual(t, int32(7), *calls)
+	testutils.AssertEqual(t, true, *peak > 1 && *peak <= repoSizeWorkers)
+
+	// Cached sizes are reused until they expire
+	measureRepoSizes(repos, now.Add(time.Minute))
+	testutils.AssertEqual(t, int32(7), *calls)
+	measureRepoSizes(append(repos, &models.Repository{Name: "new"}), now.Add(time.Minute))
+	testutils.AssertEqual(t, int32(8), *calls)
+	measureRepoSizes(repos, now.Add(repoSizeTTL+time.Minute))
+	testutils.AssertEqual(t, int32(15), *calls)
+	testutils.AssertEqual(t, 7, len(repoSizes))
+}
@@ -0,0 +1,161 @@
+package internal
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"workbench/models"
+	"workbench/services"
+)
+
+// RepoStatus summarizes a repository's working tree and branch.
+type RepoStatus struct {
+	Missing    bool   // The directory is gone, e.g. after a volume change; nothing else is set
+	Branch     string // Empty when HEAD is detached
+	Upstream   string // Empty when the branch tracks nothing
+	Ahead      int
+	Behind     int
+	Changed    int    // Staged, modified, conflicted, or untracked paths
+	LastCommit string // Abbreviated hash and subject of HEAD
+}
+
+// Dirty reports whether the working tree has uncommitted changes.
+func (s *RepoStatus) Dirty() bool {
+	return s.Changed > 0
+}
+
+// GetRepoStatus reads the branch, upstream divergence, and uncommitted
+// changes of a repository. A missing directory is reported as
+// RepoStatus.Missing rather than an error, so it can be re-cloned.
+func GetRepoStatus(repoName string) (*RepoStatus, error) {
+	repo, err := models.Repositories.Find("WHERE Name = ?", repoName)
+	if err != nil {
+		return nil, repoNotFound(repoName)
+	}
+	return readRepoStatus(repo)
+}
+
+func readRepoStatus(repo *models.Repository) (*RepoStatus, error) {
+	output, err := services.CoderExec(fmt.Sprintf(
+		"test -d %[1]s || { echo '# missing'; exit 0; }; cd %[1]s && git log -1 --format='# commit %%h %%s' 2>/dev/null; git status --porcelain=v2 --branch 2>&1", shellQuote(repo.LocalPath)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read status: %s", strings.TrimSpace(output))
+	}
+	return parseRepoStatus(output), nil
+}
+
+// parseRepoStatus parses git status --porcelain=v2 --branch output,
+// preceded by a "# commit <hash> <subject>" line, or a lone "# missing"
+// line when the directory doesn't exist.
+func parseRepoStatus(output string) *RepoStatus {
+	status := &RepoStatus{}
+	for _, line := range strings.Split(output, "\n") {
+		switch {
+		case line == "# missing":
+			return &RepoStatus{Missing: true}
+		case strings.HasPrefix(line, "# commit "):
+			status.LastCommit = strings.TrimPrefix(line, "# commit ")
+		case strings.HasPrefix(line, "# branch.head "):
+			if head := strings.TrimPrefix(line, "# branch.head "); head != "(detached)" {
+				status.Branch = head
+			}
+		case strings.HasPrefix(line, "# branch.upstream "):
+			status.Upstream = strings.TrimPrefix(line, "# branch.upstream ")
+		case strings.HasPrefix(line, "# branch.ab "):
+			fields := strings.Fields(strings.TrimPrefix(line, "# branch.ab "))
+			if len(fields) == 2 {
+				status.Ahead, _ = strconv.Atoi(strings.TrimPrefix(fields[0], "+"))
+				status.Behind, _ = strconv.Atoi(strings.TrimPrefix(fields[1], "-"))
+			}
+		case strings.HasPrefix(line, "1 "), strings.HasPrefix(line, "2 "),
+			strings.HasPrefix(line, "u "), strings.HasPrefix(line, "? "):
+			status.Changed++
+		}
+	}
+	return status
+}
+
+// Repository states reported in RepoSummary.Status.
+const (
+	RepoClean   = "clean"
+	RepoDirty   = "dirty"
+	RepoMissing = "missing"
+	RepoCloning = "cloning"
+	RepoFailed  = "clone_failed"
+	RepoUnknown = "unknown" // git status failed
+)
+
+// RepoSummary is a repository as listed by the JSON API.
+type RepoSummary struct {
+	Name   string  `json:"name"`
+	URL    string  `json:"url"` // Empty for projects that haven't been exported
+	Branch string  `json:"branch"`
+	Size   *uint64 `json:"size"` // Bytes on disk; null when it couldn't be measured
+	Status string  `json:"status" api:"enum=clean|dirty|missing|cloning|clone_failed|unknown"`
+	Ahead  int     `json:"ahead"`
+	Behind int     `json:"behind"`
+}
+
+// ListRepositorySummaries returns every repository by name with its
+// branch, working tree state, and disk usage. A repository whose status
+// can't be read is listed with status "unknown" rather than failing the
+// whole list.
+func ListRepositorySummaries() ([]*RepoSummary, error) {
+	repos, err := models.Repositories.Search("ORDER BY Name ASC")
+	if err != nil {
+		return nil, fmt.Errorf("failed to load repositories")
+	}
+
+	sizes := map[string]*RepoSize{}
+	for _, size := range measureRepoSizes(repos, time.Now()) {
+		sizes[size.Name] = size
+	}
+
+	summaries := make([]*RepoSummary, 0, len(repos))
+	for _, repo := range repos {
+		status, err := readRepoStatus(repo)
+		summaries = append(summaries, summarizeRepository(repo, status, err, sizes[repo.Name]))
+	}
+	return summaries, nil
+}
+
+// GetRepositorySummary returns one repository as ListRepositorySummaries
+// would list it, with the size from the last measurement if there was one.
+func GetRepositorySummary(repoName string) (*RepoSummary, error) {
+	repo, err := models.Repositories.Find("WHERE Name = ?", repoName)
+	if err != nil {
+		return nil, repoNotFound(repoName)
+	}
+	status, err := readRepoStatus(repo)
+	return summarizeRepository(repo, status, err, cachedRepoSize(repo.Name)), nil
+}
+
+// summarizeRepository combines a repository's record, status, and size.
+// size may be nil, and status is ignored when statusErr is set.
+func summarizeRepository(repo *models.Repository, status *RepoStatus, statusErr error, size *RepoSize) *RepoSummary {
+	summary := &RepoSummary{Name: repo.Name, URL: repo.URL, Status: RepoUnknown}
+	if size != nil && size.Available {
+		bytes := size.Bytes
+		summary.Size = &bytes
+	}
+
+	switch {
+	case repo.IsCloning():
+		summary.Status = RepoCloning
+	case repo.IsCloneFailed():
+		summary.Status = RepoFailed
+	case statusErr != nil:
+	case status.Missing:
+		summary.Status = RepoMissing
+	default:
+		summary.Status = RepoClean
+		if status.Dirty() {
+			summary.Status = RepoDirty
+		}
+		summary.Branch = status.Branch
+		summary.Ahead = status.Ahead
+		summary.Behind = status.Behind
+	}
+	return summary
+}
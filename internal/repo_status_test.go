@@ -0,0 +1,86 @@
+package internal
+
+import (
+	"errors"
+	"testing"
+	"workbench/models"
+
+	"github.com/The-Skyscape/devtools/pkg/testutils"
+)
+
+func TestParseRepoStatus(t *testing.T) {
+	output := "# commit 1a2b3c4 Fix the build\n" +
+		"# branch.oid 1a2b3c4d5e6f\n" +
+		"# branch.head main\n" +
+		"# branch.upstream origin/main\n" +
+		"# branch.ab +2 -5\n" +
+		"1 .M N... 100644 100644 100644 abc abc README.md\n" +
+		"u UU N... 100644 100644 100644 100644 a b c go.mod\n" +
+		"? notes.txt\n"
+
+	status := parseRepoStatus(output)
+	testutils.AssertEqual(t, "main", status.Branch)
+	testutils.AssertEqual(t, "origin/main", status.Upstream)
+	testutils.AssertEqual(t, 2, status.Ahead)
+	testutils.AssertEqual(t, 5, status.Behind)
+	testutils.AssertEqual(t, 3, status.Changed)
+	testutils.AssertEqual(t, true, status.Dirty())
+	testutils.AssertEqual(t, false, status.Missing)
+	testutils.AssertEqual(t, "1a2b3c4 Fix the build", status.LastCommit)
+}
+
+func TestParseRepoStatusDetached(t *testing.T) {
+	status := parseRepoStatus("# branch.oid 1a2b3c4d5e6f\n# branch.head (detached)\n")
+	testutils.AssertEqual(t, "", status.Branch)
+	testutils.AssertEqual(t, "", status.Upstream)
+	testutils.AssertEqual(t, 0, status.Changed)
+}
+
+func TestParseRepoStatusMissing(t *testing.T) {
+	status := parseRepoStatus("# missing\n")
+	testutils.AssertEqual(t, true, status.Missing)
+	testutils.AssertEqual(t, false, status.Dirty())
+	testutils.AssertEqual(t, "", status.Branch)
+}
+
+func TestSummarizeRepositoryClone(t *testing.T) {
+	cloning := &models.Repository{Name: "big", CloneStatus: models.CloneCloning}
+	testutils.AssertEqual(t, RepoCloning, summarizeRepository(cloning, nil, errors.New("not a git repository"), nil).Status)
+
+	failed := &models.Repository{Name: "big", CloneStatus: models.CloneFailed}
+	testutils.AssertEqual(t, RepoFailed, summarizeRepository(failed, nil, errors.New("not a git repository"), nil).Status)
+}
+
+func TestSummarizeRepository(t *testing.T) {
+	repo := &models.Repository{Name: "api", URL: "git@github.com:acme/api.git"}
+	measured := &RepoSize{Name: "api", Bytes: 2048, Available: true}
+
+	testCases := []struct {
+		name      string
+		status    *RepoStatus
+		statusErr error
+		size      *RepoSize
+		expected  string
+		branch    string
+		hasSize   bool
+	}{
+		{"clean", &RepoStatus{Branch: "main"}, nil, measured, RepoClean, "main", true},
+		{"dirty", &RepoStatus{Branch: "main", Changed: 2}, nil, measured, RepoDirty, "main", true},
+		{"missing", &RepoStatus{Missing: true}, nil, &RepoSize{Name: "api"}, RepoMissing, "", false},
+		{"status failed", nil, errors.New("not a git repository"), nil, RepoUnknown, "", false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			summary := summarizeRepository(repo, tc.status, tc.statusErr, tc.size)
+			testutils.AssertEqual(t, "api", summary.Name)
+			testutils.AssertEqual(t, repo.URL, summary.URL)
+			testutils.AssertEqual(t, tc.expected, summary.Status)
+			testutils.AssertEqual(t, tc.branch, summary.Branch)
+			testutils.AssertEqual(t, tc.hasSize, summary.Size != nil)
+			if tc.hasSize {
+				testutils.AssertEqual(t, uint64(2048), *summary.Size)
+			}
+		})
+	}
+}
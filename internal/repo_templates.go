@@ -0,0 +1,381 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"workbench/models"
+	"workbench/services"
+)
+
+// TemplateManifest is the optional file at the root of a starter template
+// repository that declares its placeholders. It isn't copied into new
+// projects.
+const TemplateManifest = "template.json"
+
+// maxTemplateFileSize caps the files placeholders are filled in; larger
+// files are copied as they are.
+const maxTemplateFileSize = 1 << 20
+
+// TemplateVariable is a placeholder declared in a template's manifest,
+// written {{name}} in file contents and file names.
+type TemplateVariable struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Default     string `json:"default,omitempty"`
+}
+
+// TemplateResult describes a project created from a starter template.
+type TemplateResult struct {
+	Repository string
+	Unfilled   []string // Declared placeholders left as {{name}} for want of a value
+	Skipped    []string // Text files too large to fill in
+}
+
+var validVariableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.-]*$`)
+
+// starterCloneExec clones template repositories, which may outlast a
+// plain command. Replaced in tests.
+var starterCloneExec = operationExecArgs
+
+// GetRepoTemplates returns the starter templates by name.
+func GetRepoTemplates() ([]*models.RepoTemplate, error) {
+	return models.RepoTemplates.Search("ORDER BY Name ASC")
+}
+
+// GetRepoTemplate loads a starter template by name, ignoring case.
+func GetRepoTemplate(name string) (*models.RepoTemplate, error) {
+	template, err := models.RepoTemplates.Find("WHERE LOWER(Name) = LOWER(?)", strings.TrimSpace(name))
+	if err != nil || template == nil || template.Name == "" {
+		return nil, fmt.Errorf("no starter template is named '%s'", name)
+	}
+	return template, nil
+}
+
+// AddRepoTemplate saves a starter template after reading the placeholders
+// its manifest declares, which also checks that it can be cloned.
+func AddRepoTemplate(name, sourceURL, branch, description string) (*models.RepoTemplate, error) {
+	name, sourceURL, branch = strings.TrimSpace(name), strings.TrimSpace(sourceURL), strings.TrimSpace(branch)
+	if name == "" {
+		return nil, fmt.Errorf("template name is required")
+	}
+	if err := ValidateRemoteURL(sourceURL); err != nil {
+		return nil, err
+	}
+	if branch != "" && !validDefaultBranch(branch) {
+		return nil, fmt.Errorf("invalid branch name")
+	}
+	if models.RepoTemplates.Count("WHERE LOWER(Name) = LOWER(?)", name) > 0 {
+		return nil, fmt.Errorf("a starter template named '%s' already exists", name)
+	}
+
+	variables, err := readTemplateVariables(sourceURL, branch)
+	if err != nil {
+		return nil, err
+	}
+	template, err := models.RepoTemplates.Insert(&models.RepoTemplate{
+		Name:          name,
+		SourceURL:     sourceURL,
+		DefaultBranch: branch,
+		Description:   strings.TrimSpace(description),
+		Variables:     encodeTemplateVariables(variables),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to save starter template")
+	}
+	return template, nil
+}
+
+// RefreshRepoTemplate reads a starter template's manifest again, after
+// its placeholders changed upstream.
+func RefreshRepoTemplate(id string) error {
+	template, err := models.RepoTemplates.Get(id)
+	if err != nil {
+		return fmt.Errorf("starter template not found")
+	}
+	variables, err := readTemplateVariables(template.SourceURL, template.DefaultBranch)
+	if err != nil {
+		return err
+	}
+	template.Variables = encodeTemplateVariables(variables)
+	return models.RepoTemplates.Update(template)
+}
+
+// DeleteRepoTemplate removes a starter template. Projects created from it
+// are not changed.
+func DeleteRepoTemplate(id string) error {
+	template, err := models.RepoTemplates.Get(id)
+	if err != nil {
+		return fmt.Errorf("starter template not found")
+	}
+	return models.RepoTemplates.Delete(template)
+}
+
+// RepoTemplateVariables returns the placeholders a starter template
+// declared when its manifest was last read.
+func RepoTemplateVariables(template *models.RepoTemplate) []TemplateVariable {
+	var variables []TemplateVariable
+	if template.Variables != "" {
+		json.Unmarshal([]byte(template.Variables), &variables)
+	}
+	return variables
+}
+
+func encodeTemplateVariables(variables []TemplateVariable) string {
+	if len(variables) == 0 {
+		return ""
+	}
+	data, _ := json.Marshal(variables)
+	return string(data)
+}
+
+// readTemplateVariables reads the manifest of a template repository
+// without checking out its files.
+func readTemplateVariables(sourceURL, branch string) ([]TemplateVariable, error) {
+	dir, err := scaffoldExec("mktemp -d", services.ExecOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create a temporary directory")
+	}
+	tmp := strings.TrimSpace(dir.Output)
+	defer scaffoldExec("rm -rf "+shellQuote(tmp), services.ExecOptions{})
+
+	args := []string{"git", "clone", "-q", "--depth", "1", "--no-checkout", "--filter=blob:none"}
+	if branch != "" {
+		args = append(args, "--branch", branch)
+	}
+	if output, err := starterCloneExec(append(args, "--", sourceURL, tmp)...); err != nil {
+		return nil, fmt.Errorf("failed to clone the template: %s", lastLine(output))
+	}
+
+	manifest, err := scaffoldExec(fmt.Sprintf("cd %s && git show HEAD:%s 2>/dev/null", shellQuote(tmp), TemplateManifest), services.ExecOptions{})
+	if err != nil {
+		return nil, nil // No manifest declares no placeholders
+	}
+	return ParseTemplateManifest([]byte(manifest.Stdout))
+}
+
+// ParseTemplateManifest decodes a template.json, such as
+//
+//	{"variables": [{"name": "module", "description": "Go module path"}]}
+func ParseTemplateManifest(data []byte) ([]TemplateVariable, error) {
+	var manifest struct {
+		Variables []TemplateVariable `json:"variables"`
+	}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", TemplateManifest, err)
+	}
+	seen := map[string]bool{}
+	for _, v := range manifest.Variables {
+		if !validVariableName.MatchString(v.Name) {
+			return nil, fmt.Errorf("invalid %s: '%s' isn't a valid placeholder name", TemplateManifest, v.Name)
+		}
+		if seen[v.Name] {
+			return nil, fmt.Errorf("invalid %s: '%s' is declared twice", TemplateManifest, v.Name)
+		}
+		seen[v.Name] = true
+	}
+	return manifest.Variables, nil
+}
+
+// templateValues picks the value of each declared placeholder: the one
+// given, else its default, else the repository name for a placeholder
+// called name. Placeholders without a value are returned as unfilled.
+func templateValues(declared []TemplateVariable, given map[string]string, repoName string) (values map[string]string, unfilled []string) {
+	values = map[string]string{}
+	for _, v := range declared {
+		switch value := given[v.Name]; {
+		case value != "":
+			values[v.Name] = value
+		case v.Default != "":
+			values[v.Name] = v.Default
+		case v.Name == "name":
+			values[v.Name] = repoName
+		default:
+			unfilled = append(unfilled, v.Name)
+		}
+	}
+	sort.Strings(unfilled)
+	return values, unfilled
+}
+
+// fillPlaceholders replaces {{name}} with its value for each placeholder
+// in values. Other double-braced text is left alone.
+func fillPlaceholders(text string, values map[string]string) string {
+	if len(values) == 0 || !strings.Contains(text, "{{") {
+		return text
+	}
+	pairs := make([]string, 0, 2*len(values))
+	for name, value := range values {
+		pairs = append(pairs, "{{"+name+"}}", value)
+	}
+	return strings.NewReplacer(pairs...).Replace(text)
+}
+
+// CreateFromTemplate creates a new local repository from a starter
+// template: a shallow copy of its files without their history, with the
+// declared placeholders filled in from variables, committed on the
+// default branch as a fresh repository.
+func CreateFromTemplate(templateName, newName string, variables map[string]string) (*TemplateResult, error) {
+	newName = strings.TrimSpace(newName)
+	if err := ValidateRepoName(newName); err != nil {
+		return nil, err
+	}
+	template, err := GetRepoTemplate(templateName)
+	if err != nil {
+		return nil, err
+	}
+	existing, err := models.Repositories.Find("WHERE LOWER(Name) = LOWER(?)", newName)
+	if err == nil && existing != nil && existing.Name != "" {
+		return nil, fmt.Errorf("a repository named '%s' already exists", existing.Name)
+	}
+
+	targetDir := path.Join(DefaultReposDir, newName)
+	branch := DefaultBranch()
+	result, err := copyTemplate(template, newName, targetDir, branch, variables)
+	if err != nil {
+		return nil, err
+	}
+
+	// No URL marks the repository local-only until it is exported
+	if _, err := models.Repositories.Insert(&models.Repository{Name: newName, LocalPath: targetDir, Host: OtherHost}); err != nil {
+		return nil, fmt.Errorf("failed to save repository: %w", err)
+	}
+
+	description := fmt.Sprintf("Created repository %s from the %s starter template on %s", newName, template.Name, branch)
+	if len(result.Unfilled) > 0 {
+		description += fmt.Sprintf(" (unfilled: %s)", strings.Join(result.Unfilled, ", "))
+	}
+	go models.Activities.Insert(&models.Activity{
+		Type:        "repo_create",
+		Repository:  newName,
+		Description: description,
+		Author:      "System",
+		Timestamp:   time.Now(),
+	})
+	return result, nil
+}
+
+// copyTemplate clones a template into targetDir, drops its history and
+// manifest, fills in placeholders, and commits the result on branch. The
+// directory is removed if any step after the clone fails.
+func copyTemplate(template *models.RepoTemplate, name, targetDir, branch string, variables map[string]string) (*TemplateResult, error) {
+	dir := shellQuote(targetDir)
+	exists, _ := scaffoldExec(fmt.Sprintf("test -e %s && echo exists", dir), services.ExecOptions{})
+	if strings.TrimSpace(exists.Output) == "exists" {
+		return nil, fmt.Errorf("directory %s already exists - please choose a different name", name)
+	}
+
+	args := []string{"git", "clone", "-q", "--depth", "1"}
+	if template.DefaultBranch != "" {
+		args = append(args, "--branch", template.DefaultBranch)
+	}
+	if output, err := starterCloneExec(append(args, "--", template.SourceURL, targetDir)...); err != nil {
+		scaffoldExec("rm -rf "+dir, services.ExecOptions{})
+		return nil, fmt.Errorf("failed to clone the template: %s", lastLine(output))
+	}
+	fail := func(err error) (*TemplateResult, error) {
+		scaffoldExec("rm -rf "+dir, services.ExecOptions{})
+		return nil, err
+	}
+
+	// The manifest in the copy is the one that counts, even if the saved
+	// template's placeholders are out of date
+	manifest, err := scaffoldExec(fmt.Sprintf("cd %s && rm -rf .git && cat %s 2>/dev/null", dir, TemplateManifest), services.ExecOptions{})
+	var declared []TemplateVariable
+	if err == nil {
+		if declared, err = ParseTemplateManifest([]byte(manifest.Stdout)); err != nil {
+			return fail(err)
+		}
+		scaffoldExec(fmt.Sprintf("rm -f %s/%s", dir, TemplateManifest), services.ExecOptions{})
+	}
+
+	values, unfilled := templateValues(declared, variables, name)
+	result := &TemplateResult{Repository: name, Unfilled: unfilled}
+	skipped, err := fillTemplateFiles(targetDir, values)
+	if err != nil {
+		return fail(err)
+	}
+	result.Skipped = skipped
+	if err := renameTemplateFiles(targetDir, values); err != nil {
+		return fail(err)
+	}
+
+	commit := fmt.Sprintf("cd %s && git init -q -b %s && git add -A && git commit -q -m %s 2>&1",
+		dir, shellQuote(branch), shellQuote("Initial commit from the "+template.Name+" template"))
+	if output, err := scaffoldExec(commit, services.ExecOptions{}); err != nil {
+		return fail(fmt.Errorf("failed to create initial commit: %s", strings.TrimSpace(output.Output)))
+	}
+	return result, nil
+}
+
+// fillTemplateFiles fills in placeholders in the text files under dir.
+// grep -I leaves out binary files. Files too large to fill in are
+// returned.
+func fillTemplateFiles(dir string, values map[string]string) (skipped []string, err error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+	list, err := scaffoldExec(fmt.Sprintf("cd %s && grep -rlIF -e '{{' . || true", shellQuote(dir)), services.ExecOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the template's files")
+	}
+	for _, file := range strings.Split(strings.TrimSpace(list.Stdout), "\n") {
+		file = strings.TrimPrefix(file, "./")
+		if file == "" {
+			continue
+		}
+		full := shellQuote(path.Join(dir, file))
+		content, err := scaffoldExec("cat "+full, services.ExecOptions{MaxOutput: maxTemplateFileSize})
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s", file)
+		}
+		if content.Truncated {
+			skipped = append(skipped, file)
+			continue
+		}
+		filled := fillPlaceholders(content.Stdout, values)
+		if filled == content.Stdout {
+			continue
+		}
+		if _, err := scaffoldExec("cat > "+full, services.ExecOptions{Stdin: strings.NewReader(filled)}); err != nil {
+			return nil, fmt.Errorf("failed to write %s", file)
+		}
+	}
+	return skipped, nil
+}
+
+// renameTemplateFiles fills in placeholders in file and directory names
+// under dir, deepest first so renaming a directory doesn't move the
+// files still to be renamed.
+func renameTemplateFiles(dir string, values map[string]string) error {
+	if len(values) == 0 {
+		return nil
+	}
+	list, err := scaffoldExec(fmt.Sprintf("cd %s && find . -depth -name '*{{*}}*'", shellQuote(dir)), services.ExecOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to list the template's files")
+	}
+	for _, file := range strings.Split(strings.TrimSpace(list.Stdout), "\n") {
+		file = strings.TrimPrefix(file, "./")
+		if file == "" {
+			continue
+		}
+		base := fillPlaceholders(path.Base(file), values)
+		if base == path.Base(file) {
+			continue
+		}
+		if base == "." || base == ".." || strings.ContainsAny(base, "/\x00") {
+			return fmt.Errorf("the values given make '%s' an invalid file name", file)
+		}
+		renamed := path.Join(path.Dir(file), base)
+		cmd := fmt.Sprintf("cd %s && mv -n -- %s %s", shellQuote(dir), shellQuote(file), shellQuote(renamed))
+		if _, err := scaffoldExec(cmd, services.ExecOptions{}); err != nil {
+			return fmt.Errorf("failed to rename %s", file)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package internal
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"workbench/services"
+
+	"github.com/The-Skyscape/devtools/pkg/testutils"
+)
+
+func TestParseTemplateManifest(t *testing.T) {
+	variables, err := ParseTemplateManifest([]byte(`{"variables": [
+		{"name": "module", "description": "Go module path"},
+		{"name": "port", "default": "8080"}
+	]}`))
+	testutils.AssertEqual(t, nil, err)
+	testutils.AssertEqual(t, []TemplateVariable{
+		{Name: "module", Description: "Go module path"},
+		{Name: "port", Default: "8080"},
+	}, variables)
+
+	testCases := []struct {
+		name     string
+		manifest string
+		expected string
+	}{
+		{"not json", `variables`, "invalid template.json"},
+		{"bad name", `{"variables": [{"name": "a b"}]}`, "invalid template.json: 'a b' isn't a valid placeholder name"},
+		{"twice", `{"variables": [{"name": "a"}, {"name": "a"}]}`, "invalid template.json: 'a' is declared twice"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := ParseTemplateManifest([]byte(tc.manifest))
+			testutils.AssertEqual(t, true, err != nil && strings.HasPrefix(err.Error(), tc.expected))
+		})
+	}
+}
+
+func TestTemplateValues(t *testing.T) {
+	declared := []TemplateVariable{
+		{Name: "module"},
+		{Name: "port", Default: "8080"},
+		{Name: "name"},
+		{Name: "owner"},
+		{Name: "license"},
+	}
+	values, unfilled := templateValues(declared, map[string]string{"module": "github.com/me/api", "port": "9000", "undeclared": "x"}, "api")
+
+	testutils.AssertEqual(t, map[string]string{"module": "github.com/me/api", "port": "9000", "name": "api"}, values)
+	testutils.AssertEqual(t, []string{"license", "owner"}, unfilled)
+}
+
+func TestFillPlaceholders(t *testing.T) {
+	values := map[string]string{"name": "api", "module": "github.com/me/api"}
+	testutils.AssertEqual(t,
+		"module github.com/me/api // api {{owner}} ${{ secrets.TOKEN }}",
+		fillPlaceholders("module {{module}} // {{name}} {{owner}} ${{ secrets.TOKEN }}", values))
+}
+
+// fakeTemplateExec stands in for the coder container with the files of a
+// cloned template, recording every command it runs.
+func fakeTemplateExec(t *testing.T, files map[string]string) *[]string {
+	var commands []string
+	original := scaffoldExec
+	scaffoldExec = func(command string, opts services.ExecOptions) (*services.ExecResult, error) {
+		if opts.Stdin != nil {
+			stdin, _ := io.ReadAll(opts.Stdin)
+			command += " <<< " + string(stdin)
+		}
+		commands = append(commands, command)
+		switch {
+		case strings.Contains(command, "grep -rlIF"):
+			var list []string
+			for file, content := range files {
+				if strings.Contains(content, "{{") {
+					list = append(list, "./"+file)
+				}
+			}
+			return &services.ExecResult{Stdout: strings.Join(list, "\n")}, nil
+		case strings.Contains(command, "find . -depth"):
+			return &services.ExecResult{Stdout: "./cmd/{{name}}/main.go\n./cmd/{{name}}\n./{{slash}}.txt\n"}, nil
+		case strings.HasPrefix(command, "cat '"):
+			file := strings.TrimPrefix(strings.Trim(strings.TrimPrefix(command, "cat "), "'"), "/repos/api/")
+			return &services.ExecResult{Stdout: files[file]}, nil
+		}
+		return &services.ExecResult{}, nil
+	}
+	t.Cleanup(func() { scaffoldExec = original })
+	return &commands
+}
+
+func TestFillTemplateFiles(t *testing.T) {
+	commands := fakeTemplateExec(t, map[string]string{
+		"go.mod":    "module {{module}}\n",
+		"README.md": "# {{name}}\n\n{{owner}} owns this\n",
+		"ci.yml":    "token: ${{ secrets.TOKEN }}\n",
+	})
+
+	skipped, err := fillTemplateFiles("/repos/api", map[string]string{"name": "api", "module": "github.com/me/api"})
+	testutils.AssertEqual(t, nil, err)
+	testutils.AssertEqual(t, 0, len(skipped))
+
+	var writes []string
+	for _, cmd := range *commands {
+		if strings.HasPrefix(cmd, "cat > ") {
+			writes = append(writes, cmd)
+		}
+	}
+	// Files that don't change aren't written back
+	testutils.AssertEqual(t, 2, len(writes))
+	testutils.AssertEqual(t, true, strings.Contains(strings.Join(writes, "\n"), "cat > '/repos/api/go.mod' <<< module github.com/me/api\n"))
+	testutils.AssertEqual(t, true, strings.Contains(strings.Join(writes, "\n"), "cat > '/repos/api/README.md' <<< # api\n\n{{owner}} owns this\n"))
+}
+
+func TestRenameTemplateFiles(t *testing.T) {
+	commands := fakeTemplateExec(t, nil)
+
+	err := renameTemplateFiles("/repos/api", map[string]string{"name": "api"})
+	testutils.AssertEqual(t, nil, err)
+	testutils.AssertEqual(t, strings.Join([]string{
+		"cd '/repos/api' && find . -depth -name '*{{*}}*'",
+		"cd '/repos/api' && mv -n -- 'cmd/{{name}}' 'cmd/api'",
+	}, "\n"), strings.Join(*commands, "\n"))
+}
+
+func TestRenameTemplateFilesRefusesPathsInValues(t *testing.T) {
+	fakeTemplateExec(t, nil)
+
+	err := renameTemplateFiles("/repos/api", map[string]string{"slash": "../../etc/passwd"})
+	testutils.AssertEqual(t, "the values given make '{{slash}}.txt' an invalid file name", err.Error())
+}
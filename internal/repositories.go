@@ -4,8 +4,11 @@
 package internal
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"net/url"
 	"path/filepath"
 	"strings"
 	"time"
@@ -13,161 +16,328 @@ import (
 	"workbench/services"
 )
 
-// CloneRepository clones a Git repository into the VS Code server container.
-// Parameters:
+// ErrRepoNotFound, ErrRepoExists and ErrInvalidInput are matched with
+// errors.Is by callers that need to tell a missing or duplicate repository
+// or a rejected name or URL from other failures, such as the JSON API
+// choosing a status code.
+var (
+	ErrRepoNotFound = errors.New("repository not found")
+	ErrRepoExists   = errors.New("repository already exists")
+	ErrInvalidInput = errors.New("invalid input")
+)
+
+// repoError has its own message but matches one of the sentinels above.
+type repoError struct {
+	kind    error
+	message string
+}
+
+func (e *repoError) Error() string { return e.message }
+func (e *repoError) Unwrap() error { return e.kind }
+
+func repoNotFound(name string) error {
+	return &repoError{ErrRepoNotFound, fmt.Sprintf("repository '%s' not found", name)}
+}
+
+// ValidateRepoName checks a repository name is safe to use as a directory
+// name and command argument: letters, numbers, dots, dashes, and
+// underscores, not starting with a dot or a dash.
+func ValidateRepoName(name string) error {
+	if name == "" {
+		return &repoError{ErrInvalidInput, "invalid repository name - it can't be empty"}
+	}
+	if !validRepoName.MatchString(name) || strings.HasPrefix(name, ".") || strings.HasPrefix(name, "-") {
+		return &repoError{ErrInvalidInput, fmt.Sprintf("invalid repository name '%s' - use letters, numbers, dots, dashes, and underscores", name)}
+	}
+	return nil
+}
+
+// checkRepoPath makes sure a repository's LocalPath is strictly inside its
+// storage location's directory, DefaultReposDir for most repositories,
+// before anything is removed from it.
+func checkRepoPath(repo *models.Repository, root string) error {
+	rel, err := filepath.Rel(root, repo.LocalPath)
+	if err != nil || !filepath.IsAbs(repo.LocalPath) || rel == "." || rel == ".." || strings.HasPrefix(rel, "../") {
+		return fmt.Errorf("refusing to delete %s - it isn't inside %s", repo.LocalPath, root)
+	}
+	return nil
+}
+
+func init() {
+	openInCoder := ActivityActionDef{
+		Label:        "Open in VS Code",
+		Method:       ActionLink,
+		RequiresRepo: true,
+		URL: func(a *models.Activity, repo *models.Repository) string {
+			return "/coder/?folder=" + url.QueryEscape(repo.LocalPath)
+		},
+	}
+
+	RegisterActivityAction("repo_pull_failed", ActivityActionDef{
+		Label:        "Retry pull",
+		Method:       ActionPost,
+		RequiresRepo: true,
+		URL: func(a *models.Activity, repo *models.Repository) string {
+			return "/repos/pull/" + repoPath(repo)
+		},
+	})
+	RegisterActivityAction("repo_pull_failed", ActivityActionDef{
+		Label:        "View status",
+		Method:       ActionGet,
+		RequiresRepo: true,
+		URL: func(a *models.Activity, repo *models.Repository) string {
+			return "/partials/repo-status/" + repoPath(repo)
+		},
+	})
+	RegisterActivityAction("repo_pull_failed", openInCoder)
+	RegisterActivityAction("repo_clone", ActivityActionDef{
+		Label:        "View repo",
+		Method:       ActionGet,
+		RequiresRepo: true,
+		URL: func(a *models.Activity, repo *models.Repository) string {
+			return "/partials/repo-status/" + repoPath(repo)
+		},
+	})
+	RegisterActivityAction("repo_clone", openInCoder)
+	RegisterActivityAction("repo_pull", openInCoder)
+	RegisterActivityAction("repo_webhook_pull", openInCoder)
+}
+
+// CloneOptions are the inputs for cloning a repository, from the clone
+// form or the JSON API.
+type CloneOptions struct {
+	URL     string `json:"url" api:"required"`
+	Name    string `json:"name"`    // Derived from the URL when empty
+	Storage string `json:"storage"` // Storage location ID; the standard repos directory when empty
+	SSHKey  string `json:"ssh_key"` // Named SSH key to map the URL's host to before cloning
+
+	// Access token to save for an HTTPS URL's host before cloning, for
+	// private repositories on hosts that don't accept SSH
+	Username string `json:"username"`
+	Token    string `json:"token"`
+
+	// Wait for the clone to finish rather than returning once it's
+	// queued. It still waits its turn in the clone queue; a failed clone
+	// then leaves no record behind.
+	Wait bool `json:"-"`
+}
+
+// Clone validates the URL and name, checks the coder container is
+// running, maps the URL's host to the chosen SSH key or saves its access
+// token, and clones the repository through the clone queue, waiting for
+// it when opts.Wait is set. Returns the repository's name. A waited-for
+// clone is stopped if ctx is cancelled.
+func Clone(ctx context.Context, opts CloneOptions) (string, error) {
+	if opts.URL == "" {
+		return "", fmt.Errorf("repository URL is required")
+	}
+	name := opts.Name
+	if name == "" {
+		name = ParseRepoName(opts.URL)
+	}
+	if err := ValidateRemoteURL(opts.URL); err != nil {
+		return "", &repoError{ErrInvalidInput, err.Error()}
+	}
+	if err := ValidateRepoName(name); err != nil {
+		return "", err
+	}
+	if !services.Coder.IsRunning() {
+		return "", ErrCoderNotRunning
+	}
+	if opts.SSHKey != "" {
+		if err := UseSSHKeyForURL(opts.SSHKey, opts.URL); err != nil {
+			return "", err
+		}
+	}
+	if opts.Token != "" {
+		host := CredentialHost(opts.URL)
+		if host == "" {
+			return "", fmt.Errorf("access tokens only work with HTTPS URLs - use an SSH key for SSH URLs")
+		}
+		if err := SetGitCredential(host, opts.Username, opts.Token); err != nil {
+			return "", err
+		}
+	}
+
+	start := QueueClone
+	if opts.Wait {
+		start = CloneRepository
+	}
+	if err := start(ctx, opts.URL, name, opts.Storage); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// CloneRepository queues a clone of a repository into the VS Code server
+// container and waits for it to finish. Parameters:
 //   - url: The repository URL (HTTPS or SSH format)
 //   - name: Optional repository name (auto-detected from URL if empty)
+//   - locationID: Optional storage location (the standard repos directory if empty)
 //
-// The function:
+// Once a clone queue worker is free, the clone:
 // 1. Validates the repository doesn't already exist (case-insensitive)
-// 2. Creates the repos directory if needed
-// 3. Executes git clone in the container
-// 4. Saves repository metadata to the database
-// 5. Logs the activity for audit purposes
+// 2. Creates the repos directory of the storage location if needed
+// 3. Checks the repository quota and that the disk has room for it
+// 4. Saves the repository with CloneStatus cloning
+// 5. Runs git clone --progress in the container, reporting GetCloneProgress
+// 6. Marks the repository ready and logs the activity
 //
-// Returns user-friendly error messages for common Git failures.
-func CloneRepository(url, name string) error {
+// Cancelling ctx takes the clone out of the queue or stops it. A failed
+// or cancelled clone leaves no record and no directory. Returns
+// user-friendly error messages.
+func CloneRepository(ctx context.Context, url, name, locationID string) error {
+	entry, err := submitClone(ctx, url, name, locationID, true)
+	if err != nil {
+		return err
+	}
+	return waitClone(ctx, entry)
+}
+
+// startClone runs the steps above for a queued clone. keepFailed keeps
+// the record of a clone that fails, as CloneFailed, rather than deleting
+// it.
+func startClone(ctx context.Context, url, name, locationID string, keepFailed bool) (<-chan error, error) {
 	if name == "" {
 		// Auto-detect name from URL
-		name = parseRepoName(url)
+		name = ParseRepoName(url)
 	}
 
-	// Log for debugging
-	log.Printf("Attempting to clone repository: URL=%s, Name=%s", url, name)
+	slog.InfoContext(ctx, "cloning repository", "name", name, "url", maskURLCredentials(url))
 
-	// Validate name is not empty
-	if name == "" {
-		return fmt.Errorf("repository name cannot be empty")
+	if err := ValidateRemoteURL(url); err != nil {
+		return nil, &repoError{ErrInvalidInput, err.Error()}
+	}
+	if err := ValidateRepoName(name); err != nil {
+		return nil, err
 	}
 
 	// Check if repository already exists (case-insensitive)
 	existing, err := models.Repositories.Find("WHERE LOWER(Name) = LOWER(?)", name)
 	if err == nil && existing != nil && existing.Name != "" {
-		log.Printf("Repository already exists in database: %s (found: %s)", name, existing.Name)
-		return fmt.Errorf("a repository named '%s' already exists", existing.Name)
+		slog.InfoContext(ctx, "repository already exists", "name", name, "existing", existing.Name)
+		return nil, &repoError{ErrRepoExists, fmt.Sprintf("a repository named '%s' already exists", existing.Name)}
+	}
+
+	reposDir, err := RepoDir(locationID)
+	if err != nil {
+		return nil, err
 	}
-	log.Printf("No existing repository found for name: %s (err: %v)", name, err)
 
 	// Ensure repos directory exists
-	services.CoderExec("mkdir -p /home/coder/repos")
+	repoExec("mkdir", "-p", "--", reposDir)
 
-	targetDir := filepath.Join("/home/coder/repos", name)
+	targetDir := filepath.Join(reposDir, name)
 
 	// Check if directory already exists
-	checkCmd := fmt.Sprintf("test -d %s && echo exists", targetDir)
-	exists, _ := services.CoderExec(checkCmd)
-	if strings.TrimSpace(exists) == "exists" {
-		return fmt.Errorf("directory %s already exists - please choose a different name", name)
+	if _, err := repoExec("test", "-d", targetDir); err == nil {
+		return nil, &repoError{ErrRepoExists, fmt.Sprintf("directory %s already exists - please choose a different name", name)}
 	}
 
-	// Execute git clone in the coder container
-	cmd := fmt.Sprintf("git clone %s %s 2>&1", url, targetDir)
-	output, err := services.CoderExec(cmd)
-	if err != nil {
-		// Parse common git errors for better messages
-		outputStr := string(output)
-		if strings.Contains(outputStr, "Permission denied") || strings.Contains(outputStr, "Could not read from remote") {
-			return fmt.Errorf("authentication failed - for private repos, add your SSH key to the git provider")
-		}
-		if strings.Contains(outputStr, "does not exist") || strings.Contains(outputStr, "not found") {
-			return fmt.Errorf("repository not found - check the URL is correct")
-		}
-		if strings.Contains(outputStr, "Could not resolve") || strings.Contains(outputStr, "unable to access") {
-			return fmt.Errorf("network error - check your connection and try again")
-		}
-		// Generic error
-		return fmt.Errorf("failed to clone repository")
+	// Refuse up front rather than fail halfway on a full disk
+	if err := checkCloneSpace(url, locationID); err != nil {
+		return nil, err
 	}
 
-	// Save to database
-	repo := &models.Repository{
-		Name:      name,
-		URL:       url,
-		LocalPath: targetDir,
-		IsPrivate: strings.Contains(url, "git@"),
-	}
-	_, err = models.Repositories.Insert(repo)
+	// Save to database before cloning, so the dashboard can show progress
+	repo, err := insertRepo(&models.Repository{
+		Name:              name,
+		URL:               url,
+		LocalPath:         targetDir,
+		IsPrivate:         strings.Contains(url, "git@"),
+		Host:              RepoHost(url),
+		StorageLocationID: locationID,
+		CloneStatus:       models.CloneCloning,
+	})
 	if err != nil {
-		return fmt.Errorf("failed to save repository: %w", err)
+		return nil, fmt.Errorf("failed to save repository: %w", err)
 	}
-
-	// Log activity
-	go models.Activities.Insert(&models.Activity{
-		Type:        "repo_clone",
-		Repository:  name,
-		Description: fmt.Sprintf("Cloned repository %s", name),
-		Author:      "System",
-		Timestamp:   time.Now(),
-	})
-
-	return nil
+	return runClone(ctx, repo, keepFailed), nil
 }
 
 // PullRepository fetches and merges latest changes from the remote repository.
-// If the local directory is missing (e.g., after container rebuild), it attempts
-// to re-clone the repository automatically.
+// If the local directory is missing (e.g., after container rebuild), the
+// missing_repo_behavior setting decides whether it is re-cloned.
 //
 // Parameters:
+//   - ctx: Stops the pull when cancelled, e.g. when the browser tab closes
 //   - repoName: The name of the repository in the database
 //
+// The pull is also bounded by OperationTimeout.
+//
 // Common error scenarios handled:
-//   - Missing local directory → automatic re-clone
+//   - Missing local directory → re-clone, or *MissingRepoError to prompt or fail
 //   - Authentication failures → SSH key reminder
 //   - Merge conflicts → manual resolution required
 //   - Uncommitted changes → stash or commit first
 //
-// Returns detailed error messages to guide user actions.
-func PullRepository(repoName string) error {
+// Returns detailed error messages to guide user actions. Failures are
+// recorded as repo_pull_failed activities so they can be retried from the feed.
+func PullRepository(ctx context.Context, repoName string) error {
 	repo, err := models.Repositories.Find("WHERE Name = ?", repoName)
 	if err != nil {
-		return fmt.Errorf("repository '%s' not found", repoName)
+		return repoNotFound(repoName)
+	}
+	if repo.IsLocal() {
+		return fmt.Errorf("'%s' has no remote to pull from - export it to a remote first", repoName)
 	}
+	return pullAndRecord(ctx, repo, "repo_pull")
+}
 
-	// Check if directory exists
-	checkCmd := fmt.Sprintf("test -d %s && echo exists", repo.LocalPath)
-	exists, _ := services.CoderExec(checkCmd)
-	if strings.TrimSpace(exists) != "exists" {
-		// Try to re-clone if directory is missing
-		log.Printf("Repository directory missing, attempting to re-clone: %s", repoName)
-		services.CoderExec("mkdir -p /home/coder/repos")
-		cmd := fmt.Sprintf("git clone %s %s 2>&1", repo.URL, repo.LocalPath)
-		_, err := services.CoderExec(cmd)
-		if err != nil {
-			return fmt.Errorf("repository directory was missing and re-clone failed")
+// pullAndRecord pulls a repository, recording success as activityType and
+// failure as repo_pull_failed. A missing directory is reported once as
+// repo_missing instead.
+func pullAndRecord(ctx context.Context, repo *models.Repository, activityType string) error {
+	if err := checkCloneReady(repo); err != nil {
+		return err
+	}
+	if err := pullRepository(ctx, repo, activityType); err != nil {
+		var missing *MissingRepoError
+		if errors.As(err, &missing) {
+			return err
 		}
-
 		go models.Activities.Insert(&models.Activity{
-			Type:        "repo_pull",
+			Type:        "repo_pull_failed",
 			Repository:  repo.Name,
-			Description: fmt.Sprintf("Re-cloned missing repository %s", repoName),
+			Description: fmt.Sprintf("Failed to pull %s: %v", repo.Name, err),
 			Author:      "System",
 			Timestamp:   time.Now(),
 		})
+		return err
+	}
+	return nil
+}
 
-		return nil
+// pullRepository pulls an existing repository, handling a missing
+// directory per missing_repo_behavior. Success is recorded as activityType.
+func pullRepository(ctx context.Context, repo *models.Repository, activityType string) error {
+	repoName := repo.Name
+
+	// Check if directory exists
+	if _, err := repoExec("test", "-d", repo.LocalPath); err != nil {
+		return handleMissingRepository(repo)
 	}
+	clearMissing(repo)
 
-	cmd := fmt.Sprintf("cd %s && git pull 2>&1", repo.LocalPath)
-	output, err := services.CoderExec(cmd)
-	if err != nil {
-		outputStr := string(output)
-		// Check for common issues
-		if strings.Contains(outputStr, "Permission denied") {
-			return fmt.Errorf("authentication failed - check your SSH key is added to the git provider")
-		}
-		if strings.Contains(outputStr, "merge conflict") || strings.Contains(outputStr, "Merge conflict") {
-			return fmt.Errorf("merge conflicts detected - resolve manually in VS Code")
-		}
-		if strings.Contains(outputStr, "uncommitted changes") || strings.Contains(outputStr, "Your local changes") {
-			return fmt.Errorf("uncommitted changes - commit or stash them first")
+	ctx, cancel := context.WithTimeout(ctx, OperationTimeout())
+	defer cancel()
+	err := WithSafetyPoint(repo, "pull", func() error {
+		result, err := repoExecCtx(ctx, "git", "-C", repo.LocalPath, "pull")
+		if err != nil {
+			return classifyPullError(result, err)
 		}
-		// Generic error
-		return fmt.Errorf("failed to pull latest changes")
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
+	// New LFS files arrive as pointer stubs while git-lfs is missing
+	refreshLFS(repo)
+
 	// Log activity
 	go models.Activities.Insert(&models.Activity{
-		Type:        "repo_pull",
+		Type:        activityType,
 		Repository:  repoName,
 		Description: fmt.Sprintf("Synced repository %s", repoName),
 		Author:      "System",
@@ -177,26 +347,83 @@ func PullRepository(repoName string) error {
 	return nil
 }
 
-// DeleteRepository permanently removes a repository from both filesystem and database.
-// This operation cannot be undone. The function:
-// 1. Verifies the repository exists in the database
-// 2. Deletes the repository directory and all contents
-// 3. Removes the database record
-// 4. Logs the deletion for audit purposes
+// Repository commands run as argv, without a shell, so names and paths
+// are never parsed as shell syntax. Replaced in tests.
+var (
+	repoExec    = services.CoderExecArgs    // Directory checks and cleanup
+	repoExecCtx = services.CoderExecArgsCtx // git pull and deletion
+)
+
+// classifyPullError explains a failed git pull. Git reports merge
+// conflicts on stdout and everything else on stderr.
+func classifyPullError(result *services.ExecResult, err error) error {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, context.Canceled):
+		return classifyRemoteError(result.Stderr, err, "")
+	case strings.Contains(result.Stdout, "CONFLICT") || strings.Contains(result.Stderr, "Automatic merge failed"):
+		return &AppError{Code: CodeConflict, UserMessage: "merge conflicts detected - resolve manually in VS Code", Detail: result.Stdout}
+	case strings.Contains(result.Stderr, "Your local changes") || strings.Contains(result.Stderr, "uncommitted changes"):
+		return &AppError{Code: CodeConflict, UserMessage: "uncommitted changes - commit or stash them first", Detail: result.Stderr}
+	case strings.Contains(result.Stderr, "Permission denied"):
+		return &AppError{Code: CodeAuthFailed, UserMessage: "authentication failed - check your SSH key is added to the git provider", Detail: result.Stderr}
+	}
+	return classifyRemoteError(result.Stderr, err, "failed to pull latest changes")
+}
+
+// DeleteRepository removes a repository from both filesystem and database.
+// The function:
+// 1. Verifies the repository exists in the database, isn't locked, and its
+// directory is inside its storage location
+// 2. Archives the working tree to the trash when archive is set
+// 3. Deletes the repository directory and all contents
+// 4. Removes the database record
+// 5. Logs the deletion for audit purposes, with the archive's path and
+// whether uncommitted changes or unpushed commits were lost
 //
 // Parameters:
+//   - ctx: Stops the deletion when cancelled, e.g. when the browser tab closes
 //   - name: The repository name to delete
+//   - archive: Whether to keep a tar.gz in TrashDir, restorable with RestoreTrash
 //
-// Returns error if repository not found or deletion fails.
-func DeleteRepository(name string) error {
-	repo, err := models.Repositories.Find("WHERE Name = ?", name)
+// Without an archive this cannot be undone. A failed archive stops the
+// deletion. Returns error if repository not found or deletion fails.
+func DeleteRepository(ctx context.Context, name string, archive bool) error {
+	repo, err := findRepo(name)
+	if err != nil {
+		return repoNotFound(name)
+	}
+	if err := checkUnlocked(repo); err != nil {
+		return err
+	}
+	if repo.IsCloning() {
+		return fmt.Errorf("'%s' is still cloning - wait for it to finish before removing it", name)
+	}
+	root, err := RepoDir(repo.StorageLocationID)
 	if err != nil {
-		return fmt.Errorf("repository not found: %s", name)
+		return err
+	}
+	if err := checkRepoPath(repo, root); err != nil {
+		return err
+	}
+
+	// Missing repositories and failed clones have no working tree to keep
+	var archivePath string
+	var status *RepoStatus
+	if !repo.Missing && !repo.IsCloneFailed() {
+		// Recorded with the deletion; a status that can't be read doesn't stop it
+		status, _ = repoStatus(repo)
+		if archive {
+			if archivePath, err = ArchiveRepository(repo, time.Now()); err != nil {
+				return err
+			}
+		}
 	}
 
 	// Remove from filesystem
-	cmd := fmt.Sprintf("rm -rf %s", repo.LocalPath)
-	if _, err := services.CoderExec(cmd); err != nil {
+	if _, err := repoExecCtx(ctx, "rm", "-rf", "--", repo.LocalPath); err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("deletion of %s was cancelled part way - remove it again to finish", name)
+		}
 		return fmt.Errorf("failed to delete repository files: %w", err)
 	}
 
@@ -206,18 +433,139 @@ func DeleteRepository(name string) error {
 	}
 
 	// Log activity
-	go models.Activities.Insert(&models.Activity{
+	activity := &models.Activity{
 		Type:        "repo_delete",
 		Repository:  name,
 		Description: fmt.Sprintf("Deleted repository %s", name),
 		Author:      "System",
 		Timestamp:   time.Now(),
+		Metadata:    deletionMetadata(archivePath, status),
+	}
+	if archivePath != "" {
+		activity.Description += ", archived to the trash"
+	}
+	go models.Activities.Insert(activity)
+
+	return nil
+}
+
+// Test seams for RenameRepository: renameExec moves directories in the
+// coder container, bounded by OperationTimeout, and saveRenamedRepo
+// persists the renamed record.
+var (
+	renameExec      = operationExec
+	saveRenamedRepo = func(repo *models.Repository) error { return models.Repositories.Update(repo) }
+)
+
+// RenameRepository renames a repository and moves its directory to match,
+// keeping local branches and uncommitted work. The new name follows the
+// same rules as new projects and must be unique ignoring case; changing
+// only the case of a name is allowed. If the record can't be saved after
+// the move, the directory is moved back.
+func RenameRepository(oldName, newName string) error {
+	newName = strings.TrimSpace(newName)
+	if err := ValidateRepoName(newName); err != nil {
+		return err
+	}
+	repo, err := findRepo(oldName)
+	if err != nil {
+		return repoNotFound(oldName)
+	}
+	if err := checkUnlocked(repo); err != nil {
+		return err
+	}
+	// A running clone writes to the old path, and a failed one is retried
+	// there
+	if err := checkCloneReady(repo); err != nil {
+		return err
+	}
+	if newName == repo.Name {
+		return nil
+	}
+	existing, err := models.Repositories.Find("WHERE LOWER(Name) = LOWER(?)", newName)
+	if err == nil && existing != nil && existing.ID != repo.ID {
+		return &repoError{ErrRepoExists, fmt.Sprintf("a repository named '%s' already exists", existing.Name)}
+	}
+
+	if err := renameRepository(repo, newName); err != nil {
+		return err
+	}
+	migrateRepoReferences(oldName, newName)
+
+	go models.Activities.Insert(&models.Activity{
+		Type:        "repo_rename",
+		Repository:  newName,
+		Description: fmt.Sprintf("Renamed repository %s to %s", oldName, newName),
+		Author:      "System",
+		Timestamp:   time.Now(),
 	})
+	return nil
+}
+
+// renameRepository moves repo's directory next to where it is, under
+// newName, and saves the new name and path, moving the directory back if
+// the save fails.
+func renameRepository(repo *models.Repository, newName string) error {
+	oldName, oldPath := repo.Name, repo.LocalPath
+	newPath := filepath.Join(filepath.Dir(oldPath), newName)
 
+	output, err := renameExec(fmt.Sprintf("if [ -e %[2]s ]; then echo '# exists'; exit 1; fi; mv -T %[1]s %[2]s 2>&1",
+		shellQuote(oldPath), shellQuote(newPath)))
+	if err != nil {
+		if strings.TrimSpace(output) == "# exists" {
+			return &repoError{ErrRepoExists, fmt.Sprintf("directory %s already exists - please choose a different name", newName)}
+		}
+		return fmt.Errorf("failed to move repository directory: %s", strings.TrimSpace(output))
+	}
+
+	repo.Name, repo.LocalPath = newName, newPath
+	if err := saveRenamedRepo(repo); err != nil {
+		repo.Name, repo.LocalPath = oldName, oldPath
+		if output, undoErr := renameExec(fmt.Sprintf("mv -T %s %s 2>&1", shellQuote(newPath), shellQuote(oldPath))); undoErr != nil {
+			slog.Error("failed to move repository back after a failed rename", "from", newPath, "to", oldPath, "output", strings.TrimSpace(output))
+			return fmt.Errorf("failed to save the renamed repository, and moving it back failed - its directory is now %s", newPath)
+		}
+		return fmt.Errorf("failed to save the renamed repository: %w", err)
+	}
 	return nil
 }
 
-// parseRepoName extracts a clean repository name from various Git URL formats.
+// migrateRepoReferences points per-repository settings and safety points
+// at a renamed repository. Activities and access records keep the name
+// they were recorded under. Failures are logged; the rename stands.
+func migrateRepoReferences(oldName, newName string) {
+	for _, key := range []func(string) string{safetyDisabledKey, setupStepsKey, dismissedStepsKey, postCloneHooksKey} {
+		setting, err := models.Settings.Find("WHERE Key = ?", key(oldName))
+		if err != nil {
+			continue
+		}
+		// A leftover setting under the new name would leave the key saved twice
+		if stale, err := models.Settings.Find("WHERE Key = ?", key(newName)); err == nil && stale != nil {
+			if err := models.Settings.Delete(stale); err != nil {
+				slog.Error("failed to replace setting", "key", key(newName), "error", err)
+				continue
+			}
+		}
+		setting.Key = key(newName)
+		if err := models.Settings.Update(setting); err != nil {
+			slog.Error("failed to move setting", "from", key(oldName), "to", key(newName), "error", err)
+		}
+	}
+
+	points, err := models.SafetyPoints.Search("WHERE Repository = ?", oldName)
+	if err != nil {
+		slog.Error("failed to load safety points of renamed repository", "name", oldName, "error", err)
+		return
+	}
+	for _, point := range points {
+		point.Repository = newName
+		if err := models.SafetyPoints.Update(point); err != nil {
+			slog.Error("failed to move safety point", "id", point.ID, "name", newName, "error", err)
+		}
+	}
+}
+
+// ParseRepoName extracts a clean repository name from various Git URL formats.
 // Handles:
 //   - HTTPS URLs: https://github.com/user/repo.git → "repo"
 //   - SSH URLs: git@github.com:user/repo.git → "repo"
@@ -225,7 +573,7 @@ func DeleteRepository(name string) error {
 //   - URLs with trailing slashes
 //
 // Returns empty string if URL is invalid or name cannot be extracted.
-func parseRepoName(url string) string {
+func ParseRepoName(url string) string {
 	// Handle empty URL
 	if url == "" {
 		return ""
@@ -1,7 +1,12 @@
 package internal
 
 import (
+	"context"
+	"errors"
+	"strings"
 	"testing"
+	"workbench/models"
+	"workbench/services"
 
 	"github.com/The-Skyscape/devtools/pkg/testutils"
 )
@@ -28,7 +33,159 @@ func TestParseRepoName(t *testing.T) {
 	}
 	
 	for _, tc := range testCases {
-		result := parseRepoName(tc.input)
+		result := ParseRepoName(tc.input)
 		testutils.AssertEqual(t, tc.expected, result)
 	}
-}
\ No newline at end of file
+}
+
+// fakeRename replaces the rename seams, recording commands. Commands
+// containing failOn fail with output; save returns saveErr.
+func fakeRename(t *testing.T, failOn, output string, saveErr error) *[]string {
+	var commands []string
+	originalExec, originalSave := renameExec, saveRenamedRepo
+	renameExec = func(cmd string) (string, error) {
+		commands = append(commands, cmd)
+		if failOn != "" && strings.Contains(cmd, failOn) {
+			return output, errors.New("exit status 1")
+		}
+		return "", nil
+	}
+	saveRenamedRepo = func(*models.Repository) error { return saveErr }
+	t.Cleanup(func() { renameExec, saveRenamedRepo = originalExec, originalSave })
+	return &commands
+}
+
+func TestRenameRepositoryMovesDirectory(t *testing.T) {
+	commands := fakeRename(t, "", "", nil)
+	repo := &models.Repository{Name: "api-servr", LocalPath: "/home/coder/repos/api-servr"}
+
+	testutils.AssertEqual(t, nil, renameRepository(repo, "api-server"))
+	testutils.AssertEqual(t, "api-server", repo.Name)
+	testutils.AssertEqual(t, "/home/coder/repos/api-server", repo.LocalPath)
+	testutils.AssertEqual(t, 1, len(*commands))
+	testutils.AssertEqual(t, true, strings.Contains((*commands)[0], "mv -T '/home/coder/repos/api-servr' '/home/coder/repos/api-server'"))
+}
+
+func TestRenameRepositoryRollsBackFailedSave(t *testing.T) {
+	commands := fakeRename(t, "", "", errors.New("database is locked"))
+	repo := &models.Repository{Name: "api-servr", LocalPath: "/home/coder/repos/api-servr"}
+
+	err := renameRepository(repo, "api-server")
+	testutils.AssertEqual(t, true, err != nil)
+	testutils.AssertEqual(t, "api-servr", repo.Name)
+	testutils.AssertEqual(t, "/home/coder/repos/api-servr", repo.LocalPath)
+	testutils.AssertEqual(t, 2, len(*commands))
+	testutils.AssertEqual(t, "mv -T '/home/coder/repos/api-server' '/home/coder/repos/api-servr' 2>&1", (*commands)[1])
+}
+
+func TestRenameRepositoryReportsFailedRollback(t *testing.T) {
+	fakeRename(t, "mv -T '/home/coder/repos/api-server'", "mv: cannot move", errors.New("database is locked"))
+	repo := &models.Repository{Name: "api-servr", LocalPath: "/home/coder/repos/api-servr"}
+
+	err := renameRepository(repo, "api-server")
+	testutils.AssertEqual(t, true, strings.Contains(err.Error(), "its directory is now /home/coder/repos/api-server"))
+}
+
+func TestRenameRepositoryExistingDirectory(t *testing.T) {
+	fakeRename(t, "mv -T", "# exists\n", nil)
+	repo := &models.Repository{Name: "api-servr", LocalPath: "/home/coder/repos/api-servr"}
+
+	err := renameRepository(repo, "api-server")
+	testutils.AssertEqual(t, true, errors.Is(err, ErrRepoExists))
+	testutils.AssertEqual(t, "api-servr", repo.Name)
+}
+
+func TestRenameRepositoryInvalidName(t *testing.T) {
+	for _, name := range []string{"", "../etc", "team/api", ".hidden", "my repo"} {
+		t.Run(name, func(t *testing.T) {
+			err := RenameRepository("api", name)
+			testutils.AssertEqual(t, true, err != nil && strings.HasPrefix(err.Error(), "invalid repository name"))
+		})
+	}
+}
+
+func TestClassifyPullError(t *testing.T) {
+	exit := errors.New("exit status 1")
+	testCases := []struct {
+		name     string
+		result   *services.ExecResult
+		err      error
+		expected string
+	}{
+		{"conflict", &services.ExecResult{Stdout: "CONFLICT (content): Merge conflict in main.go\n", Stderr: ""}, exit,
+			"merge conflicts detected - resolve manually in VS Code"},
+		{"local changes", &services.ExecResult{Stderr: "error: Your local changes to the following files would be overwritten by merge:\n"}, exit,
+			"uncommitted changes - commit or stash them first"},
+		{"ssh denied", &services.ExecResult{Stderr: "git@github.com: Permission denied (publickey).\n"}, exit,
+			"authentication failed - check your SSH key is added to the git provider"},
+		{"network", &services.ExecResult{Stderr: "fatal: unable to access 'https://github.com/acme/api.git/'\n"}, exit,
+			"network error - check your connection and try again"},
+		{"conflict words in a commit message", &services.ExecResult{Stdout: "Updating 1a2b..3c4d\n fix merge conflict handling\n", Stderr: "fatal: early EOF\n"}, exit,
+			"failed to pull latest changes"},
+		{"timed out", &services.ExecResult{ExitCode: -1}, context.DeadlineExceeded, ErrOperationTimedOut.Error()},
+		{"cancelled", &services.ExecResult{ExitCode: -1}, context.Canceled, ErrOperationCancelled.Error()},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			testutils.AssertEqual(t, tc.expected, classifyPullError(tc.result, tc.err).Error())
+		})
+	}
+}
+
+func TestValidateRepoName(t *testing.T) {
+	for _, name := range []string{"api", "api-server", "my_repo.v2"} {
+		testutils.AssertEqual(t, nil, ValidateRepoName(name))
+	}
+	for _, name := range []string{"", "foo; rm -rf /home/coder", "$(reboot)", "`id`", "a|b", "../etc", "-rf", ".git", "my repo", "a\nb"} {
+		t.Run(name, func(t *testing.T) {
+			testutils.AssertEqual(t, true, errors.Is(ValidateRepoName(name), ErrInvalidInput))
+		})
+	}
+}
+
+func TestCloneRejectsMaliciousInput(t *testing.T) {
+	testCases := []struct {
+		url  string
+		name string
+	}{
+		{"https://github.com/acme/api.git", "foo; rm -rf /home/coder"},
+		{"https://github.com/acme/`reboot`.git", ""},
+		{"https://github.com/acme/api.git; rm -rf ~", ""},
+		{"git@github.com:acme/$(id).git", ""},
+		{"--upload-pack=touch /tmp/pwned", "api"},
+		{"https://github.com/acme/--upload-pack", ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.url, func(t *testing.T) {
+			_, err := Clone(context.Background(), CloneOptions{URL: tc.url, Name: tc.name})
+			testutils.AssertEqual(t, true, errors.Is(err, ErrInvalidInput))
+		})
+	}
+}
+
+func TestCheckRepoPath(t *testing.T) {
+	testCases := []struct {
+		path string
+		ok   bool
+	}{
+		{"/home/coder/repos/api", true},
+		{"/home/coder/repos/team/api", true},
+		{"/home/coder/repos", false},
+		{"/home/coder/repos/", false},
+		{"/home/coder/repos/..", false},
+		{"/home/coder/repos/../.ssh", false},
+		{"/home/coder", false},
+		{"/home/coder/repos-old/api", false},
+		{"repos/api", false},
+		{"", false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.path, func(t *testing.T) {
+			err := checkRepoPath(&models.Repository{LocalPath: tc.path}, DefaultReposDir)
+			testutils.AssertEqual(t, tc.ok, err == nil)
+		})
+	}
+}
@@ -0,0 +1,257 @@
+package internal
+
+import (
+	"fmt"
+	"log/slog"
+	"path"
+	"sort"
+	"strings"
+	"time"
+	"workbench/models"
+)
+
+// Safety point retention.
+const (
+	MaxSafetyPoints     = 10                 // Newest points kept per repository
+	SafetyPointLifetime = 7 * 24 * time.Hour // Expiry after an uneventful operation
+)
+
+// Safety point kinds.
+const (
+	SafetyStash = "stash" // Stash commit recorded in the stash list
+	SafetyDiff  = "diff"  // Binary diff stored under .git/workbench-safety/
+)
+
+// safetyDir holds diff snapshots, relative to the repository root.
+const safetyDir = ".git/workbench-safety"
+
+// safetyExec runs git commands in the Coder container, bounded by
+// OperationTimeout since stashing or patching a large working tree, and
+// the git operations run under a safety point, can take minutes.
+// Replaced in tests.
+var safetyExec = operationExec
+
+// SafetyEnabled reports whether safety points are captured for a
+// repository. On by default; can be turned off for huge working trees
+// where stashing is slow.
+func SafetyEnabled(repoName string) bool {
+	value, _ := models.GetSetting(safetyDisabledKey(repoName))
+	return value != "true"
+}
+
+// SetSafetyEnabled turns safety points on or off for a repository.
+func SetSafetyEnabled(repoName string, enabled bool) error {
+	if _, err := models.SetSetting(safetyDisabledKey(repoName), fmt.Sprint(!enabled), "safety"); err != nil {
+		return fmt.Errorf("failed to save safety setting")
+	}
+	return nil
+}
+
+func safetyDisabledKey(repoName string) string {
+	return "safety_disabled:" + repoName
+}
+
+// WithSafetyPoint runs a potentially destructive git operation on repo,
+// capturing uncommitted work first. Capture failures are logged and don't
+// block the operation. When the operation succeeds its safety point
+// expires after SafetyPointLifetime; otherwise it is kept until pruned.
+func WithSafetyPoint(repo *models.Repository, operation string, run func() error) error {
+	capture := func() (*models.SafetyPoint, error) {
+		return captureSafetyPoint(repo, operation, time.Now().UTC())
+	}
+	expire := func(point *models.SafetyPoint) {
+		point.ExpiresAt = point.Timestamp.Add(SafetyPointLifetime)
+		if err := models.SafetyPoints.Update(point); err != nil {
+			slog.Error("failed to update safety point", "name", repo.Name, "error", err)
+		}
+	}
+
+	err := runWithSafetyPoint(repo.Name, operation, capture, run, expire)
+	pruneSafetyPoints(repo, time.Now().UTC())
+	return err
+}
+
+// runWithSafetyPoint captures, runs the operation, and expires the point
+// only when the operation succeeded.
+func runWithSafetyPoint(repoName, operation string, capture func() (*models.SafetyPoint, error), run func() error, expire func(*models.SafetyPoint)) error {
+	point, err := capture()
+	if err != nil {
+		slog.Error("failed to capture safety point", "name", repoName, "operation", operation, "error", err)
+	}
+
+	runErr := run()
+	if point != nil && runErr == nil {
+		expire(point)
+	}
+	return runErr
+}
+
+// stashScript builds a stash-shaped commit like `git stash -u` would,
+// without touching the working tree or the stash list: `git stash create`
+// covers tracked changes, and untracked (non-ignored) files are added as a
+// third parent through a temporary index. Prints the commit hash, or
+// nothing for a clean tree.
+const stashScript = `w=$(git stash create %[1]s) || exit 1
+if [ -n "$(git ls-files --others --exclude-standard)" ]; then
+  tmp=$(mktemp) || exit 1
+  GIT_INDEX_FILE=$tmp git read-tree --empty &&
+    git ls-files -z --others --exclude-standard | GIT_INDEX_FILE=$tmp xargs -0 git add -f -- &&
+    u=$(git commit-tree -m "untracked files" $(GIT_INDEX_FILE=$tmp git write-tree))
+  rm -f $tmp
+  [ -n "$u" ] || exit 1
+  if [ -z "$w" ]; then
+    i=$(git commit-tree -p HEAD -m index HEAD^{tree}) &&
+      w=$(git commit-tree -p HEAD -p $i -p $u -m %[1]s HEAD^{tree}) || exit 1
+  else
+    w=$(git commit-tree -p HEAD -p $w^2 -p $u -m %[1]s $w^{tree}) || exit 1
+  fi
+fi
+echo $w`
+
+// diffScript writes every uncommitted change, untracked files included,
+// as a binary diff against HEAD. A copy of the index is used so staged
+// state (including unresolved conflicts) is left as it was.
+const diffScript = `mkdir -p %[1]s && tmp=$(mktemp) && cp .git/index $tmp &&
+  GIT_INDEX_FILE=$tmp git add -A && GIT_INDEX_FILE=$tmp git diff --cached --binary HEAD > %[2]s
+rm -f $tmp
+test -s %[2]s || { rm -f %[2]s; exit 1; }`
+
+// captureSafetyPoint snapshots uncommitted work, untracked files included.
+// It prefers a stash entry, which leaves the working tree untouched; when
+// a stash can't be created (e.g. during an unfinished merge) it falls back
+// to a binary diff. Returns nil when there is nothing to capture.
+func captureSafetyPoint(repo *models.Repository, operation string, now time.Time) (*models.SafetyPoint, error) {
+	if !SafetyEnabled(repo.Name) {
+		return nil, nil
+	}
+
+	point := &models.SafetyPoint{Repository: repo.Name, Operation: operation, Timestamp: now}
+	message := shellQuote(fmt.Sprintf("workbench safety: before %s at %s", operation, now.Format(time.RFC3339)))
+
+	output, err := safetyExec(fmt.Sprintf("cd %s && %s", shellQuote(repo.LocalPath), fmt.Sprintf(stashScript, message)))
+	if sha := strings.TrimSpace(output); err == nil {
+		if sha == "" {
+			return nil, nil // Clean working tree
+		}
+		if _, err := safetyExec(fmt.Sprintf("cd %s && git stash store -m %s %s", shellQuote(repo.LocalPath), message, shellQuote(sha))); err != nil {
+			return nil, fmt.Errorf("failed to store stash")
+		}
+		point.Kind, point.Ref = SafetyStash, sha
+	} else {
+		file := path.Join(safetyDir, fmt.Sprintf("%s-%d.diff", operation, now.UnixNano()))
+		if _, err := safetyExec(fmt.Sprintf("cd %s && %s", shellQuote(repo.LocalPath), fmt.Sprintf(diffScript, shellQuote(safetyDir), shellQuote(file)))); err != nil {
+			return nil, nil // No diff to capture
+		}
+		point.Kind, point.Ref = SafetyDiff, file
+	}
+
+	return models.SafetyPoints.Insert(point)
+}
+
+// SafetyPoints returns a repository's safety points, newest first.
+func SafetyPoints(repoName string) ([]*models.SafetyPoint, error) {
+	points, err := models.SafetyPoints.Search("WHERE Repository = ? ORDER BY Timestamp DESC", repoName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load safety points")
+	}
+	return points, nil
+}
+
+// RestoreSafetyPoint applies a safety point's changes onto the current
+// working tree. Nothing is forced: if the changes don't apply cleanly the
+// conflicting output is returned and the tree is left untouched.
+func RestoreSafetyPoint(repoName, id string) error {
+	point, err := models.SafetyPoints.Get(id)
+	if err != nil || point.Repository != repoName {
+		return fmt.Errorf("safety point not found")
+	}
+	repo, err := models.Repositories.Find("WHERE Name = ?", repoName)
+	if err != nil {
+		return fmt.Errorf("repository '%s' not found", repoName)
+	}
+
+	patch := safetyPatchCommand(point)
+	if output, err := safetyExec(fmt.Sprintf("cd %s && %s | git apply --check --binary 2>&1", shellQuote(repo.LocalPath), patch)); err != nil {
+		return fmt.Errorf("restore would conflict with the current working tree: %s", strings.TrimSpace(output))
+	}
+	if output, err := safetyExec(fmt.Sprintf("cd %s && %s | git apply --binary 2>&1", shellQuote(repo.LocalPath), patch)); err != nil {
+		return fmt.Errorf("failed to restore safety point: %s", strings.TrimSpace(output))
+	}
+
+	go models.Activities.Insert(&models.Activity{
+		Type:        "safety_restore",
+		Repository:  repoName,
+		Description: fmt.Sprintf("Restored uncommitted work captured before %s on %s", point.Operation, point.Timestamp.Format("Jan 2 15:04")),
+		Author:      "System",
+		Timestamp:   time.Now(),
+	})
+	return nil
+}
+
+// safetyPatchCommand returns a shell command printing a safety point's
+// changes as a patch against the commit it was captured on. Untracked
+// files in a stash's third parent are diffed against the empty tree.
+func safetyPatchCommand(point *models.SafetyPoint) string {
+	if point.Kind == SafetyStash {
+		return fmt.Sprintf("{ git diff --binary %[1]s^1 %[1]s; "+
+			"if git rev-parse -q --verify %[1]s^3 >/dev/null; then git diff --binary $(git hash-object -t tree /dev/null) %[1]s^3; fi; }", shellQuote(point.Ref))
+	}
+	return "cat " + shellQuote(point.Ref)
+}
+
+// planSafetyPrune returns the points to delete: expired points, and any
+// beyond the newest MaxSafetyPoints.
+func planSafetyPrune(points []*models.SafetyPoint, now time.Time) []*models.SafetyPoint {
+	sorted := append([]*models.SafetyPoint(nil), points...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Timestamp.After(sorted[j].Timestamp) })
+
+	var prune []*models.SafetyPoint
+	for i, point := range sorted {
+		expired := !point.ExpiresAt.IsZero() && now.After(point.ExpiresAt)
+		if expired || i >= MaxSafetyPoints {
+			prune = append(prune, point)
+		}
+	}
+	return prune
+}
+
+// pruneSafetyPoints deletes expired and excess safety points along with
+// their stash entries or diff files.
+func pruneSafetyPoints(repo *models.Repository, now time.Time) {
+	points, err := SafetyPoints(repo.Name)
+	if err != nil {
+		return
+	}
+
+	for _, point := range planSafetyPrune(points, now) {
+		if point.Kind == SafetyStash {
+			dropStash(repo, point.Ref)
+		} else {
+			safetyExec(fmt.Sprintf("cd %s && rm -f %s", shellQuote(repo.LocalPath), shellQuote(point.Ref)))
+		}
+		if err := models.SafetyPoints.Delete(point); err != nil {
+			slog.Error("failed to delete safety point", "name", repo.Name, "error", err)
+		}
+	}
+}
+
+// dropStash removes a stash commit from the stash list, if still present.
+func dropStash(repo *models.Repository, sha string) {
+	output, err := safetyExec(fmt.Sprintf("cd %s && git stash list --format=%%H", shellQuote(repo.LocalPath)))
+	if err != nil {
+		return
+	}
+	if i := stashIndex(output, sha); i >= 0 {
+		safetyExec(fmt.Sprintf("cd %s && git stash drop -q stash@{%d}", shellQuote(repo.LocalPath), i))
+	}
+}
+
+// stashIndex finds a stash commit in `git stash list --format=%H` output.
+func stashIndex(list, sha string) int {
+	for i, line := range strings.Split(strings.TrimSpace(list), "\n") {
+		if strings.TrimSpace(line) == sha {
+			return i
+		}
+	}
+	return -1
+}
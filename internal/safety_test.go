@@ -0,0 +1,115 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+	"workbench/models"
+
+	"github.com/The-Skyscape/devtools/pkg/testutils"
+)
+
+func TestPlanSafetyPrune(t *testing.T) {
+	now := time.Date(2025, 1, 15, 12, 0, 0, 0, time.UTC)
+	point := func(id string, age time.Duration, expires time.Duration) *models.SafetyPoint {
+		p := &models.SafetyPoint{Ref: id, Timestamp: now.Add(-age)}
+		if expires != 0 {
+			p.ExpiresAt = now.Add(expires)
+		}
+		return p
+	}
+	refs := func(points []*models.SafetyPoint) string {
+		var ids []string
+		for _, p := range points {
+			ids = append(ids, p.Ref)
+		}
+		return strings.Join(ids, ",")
+	}
+
+	// Expiry
+	points := []*models.SafetyPoint{
+		point("kept-failed", 30*24*time.Hour, 0),
+		point("expired", 8*24*time.Hour, -time.Hour),
+		point("not-yet", time.Hour, SafetyPointLifetime),
+	}
+	testutils.AssertEqual(t, "expired", refs(planSafetyPrune(points, now)))
+
+	// Only the newest MaxSafetyPoints are kept, whatever the input order
+	points = nil
+	for i := MaxSafetyPoints + 2; i > 0; i-- {
+		points = append(points, point(fmt.Sprint(i), time.Duration(i)*time.Minute, 0))
+	}
+	testutils.AssertEqual(t, fmt.Sprintf("%d,%d", MaxSafetyPoints+1, MaxSafetyPoints+2), refs(planSafetyPrune(points, now)))
+}
+
+func TestStashIndex(t *testing.T) {
+	list := "aaa111\nbbb222\nccc333\n"
+
+	testCases := []struct {
+		name     string
+		list     string
+		sha      string
+		expected int
+	}{
+		{"first", list, "aaa111", 0},
+		{"last", list, "ccc333", 2},
+		{"already dropped", list, "ddd444", -1},
+		{"empty stash list", "", "aaa111", -1},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			testutils.AssertEqual(t, tc.expected, stashIndex(tc.list, tc.sha))
+		})
+	}
+}
+
+func TestRunWithSafetyPoint(t *testing.T) {
+	captured := &models.SafetyPoint{Repository: "api", Operation: "pull"}
+	failure := errors.New("merge conflict")
+
+	testCases := []struct {
+		name       string
+		point      *models.SafetyPoint
+		captureErr error
+		runErr     error
+		expired    bool
+	}{
+		{"uneventful operation expires its point", captured, nil, nil, true},
+		{"failed operation keeps its point", captured, nil, failure, false},
+		{"clean tree captures nothing", nil, nil, nil, false},
+		{"capture failure doesn't block the operation", nil, errors.New("stash failed"), nil, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var steps []string
+			capture := func() (*models.SafetyPoint, error) {
+				steps = append(steps, "capture")
+				return tc.point, tc.captureErr
+			}
+			run := func() error {
+				steps = append(steps, "run")
+				return tc.runErr
+			}
+			expired := false
+			expire := func(*models.SafetyPoint) { expired = true }
+
+			err := runWithSafetyPoint("api", "pull", capture, run, expire)
+			testutils.AssertEqual(t, tc.runErr, err)
+			testutils.AssertEqual(t, "capture,run", strings.Join(steps, ","))
+			testutils.AssertEqual(t, tc.expired, expired)
+		})
+	}
+}
+
+func TestSafetyPatchCommand(t *testing.T) {
+	stash := safetyPatchCommand(&models.SafetyPoint{Kind: SafetyStash, Ref: "abc123"})
+	testutils.AssertEqual(t, true, strings.Contains(stash, "git diff --binary abc123^1 abc123"))
+	testutils.AssertEqual(t, true, strings.Contains(stash, "abc123^3"))
+
+	diff := safetyPatchCommand(&models.SafetyPoint{Kind: SafetyDiff, Ref: ".git/workbench-safety/pull-1.diff"})
+	testutils.AssertEqual(t, "cat '.git/workbench-safety/pull-1.diff'", diff)
+}
@@ -0,0 +1,274 @@
+package internal
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"workbench/models"
+	"workbench/services"
+)
+
+// Limits on user-defined scaffold files.
+const (
+	MaxScaffoldFileSize = 64 * 1024
+	MaxScaffoldFiles    = 50
+)
+
+// DefaultBranchName is used when no default branch is configured.
+const DefaultBranchName = "main"
+
+// ScaffoldTemplate is a built-in project template.
+type ScaffoldTemplate struct {
+	ID    string
+	Name  string
+	Files map[string]string // Path to content; {{name}} is replaced with the repository name
+}
+
+// scaffoldTemplates are the built-in templates offered for new projects.
+var scaffoldTemplates = []*ScaffoldTemplate{
+	{ID: "empty", Name: "Empty project", Files: map[string]string{
+		"README.md": "# {{name}}\n",
+	}},
+	{ID: "go", Name: "Go module", Files: map[string]string{
+		"README.md":  "# {{name}}\n",
+		"go.mod":     "module {{name}}\n\ngo 1.22\n",
+		"main.go":    "package main\n\nfunc main() {\n}\n",
+		".gitignore": "/{{name}}\n*.test\n*.out\n",
+	}},
+	{ID: "node", Name: "Node.js package", Files: map[string]string{
+		"README.md":    "# {{name}}\n",
+		"package.json": "{\n  \"name\": \"{{name}}\",\n  \"version\": \"0.1.0\",\n  \"main\": \"index.js\"\n}\n",
+		"index.js":     "",
+		".gitignore":   "node_modules/\n",
+	}},
+}
+
+// ScaffoldEntry is a file that will be written into a new project.
+type ScaffoldEntry struct {
+	Path    string
+	Content string
+	Source  string // "template", "always", or "override"
+}
+
+var (
+	validBranchName = regexp.MustCompile(`^[A-Za-z0-9._/-]+$`)
+	validRepoName   = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+)
+
+func init() {
+	RegisterActivityAction("repo_create", ActivityActionDef{
+		Label:        "Open in VS Code",
+		Method:       ActionLink,
+		RequiresRepo: true,
+		URL: func(a *models.Activity, repo *models.Repository) string {
+			return "/coder/?folder=" + url.QueryEscape(repo.LocalPath)
+		},
+	})
+}
+
+// ScaffoldTemplates returns the built-in project templates.
+func ScaffoldTemplates() []*ScaffoldTemplate {
+	return scaffoldTemplates
+}
+
+// FindScaffoldTemplate returns a built-in template by ID.
+func FindScaffoldTemplate(id string) (*ScaffoldTemplate, error) {
+	for _, t := range scaffoldTemplates {
+		if t.ID == id {
+			return t, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown template '%s'", id)
+}
+
+// DefaultBranch returns the branch name new projects are created on.
+func DefaultBranch() string {
+	return models.SettingOrDefault("scaffold_default_branch")
+}
+
+// SetDefaultBranch stores the default branch for new projects and applies
+// it to git's init.defaultBranch in the coder container. Existing
+// repositories are not changed.
+func SetDefaultBranch(branch string) error {
+	branch = strings.TrimSpace(branch)
+	if !validDefaultBranch(branch) {
+		return fmt.Errorf("invalid branch name")
+	}
+
+	if _, err := models.SetSetting("scaffold_default_branch", branch, "scaffold"); err != nil {
+		return fmt.Errorf("failed to save default branch")
+	}
+	if _, err := services.CoderExec("git config --global init.defaultBranch " + shellQuote(branch)); err != nil {
+		return fmt.Errorf("saved, but failed to update git init.defaultBranch")
+	}
+	return nil
+}
+
+func validDefaultBranch(branch string) bool {
+	return validBranchName.MatchString(branch) && !strings.HasPrefix(branch, "-") && !strings.Contains(branch, "..")
+}
+
+// SaveScaffoldFile adds or replaces an always-include file. An empty
+// templateID applies the file to every template; otherwise it overrides
+// files for that template only.
+func SaveScaffoldFile(filePath, content, templateID string) error {
+	filePath, err := cleanRepoPath(filePath)
+	if err != nil {
+		return err
+	}
+	if templateID != "" {
+		if _, err := FindScaffoldTemplate(templateID); err != nil {
+			return err
+		}
+	}
+	content = matchLineEndings(content, false)
+	if len(content) > MaxScaffoldFileSize {
+		return fmt.Errorf("file is larger than %d KB", MaxScaffoldFileSize/1024)
+	}
+
+	existing, err := models.ScaffoldFiles.Find("WHERE Path = ? AND Template = ?", filePath, templateID)
+	if err == nil && existing != nil && existing.Path != "" {
+		existing.Content = content
+		return models.ScaffoldFiles.Update(existing)
+	}
+
+	if models.ScaffoldFiles.Count("") >= MaxScaffoldFiles {
+		return fmt.Errorf("at most %d scaffold files can be configured", MaxScaffoldFiles)
+	}
+	_, err = models.ScaffoldFiles.Insert(&models.ScaffoldFile{Path: filePath, Content: content, Template: templateID})
+	return err
+}
+
+// DeleteScaffoldFile removes an always-include file.
+func DeleteScaffoldFile(id string) error {
+	file, err := models.ScaffoldFiles.Get(id)
+	if err != nil {
+		return fmt.Errorf("scaffold file not found")
+	}
+	return models.ScaffoldFiles.Delete(file)
+}
+
+// ScaffoldPreview returns the files a new project would contain for the
+// given template, using "example" as the repository name.
+func ScaffoldPreview(templateID string) ([]*ScaffoldEntry, error) {
+	return scaffoldEntries(templateID, "example")
+}
+
+func scaffoldEntries(templateID, repoName string) ([]*ScaffoldEntry, error) {
+	tmpl, err := FindScaffoldTemplate(templateID)
+	if err != nil {
+		return nil, err
+	}
+	userFiles, err := models.ScaffoldFiles.Search("ORDER BY Path ASC")
+	if err != nil {
+		return nil, fmt.Errorf("failed to load scaffold files")
+	}
+	return mergeScaffoldFiles(tmpl, userFiles, repoName), nil
+}
+
+// mergeScaffoldFiles combines a template's files with the user's files.
+// Always-include files replace template files, and per-template overrides
+// replace both. Entries are sorted by path.
+func mergeScaffoldFiles(tmpl *ScaffoldTemplate, userFiles []*models.ScaffoldFile, repoName string) []*ScaffoldEntry {
+	files := map[string]*ScaffoldEntry{}
+	for p, content := range tmpl.Files {
+		files[p] = &ScaffoldEntry{Path: p, Content: strings.ReplaceAll(content, "{{name}}", repoName), Source: "template"}
+	}
+	for _, f := range userFiles {
+		if f.Template == "" {
+			files[f.Path] = &ScaffoldEntry{Path: f.Path, Content: f.Content, Source: "always"}
+		}
+	}
+	for _, f := range userFiles {
+		if f.Template == tmpl.ID {
+			files[f.Path] = &ScaffoldEntry{Path: f.Path, Content: f.Content, Source: "override"}
+		}
+	}
+
+	entries := make([]*ScaffoldEntry, 0, len(files))
+	for _, entry := range files {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+	return entries
+}
+
+// CreateRepositoryFromTemplate creates a new local repository from a
+// built-in template merged with the user's scaffold files, and commits
+// it on the configured default branch.
+func CreateRepositoryFromTemplate(name, templateID string) error {
+	name = strings.TrimSpace(name)
+	if err := ValidateRepoName(name); err != nil {
+		return err
+	}
+
+	existing, err := models.Repositories.Find("WHERE LOWER(Name) = LOWER(?)", name)
+	if err == nil && existing != nil && existing.Name != "" {
+		return fmt.Errorf("a repository named '%s' already exists", existing.Name)
+	}
+
+	entries, err := scaffoldEntries(templateID, name)
+	if err != nil {
+		return err
+	}
+
+	targetDir := path.Join("/home/coder/repos", name)
+	branch := DefaultBranch()
+	if err := writeScaffold(name, targetDir, branch, entries); err != nil {
+		return err
+	}
+
+	// No URL marks the repository local-only until it is exported
+	if _, err := models.Repositories.Insert(&models.Repository{Name: name, LocalPath: targetDir, Host: OtherHost}); err != nil {
+		return fmt.Errorf("failed to save repository: %w", err)
+	}
+
+	go models.Activities.Insert(&models.Activity{
+		Type:        "repo_create",
+		Repository:  name,
+		Description: fmt.Sprintf("Created repository %s from the %s template on %s", name, templateID, branch),
+		Author:      "System",
+		Timestamp:   time.Now(),
+	})
+
+	return nil
+}
+
+// scaffoldExec runs a command in the coder container. Replaced in tests.
+var scaffoldExec = services.CoderExecWithOptions
+
+// writeScaffold initializes a repository in targetDir on branch, writes
+// the entries with LF line endings, and commits them. The directory is
+// removed if any step after creating it fails.
+func writeScaffold(name, targetDir, branch string, entries []*ScaffoldEntry) error {
+	dir := shellQuote(targetDir)
+	exists, _ := scaffoldExec(fmt.Sprintf("test -e %s && echo exists", dir), services.ExecOptions{})
+	if strings.TrimSpace(exists.Output) == "exists" {
+		return fmt.Errorf("directory %s already exists - please choose a different name", name)
+	}
+
+	if _, err := scaffoldExec(fmt.Sprintf("mkdir -p %s && cd %s && git init -q -b %s", dir, dir, shellQuote(branch)), services.ExecOptions{}); err != nil {
+		return fmt.Errorf("failed to initialize repository")
+	}
+
+	for _, entry := range entries {
+		file := path.Join(targetDir, entry.Path)
+		cmd := fmt.Sprintf("mkdir -p %s && cat > %s", shellQuote(path.Dir(file)), shellQuote(file))
+		content := matchLineEndings(entry.Content, false)
+		if _, err := scaffoldExec(cmd, services.ExecOptions{Stdin: strings.NewReader(content)}); err != nil {
+			scaffoldExec("rm -rf "+dir, services.ExecOptions{})
+			return fmt.Errorf("failed to write %s", entry.Path)
+		}
+	}
+
+	result, err := scaffoldExec(fmt.Sprintf("cd %s && git add -A && git commit -q -m 'Initial commit' 2>&1", dir), services.ExecOptions{})
+	if err != nil {
+		scaffoldExec("rm -rf "+dir, services.ExecOptions{})
+		return fmt.Errorf("failed to create initial commit: %s", strings.TrimSpace(result.Output))
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package internal
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"workbench/models"
+	"workbench/services"
+
+	"github.com/The-Skyscape/devtools/pkg/testutils"
+)
+
+func entrySummary(entries []*ScaffoldEntry) string {
+	var parts []string
+	for _, e := range entries {
+		parts = append(parts, e.Path+"="+e.Source)
+	}
+	return strings.Join(parts, " ")
+}
+
+func TestMergeScaffoldFiles(t *testing.T) {
+	goTemplate, _ := FindScaffoldTemplate("go")
+	nodeTemplate, _ := FindScaffoldTemplate("node")
+
+	userFiles := []*models.ScaffoldFile{
+		{Path: "LICENSE", Content: "MIT"},
+		{Path: ".editorconfig", Content: "root = true"},
+		{Path: "README.md", Content: "# Company project"},
+		{Path: ".gitignore", Content: "bin/", Template: "go"},
+	}
+
+	testCases := []struct {
+		name     string
+		tmpl     *ScaffoldTemplate
+		expected string
+	}{
+		{"go with override", goTemplate,
+			".editorconfig=always .gitignore=override LICENSE=always README.md=always go.mod=template main.go=template"},
+		{"node without override", nodeTemplate,
+			".editorconfig=always .gitignore=template LICENSE=always README.md=always index.js=template package.json=template"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			testutils.AssertEqual(t, tc.expected, entrySummary(mergeScaffoldFiles(tc.tmpl, userFiles, "demo")))
+		})
+	}
+}
+
+func TestMergeScaffoldFilesOverrideWinsOverAlways(t *testing.T) {
+	tmpl, _ := FindScaffoldTemplate("empty")
+	userFiles := []*models.ScaffoldFile{
+		{Path: "LICENSE", Content: "Apache", Template: "empty"},
+		{Path: "LICENSE", Content: "MIT"},
+	}
+
+	entries := mergeScaffoldFiles(tmpl, userFiles, "demo")
+	testutils.AssertEqual(t, "LICENSE=override README.md=template", entrySummary(entries))
+	testutils.AssertEqual(t, "Apache", entries[0].Content)
+}
+
+func TestMergeScaffoldFilesSubstitutesName(t *testing.T) {
+	tmpl, _ := FindScaffoldTemplate("go")
+	for _, e := range mergeScaffoldFiles(tmpl, nil, "demo") {
+		if e.Path == "go.mod" {
+			testutils.AssertEqual(t, "module demo\n\ngo 1.22\n", e.Content)
+		}
+	}
+}
+
+func TestFindScaffoldTemplateUnknown(t *testing.T) {
+	_, err := FindScaffoldTemplate("rust")
+	testutils.AssertEqual(t, "unknown template 'rust'", err.Error())
+}
+
+// fakeScaffoldExec records commands, and the stdin written for each, in
+// place of the coder container. fail makes matching commands fail.
+func fakeScaffoldExec(t *testing.T, fail string) *[]string {
+	var commands []string
+	original := scaffoldExec
+	scaffoldExec = func(command string, opts services.ExecOptions) (*services.ExecResult, error) {
+		if opts.Stdin != nil {
+			stdin, _ := io.ReadAll(opts.Stdin)
+			command += " <<< " + strings.ReplaceAll(string(stdin), "\r", "\\r")
+		}
+		commands = append(commands, command)
+		if fail != "" && strings.Contains(command, fail) {
+			return &services.ExecResult{Output: "boom"}, errors.New("exit status 1")
+		}
+		return &services.ExecResult{}, nil
+	}
+	t.Cleanup(func() { scaffoldExec = original })
+	return &commands
+}
+
+func TestWriteScaffold(t *testing.T) {
+	commands := fakeScaffoldExec(t, "")
+	entries := []*ScaffoldEntry{
+		{Path: "README.md", Content: "# demo\r\nhello\r\n"},
+		{Path: "cmd/demo/main.go", Content: "package main\n"},
+	}
+
+	err := writeScaffold("demo", "/home/coder/repos/demo", "trunk", entries)
+	testutils.AssertEqual(t, nil, err)
+	testutils.AssertEqual(t, strings.Join([]string{
+		"test -e '/home/coder/repos/demo' && echo exists",
+		"mkdir -p '/home/coder/repos/demo' && cd '/home/coder/repos/demo' && git init -q -b 'trunk'",
+		"mkdir -p '/home/coder/repos/demo' && cat > '/home/coder/repos/demo/README.md' <<< # demo\nhello\n",
+		"mkdir -p '/home/coder/repos/demo/cmd/demo' && cat > '/home/coder/repos/demo/cmd/demo/main.go' <<< package main\n",
+		"cd '/home/coder/repos/demo' && git add -A && git commit -q -m 'Initial commit' 2>&1",
+	}, "\n"), strings.Join(*commands, "\n"))
+}
+
+func TestWriteScaffoldRemovesDirectoryOnFailure(t *testing.T) {
+	commands := fakeScaffoldExec(t, "git commit")
+
+	err := writeScaffold("demo", "/home/coder/repos/demo", "main", []*ScaffoldEntry{{Path: "README.md"}})
+	testutils.AssertEqual(t, "failed to create initial commit: boom", err.Error())
+	testutils.AssertEqual(t, "rm -rf '/home/coder/repos/demo'", (*commands)[len(*commands)-1])
+}
+
+func TestScaffoldSettingsValidation(t *testing.T) {
+	testCases := []struct {
+		name     string
+		err      error
+		expected string
+	}{
+		{"branch with dots", SetDefaultBranch("a..b"), "invalid branch name"},
+		{"branch with option", SetDefaultBranch("-f"), "invalid branch name"},
+		{"file inside .git", SaveScaffoldFile(".git/hooks/post-commit", "echo hi", ""), "files inside .git can't be edited"},
+		{"file inside nested .GIT", SaveScaffoldFile("sub/.GIT/config", "", ""), "files inside .git can't be edited"},
+		{"unknown template", SaveScaffoldFile("LICENSE", "MIT", "rust"), "unknown template 'rust'"},
+		{"file too large", SaveScaffoldFile("big.txt", strings.Repeat("x", MaxScaffoldFileSize+1), ""), "file is larger than 64 KB"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.err == nil {
+				t.Fatal("expected an error")
+			}
+			testutils.AssertEqual(t, tc.expected, tc.err.Error())
+		})
+	}
+}
@@ -0,0 +1,472 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"runtime"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+	"workbench/models"
+)
+
+// Priority classes for background jobs. When more jobs are due than there
+// are workers, higher classes start first.
+const (
+	PriorityHigh   = "high"
+	PriorityNormal = "normal"
+	PriorityLow    = "low"
+)
+
+var priorityRank = map[string]int{PriorityHigh: 0, PriorityNormal: 1, PriorityLow: 2}
+
+// Background job states shown in the jobs panel.
+const (
+	JobIdle    = "idle"
+	JobQueued  = "queued" // Due, waiting for a free worker
+	JobRunning = "running"
+	JobPaused  = "paused"
+	JobOff     = "off" // Interval is zero, e.g. auto-pull turned off
+)
+
+// schedulerTick is how often the scheduler looks for due jobs.
+const schedulerTick = 10 * time.Second
+
+// maxBackgroundWorkers caps the default worker pool on large machines;
+// background jobs mostly wait on git and the database, not the CPU.
+const maxBackgroundWorkers = 4
+
+// JobSpec describes a periodic background job.
+type JobSpec struct {
+	Name        string
+	Description string
+	Interval    func() time.Duration // Read on every tick; zero turns the job off
+	Jitter      time.Duration        // Each run is delayed by a random amount below this
+	Priority    string
+	Immediate   bool // First run on the first tick rather than one interval after start
+	Unpausable  bool // Keeps the workbench safe, so the jobs panel can't pause it
+	Run         func(now time.Time) error
+}
+
+// Every returns a fixed JobSpec.Interval.
+func Every(d time.Duration) func() time.Duration {
+	return func() time.Duration { return d }
+}
+
+// backgroundJob is a registered job and what the scheduler knows of it.
+type backgroundJob struct {
+	spec JobSpec
+
+	scheduled time.Time     // Last time a run was due, or when the scheduler started
+	delay     time.Duration // Jitter drawn for the next run
+	queued    bool
+	running   bool
+	paused    bool
+	loop      bool // Runs on its own timer, reporting through a JobHandle
+
+	lastRun      time.Time
+	lastDuration time.Duration
+	lastError    string
+	failures     int // Runs in a row that returned an error
+	runs         int
+	overruns     int
+}
+
+// JobStatus is a point-in-time copy of a background job for rendering.
+type JobStatus struct {
+	Name         string
+	Description  string
+	Priority     string
+	Interval     time.Duration
+	Jitter       time.Duration
+	State        string
+	Paused       bool      // Scheduled runs are skipped; may still be running
+	LastRun      time.Time // Zero until the first run
+	LastDuration time.Duration
+	LastError    string
+	Failures     int       // Runs in a row that returned an error
+	NextRun      time.Time // Zero while off or paused
+	Runs         int
+	Overruns     int  // Runs skipped because the previous one was still going
+	Loop         bool // Runs on its own timer, so it can't be run now
+	Unpausable   bool // Can't be paused
+}
+
+// MarshalJSON writes durations as seconds and milliseconds, for GET
+// /api/jobs.
+func (s *JobStatus) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Name           string    `json:"name"`
+		Description    string    `json:"description"`
+		Priority       string    `json:"priority,omitempty"`
+		IntervalSecs   float64   `json:"interval_seconds"`
+		State          string    `json:"state"`
+		Paused         bool      `json:"paused"`
+		LastRun        time.Time `json:"last_run,omitzero"`
+		LastDurationMS int64     `json:"last_duration_ms"`
+		LastError      string    `json:"last_error,omitempty"`
+		Failures       int       `json:"consecutive_failures"`
+		NextRun        time.Time `json:"next_run,omitzero"`
+		Runs           int       `json:"runs"`
+		Overruns       int       `json:"overruns"`
+	}{
+		s.Name, s.Description, s.Priority, s.Interval.Seconds(), s.State, s.Paused,
+		s.LastRun, s.LastDuration.Milliseconds(), s.LastError, s.Failures, s.NextRun, s.Runs, s.Overruns,
+	})
+}
+
+// Failed reports whether the last run returned an error.
+func (s *JobStatus) Failed() bool {
+	return s.LastError != ""
+}
+
+// Schedule describes the interval and jitter, e.g. "every 1h0m0s, up to 5m0s later".
+func (s *JobStatus) Schedule() string {
+	if s.Interval == 0 {
+		return "off"
+	}
+	if s.Jitter > 0 {
+		return fmt.Sprintf("every %s, up to %s later", s.Interval, s.Jitter)
+	}
+	return fmt.Sprintf("every %s", s.Interval)
+}
+
+// scheduler runs registered jobs on a bounded worker pool.
+type scheduler struct {
+	mu      sync.Mutex
+	jobs    []*backgroundJob // Registration order
+	workers int
+	busy    int
+	started time.Time
+
+	// Replaced in tests
+	now        func() time.Time
+	jitter     func(limit time.Duration) time.Duration
+	skip       func() bool // Whether due runs wait, e.g. in protective mode
+	loadPaused func(name string) bool
+	savePaused func(name string, paused bool) error
+	wg         sync.WaitGroup // Running jobs, for tests
+}
+
+var jobs = newScheduler()
+
+func newScheduler() *scheduler {
+	return &scheduler{
+		workers:    defaultBackgroundWorkers(),
+		now:        time.Now,
+		jitter:     randomJitter,
+		skip:       BackgroundJobsPaused,
+		loadPaused: func(name string) bool { return models.GetBoolSetting(jobPausedKey(name)) },
+		savePaused: func(name string, paused bool) error {
+			_, err := models.SetSetting(jobPausedKey(name), fmt.Sprint(paused), "background_job")
+			return err
+		},
+	}
+}
+
+func jobPausedKey(name string) string {
+	return "job_paused:" + name
+}
+
+func defaultBackgroundWorkers() int {
+	return min(max(runtime.NumCPU()/2, 1), maxBackgroundWorkers)
+}
+
+// randomJitter returns a delay in [0, limit).
+func randomJitter(limit time.Duration) time.Duration {
+	if limit <= 0 {
+		return 0
+	}
+	return rand.N(limit)
+}
+
+// RegisterJob adds a periodic job to the background scheduler. Jobs are
+// registered from init functions and start running with StartScheduler.
+func RegisterJob(spec JobSpec) {
+	jobs.register(spec)
+}
+
+func (s *scheduler) register(spec JobSpec) {
+	if spec.Priority == "" {
+		spec.Priority = PriorityNormal
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jobs = slices.DeleteFunc(s.jobs, func(j *backgroundJob) bool { return j.spec.Name == spec.Name })
+	s.jobs = append(s.jobs, &backgroundJob{spec: spec})
+}
+
+// StartScheduler runs registered background jobs on a pool of
+// background_workers workers, restoring jobs paused from the jobs panel.
+// Runs until the process exits.
+func StartScheduler() {
+	jobs.start(models.GetIntSetting("background_workers"))
+	ticker := time.NewTicker(schedulerTick)
+	for range ticker.C {
+		jobs.tick()
+	}
+}
+
+func (s *scheduler) start(workers int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if workers > 0 {
+		s.workers = workers
+	}
+	s.started = s.now()
+	for _, job := range s.jobs {
+		job.paused = !job.spec.Unpausable && s.loadPaused(job.spec.Name)
+		job.scheduled = s.started
+		if job.spec.Immediate {
+			job.scheduled = time.Time{}
+		}
+		job.delay = s.jitter(job.spec.Jitter)
+	}
+}
+
+// tick queues every due job and starts queued jobs while workers are free.
+// A job still running from its last run is skipped for this one.
+func (s *scheduler) tick() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	if s.skip() {
+		return
+	}
+	for _, job := range s.jobs {
+		interval := job.spec.Interval()
+		if job.loop || interval <= 0 || now.Before(job.scheduled.Add(interval+job.delay)) {
+			continue
+		}
+		job.scheduled = now
+		job.delay = s.jitter(job.spec.Jitter)
+		switch {
+		case job.paused:
+		case job.running || job.queued:
+			job.overruns++
+			slog.Warn("background job is still running, skipping this run", "job", job.spec.Name, "started", job.lastRun.Format(time.RFC3339))
+		default:
+			job.queued = true
+		}
+	}
+	s.dispatch()
+}
+
+// dispatch starts queued jobs, highest priority first, until every worker
+// is busy. Callers hold s.mu.
+func (s *scheduler) dispatch() {
+	queued := slices.DeleteFunc(slices.Clone(s.jobs), func(j *backgroundJob) bool { return !j.queued })
+	sort.SliceStable(queued, func(a, b int) bool {
+		return priorityRank[queued[a].spec.Priority] < priorityRank[queued[b].spec.Priority]
+	})
+	for _, job := range queued {
+		if s.busy >= s.workers {
+			return
+		}
+		s.busy++
+		job.queued, job.running = false, true
+		job.lastRun = s.now()
+		s.wg.Add(1)
+		go s.run(job, job.lastRun)
+	}
+}
+
+func (s *scheduler) run(job *backgroundJob, started time.Time) {
+	defer s.wg.Done()
+	err := job.spec.Run(started)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.busy--
+	s.finish(job, err)
+	s.dispatch()
+}
+
+// finish records the outcome of a job's run. A loop's failures are only
+// logged when a run of them starts, as loops run often. Callers hold s.mu.
+func (s *scheduler) finish(job *backgroundJob, err error) {
+	job.running = false
+	job.runs++
+	job.lastDuration = s.now().Sub(job.lastRun)
+	job.lastError = ""
+	if err == nil {
+		job.failures = 0
+		return
+	}
+	job.lastError = err.Error()
+	job.failures++
+	if !job.loop || job.failures == 1 {
+		slog.Error("background job failed", "job", job.spec.Name, "error", err)
+	}
+}
+
+// JobHandle reports the runs of a loop job, one that keeps its own timer
+// because it runs more often than the scheduler ticks or must keep
+// running in protective mode.
+type JobHandle struct {
+	s   *scheduler
+	job *backgroundJob
+}
+
+// RegisterLoop adds a job that runs on its own timer to the jobs panel.
+// The loop calls RunStarted before each run, skipping the run when it
+// returns false because the job is paused, and RunFinished after it.
+func RegisterLoop(name, description string, interval time.Duration) *JobHandle {
+	return jobs.registerLoop(JobSpec{Name: name, Description: description, Interval: Every(interval)})
+}
+
+// RegisterUnpausableLoop is RegisterLoop for a loop the workbench's
+// safety depends on, such as the volume watchdog that enters protective
+// mode. It can't be paused, and a pause saved before it became
+// unpausable is ignored.
+func RegisterUnpausableLoop(name, description string, interval time.Duration) *JobHandle {
+	return jobs.registerLoop(JobSpec{Name: name, Description: description, Interval: Every(interval), Unpausable: true})
+}
+
+func (s *scheduler) registerLoop(spec JobSpec) *JobHandle {
+	s.register(spec)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, _ := s.find(spec.Name)
+	job.loop = true
+	job.paused = !spec.Unpausable && s.loadPaused(spec.Name)
+	return &JobHandle{s: s, job: job}
+}
+
+// RunStarted records the start of a run. Returns false, recording
+// nothing, while the job is paused.
+func (h *JobHandle) RunStarted() bool {
+	h.s.mu.Lock()
+	defer h.s.mu.Unlock()
+	if h.job.paused {
+		return false
+	}
+	h.job.running = true
+	h.job.lastRun = h.s.now()
+	return true
+}
+
+// RunFinished records the end of the run RunStarted began and its error,
+// if any.
+func (h *JobHandle) RunFinished(err error) {
+	h.s.mu.Lock()
+	defer h.s.mu.Unlock()
+	h.s.finish(h.job, err)
+}
+
+func (s *scheduler) find(name string) (*backgroundJob, error) {
+	for _, job := range s.jobs {
+		if job.spec.Name == name {
+			return job, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown background job '%s'", name)
+}
+
+// RunJobNow queues a background job to run as soon as a worker is free,
+// even if it is paused. Its schedule is unchanged.
+func RunJobNow(name string) error {
+	return jobs.runNow(name)
+}
+
+func (s *scheduler) runNow(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, err := s.find(name)
+	if err != nil {
+		return err
+	}
+	if job.loop {
+		return fmt.Errorf("%s runs on its own every %s and can't be run now", name, job.spec.Interval())
+	}
+	if job.running || job.queued {
+		return fmt.Errorf("%s is already running", name)
+	}
+	job.queued = true
+	s.dispatch()
+	return nil
+}
+
+// SetJobPaused pauses or resumes a background job's scheduled runs. The
+// choice is saved, so a paused job stays paused across restarts. A run in
+// progress is not interrupted. Unpausable jobs can't be paused.
+func SetJobPaused(name string, paused bool) error {
+	return jobs.setPaused(name, paused)
+}
+
+func (s *scheduler) setPaused(name string, paused bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, err := s.find(name)
+	if err != nil {
+		return err
+	}
+	if paused && job.spec.Unpausable {
+		return fmt.Errorf("%s keeps the workbench safe and can't be paused", name)
+	}
+	if err := s.savePaused(name, paused); err != nil {
+		return fmt.Errorf("failed to save job state")
+	}
+	job.paused = paused
+	return nil
+}
+
+// GetJobStatuses returns every registered background job in registration order.
+func GetJobStatuses() []*JobStatus {
+	return jobs.statuses()
+}
+
+func (s *scheduler) statuses() []*JobStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var result []*JobStatus
+	for _, job := range s.jobs {
+		status := &JobStatus{
+			Name:         job.spec.Name,
+			Description:  job.spec.Description,
+			Priority:     job.spec.Priority,
+			Interval:     job.spec.Interval(),
+			Jitter:       job.spec.Jitter,
+			State:        JobIdle,
+			Paused:       job.paused,
+			LastRun:      job.lastRun,
+			LastDuration: job.lastDuration.Round(time.Millisecond),
+			LastError:    job.lastError,
+			Failures:     job.failures,
+			Runs:         job.runs,
+			Overruns:     job.overruns,
+			Loop:         job.loop,
+			Unpausable:   job.spec.Unpausable,
+		}
+		if job.loop {
+			status.Priority = ""
+		}
+		switch {
+		case job.running:
+			status.State = JobRunning
+		case job.queued:
+			status.State = JobQueued
+		case job.paused:
+			status.State = JobPaused
+		case status.Interval <= 0:
+			status.State = JobOff
+		}
+		if job.loop {
+			if !job.paused && !job.lastRun.IsZero() {
+				status.NextRun = job.lastRun.Add(status.Interval)
+			}
+		} else if !job.paused && status.Interval > 0 && !s.started.IsZero() {
+			status.NextRun = job.scheduled.Add(status.Interval + job.delay)
+			if status.NextRun.Before(s.started) {
+				status.NextRun = s.started
+			}
+		}
+		result = append(result, status)
+	}
+	return result
+}
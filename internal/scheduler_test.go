@@ -0,0 +1,256 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/The-Skyscape/devtools/pkg/testutils"
+)
+
+// testScheduler returns a scheduler started at t0 with workers workers, a
+// fixed jitter, and pause choices kept in memory.
+func testScheduler(workers int, jitter time.Duration) (*scheduler, *time.Time) {
+	now := time.Date(2026, 10, 1, 9, 0, 0, 0, time.UTC)
+	s := newScheduler()
+	s.now = func() time.Time { return now }
+	s.jitter = func(limit time.Duration) time.Duration { return min(jitter, limit) }
+	s.skip = func() bool { return false }
+	saved := map[string]bool{}
+	s.loadPaused = func(name string) bool { return saved[name] }
+	s.savePaused = func(name string, paused bool) error { saved[name] = paused; return nil }
+	s.workers = workers
+	return s, &now
+}
+
+// blockingJob returns a job that records its runs and waits for release
+// before finishing.
+func blockingJob(name, priority string, interval time.Duration, order *[]string, release chan struct{}) JobSpec {
+	return JobSpec{
+		Name:     name,
+		Interval: Every(interval),
+		Priority: priority,
+		Run: func(time.Time) error {
+			*order = append(*order, name)
+			<-release
+			return nil
+		},
+	}
+}
+
+func jobStatus(s *scheduler, name string) *JobStatus {
+	for _, status := range s.statuses() {
+		if status.Name == name {
+			return status
+		}
+	}
+	return nil
+}
+
+func TestSchedulerSkipsOverrunningJobs(t *testing.T) {
+	s, now := testScheduler(2, 0)
+	var order []string
+	release := make(chan struct{})
+	s.register(blockingJob("sizes", "", time.Minute, &order, release))
+	s.start(0)
+
+	s.tick() // Not due yet
+	testutils.AssertEqual(t, JobIdle, jobStatus(s, "sizes").State)
+
+	*now = now.Add(time.Minute)
+	s.tick()
+	testutils.AssertEqual(t, JobRunning, jobStatus(s, "sizes").State)
+
+	*now = now.Add(time.Minute)
+	s.tick() // Still running: skipped
+	testutils.AssertEqual(t, 1, jobStatus(s, "sizes").Overruns)
+
+	release <- struct{}{}
+	s.wg.Wait()
+	status := jobStatus(s, "sizes")
+	testutils.AssertEqual(t, JobIdle, status.State)
+	testutils.AssertEqual(t, 1, status.Runs)
+
+	*now = now.Add(time.Minute)
+	s.tick()
+	close(release)
+	s.wg.Wait()
+	testutils.AssertEqual(t, 2, jobStatus(s, "sizes").Runs)
+	testutils.AssertEqual(t, "sizes,sizes", strings.Join(order, ","))
+}
+
+func TestSchedulerJitter(t *testing.T) {
+	for i := 0; i < 1000; i++ {
+		if d := randomJitter(5 * time.Minute); d < 0 || d >= 5*time.Minute {
+			t.Fatalf("jitter %s outside [0, 5m)", d)
+		}
+	}
+	testutils.AssertEqual(t, time.Duration(0), randomJitter(0))
+
+	// A run waits out its interval plus the drawn jitter
+	s, now := testScheduler(1, 5*time.Minute-time.Second)
+	runs := 0
+	s.register(JobSpec{Name: "prune", Interval: Every(time.Hour), Jitter: 5 * time.Minute, Run: func(time.Time) error { runs++; return nil }})
+	s.start(0)
+	testutils.AssertEqual(t, "every 1h0m0s, up to 5m0s later", jobStatus(s, "prune").Schedule())
+	testutils.AssertEqual(t, now.Add(time.Hour+5*time.Minute-time.Second), jobStatus(s, "prune").NextRun)
+
+	*now = now.Add(time.Hour + 4*time.Minute)
+	s.tick()
+	s.wg.Wait()
+	testutils.AssertEqual(t, 0, runs)
+
+	*now = now.Add(time.Minute)
+	s.tick()
+	s.wg.Wait()
+	testutils.AssertEqual(t, 1, runs)
+}
+
+func TestSchedulerPauseResume(t *testing.T) {
+	s, now := testScheduler(1, 0)
+	runs := 0
+	s.register(JobSpec{Name: "autopull", Interval: Every(time.Minute), Immediate: true, Run: func(time.Time) error { runs++; return nil }})
+	s.start(0)
+
+	testutils.AssertEqual(t, nil, s.setPaused("autopull", true))
+	status := jobStatus(s, "autopull")
+	testutils.AssertEqual(t, JobPaused, status.State)
+	testutils.AssertEqual(t, true, status.NextRun.IsZero())
+
+	s.tick()
+	s.wg.Wait()
+	testutils.AssertEqual(t, 0, runs)
+	testutils.AssertEqual(t, 0, jobStatus(s, "autopull").Overruns)
+
+	// Run now works while paused and leaves the job paused
+	testutils.AssertEqual(t, nil, s.runNow("autopull"))
+	s.wg.Wait()
+	testutils.AssertEqual(t, 1, runs)
+	testutils.AssertEqual(t, JobPaused, jobStatus(s, "autopull").State)
+
+	// Paused jobs stay paused across restarts
+	restarted, _ := testScheduler(1, 0)
+	restarted.loadPaused = func(name string) bool { return name == "autopull" }
+	restarted.register(JobSpec{Name: "autopull", Interval: Every(time.Minute), Run: func(time.Time) error { return nil }})
+	restarted.start(0)
+	testutils.AssertEqual(t, JobPaused, jobStatus(restarted, "autopull").State)
+
+	testutils.AssertEqual(t, nil, s.setPaused("autopull", false))
+	*now = now.Add(time.Minute)
+	s.tick()
+	s.wg.Wait()
+	testutils.AssertEqual(t, 2, runs)
+
+	testutils.AssertEqual(t, "unknown background job 'sizes'", s.setPaused("sizes", true).Error())
+}
+
+func TestSchedulerRunsHigherPriorityFirst(t *testing.T) {
+	s, now := testScheduler(1, 0)
+	var order []string
+	release := make(chan struct{})
+	s.register(blockingJob("pruning", PriorityLow, time.Minute, &order, release))
+	s.register(blockingJob("notifications", PriorityHigh, time.Minute, &order, release))
+	s.start(0)
+
+	*now = now.Add(time.Minute)
+	s.tick()
+	testutils.AssertEqual(t, JobRunning, jobStatus(s, "notifications").State)
+	testutils.AssertEqual(t, JobQueued, jobStatus(s, "pruning").State)
+	testutils.AssertEqual(t, "pruning is already running", s.runNow("pruning").Error())
+
+	release <- struct{}{} // The free worker picks up the queued job
+	release <- struct{}{}
+	s.wg.Wait()
+	testutils.AssertEqual(t, "notifications,pruning", strings.Join(order, ","))
+}
+
+func TestSchedulerRecordsOutcomes(t *testing.T) {
+	s, now := testScheduler(1, 0)
+	s.register(JobSpec{Name: "prune", Interval: Every(time.Minute), Run: func(time.Time) error {
+		*now = now.Add(3 * time.Second)
+		return errors.New("failed to load expired access records")
+	}})
+	s.register(JobSpec{Name: "autopull", Interval: func() time.Duration { return 0 }, Run: func(time.Time) error { return nil }})
+	s.start(0)
+
+	skip := true
+	s.skip = func() bool { return skip } // Protective mode holds every run
+	*now = now.Add(time.Minute)
+	s.tick()
+	testutils.AssertEqual(t, 0, jobStatus(s, "prune").Runs)
+
+	skip = false
+	s.tick()
+	s.wg.Wait()
+	status := jobStatus(s, "prune")
+	testutils.AssertEqual(t, true, status.Failed())
+	testutils.AssertEqual(t, "failed to load expired access records", status.LastError)
+	testutils.AssertEqual(t, 3*time.Second, status.LastDuration)
+	testutils.AssertEqual(t, 1, status.Failures)
+	testutils.AssertEqual(t, JobOff, jobStatus(s, "autopull").State)
+	testutils.AssertEqual(t, "off", jobStatus(s, "autopull").Schedule())
+}
+
+func TestSchedulerLoopJobs(t *testing.T) {
+	s, now := testScheduler(1, 0)
+	job := s.registerLoop(JobSpec{Name: "metrics-collector", Description: "Sample system stats", Interval: Every(2 * time.Second)})
+	s.start(0)
+
+	testutils.AssertEqual(t, true, job.RunStarted())
+	testutils.AssertEqual(t, JobRunning, jobStatus(s, "metrics-collector").State)
+	*now = now.Add(40 * time.Millisecond)
+	job.RunFinished(errors.New("failed to read system stats"))
+	job.RunStarted()
+	job.RunFinished(errors.New("failed to read system stats"))
+
+	status := jobStatus(s, "metrics-collector")
+	testutils.AssertEqual(t, JobIdle, status.State)
+	testutils.AssertEqual(t, 2, status.Runs)
+	testutils.AssertEqual(t, 2, status.Failures)
+	testutils.AssertEqual(t, now.Add(2*time.Second), status.NextRun)
+	testutils.AssertEqual(t, true, status.Loop)
+
+	job.RunStarted()
+	job.RunFinished(nil)
+	testutils.AssertEqual(t, 0, jobStatus(s, "metrics-collector").Failures)
+
+	// The scheduler leaves loops to their own timer
+	*now = now.Add(time.Hour)
+	s.tick()
+	testutils.AssertEqual(t, 3, jobStatus(s, "metrics-collector").Runs)
+	testutils.AssertEqual(t, true, s.runNow("metrics-collector") != nil)
+
+	s.setPaused("metrics-collector", true)
+	testutils.AssertEqual(t, false, job.RunStarted())
+	testutils.AssertEqual(t, JobPaused, jobStatus(s, "metrics-collector").State)
+	testutils.AssertEqual(t, true, jobStatus(s, "metrics-collector").NextRun.IsZero())
+}
+
+func TestSchedulerUnpausableLoop(t *testing.T) {
+	s, _ := testScheduler(1, 0)
+	s.savePaused("volume-watchdog", true) // Saved before it became unpausable
+	job := s.registerLoop(JobSpec{Name: "volume-watchdog", Interval: Every(30 * time.Second), Unpausable: true})
+	s.start(0)
+	testutils.AssertEqual(t, true, job.RunStarted())
+	job.RunFinished(nil)
+
+	err := s.setPaused("volume-watchdog", true)
+	testutils.AssertEqual(t, "volume-watchdog keeps the workbench safe and can't be paused", err.Error())
+	testutils.AssertEqual(t, true, job.RunStarted())
+	status := jobStatus(s, "volume-watchdog")
+	testutils.AssertEqual(t, false, status.Paused)
+	testutils.AssertEqual(t, true, status.Unpausable)
+
+	// Resuming is still allowed, clearing the saved pause
+	testutils.AssertEqual(t, nil, s.setPaused("volume-watchdog", false))
+	testutils.AssertEqual(t, false, s.loadPaused("volume-watchdog"))
+}
+
+func TestJobStatusJSON(t *testing.T) {
+	status := &JobStatus{Name: "trash-pruning", Interval: time.Hour, State: JobIdle, LastDuration: 1500 * time.Millisecond, Failures: 2}
+	data, err := json.Marshal([]*JobStatus{status})
+	testutils.AssertEqual(t, nil, err)
+	testutils.AssertEqual(t, `[{"name":"trash-pruning","description":"","interval_seconds":3600,"state":"idle","paused":false,"last_duration_ms":1500,"consecutive_failures":2,"runs":0,"overruns":0}]`, string(data))
+}
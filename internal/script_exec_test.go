@@ -0,0 +1,76 @@
+package internal
+
+import (
+	"strings"
+	"sync"
+	"testing"
+)
+
+// scriptedExec stands in for the coder container: each command gets the
+// output and error of the first rule whose match it contains, and commands
+// no rule matches succeed with no output. Every command is recorded. It
+// replaces the package's exec seams, which take the place of an Executor
+// interface, one at a time.
+type scriptedExec struct {
+	mu       sync.Mutex
+	rules    []scriptRule
+	commands []string
+}
+
+type scriptRule struct {
+	match  string
+	output string
+	err    error
+}
+
+// fakeScript replaces seam with a scriptedExec until the test ends.
+func fakeScript(t *testing.T, seam *func(string) (string, error)) *scriptedExec {
+	script := &scriptedExec{}
+	original := *seam
+	*seam = script.exec
+	t.Cleanup(func() { *seam = original })
+	return script
+}
+
+// fakeArgsScript replaces an argv seam with a scriptedExec until the test
+// ends. Commands are matched and recorded with their arguments joined by
+// spaces.
+func fakeArgsScript(t *testing.T, seam *func(...string) (string, error)) *scriptedExec {
+	script := &scriptedExec{}
+	original := *seam
+	*seam = func(args ...string) (string, error) { return script.exec(strings.Join(args, " ")) }
+	t.Cleanup(func() { *seam = original })
+	return script
+}
+
+// on adds a rule for commands containing match.
+func (s *scriptedExec) on(match, output string, err error) *scriptedExec {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rules = append(s.rules, scriptRule{match, output, err})
+	return s
+}
+
+func (s *scriptedExec) exec(cmd string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.commands = append(s.commands, cmd)
+	for _, rule := range s.rules {
+		if strings.Contains(cmd, rule.match) {
+			return rule.output, rule.err
+		}
+	}
+	return "", nil
+}
+
+// ran reports whether a command containing match was run.
+func (s *scriptedExec) ran(match string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, cmd := range s.commands {
+		if strings.Contains(cmd, match) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,137 @@
+package internal
+
+import (
+	"strings"
+	"unicode/utf8"
+	"workbench/models"
+)
+
+// Search categories, in the order results are shown.
+const (
+	SearchRepositories = "repositories"
+	SearchActivities   = "activities"
+	SearchSettings     = "settings"
+)
+
+// MinSearchLength is the shortest query that's searched; shorter ones
+// would match nearly everything.
+const MinSearchLength = 2
+
+// Results per category, and per category when it's expanded.
+const (
+	SearchLimit         = 5
+	SearchExpandedLimit = 50
+)
+
+// SearchResult is one match, linked to where it's shown.
+type SearchResult struct {
+	ID     string // Repository name, activity ID, or setting key
+	Title  string
+	Detail string
+}
+
+// SearchGroup is the matches of one category.
+type SearchGroup struct {
+	Category string // SearchRepositories, SearchActivities, or SearchSettings
+	Label    string // e.g. "Repositories"
+	Results  []*SearchResult
+	More     bool // There are matches past Results
+}
+
+// Searches of each category, given a LIKE pattern and a row limit.
+// Replaced in tests.
+var (
+	searchRepos = func(pattern string, limit int) ([]*models.Repository, error) {
+		return models.Repositories.Search(`WHERE LOWER(Name) LIKE ? ESCAPE '\' OR LOWER(URL) LIKE ? ESCAPE '\' OR LOWER(Description) LIKE ? ESCAPE '\'
+			ORDER BY Name ASC LIMIT ?`, pattern, pattern, pattern, limit)
+	}
+	searchActivities = func(pattern string, limit int) ([]*models.Activity, error) {
+		return models.Activities.Search(`WHERE LOWER(Description) LIKE ? ESCAPE '\' ORDER BY CreatedAt DESC LIMIT ?`, pattern, limit)
+	}
+)
+
+// Search matches query, case-insensitively, against repository names,
+// URLs, and descriptions, activity descriptions, and the keys and
+// descriptions of visible settings. Secret settings are never matched.
+// Each category returns up to SearchLimit results, or SearchExpandedLimit
+// for the category named by expand. Queries shorter than MinSearchLength
+// return nothing.
+func Search(query, expand string) ([]*SearchGroup, error) {
+	query = strings.ToLower(strings.TrimSpace(query))
+	if utf8.RuneCountInString(query) < MinSearchLength {
+		return nil, nil
+	}
+	limit := func(category string) int {
+		if category == expand {
+			return SearchExpandedLimit
+		}
+		return SearchLimit
+	}
+	pattern := "%" + escapeLike(query) + "%"
+
+	var groups []*SearchGroup
+	add := func(category, label string, results []*SearchResult, max int) {
+		if len(results) == 0 {
+			return
+		}
+		group := &SearchGroup{Category: category, Label: label, Results: results}
+		if len(results) > max {
+			group.Results, group.More = results[:max], true
+		}
+		groups = append(groups, group)
+	}
+
+	max := limit(SearchRepositories)
+	repos, err := searchRepos(pattern, max+1)
+	if err != nil {
+		return nil, err
+	}
+	var results []*SearchResult
+	for _, repo := range repos {
+		detail := repo.URL
+		if repo.Description != "" {
+			detail = repo.Description
+		}
+		results = append(results, &SearchResult{ID: repo.Name, Title: repo.Name, Detail: detail})
+	}
+	add(SearchRepositories, "Repositories", results, max)
+
+	max = limit(SearchActivities)
+	activities, err := searchActivities(pattern, max+1)
+	if err != nil {
+		return nil, err
+	}
+	results = nil
+	for _, activity := range activities {
+		results = append(results, &SearchResult{ID: activity.ID, Title: activity.Description, Detail: activity.Type})
+	}
+	add(SearchActivities, "Activity", results, max)
+
+	max = limit(SearchSettings)
+	add(SearchSettings, "Settings", searchSettings(query, max+1), max)
+	return groups, nil
+}
+
+// searchSettings matches registered settings shown on the settings page,
+// leaving out system-kept and secret ones.
+func searchSettings(query string, limit int) []*SearchResult {
+	var results []*SearchResult
+	for _, def := range models.RegisteredSettings() {
+		if def.Hidden || isSecretSetting(def.Key) {
+			continue
+		}
+		if !strings.Contains(strings.ToLower(def.Key), query) && !strings.Contains(strings.ToLower(def.Description), query) {
+			continue
+		}
+		results = append(results, &SearchResult{ID: def.Key, Title: def.Key, Detail: def.Description})
+		if len(results) == limit {
+			break
+		}
+	}
+	return results
+}
+
+// escapeLike escapes LIKE's wildcards in s, for patterns using ESCAPE '\'.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
@@ -0,0 +1,91 @@
+package internal
+
+import (
+	"testing"
+	"workbench/models"
+
+	"github.com/The-Skyscape/devtools/pkg/testutils"
+)
+
+func TestEscapeLike(t *testing.T) {
+	testCases := []struct {
+		input, expected string
+	}{
+		{"api", "api"},
+		{"100%", `100\%`},
+		{"my_repo", `my\_repo`},
+		{`C:\src`, `C:\\src`},
+		{`%_\`, `\%\_\\`},
+	}
+	for _, tc := range testCases {
+		testutils.AssertEqual(t, tc.expected, escapeLike(tc.input))
+	}
+}
+
+func stubSearch(t *testing.T, repos []*models.Repository, activities []*models.Activity) *[]string {
+	var patterns []string
+	origRepos, origActivities := searchRepos, searchActivities
+	searchRepos = func(pattern string, limit int) ([]*models.Repository, error) {
+		patterns = append(patterns, pattern)
+		return repos[:min(len(repos), limit)], nil
+	}
+	searchActivities = func(pattern string, limit int) ([]*models.Activity, error) {
+		return activities[:min(len(activities), limit)], nil
+	}
+	t.Cleanup(func() { searchRepos, searchActivities = origRepos, origActivities })
+	return &patterns
+}
+
+func TestSearchShortQuery(t *testing.T) {
+	patterns := stubSearch(t, []*models.Repository{{Name: "api"}}, nil)
+
+	for _, query := range []string{"", "a", "  a  ", "é"} {
+		groups, err := Search(query, "")
+		testutils.AssertEqual(t, nil, err)
+		testutils.AssertEqual(t, 0, len(groups))
+	}
+	testutils.AssertEqual(t, 0, len(*patterns)) // Nothing was queried
+}
+
+func TestSearchCapsEachCategory(t *testing.T) {
+	var repos []*models.Repository
+	for _, name := range []string{"api-1", "api-2", "api-3", "api-4", "api-5", "api-6", "api-7"} {
+		repos = append(repos, &models.Repository{Name: name, URL: "git@github.com:me/" + name + ".git"})
+	}
+	activities := []*models.Activity{{Description: "Cloned api-1"}}
+	patterns := stubSearch(t, repos, activities)
+
+	groups, err := Search("  API-%", "")
+	testutils.AssertEqual(t, nil, err)
+	testutils.AssertEqual(t, `%api-\%%`, (*patterns)[0]) // Lowercased, trimmed, and escaped
+	testutils.AssertEqual(t, 2, len(groups))
+
+	testutils.AssertEqual(t, SearchRepositories, groups[0].Category)
+	testutils.AssertEqual(t, SearchLimit, len(groups[0].Results))
+	testutils.AssertEqual(t, true, groups[0].More)
+	testutils.AssertEqual(t, "git@github.com:me/api-1.git", groups[0].Results[0].Detail)
+
+	testutils.AssertEqual(t, SearchActivities, groups[1].Category)
+	testutils.AssertEqual(t, false, groups[1].More)
+
+	groups, _ = Search("api-", SearchRepositories)
+	testutils.AssertEqual(t, 7, len(groups[0].Results))
+	testutils.AssertEqual(t, false, groups[0].More)
+}
+
+func TestSearchSettingsSkipsSecrets(t *testing.T) {
+	stubSearch(t, nil, nil)
+
+	groups, err := Search("webhook", "")
+	testutils.AssertEqual(t, nil, err)
+	for _, group := range groups {
+		for _, result := range group.Results {
+			testutils.AssertEqual(t, false, isSecretSetting(result.ID))
+		}
+	}
+
+	groups, _ = Search("diff_max", "")
+	testutils.AssertEqual(t, 1, len(groups))
+	testutils.AssertEqual(t, SearchSettings, groups[0].Category)
+	testutils.AssertEqual(t, "diff_max_file_size", groups[0].Results[0].ID)
+}
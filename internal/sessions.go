@@ -0,0 +1,236 @@
+package internal
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+	"workbench/models"
+)
+
+// Session settings kept by the system.
+const (
+	// sessionCookieKey is the name of the auth cookie, learned from the
+	// first signin that sets it.
+	sessionCookieKey = "session_cookie"
+	// sessionsStrictKey is "true" once other sessions were signed out.
+	// From then on only recorded sessions are let in, so a cookie stolen
+	// before sessions were tracked can't be adopted as a new one.
+	sessionsStrictKey = "sessions_strict"
+)
+
+// SessionLifetime is how long an auth cookie lasts when its expiry isn't
+// known.
+const SessionLifetime = 30 * 24 * time.Hour
+
+// sessionTouchInterval is how stale LastSeen gets before a request
+// updates it, so browsing doesn't write to the database on every request.
+const sessionTouchInterval = time.Minute
+
+// ErrSessionRevoked is returned for a cookie whose session was revoked.
+var ErrSessionRevoked = errors.New("this session was signed out")
+
+// Session storage. Replaced in tests.
+var (
+	sessionByHash = func(hash string) (*models.Session, error) {
+		return models.Sessions.Find("WHERE TokenHash = ?", hash)
+	}
+	insertSession = func(s *models.Session) error {
+		_, err := models.Sessions.Insert(s)
+		return err
+	}
+	updateSession  = func(s *models.Session) error { return models.Sessions.Update(s) }
+	sessionsStrict = func() bool { return models.GetBoolSetting(sessionsStrictKey) }
+)
+
+// SessionCookie returns the name of the auth cookie, or "" before anyone
+// has signed in since sessions were tracked.
+func SessionCookie() string {
+	name, _ := models.GetSetting(sessionCookieKey)
+	return name
+}
+
+// StartSession records a signin that set the auth cookie name to token,
+// expiring at expires (zero when unknown). Expired sessions are pruned.
+func StartSession(cookieName, token string, expires time.Time, client AuthClient) error {
+	if cookieName != SessionCookie() {
+		if _, err := models.SetSetting(sessionCookieKey, cookieName, "system"); err != nil {
+			return fmt.Errorf("failed to save the session cookie name: %w", err)
+		}
+	}
+	pruneSessions()
+
+	now := time.Now()
+	if expires.IsZero() {
+		expires = now.Add(SessionLifetime)
+	}
+	if err := insertSession(newSession(token, client, now, expires)); err != nil {
+		return fmt.Errorf("failed to record session: %w", err)
+	}
+	return nil
+}
+
+// CheckSession returns the session for an auth cookie that was already
+// accepted, updating when and where it was last seen. A cookie from before
+// sessions were tracked is recorded as a new session, unless other
+// sessions were signed out since. Returns ErrSessionRevoked for a cookie
+// that must be refused.
+func CheckSession(token string, client AuthClient) (*models.Session, error) {
+	now := time.Now()
+	session, err := sessionByHash(hashSessionToken(token))
+	if err != nil {
+		if sessionsStrict() {
+			return nil, ErrSessionRevoked
+		}
+		session = newSession(token, client, now, now.Add(SessionLifetime))
+		if err := insertSession(session); err != nil {
+			return nil, fmt.Errorf("failed to record session: %w", err)
+		}
+		return session, nil
+	}
+	if session.Revoked || !now.Before(session.ExpiresAt) {
+		return nil, ErrSessionRevoked
+	}
+
+	if now.Sub(session.LastSeen) >= sessionTouchInterval || session.ClientIP != client.IP {
+		session.LastSeen = now
+		session.ClientIP = client.IP
+		session.UserAgent = truncateUserAgent(client.UserAgent)
+		if err := updateSession(session); err != nil {
+			slog.Error("failed to update session", "session", session.ID, "error", err)
+		}
+	}
+	return session, nil
+}
+
+// CurrentSessionID returns the ID of the session an auth cookie belongs
+// to, or "" when it isn't recorded.
+func CurrentSessionID(token string) string {
+	if token == "" {
+		return ""
+	}
+	session, err := sessionByHash(hashSessionToken(token))
+	if err != nil {
+		return ""
+	}
+	return session.ID
+}
+
+// GetSessions returns the sessions that are signed in, most recently seen
+// first.
+func GetSessions() ([]*models.Session, error) {
+	return models.Sessions.Search("WHERE Revoked = ? AND ExpiresAt > ? ORDER BY LastSeen DESC", false, time.Now())
+}
+
+// RevokeSession signs out a session: its cookie is refused from the next
+// request on, and its terminals close.
+func RevokeSession(id string) error {
+	session, err := models.Sessions.Get(id)
+	if err != nil || session.Revoked {
+		return fmt.Errorf("session not found")
+	}
+	session.Revoked = true
+	if err := models.Sessions.Update(session); err != nil {
+		return fmt.Errorf("failed to revoke session: %w", err)
+	}
+	closeSessionTerminals(session.ID, false)
+
+	go models.Activities.Insert(&models.Activity{
+		Type:        "auth_session_revoked",
+		Description: fmt.Sprintf("Signed out the session last seen from %s", session.ClientIP),
+		Author:      "System",
+		Timestamp:   time.Now(),
+	})
+	return nil
+}
+
+// RevokeOtherSessions signs out every session except currentID and
+// returns how many were signed out. Cookies that were never seen, such as
+// one copied before sessions were tracked, are refused from now on too.
+func RevokeOtherSessions(currentID string) (int, error) {
+	if currentID == "" {
+		return 0, fmt.Errorf("this session isn't recorded yet - reload the page and try again")
+	}
+	revoked, err := revokeSessions(currentID)
+	if err != nil {
+		return 0, err
+	}
+
+	go models.Activities.Insert(&models.Activity{
+		Type:        "auth_session_revoked",
+		Description: fmt.Sprintf("Signed out everywhere else: %d sessions", revoked),
+		Author:      "System",
+		Timestamp:   time.Now(),
+	})
+	return revoked, nil
+}
+
+// revokeSessions signs out every session except keepID, every one when
+// it's empty, and from then on refuses cookies that were never seen.
+// Returns how many were signed out.
+func revokeSessions(keepID string) (int, error) {
+	if _, err := models.SetSetting(sessionsStrictKey, "true", "system"); err != nil {
+		return 0, fmt.Errorf("failed to save session settings: %w", err)
+	}
+	sessions, err := GetSessions()
+	if err != nil {
+		return 0, fmt.Errorf("failed to load sessions: %w", err)
+	}
+
+	revoked := 0
+	for _, session := range sessions {
+		if keepID != "" && session.ID == keepID {
+			continue
+		}
+		session.Revoked = true
+		if err := models.Sessions.Update(session); err != nil {
+			slog.Error("failed to revoke session", "session", session.ID, "error", err)
+			continue
+		}
+		revoked++
+	}
+	closeSessionTerminals(keepID, true)
+	return revoked, nil
+}
+
+// EndSession forgets the session of an auth cookie on signout, closing
+// its terminals.
+func EndSession(token string) {
+	session, err := sessionByHash(hashSessionToken(token))
+	if err != nil {
+		return
+	}
+	session.Revoked = true
+	if err := updateSession(session); err != nil {
+		slog.Error("failed to end session", "session", session.ID, "error", err)
+	}
+	closeSessionTerminals(session.ID, false)
+}
+
+// pruneSessions deletes sessions whose cookie has expired.
+func pruneSessions() {
+	expired, err := models.Sessions.Search("WHERE ExpiresAt <= ?", time.Now())
+	if err != nil {
+		return
+	}
+	for _, session := range expired {
+		models.Sessions.Delete(session)
+	}
+}
+
+func newSession(token string, client AuthClient, now, expires time.Time) *models.Session {
+	return &models.Session{
+		TokenHash: hashSessionToken(token),
+		ClientIP:  client.IP,
+		UserAgent: truncateUserAgent(client.UserAgent),
+		LastSeen:  now,
+		ExpiresAt: expires,
+	}
+}
+
+func hashSessionToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
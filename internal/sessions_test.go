@@ -0,0 +1,75 @@
+package internal
+
+import (
+	"errors"
+	"testing"
+	"time"
+	"workbench/models"
+
+	"github.com/The-Skyscape/devtools/pkg/testutils"
+)
+
+// fakeSessions serves sessions from a map of token hashes for the duration
+// of a test.
+func fakeSessions(t *testing.T, strict bool, sessions ...*models.Session) map[string]*models.Session {
+	byHash, insert, update, strictness := sessionByHash, insertSession, updateSession, sessionsStrict
+	t.Cleanup(func() {
+		sessionByHash, insertSession, updateSession, sessionsStrict = byHash, insert, update, strictness
+	})
+
+	stored := map[string]*models.Session{}
+	for _, s := range sessions {
+		stored[s.TokenHash] = s
+	}
+	sessionByHash = func(hash string) (*models.Session, error) {
+		if s, ok := stored[hash]; ok {
+			return s, nil
+		}
+		return nil, errors.New("record not found")
+	}
+	insertSession = func(s *models.Session) error {
+		stored[s.TokenHash] = s
+		return nil
+	}
+	updateSession = func(s *models.Session) error { return nil }
+	sessionsStrict = func() bool { return strict }
+	return stored
+}
+
+func TestCheckSession(t *testing.T) {
+	now := time.Now()
+	client := AuthClient{IP: "203.0.113.7", UserAgent: "Firefox"}
+	active := &models.Session{TokenHash: hashSessionToken("active"), ClientIP: "198.51.100.1", LastSeen: now.Add(-time.Hour), ExpiresAt: now.Add(time.Hour)}
+	revoked := &models.Session{TokenHash: hashSessionToken("revoked"), ExpiresAt: now.Add(time.Hour), Revoked: true}
+	expired := &models.Session{TokenHash: hashSessionToken("expired"), ExpiresAt: now.Add(-time.Minute)}
+	stored := fakeSessions(t, false, active, revoked, expired)
+
+	session, err := CheckSession("active", client)
+	testutils.AssertEqual(t, nil, err)
+	testutils.AssertEqual(t, "203.0.113.7", session.ClientIP)
+	testutils.AssertEqual(t, true, session.LastSeen.After(now.Add(-time.Minute)))
+
+	_, err = CheckSession("revoked", client)
+	testutils.AssertEqual(t, ErrSessionRevoked, err)
+	_, err = CheckSession("expired", client)
+	testutils.AssertEqual(t, ErrSessionRevoked, err)
+
+	// A cookie from before sessions were tracked is adopted
+	_, err = CheckSession("older", client)
+	testutils.AssertEqual(t, nil, err)
+	testutils.AssertEqual(t, "Firefox", stored[hashSessionToken("older")].UserAgent)
+}
+
+func TestCheckSessionRefusesUnknownCookiesOnceStrict(t *testing.T) {
+	stored := fakeSessions(t, true)
+
+	_, err := CheckSession("copied", AuthClient{IP: "203.0.113.7"})
+	testutils.AssertEqual(t, ErrSessionRevoked, err)
+	testutils.AssertEqual(t, 0, len(stored))
+}
+
+func TestSessionTokensAreHashed(t *testing.T) {
+	session := newSession("secret-jwt", AuthClient{IP: "203.0.113.7"}, time.Now(), time.Now().Add(SessionLifetime))
+	testutils.AssertEqual(t, 64, len(session.TokenHash))
+	testutils.AssertEqual(t, hashSessionToken("secret-jwt"), session.TokenHash)
+}
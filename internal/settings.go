@@ -0,0 +1,350 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/mail"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"workbench/internal/i18n"
+	"workbench/models"
+	"workbench/services"
+)
+
+// Setting categories, in the order the settings page shows them.
+const (
+	CategoryDisplay       = "Display"
+	CategoryNotifications = "Notifications"
+	CategoryRepositories  = "Repositories"
+	CategoryAPI           = "API"
+	CategoryCoder         = "VS Code"
+	CategoryBackground    = "Background Jobs"
+	CategorySystem        = "System"
+	CategoryUnregistered  = "Unregistered"
+)
+
+// DefaultTheme is the color theme until the theme setting says otherwise.
+const DefaultTheme = "dark"
+
+// Themes are the daisyUI themes the theme setting offers.
+var Themes = []string{"dark", "light", "dim", "nord", "dracula"}
+
+var settingCategories = []string{CategoryDisplay, CategoryNotifications, CategoryRepositories, CategoryAPI, CategoryCoder, CategoryBackground}
+
+func init() {
+	var locales []string
+	for _, l := range i18n.Supported() {
+		locales = append(locales, l.Tag)
+	}
+
+	models.RegisterSettings(
+		// Display
+		&models.SettingDef{Key: "locale", Type: models.SettingEnum, Options: locales, Category: CategoryDisplay,
+			Description: "Language dates and numbers are formatted for; empty follows the browser"},
+		&models.SettingDef{Key: "theme", Type: models.SettingEnum, Default: DefaultTheme, Options: Themes, Category: CategoryDisplay,
+			Description: "Color theme of every page"},
+		&models.SettingDef{Key: "timezone", Type: models.SettingString, Default: "UTC", Category: CategoryDisplay,
+			Description: "Timezone for digests and quiet hours", Check: checkTimezone, Rule: "must be a timezone such as Europe/Berlin"},
+		&models.SettingDef{Key: "display_timezone", Type: models.SettingString, Category: CategoryDisplay,
+			Description: "Timezone timestamps are shown in; empty follows the browser", Check: checkTimezone, Rule: "must be a timezone such as Europe/Berlin"},
+		&models.SettingDef{Key: "tour_completed", Type: models.SettingBool, Default: "false", Category: CategoryDisplay,
+			Description: "The welcome tour has been finished"},
+		&models.SettingDef{Key: "tour_never_show", Type: models.SettingBool, Default: "false", Category: CategoryDisplay,
+			Description: "Never offer the welcome tour"},
+
+		// Notifications
+		&models.SettingDef{Key: "activity_digest", Type: models.SettingEnum, Default: DigestOff, Options: []string{DigestOff, DigestDaily, DigestWeekly},
+			Category: CategoryNotifications, Description: "Summarize routine background events instead of notifying each one"},
+		&models.SettingDef{Key: "activity_digest_time", Type: models.SettingString, Default: "08:00", Category: CategoryNotifications,
+			Description: "When the digest is sent", Check: checkClock, Rule: "must be a time of day as HH:MM"},
+		&models.SettingDef{Key: "digest_quiet_start", Type: models.SettingString, Category: CategoryNotifications,
+			Description: "Hold non-critical notifications from this time", Check: checkClock, Rule: "must be a time of day as HH:MM"},
+		&models.SettingDef{Key: "digest_quiet_end", Type: models.SettingString, Category: CategoryNotifications,
+			Description: "Release held notifications at this time", Check: checkClock, Rule: "must be a time of day as HH:MM"},
+		&models.SettingDef{Key: "notify_webhook_url", Type: models.SettingString, Category: CategoryNotifications,
+			Description: "Notifications are posted here as JSON; empty turns the webhook off", Check: checkWebhookURL, Rule: "must be an http(s) URL"},
+		&models.SettingDef{Key: "notify_in_app", Type: models.SettingBool, Default: "true", Category: CategoryNotifications,
+			Description: "Show notifications under the bell in the header"},
+		&models.SettingDef{Key: "notify_webhook", Type: models.SettingBool, Default: "true", Category: CategoryNotifications,
+			Description: "Post notifications to notify_webhook_url"},
+		&models.SettingDef{Key: "notify_email", Type: models.SettingBool, Default: "false", Category: CategoryNotifications,
+			Description: "Email notifications to notify_email_to with the SMTP server"},
+		&models.SettingDef{Key: "notify_email_to", Type: models.SettingString, Category: CategoryNotifications,
+			Description: "Address notifications are emailed to", Check: checkEmailAddress, Rule: "must be an email address"},
+		&models.SettingDef{Key: "smtp_host", Type: models.SettingString, Category: CategoryNotifications,
+			Description: "SMTP server notification emails are sent through"},
+		&models.SettingDef{Key: "smtp_port", Type: models.SettingInt, Min: 1, Max: 65535, Default: "587", Category: CategoryNotifications,
+			Description: "Port of the SMTP server; STARTTLS is used when offered"},
+		&models.SettingDef{Key: "smtp_username", Type: models.SettingString, Category: CategoryNotifications,
+			Description: "SMTP login; empty sends without logging in"},
+		&models.SettingDef{Key: "smtp_from", Type: models.SettingString, Category: CategoryNotifications,
+			Description: "Sender address of notification emails", Check: checkEmailAddress, Rule: "must be an email address"},
+		&models.SettingDef{Key: CPUAlertKey, Type: models.SettingInt, Default: strconv.Itoa(DefaultCPUAlert), Min: 0, Max: 100, Category: CategoryNotifications,
+			Description: "Alert when CPU stays at or above this percent; 0 turns the alert off"},
+		&models.SettingDef{Key: MemoryAlertKey, Type: models.SettingInt, Default: strconv.Itoa(DefaultMemoryAlert), Min: 0, Max: 100, Category: CategoryNotifications,
+			Description: "Alert when memory stays at or above this percent; 0 turns the alert off"},
+		&models.SettingDef{Key: DiskAlertKey, Type: models.SettingInt, Default: strconv.Itoa(DefaultDiskAlert), Min: 0, Max: 100, Category: CategoryNotifications,
+			Description: "Alert when the data disk stays at or above this percent; 0 turns the alert off"},
+
+		// Repositories
+		&models.SettingDef{Key: "autopull_interval", Type: models.SettingDuration, Unit: time.Minute, Default: "0", Category: CategoryRepositories,
+			Description: "How often repositories are pulled in the background; 0 turns auto-pull off",
+			Check:       checkAutoPullInterval, Rule: "must be 0, 15, 60, 360, or 1440 minutes"},
+		&models.SettingDef{Key: "missing_repo_behavior", Type: models.SettingEnum, Default: MissingRepoReclone,
+			Options: []string{MissingRepoReclone, MissingRepoPrompt, MissingRepoFail}, Category: CategoryRepositories,
+			Description: "What a pull does when a repository's directory is gone"},
+		&models.SettingDef{Key: "operation_timeout", Type: models.SettingDuration, Unit: time.Second, Min: 1, Max: 86400,
+			Default: strconv.Itoa(int(DefaultOperationTimeout / time.Second)), Category: CategoryRepositories,
+			Description: "Longest a pull or export may run"},
+		&models.SettingDef{Key: "clone_workers", Type: models.SettingInt, Min: 1, Max: 8, Default: strconv.Itoa(DefaultCloneWorkers),
+			Category: CategoryRepositories, Description: "Clones that may run at once; the rest wait in the clone queue"},
+		&models.SettingDef{Key: "scaffold_default_branch", Type: models.SettingString, Default: DefaultBranchName, Category: CategoryRepositories,
+			Description: "Branch new projects are created on", Check: checkBranchName, Rule: "must be a valid branch name"},
+		&models.SettingDef{Key: "default_storage", Type: models.SettingString, Category: CategoryRepositories,
+			Description: "Storage location preselected when cloning; empty for the standard repos directory",
+			Check:       checkStorageName, Rule: "must be the name of a storage location"},
+		&models.SettingDef{Key: "diff_max_file_size", Type: models.SettingInt, Min: 1024, Max: 16 << 20, Default: strconv.Itoa(DefaultDiffMaxFileSize),
+			Category: CategoryRepositories, Description: "Largest diff shown per file, in bytes; bigger ones link to the editor"},
+		&models.SettingDef{Key: "trash_quota_mb", Type: models.SettingInt, Min: 1, Max: 1 << 20, Default: strconv.Itoa(DefaultTrashQuotaMB),
+			Category: CategoryRepositories, Description: "Megabytes of deleted repository archives kept before the oldest are removed"},
+		&models.SettingDef{Key: "clone_min_free_mb", Type: models.SettingInt, Min: 0, Max: 1 << 20, Default: strconv.Itoa(DefaultCloneMinFreeMB),
+			Category: CategoryRepositories, Description: "Megabytes a clone must leave free on its disk, on top of the repository's estimated size"},
+		&models.SettingDef{Key: "repos_quota_mb", Type: models.SettingInt, Min: 0, Max: 1 << 30, Default: "0",
+			Category: CategoryRepositories, Description: "Megabytes all repositories may use before new clones are refused; 0 turns the quota off"},
+		&models.SettingDef{Key: "maintenance_protected_branches", Type: models.SettingString, Default: DefaultProtectedBranches, Category: CategoryRepositories,
+			Description: "Comma-separated branches and patterns like release/* that cleaning up merged branches never deletes",
+			Check:       checkBranchPatterns, Rule: "must be comma-separated branch names or patterns"},
+		&models.SettingDef{Key: "ssh_old_key_grace", Type: models.SettingDuration, Unit: 24 * time.Hour, Min: 0, Max: 365,
+			Default: strconv.Itoa(DefaultSSHOldKeyGraceDays), Category: CategoryRepositories,
+			Description: "Days the previous SSH key is kept after a rotation; 0 removes it right away"},
+
+		// API
+		&models.SettingDef{Key: "api_read_rate", Type: models.SettingInt, Min: 1, Max: math.MaxInt32, Rule: "must be a positive whole number", Default: strconv.Itoa(DefaultAPIReadRate),
+			Category: CategoryAPI, Description: "Sustained read requests per minute per token"},
+		&models.SettingDef{Key: "api_read_burst", Type: models.SettingInt, Min: 1, Max: math.MaxInt32, Rule: "must be a positive whole number", Default: strconv.Itoa(DefaultAPIReadBurst),
+			Category: CategoryAPI, Description: "Read requests allowed at once before the rate applies"},
+		&models.SettingDef{Key: "api_write_rate", Type: models.SettingInt, Min: 1, Max: math.MaxInt32, Rule: "must be a positive whole number", Default: strconv.Itoa(DefaultAPIWriteRate),
+			Category: CategoryAPI, Description: "Sustained write requests per minute per token"},
+		&models.SettingDef{Key: "api_write_burst", Type: models.SettingInt, Min: 1, Max: math.MaxInt32, Rule: "must be a positive whole number", Default: strconv.Itoa(DefaultAPIWriteBurst),
+			Category: CategoryAPI, Description: "Write requests allowed at once before the rate applies"},
+		&models.SettingDef{Key: "api_max_body", Type: models.SettingInt, Min: 1, Max: math.MaxInt32, Rule: "must be a positive whole number", Default: strconv.Itoa(DefaultAPIMaxBody),
+			Category: CategoryAPI, Description: "Largest accepted JSON request body, in bytes"},
+		&models.SettingDef{Key: HealthRequiredChecksKey, Type: models.SettingString, Category: CategoryAPI,
+			Default: strings.Join(HealthChecks, ","), Check: checkHealthChecks, Rule: "must list checks from database, coder, and datadir, or be none",
+			Description: "Comma-separated checks /health/ready needs to pass; the others are reported only"},
+		&models.SettingDef{Key: "share_links", Type: models.SettingBool, Default: "false", Category: CategoryAPI,
+			Description: "Read-only links to a file, diff, or README open without signing in; off makes every link answer 404"},
+
+		// VS Code
+		&models.SettingDef{Key: "coder_proxy_buffer", Type: models.SettingInt, Min: 1024, Max: 16 << 20, Default: strconv.Itoa(services.DefaultProxyBufferSize),
+			Category: CategoryCoder, Description: "Bytes the proxy copies per chunk of a response. Applies after a restart"},
+		&models.SettingDef{Key: "terminal_max_sessions", Type: models.SettingInt, Min: 1, Max: 16, Default: strconv.Itoa(DefaultTerminalSessions),
+			Category: CategoryCoder, Description: "Terminals that may be open in the coder container at once"},
+		&models.SettingDef{Key: "terminal_idle_timeout", Type: models.SettingDuration, Unit: time.Minute, Min: 1, Max: 1440,
+			Default: strconv.Itoa(int(DefaultTerminalIdleTimeout / time.Minute)), Category: CategoryCoder,
+			Description: "Minutes a terminal may sit with nothing typed or printed before it's closed"},
+		&models.SettingDef{Key: "coder_idle_pause", Type: models.SettingDuration, Unit: time.Minute, Min: 0, Max: 10080, Default: "0",
+			Category: CategoryCoder, Description: "Minutes the IDE, commands, and terminals may leave the coder container unused before it's paused to save resources; 0 never pauses it"},
+		&models.SettingDef{Key: "coder_paused_jobs", Type: models.SettingEnum, Default: PausedJobsSkip, Options: []string{PausedJobsSkip, PausedJobsWake},
+			Category: CategoryCoder, Description: "Whether auto-pull and webhook pulls skip while the coder container is paused, or wake it and pause it again after"},
+		&models.SettingDef{Key: "coder_image_tag", Type: models.SettingString, Default: services.DefaultCoderImageTag, Category: CategoryCoder,
+			Description: "code-server version the coder container should run; upgrade to it from the dashboard",
+			Check:       checkCoderImageTag, Rule: "must be an image tag like " + services.DefaultCoderImageTag},
+
+		// Background jobs
+		&models.SettingDef{Key: "background_workers", Type: models.SettingInt, Min: 1, Max: 32, Default: strconv.Itoa(defaultBackgroundWorkers()),
+			Category: CategoryBackground, Description: "Background jobs that may run at once. Applies after a restart"},
+		&models.SettingDef{Key: "metrics_retention", Type: models.SettingDuration, Unit: 24 * time.Hour, Min: 1, Max: 30,
+			Default: strconv.Itoa(int(DefaultMetricsRetention / (24 * time.Hour))), Category: CategoryBackground,
+			Description: "Days of per-minute metrics history kept for charts"},
+		&models.SettingDef{Key: "activity_retention_days", Type: models.SettingDuration, Unit: 24 * time.Hour, Min: 0, Max: 3650,
+			Default: strconv.Itoa(DefaultActivityRetentionDays), Category: CategoryBackground,
+			Description: "Days activities are kept before they're deleted; 0 keeps them forever"},
+
+		// Kept by the system
+		&models.SettingDef{Key: "activity_digest_last", Type: models.SettingString, Category: CategorySystem, Hidden: true},
+		&models.SettingDef{Key: "volume_health_check", Type: models.SettingString, Category: CategorySystem, Hidden: true},
+		&models.SettingDef{Key: recoveryConsumedKey, Type: models.SettingString, Category: CategorySystem, Hidden: true},
+		&models.SettingDef{Key: apiTokenKey, Type: models.SettingString, Category: CategorySystem, Hidden: true},
+		&models.SettingDef{Key: gitIdentitySuggestionKey, Type: models.SettingString, Category: CategorySystem, Hidden: true},
+		&models.SettingDef{Key: gitIdentityDismissedKey, Type: models.SettingBool, Category: CategorySystem, Hidden: true},
+		&models.SettingDef{Key: gitUserNameKey, Type: models.SettingString, Category: CategorySystem, Hidden: true},
+		&models.SettingDef{Key: gitUserEmailKey, Type: models.SettingString, Category: CategorySystem, Hidden: true},
+		&models.SettingDef{Key: "provider_token:*", Type: models.SettingString, Category: CategorySystem, Hidden: true},
+		&models.SettingDef{Key: notifyWebhookSecretKey, Type: models.SettingString, Category: CategorySystem, Hidden: true},
+		&models.SettingDef{Key: smtpPasswordKey, Type: models.SettingString, Category: CategorySystem, Hidden: true},
+		&models.SettingDef{Key: MetricsTokenKey, Type: models.SettingString, Category: CategorySystem, Hidden: true},
+		&models.SettingDef{Key: "autopull_paused:*", Type: models.SettingBool, Category: CategorySystem, Hidden: true},
+		&models.SettingDef{Key: "repo_group_collapsed:*", Type: models.SettingBool, Category: CategorySystem, Hidden: true},
+		&models.SettingDef{Key: "safety_disabled:*", Type: models.SettingBool, Category: CategorySystem, Hidden: true},
+		&models.SettingDef{Key: "ssh_verified:*", Type: models.SettingString, Category: CategorySystem, Hidden: true},
+		&models.SettingDef{Key: sshPublicKeyKey, Type: models.SettingString, Category: CategorySystem, Hidden: true},
+		&models.SettingDef{Key: sshRotatedAtKey, Type: models.SettingString, Category: CategorySystem, Hidden: true},
+		&models.SettingDef{Key: "setup_steps:*", Type: models.SettingString, Category: CategorySystem, Hidden: true},
+		&models.SettingDef{Key: "setup_dismissed:*", Type: models.SettingString, Category: CategorySystem, Hidden: true},
+		&models.SettingDef{Key: sessionCookieKey, Type: models.SettingString, Category: CategorySystem, Hidden: true},
+		&models.SettingDef{Key: sessionsStrictKey, Type: models.SettingBool, Category: CategorySystem, Hidden: true},
+		&models.SettingDef{Key: "ratelimit_lockouts:*", Type: models.SettingString, Category: CategorySystem, Hidden: true},
+		&models.SettingDef{Key: "post_clone_hooks:*", Type: models.SettingString, Category: CategorySystem, Hidden: true},
+		&models.SettingDef{Key: "job_paused:*", Type: models.SettingBool, Category: CategorySystem, Hidden: true},
+		&models.SettingDef{Key: desiredExtensionsKey, Type: models.SettingString, Category: CategorySystem, Hidden: true},
+	)
+}
+
+func checkTimezone(value string) error {
+	_, err := time.LoadLocation(value)
+	return err
+}
+
+func checkClock(value string) error {
+	if _, ok := parseClock(value); !ok {
+		return errors.New("not HH:MM")
+	}
+	return nil
+}
+
+func checkWebhookURL(value string) error {
+	if !strings.HasPrefix(value, "https://") && !strings.HasPrefix(value, "http://") {
+		return errors.New("not http(s)")
+	}
+	return nil
+}
+
+func checkEmailAddress(value string) error {
+	_, err := mail.ParseAddress(value)
+	return err
+}
+
+func checkAutoPullInterval(value string) error {
+	minutes, _ := strconv.Atoi(value)
+	if !slices.Contains(AutoPullIntervals, minutes) {
+		return errors.New("not an offered interval")
+	}
+	return nil
+}
+
+func checkBranchName(value string) error {
+	if !validDefaultBranch(value) {
+		return errors.New("invalid branch name")
+	}
+	return nil
+}
+
+func checkStorageName(value string) error {
+	if value == "" {
+		return nil
+	}
+	if _, err := storageByName(value); err != nil {
+		return err
+	}
+	return nil
+}
+
+// settingSavers apply settings whose change has effects beyond the stored
+// value, so saving them from the settings page matches their own forms.
+var settingSavers = map[string]func(string) error{
+	"scaffold_default_branch": SetDefaultBranch,
+}
+
+// SettingEntry is one row of the settings page.
+type SettingEntry struct {
+	*models.SettingDef
+	Value      string // In effect: the stored value or the default
+	Stored     bool   // Set explicitly rather than defaulted
+	Registered bool
+	Error      string // Why the submitted value was rejected
+}
+
+// SettingGroup is the settings of one category.
+type SettingGroup struct {
+	Category string
+	Settings []*SettingEntry
+}
+
+// SettingCategories returns the categories the settings page can filter by.
+func SettingCategories() []string {
+	return append(slices.Clone(settingCategories), CategoryUnregistered)
+}
+
+// storedSettings reads every saved setting; tests replace it.
+var storedSettings = func() ([]*models.Setting, error) {
+	return models.Settings.Search("ORDER BY Key ASC")
+}
+
+// SettingGroups returns the settings page: every visible registered
+// setting grouped by category, then saved keys the registry doesn't know.
+// query matches keys and descriptions; category limits to one group.
+func SettingGroups(query, category string) []*SettingGroup {
+	stored := map[string]bool{}
+	var unregistered []*SettingEntry
+	settings, _ := storedSettings()
+	for _, s := range settings {
+		stored[s.Key] = s.Value != ""
+		if models.LookupSetting(s.Key) == nil {
+			// Values are left out: unknown keys may hold secrets
+			unregistered = append(unregistered, &SettingEntry{SettingDef: &models.SettingDef{
+				Key: s.Key, Type: models.SettingString, Category: CategoryUnregistered,
+				Description: fmt.Sprintf("Saved as %s but not in the settings registry", s.Type),
+			}})
+		}
+	}
+
+	var groups []*SettingGroup
+	add := func(name string, entries []*SettingEntry) {
+		if (category != "" && category != name) || len(entries) == 0 {
+			return
+		}
+		groups = append(groups, &SettingGroup{Category: name, Settings: entries})
+	}
+
+	query = strings.ToLower(strings.TrimSpace(query))
+	matches := func(def *models.SettingDef) bool {
+		return query == "" || strings.Contains(strings.ToLower(def.Key), query) || strings.Contains(strings.ToLower(def.Description), query)
+	}
+	defs := models.RegisteredSettings()
+	for _, name := range settingCategories {
+		var entries []*SettingEntry
+		for _, def := range defs {
+			if def.Category == name && !def.Hidden && matches(def) {
+				entries = append(entries, &SettingEntry{SettingDef: def, Value: models.SettingOrDefault(def.Key), Stored: stored[def.Key], Registered: true})
+			}
+		}
+		add(name, entries)
+	}
+	add(CategoryUnregistered, slices.DeleteFunc(unregistered, func(e *SettingEntry) bool { return !matches(e.SettingDef) }))
+	return groups
+}
+
+// SaveSetting validates and saves a setting from the settings page. When
+// the value breaks the setting's rule or can't be saved, the entry holds
+// the submitted value and the reason in Error, so the row can be shown
+// again with the message. Only unknown and hidden keys return an error.
+func SaveSetting(key, value string) (*SettingEntry, error) {
+	def := models.LookupSetting(key)
+	if def == nil || def.Hidden || strings.HasSuffix(def.Key, "*") {
+		return nil, fmt.Errorf("unknown setting '%s'", key)
+	}
+
+	entry := &SettingEntry{SettingDef: def, Registered: true}
+	normalized, err := models.NormalizeSetting(key, value)
+	var invalid *models.SettingError
+	if errors.As(err, &invalid) {
+		entry.Value, entry.Error = value, invalid.Rule
+		return entry, nil
+	}
+
+	if save, ok := settingSavers[key]; ok && normalized != "" {
+		err = save(normalized)
+	} else if _, err = models.SetSetting(key, normalized, "user_preference"); err != nil {
+		err = fmt.Errorf("failed to save setting")
+	}
+	if err != nil {
+		entry.Value, entry.Error = value, err.Error()
+		return entry, nil
+	}
+	entry.Value, entry.Stored = models.SettingOrDefault(key), normalized != ""
+	return entry, nil
+}
@@ -0,0 +1,63 @@
+package internal
+
+import (
+	"testing"
+	"workbench/models"
+
+	"github.com/The-Skyscape/devtools/pkg/testutils"
+)
+
+func TestRegisteredSettingRules(t *testing.T) {
+	testCases := []struct {
+		key, value, expected string
+	}{
+		{"timezone", "Europe/Berlin", ""},
+		{"timezone", "Mars/Olympus", "timezone must be a timezone such as Europe/Berlin"},
+		{"activity_digest_time", "07:30", ""},
+		{"activity_digest_time", "7pm", "activity_digest_time must be a time of day as HH:MM"},
+		{"notify_webhook_url", "ftp://hooks.example.com", "notify_webhook_url must be an http(s) URL"},
+		{"autopull_interval", "1h", ""},
+		{"autopull_interval", "45", "autopull_interval must be 0, 15, 60, 360, or 1440 minutes"},
+		{"missing_repo_behavior", "ignore", "missing_repo_behavior must be one of auto-reclone, prompt, fail"},
+		{"operation_timeout", "0", "operation_timeout must be from 1 to 86400 seconds"},
+		{"scaffold_default_branch", "--force", "scaffold_default_branch must be a valid branch name"},
+		{"api_write_rate", "-5", "api_write_rate must be a positive whole number"},
+		{"locale", "de", ""},
+		{"autopull_paused:github.com", "yes", ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.key+"="+tc.value, func(t *testing.T) {
+			message := ""
+			if _, err := models.NormalizeSetting(tc.key, tc.value); err != nil {
+				message = err.Error()
+			}
+			testutils.AssertEqual(t, tc.expected, message)
+		})
+	}
+}
+
+func TestRegisteredSettingDefaults(t *testing.T) {
+	testutils.AssertEqual(t, "600", models.LookupSetting("operation_timeout").Default)
+	testutils.AssertEqual(t, DefaultBranchName, models.LookupSetting("scaffold_default_branch").Default)
+	testutils.AssertEqual(t, "32768", models.LookupSetting("coder_proxy_buffer").Default)
+
+	// Every default passes its own rule
+	for _, def := range models.RegisteredSettings() {
+		if _, err := models.NormalizeSetting(def.Key, def.Default); err != nil {
+			t.Errorf("default for %s is invalid: %v", def.Key, err)
+		}
+	}
+}
+
+func TestSaveSettingShowsRule(t *testing.T) {
+	entry, err := SaveSetting("notify_webhook_url", "hooks.example.com")
+	testutils.AssertEqual(t, nil, err)
+	testutils.AssertEqual(t, "hooks.example.com", entry.Value)
+	testutils.AssertEqual(t, "must be an http(s) URL", entry.Error)
+
+	for _, key := range []string{"not_a_setting", "provider_token:github.com", "activity_digest_last"} {
+		_, err = SaveSetting(key, "x")
+		testutils.AssertEqual(t, "unknown setting '"+key+"'", err.Error())
+	}
+}
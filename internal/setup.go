@@ -0,0 +1,309 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+	"workbench/models"
+	"workbench/services"
+)
+
+// SetupStep is a suggested command to prepare a freshly cloned repository,
+// such as installing dependencies or creating a local .env file.
+type SetupStep struct {
+	ID        string // Stable rule identifier, used for dismissals and hooks
+	Title     string // Human-readable description of the step
+	Command   string // Exact shell command run from the repository root
+	Dismissed bool   `json:"-"` // User dismissed this suggestion for the repository
+	AutoRun   bool   `json:"-"` // Registered as a post-clone hook for the repository
+}
+
+func init() {
+	viewSetup := ActivityActionDef{
+		Label:        "Setup steps",
+		Method:       ActionGet,
+		RequiresRepo: true,
+		URL: func(a *models.Activity, repo *models.Repository) string {
+			return "/partials/setup/" + repoPath(repo)
+		},
+	}
+	RegisterActivityAction("repo_clone", viewSetup)
+	RegisterActivityAction("repo_setup_failed", viewSetup)
+}
+
+// repoFiles is a read-only snapshot of a repository root used by setup rules.
+type repoFiles struct {
+	names       map[string]bool // Entries in the repository root
+	makeTargets []string        // Common Makefile targets that are defined
+}
+
+func (f repoFiles) has(name string) bool {
+	return f.names[name]
+}
+
+// setupRule detects a manifest in the repository root and returns the
+// command that prepares it, or empty string if the rule doesn't apply.
+type setupRule struct {
+	ID     string
+	Title  string
+	Detect func(f repoFiles) string
+}
+
+// makeTargets lists Makefile targets conventionally used for project setup,
+// in order of preference.
+var makeTargets = []string{"setup", "bootstrap", "install", "deps"}
+
+// setupRules is the table of known manifests. Add new ecosystems here.
+var setupRules = []setupRule{
+	{"npm", "Install Node.js dependencies", func(f repoFiles) string {
+		if f.has("package.json") && f.has("package-lock.json") {
+			return "npm ci"
+		}
+		return ""
+	}},
+	{"yarn", "Install Node.js dependencies", func(f repoFiles) string {
+		if f.has("package.json") && f.has("yarn.lock") {
+			return "yarn install --frozen-lockfile"
+		}
+		return ""
+	}},
+	{"pnpm", "Install Node.js dependencies", func(f repoFiles) string {
+		if f.has("package.json") && f.has("pnpm-lock.yaml") {
+			return "pnpm install --frozen-lockfile"
+		}
+		return ""
+	}},
+	{"go", "Download Go modules", func(f repoFiles) string {
+		if f.has("go.mod") {
+			return "go mod download"
+		}
+		return ""
+	}},
+	{"poetry", "Install Python dependencies", func(f repoFiles) string {
+		if f.has("poetry.lock") {
+			return "poetry install"
+		}
+		return ""
+	}},
+	{"pip", "Install Python dependencies", func(f repoFiles) string {
+		if f.has("requirements.txt") && !f.has("poetry.lock") {
+			return "pip install -r requirements.txt"
+		}
+		return ""
+	}},
+	{"make", "Run Makefile setup target", func(f repoFiles) string {
+		if f.has("Makefile") && len(f.makeTargets) > 0 {
+			return "make " + f.makeTargets[0]
+		}
+		return ""
+	}},
+	{"env", "Create local environment file", func(f repoFiles) string {
+		if f.has(".env.example") && !f.has(".env") {
+			return "cp .env.example .env"
+		}
+		return ""
+	}},
+}
+
+// detectSetupSteps applies every rule to a repository snapshot.
+func detectSetupSteps(f repoFiles) []*SetupStep {
+	var steps []*SetupStep
+	for _, rule := range setupRules {
+		if cmd := rule.Detect(f); cmd != "" {
+			steps = append(steps, &SetupStep{ID: rule.ID, Title: rule.Title, Command: cmd})
+		}
+	}
+	return steps
+}
+
+// DetectSetupSteps returns suggested setup commands for a repository.
+// Suggestions are detected when the repository is cloned and cached, so
+// rendering them doesn't inspect the repository; repositories cloned
+// before caching are inspected once on first use. Dismissed and auto-run
+// state is loaded from settings for each step.
+func DetectSetupSteps(repoName string) ([]*SetupStep, error) {
+	var steps []*SetupStep
+	cached, err := models.GetSetting(setupStepsKey(repoName))
+	if err != nil || json.Unmarshal([]byte(cached), &steps) != nil {
+		if steps, err = RefreshSetupSteps(repoName); err != nil {
+			return nil, err
+		}
+	}
+
+	dismissed := settingList(dismissedStepsKey(repoName))
+	hooks := settingList(postCloneHooksKey(repoName))
+	for _, step := range steps {
+		step.Dismissed = slices.Contains(dismissed, step.ID)
+		step.AutoRun = slices.Contains(hooks, step.ID)
+	}
+	return steps, nil
+}
+
+// RefreshSetupSteps inspects the repository root for known manifests and
+// caches the suggested setup commands. Detection is read-only: it lists
+// the root directory and reads the Makefile, but never executes a
+// suggestion.
+func RefreshSetupSteps(repoName string) ([]*SetupStep, error) {
+	repo, err := models.Repositories.Find("WHERE Name = ?", repoName)
+	if err != nil {
+		return nil, fmt.Errorf("repository '%s' not found", repoName)
+	}
+
+	output, err := services.CoderExec(fmt.Sprintf("ls -A1 %s", shellQuote(repo.LocalPath)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect repository")
+	}
+
+	files := repoFiles{names: map[string]bool{}}
+	for _, name := range strings.Split(output, "\n") {
+		if name = strings.TrimSpace(name); name != "" {
+			files.names[name] = true
+		}
+	}
+
+	if files.has("Makefile") {
+		cmd := fmt.Sprintf("grep -oE '^[A-Za-z_-]+:' %s/Makefile 2>/dev/null", shellQuote(repo.LocalPath))
+		targets, _ := services.CoderExec(cmd)
+		defined := map[string]bool{}
+		for _, line := range strings.Split(targets, "\n") {
+			defined[strings.TrimSuffix(strings.TrimSpace(line), ":")] = true
+		}
+		for _, target := range makeTargets {
+			if defined[target] {
+				files.makeTargets = append(files.makeTargets, target)
+			}
+		}
+	}
+
+	steps := detectSetupSteps(files)
+	if data, err := json.Marshal(steps); err == nil {
+		models.SetSetting(setupStepsKey(repoName), string(data), "repository")
+	}
+	return steps, nil
+}
+
+// RunSetupStep starts a detected setup step in the repository root as a
+// background task. Only steps that are currently detected can be run, so
+// arbitrary commands cannot be injected through the step ID. Suggestions
+// are re-detected when the step finishes, since it may have created files.
+func RunSetupStep(repoName, stepID string) (*Task, error) {
+	step, err := findSetupStep(repoName, stepID)
+	if err != nil {
+		return nil, err
+	}
+
+	repo, err := models.Repositories.Find("WHERE Name = ?", repoName)
+	if err != nil {
+		return nil, fmt.Errorf("repository '%s' not found", repoName)
+	}
+
+	return StartTask(repoName, repo.LocalPath, step.Command, func(output string, err error) {
+		activity := &models.Activity{
+			Type:        "repo_setup",
+			Repository:  repoName,
+			Description: fmt.Sprintf("Ran setup step '%s' in %s", step.Command, repoName),
+			Author:      "System",
+			Timestamp:   time.Now(),
+		}
+		if err != nil {
+			activity.Type = "repo_setup_failed"
+			activity.Description = fmt.Sprintf("Setup step '%s' failed in %s", step.Command, repoName)
+		}
+		models.Activities.Insert(activity)
+		RefreshSetupSteps(repoName)
+	}), nil
+}
+
+// DismissSetupStep hides a setup suggestion for a repository.
+func DismissSetupStep(repoName, stepID string) error {
+	return addSettingListItem(dismissedStepsKey(repoName), stepID)
+}
+
+// SetPostCloneHook registers or removes a setup step that runs
+// automatically whenever the repository is cloned again.
+func SetPostCloneHook(repoName, stepID string, enabled bool) error {
+	if _, err := findSetupStep(repoName, stepID); err != nil {
+		return err
+	}
+	if enabled {
+		return addSettingListItem(postCloneHooksKey(repoName), stepID)
+	}
+	return removeSettingListItem(postCloneHooksKey(repoName), stepID)
+}
+
+// runPostCloneHooks detects setup steps for a fresh clone and runs the
+// ones registered for the repository, one after another. Failures are
+// recorded in the activity log but don't fail the clone.
+func runPostCloneHooks(repoName string) {
+	if _, err := RefreshSetupSteps(repoName); err != nil {
+		return
+	}
+	for _, stepID := range settingList(postCloneHooksKey(repoName)) {
+		task, err := RunSetupStep(repoName, stepID)
+		if err != nil {
+			go models.Activities.Insert(&models.Activity{
+				Type:        "repo_setup_failed",
+				Repository:  repoName,
+				Description: fmt.Sprintf("Post-clone hook '%s' failed for %s: %v", stepID, repoName, err),
+				Author:      "System",
+				Timestamp:   time.Now(),
+			})
+			continue
+		}
+		task.Wait()
+	}
+}
+
+// findSetupStep returns a currently detected step by ID.
+func findSetupStep(repoName, stepID string) (*SetupStep, error) {
+	steps, err := DetectSetupSteps(repoName)
+	if err != nil {
+		return nil, err
+	}
+	for _, step := range steps {
+		if step.ID == stepID {
+			return step, nil
+		}
+	}
+	return nil, fmt.Errorf("setup step '%s' does not apply to %s", stepID, repoName)
+}
+
+func dismissedStepsKey(repoName string) string {
+	return "setup_dismissed:" + repoName
+}
+
+func setupStepsKey(repoName string) string {
+	return "setup_steps:" + repoName
+}
+
+func postCloneHooksKey(repoName string) string {
+	return "post_clone_hooks:" + repoName
+}
+
+// settingList reads a comma-separated list setting.
+func settingList(key string) []string {
+	value, _ := models.GetSetting(key)
+	if value == "" {
+		return nil
+	}
+	return strings.Split(value, ",")
+}
+
+// addSettingListItem appends an item to a comma-separated list setting.
+func addSettingListItem(key, item string) error {
+	items := settingList(key)
+	if slices.Contains(items, item) {
+		return nil
+	}
+	_, err := models.SetSetting(key, strings.Join(append(items, item), ","), "repository")
+	return err
+}
+
+// removeSettingListItem removes an item from a comma-separated list setting.
+func removeSettingListItem(key, item string) error {
+	items := slices.DeleteFunc(settingList(key), func(s string) bool { return s == item })
+	_, err := models.SetSetting(key, strings.Join(items, ","), "repository")
+	return err
+}
@@ -0,0 +1,66 @@
+package internal
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/The-Skyscape/devtools/pkg/testutils"
+)
+
+func files(names ...string) repoFiles {
+	f := repoFiles{names: map[string]bool{}}
+	for _, name := range names {
+		f.names[name] = true
+	}
+	return f
+}
+
+func commands(steps []*SetupStep) string {
+	var cmds []string
+	for _, step := range steps {
+		cmds = append(cmds, step.Command)
+	}
+	return strings.Join(cmds, "; ")
+}
+
+func TestDetectSetupSteps(t *testing.T) {
+	testCases := []struct {
+		name     string
+		files    repoFiles
+		expected string
+	}{
+		{"empty", files(), ""},
+		{"npm", files("package.json", "package-lock.json"), "npm ci"},
+		{"yarn", files("package.json", "yarn.lock"), "yarn install --frozen-lockfile"},
+		{"pnpm", files("package.json", "pnpm-lock.yaml"), "pnpm install --frozen-lockfile"},
+		{"package.json without lockfile", files("package.json"), ""},
+		{"go", files("go.mod", "go.sum"), "go mod download"},
+		{"pip", files("requirements.txt"), "pip install -r requirements.txt"},
+		{"poetry", files("pyproject.toml", "poetry.lock"), "poetry install"},
+		{"poetry wins over pip", files("requirements.txt", "poetry.lock"), "poetry install"},
+		{"env", files(".env.example"), "cp .env.example .env"},
+		{"env already present", files(".env.example", ".env"), ""},
+		{"makefile without targets", files("Makefile"), ""},
+		{"multiple", files("go.mod", ".env.example"), "go mod download; cp .env.example .env"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			testutils.AssertEqual(t, tc.expected, commands(detectSetupSteps(tc.files)))
+		})
+	}
+}
+
+func TestDetectSetupStepsMakefile(t *testing.T) {
+	f := files("Makefile")
+	f.makeTargets = []string{"setup", "install"}
+	testutils.AssertEqual(t, "make setup", commands(detectSetupSteps(f)))
+}
+
+func TestSetupRuleIDsUnique(t *testing.T) {
+	seen := map[string]bool{}
+	for _, rule := range setupRules {
+		testutils.AssertEqual(t, false, seen[rule.ID])
+		seen[rule.ID] = true
+	}
+}
@@ -0,0 +1,409 @@
+package internal
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"workbench/models"
+	"workbench/services"
+)
+
+// Shared resource types.
+const (
+	ShareFile   = "file"
+	ShareDiff   = "diff"
+	ShareReadme = "readme"
+)
+
+// Share link lifetimes.
+const (
+	DefaultShareExpiry = 7 * 24 * time.Hour
+	MaxShareExpiry     = 30 * 24 * time.Hour
+)
+
+// shareRetention is how long expired shares stay listed before the
+// pruning job removes them. Revoked ones go once they would have expired.
+const shareRetention = 30 * 24 * time.Hour
+
+// shareRefPattern matches a commit, branch, tag, or revision such as
+// HEAD~2 that a share can name. It can't start with a dash, so git
+// never takes it for an option.
+var shareRefPattern = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9._/~^-]*$`)
+
+// readmePattern matches the README files a readme share picks from the
+// repository root.
+var readmePattern = regexp.MustCompile(`(?i)^readme(\.(md|markdown|txt|rst|adoc))?$`)
+
+// Seams for share links. Replaced in tests.
+var (
+	shareExec = func(command string, maxOutput int) (string, error) {
+		result, err := services.CoderExecWithOptions(command, services.ExecOptions{MaxOutput: maxOutput})
+		return result.Output, err
+	}
+	shareRepo = func(name string) (*models.Repository, error) {
+		return models.Repositories.Find("WHERE Name = ?", name)
+	}
+	shareByHash = func(hash string) (*models.Share, error) {
+		return models.Shares.Find("WHERE TokenHash = ?", hash)
+	}
+	insertShare      = func(share *models.Share) (*models.Share, error) { return models.Shares.Insert(share) }
+	updateShare      = func(share *models.Share) error { return models.Shares.Update(share) }
+	countShareAccess = models.CountShareAccess
+	sharingOn        = func() bool { return models.GetBoolSetting("share_links") }
+	shareEvent       = func(activity *models.Activity) { go models.Activities.Insert(activity) }
+)
+
+// ErrShareNotFound is returned by OpenShare for links that don't work.
+var ErrShareNotFound = &AppError{Code: CodeNotFound, UserMessage: "This link doesn't exist or has expired"}
+
+// ShareOptions are the inputs for creating a share link.
+type ShareOptions struct {
+	Type       string `json:"type" api:"required,enum=file|diff|readme"`
+	Repository string `json:"repository" api:"required"`
+	Path       string `json:"path"` // The file of a file share; limits a diff to it
+	// Commit, branch, or tag a file or README is shown at, HEAD when
+	// empty; a diff's commit or A..B range. Resolved to commit ids, so the
+	// link keeps showing the same content.
+	Ref       string `json:"ref"`
+	ExpiresIn int    `json:"expires_in_hours"` // DefaultShareExpiry when 0, up to MaxShareExpiry
+}
+
+// SharedView is what a share link shows: the text of a file or README,
+// or a diff.
+type SharedView struct {
+	*models.Share
+	Content  string
+	Binary   bool
+	Size     uint64
+	TooLarge bool
+	Diff     *DiffSection
+}
+
+// SharingEnabled reports whether share links work, per the share_links
+// setting.
+func SharingEnabled() bool {
+	return sharingOn()
+}
+
+// CreateShare checks the resource exists and records a share link for
+// it, returning the share and the token for its URL. The token is only
+// returned here; the share keeps its hash.
+func CreateShare(opts ShareOptions) (*models.Share, string, error) {
+	if !sharingOn() {
+		return nil, "", &AppError{Code: CodeConflict, UserMessage: "Share links are turned off - turn on share_links in Settings first"}
+	}
+	expiry := time.Duration(opts.ExpiresIn) * time.Hour
+	switch {
+	case opts.ExpiresIn == 0:
+		expiry = DefaultShareExpiry
+	case opts.ExpiresIn < 0 || expiry > MaxShareExpiry:
+		return nil, "", &repoError{ErrInvalidInput, fmt.Sprintf("links expire after 1 to %d hours", int(MaxShareExpiry/time.Hour))}
+	}
+	repo, err := shareRepo(opts.Repository)
+	if err != nil {
+		return nil, "", repoNotFound(opts.Repository)
+	}
+
+	share := &models.Share{ResourceType: opts.Type, Repository: repo.Name, ExpiresAt: time.Now().Add(expiry)}
+	switch opts.Type {
+	case ShareFile, ShareReadme:
+		err = resolveSharedFile(repo, share, opts)
+	case ShareDiff:
+		err = resolveSharedDiff(repo, share, opts)
+	default:
+		err = &repoError{ErrInvalidInput, "type must be file, diff, or readme"}
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	token, err := newShareToken()
+	if err != nil {
+		return nil, "", err
+	}
+	share.TokenHash = hashShareToken(token)
+	if share, err = insertShare(share); err != nil {
+		return nil, "", fmt.Errorf("failed to save share link: %w", err)
+	}
+
+	shareEvent(&models.Activity{
+		Type:        "share_create",
+		Repository:  share.Repository,
+		Description: fmt.Sprintf("Shared %s until %s", DescribeShare(share), share.ExpiresAt.UTC().Format("2006-01-02 15:04 UTC")),
+		Author:      "System",
+		Timestamp:   time.Now(),
+	})
+	return share, token, nil
+}
+
+// resolveSharedFile pins a file or README share to a commit, checking the
+// file is there. A readme share picks the README in the repository root.
+func resolveSharedFile(repo *models.Repository, share *models.Share, opts ShareOptions) error {
+	ref := opts.Ref
+	if ref == "" {
+		ref = "HEAD"
+	}
+	commit, err := resolveShareRef(repo, ref)
+	if err != nil {
+		return err
+	}
+	share.RefRange = commit
+
+	if share.ResourceType == ShareReadme {
+		output, err := shareExec(fmt.Sprintf("cd %s && git ls-tree --name-only %s 2>&1", shellQuote(repo.LocalPath), commit), 64<<10)
+		if err != nil {
+			return fmt.Errorf("failed to list the repository root: %s", strings.TrimSpace(output))
+		}
+		for _, name := range strings.Split(output, "\n") {
+			if readmePattern.MatchString(name) {
+				share.Path = name
+				return nil
+			}
+		}
+		return &repoError{ErrInvalidInput, fmt.Sprintf("'%s' has no README at %s", repo.Name, ref)}
+	}
+
+	rel, err := cleanBrowsePath(opts.Path)
+	if err != nil || rel == "." {
+		return &repoError{ErrInvalidInput, "path must be a file in the repository"}
+	}
+	output, err := shareExec(fmt.Sprintf("cd %s && git cat-file -t %s 2>&1", shellQuote(repo.LocalPath), shellQuote(commit+":"+rel)), 1024)
+	if err != nil || strings.TrimSpace(output) != "blob" {
+		return &repoError{ErrInvalidInput, fmt.Sprintf("'%s' isn't a committed file at %s - commit it first", rel, ref)}
+	}
+	share.Path = rel
+	return nil
+}
+
+// resolveSharedDiff pins a diff share to the commit ids of its commit or
+// range, checking its path when one limits it.
+func resolveSharedDiff(repo *models.Repository, share *models.Share, opts ShareOptions) error {
+	if opts.Ref == "" {
+		return &repoError{ErrInvalidInput, "a diff needs a commit or a range such as main..feature"}
+	}
+	refs := []string{opts.Ref}
+	if from, to, isRange := strings.Cut(opts.Ref, ".."); isRange {
+		refs = []string{from, to}
+	}
+	var commits []string
+	for _, ref := range refs {
+		commit, err := resolveShareRef(repo, ref)
+		if err != nil {
+			return err
+		}
+		commits = append(commits, commit)
+	}
+	share.RefRange = strings.Join(commits, "..")
+
+	if opts.Path != "" {
+		rel, err := cleanBrowsePath(opts.Path)
+		if err != nil || rel == "." {
+			return &repoError{ErrInvalidInput, "path must be a file or directory in the repository"}
+		}
+		share.Path = rel
+	}
+	return nil
+}
+
+// resolveShareRef returns the commit id ref names in repo.
+func resolveShareRef(repo *models.Repository, ref string) (string, error) {
+	if !shareRefPattern.MatchString(ref) || strings.Contains(ref, "..") {
+		return "", &repoError{ErrInvalidInput, fmt.Sprintf("'%s' isn't a commit, branch, or tag", ref)}
+	}
+	output, err := shareExec(fmt.Sprintf("cd %s && git rev-parse -q --verify %s 2>&1", shellQuote(repo.LocalPath), shellQuote(ref+"^{commit}")), 1024)
+	commit := strings.TrimSpace(output)
+	if err != nil || !commitIDPattern.MatchString(commit) {
+		return "", &repoError{ErrInvalidInput, fmt.Sprintf("'%s' isn't a commit in '%s'", ref, repo.Name)}
+	}
+	return commit, nil
+}
+
+// OpenShare returns what the share link for token shows and counts the
+// view. Links that are unknown, revoked, expired, or off, and resources
+// that are gone, all return ErrShareNotFound, so the link reveals nothing
+// about why.
+func OpenShare(token string) (*SharedView, error) {
+	if !sharingOn() || token == "" {
+		return nil, ErrShareNotFound
+	}
+	share, err := shareByHash(hashShareToken(token))
+	if err != nil || share == nil || share.Revoked || !time.Now().Before(share.ExpiresAt) {
+		return nil, ErrShareNotFound
+	}
+	repo, err := shareRepo(share.Repository)
+	if err != nil {
+		return nil, ErrShareNotFound
+	}
+
+	view := &SharedView{Share: share}
+	if share.ResourceType == ShareDiff {
+		err = readSharedDiff(repo, view)
+	} else {
+		err = readSharedFile(repo, view)
+	}
+	if err != nil {
+		slog.Warn("failed to read shared resource", "share", share.ID, "repository", share.Repository, "error", err)
+		return nil, ErrShareNotFound
+	}
+
+	// Counted in the database, since views opened together each loaded
+	// their own copy of the share
+	share.Accesses++
+	share.LastAccess = time.Now()
+	if err := countShareAccess(share.ID, share.LastAccess); err != nil {
+		slog.Error("failed to count share access", "share", share.ID, "error", err)
+	}
+	return view, nil
+}
+
+// readSharedFile reads a shared file or README as committed, up to
+// MaxRepoFileSize.
+func readSharedFile(repo *models.Repository, view *SharedView) error {
+	object := shellQuote(view.RefRange + ":" + view.Path)
+	output, err := shareExec(fmt.Sprintf("cd %s && git cat-file -s %s 2>&1", shellQuote(repo.LocalPath), object), 1024)
+	if err != nil {
+		return fmt.Errorf("failed to read file size: %s", strings.TrimSpace(output))
+	}
+	view.Size, _ = strconv.ParseUint(strings.TrimSpace(output), 10, 64)
+	if view.Size > MaxRepoFileSize {
+		view.TooLarge = true
+		return nil
+	}
+
+	content, err := shareExec(fmt.Sprintf("cd %s && git cat-file blob %s", shellQuote(repo.LocalPath), object), MaxRepoFileSize)
+	if err != nil {
+		return fmt.Errorf("failed to read file")
+	}
+	view.Binary = isBinary(content)
+	if !view.Binary {
+		view.Content = content
+	}
+	return nil
+}
+
+// readSharedDiff reads a shared diff: a commit's changes with its
+// message, or the changes between the two commits of a range.
+func readSharedDiff(repo *models.Repository, view *SharedView) error {
+	from, to, isRange := strings.Cut(view.RefRange, "..")
+	command := "git show --no-color --no-ext-diff -M --format=medium " + from
+	if isRange {
+		command = "git diff --no-color --no-ext-diff -M " + from + " " + to
+	}
+	if view.Path != "" {
+		command += " -- " + shellQuote(view.Path)
+	}
+
+	script := fmt.Sprintf("cd %s && { echo '%s0 %s'; %s 2>&1 | head -c %d; }",
+		shellQuote(repo.LocalPath), diffMarker, ShareDiff, command, MaxRepoFileSize+1)
+	output, err := shareExec(script, MaxRepoFileSize+4096)
+	if err != nil {
+		return fmt.Errorf("failed to read diff: %s", strings.TrimSpace(output))
+	}
+	sections := parseDiffSections(output, MaxRepoFileSize)
+	if len(sections) == 0 {
+		return fmt.Errorf("failed to read diff")
+	}
+	view.Diff = sections[0].DiffSection
+	return nil
+}
+
+// GetShares returns the share links, newest first, revoked and expired
+// ones included until they're pruned.
+func GetShares() ([]*models.Share, error) {
+	return models.Shares.Search("ORDER BY CreatedAt DESC")
+}
+
+// RevokeShare stops a share link working. Its views stay counted.
+func RevokeShare(id string) error {
+	share, err := models.Shares.Get(id)
+	if err != nil || share.Revoked {
+		return fmt.Errorf("share link not found")
+	}
+	share.Revoked = true
+	if err := updateShare(share); err != nil {
+		return fmt.Errorf("failed to revoke share link: %w", err)
+	}
+
+	shareEvent(&models.Activity{
+		Type:        "share_revoke",
+		Repository:  share.Repository,
+		Description: fmt.Sprintf("Revoked the link to %s after %s", DescribeShare(share), plural(share.Accesses, "view", "views")),
+		Author:      "System",
+		Timestamp:   time.Now(),
+	})
+	return nil
+}
+
+// ShareStatus describes whether a share link works: "active", "revoked",
+// or "expired".
+func ShareStatus(share *models.Share) string {
+	switch {
+	case share.Revoked:
+		return "revoked"
+	case !time.Now().Before(share.ExpiresAt):
+		return "expired"
+	default:
+		return "active"
+	}
+}
+
+// DescribeShare names what a share link shows, e.g. "README.md at
+// 3f9c2ab" or "the diff 3f9c2ab..81d0e4c".
+func DescribeShare(share *models.Share) string {
+	from, to, isRange := strings.Cut(share.RefRange, "..")
+	switch {
+	case share.ResourceType != ShareDiff:
+		return fmt.Sprintf("%s at %s", share.Path, shortHash(from))
+	case isRange:
+		return fmt.Sprintf("the diff %s..%s", shortHash(from), shortHash(to))
+	default:
+		return fmt.Sprintf("the changes of %s", shortHash(from))
+	}
+}
+
+// The share pruning job removes share links that expired more than
+// shareRetention ago once a day.
+func init() {
+	RegisterJob(JobSpec{
+		Name:        "share-pruning",
+		Description: "Remove share links that expired a month ago",
+		Interval:    Every(24 * time.Hour),
+		Jitter:      time.Hour,
+		Priority:    PriorityLow,
+		Run:         pruneShares,
+	})
+}
+
+func pruneShares(now time.Time) error {
+	old, err := models.Shares.Search("WHERE ExpiresAt < ?", now.Add(-shareRetention))
+	if err != nil {
+		return fmt.Errorf("failed to load share links: %w", err)
+	}
+	for _, share := range old {
+		if err := models.Shares.Delete(share); err != nil {
+			return fmt.Errorf("failed to delete share link: %w", err)
+		}
+	}
+	return nil
+}
+
+// newShareToken returns a random 256-bit token for a share link, URL-safe.
+func newShareToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate share token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashShareToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
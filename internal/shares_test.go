@@ -0,0 +1,240 @@
+package internal
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+	"workbench/models"
+
+	"github.com/The-Skyscape/devtools/pkg/testutils"
+)
+
+const (
+	headCommit = "3f9c2ab0d1e4f5a6b7c8d9e0f1a2b3c4d5e6f7a8"
+	mainCommit = "81d0e4c7b6a5f4e3d2c1b0a9f8e7d6c5b4a3f2e1"
+)
+
+// shareFake is a repository with a README and main.go committed, and
+// the share links made for it.
+type shareFake struct {
+	enabled    bool
+	shares     map[string]*models.Share // By token hash
+	commands   []string
+	activities []*models.Activity
+	accesses   int // Counted in the database
+}
+
+func fakeShares(t *testing.T) *shareFake {
+	exec, repo, byHash, insert, update, count, on, event := shareExec, shareRepo, shareByHash, insertShare, updateShare, countShareAccess, sharingOn, shareEvent
+	t.Cleanup(func() {
+		shareExec, shareRepo, shareByHash, insertShare, updateShare, countShareAccess, sharingOn, shareEvent = exec, repo, byHash, insert, update, count, on, event
+	})
+
+	fake := &shareFake{enabled: true, shares: map[string]*models.Share{}}
+	shareRepo = func(name string) (*models.Repository, error) {
+		if name != "api" {
+			return nil, errors.New("not found")
+		}
+		return &models.Repository{Name: "api", LocalPath: "/home/coder/repos/api"}, nil
+	}
+	shareExec = func(command string, maxOutput int) (string, error) {
+		fake.commands = append(fake.commands, command)
+		switch {
+		case strings.Contains(command, "rev-parse"):
+			switch {
+			case strings.Contains(command, "'HEAD^{commit}'"):
+				return headCommit + "\n", nil
+			case strings.Contains(command, "'main^{commit}'"):
+				return mainCommit + "\n", nil
+			}
+			return "", errors.New("exit status 1")
+		case strings.Contains(command, "ls-tree"):
+			return "README.md\ncmd\nmain.go\n", nil
+		case strings.Contains(command, "cat-file -t"):
+			if strings.HasSuffix(command, ":main.go' 2>&1") {
+				return "blob\n", nil
+			}
+			return "fatal: path 'docs' does not exist\n", errors.New("exit status 128")
+		case strings.Contains(command, "cat-file -s"):
+			return "28\n", nil
+		case strings.Contains(command, "cat-file blob"):
+			return "package main\n\nfunc main() {}\n", nil
+		case strings.Contains(command, "git show"), strings.Contains(command, "git diff"):
+			return diffMarker + "0 diff\ndiff --git a/main.go b/main.go\n@@ -1 +1 @@\n-package old\n+package main\n", nil
+		}
+		return "", errors.New("unexpected command")
+	}
+	shareByHash = func(hash string) (*models.Share, error) {
+		if share, ok := fake.shares[hash]; ok {
+			return share, nil
+		}
+		return nil, errors.New("not found")
+	}
+	insertShare = func(share *models.Share) (*models.Share, error) {
+		share.ID = "share-1"
+		fake.shares[share.TokenHash] = share
+		return share, nil
+	}
+	updateShare = func(*models.Share) error { return nil }
+	countShareAccess = func(id string, at time.Time) error {
+		for _, share := range fake.shares {
+			if share.ID == id {
+				fake.accesses++
+			}
+		}
+		return nil
+	}
+	sharingOn = func() bool { return fake.enabled }
+	shareEvent = func(a *models.Activity) { fake.activities = append(fake.activities, a) }
+	return fake
+}
+
+func TestCreateShare(t *testing.T) {
+	testCases := []struct {
+		name string
+		opts ShareOptions
+		path string
+		ref  string
+	}{
+		{"file at head", ShareOptions{Type: ShareFile, Repository: "api", Path: "./main.go"}, "main.go", headCommit},
+		{"file at branch", ShareOptions{Type: ShareFile, Repository: "api", Path: "main.go", Ref: "main"}, "main.go", mainCommit},
+		{"readme", ShareOptions{Type: ShareReadme, Repository: "api"}, "README.md", headCommit},
+		{"commit diff", ShareOptions{Type: ShareDiff, Repository: "api", Ref: "HEAD"}, "", headCommit},
+		{"range diff", ShareOptions{Type: ShareDiff, Repository: "api", Ref: "main..HEAD", Path: "cmd"}, "cmd", mainCommit + ".." + headCommit},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			fake := fakeShares(t)
+
+			share, token, err := CreateShare(tc.opts)
+			testutils.AssertEqual(t, nil, err)
+			testutils.AssertEqual(t, tc.opts.Type, share.ResourceType)
+			testutils.AssertEqual(t, tc.path, share.Path)
+			testutils.AssertEqual(t, tc.ref, share.RefRange)
+			testutils.AssertEqual(t, 43, len(token))
+			testutils.AssertEqual(t, hashShareToken(token), share.TokenHash)
+			testutils.AssertEqual(t, false, strings.Contains(share.TokenHash, token))
+			testutils.AssertEqual(t, true, time.Until(share.ExpiresAt) > DefaultShareExpiry-time.Minute)
+			testutils.AssertEqual(t, 1, len(fake.activities))
+			testutils.AssertEqual(t, "share_create", fake.activities[0].Type)
+		})
+	}
+}
+
+func TestCreateShareRefused(t *testing.T) {
+	testCases := []struct {
+		name    string
+		opts    ShareOptions
+		enabled bool
+		want    string
+	}{
+		{"sharing off", ShareOptions{Type: ShareFile, Repository: "api", Path: "main.go"}, false, "turned off"},
+		{"unknown repository", ShareOptions{Type: ShareFile, Repository: "web", Path: "main.go"}, true, "web"},
+		{"unknown type", ShareOptions{Type: "tree", Repository: "api"}, true, "type must be"},
+		{"directory", ShareOptions{Type: ShareFile, Repository: "api", Path: "docs"}, true, "isn't a committed file"},
+		{"outside repository", ShareOptions{Type: ShareFile, Repository: "api", Path: "../other/main.go"}, true, "path must be"},
+		{"git directory", ShareOptions{Type: ShareFile, Repository: "api", Path: ".git/config"}, true, "path must be"},
+		{"option as ref", ShareOptions{Type: ShareDiff, Repository: "api", Ref: "--output=/tmp/x"}, true, "isn't a commit, branch, or tag"},
+		{"unknown ref", ShareOptions{Type: ShareDiff, Repository: "api", Ref: "feature"}, true, "isn't a commit in"},
+		{"diff without ref", ShareOptions{Type: ShareDiff, Repository: "api"}, true, "needs a commit"},
+		{"expiry too long", ShareOptions{Type: ShareFile, Repository: "api", Path: "main.go", ExpiresIn: 24*30 + 1}, true, "expire after"},
+		{"negative expiry", ShareOptions{Type: ShareFile, Repository: "api", Path: "main.go", ExpiresIn: -1}, true, "expire after"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			fake := fakeShares(t)
+			fake.enabled = tc.enabled
+
+			_, _, err := CreateShare(tc.opts)
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			message := err.Error()
+			var appErr *AppError
+			if errors.As(err, &appErr) {
+				message = appErr.UserMessage
+			}
+			testutils.AssertEqual(t, true, strings.Contains(message, tc.want))
+			testutils.AssertEqual(t, 0, len(fake.shares))
+		})
+	}
+}
+
+func TestOpenShare(t *testing.T) {
+	fake := fakeShares(t)
+	_, token, err := CreateShare(ShareOptions{Type: ShareFile, Repository: "api", Path: "main.go", ExpiresIn: 1})
+	testutils.AssertEqual(t, nil, err)
+
+	view, err := OpenShare(token)
+	testutils.AssertEqual(t, nil, err)
+	testutils.AssertEqual(t, "package main\n\nfunc main() {}\n", view.Content)
+	testutils.AssertEqual(t, uint64(28), view.Size)
+	testutils.AssertEqual(t, false, view.Binary)
+	view, _ = OpenShare(token)
+	testutils.AssertEqual(t, 2, view.Accesses)
+	testutils.AssertEqual(t, 2, fake.accesses)
+	testutils.AssertEqual(t, false, view.LastAccess.IsZero())
+
+	// Reads the committed blob, not the working tree
+	testutils.AssertEqual(t, true, strings.Contains(fake.commands[len(fake.commands)-1], "git cat-file blob '"+headCommit+":main.go'"))
+}
+
+func TestOpenShareDiff(t *testing.T) {
+	fakeShares(t)
+	_, token, err := CreateShare(ShareOptions{Type: ShareDiff, Repository: "api", Ref: "HEAD"})
+	testutils.AssertEqual(t, nil, err)
+
+	view, err := OpenShare(token)
+	testutils.AssertEqual(t, nil, err)
+	testutils.AssertEqual(t, 4, len(view.Diff.Lines))
+	testutils.AssertEqual(t, "add", view.Diff.Lines[3].Kind)
+}
+
+func TestOpenShareNotFound(t *testing.T) {
+	testCases := []struct {
+		name  string
+		token func(fake *shareFake, token string) string
+	}{
+		{"unknown token", func(fake *shareFake, token string) string { return token + "x" }},
+		{"empty token", func(fake *shareFake, token string) string { return "" }},
+		{"sharing off", func(fake *shareFake, token string) string {
+			fake.enabled = false
+			return token
+		}},
+		{"revoked", func(fake *shareFake, token string) string {
+			fake.shares[hashShareToken(token)].Revoked = true
+			return token
+		}},
+		{"expired", func(fake *shareFake, token string) string {
+			fake.shares[hashShareToken(token)].ExpiresAt = time.Now().Add(-time.Second)
+			return token
+		}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			fake := fakeShares(t)
+			_, token, err := CreateShare(ShareOptions{Type: ShareReadme, Repository: "api"})
+			testutils.AssertEqual(t, nil, err)
+
+			_, err = OpenShare(tc.token(fake, token))
+			testutils.AssertEqual(t, ErrShareNotFound, err)
+		})
+	}
+}
+
+func TestShareStatus(t *testing.T) {
+	now := time.Now()
+	testutils.AssertEqual(t, "active", ShareStatus(&models.Share{ExpiresAt: now.Add(time.Hour)}))
+	testutils.AssertEqual(t, "expired", ShareStatus(&models.Share{ExpiresAt: now.Add(-time.Hour)}))
+	testutils.AssertEqual(t, "revoked", ShareStatus(&models.Share{ExpiresAt: now.Add(time.Hour), Revoked: true}))
+}
+
+func TestDescribeShare(t *testing.T) {
+	testutils.AssertEqual(t, "main.go at 3f9c2ab", DescribeShare(&models.Share{ResourceType: ShareFile, Path: "main.go", RefRange: headCommit}))
+	testutils.AssertEqual(t, "the changes of 3f9c2ab", DescribeShare(&models.Share{ResourceType: ShareDiff, RefRange: headCommit}))
+	testutils.AssertEqual(t, "the diff 81d0e4c..3f9c2ab", DescribeShare(&models.Share{ResourceType: ShareDiff, RefRange: mainCommit + ".." + headCommit}))
+}
@@ -0,0 +1,332 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+	"workbench/internal/providers"
+	"workbench/models"
+)
+
+// Paths of the default key while it's rotated: the new pair is generated
+// beside it, and the previous one is kept for the grace period with
+// sshOldKeySuffix added to its own name, e.g. id_rsa_old.
+const (
+	sshKeyPath      = "~/.ssh/id_ed25519"
+	sshNewKeyPath   = sshKeyPath + "_new"
+	sshOldKeySuffix = "_old"
+)
+
+// sshOldKeyPaths are where a rotation may have kept the previous key, one
+// for each type currentSSHKeyPath finds.
+var sshOldKeyPaths = []string{"~/.ssh/id_ed25519" + sshOldKeySuffix, "~/.ssh/id_rsa" + sshOldKeySuffix}
+
+// DefaultSSHOldKeyGraceDays is how many days the previous key is kept
+// after a rotation unless the ssh_old_key_grace setting says otherwise.
+const DefaultSSHOldKeyGraceDays = 7
+
+// Settings a rotation keeps.
+const (
+	sshPublicKeyKey = "ssh_public_key"
+	sshRotatedAtKey = "ssh_key_rotated_at"
+)
+
+// SSHRotateOptions are the optional steps of RotateSSHKey.
+type SSHRotateOptions struct {
+	Tokens     map[providers.Kind]string // Access tokens to upload the new key with, used once
+	SaveTokens bool                      // Keep the tokens as the providers' access tokens too
+	Force      bool                      // Swap in a new key no host accepted yet
+}
+
+// SSHKeyRotation is the outcome of RotateSSHKey.
+type SSHKeyRotation struct {
+	PublicKey   string
+	OldKeyPath  string    // Where the previous key is kept, e.g. ~/.ssh/id_rsa_old
+	OldKeyUntil time.Time // When the previous key is removed; zero if it already was
+	Uploads     []*SSHKeyUpload
+	Hosts       []*SSHRotatedHost
+}
+
+// SSHKeyUpload is the upload of the new key to one provider.
+type SSHKeyUpload struct {
+	Provider   string // e.g. "GitHub"
+	Host       string
+	Error      string // Why the upload failed; empty when it succeeded
+	TokenSaved bool
+}
+
+// SSHRotatedHost is a host checked with both keys during a rotation.
+type SSHRotatedHost struct {
+	Host   string
+	Name   string
+	OldKey bool // The previous key authenticated before the swap
+	NewKey bool // The new key authenticated before the swap
+}
+
+// SSH key rotation seams. Replaced in tests.
+var (
+	sshRotateVerified = func(host string) bool { return readSSHHostStatus(host).Verified }
+	sshUploadKey      = providers.UploadSSHKey
+	sshSaveToken      = providers.SetToken
+	sshOldKeyGrace    = func() time.Duration { return models.GetDurationSetting("ssh_old_key_grace") }
+	sshRotatedAt      = func() string {
+		value, _ := models.GetSetting(sshRotatedAtKey)
+		return value
+	}
+	sshSaveRotation = func(publicKey, rotatedAt string) error {
+		if publicKey != "" {
+			if _, err := models.SetSetting(sshPublicKeyKey, publicKey, "ssh_key"); err != nil {
+				return err
+			}
+		}
+		_, err := models.SetSetting(sshRotatedAtKey, rotatedAt, "ssh_key")
+		return err
+	}
+	sshRotateActivity = func(description string) {
+		go models.Activities.Insert(&models.Activity{
+			Type:        "key_rotated",
+			Description: description,
+			Author:      "System",
+			Timestamp:   time.Now(),
+		})
+	}
+)
+
+// ErrSSHRotationRunning is returned while another rotation is running.
+var ErrSSHRotationRunning = errors.New("an SSH key rotation is already running")
+
+// ErrSSHNewKeyRejected is matched with errors.Is when RotateSSHKey keeps
+// the current key because no host accepted the new one.
+var ErrSSHNewKeyRejected = errors.New("no host accepted the new SSH key")
+
+// sshRotating is held while RotateSSHKey runs.
+var sshRotating sync.Mutex
+
+// RotateSSHKey replaces the default SSH key with a new Ed25519 pair.
+//
+// The new pair is generated as id_ed25519_new, and the current key is
+// checked against every host it was verified with; if it no longer
+// authenticates with any of them, nothing changes. The new key is then
+// uploaded with each token in opts and tried with the same hosts, before
+// the files are swapped. When none of them accepts it, nothing changes
+// either unless opts.Force is set. The previous key stays beside it with
+// an _old suffix, e.g. id_ed25519_old, for the ssh_old_key_grace setting,
+// so it can be put back by hand if a host turns out to still need it.
+// Recorded as key_rotated.
+func RotateSSHKey(email string, opts SSHRotateOptions) (*SSHKeyRotation, error) {
+	if !sshRotating.TryLock() {
+		return nil, ErrSSHRotationRunning
+	}
+	defer sshRotating.Unlock()
+
+	current, err := currentSSHKeyPath()
+	if err != nil {
+		return nil, err
+	}
+	cmd := fmt.Sprintf(`rm -f %[1]s %[1]s.pub && ssh-keygen -t ed25519 -C %[2]s -f %[1]s -N "" -q 2>&1 && chmod 600 %[1]s && chmod 644 %[1]s.pub && cat %[1]s.pub`,
+		sshNewKeyPath, shellQuote(email))
+	publicKey, err := sshExec(cmd)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate the new SSH key: %s", strings.TrimSpace(publicKey))
+	}
+	rotation := &SSHKeyRotation{PublicKey: strings.TrimSpace(publicKey), OldKeyPath: current + sshOldKeySuffix}
+
+	if err := checkOldSSHKey(rotation, current); err != nil {
+		sshExec(fmt.Sprintf("rm -f %[1]s %[1]s.pub", sshNewKeyPath))
+		return nil, err
+	}
+	uploadSSHKey(rotation, opts)
+	accepted := false
+	for _, host := range rotation.Hosts {
+		host.NewKey = TestSSHConnectionWithKey(host.Host, sshNewKeyPath) == nil
+		accepted = accepted || host.NewKey
+	}
+	if len(rotation.Hosts) > 0 && !accepted && !opts.Force {
+		sshExec(fmt.Sprintf("rm -f %[1]s %[1]s.pub", sshNewKeyPath))
+		var hosts []string
+		for _, host := range rotation.Hosts {
+			hosts = append(hosts, host.Host)
+		}
+		return nil, &repoError{ErrSSHNewKeyRejected, fmt.Sprintf("the new SSH key doesn't authenticate with %s yet, so the current key was kept - "+
+			"upload it with a token, or rotate anyway and add it to each host by hand", strings.Join(hosts, ", "))}
+	}
+
+	if err := swapSSHKeys(current, rotation.OldKeyPath); err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	for _, host := range rotation.Hosts {
+		// Verifications belong to the previous key
+		value := ""
+		if host.NewKey {
+			value = now.Format(time.RFC3339)
+		}
+		if err := sshSaveVerified(host.Host, value); err != nil {
+			slog.Warn("failed to save SSH verification", "host", host.Host, "error", err)
+		}
+	}
+	if err := sshSaveRotation(rotation.PublicKey, now.Format(time.RFC3339)); err != nil {
+		slog.Warn("failed to save the rotated SSH key", "error", err)
+	}
+
+	if grace := sshOldKeyGrace(); grace > 0 {
+		rotation.OldKeyUntil = now.Add(grace)
+	} else if err := RemoveOldSSHKey(now); err != nil {
+		slog.Warn("failed to remove the previous SSH key", "error", err)
+	}
+	sshRotateActivity(rotation.describe())
+	return rotation, nil
+}
+
+// currentSSHKeyPath returns the default private key, Ed25519 first.
+func currentSSHKeyPath() (string, error) {
+	name, err := sshExec("if [ -f ~/.ssh/id_ed25519 ]; then echo id_ed25519; elif [ -f ~/.ssh/id_rsa ]; then echo id_rsa; else exit 1; fi")
+	if err != nil || strings.TrimSpace(name) == "" {
+		return "", fmt.Errorf("no SSH key to rotate - generate one first")
+	}
+	return "~/.ssh/" + strings.TrimSpace(name), nil
+}
+
+// checkOldSSHKey tries the current key with the hosts it was verified
+// with, adding them to the rotation. It fails only when there were such
+// hosts and the key authenticates with none of them.
+func checkOldSSHKey(rotation *SSHKeyRotation, current string) error {
+	var failed []string
+	for _, known := range KnownSSHHosts {
+		if !sshRotateVerified(known.Host) {
+			continue
+		}
+		host := &SSHRotatedHost{Host: known.Host, Name: known.Name}
+		host.OldKey = TestSSHConnectionWithKey(known.Host, current) == nil
+		if !host.OldKey {
+			failed = append(failed, known.Host)
+		}
+		rotation.Hosts = append(rotation.Hosts, host)
+	}
+	if len(rotation.Hosts) > 0 && len(failed) == len(rotation.Hosts) {
+		return fmt.Errorf("the current SSH key no longer authenticates with %s - test it from the SSH checklist before rotating", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// uploadSSHKey adds the new key to each provider a token was given for,
+// and its host to the hosts the new key is tried with.
+func uploadSSHKey(rotation *SSHKeyRotation, opts SSHRotateOptions) {
+	title := "Workbench " + time.Now().Format("2006-01-02")
+	for _, kind := range []providers.Kind{providers.GitHub, providers.GitLab} {
+		token := strings.TrimSpace(opts.Tokens[kind])
+		if token == "" {
+			continue
+		}
+		upload := &SSHKeyUpload{Provider: providerName(kind), Host: kind.Host()}
+		if err := sshUploadKey(kind, token, title, rotation.PublicKey); err != nil {
+			upload.Error = err.Error()
+		} else if opts.SaveTokens {
+			if err := sshSaveToken(upload.Host, token); err != nil {
+				slog.Warn("failed to save access token", "host", upload.Host, "error", err)
+			} else {
+				upload.TokenSaved = true
+			}
+		}
+		rotation.Uploads = append(rotation.Uploads, upload)
+
+		if !rotation.checks(upload.Host) {
+			rotation.Hosts = append(rotation.Hosts, &SSHRotatedHost{Host: upload.Host, Name: upload.Provider})
+		}
+	}
+}
+
+// swapSSHKeys moves the new pair into place. The current key is hard
+// linked as old first, and each file is replaced with a rename, so
+// there's always a key at the default path.
+func swapSSHKeys(current, old string) error {
+	cmd := fmt.Sprintf("ln -f %[1]s %[2]s && ln -f %[1]s.pub %[2]s.pub && mv -f %[3]s %[4]s && mv -f %[3]s.pub %[4]s.pub",
+		current, old, sshNewKeyPath, sshKeyPath)
+	if current != sshKeyPath {
+		// An RSA key would still be offered before the new one
+		cmd += fmt.Sprintf(" && rm -f %[1]s %[1]s.pub", current)
+	}
+	if output, err := sshExec(cmd + " 2>&1"); err != nil {
+		return fmt.Errorf("failed to swap in the new SSH key: %s", strings.TrimSpace(output))
+	}
+	return nil
+}
+
+// RemoveOldSSHKey removes the key kept by the last rotation once the
+// ssh_old_key_grace setting has passed since it.
+func RemoveOldSSHKey(now time.Time) error {
+	rotatedAt, err := time.Parse(time.RFC3339, sshRotatedAt())
+	if err != nil {
+		return nil
+	}
+	if now.Before(rotatedAt.Add(sshOldKeyGrace())) {
+		return nil
+	}
+	var files []string
+	for _, old := range sshOldKeyPaths {
+		files = append(files, old, old+".pub")
+	}
+	if _, err := sshExec("rm -f " + strings.Join(files, " ")); err != nil {
+		return fmt.Errorf("failed to remove the previous SSH key")
+	}
+	return sshSaveRotation("", "")
+}
+
+// checks reports whether a host is already among the rotation's hosts.
+func (r *SSHKeyRotation) checks(host string) bool {
+	for _, h := range r.Hosts {
+		if h.Host == host {
+			return true
+		}
+	}
+	return false
+}
+
+// describe summarizes the rotation for its activity.
+func (r *SSHKeyRotation) describe() string {
+	var b strings.Builder
+	b.WriteString("Rotated the default SSH key")
+	var uploaded, failed []string
+	for _, upload := range r.Uploads {
+		if upload.Error == "" {
+			uploaded = append(uploaded, upload.Provider)
+		} else {
+			failed = append(failed, upload.Provider)
+		}
+	}
+	if len(uploaded) > 0 {
+		fmt.Fprintf(&b, "; uploaded it to %s", strings.Join(uploaded, " and "))
+	}
+	if len(failed) > 0 {
+		fmt.Fprintf(&b, "; uploading to %s failed", strings.Join(failed, " and "))
+	}
+	if !r.OldKeyUntil.IsZero() {
+		fmt.Fprintf(&b, "; the previous key is kept until %s", r.OldKeyUntil.Format("Jan 2"))
+	}
+	return b.String()
+}
+
+// providerName returns the display name of a provider kind.
+func providerName(kind providers.Kind) string {
+	for _, known := range KnownSSHHosts {
+		if known.Host == kind.Host() {
+			return known.Name
+		}
+	}
+	return string(kind)
+}
+
+func init() {
+	RegisterJob(JobSpec{
+		Name:        "ssh-old-key-cleanup",
+		Description: "Remove the previous SSH key once a rotation's grace period is over",
+		Interval:    Every(time.Hour),
+		Jitter:      5 * time.Minute,
+		Priority:    PriorityLow,
+		Immediate:   true,
+		Run:         RemoveOldSSHKey,
+	})
+}
@@ -0,0 +1,131 @@
+package internal
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+	"workbench/internal/providers"
+
+	"github.com/The-Skyscape/devtools/pkg/testutils"
+)
+
+// fakeSSHRotation scripts a container whose Ed25519 key was verified with
+// GitHub and GitLab, returning the saved verifications, uploads, and
+// activities.
+func fakeSSHRotation(t *testing.T, grace time.Duration) (*scriptedExec, map[string]string, *[]string, *[]string) {
+	script := fakeScript(t, &sshExec).
+		on("elif [ -f ~/.ssh/id_rsa ]", "id_ed25519\n", nil).
+		on("ssh-keygen -t ed25519", "ssh-ed25519 AAAAnew dev@example.com\n", nil)
+
+	verified := map[string]string{}
+	var uploads, activities []string
+	rotatedAt := ""
+	saveVerified, isVerified, upload, saveToken, keepFor, readRotated, saveRotation, activity :=
+		sshSaveVerified, sshRotateVerified, sshUploadKey, sshSaveToken, sshOldKeyGrace, sshRotatedAt, sshSaveRotation, sshRotateActivity
+	sshSaveVerified = func(host, value string) error { verified[host] = value; return nil }
+	sshRotateVerified = func(host string) bool { return host == "github.com" || host == "gitlab.com" }
+	sshUploadKey = func(kind providers.Kind, token, title, publicKey string) error {
+		if token == "bad" {
+			return errors.New("provider rejected the access token")
+		}
+		uploads = append(uploads, string(kind)+" "+publicKey)
+		return nil
+	}
+	sshSaveToken = func(host, token string) error { return nil }
+	sshOldKeyGrace = func() time.Duration { return grace }
+	sshRotatedAt = func() string { return rotatedAt }
+	sshSaveRotation = func(publicKey, at string) error { rotatedAt = at; return nil }
+	sshRotateActivity = func(description string) { activities = append(activities, description) }
+	t.Cleanup(func() {
+		sshSaveVerified, sshRotateVerified, sshUploadKey, sshSaveToken, sshOldKeyGrace, sshRotatedAt, sshSaveRotation, sshRotateActivity =
+			saveVerified, isVerified, upload, saveToken, keepFor, readRotated, saveRotation, activity
+	})
+	return script, verified, &uploads, &activities
+}
+
+func TestRotateSSHKey(t *testing.T) {
+	script, verified, uploads, activities := fakeSSHRotation(t, 7*24*time.Hour)
+	script.on("-i ~/.ssh/id_ed25519 -o IdentitiesOnly=yes", "Hi dev! You've successfully authenticated", nil).
+		on("-i ~/.ssh/id_ed25519_new -o IdentitiesOnly=yes -o BatchMode=yes -o ConnectTimeout=10 -o StrictHostKeyChecking=accept-new git@github.com",
+			"Hi dev! You've successfully authenticated", nil).
+		on("-i ~/.ssh/id_ed25519_new", "git@gitlab.com: Permission denied (publickey).", nil)
+
+	rotation, err := RotateSSHKey("dev@example.com", SSHRotateOptions{
+		Tokens: map[providers.Kind]string{providers.GitHub: "secret", providers.GitLab: "bad"},
+	})
+	testutils.AssertEqual(t, nil, err)
+	testutils.AssertEqual(t, "ssh-ed25519 AAAAnew dev@example.com", rotation.PublicKey)
+	testutils.AssertEqual(t, []string{"github ssh-ed25519 AAAAnew dev@example.com"}, *uploads)
+	testutils.AssertEqual(t, "provider rejected the access token", rotation.Uploads[1].Error)
+
+	testutils.AssertEqual(t, 2, len(rotation.Hosts))
+	testutils.AssertEqual(t, true, rotation.Hosts[0].OldKey && rotation.Hosts[0].NewKey)
+	testutils.AssertEqual(t, false, rotation.Hosts[1].NewKey)
+	testutils.AssertEqual(t, true, verified["github.com"] != "")
+	testutils.AssertEqual(t, "", verified["gitlab.com"])
+
+	testutils.AssertEqual(t, true, script.ran("ln -f ~/.ssh/id_ed25519 ~/.ssh/id_ed25519_old && ln -f ~/.ssh/id_ed25519.pub ~/.ssh/id_ed25519_old.pub && mv -f ~/.ssh/id_ed25519_new ~/.ssh/id_ed25519 && mv -f ~/.ssh/id_ed25519_new.pub ~/.ssh/id_ed25519.pub"))
+	testutils.AssertEqual(t, false, script.ran("rm -f ~/.ssh/id_ed25519_old"))
+	testutils.AssertEqual(t, true, strings.HasPrefix((*activities)[0],
+		"Rotated the default SSH key; uploaded it to GitHub; uploading to GitLab failed; the previous key is kept until "))
+}
+
+func TestRotateSSHKeyKeepsKeyWhenOldKeyFails(t *testing.T) {
+	script, _, uploads, _ := fakeSSHRotation(t, 0)
+	script.on("ssh -T", "Permission denied (publickey).", nil)
+
+	_, err := RotateSSHKey("dev@example.com", SSHRotateOptions{Tokens: map[providers.Kind]string{providers.GitHub: "secret"}})
+	testutils.AssertEqual(t, "the current SSH key no longer authenticates with github.com, gitlab.com - test it from the SSH checklist before rotating", err.Error())
+	testutils.AssertEqual(t, 0, len(*uploads))
+	testutils.AssertEqual(t, true, script.ran("rm -f ~/.ssh/id_ed25519_new ~/.ssh/id_ed25519_new.pub"))
+	testutils.AssertEqual(t, false, script.ran("mv -f"))
+}
+
+func TestRotateSSHKeyReplacesRSAKey(t *testing.T) {
+	script, _, _, _ := fakeSSHRotation(t, 0)
+	script.rules[0] = scriptRule{"elif [ -f ~/.ssh/id_rsa ]", "id_rsa\n", nil}
+	script.on("ssh -T", "You've successfully authenticated", nil)
+
+	rotation, err := RotateSSHKey("dev@example.com", SSHRotateOptions{})
+	testutils.AssertEqual(t, nil, err)
+	testutils.AssertEqual(t, "~/.ssh/id_rsa_old", rotation.OldKeyPath)
+	testutils.AssertEqual(t, true, script.ran("ln -f ~/.ssh/id_rsa ~/.ssh/id_rsa_old && ln -f ~/.ssh/id_rsa.pub ~/.ssh/id_rsa_old.pub"))
+	testutils.AssertEqual(t, true, script.ran("&& rm -f ~/.ssh/id_rsa ~/.ssh/id_rsa.pub"))
+	// No grace period removes the previous key right away
+	testutils.AssertEqual(t, true, rotation.OldKeyUntil.IsZero())
+	testutils.AssertEqual(t, true, script.ran("rm -f ~/.ssh/id_ed25519_old ~/.ssh/id_ed25519_old.pub ~/.ssh/id_rsa_old ~/.ssh/id_rsa_old.pub"))
+}
+
+func TestRotateSSHKeyKeepsKeyWhenNewKeyRejected(t *testing.T) {
+	script, verified, _, activities := fakeSSHRotation(t, 0)
+	script.on("-i ~/.ssh/id_ed25519 -o IdentitiesOnly=yes", "Hi dev! You've successfully authenticated", nil).
+		on("-i ~/.ssh/id_ed25519_new", "Permission denied (publickey).", nil)
+
+	_, err := RotateSSHKey("dev@example.com", SSHRotateOptions{})
+	testutils.AssertEqual(t, true, errors.Is(err, ErrSSHNewKeyRejected))
+	testutils.AssertEqual(t, true, strings.HasPrefix(err.Error(), "the new SSH key doesn't authenticate with github.com, gitlab.com yet"))
+	testutils.AssertEqual(t, true, script.ran("rm -f ~/.ssh/id_ed25519_new ~/.ssh/id_ed25519_new.pub"))
+	testutils.AssertEqual(t, false, script.ran("mv -f"))
+	testutils.AssertEqual(t, 0, len(verified))
+	testutils.AssertEqual(t, 0, len(*activities))
+
+	// Rotating anyway swaps it in, leaving the hosts unverified
+	rotation, err := RotateSSHKey("dev@example.com", SSHRotateOptions{Force: true})
+	testutils.AssertEqual(t, nil, err)
+	testutils.AssertEqual(t, false, rotation.Hosts[0].NewKey)
+	testutils.AssertEqual(t, true, script.ran("mv -f ~/.ssh/id_ed25519_new ~/.ssh/id_ed25519"))
+	testutils.AssertEqual(t, "", verified["github.com"])
+}
+
+func TestRemoveOldSSHKey(t *testing.T) {
+	script, _, _, _ := fakeSSHRotation(t, 24*time.Hour)
+	rotatedAt := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	sshRotatedAt = func() string { return rotatedAt.Format(time.RFC3339) }
+
+	testutils.AssertEqual(t, nil, RemoveOldSSHKey(rotatedAt.Add(23*time.Hour)))
+	testutils.AssertEqual(t, false, script.ran("id_ed25519_old"))
+
+	testutils.AssertEqual(t, nil, RemoveOldSSHKey(rotatedAt.Add(25*time.Hour)))
+	testutils.AssertEqual(t, true, script.ran("rm -f ~/.ssh/id_ed25519_old ~/.ssh/id_ed25519_old.pub"))
+}
@@ -0,0 +1,170 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"workbench/models"
+	"workbench/services"
+)
+
+// ErrStashConflict is returned by PopStash when the stash applied with
+// conflicts. git keeps the stash in that case, so nothing is lost.
+var ErrStashConflict = errors.New("stash applied with merge conflicts - resolve them in VS Code; the stash was kept")
+
+// ErrNothingToStash is returned by StashChanges for a clean working tree.
+var ErrNothingToStash = errors.New("no uncommitted changes to stash")
+
+// Stash is an entry in a repository's stash list.
+type Stash struct {
+	Index   int    // N in stash@{N}; 0 is the newest
+	Branch  string // Branch the changes were stashed on
+	Message string
+	Date    string // Relative, e.g. "2 hours ago"
+	Safety  bool   // Captured by the workbench before a pull or other git action
+}
+
+// stashFormat prints each stash's selector, reflog subject, and relative
+// date, separated by unit separators.
+const stashFormat = "%gd%x1f%gs%x1f%cr"
+
+// stashExec runs git commands in the coder container. Replaced in tests.
+var stashExec = services.CoderExec
+
+// StashChanges stashes a repository's uncommitted changes, untracked
+// files included, with an optional message.
+func StashChanges(repoName, message string) error {
+	repo, err := models.Repositories.Find("WHERE Name = ?", repoName)
+	if err != nil {
+		return repoNotFound(repoName)
+	}
+
+	cmd := "git stash push -u"
+	if message = strings.TrimSpace(message); message != "" {
+		cmd += " -m " + shellQuote(message)
+	}
+	output, err := stashExec(fmt.Sprintf("cd %s && %s 2>&1", shellQuote(repo.LocalPath), cmd))
+	if err != nil {
+		return fmt.Errorf("failed to stash changes: %s", strings.TrimSpace(output))
+	}
+	if strings.Contains(output, "No local changes to save") {
+		return ErrNothingToStash
+	}
+
+	recordStashActivity("repo_stash", repoName, fmt.Sprintf("Stashed uncommitted changes in %s", repoName))
+	return nil
+}
+
+// ListStashes returns a repository's stashes, newest first.
+func ListStashes(repoName string) ([]*Stash, error) {
+	repo, err := models.Repositories.Find("WHERE Name = ?", repoName)
+	if err != nil {
+		return nil, repoNotFound(repoName)
+	}
+
+	output, err := stashExec(fmt.Sprintf("cd %s && git stash list --format='%s' 2>&1", shellQuote(repo.LocalPath), stashFormat))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list stashes: %s", strings.TrimSpace(output))
+	}
+	return parseStashes(output), nil
+}
+
+// parseStashes parses git stash list output in stashFormat. Subjects are
+// "On <branch>: <message>" for stashes with a message and
+// "WIP on <branch>: <commit> <subject>" for those without.
+func parseStashes(output string) []*Stash {
+	stashes := []*Stash{}
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.SplitN(line, "\x1f", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		index, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(fields[0], "stash@{"), "}"))
+		if err != nil {
+			continue
+		}
+
+		stash := &Stash{Index: index, Message: fields[1], Date: fields[2]}
+		subject := fields[1]
+		if rest, ok := strings.CutPrefix(subject, "WIP on "); ok {
+			subject = "On " + rest
+		}
+		if rest, ok := strings.CutPrefix(subject, "On "); ok {
+			if branch, message, found := strings.Cut(rest, ": "); found {
+				stash.Branch, stash.Message = branch, message
+			}
+		}
+		stash.Safety = strings.HasPrefix(stash.Message, "workbench safety:")
+		stashes = append(stashes, stash)
+	}
+	return stashes
+}
+
+// PopStash applies stash@{index} and removes it from the stash list.
+// Returns ErrStashConflict, keeping the stash, when applying it conflicts
+// with the working tree.
+func PopStash(repoName string, index int) error {
+	repo, err := findStashRepo(repoName, index)
+	if err != nil {
+		return err
+	}
+
+	output, err := stashExec(fmt.Sprintf("cd %s && git stash pop stash@{%d} 2>&1", shellQuote(repo.LocalPath), index))
+	if err != nil {
+		if strings.Contains(output, "CONFLICT") {
+			recordStashActivity("repo_stash_pop_failed", repoName, fmt.Sprintf("Stash %d applied to %s with conflicts; the stash was kept", index, repoName))
+			return ErrStashConflict
+		}
+		recordStashActivity("repo_stash_pop_failed", repoName, fmt.Sprintf("Failed to apply stash %d to %s: %s", index, repoName, strings.TrimSpace(output)))
+		if strings.Contains(output, "would be overwritten") || strings.Contains(output, "already exists") {
+			return fmt.Errorf("uncommitted changes would be overwritten - commit or stash them first")
+		}
+		return fmt.Errorf("failed to apply stash: %s", strings.TrimSpace(output))
+	}
+
+	recordStashActivity("repo_stash_pop", repoName, fmt.Sprintf("Applied and removed stash %d in %s", index, repoName))
+	return nil
+}
+
+// DropStash deletes stash@{index} without applying it. Refused while the
+// repository is locked, since the stash can't be got back.
+func DropStash(repoName string, index int) error {
+	repo, err := findStashRepo(repoName, index)
+	if err != nil {
+		return err
+	}
+	if err := checkUnlocked(repo); err != nil {
+		return err
+	}
+
+	output, err := stashExec(fmt.Sprintf("cd %s && git stash drop stash@{%d} 2>&1", shellQuote(repo.LocalPath), index))
+	if err != nil {
+		return fmt.Errorf("failed to drop stash: %s", strings.TrimSpace(output))
+	}
+
+	recordStashActivity("repo_stash_drop", repoName, fmt.Sprintf("Dropped stash %d in %s", index, repoName))
+	return nil
+}
+
+func findStashRepo(repoName string, index int) (*models.Repository, error) {
+	if index < 0 {
+		return nil, fmt.Errorf("invalid stash index")
+	}
+	repo, err := findRepo(repoName)
+	if err != nil {
+		return nil, repoNotFound(repoName)
+	}
+	return repo, nil
+}
+
+func recordStashActivity(activityType, repoName, description string) {
+	go models.Activities.Insert(&models.Activity{
+		Type:        activityType,
+		Repository:  repoName,
+		Description: description,
+		Author:      "System",
+		Timestamp:   time.Now(),
+	})
+}
@@ -0,0 +1,52 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/The-Skyscape/devtools/pkg/testutils"
+)
+
+func TestParseStashes(t *testing.T) {
+	output := "stash@{0}\x1fOn main: half-done refactor\x1f5 minutes ago\n" +
+		"stash@{1}\x1fWIP on feature/login: 1a2b3c4 Add form\x1f2 hours ago\n" +
+		"stash@{2}\x1fOn main: workbench safety: before pull at 2026-01-01T12:00:00Z\x1f3 days ago\n"
+
+	stashes := parseStashes(output)
+	testutils.AssertEqual(t, 3, len(stashes))
+
+	testCases := []struct {
+		index   int
+		branch  string
+		message string
+		date    string
+		safety  bool
+	}{
+		{0, "main", "half-done refactor", "5 minutes ago", false},
+		{1, "feature/login", "1a2b3c4 Add form", "2 hours ago", false},
+		{2, "main", "workbench safety: before pull at 2026-01-01T12:00:00Z", "3 days ago", true},
+	}
+	for i, tc := range testCases {
+		testutils.AssertEqual(t, tc.index, stashes[i].Index)
+		testutils.AssertEqual(t, tc.branch, stashes[i].Branch)
+		testutils.AssertEqual(t, tc.message, stashes[i].Message)
+		testutils.AssertEqual(t, tc.date, stashes[i].Date)
+		testutils.AssertEqual(t, tc.safety, stashes[i].Safety)
+	}
+}
+
+func TestParseStashesEmpty(t *testing.T) {
+	stashes := parseStashes("")
+	testutils.AssertEqual(t, true, stashes != nil)
+	testutils.AssertEqual(t, 0, len(stashes))
+}
+
+func TestParseStashesSkipsMalformedLines(t *testing.T) {
+	stashes := parseStashes("warning: something\nstash@{x}\x1fOn main: a\x1fnow\nstash@{3}\x1fOn main: kept\x1fnow\n")
+	testutils.AssertEqual(t, 1, len(stashes))
+	testutils.AssertEqual(t, 3, stashes[0].Index)
+}
+
+func TestFindStashRepoRejectsNegativeIndex(t *testing.T) {
+	_, err := findStashRepo("api", -1)
+	testutils.AssertEqual(t, "invalid stash index", err.Error())
+}
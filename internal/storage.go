@@ -0,0 +1,419 @@
+package internal
+
+import (
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"workbench/models"
+)
+
+// DefaultReposDir is where repositories live unless they're assigned a
+// storage location.
+const DefaultReposDir = "/home/coder/repos"
+
+// storageUsageTTL is how long storage location disk usage is reused, since
+// the metrics sampler asks every few seconds.
+const storageUsageTTL = time.Minute
+
+// storageExec runs storage commands in the coder container. Replaced in tests.
+var storageExec = operationExec
+
+// GetStorageLocations returns the configured storage locations by name.
+func GetStorageLocations() ([]*models.StorageLocation, error) {
+	return models.StorageLocations.Search("ORDER BY Name ASC")
+}
+
+// storageByName looks up a storage location by its name.
+func storageByName(name string) (*models.StorageLocation, error) {
+	location, err := models.StorageLocations.Find("WHERE Name = ?", name)
+	if err != nil || location == nil {
+		return nil, fmt.Errorf("no storage location is named %s", name)
+	}
+	return location, nil
+}
+
+// DefaultStorageID returns the ID of the default_storage location, or
+// empty for the standard repos directory, also when that location has
+// since been removed.
+func DefaultStorageID() string {
+	name, _ := models.GetSetting("default_storage")
+	if name == "" {
+		return ""
+	}
+	location, err := storageByName(name)
+	if err != nil {
+		return ""
+	}
+	return location.ID
+}
+
+// RepoDir returns the parent directory for repositories in a storage
+// location, or DefaultReposDir when locationID is empty.
+func RepoDir(locationID string) (string, error) {
+	if locationID == "" {
+		return DefaultReposDir, nil
+	}
+	location, err := models.StorageLocations.Get(locationID)
+	if err != nil {
+		return "", fmt.Errorf("storage location not found")
+	}
+	return location.ContainerPath, nil
+}
+
+// AddStorageLocation saves a new storage location after checking that its
+// directory exists and is writable from inside the container.
+func AddStorageLocation(name, containerPath, hostPath string) (*models.StorageLocation, error) {
+	name = strings.TrimSpace(name)
+	containerPath = strings.TrimSpace(containerPath)
+	if err := validateStorageLocation(name, containerPath); err != nil {
+		return nil, err
+	}
+	if models.StorageLocations.Count("WHERE Name = ? OR ContainerPath = ?", name, containerPath) > 0 {
+		return nil, fmt.Errorf("a storage location with that name or path already exists")
+	}
+
+	output, _ := storageExec(fmt.Sprintf("test -d %[1]s && test -w %[1]s && echo writable", shellQuote(containerPath)))
+	if strings.TrimSpace(output) != "writable" {
+		return nil, fmt.Errorf("%s doesn't exist or isn't writable inside the container - mount it into workbench-coder first", containerPath)
+	}
+
+	location, err := models.StorageLocations.Insert(&models.StorageLocation{
+		Name:          name,
+		ContainerPath: containerPath,
+		HostPath:      strings.TrimSpace(hostPath),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to save storage location")
+	}
+	return location, nil
+}
+
+// storagePathPattern matches the characters a storage location's
+// container path may use. Repository paths under it end up in shell
+// commands, so anything the shell would interpret is refused.
+var storagePathPattern = regexp.MustCompile(`^[A-Za-z0-9._/-]+$`)
+
+// validateStorageLocation checks a storage location's name and container
+// path before the directory itself is checked.
+func validateStorageLocation(name, containerPath string) error {
+	if name == "" {
+		return fmt.Errorf("storage location name is required")
+	}
+	if !filepath.IsAbs(containerPath) || filepath.Clean(containerPath) != containerPath || containerPath == "/" {
+		return fmt.Errorf("container path must be an absolute directory, e.g. /mnt/bulk/repos")
+	}
+	if !storagePathPattern.MatchString(containerPath) {
+		return fmt.Errorf("container path may only use letters, numbers, dots, dashes, underscores, and slashes")
+	}
+	if containerPath == DefaultReposDir {
+		return fmt.Errorf("%s is already the standard repos directory", DefaultReposDir)
+	}
+	return nil
+}
+
+// DeleteStorageLocation removes a storage location. Refused while any
+// repository is stored there; the directory itself is left alone.
+func DeleteStorageLocation(id string) error {
+	location, err := models.StorageLocations.Get(id)
+	if err != nil {
+		return fmt.Errorf("storage location not found")
+	}
+	if count := models.Repositories.Count("WHERE StorageLocationID = ?", id); count > 0 {
+		return fmt.Errorf("%d repositories are stored in %s - move them first", count, location.Name)
+	}
+	if err := models.StorageLocations.Delete(location); err != nil {
+		return fmt.Errorf("failed to delete storage location")
+	}
+	return nil
+}
+
+var (
+	movingMu sync.Mutex
+	moving   = map[string]bool{} // Repositories being moved
+)
+
+// StartStorageMove copies a repository to another storage location in
+// the background, verifies the copy, then removes the old one. An empty
+// locationID moves it back to DefaultReposDir.
+func StartStorageMove(repoName, locationID string) (*Task, error) {
+	repo, err := models.Repositories.Find("WHERE Name = ?", repoName)
+	if err != nil {
+		return nil, fmt.Errorf("repository '%s' not found", repoName)
+	}
+	dir, err := RepoDir(locationID)
+	if err != nil {
+		return nil, err
+	}
+	target := filepath.Join(dir, repo.Name)
+	if target == repo.LocalPath {
+		return nil, fmt.Errorf("%s is already stored in %s", repo.Name, dir)
+	}
+	if output, _ := storageExec(fmt.Sprintf("test -e %s && echo exists", shellQuote(target))); strings.TrimSpace(output) == "exists" {
+		return nil, fmt.Errorf("%s already exists", target)
+	}
+
+	movingMu.Lock()
+	defer movingMu.Unlock()
+	if moving[repo.Name] {
+		return nil, fmt.Errorf("%s is already being moved", repo.Name)
+	}
+	moving[repo.Name] = true
+
+	task := newTask(repo.Name, "move to "+dir)
+	task.RefreshRepos = true
+	task.start(func(onLine func(string) error) error {
+		return moveRepository(repo, locationID, target, onLine)
+	}, func(output string, err error) {
+		movingMu.Lock()
+		delete(moving, repo.Name)
+		movingMu.Unlock()
+	})
+	return task, nil
+}
+
+// moveRepository copies, verifies, records, then removes the old copy,
+// writing one line per step. The new copy is removed if any step before
+// the record is updated fails, so the repository is never left in two
+// half states.
+func moveRepository(repo *models.Repository, locationID, target string, onLine func(string) error) error {
+	source := repo.LocalPath
+	onLine(fmt.Sprintf("Copying %s to %s", source, target))
+	if output, err := storageExec(fmt.Sprintf("mkdir -p %s && cp -a %s %s 2>&1",
+		shellQuote(filepath.Dir(target)), shellQuote(source), shellQuote(target))); err != nil {
+		storageExec("rm -rf " + shellQuote(target))
+		return recordMoveFailure(repo, fmt.Errorf("copy failed: %s", strings.TrimSpace(output)))
+	}
+
+	onLine("Verifying copy")
+	before, err := snapshotCopy(source)
+	if err == nil {
+		var after *copySnapshot
+		if after, err = snapshotCopy(target); err == nil {
+			err = verifyCopy(before, after)
+		}
+	}
+	if err != nil {
+		storageExec("rm -rf " + shellQuote(target))
+		return recordMoveFailure(repo, err)
+	}
+	onLine(fmt.Sprintf("Verified HEAD %s and %d files", shortHash(before.Head), before.Files))
+
+	previousLocation := repo.StorageLocationID
+	repo.LocalPath, repo.StorageLocationID = target, locationID
+	if err := models.Repositories.Update(repo); err != nil {
+		storageExec("rm -rf " + shellQuote(target))
+		repo.LocalPath, repo.StorageLocationID = source, previousLocation
+		return recordMoveFailure(repo, fmt.Errorf("failed to save new location"))
+	}
+
+	onLine("Removing old copy")
+	if output, err := storageExec("rm -rf " + shellQuote(source) + " 2>&1"); err != nil {
+		onLine(fmt.Sprintf("Couldn't remove %s: %s", source, strings.TrimSpace(output)))
+	}
+
+	go models.Activities.Insert(&models.Activity{
+		Type:        "repo_storage_move",
+		Repository:  repo.Name,
+		Description: fmt.Sprintf("Moved %s from %s to %s", repo.Name, source, target),
+		Author:      "System",
+		Timestamp:   time.Now(),
+	})
+	onLine("Done")
+	return nil
+}
+
+func recordMoveFailure(repo *models.Repository, err error) error {
+	go models.Activities.Insert(&models.Activity{
+		Type:        "repo_storage_move_failed",
+		Repository:  repo.Name,
+		Description: fmt.Sprintf("Failed to move %s: %v", repo.Name, err),
+		Author:      "System",
+		Timestamp:   time.Now(),
+	})
+	return err
+}
+
+// copySnapshot identifies a repository copy well enough to tell whether
+// a copy is complete.
+type copySnapshot struct {
+	Head  string // Empty for a repository without commits
+	Files int
+}
+
+func snapshotCopy(dir string) (*copySnapshot, error) {
+	output, err := storageExec(fmt.Sprintf(
+		`cd %s && echo "head $(git rev-parse --verify -q HEAD)" && echo "files $(find . -type f | wc -l)"`, shellQuote(dir)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect %s", dir)
+	}
+	return parseCopySnapshot(output)
+}
+
+// parseCopySnapshot parses "head <hash>" and "files <count>" lines.
+func parseCopySnapshot(output string) (*copySnapshot, error) {
+	snapshot := &copySnapshot{Files: -1}
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "head"):
+			snapshot.Head = strings.TrimSpace(strings.TrimPrefix(line, "head"))
+		case strings.HasPrefix(line, "files "):
+			files, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "files ")))
+			if err != nil {
+				return nil, fmt.Errorf("unexpected file count %q", line)
+			}
+			snapshot.Files = files
+		}
+	}
+	if snapshot.Files < 0 {
+		return nil, fmt.Errorf("missing file count")
+	}
+	return snapshot, nil
+}
+
+// verifyCopy reports how a copy differs from its source, if it does.
+func verifyCopy(source, copied *copySnapshot) error {
+	if source.Head != copied.Head {
+		return fmt.Errorf("copy verification failed: HEAD is %s, expected %s", shortHash(copied.Head), shortHash(source.Head))
+	}
+	if source.Files != copied.Files {
+		return fmt.Errorf("copy verification failed: %d files copied, expected %d", copied.Files, source.Files)
+	}
+	return nil
+}
+
+func shortHash(hash string) string {
+	if hash == "" {
+		return "(no commits)"
+	}
+	if len(hash) > 7 {
+		return hash[:7]
+	}
+	return hash
+}
+
+// StorageLocationUsage is the disk usage of the filesystem backing a
+// storage location.
+type StorageLocationUsage struct {
+	Location *models.StorageLocation
+	Usage    *DataDirUsage // Nil when it couldn't be read
+}
+
+var (
+	storageUsageMu      sync.Mutex
+	storageUsageCache   []*StorageLocationUsage
+	storageUsageCounted []*DataDirUsage // Filesystems beyond the standard repos dir
+	storageUsageAt      time.Time
+)
+
+// GetStorageUsage returns disk usage across the data directory and every
+// other filesystem backing a storage location, each counted once.
+func GetStorageUsage() (*DataDirUsage, error) {
+	usage, err := GetDataDirUsage()
+	if err != nil {
+		return nil, err
+	}
+	_, extra := storageLocationUsage()
+	return aggregateUsage(usage, extra), nil
+}
+
+// GetStorageLocationUsage returns each storage location with the disk
+// usage of its filesystem.
+func GetStorageLocationUsage() []*StorageLocationUsage {
+	locations, _ := storageLocationUsage()
+	return locations
+}
+
+// storageLocationUsage reads each location's filesystem with df, reusing
+// the result for storageUsageTTL. extra lists the distinct filesystems
+// that aren't the one holding DefaultReposDir, which the data directory
+// already covers.
+func storageLocationUsage() (locations []*StorageLocationUsage, extra []*DataDirUsage) {
+	storageUsageMu.Lock()
+	defer storageUsageMu.Unlock()
+	if time.Since(storageUsageAt) < storageUsageTTL {
+		return storageUsageCache, storageUsageCounted
+	}
+
+	configured, err := GetStorageLocations()
+	if err != nil {
+		slog.Error("failed to load storage locations", "error", err)
+	}
+
+	seen := map[string]bool{}
+	if len(configured) == 0 {
+		storageUsageCache, storageUsageCounted, storageUsageAt = nil, nil, time.Now()
+		return nil, nil
+	}
+	if standard, err := dfUsage(DefaultReposDir); err == nil {
+		seen[standard.Path] = true
+	}
+	for _, location := range configured {
+		usage, err := dfUsage(location.ContainerPath)
+		locations = append(locations, &StorageLocationUsage{Location: location, Usage: usage})
+		if err != nil || seen[usage.Path] {
+			continue
+		}
+		seen[usage.Path] = true
+		extra = append(extra, usage)
+	}
+
+	storageUsageCache, storageUsageCounted, storageUsageAt = locations, extra, time.Now()
+	return locations, extra
+}
+
+func dfUsage(path string) (*DataDirUsage, error) {
+	output, err := storageExec(fmt.Sprintf("df -B1 --output=target,size,avail %s | tail -n 1", shellQuote(path)))
+	if err != nil {
+		return nil, err
+	}
+	return parseDFUsage(output)
+}
+
+// parseDFUsage parses a "target size avail" line from df -B1.
+func parseDFUsage(output string) (*DataDirUsage, error) {
+	fields := strings.Fields(output)
+	if len(fields) != 3 {
+		return nil, fmt.Errorf("unexpected df output %q", strings.TrimSpace(output))
+	}
+	total, err := strconv.ParseUint(fields[1], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("unexpected df size %q", fields[1])
+	}
+	free, err := strconv.ParseUint(fields[2], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("unexpected df available %q", fields[2])
+	}
+	return newUsage(fields[0], total, free), nil
+}
+
+// aggregateUsage sums disk usage across filesystems.
+func aggregateUsage(base *DataDirUsage, extra []*DataDirUsage) *DataDirUsage {
+	if len(extra) == 0 {
+		return base
+	}
+	paths := []string{base.Path}
+	total, free := base.Total, base.Free
+	for _, usage := range extra {
+		paths = append(paths, usage.Path)
+		total += usage.Total
+		free += usage.Free
+	}
+	return newUsage(strings.Join(paths, ", "), total, free)
+}
+
+func newUsage(path string, total, free uint64) *DataDirUsage {
+	usage := &DataDirUsage{Path: path, Total: total, Free: free}
+	if free < total {
+		usage.Used = total - free
+	}
+	if usage.Total > 0 {
+		usage.UsedPercent = float64(usage.Used) / float64(usage.Total) * 100.0
+	}
+	return usage
+}
@@ -0,0 +1,90 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/The-Skyscape/devtools/pkg/testutils"
+)
+
+func TestValidateStorageLocation(t *testing.T) {
+	testCases := []struct {
+		name     string
+		location string
+		path     string
+		expected string
+	}{
+		{"valid", "Bulk", "/mnt/bulk/repos", ""},
+		{"missing name", "", "/mnt/bulk/repos", "storage location name is required"},
+		{"relative", "Bulk", "mnt/bulk", "container path must be an absolute directory, e.g. /mnt/bulk/repos"},
+		{"unclean", "Bulk", "/mnt/bulk/../etc", "container path must be an absolute directory, e.g. /mnt/bulk/repos"},
+		{"root", "Bulk", "/", "container path must be an absolute directory, e.g. /mnt/bulk/repos"},
+		{"command substitution", "Bulk", "/mnt/$(reboot)", "container path may only use letters, numbers, dots, dashes, underscores, and slashes"},
+		{"semicolon", "Bulk", "/mnt/bulk;rm", "container path may only use letters, numbers, dots, dashes, underscores, and slashes"},
+		{"space", "Bulk", "/mnt/bulk repos", "container path may only use letters, numbers, dots, dashes, underscores, and slashes"},
+		{"standard", "Bulk", DefaultReposDir, DefaultReposDir + " is already the standard repos directory"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := validateStorageLocation(tc.location, tc.path)
+			message := ""
+			if err != nil {
+				message = err.Error()
+			}
+			testutils.AssertEqual(t, tc.expected, message)
+		})
+	}
+}
+
+func TestVerifyCopy(t *testing.T) {
+	source, err := parseCopySnapshot("head 1a2b3c4d5e6f7a8b\nfiles 120\n")
+	testutils.AssertEqual(t, nil, err)
+	testutils.AssertEqual(t, "1a2b3c4d5e6f7a8b", source.Head)
+	testutils.AssertEqual(t, 120, source.Files)
+
+	testCases := []struct {
+		name     string
+		copy     string
+		expected string
+	}{
+		{"identical", "head 1a2b3c4d5e6f7a8b\nfiles 120\n", ""},
+		{"missing files", "head 1a2b3c4d5e6f7a8b\nfiles 118\n", "copy verification failed: 118 files copied, expected 120"},
+		{"different head", "head 9f8e7d6c5b4a\nfiles 120\n", "copy verification failed: HEAD is 9f8e7d6, expected 1a2b3c4"},
+		{"no commits", "head \nfiles 120\n", "copy verification failed: HEAD is (no commits), expected 1a2b3c4"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			copied, err := parseCopySnapshot(tc.copy)
+			testutils.AssertEqual(t, nil, err)
+			message := ""
+			if err := verifyCopy(source, copied); err != nil {
+				message = err.Error()
+			}
+			testutils.AssertEqual(t, tc.expected, message)
+		})
+	}
+
+	_, err = parseCopySnapshot("head 1a2b3c4\n")
+	testutils.AssertEqual(t, "missing file count", err.Error())
+}
+
+func TestStorageUsageAggregates(t *testing.T) {
+	bulk, err := parseDFUsage("/mnt/bulk 4000 1000\n")
+	testutils.AssertEqual(t, nil, err)
+	testutils.AssertEqual(t, "/mnt/bulk", bulk.Path)
+	testutils.AssertEqual(t, uint64(3000), bulk.Used)
+
+	_, err = parseDFUsage("df: /mnt/missing: No such file or directory")
+	testutils.AssertEqual(t, true, err != nil)
+
+	data := newUsage("/mnt/data", 1000, 500)
+	testutils.AssertEqual(t, data, aggregateUsage(data, nil))
+
+	total := aggregateUsage(data, []*DataDirUsage{bulk})
+	testutils.AssertEqual(t, "/mnt/data, /mnt/bulk", total.Path)
+	testutils.AssertEqual(t, uint64(5000), total.Total)
+	testutils.AssertEqual(t, uint64(1500), total.Free)
+	testutils.AssertEqual(t, uint64(3500), total.Used)
+	testutils.AssertEqual(t, 70.0, total.UsedPercent)
+}
@@ -0,0 +1,151 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"workbench/models"
+)
+
+// ErrTagExists is matched with errors.Is when CreateTag refuses a tag
+// name that's already taken.
+var ErrTagExists = errors.New("tag already exists")
+
+// Tag is a tag in a repository.
+type Tag struct {
+	Name      string
+	Commit    string    // Abbreviated hash of the tagged commit
+	Date      time.Time // When the tag was made; the commit date for lightweight tags
+	Annotated bool
+	Subject   string // First line of an annotated tag's message
+}
+
+// tagFormat prints each tag's name, object, peeled commit (empty for
+// lightweight tags), creation date, and subject, separated by unit
+// separators.
+const tagFormat = "%(refname:short)%1f%(objectname:short)%1f%(*objectname:short)%1f%(creatordate:iso-strict)%1f%(contents:subject)"
+
+// tagExec runs git commands in the coder container. Replaced in tests.
+var tagExec = operationExec
+
+// ListTags returns a repository's tags, newest first.
+func ListTags(repoName string) ([]*Tag, error) {
+	repo, err := models.Repositories.Find("WHERE Name = ?", repoName)
+	if err != nil {
+		return nil, repoNotFound(repoName)
+	}
+
+	output, err := tagExec(fmt.Sprintf("cd %s && git tag --sort=-creatordate --format='%s' 2>&1", shellQuote(repo.LocalPath), tagFormat))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tags: %s", strings.TrimSpace(output))
+	}
+	return parseTags(output), nil
+}
+
+// parseTags parses git tag output in tagFormat.
+func parseTags(output string) []*Tag {
+	tags := []*Tag{}
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.SplitN(line, "\x1f", 5)
+		if len(fields) != 5 {
+			continue
+		}
+		tag := &Tag{Name: fields[0], Commit: fields[1]}
+		if fields[2] != "" {
+			tag.Commit, tag.Annotated, tag.Subject = fields[2], true, fields[4]
+		}
+		tag.Date, _ = time.Parse(time.RFC3339, fields[3])
+		tags = append(tags, tag)
+	}
+	return tags
+}
+
+// CreateTag makes an annotated tag on a repository's HEAD, with message
+// defaulting to the tag name, and pushes it to origin when push is set.
+// A tag that was made but couldn't be pushed stays local; the error says
+// so. Records a repo_tag activity, or repo_tag_push_failed.
+func CreateTag(repoName, tag, message string, push bool) error {
+	repo, err := models.Repositories.Find("WHERE Name = ?", repoName)
+	if err != nil {
+		return repoNotFound(repoName)
+	}
+	tag = strings.TrimSpace(tag)
+	if err := checkTagName(tag); err != nil {
+		return err
+	}
+	if push && repo.IsLocal() {
+		return fmt.Errorf("'%s' has no remote to push the tag to - export it to a remote first, or don't push", repoName)
+	}
+	if message = strings.TrimSpace(message); message == "" {
+		message = tag
+	}
+
+	ref := shellQuote("refs/tags/" + tag)
+	output, _ := tagExec(fmt.Sprintf("cd %s && git rev-parse -q --verify %s >/dev/null && echo exists", shellQuote(repo.LocalPath), ref))
+	if strings.TrimSpace(output) == "exists" {
+		return &repoError{ErrTagExists, fmt.Sprintf("tag '%s' already exists in %s - pick another name", tag, repoName)}
+	}
+	output, err = tagExec(fmt.Sprintf("cd %s && git tag -a %s -m %s 2>&1", shellQuote(repo.LocalPath), shellQuote(tag), shellQuote(message)))
+	if err != nil {
+		return fmt.Errorf("failed to create tag: %s", strings.TrimSpace(output))
+	}
+
+	activity := tagActivity(repoName, tag, push)
+	if push {
+		timeout := int(OperationTimeout().Seconds())
+		output, err = tagExec(fmt.Sprintf("cd %s && timeout %d git push origin %s 2>&1", shellQuote(repo.LocalPath), timeout, ref))
+		if err != nil {
+			err = classifyRemoteError(output, err, "failed to push the tag")
+			activity.Type = "repo_tag_push_failed"
+			activity.Description = fmt.Sprintf("Tagged %s as %s, but pushing it failed: %v", repoName, tag, err)
+			go models.Activities.Insert(activity)
+			return fmt.Errorf("tag %s was created but not pushed: %w", tag, err)
+		}
+	}
+	go models.Activities.Insert(activity)
+	return nil
+}
+
+// tagActivity builds the repo_tag activity for a new tag, with the tag
+// name in its metadata.
+func tagActivity(repoName, tag string, push bool) *models.Activity {
+	description := fmt.Sprintf("Tagged %s as %s", repoName, tag)
+	if push {
+		description += " and pushed it"
+	}
+	metadata, _ := json.Marshal(map[string]any{"tag": tag, "pushed": push})
+	return &models.Activity{
+		Type:        "repo_tag",
+		Repository:  repoName,
+		Description: description,
+		Author:      "System",
+		Timestamp:   time.Now(),
+		Metadata:    string(metadata),
+	}
+}
+
+// checkTagName applies git's refname rules (git check-ref-format) to a
+// tag name.
+func checkTagName(tag string) error {
+	invalid := fmt.Errorf("'%s' isn't a valid tag name - use letters, digits, dots, dashes, and slashes, e.g. v1.2.0", tag)
+	if tag == "" {
+		return fmt.Errorf("tag name is required")
+	}
+	if tag == "@" || strings.HasPrefix(tag, "-") || strings.HasSuffix(tag, ".") ||
+		strings.Contains(tag, "..") || strings.Contains(tag, "@{") || strings.ContainsAny(tag, " ~^:?*[\\") {
+		return invalid
+	}
+	for _, r := range tag {
+		if r < 0x20 || r == 0x7f {
+			return invalid
+		}
+	}
+	for _, part := range strings.Split(tag, "/") {
+		if part == "" || strings.HasPrefix(part, ".") || strings.HasSuffix(part, ".lock") {
+			return invalid
+		}
+	}
+	return nil
+}
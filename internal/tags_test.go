@@ -0,0 +1,64 @@
+package internal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/The-Skyscape/devtools/pkg/testutils"
+)
+
+func TestParseTags(t *testing.T) {
+	output := "v1.1.0\x1f9f8e7d6\x1f1a2b3c4\x1f2026-03-01T12:00:00+01:00\x1fRelease 1.1.0\n" +
+		"v1.0.0\x1f5e6f7a8\x1f\x1f2026-01-15T09:30:00Z\x1fInitial commit\n"
+
+	tags := parseTags(output)
+	testutils.AssertEqual(t, 2, len(tags))
+
+	testutils.AssertEqual(t, "v1.1.0", tags[0].Name)
+	testutils.AssertEqual(t, "1a2b3c4", tags[0].Commit) // The commit, not the tag object
+	testutils.AssertEqual(t, true, tags[0].Annotated)
+	testutils.AssertEqual(t, "Release 1.1.0", tags[0].Subject)
+	testutils.AssertEqual(t, time.Date(2026, 3, 1, 11, 0, 0, 0, time.UTC), tags[0].Date.UTC())
+
+	testutils.AssertEqual(t, "5e6f7a8", tags[1].Commit)
+	testutils.AssertEqual(t, false, tags[1].Annotated)
+	testutils.AssertEqual(t, "", tags[1].Subject) // A lightweight tag's subject is its commit's
+}
+
+func TestCheckTagName(t *testing.T) {
+	testCases := []struct {
+		tag   string
+		valid bool
+	}{
+		{"v1.2.0", true},
+		{"release/2026-01", true},
+		{"", false},
+		{"-v1", false},
+		{"v1..2", false},
+		{"v1 beta", false},
+		{"v1~1", false},
+		{"v1^", false},
+		{"v1:x", false},
+		{"v1@{0}", false},
+		{"@", false},
+		{"v1.", false},
+		{"v1.lock", false},
+		{"release//1", false},
+		{"release/.hidden", false},
+		{"v1/", false},
+		{"v1\x01", false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.tag, func(t *testing.T) {
+			testutils.AssertEqual(t, tc.valid, checkTagName(tc.tag) == nil)
+		})
+	}
+}
+
+func TestTagActivity(t *testing.T) {
+	activity := tagActivity("api", "v1.2.0", true)
+	testutils.AssertEqual(t, "repo_tag", activity.Type)
+	testutils.AssertEqual(t, "Tagged api as v1.2.0 and pushed it", activity.Description)
+	testutils.AssertEqual(t, `{"pushed":true,"tag":"v1.2.0"}`, activity.Metadata)
+}
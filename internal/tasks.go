@@ -0,0 +1,168 @@
+package internal
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+	"workbench/services"
+)
+
+// MaxTaskOutput caps the output kept for a task; the tail beyond it is
+// dropped with a note.
+const MaxTaskOutput = 64 * 1024
+
+// maxTasks bounds how many tasks are remembered; the oldest finished
+// tasks are forgotten first.
+const maxTasks = 20
+
+// Task is a one-off command run in the background in a repository, such
+// as a setup step, or a bulk operation such as pulling every repository on
+// a host. Its output is kept in memory so the UI can poll it.
+type Task struct {
+	ID           string
+	Repository   string // Empty for tasks spanning repositories
+	Command      string
+	Started      time.Time
+	RefreshRepos bool // Finishing changes what the repository list shows
+
+	done chan struct{}
+
+	mu     sync.Mutex
+	output []byte
+	err    error
+}
+
+// TaskStatus is a point-in-time copy of a task for rendering.
+type TaskStatus struct {
+	ID      string
+	Command string
+	Output  string
+	Done    bool
+	Error   string
+}
+
+// taskExec streams a command's output. Replaced in tests.
+var taskExec = services.CoderExecStream
+
+var (
+	tasksMu sync.Mutex
+	tasks   []*Task // Oldest first
+)
+
+// StartTask runs command from dir in the background and returns
+// immediately. finish, if set, is called with the final output and error
+// once the command exits.
+func StartTask(repoName, dir, command string, finish func(output string, err error)) *Task {
+	task := newTask(repoName, command)
+	task.start(func(onLine func(string) error) error {
+		return taskExec(fmt.Sprintf("cd %s && %s 2>&1", shellQuote(dir), command), onLine)
+	}, finish)
+	return task
+}
+
+func newTask(repoName, command string) *Task {
+	return &Task{
+		ID:         newTaskID(),
+		Repository: repoName,
+		Command:    command,
+		Started:    time.Now(),
+		done:       make(chan struct{}),
+	}
+}
+
+// start remembers the task and runs it in the background. run reports
+// output a line at a time.
+func (t *Task) start(run func(onLine func(string) error) error, finish func(output string, err error)) {
+	rememberTask(t)
+
+	go func() {
+		err := run(t.appendLine)
+
+		t.mu.Lock()
+		t.err = err
+		output := string(t.output)
+		t.mu.Unlock()
+
+		if finish != nil {
+			finish(output, err)
+		}
+		close(t.done)
+	}()
+}
+
+// GetTask returns a remembered task by ID, or nil.
+func GetTask(id string) *Task {
+	tasksMu.Lock()
+	defer tasksMu.Unlock()
+	for _, task := range tasks {
+		if task.ID == id {
+			return task
+		}
+	}
+	return nil
+}
+
+// Wait blocks until the task finishes and returns its error.
+func (t *Task) Wait() error {
+	<-t.done
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.err
+}
+
+// Status returns a snapshot of the task's progress.
+func (t *Task) Status() *TaskStatus {
+	status := &TaskStatus{ID: t.ID, Command: t.Command}
+	select {
+	case <-t.done:
+		status.Done = true
+	default:
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	status.Output = string(t.output)
+	if status.Done && t.err != nil {
+		status.Error = fmt.Sprintf("'%s' failed: %v", t.Command, t.err)
+	}
+	return status
+}
+
+func (t *Task) appendLine(line string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.output) >= MaxTaskOutput {
+		return nil
+	}
+	t.output = append(t.output, line...)
+	t.output = append(t.output, '\n')
+	if len(t.output) >= MaxTaskOutput {
+		t.output = append(t.output[:MaxTaskOutput], "\n[output truncated]\n"...)
+	}
+	return nil
+}
+
+// rememberTask adds a task, forgetting the oldest finished tasks beyond
+// maxTasks. Running tasks are never forgotten.
+func rememberTask(task *Task) {
+	tasksMu.Lock()
+	defer tasksMu.Unlock()
+
+	tasks = append(tasks, task)
+	for i := 0; len(tasks) > maxTasks && i < len(tasks); {
+		select {
+		case <-tasks[i].done:
+			tasks = append(tasks[:i], tasks[i+1:]...)
+		default:
+			i++
+		}
+	}
+}
+
+func newTaskID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
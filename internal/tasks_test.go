@@ -0,0 +1,94 @@
+package internal
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/The-Skyscape/devtools/pkg/testutils"
+)
+
+func fakeTaskExec(t *testing.T, fn func(command string, onLine func(string) error) error) {
+	original := taskExec
+	taskExec = fn
+	t.Cleanup(func() { taskExec = original })
+}
+
+func TestStartTask(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	fakeTaskExec(t, func(command string, onLine func(string) error) error {
+		onLine(command)
+		close(started)
+		<-release
+		onLine("added 12 packages")
+		return nil
+	})
+
+	var finished string
+	task := StartTask("web", "/home/coder/repos/web", "npm ci", func(output string, err error) {
+		finished = output
+	})
+
+	// Running: output so far, still polling
+	<-started
+	status := GetTask(task.ID).Status()
+	testutils.AssertEqual(t, false, status.Done)
+	testutils.AssertEqual(t, "cd '/home/coder/repos/web' && npm ci 2>&1\n", status.Output)
+
+	close(release)
+	testutils.AssertEqual(t, nil, task.Wait())
+
+	status = task.Status()
+	testutils.AssertEqual(t, true, status.Done)
+	testutils.AssertEqual(t, "", status.Error)
+	testutils.AssertEqual(t, true, strings.HasSuffix(status.Output, "added 12 packages\n"))
+	testutils.AssertEqual(t, status.Output, finished)
+}
+
+func TestStartTaskFailure(t *testing.T) {
+	fakeTaskExec(t, func(command string, onLine func(string) error) error {
+		return errors.New("exit status 1")
+	})
+
+	task := StartTask("web", "/tmp", "make setup", nil)
+	testutils.AssertEqual(t, "exit status 1", task.Wait().Error())
+	testutils.AssertEqual(t, "'make setup' failed: exit status 1", task.Status().Error)
+}
+
+func TestTaskOutputLimit(t *testing.T) {
+	fakeTaskExec(t, func(command string, onLine func(string) error) error {
+		line := strings.Repeat("x", 1023)
+		for i := 0; i < 2*MaxTaskOutput/1024; i++ {
+			onLine(line)
+		}
+		return nil
+	})
+
+	task := StartTask("web", "/tmp", "yes", nil)
+	task.Wait()
+	output := task.Status().Output
+	testutils.AssertEqual(t, true, len(output) <= MaxTaskOutput+len("\n[output truncated]\n"))
+	testutils.AssertEqual(t, true, strings.HasSuffix(output, "[output truncated]\n"))
+}
+
+func TestRememberTaskKeepsRunningTasks(t *testing.T) {
+	block := make(chan struct{})
+	defer close(block)
+	fakeTaskExec(t, func(command string, onLine func(string) error) error {
+		if command == "cd '/tmp' && slow 2>&1" {
+			<-block
+		}
+		return nil
+	})
+
+	running := StartTask("web", "/tmp", "slow", nil)
+	for i := 0; i < maxTasks+5; i++ {
+		StartTask("web", "/tmp", "fast", nil).Wait()
+	}
+
+	testutils.AssertEqual(t, running, GetTask(running.ID))
+	tasksMu.Lock()
+	count := len(tasks)
+	tasksMu.Unlock()
+	testutils.AssertEqual(t, maxTasks, count)
+}
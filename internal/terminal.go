@@ -0,0 +1,209 @@
+package internal
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+	"workbench/models"
+	"workbench/services"
+)
+
+// Terminal defaults until the terminal settings say otherwise.
+const (
+	DefaultTerminalSessions    = 3
+	DefaultTerminalIdleTimeout = 30 * time.Minute
+)
+
+// terminalShell is what a terminal runs in the coder container.
+var terminalShell = []string{"bash", "-l"}
+
+// ErrTerminalLimit is returned when as many terminals are open as the
+// terminal_max_sessions setting allows.
+var ErrTerminalLimit = errors.New("too many terminals are open")
+
+// terminalPTY is the pseudo-terminal a Terminal relays, a
+// services.PTY outside tests.
+type terminalPTY interface {
+	io.ReadWriter
+	Resize(cols, rows int) error
+	Close() error
+	Done() <-chan struct{}
+}
+
+// Terminal seams. Replaced in tests.
+var (
+	startTerminalPTY = func(cols, rows int) (terminalPTY, error) {
+		return services.CoderExecPTY(terminalShell, cols, rows)
+	}
+	terminalLimit       = func() int { return models.GetIntSetting("terminal_max_sessions") }
+	terminalIdleTimeout = func() time.Duration { return models.GetDurationSetting("terminal_idle_timeout") }
+	terminalActivity    = func(description string) {
+		go models.Activities.Insert(&models.Activity{
+			Type:        "terminal_session",
+			Description: description,
+			Author:      "System",
+			Timestamp:   time.Now(),
+		})
+	}
+)
+
+// terminals are the open terminals by ID.
+var terminals = struct {
+	sync.Mutex
+	open map[string]*Terminal
+}{open: map[string]*Terminal{}}
+
+// Terminal is a shell in the coder container opened from the dashboard,
+// belonging to the signed-in session that opened it. It closes when its
+// shell exits, when nothing is typed or printed for the idle timeout, or
+// when its session signs out.
+type Terminal struct {
+	ID        string
+	SessionID string
+	Started   time.Time
+
+	pty  terminalPTY
+	idle *time.Timer
+
+	mu     sync.Mutex
+	reason string // Why it closed, once it has
+	closed chan struct{}
+}
+
+// OpenTerminal starts a login shell in the coder container for a signed-in
+// session, with a terminal of the given size. The caller relays it and
+// must Close it when the connection ends.
+func OpenTerminal(sessionID string, cols, rows int) (*Terminal, error) {
+	if sessionID == "" {
+		return nil, fmt.Errorf("this session isn't recorded yet - reload the page and try again")
+	}
+
+	terminals.Lock()
+	defer terminals.Unlock()
+	if limit := terminalLimit(); len(terminals.open) >= limit {
+		return nil, fmt.Errorf("%w - close one of the %d first", ErrTerminalLimit, limit)
+	}
+	pty, err := startTerminalPTY(cols, rows)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &Terminal{ID: newTerminalID(), SessionID: sessionID, Started: time.Now(), pty: pty, closed: make(chan struct{})}
+	timeout := terminalIdleTimeout()
+	t.mu.Lock()
+	t.idle = time.AfterFunc(timeout, func() {
+		t.Close(fmt.Sprintf("idle for %s", timeout))
+	})
+	t.mu.Unlock()
+	go func() {
+		select {
+		case <-pty.Done():
+			t.Close("the shell exited")
+		case <-t.closed:
+		}
+	}()
+	terminals.open[t.ID] = t
+
+	terminalActivity("Opened a terminal in the coder container")
+	return t, nil
+}
+
+// Read reads what the shell prints.
+func (t *Terminal) Read(b []byte) (int, error) {
+	n, err := t.pty.Read(b)
+	if n > 0 {
+		t.touch()
+	}
+	return n, err
+}
+
+// Write types into the shell.
+func (t *Terminal) Write(b []byte) (int, error) {
+	t.touch()
+	return t.pty.Write(b)
+}
+
+// Resize sets the terminal size in characters.
+func (t *Terminal) Resize(cols, rows int) error {
+	return t.pty.Resize(cols, rows)
+}
+
+// touch restarts the idle timeout, and counts as use of the coder
+// container.
+func (t *Terminal) touch() {
+	t.idle.Reset(terminalIdleTimeout())
+	services.TouchCoder()
+}
+
+// Close ends the shell and records for how long it was open and why it
+// closed. Only the first reason counts.
+func (t *Terminal) Close(reason string) {
+	t.mu.Lock()
+	if t.reason != "" {
+		t.mu.Unlock()
+		return
+	}
+	t.reason = reason
+	t.idle.Stop()
+	t.mu.Unlock()
+
+	t.pty.Close()
+	terminals.Lock()
+	delete(terminals.open, t.ID)
+	terminals.Unlock()
+
+	terminalActivity(fmt.Sprintf("Closed a terminal after %s: %s", time.Since(t.Started).Round(time.Second), reason))
+	close(t.closed)
+}
+
+// Closed is closed once the terminal is, after its shell has ended.
+func (t *Terminal) Closed() <-chan struct{} {
+	return t.closed
+}
+
+// Reason says why the terminal closed, or "" while it's open.
+func (t *Terminal) Reason() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.reason
+}
+
+// OpenTerminals returns how many terminals are open.
+func OpenTerminals() int {
+	terminals.Lock()
+	defer terminals.Unlock()
+	return len(terminals.open)
+}
+
+// MaxTerminals returns how many terminals may be open at once.
+func MaxTerminals() int {
+	return terminalLimit()
+}
+
+// closeSessionTerminals closes the terminals of signed-out sessions: those
+// of sessionID, or with except set, those of every other session (of
+// every session when sessionID is empty).
+func closeSessionTerminals(sessionID string, except bool) {
+	terminals.Lock()
+	var closing []*Terminal
+	for _, t := range terminals.open {
+		if (t.SessionID == sessionID) != except || (except && sessionID == "") {
+			closing = append(closing, t)
+		}
+	}
+	terminals.Unlock()
+
+	for _, t := range closing {
+		t.Close("its session signed out")
+	}
+}
+
+func newTerminalID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
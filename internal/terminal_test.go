@@ -0,0 +1,174 @@
+package internal
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/The-Skyscape/devtools/pkg/testutils"
+)
+
+// fakePTY is a terminal whose shell echoes what's typed.
+type fakePTY struct {
+	mu     sync.Mutex
+	input  bytes.Buffer
+	size   [2]int
+	closed bool
+	done   chan struct{}
+}
+
+func (p *fakePTY) Read(b []byte) (int, error) { return copy(b, "$ "), nil }
+
+func (p *fakePTY) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.input.Write(b)
+}
+
+func (p *fakePTY) Resize(cols, rows int) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.size = [2]int{cols, rows}
+	return nil
+}
+
+func (p *fakePTY) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	return nil
+}
+
+func (p *fakePTY) Done() <-chan struct{} { return p.done }
+
+func (p *fakePTY) isClosed() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.closed
+}
+
+// fakeTerminals replaces the terminal seams until the test ends. The
+// started terminals are sent on ptys and recorded activities on
+// activities.
+func fakeTerminals(t *testing.T, limit int, idle time.Duration) (ptys chan *fakePTY, activities chan string) {
+	originalStart, originalLimit, originalIdle, originalActivity := startTerminalPTY, terminalLimit, terminalIdleTimeout, terminalActivity
+	ptys = make(chan *fakePTY, 16)
+	activities = make(chan string, 32)
+	startTerminalPTY = func(cols, rows int) (terminalPTY, error) {
+		p := &fakePTY{size: [2]int{cols, rows}, done: make(chan struct{})}
+		ptys <- p
+		return p, nil
+	}
+	terminalLimit = func() int { return limit }
+	terminalIdleTimeout = func() time.Duration { return idle }
+	terminalActivity = func(description string) { activities <- description }
+	t.Cleanup(func() {
+		for _, open := range allTerminals() {
+			open.Close("test ended")
+		}
+		startTerminalPTY, terminalLimit, terminalIdleTimeout, terminalActivity = originalStart, originalLimit, originalIdle, originalActivity
+	})
+	return ptys, activities
+}
+
+func allTerminals() []*Terminal {
+	terminals.Lock()
+	defer terminals.Unlock()
+	var all []*Terminal
+	for _, t := range terminals.open {
+		all = append(all, t)
+	}
+	return all
+}
+
+// waitClosed waits for a terminal to close and returns why it did.
+func waitClosed(t *testing.T, term *Terminal) string {
+	t.Helper()
+	select {
+	case <-term.Closed():
+		return term.Reason()
+	case <-time.After(5 * time.Second):
+		t.Fatal("the terminal stayed open")
+		return ""
+	}
+}
+
+func TestOpenTerminalRelaysTheShell(t *testing.T) {
+	ptys, activities := fakeTerminals(t, 2, time.Hour)
+	term, err := OpenTerminal("session-1", 100, 30)
+	testutils.AssertEqual(t, nil, err)
+	pty := <-ptys
+	testutils.AssertEqual(t, "Opened a terminal in the coder container", <-activities)
+	testutils.AssertEqual(t, [2]int{100, 30}, pty.size)
+
+	term.Write([]byte("ls\r"))
+	testutils.AssertEqual(t, "ls\r", pty.input.String())
+	term.Resize(120, 40)
+	testutils.AssertEqual(t, [2]int{120, 40}, pty.size)
+	testutils.AssertEqual(t, 1, OpenTerminals())
+
+	term.Close("disconnected")
+	term.Close("closed again")
+	testutils.AssertEqual(t, true, pty.isClosed())
+	testutils.AssertEqual(t, "disconnected", term.Reason())
+	testutils.AssertEqual(t, true, strings.HasSuffix(<-activities, ": disconnected"))
+	testutils.AssertEqual(t, 0, len(activities))
+	testutils.AssertEqual(t, 0, OpenTerminals())
+}
+
+func TestOpenTerminalLimitsOpenTerminals(t *testing.T) {
+	fakeTerminals(t, 1, time.Hour)
+	first, err := OpenTerminal("session-1", 80, 24)
+	testutils.AssertEqual(t, nil, err)
+
+	_, err = OpenTerminal("session-1", 80, 24)
+	testutils.AssertEqual(t, true, errors.Is(err, ErrTerminalLimit))
+
+	first.Close("disconnected")
+	_, err = OpenTerminal("session-1", 80, 24)
+	testutils.AssertEqual(t, nil, err)
+}
+
+func TestOpenTerminalNeedsASession(t *testing.T) {
+	fakeTerminals(t, 1, time.Hour)
+	_, err := OpenTerminal("", 80, 24)
+	testutils.AssertEqual(t, true, err != nil)
+	testutils.AssertEqual(t, 0, OpenTerminals())
+}
+
+func TestTerminalClosesWhenIdle(t *testing.T) {
+	ptys, _ := fakeTerminals(t, 1, 20*time.Millisecond)
+	term, err := OpenTerminal("session-1", 80, 24)
+	testutils.AssertEqual(t, nil, err)
+
+	testutils.AssertEqual(t, "idle for 20ms", waitClosed(t, term))
+	testutils.AssertEqual(t, true, (<-ptys).isClosed())
+}
+
+func TestTerminalClosesWhenTheShellExits(t *testing.T) {
+	ptys, _ := fakeTerminals(t, 1, time.Hour)
+	term, err := OpenTerminal("session-1", 80, 24)
+	testutils.AssertEqual(t, nil, err)
+
+	close((<-ptys).done)
+	testutils.AssertEqual(t, "the shell exited", waitClosed(t, term))
+}
+
+func TestSignoutClosesTheSessionsTerminals(t *testing.T) {
+	fakeTerminals(t, 3, time.Hour)
+	mine, _ := OpenTerminal("session-1", 80, 24)
+	other, _ := OpenTerminal("session-2", 80, 24)
+	third, _ := OpenTerminal("session-3", 80, 24)
+
+	closeSessionTerminals("session-2", false)
+	testutils.AssertEqual(t, "its session signed out", other.Reason())
+	testutils.AssertEqual(t, "", mine.Reason())
+
+	closeSessionTerminals("session-1", true)
+	testutils.AssertEqual(t, "its session signed out", third.Reason())
+	testutils.AssertEqual(t, "", mine.Reason())
+	testutils.AssertEqual(t, 1, OpenTerminals())
+}
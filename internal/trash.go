@@ -0,0 +1,250 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"workbench/models"
+	"workbench/services"
+)
+
+// TrashDir is where repositories are archived before they're deleted.
+const TrashDir = "/home/coder/.trash"
+
+// DefaultTrashQuotaMB is how much archived repositories may take up before
+// the oldest archives are deleted.
+const DefaultTrashQuotaMB = 2048
+
+// TrashRetention is how long archives are kept.
+const TrashRetention = 30 * 24 * time.Hour
+
+// trashTimeFormat is the timestamp in an archive's file name.
+const trashTimeFormat = "20060102-150405"
+
+// trashFileName matches an archive's file name, {name}-{timestamp}.tar.gz.
+var trashFileName = regexp.MustCompile(`^([A-Za-z0-9._\-]+)-(\d{8}-\d{6})\.tar\.gz$`)
+
+// TrashArchive is a deleted repository's archived working tree.
+type TrashArchive struct {
+	File       string    `json:"file"`
+	Repository string    `json:"repository"`
+	Size       int64     `json:"size"` // Bytes
+	ArchivedAt time.Time `json:"archived_at"`
+}
+
+// trashExec runs commands against the trash in the coder container.
+// Replaced in tests.
+var trashExec = operationExec
+
+// TrashQuota returns the trash_quota_mb setting in bytes.
+func TrashQuota() int64 {
+	return int64(models.GetIntSetting("trash_quota_mb")) << 20
+}
+
+// ArchiveRepository writes a repository's working tree, .git included, to
+// a tar.gz in TrashDir and returns its path. The oldest archives are then
+// deleted until the trash fits its quota, sparing the new one.
+func ArchiveRepository(repo *models.Repository, now time.Time) (string, error) {
+	archive := path.Join(TrashDir, fmt.Sprintf("%s-%s.tar.gz", repo.Name, now.UTC().Format(trashTimeFormat)))
+	cmd := fmt.Sprintf("mkdir -p %s && tar -czf %s -C %s %s 2>&1", TrashDir, shellQuote(archive),
+		shellQuote(path.Dir(repo.LocalPath)), shellQuote(path.Base(repo.LocalPath)))
+	if output, err := trashExec(cmd); err != nil {
+		trashExec("rm -f " + shellQuote(archive))
+		return "", fmt.Errorf("failed to archive %s, so it wasn't deleted: %s", repo.Name, strings.TrimSpace(output))
+	}
+
+	archives, err := ListTrash()
+	if err != nil {
+		slog.Error("failed to check the trash quota", "error", err)
+		return archive, nil
+	}
+	removeTrash(overTrashQuota(archives, TrashQuota(), path.Base(archive)), "over the trash quota")
+	return archive, nil
+}
+
+// ListTrash returns the archives in the trash, oldest first.
+func ListTrash() ([]*TrashArchive, error) {
+	output, err := trashExec(fmt.Sprintf("test -d %s || exit 0; find %s -maxdepth 1 -type f -name '*.tar.gz' -printf '%%f\\t%%s\\t%%T@\\n'", TrashDir, TrashDir))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the trash")
+	}
+	return parseTrashListing(output), nil
+}
+
+// parseTrashListing reads find's "name<TAB>bytes<TAB>mtime" lines. Files
+// that aren't named like archives are left out. The time in the name is
+// used rather than the mtime, which tar can't be trusted to keep.
+func parseTrashListing(output string) []*TrashArchive {
+	var archives []*TrashArchive
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) < 2 {
+			continue
+		}
+		m := trashFileName.FindStringSubmatch(fields[0])
+		if m == nil {
+			continue
+		}
+		archivedAt, err := time.Parse(trashTimeFormat, m[2])
+		if err != nil {
+			continue
+		}
+		size, _ := strconv.ParseInt(fields[1], 10, 64)
+		archives = append(archives, &TrashArchive{File: fields[0], Repository: m[1], Size: size, ArchivedAt: archivedAt})
+	}
+	sort.SliceStable(archives, func(i, j int) bool { return archives[i].ArchivedAt.Before(archives[j].ArchivedAt) })
+	return archives
+}
+
+// overTrashQuota returns the oldest archives to delete for the rest to fit
+// in quota bytes, never choosing keep.
+func overTrashQuota(archives []*TrashArchive, quota int64, keep string) []*TrashArchive {
+	var total int64
+	for _, a := range archives {
+		total += a.Size
+	}
+	var remove []*TrashArchive
+	for _, a := range archives {
+		if total <= quota {
+			break
+		}
+		if a.File == keep {
+			continue
+		}
+		remove = append(remove, a)
+		total -= a.Size
+	}
+	return remove
+}
+
+// expiredTrash returns the archives made before cutoff.
+func expiredTrash(archives []*TrashArchive, cutoff time.Time) []*TrashArchive {
+	var expired []*TrashArchive
+	for _, a := range archives {
+		if a.ArchivedAt.Before(cutoff) {
+			expired = append(expired, a)
+		}
+	}
+	return expired
+}
+
+// removeTrash deletes archives, recording each as a trash_purge activity.
+func removeTrash(archives []*TrashArchive, reason string) {
+	for _, a := range archives {
+		if _, err := trashExec("rm -f " + shellQuote(path.Join(TrashDir, a.File))); err != nil {
+			slog.Error("failed to remove from the trash", "file", a.File, "error", err)
+			continue
+		}
+		go models.Activities.Insert(&models.Activity{
+			Type:        "trash_purge",
+			Repository:  a.Repository,
+			Description: fmt.Sprintf("Deleted archive %s %s", a.File, reason),
+			Author:      "System",
+			Timestamp:   time.Now(),
+		})
+	}
+}
+
+// findTrash looks up an archive by file name.
+func findTrash(file string) (*TrashArchive, error) {
+	if !trashFileName.MatchString(file) {
+		return nil, &repoError{ErrRepoNotFound, fmt.Sprintf("'%s' isn't an archive in the trash", file)}
+	}
+	archives, err := ListTrash()
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range archives {
+		if a.File == file {
+			return a, nil
+		}
+	}
+	return nil, &repoError{ErrRepoNotFound, fmt.Sprintf("'%s' isn't in the trash - it may have been purged", file)}
+}
+
+// OpenTrash returns an archive and its contents for download. The caller
+// closes the reader.
+func OpenTrash(file string) (*TrashArchive, io.ReadCloser, error) {
+	archive, err := findTrash(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	return archive, services.CoderExecReader(context.Background(), "cat "+shellQuote(path.Join(TrashDir, file))), nil
+}
+
+// RestoreTrash extracts an archive into DefaultReposDir, records the
+// repository again with its origin as the URL, and removes the archive.
+// Returns the repository's name.
+func RestoreTrash(file string) (string, error) {
+	archive, err := findTrash(file)
+	if err != nil {
+		return "", err
+	}
+	name := archive.Repository
+
+	existing, err := models.Repositories.Find("WHERE LOWER(Name) = LOWER(?)", name)
+	if err == nil && existing != nil && existing.Name != "" {
+		return "", &repoError{ErrRepoExists, fmt.Sprintf("a repository named '%s' already exists - rename or remove it first", existing.Name)}
+	}
+	target := path.Join(DefaultReposDir, name)
+	if output, _ := trashExec(fmt.Sprintf("test -e %s && echo exists", shellQuote(target))); strings.TrimSpace(output) == "exists" {
+		return "", &repoError{ErrRepoExists, fmt.Sprintf("directory %s already exists - move it before restoring", name)}
+	}
+
+	source := path.Join(TrashDir, file)
+	if output, err := trashExec(fmt.Sprintf("mkdir -p %s && tar -xzf %s -C %s 2>&1", DefaultReposDir, shellQuote(source), DefaultReposDir)); err != nil {
+		return "", fmt.Errorf("failed to extract %s: %s", file, strings.TrimSpace(output))
+	}
+	remote, _ := trashExec(fmt.Sprintf("git -C %s remote get-url origin 2>/dev/null", shellQuote(target)))
+	remote = strings.TrimSpace(remote)
+
+	if _, err := insertRepo(&models.Repository{
+		Name:      name,
+		URL:       remote,
+		LocalPath: target,
+		IsPrivate: strings.Contains(remote, "git@"),
+		Host:      RepoHost(remote),
+	}); err != nil {
+		return "", fmt.Errorf("failed to save repository: %w", err)
+	}
+	trashExec("rm -f " + shellQuote(source))
+
+	go models.Activities.Insert(&models.Activity{
+		Type:        "repo_restore",
+		Repository:  name,
+		Description: fmt.Sprintf("Restored repository %s from the trash", name),
+		Author:      "System",
+		Timestamp:   time.Now(),
+		Metadata:    fmt.Sprintf(`{"archive":%q}`, source),
+	})
+	return name, nil
+}
+
+// PurgeTrash deletes archives older than TrashRetention.
+func PurgeTrash(now time.Time) error {
+	archives, err := ListTrash()
+	if err != nil {
+		return err
+	}
+	removeTrash(expiredTrash(archives, now.Add(-TrashRetention)), "after 30 days")
+	return nil
+}
+
+func init() {
+	RegisterJob(JobSpec{
+		Name:        "trash-purge",
+		Description: "Delete repository archives older than 30 days",
+		Interval:    Every(24 * time.Hour),
+		Jitter:      30 * time.Minute,
+		Priority:    PriorityLow,
+		Immediate:   true,
+		Run:         PurgeTrash,
+	})
+}
@@ -0,0 +1,82 @@
+package internal
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+	"workbench/models"
+
+	"github.com/The-Skyscape/devtools/pkg/testutils"
+)
+
+func TestParseTrashListing(t *testing.T) {
+	output := "web-20250301-090000.tar.gz\t2048\t1740819600.0\n" +
+		"api-20250101-120000.tar.gz\t1024\t1735732800.0\n" +
+		"notes.txt\t12\t1735732800.0\n"
+
+	archives := parseTrashListing(output)
+	testutils.AssertEqual(t, 2, len(archives))
+	testutils.AssertEqual(t, "api-20250101-120000.tar.gz", archives[0].File)
+	testutils.AssertEqual(t, "api", archives[0].Repository)
+	testutils.AssertEqual(t, int64(1024), archives[0].Size)
+	testutils.AssertEqual(t, time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC), archives[0].ArchivedAt)
+	testutils.AssertEqual(t, "web", archives[1].Repository)
+
+	testutils.AssertEqual(t, 0, len(parseTrashListing("")))
+}
+
+func TestOverTrashQuota(t *testing.T) {
+	archives := []*TrashArchive{
+		{File: "a-20250101-000000.tar.gz", Size: 400},
+		{File: "b-20250102-000000.tar.gz", Size: 300},
+		{File: "c-20250103-000000.tar.gz", Size: 500},
+	}
+
+	testutils.AssertEqual(t, 0, len(overTrashQuota(archives, 1200, "c-20250103-000000.tar.gz")))
+
+	remove := overTrashQuota(archives, 800, "c-20250103-000000.tar.gz")
+	testutils.AssertEqual(t, 1, len(remove))
+	testutils.AssertEqual(t, "a-20250101-000000.tar.gz", remove[0].File)
+
+	// The new archive is kept even when it alone is over the quota
+	remove = overTrashQuota(archives, 100, "c-20250103-000000.tar.gz")
+	testutils.AssertEqual(t, 2, len(remove))
+	testutils.AssertEqual(t, "b-20250102-000000.tar.gz", remove[1].File)
+}
+
+func TestExpiredTrash(t *testing.T) {
+	now := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+	archives := []*TrashArchive{
+		{File: "old-20250101-000000.tar.gz", ArchivedAt: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{File: "new-20250215-000000.tar.gz", ArchivedAt: time.Date(2025, 2, 15, 0, 0, 0, 0, time.UTC)},
+	}
+
+	expired := expiredTrash(archives, now.Add(-TrashRetention))
+	testutils.AssertEqual(t, 1, len(expired))
+	testutils.AssertEqual(t, "old-20250101-000000.tar.gz", expired[0].File)
+}
+
+func TestArchiveRepositoryFailure(t *testing.T) {
+	original := trashExec
+	t.Cleanup(func() { trashExec = original })
+	var commands []string
+	trashExec = func(command string) (string, error) {
+		commands = append(commands, command)
+		if strings.Contains(command, "tar -czf") {
+			return "tar: No space left on device", errors.New("exit status 2")
+		}
+		return "", nil
+	}
+
+	repo := &models.Repository{Name: "api", LocalPath: "/home/coder/repos/api"}
+	_, err := ArchiveRepository(repo, time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC))
+	testutils.AssertEqual(t, "failed to archive api, so it wasn't deleted: tar: No space left on device", err.Error())
+	testutils.AssertEqual(t, 2, len(commands))
+	testutils.AssertEqual(t, "rm -f '/home/coder/.trash/api-20250101-120000.tar.gz'", commands[1])
+}
+
+func TestFindTrashRejectsPaths(t *testing.T) {
+	_, err := findTrash("../repos/api-20250101-120000.tar.gz")
+	testutils.AssertEqual(t, true, errors.Is(err, ErrRepoNotFound))
+}
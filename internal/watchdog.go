@@ -0,0 +1,253 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+	"workbench/models"
+	"workbench/services"
+
+	"github.com/The-Skyscape/devtools/pkg/database"
+)
+
+// Data volume states reported by the watchdog and the health endpoint.
+const (
+	VolumeHealthy    = "healthy"
+	VolumeProtective = "protective" // Volume changed or unwritable, recovering
+	VolumeFailed     = "failed"     // Recovery attempts exhausted
+)
+
+// MaxVolumeRecoveryAttempts caps recovery attempts before the watchdog
+// gives up and records a critical failure.
+const MaxVolumeRecoveryAttempts = 5
+
+const volumeCheckInterval = 30 * time.Second
+
+// VolumeStatus is a snapshot of the data volume watchdog.
+type VolumeStatus struct {
+	State     string
+	Reason    string
+	FSID      string // Filesystem ID recorded at startup
+	CurrentID string // Filesystem ID seen by the last check
+	Attempts  int
+	Since     time.Time
+}
+
+// Protective reports whether the workbench is in protective mode.
+func (s VolumeStatus) Protective() bool {
+	return s.State != VolumeHealthy
+}
+
+// volumeWatchdog tracks the data volume and drives protective mode. The
+// probes are fields so the state machine can be tested without a real volume.
+type volumeWatchdog struct {
+	mu       sync.Mutex
+	status   VolumeStatus
+	volumeID func() (string, error)
+	probe    func() error
+	recover  func() error
+	record   func(activityType, description string)
+}
+
+var watchdog = &volumeWatchdog{
+	status:   VolumeStatus{State: VolumeHealthy},
+	volumeID: func() (string, error) { return dataVolumeID(database.DataDir()) },
+	probe:    func() error { return probeWritable(database.DataDir()) },
+	recover:  recoverDataVolume,
+	record:   recordVolumeActivity,
+}
+
+// GetVolumeStatus returns the current data volume watchdog status.
+func GetVolumeStatus() VolumeStatus {
+	watchdog.mu.Lock()
+	defer watchdog.mu.Unlock()
+	return watchdog.status
+}
+
+// BackgroundJobsPaused reports whether background jobs should skip their
+// work because the data volume is in protective mode.
+func BackgroundJobsPaused() bool {
+	return GetVolumeStatus().Protective()
+}
+
+// StartVolumeWatchdog records the data directory's filesystem ID and then
+// checks every 30 seconds that it hasn't changed and is still writable, as
+// the volume-watchdog loop job, which can't be paused: protective mode
+// depends on it. Runs until the process exits.
+func StartVolumeWatchdog() {
+	if id, err := watchdog.volumeID(); err == nil {
+		watchdog.mu.Lock()
+		watchdog.status.FSID = id
+		watchdog.status.CurrentID = id
+		watchdog.mu.Unlock()
+	} else {
+		slog.Error("failed to read data volume ID", "error", err)
+	}
+
+	job := RegisterUnpausableLoop("volume-watchdog", "Check the data volume is unchanged and writable", volumeCheckInterval)
+	ticker := time.NewTicker(volumeCheckInterval)
+	for now := range ticker.C {
+		if !job.RunStarted() {
+			continue
+		}
+		watchdog.check(now)
+		var err error
+		if status := GetVolumeStatus(); status.Protective() {
+			err = fmt.Errorf("data volume is %s: %s", status.State, status.Reason)
+		}
+		job.RunFinished(err)
+	}
+}
+
+// check runs one watchdog cycle: detect a changed or unwritable volume,
+// enter protective mode, and attempt recovery until writes succeed again
+// or the attempt cap is reached. The probes and recovery (which restarts
+// the coder container) run on a copy of the status without holding the
+// lock, so status readers such as the health endpoint never wait on them.
+func (w *volumeWatchdog) check(now time.Time) {
+	w.mu.Lock()
+	status := w.status
+	w.mu.Unlock()
+
+	if status.State == VolumeFailed {
+		return
+	}
+
+	previous := status.CurrentID
+	current, idErr := w.volumeID()
+	probeErr := w.probe()
+
+	var reason string
+	switch {
+	case idErr != nil:
+		reason = fmt.Sprintf("data directory unavailable: %v", idErr)
+	case previous != "" && current != previous:
+		reason = fmt.Sprintf("data volume changed from %s to %s", previous, current)
+	case probeErr != nil:
+		reason = fmt.Sprintf("data directory not writable: %v", probeErr)
+	}
+	if idErr == nil {
+		status.CurrentID = current
+	}
+
+	if status.State == VolumeHealthy {
+		if reason == "" {
+			w.publish(status)
+			return
+		}
+		status.State = VolumeProtective
+		status.Reason = reason
+		status.Since = now
+		status.Attempts = 0
+		w.record("data_volume_protective", fmt.Sprintf("Entered protective mode: %s (fsid %s -> %s)",
+			reason, previous, status.CurrentID))
+	}
+
+	if idErr != nil || probeErr != nil {
+		status.Attempts++
+		if status.Attempts >= MaxVolumeRecoveryAttempts {
+			status.State = VolumeFailed
+			w.record("data_volume_recovery_failed", fmt.Sprintf("Data volume recovery failed after %d attempts: %s",
+				status.Attempts, status.Reason))
+		}
+		w.publish(status)
+		return
+	}
+
+	// Writes succeed again: rebind the coder mounts and verify state.
+	// Background jobs stay paused while this runs.
+	status.Attempts++
+	w.publish(status)
+	if err := w.recover(); err != nil {
+		status.Reason = fmt.Sprintf("recovery failed: %v", err)
+		if status.Attempts >= MaxVolumeRecoveryAttempts {
+			status.State = VolumeFailed
+			w.record("data_volume_recovery_failed", fmt.Sprintf("Data volume recovery failed after %d attempts: %v",
+				status.Attempts, err))
+		}
+		w.publish(status)
+		return
+	}
+
+	w.record("data_volume_recovered", fmt.Sprintf("Data volume recovered after %d attempt(s) (fsid %s -> %s)",
+		status.Attempts, status.FSID, status.CurrentID))
+	w.publish(VolumeStatus{State: VolumeHealthy, FSID: status.CurrentID, CurrentID: status.CurrentID})
+}
+
+// publish replaces the status seen by readers.
+func (w *volumeWatchdog) publish(status VolumeStatus) {
+	w.mu.Lock()
+	w.status = status
+	w.mu.Unlock()
+}
+
+// dataVolumeID returns the filesystem ID of the volume holding dir.
+func dataVolumeID(dir string) (string, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x:%x", uint32(stat.Fsid.X__val[0]), uint32(stat.Fsid.X__val[1])), nil
+}
+
+// probeWritable checks that dir accepts writes by creating and removing
+// a small file.
+func probeWritable(dir string) error {
+	path := filepath.Join(dir, ".workbench-probe")
+	if err := os.WriteFile(path, []byte(time.Now().UTC().Format(time.RFC3339)), 0600); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// recoverDataVolume re-runs the database health check, restarts the coder
+// container so its mounts bind to the new volume, and reconciles
+// repositories against what the container can see.
+func recoverDataVolume() error {
+	if _, err := models.SetSetting("volume_health_check", time.Now().UTC().Format(time.RFC3339), "system"); err != nil {
+		return fmt.Errorf("database health check failed: %w", err)
+	}
+	if err := services.CoderRestart(); err != nil {
+		return fmt.Errorf("failed to restart coder: %w", err)
+	}
+	return reconcileRepositories()
+}
+
+// reconcileRepositories flags each repository whose checkout is no longer
+// visible inside the coder container as missing.
+func reconcileRepositories() error {
+	repos, err := models.Repositories.Search("")
+	if err != nil {
+		return fmt.Errorf("failed to load repositories: %w", err)
+	}
+
+	for _, repo := range repos {
+		if repo.CloneStatus != models.CloneReady {
+			continue
+		}
+		if _, err := services.CoderExec(fmt.Sprintf("test -d %s/.git", shellQuote(repo.LocalPath))); err != nil {
+			repoMissingActivity(repo, true, "after volume recovery")
+		}
+	}
+	return nil
+}
+
+func recordVolumeActivity(activityType, description string) {
+	level := slog.LevelWarn
+	if activityType == "data_volume_recovered" {
+		level = slog.LevelInfo
+	}
+	slog.Log(context.Background(), level, description, "type", activityType)
+	go models.Activities.Insert(&models.Activity{
+		Type:        activityType,
+		Repository:  "",
+		Description: description,
+		Author:      "System",
+		Timestamp:   time.Now(),
+	})
+}
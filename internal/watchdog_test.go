@@ -0,0 +1,128 @@
+package internal
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/The-Skyscape/devtools/pkg/testutils"
+)
+
+// fakeVolume drives a volumeWatchdog with scripted probe results.
+type fakeVolume struct {
+	id         string
+	idErr      error
+	probeErr   error
+	recoverErr error
+	recovered  int
+	activities []string
+}
+
+func (f *fakeVolume) watchdog() *volumeWatchdog {
+	return &volumeWatchdog{
+		status:   VolumeStatus{State: VolumeHealthy, FSID: "a:1", CurrentID: "a:1"},
+		volumeID: func() (string, error) { return f.id, f.idErr },
+		probe:    func() error { return f.probeErr },
+		recover: func() error {
+			f.recovered++
+			return f.recoverErr
+		},
+		record: func(activityType, description string) {
+			f.activities = append(f.activities, activityType+": "+description)
+		},
+	}
+}
+
+func TestWatchdogHealthy(t *testing.T) {
+	f := &fakeVolume{id: "a:1"}
+	w := f.watchdog()
+	w.check(time.Now())
+
+	testutils.AssertEqual(t, VolumeHealthy, w.status.State)
+	testutils.AssertEqual(t, 0, f.recovered)
+	testutils.AssertEqual(t, 0, len(f.activities))
+}
+
+func TestWatchdogVolumeChanged(t *testing.T) {
+	f := &fakeVolume{id: "b:2"}
+	w := f.watchdog()
+	w.check(time.Now())
+
+	// Writes still succeed on the new volume, so recovery runs immediately
+	testutils.AssertEqual(t, VolumeHealthy, w.status.State)
+	testutils.AssertEqual(t, 1, f.recovered)
+	testutils.AssertEqual(t, "b:2", w.status.FSID)
+	testutils.AssertEqual(t, 2, len(f.activities))
+	testutils.AssertEqual(t, true, strings.Contains(f.activities[0], "fsid a:1 -> b:2"))
+	testutils.AssertEqual(t, true, strings.HasPrefix(f.activities[1], "data_volume_recovered"))
+}
+
+func TestWatchdogUnwritableThenRecovers(t *testing.T) {
+	f := &fakeVolume{id: "a:1", probeErr: errors.New("read-only file system")}
+	w := f.watchdog()
+
+	w.check(time.Now())
+	testutils.AssertEqual(t, VolumeProtective, w.status.State)
+	testutils.AssertEqual(t, true, w.status.Protective())
+	testutils.AssertEqual(t, 0, f.recovered)
+
+	f.probeErr = nil
+	w.check(time.Now())
+	testutils.AssertEqual(t, VolumeHealthy, w.status.State)
+	testutils.AssertEqual(t, 1, f.recovered)
+}
+
+func TestWatchdogRecoveryCapped(t *testing.T) {
+	f := &fakeVolume{id: "a:1", probeErr: errors.New("input/output error")}
+	w := f.watchdog()
+
+	for i := 0; i < MaxVolumeRecoveryAttempts+3; i++ {
+		w.check(time.Now())
+	}
+
+	testutils.AssertEqual(t, VolumeFailed, w.status.State)
+	testutils.AssertEqual(t, MaxVolumeRecoveryAttempts, w.status.Attempts)
+	last := f.activities[len(f.activities)-1]
+	testutils.AssertEqual(t, true, strings.HasPrefix(last, "data_volume_recovery_failed"))
+	testutils.AssertEqual(t, 2, len(f.activities))
+}
+
+func TestWatchdogRecoverFails(t *testing.T) {
+	f := &fakeVolume{id: "b:2", recoverErr: errors.New("coder not initialized")}
+	w := f.watchdog()
+
+	for i := 0; i < MaxVolumeRecoveryAttempts; i++ {
+		w.check(time.Now())
+	}
+
+	testutils.AssertEqual(t, VolumeFailed, w.status.State)
+	testutils.AssertEqual(t, MaxVolumeRecoveryAttempts, f.recovered)
+}
+
+func TestWatchdogStatusReadableDuringRecovery(t *testing.T) {
+	f := &fakeVolume{id: "b:2"}
+	w := f.watchdog()
+
+	// Recovery restarts the coder container, which can take a while; the
+	// health endpoint must still be able to read the status meanwhile.
+	var during VolumeStatus
+	w.recover = func() error {
+		read := make(chan VolumeStatus)
+		go func() {
+			w.mu.Lock()
+			defer w.mu.Unlock()
+			read <- w.status
+		}()
+		select {
+		case during = <-read:
+		case <-time.After(time.Second):
+			t.Error("status lock held during recovery")
+		}
+		return nil
+	}
+
+	w.check(time.Now())
+	testutils.AssertEqual(t, VolumeProtective, during.State)
+	testutils.AssertEqual(t, VolumeHealthy, w.status.State)
+}
@@ -0,0 +1,199 @@
+package internal
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+	"workbench/models"
+)
+
+// WebhookMaxBody caps the push payload read from a git provider. Payloads
+// list every pushed commit, so they can be far larger than API requests.
+const WebhookMaxBody = 5 << 20
+
+// ErrWebhookUnauthorized is returned by HandleWebhook when a delivery's
+// signature or token doesn't match the repository's webhook secret. Unknown
+// repositories and repositories without a secret get the same error, so
+// deliveries can't probe which repositories exist.
+var ErrWebhookUnauthorized = errors.New("invalid webhook signature")
+
+// Webhook delivery outcomes.
+const (
+	WebhookQueued  = "queued"  // A pull was queued
+	WebhookIgnored = "ignored" // Verified, but nothing to pull
+	WebhookPong    = "pong"    // GitHub's ping when a webhook is added
+)
+
+// WebhookDelivery is a push notification from a git provider.
+type WebhookDelivery struct {
+	Event       string // X-GitHub-Event or X-Gitlab-Event
+	Signature   string // X-Hub-Signature-256, from GitHub
+	GitLabToken string // X-Gitlab-Token, from GitLab
+	Body        []byte
+}
+
+// WebhookResult describes what a verified delivery did.
+type WebhookResult struct {
+	Status  string `json:"status"`
+	Message string `json:"message"`
+}
+
+var (
+	// webhookBranch returns the checked-out branch. Replaced in tests.
+	webhookBranch = func(repo *models.Repository) (string, error) {
+		status, err := readRepoStatus(repo)
+		if err != nil {
+			return "", err
+		}
+		return status.Branch, nil
+	}
+
+	// webhookPull pulls a repository for a delivery, waking a paused
+	// coder container per coder_paused_jobs. Replaced in tests.
+	webhookPull = func(repo *models.Repository) error {
+		return WithCoderAwake("a webhook pull of "+repo.Name, func() error {
+			return pullAndRecord(context.Background(), repo, "repo_webhook_pull")
+		})
+	}
+
+	webhookMu sync.Mutex
+	// webhookPulls holds repositories with a webhook pull running, and
+	// whether another push arrived while it ran.
+	webhookPulls = map[string]bool{}
+)
+
+// GenerateWebhookSecret creates a new webhook secret for a repository,
+// replacing any previous one, and returns it.
+func GenerateWebhookSecret(repoName string) (string, error) {
+	repo, err := models.Repositories.Find("WHERE Name = ?", repoName)
+	if err != nil {
+		return "", repoNotFound(repoName)
+	}
+	if repo.IsLocal() {
+		return "", fmt.Errorf("'%s' has no remote to receive pushes from - export it to a remote first", repoName)
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate webhook secret: %w", err)
+	}
+	repo.WebhookSecret = hex.EncodeToString(b)
+	if err := models.Repositories.Update(repo); err != nil {
+		return "", fmt.Errorf("failed to save webhook secret")
+	}
+
+	go models.Activities.Insert(&models.Activity{
+		Type:        "repo_webhook_create",
+		Repository:  repoName,
+		Description: fmt.Sprintf("Generated a webhook secret for %s", repoName),
+		Author:      "System",
+		Timestamp:   time.Now(),
+	})
+	return repo.WebhookSecret, nil
+}
+
+// HandleWebhook verifies a push delivery for a repository and, when the
+// pushed branch is the checked-out one, queues a pull. Pushes that arrive
+// while a pull is running are pulled once it finishes. Returns
+// ErrWebhookUnauthorized for deliveries that fail verification.
+func HandleWebhook(repoName string, delivery WebhookDelivery) (*WebhookResult, error) {
+	repo, err := models.Repositories.Find("WHERE Name = ?", repoName)
+	if err != nil || repo.WebhookSecret == "" {
+		return nil, ErrWebhookUnauthorized
+	}
+	if !verifyWebhook(repo.WebhookSecret, delivery) {
+		return nil, ErrWebhookUnauthorized
+	}
+
+	if delivery.Event == "ping" {
+		return &WebhookResult{Status: WebhookPong, Message: "webhook is set up"}, nil
+	}
+	branch, ok := pushedBranch(delivery.Body)
+	if !ok {
+		return &WebhookResult{Status: WebhookIgnored, Message: "not a branch push"}, nil
+	}
+	if AutoPullPaused(repo.Host) {
+		return &WebhookResult{Status: WebhookIgnored, Message: fmt.Sprintf("auto-pull is paused for %s", repo.Host)}, nil
+	}
+	current, err := webhookBranch(repo)
+	if err != nil {
+		return nil, err
+	}
+	if branch != current {
+		return &WebhookResult{Status: WebhookIgnored, Message: fmt.Sprintf("pushed %s, but %s is checked out", branch, current)}, nil
+	}
+
+	queueWebhookPull(repo)
+	return &WebhookResult{Status: WebhookQueued, Message: fmt.Sprintf("pulling %s", branch)}, nil
+}
+
+// verifyWebhook checks a GitHub signature, an HMAC-SHA256 of the body
+// sent as "sha256=<hex>", or a GitLab token, which is the secret itself.
+func verifyWebhook(secret string, delivery WebhookDelivery) bool {
+	if signature, ok := strings.CutPrefix(delivery.Signature, "sha256="); ok {
+		got, err := hex.DecodeString(signature)
+		if err != nil {
+			return false
+		}
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(delivery.Body)
+		return hmac.Equal(got, mac.Sum(nil))
+	}
+	if delivery.GitLabToken != "" {
+		return subtle.ConstantTimeCompare([]byte(delivery.GitLabToken), []byte(secret)) == 1
+	}
+	return false
+}
+
+// pushedBranch returns the branch a GitHub or GitLab push payload updated.
+// Tag pushes and other events have no branch.
+func pushedBranch(body []byte) (string, bool) {
+	var payload struct {
+		Ref string `json:"ref"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return "", false
+	}
+	branch, ok := strings.CutPrefix(payload.Ref, "refs/heads/")
+	if !ok || branch == "" {
+		return "", false
+	}
+	return branch, true
+}
+
+// queueWebhookPull pulls a repository in the background. If a webhook
+// pull of it is already running, one more pull follows it instead, so
+// bursts of pushes don't stack up concurrent pulls.
+func queueWebhookPull(repo *models.Repository) {
+	webhookMu.Lock()
+	defer webhookMu.Unlock()
+	if _, running := webhookPulls[repo.Name]; running {
+		webhookPulls[repo.Name] = true
+		return
+	}
+	webhookPulls[repo.Name] = false
+
+	go func() {
+		for {
+			webhookPull(repo)
+
+			webhookMu.Lock()
+			if !webhookPulls[repo.Name] {
+				delete(webhookPulls, repo.Name)
+				webhookMu.Unlock()
+				return
+			}
+			webhookPulls[repo.Name] = false
+			webhookMu.Unlock()
+		}
+	}()
+}
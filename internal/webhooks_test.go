@@ -0,0 +1,116 @@
+package internal
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"testing"
+	"time"
+	"workbench/models"
+
+	"github.com/The-Skyscape/devtools/pkg/testutils"
+)
+
+func githubSignature(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestVerifyWebhook(t *testing.T) {
+	secret := "s3cret"
+	body := []byte(`{"ref":"refs/heads/main"}`)
+
+	testCases := []struct {
+		name     string
+		delivery WebhookDelivery
+		expected bool
+	}{
+		{"github signature", WebhookDelivery{Signature: githubSignature(secret, body), Body: body}, true},
+		{"github wrong secret", WebhookDelivery{Signature: githubSignature("other", body), Body: body}, false},
+		{"github tampered body", WebhookDelivery{Signature: githubSignature(secret, body), Body: []byte(`{"ref":"refs/heads/dev"}`)}, false},
+		{"github malformed signature", WebhookDelivery{Signature: "sha256=zz", Body: body}, false},
+		{"github sha1 signature", WebhookDelivery{Signature: "sha1=abc", Body: body}, false},
+		{"gitlab token", WebhookDelivery{GitLabToken: secret, Body: body}, true},
+		{"gitlab wrong token", WebhookDelivery{GitLabToken: "s3cre", Body: body}, false},
+		{"unsigned", WebhookDelivery{Body: body}, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			testutils.AssertEqual(t, tc.expected, verifyWebhook(secret, tc.delivery))
+		})
+	}
+}
+
+func TestPushedBranch(t *testing.T) {
+	testCases := []struct {
+		body   string
+		branch string
+		ok     bool
+	}{
+		{`{"ref":"refs/heads/main","before":"abc"}`, "main", true},
+		{`{"object_kind":"push","ref":"refs/heads/feature/login"}`, "feature/login", true},
+		{`{"ref":"refs/tags/v1.0.0"}`, "", false},
+		{`{"zen":"Keep it logically awesome."}`, "", false},
+		{`not json`, "", false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.body, func(t *testing.T) {
+			branch, ok := pushedBranch([]byte(tc.body))
+			testutils.AssertEqual(t, tc.branch, branch)
+			testutils.AssertEqual(t, tc.ok, ok)
+		})
+	}
+}
+
+func TestQueueWebhookPullCoalescesPushes(t *testing.T) {
+	release := make(chan struct{})
+	var mu sync.Mutex
+	pulls := 0
+	original := webhookPull
+	webhookPull = func(repo *models.Repository) error {
+		mu.Lock()
+		pulls++
+		first := pulls == 1
+		mu.Unlock()
+		if first {
+			<-release
+		}
+		return nil
+	}
+	t.Cleanup(func() { webhookPull = original })
+
+	repo := &models.Repository{Name: "api"}
+	// Three pushes while the first pull runs need just one more pull
+	for i := 0; i < 4; i++ {
+		queueWebhookPull(repo)
+	}
+	close(release)
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		webhookMu.Lock()
+		_, running := webhookPulls["api"]
+		webhookMu.Unlock()
+		if !running {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	testutils.AssertEqual(t, 2, pulls)
+}
+
+func TestRateLimiterBlocked(t *testing.T) {
+	rl := &RateLimiter{attempts: map[string][]time.Time{}, limit: 2, window: time.Minute}
+
+	testutils.AssertEqual(t, false, rl.Blocked("ip"))
+	rl.Allow("ip")
+	rl.Allow("ip")
+	testutils.AssertEqual(t, true, rl.Blocked("ip"))
+	testutils.AssertEqual(t, false, rl.Blocked("other"))
+}
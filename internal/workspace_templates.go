@@ -0,0 +1,464 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"workbench/models"
+)
+
+// WorkspaceTemplateVersion is the document format written by
+// SnapshotWorkspace. Documents from newer versions are refused.
+const WorkspaceTemplateVersion = 1
+
+// templatePreferences are the settings a template carries. Provider tokens,
+// the notification webhook (its URL usually embeds a secret), and system
+// state are deliberately absent, and any other key in a document is ignored.
+var templatePreferences = []string{
+	"locale",
+	"timezone",
+	"activity_digest",
+	"activity_digest_time",
+	"digest_quiet_start",
+	"digest_quiet_end",
+	"autopull_interval",
+	"scaffold_default_branch",
+}
+
+// WorkspaceDocument is the JSON form of a workspace template. It holds
+// configuration only: no secrets, activity, or repository contents.
+type WorkspaceDocument struct {
+	Version       int                    `json:"version"`
+	Name          string                 `json:"name"`
+	CreatedAt     time.Time              `json:"created_at"`
+	Repositories  []TemplateRepository   `json:"repositories"`
+	Extensions    []string               `json:"extensions"`
+	ScaffoldFiles []TemplateScaffoldFile `json:"scaffold_files"`
+	Preferences   map[string]string      `json:"preferences"`
+}
+
+// TemplateRepository is a repository to clone and the setup steps that run
+// after it is cloned.
+type TemplateRepository struct {
+	Name   string   `json:"name"`
+	URL    string   `json:"url"`
+	Branch string   `json:"branch,omitempty"`
+	Hooks  []string `json:"post_clone_hooks,omitempty"`
+}
+
+// TemplateScaffoldFile is an always-include file for new projects.
+type TemplateScaffoldFile struct {
+	Path     string `json:"path"`
+	Template string `json:"template,omitempty"` // Scaffold template ID, empty for all
+	Content  string `json:"content"`
+}
+
+// Template change actions.
+const (
+	ChangeAdd    = "add"
+	ChangeUpdate = "change"
+	ChangeSkip   = "skip"
+)
+
+// TemplateChange is one item a template would add or change, or skips
+// because the instance already has it.
+type TemplateChange struct {
+	Kind   string // "repository", "post-clone hook", "extension", "scaffold file", or "preference"
+	Item   string
+	Action string // ChangeAdd, ChangeUpdate, or ChangeSkip
+	Note   string
+	Error  string // Set after applying when the change failed
+
+	repo  *TemplateRepository   // Repository and post-clone hook changes
+	file  *TemplateScaffoldFile // Scaffold file changes
+	value string                // Preference value or hook step ID
+	apply func() error          // Changes planned by a backup restore
+}
+
+// Failed reports whether applying the change failed.
+func (c *TemplateChange) Failed() bool {
+	return c.Error != ""
+}
+
+// Configuration reads and writes used by templates. Replaced in tests.
+var (
+	templateExec  = operationExec
+	templateRepos = func() ([]*models.Repository, error) {
+		return models.Repositories.Search("ORDER BY Name ASC")
+	}
+	templateScaffoldFiles = func() ([]*models.ScaffoldFile, error) {
+		return models.ScaffoldFiles.Search("ORDER BY Path ASC, Template ASC")
+	}
+	templateSetting = models.GetSetting
+	templateHooks   = func(repoName string) []string {
+		return settingList(postCloneHooksKey(repoName))
+	}
+	templateApply = applyTemplateChange
+)
+
+// SnapshotWorkspace captures the current configuration as a template
+// document.
+func SnapshotWorkspace(name string) (*WorkspaceDocument, error) {
+	doc := &WorkspaceDocument{
+		Version:     WorkspaceTemplateVersion,
+		Name:        name,
+		CreatedAt:   time.Now().UTC(),
+		Preferences: map[string]string{},
+	}
+
+	repos, err := templateRepos()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load repositories")
+	}
+	for _, repo := range repos {
+		branch, err := templateExec(fmt.Sprintf("cd %s && git rev-parse --abbrev-ref HEAD 2>/dev/null", shellQuote(repo.LocalPath)))
+		if err != nil || strings.TrimSpace(branch) == "HEAD" {
+			branch = ""
+		}
+		doc.Repositories = append(doc.Repositories, TemplateRepository{
+			Name:   repo.Name,
+			URL:    repo.URL,
+			Branch: strings.TrimSpace(branch),
+			Hooks:  templateHooks(repo.Name),
+		})
+	}
+
+	output, err := templateExec("code-server --list-extensions 2>/dev/null")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list VS Code extensions - is the coder container running?")
+	}
+	for _, line := range strings.Split(output, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			doc.Extensions = append(doc.Extensions, line)
+		}
+	}
+	sort.Strings(doc.Extensions)
+
+	files, err := templateScaffoldFiles()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load scaffold files")
+	}
+	for _, file := range files {
+		doc.ScaffoldFiles = append(doc.ScaffoldFiles, TemplateScaffoldFile{Path: file.Path, Template: file.Template, Content: file.Content})
+	}
+
+	for _, key := range templatePreferences {
+		if value, _ := templateSetting(key); value != "" {
+			doc.Preferences[key] = value
+		}
+	}
+	return doc, nil
+}
+
+// ParseWorkspaceDocument decodes and checks a template document, such as
+// one downloaded from another instance.
+func ParseWorkspaceDocument(data []byte) (*WorkspaceDocument, error) {
+	var doc WorkspaceDocument
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("not a workspace template: %v", err)
+	}
+	if doc.Version < 1 {
+		return nil, fmt.Errorf("not a workspace template: missing version")
+	}
+	if doc.Version > WorkspaceTemplateVersion {
+		return nil, fmt.Errorf("template version %d is newer than this workbench supports (%d)", doc.Version, WorkspaceTemplateVersion)
+	}
+	if strings.TrimSpace(doc.Name) == "" {
+		return nil, fmt.Errorf("template name is required")
+	}
+	return &doc, nil
+}
+
+// PlanWorkspaceTemplate compares a template with the current configuration
+// and lists what applying it would do. Nothing the template lacks is ever
+// removed.
+func PlanWorkspaceTemplate(template, current *WorkspaceDocument) []*TemplateChange {
+	var changes []*TemplateChange
+
+	byName := map[string]TemplateRepository{}
+	byURL := map[string]TemplateRepository{}
+	for _, repo := range current.Repositories {
+		byName[strings.ToLower(repo.Name)] = repo
+		byURL[repo.URL] = repo
+	}
+	for i := range template.Repositories {
+		repo := &template.Repositories[i]
+		change := &TemplateChange{Kind: "repository", Item: repo.Name, repo: repo}
+		existing, cloned := byName[strings.ToLower(repo.Name)]
+		switch {
+		case cloned:
+			change.Action, change.Note = ChangeSkip, "already cloned"
+			if repo.Branch != "" && existing.Branch != repo.Branch {
+				change.Note += fmt.Sprintf(" (on %s, template uses %s)", existing.Branch, repo.Branch)
+			}
+		case byURL[repo.URL].Name != "":
+			existing = byURL[repo.URL]
+			change.Action, change.Note = ChangeSkip, fmt.Sprintf("already cloned as %s", existing.Name)
+		default:
+			existing = TemplateRepository{Name: repo.Name}
+			change.Action, change.Note = ChangeAdd, "clone "+repo.URL
+			if repo.Branch != "" {
+				change.Note += " and check out " + repo.Branch
+			}
+		}
+		changes = append(changes, change)
+
+		// Hooks belong to the repository under its name on this instance
+		target := &TemplateRepository{Name: existing.Name}
+		for _, hook := range repo.Hooks {
+			change := &TemplateChange{Kind: "post-clone hook", Item: target.Name + ": " + hook, repo: target, value: hook}
+			if slices.Contains(existing.Hooks, hook) {
+				change.Action, change.Note = ChangeSkip, "already runs after cloning"
+			} else {
+				change.Action, change.Note = ChangeAdd, "run after cloning"
+			}
+			changes = append(changes, change)
+		}
+	}
+
+	installed := map[string]bool{}
+	for _, ext := range current.Extensions {
+		installed[strings.ToLower(ext)] = true
+	}
+	for _, ext := range template.Extensions {
+		change := &TemplateChange{Kind: "extension", Item: ext, Action: ChangeAdd, Note: "install"}
+		if installed[strings.ToLower(ext)] {
+			change.Action, change.Note = ChangeSkip, "already installed"
+		}
+		changes = append(changes, change)
+	}
+
+	files := map[string]string{}
+	for _, file := range current.ScaffoldFiles {
+		files[file.Template+"\x00"+file.Path] = file.Content
+	}
+	for i := range template.ScaffoldFiles {
+		file := &template.ScaffoldFiles[i]
+		item := file.Path
+		if file.Template != "" {
+			item += " (" + file.Template + " projects)"
+		}
+		change := &TemplateChange{Kind: "scaffold file", Item: item, file: file}
+		content, exists := files[file.Template+"\x00"+file.Path]
+		switch {
+		case !exists:
+			change.Action, change.Note = ChangeAdd, fmt.Sprintf("%d bytes", len(file.Content))
+		case content == file.Content:
+			change.Action, change.Note = ChangeSkip, "already present"
+		default:
+			change.Action, change.Note = ChangeUpdate, fmt.Sprintf("replace content (%d → %d bytes)", len(content), len(file.Content))
+		}
+		changes = append(changes, change)
+	}
+
+	for _, key := range templatePreferences {
+		value, ok := template.Preferences[key]
+		if !ok {
+			continue
+		}
+		change := &TemplateChange{Kind: "preference", Item: key, value: value}
+		switch old := current.Preferences[key]; {
+		case old == value:
+			change.Action, change.Note = ChangeSkip, "already set to "+value
+		case old == "":
+			change.Action, change.Note = ChangeAdd, "set to "+value
+		default:
+			change.Action, change.Note = ChangeUpdate, old+" → "+value
+		}
+		changes = append(changes, change)
+	}
+	return changes
+}
+
+// applyChanges applies every change that isn't skipped, recording each
+// failure on its change and carrying on with the rest. Post-clone hooks
+// go first so they run when their repository is cloned.
+func applyChanges(changes []*TemplateChange) (applied, failed int) {
+	for _, hooksFirst := range []bool{true, false} {
+		for _, change := range changes {
+			if change.Action == ChangeSkip || (change.Kind == "post-clone hook") != hooksFirst {
+				continue
+			}
+			if err := templateApply(change); err != nil {
+				change.Error = err.Error()
+				failed++
+				continue
+			}
+			applied++
+		}
+	}
+	return applied, failed
+}
+
+// applyTemplateChange makes a single change to this instance.
+func applyTemplateChange(change *TemplateChange) error {
+	if change.apply != nil {
+		return change.apply()
+	}
+	switch change.Kind {
+	case "post-clone hook":
+		return addSettingListItem(postCloneHooksKey(change.repo.Name), change.value)
+
+	case "repository":
+		if err := CloneRepository(context.Background(), change.repo.URL, change.repo.Name, ""); err != nil {
+			return err
+		}
+		if change.repo.Branch == "" {
+			return nil
+		}
+		reposDir, _ := RepoDir("")
+		cmd := fmt.Sprintf("cd %s && git checkout %s 2>&1", shellQuote(filepath.Join(reposDir, change.repo.Name)), shellQuote(change.repo.Branch))
+		if output, err := templateExec(cmd); err != nil {
+			return fmt.Errorf("cloned, but failed to check out %s: %s", change.repo.Branch, strings.TrimSpace(output))
+		}
+		return nil
+
+	case "extension":
+		if output, err := templateExec("code-server --install-extension " + shellQuote(change.Item) + " 2>&1"); err != nil {
+			return fmt.Errorf("failed to install: %s", lastLine(output))
+		}
+		return nil
+
+	case "scaffold file":
+		return SaveScaffoldFile(change.file.Path, change.file.Content, change.file.Template)
+
+	case "preference":
+		switch change.Item {
+		case "autopull_interval":
+			minutes, err := strconv.Atoi(change.value)
+			if err != nil {
+				return fmt.Errorf("invalid auto-pull interval")
+			}
+			return SetAutoPullInterval(minutes)
+		case "scaffold_default_branch":
+			return SetDefaultBranch(change.value)
+		default:
+			_, err := models.SetSetting(change.Item, change.value, "user_preference")
+			return err
+		}
+	}
+	return fmt.Errorf("unknown change kind '%s'", change.Kind)
+}
+
+func lastLine(output string) string {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
+
+// GetWorkspaceTemplates returns saved templates by name.
+func GetWorkspaceTemplates() ([]*models.WorkspaceTemplate, error) {
+	return models.WorkspaceTemplates.Search("ORDER BY Name ASC")
+}
+
+// CreateWorkspaceTemplate snapshots the current configuration under a
+// name, replacing an earlier snapshot with the same name.
+func CreateWorkspaceTemplate(name string) (*models.WorkspaceTemplate, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, fmt.Errorf("template name is required")
+	}
+	doc, err := SnapshotWorkspace(name)
+	if err != nil {
+		return nil, err
+	}
+	return saveWorkspaceTemplate(doc)
+}
+
+// ImportWorkspaceTemplate saves a template document downloaded from
+// another instance, replacing a template with the same name.
+func ImportWorkspaceTemplate(data []byte) (*models.WorkspaceTemplate, error) {
+	doc, err := ParseWorkspaceDocument(data)
+	if err != nil {
+		return nil, err
+	}
+	return saveWorkspaceTemplate(doc)
+}
+
+func saveWorkspaceTemplate(doc *WorkspaceDocument) (*models.WorkspaceTemplate, error) {
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode template")
+	}
+
+	existing, err := models.WorkspaceTemplates.Find("WHERE Name = ?", doc.Name)
+	if err == nil && existing != nil && existing.Name != "" {
+		existing.Document = string(data)
+		if err := models.WorkspaceTemplates.Update(existing); err != nil {
+			return nil, fmt.Errorf("failed to save template")
+		}
+		return existing, nil
+	}
+
+	template, err := models.WorkspaceTemplates.Insert(&models.WorkspaceTemplate{Name: doc.Name, Document: string(data)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to save template")
+	}
+	return template, nil
+}
+
+// GetWorkspaceTemplate loads a saved template.
+func GetWorkspaceTemplate(id string) (*models.WorkspaceTemplate, error) {
+	template, err := models.WorkspaceTemplates.Get(id)
+	if err != nil {
+		return nil, fmt.Errorf("workspace template not found")
+	}
+	return template, nil
+}
+
+// DeleteWorkspaceTemplate removes a saved template. Nothing it was applied
+// to changes.
+func DeleteWorkspaceTemplate(id string) error {
+	template, err := GetWorkspaceTemplate(id)
+	if err != nil {
+		return err
+	}
+	return models.WorkspaceTemplates.Delete(template)
+}
+
+// PreviewWorkspaceTemplate lists what applying a saved template would do.
+func PreviewWorkspaceTemplate(id string) (*models.WorkspaceTemplate, []*TemplateChange, error) {
+	template, err := GetWorkspaceTemplate(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	doc, err := ParseWorkspaceDocument([]byte(template.Document))
+	if err != nil {
+		return nil, nil, err
+	}
+	current, err := SnapshotWorkspace("current")
+	if err != nil {
+		return nil, nil, err
+	}
+	return template, PlanWorkspaceTemplate(doc, current), nil
+}
+
+// ApplyWorkspaceTemplate applies a saved template and returns every change
+// with its result. Items that already exist are skipped, so applying a
+// template twice changes nothing the second time.
+func ApplyWorkspaceTemplate(id string) (*models.WorkspaceTemplate, []*TemplateChange, error) {
+	template, changes, err := PreviewWorkspaceTemplate(id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	applied, failed := applyChanges(changes)
+	activity := &models.Activity{
+		Type:        "workspace_template",
+		Repository:  "",
+		Description: fmt.Sprintf("Applied workspace template %s: %d changes", template.Name, applied),
+		Author:      "System",
+		Timestamp:   time.Now(),
+	}
+	if failed > 0 {
+		activity.Type = "workspace_template_failed"
+		activity.Description = fmt.Sprintf("Applied workspace template %s: %d changes, %d failed", template.Name, applied, failed)
+	}
+	go models.Activities.Insert(activity)
+	return template, changes, nil
+}
@@ -0,0 +1,188 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"workbench/models"
+
+	"github.com/The-Skyscape/devtools/pkg/testutils"
+)
+
+// fakeWorkspace replaces the configuration reads for the duration of a
+// test with a fixed instance.
+func fakeWorkspace(t *testing.T) {
+	exec, repos, files, setting, hooks, apply := templateExec, templateRepos, templateScaffoldFiles, templateSetting, templateHooks, templateApply
+	t.Cleanup(func() {
+		templateExec, templateRepos, templateScaffoldFiles, templateSetting, templateHooks, templateApply = exec, repos, files, setting, hooks, apply
+	})
+
+	templateRepos = func() ([]*models.Repository, error) {
+		return []*models.Repository{
+			{Name: "api", URL: "git@github.com:acme/api.git", LocalPath: "/home/coder/repos/api"},
+			{Name: "web", URL: "https://github.com/acme/web.git", LocalPath: "/home/coder/repos/web"},
+		}, nil
+	}
+	templateExec = func(cmd string) (string, error) {
+		switch {
+		case strings.Contains(cmd, "/repos/api"):
+			return "develop\n", nil
+		case strings.Contains(cmd, "/repos/web"):
+			return "HEAD\n", nil // Detached
+		case strings.Contains(cmd, "--list-extensions"):
+			return "golang.go\nesbenp.prettier-vscode\n", nil
+		}
+		return "", fmt.Errorf("unexpected command %q", cmd)
+	}
+	templateScaffoldFiles = func() ([]*models.ScaffoldFile, error) {
+		return []*models.ScaffoldFile{{Path: "LICENSE", Content: "MIT"}, {Path: ".golangci.yml", Template: "go", Content: "linters: {}"}}, nil
+	}
+	templateSetting = func(key string) (string, error) {
+		settings := map[string]string{
+			"locale":                  "de",
+			"autopull_interval":       "30",
+			"provider_token_github":   "ghp_secret",
+			"notify_webhook_url":      "https://hooks.example.com/T000/secret",
+			"activity_digest_last":    "2026-10-01T00:00:00Z",
+			"scaffold_default_branch": "main",
+		}
+		return settings[key], nil
+	}
+	templateHooks = func(repoName string) []string {
+		if repoName == "api" {
+			return []string{"go-mod-download"}
+		}
+		return nil
+	}
+}
+
+func TestSnapshotWorkspace(t *testing.T) {
+	fakeWorkspace(t)
+
+	doc, err := SnapshotWorkspace("Team")
+	testutils.AssertEqual(t, nil, err)
+	testutils.AssertEqual(t, WorkspaceTemplateVersion, doc.Version)
+	testutils.AssertEqual(t, 2, len(doc.Repositories))
+	testutils.AssertEqual(t, "develop", doc.Repositories[0].Branch)
+	testutils.AssertEqual(t, "go-mod-download", strings.Join(doc.Repositories[0].Hooks, ","))
+	testutils.AssertEqual(t, "", doc.Repositories[1].Branch)
+	testutils.AssertEqual(t, "esbenp.prettier-vscode,golang.go", strings.Join(doc.Extensions, ","))
+	testutils.AssertEqual(t, 2, len(doc.ScaffoldFiles))
+	testutils.AssertEqual(t, "go", doc.ScaffoldFiles[1].Template)
+
+	// Only non-secret preferences are captured
+	testutils.AssertEqual(t, 3, len(doc.Preferences))
+	testutils.AssertEqual(t, "de", doc.Preferences["locale"])
+	data, _ := json.Marshal(doc)
+	testutils.AssertEqual(t, false, strings.Contains(string(data), "secret"))
+
+	// The downloaded document parses back to the same configuration
+	parsed, err := ParseWorkspaceDocument(data)
+	testutils.AssertEqual(t, nil, err)
+	reencoded, _ := json.Marshal(parsed)
+	testutils.AssertEqual(t, string(data), string(reencoded))
+
+	// Nothing to do when applied to the instance it came from
+	for _, change := range PlanWorkspaceTemplate(parsed, doc) {
+		testutils.AssertEqual(t, ChangeSkip, change.Action)
+	}
+}
+
+func TestParseWorkspaceDocument(t *testing.T) {
+	testCases := []struct {
+		name     string
+		document string
+		expected string
+	}{
+		{"valid", `{"version":1,"name":"Team"}`, ""},
+		{"not json", `repos: []`, "not a workspace template: invalid character 'r' looking for beginning of value"},
+		{"no version", `{"name":"Team"}`, "not a workspace template: missing version"},
+		{"newer", `{"version":2,"name":"Team"}`, "template version 2 is newer than this workbench supports (1)"},
+		{"no name", `{"version":1}`, "template name is required"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			message := ""
+			if _, err := ParseWorkspaceDocument([]byte(tc.document)); err != nil {
+				message = err.Error()
+			}
+			testutils.AssertEqual(t, tc.expected, message)
+		})
+	}
+}
+
+func TestPlanWorkspaceTemplate(t *testing.T) {
+	current := &WorkspaceDocument{
+		Repositories: []TemplateRepository{
+			{Name: "api", URL: "git@github.com:acme/api.git", Branch: "main", Hooks: []string{"go-mod-download"}},
+			{Name: "website", URL: "https://github.com/acme/web.git"},
+		},
+		Extensions:    []string{"golang.go"},
+		ScaffoldFiles: []TemplateScaffoldFile{{Path: "LICENSE", Content: "MIT"}, {Path: ".editorconfig", Content: "root = true"}},
+		Preferences:   map[string]string{"locale": "de", "timezone": "UTC"},
+	}
+	template := &WorkspaceDocument{
+		Repositories: []TemplateRepository{
+			{Name: "API", URL: "git@github.com:acme/api.git", Branch: "develop", Hooks: []string{"go-mod-download", "make-setup"}},
+			{Name: "web", URL: "https://github.com/acme/web.git"},
+			{Name: "docs", URL: "https://github.com/acme/docs.git", Branch: "gh-pages"},
+		},
+		Extensions:    []string{"Golang.Go", "esbenp.prettier-vscode"},
+		ScaffoldFiles: []TemplateScaffoldFile{{Path: "LICENSE", Content: "Apache-2.0"}, {Path: ".editorconfig", Content: "root = true"}, {Path: "Makefile", Template: "go", Content: "all:"}},
+		Preferences:   map[string]string{"locale": "de", "timezone": "Europe/Berlin", "autopull_interval": "60", "provider_token_github": "ghp_x"},
+	}
+
+	var got []string
+	for _, change := range PlanWorkspaceTemplate(template, current) {
+		got = append(got, fmt.Sprintf("%s|%s|%s|%s", change.Kind, change.Item, change.Action, change.Note))
+	}
+	expected := []string{
+		"repository|API|skip|already cloned (on main, template uses develop)",
+		"post-clone hook|api: go-mod-download|skip|already runs after cloning",
+		"post-clone hook|api: make-setup|add|run after cloning",
+		"repository|web|skip|already cloned as website",
+		"repository|docs|add|clone https://github.com/acme/docs.git and check out gh-pages",
+		"extension|Golang.Go|skip|already installed",
+		"extension|esbenp.prettier-vscode|add|install",
+		"scaffold file|LICENSE|change|replace content (3 → 10 bytes)",
+		"scaffold file|.editorconfig|skip|already present",
+		"scaffold file|Makefile (go projects)|add|4 bytes",
+		"preference|locale|skip|already set to de",
+		"preference|timezone|change|UTC → Europe/Berlin",
+		"preference|autopull_interval|add|set to 60",
+	}
+	testutils.AssertEqual(t, strings.Join(expected, "\n"), strings.Join(got, "\n"))
+}
+
+func TestApplyChangesReportsPartialFailures(t *testing.T) {
+	fakeWorkspace(t)
+	var order []string
+	templateApply = func(change *TemplateChange) error {
+		order = append(order, change.Item)
+		if change.Item == "ms-python.python" {
+			return errors.New("failed to install: extension not found")
+		}
+		return nil
+	}
+
+	repo := &TemplateRepository{Name: "docs", URL: "https://github.com/acme/docs.git"}
+	changes := []*TemplateChange{
+		{Kind: "repository", Item: "docs", Action: ChangeAdd, repo: repo},
+		{Kind: "post-clone hook", Item: "docs: npm-install", Action: ChangeAdd, repo: repo, value: "npm-install"},
+		{Kind: "extension", Item: "golang.go", Action: ChangeSkip},
+		{Kind: "extension", Item: "ms-python.python", Action: ChangeAdd},
+		{Kind: "preference", Item: "locale", Action: ChangeUpdate, value: "fr"},
+	}
+
+	applied, failed := applyChanges(changes)
+	testutils.AssertEqual(t, 3, applied)
+	testutils.AssertEqual(t, 1, failed)
+	testutils.AssertEqual(t, "docs: npm-install,docs,ms-python.python,locale", strings.Join(order, ","))
+	testutils.AssertEqual(t, false, changes[0].Failed())
+	testutils.AssertEqual(t, false, changes[2].Failed())
+	testutils.AssertEqual(t, "failed to install: extension not found", changes[3].Error)
+	testutils.AssertEqual(t, false, changes[4].Failed())
+}
@@ -2,16 +2,30 @@ package main
 
 import (
 	"embed"
+	"log/slog"
+	"os"
 
 	"github.com/The-Skyscape/devtools/pkg/application"
 
 	"workbench/controllers"
+	"workbench/internal"
+	"workbench/models"
 )
 
 //go:embed all:views
 var views embed.FS
 
 func main() {
+	// Structured logs, in the format LOG_FORMAT asks for
+	internal.SetupLogging()
+
+	// Bring the database up to date before anything reads it. Serving
+	// from a half-migrated database could lose data, so don't start
+	if err := models.Migrate(); err != nil {
+		slog.Error("not starting: the database couldn't be migrated - fix the cause and start again", "error", err)
+		os.Exit(1)
+	}
+
 	// Start application
 	application.Serve(views,
 		application.WithDaisyTheme("dark"),
@@ -0,0 +1,31 @@
+package models
+
+import (
+	"time"
+
+	"github.com/The-Skyscape/devtools/pkg/application"
+)
+
+// AccessRecord is an audit entry for data exposed through a read endpoint
+// (file browser, downloads, blame, diffs, archives, share links).
+// Repeated access to the same path by the same requester within a minute
+// is coalesced into one record with a Count.
+type AccessRecord struct {
+	application.Model
+	RouteType     string    // Read surface: file, download, blame, diff, archive, share
+	Repository    string    // Repository name
+	Path          string    // File path or ref that was read
+	Bytes         uint64    // Total bytes returned across coalesced reads
+	Requester     string    // Session user handle, token name, or share token
+	RequesterType string    // session, token, or share
+	ClientIP      string    // Remote address of the requester
+	Count         int       // Number of coalesced reads
+	Timestamp     time.Time // First access in this record (UTC)
+	LastAccessAt  time.Time // Most recent coalesced access (UTC)
+}
+
+// Table returns the database table name for the AccessRecord model.
+// Required by the devtools ORM for database operations.
+func (*AccessRecord) Table() string {
+	return "access_records"
+}
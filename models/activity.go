@@ -1,9 +1,12 @@
 package models
 
 import (
+	"fmt"
+	"strings"
 	"time"
-	
+
 	"github.com/The-Skyscape/devtools/pkg/application"
+	"github.com/The-Skyscape/devtools/pkg/database"
 )
 
 // Activity represents an audit log entry for user and system actions.
@@ -24,4 +27,138 @@ type Activity struct {
 // Required by the devtools ORM for database operations.
 func (*Activity) Table() string {
 	return "activities"
-}
\ No newline at end of file
+}
+
+// ActivityFilter narrows activity searches. Empty fields match everything.
+type ActivityFilter struct {
+	Type         string
+	TypePrefix   string    // e.g. "auth_" for every authentication event
+	Repository   string    // Matched by name, so deleted repositories keep their history
+	ExcludeTypes []string  // Left out unless Type names one of them
+	From         time.Time // Created at or after; zero for no lower bound
+	To           time.Time // Created before; zero for no upper bound
+}
+
+// Where returns the filter as a WHERE clause with its arguments, or an
+// empty clause when nothing is filtered. Values are always bound, never
+// formatted into the query.
+func (f ActivityFilter) Where() (string, []any) {
+	var conditions []string
+	var args []any
+	if f.Type != "" {
+		conditions = append(conditions, "Type = ?")
+		args = append(args, f.Type)
+	} else if len(f.ExcludeTypes) > 0 {
+		conditions = append(conditions, "Type NOT IN (?"+strings.Repeat(", ?", len(f.ExcludeTypes)-1)+")")
+		for _, t := range f.ExcludeTypes {
+			args = append(args, t)
+		}
+	}
+	if f.TypePrefix != "" {
+		conditions = append(conditions, "substr(Type, 1, ?) = ?")
+		args = append(args, len(f.TypePrefix), f.TypePrefix)
+	}
+	if f.Repository != "" {
+		conditions = append(conditions, "Repository = ?")
+		args = append(args, f.Repository)
+	}
+	if !f.From.IsZero() {
+		conditions = append(conditions, "CreatedAt >= ?")
+		args = append(args, f.From.UTC())
+	}
+	if !f.To.IsZero() {
+		conditions = append(conditions, "CreatedAt < ?")
+		args = append(args, f.To.UTC())
+	}
+	if len(conditions) == 0 {
+		return "", nil
+	}
+	return "WHERE " + strings.Join(conditions, " AND "), args
+}
+
+// SearchActivities returns one page of matching activities, newest first.
+func SearchActivities(f ActivityFilter, limit, offset int) ([]*Activity, error) {
+	where, args := f.Where()
+	return Activities.Search(strings.TrimSpace(where+" ORDER BY CreatedAt DESC LIMIT ? OFFSET ?"), append(args, limit, offset)...)
+}
+
+// CountActivities returns how many activities match the filter.
+func CountActivities(f ActivityFilter) int {
+	where, args := f.Where()
+	return Activities.Count(where, args...)
+}
+
+// DeleteActivitiesBefore deletes every activity created before cutoff
+// in one statement and returns how many went.
+func DeleteActivitiesBefore(cutoff time.Time) (int, error) {
+	cutoff = cutoff.UTC() // CreatedAt is stored in UTC and compared as text
+	count := Activities.Count("WHERE CreatedAt < ?", cutoff)
+	if count == 0 {
+		return 0, nil
+	}
+	if err := DB.Query(fmt.Sprintf("DELETE FROM %s WHERE CreatedAt < ?", (*Activity)(nil).Table()), cutoff).Exec(); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// ActivityTypes returns the distinct activity types recorded, sorted.
+func ActivityTypes() ([]string, error) {
+	activities, err := Activities.Search("GROUP BY Type ORDER BY Type ASC")
+	if err != nil {
+		return nil, err
+	}
+	types := make([]string, 0, len(activities))
+	for _, a := range activities {
+		types = append(types, a.Type)
+	}
+	return types, nil
+}
+
+// ActivityCount is how many activities of one type a repository had in
+// one bucket of CountActivitiesBetween.
+type ActivityCount struct {
+	Bucket     int // Index of the bucket's start in bounds
+	Type       string
+	Repository string
+	Count      int
+}
+
+// CountActivitiesBetween counts activities per bucket, type, and
+// repository, grouped by the database so large logs stay fast. Bucket i
+// runs from bounds[i] up to bounds[i+1]; activities outside every bucket
+// aren't counted. Buckets with no activities have no counts.
+func CountActivitiesBetween(bounds []time.Time) ([]ActivityCount, error) {
+	if len(bounds) < 2 {
+		return nil, nil
+	}
+	query, args := activityCountQuery(bounds)
+	var counts []ActivityCount
+	err := DB.Query(query, args...).All(func(scan database.ScanFunc) error {
+		var c ActivityCount
+		if err := scan(&c.Bucket, &c.Type, &c.Repository, &c.Count); err != nil {
+			return err
+		}
+		counts = append(counts, c)
+		return nil
+	})
+	return counts, err
+}
+
+// activityCountQuery builds the query for CountActivitiesBetween. The
+// bucket is picked by comparing CreatedAt with each bound, so buckets can
+// follow local midnights, daylight saving changes included. CreatedAt is
+// stored in UTC and compared as text, so the bounds are bound in UTC too.
+func activityCountQuery(bounds []time.Time) (string, []any) {
+	var bucket strings.Builder
+	args := make([]any, 0, len(bounds)+1)
+	bucket.WriteString("CASE")
+	for i, end := range bounds[1:] {
+		fmt.Fprintf(&bucket, " WHEN CreatedAt < ? THEN %d", i)
+		args = append(args, end.UTC())
+	}
+	bucket.WriteString(" END")
+	args = append(args, bounds[0].UTC(), bounds[len(bounds)-1].UTC())
+	return fmt.Sprintf("SELECT %s AS Bucket, Type, Repository, COUNT(*) FROM %s WHERE CreatedAt >= ? AND CreatedAt < ? GROUP BY Bucket, Type, Repository",
+		bucket.String(), (*Activity)(nil).Table()), args
+}
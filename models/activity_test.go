@@ -0,0 +1,113 @@
+package models
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/The-Skyscape/devtools/pkg/database/local"
+	"github.com/The-Skyscape/devtools/pkg/testutils"
+)
+
+// testDatabase points the collections at a new database in a temporary
+// directory for the duration of a test.
+func testDatabase(t *testing.T) {
+	t.Helper()
+	original := DB
+	InitializeForTesting(local.Database(filepath.Join(t.TempDir(), "workbench.db")))
+	t.Cleanup(func() { InitializeForTesting(original) })
+}
+
+// insertActivityAt records an activity created at a given time.
+func insertActivityAt(t *testing.T, activityType, repo string, at time.Time) {
+	t.Helper()
+	activity, err := Activities.Insert(&Activity{Type: activityType, Repository: repo, Timestamp: at})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := DB.Query("UPDATE activities SET CreatedAt = ? WHERE ID = ?", at.UTC(), activity.ID).Exec(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestActivityFilterWhere(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter ActivityFilter
+		where  string
+		args   int
+	}{
+		{"everything", ActivityFilter{}, "", 0},
+		{"type", ActivityFilter{Type: "repo_pull"}, "WHERE Type = ?", 1},
+		{"repository", ActivityFilter{Repository: "api'; DROP TABLE activities; --"}, "WHERE Repository = ?", 1},
+		{"excluded types", ActivityFilter{ExcludeTypes: []string{"a", "b"}}, "WHERE Type NOT IN (?, ?)", 2},
+		{"type wins over exclusions", ActivityFilter{Type: "a", ExcludeTypes: []string{"a", "b"}}, "WHERE Type = ?", 1},
+		{"combined", ActivityFilter{Type: "repo_pull", Repository: "api"}, "WHERE Type = ? AND Repository = ?", 2},
+		{"type prefix", ActivityFilter{TypePrefix: "auth_", ExcludeTypes: []string{"a"}}, "WHERE Type NOT IN (?) AND substr(Type, 1, ?) = ?", 3},
+		{"dates", ActivityFilter{Type: "a", From: time.Unix(1, 0), To: time.Unix(2, 0)}, "WHERE Type = ? AND CreatedAt >= ? AND CreatedAt < ?", 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			where, args := tt.filter.Where()
+			testutils.AssertEqual(t, tt.where, where)
+			testutils.AssertEqual(t, tt.args, len(args))
+		})
+	}
+}
+
+func TestActivityCountQuery(t *testing.T) {
+	day := time.Date(2026, 3, 28, 0, 0, 0, 0, time.UTC)
+	bounds := []time.Time{day, day.AddDate(0, 0, 1), day.AddDate(0, 0, 2)}
+
+	query, args := activityCountQuery(bounds)
+	testutils.AssertEqual(t, "SELECT CASE WHEN CreatedAt < ? THEN 0 WHEN CreatedAt < ? THEN 1 END AS Bucket, Type, Repository, COUNT(*) "+
+		"FROM activities WHERE CreatedAt >= ? AND CreatedAt < ? GROUP BY Bucket, Type, Repository", query)
+	testutils.AssertEqual(t, []any{bounds[1], bounds[2], bounds[0], bounds[2]}, args)
+}
+
+func TestActivityCountQueryBindsUTC(t *testing.T) {
+	zone := time.FixedZone("EST", -5*60*60)
+	day := time.Date(2026, 3, 28, 0, 0, 0, 0, zone)
+
+	_, args := activityCountQuery([]time.Time{day, day.AddDate(0, 0, 1)})
+	testutils.AssertEqual(t, []any{day.AddDate(0, 0, 1).UTC(), day.UTC(), day.AddDate(0, 0, 1).UTC()}, args)
+}
+
+func TestCountActivitiesBetween(t *testing.T) {
+	testDatabase(t)
+	// Local midnights five hours behind UTC, so a late evening activity
+	// is already the next day in UTC
+	zone := time.FixedZone("EST", -5*60*60)
+	day := time.Date(2026, 3, 28, 0, 0, 0, 0, zone)
+	insertActivityAt(t, "repo_pull", "api", day.Add(-time.Hour))                  // The evening before: outside
+	insertActivityAt(t, "repo_pull", "api", day.Add(9*time.Hour))                 // Morning of day 0
+	insertActivityAt(t, "repo_pull", "api", day.Add(23*time.Hour+30*time.Minute)) // Late on day 0, day 1 in UTC
+	insertActivityAt(t, "repo_push", "web", day.AddDate(0, 0, 1).Add(time.Hour))  // Day 1
+	insertActivityAt(t, "repo_push", "web", day.AddDate(0, 0, 2))                 // The end bound: outside
+
+	counts, err := CountActivitiesBetween([]time.Time{day, day.AddDate(0, 0, 1), day.AddDate(0, 0, 2)})
+	testutils.AssertEqual(t, nil, err)
+	got := map[string]int{}
+	for _, c := range counts {
+		got[fmt.Sprintf("%d %s %s", c.Bucket, c.Type, c.Repository)] = c.Count
+	}
+	testutils.AssertEqual(t, map[string]int{"0 repo_pull api": 2, "1 repo_push web": 1}, got)
+}
+
+func TestDeleteActivitiesBefore(t *testing.T) {
+	testDatabase(t)
+	now := time.Now()
+	for _, days := range []int{100, 95, 1} {
+		insertActivityAt(t, "repo_pull", "api", now.AddDate(0, 0, -days))
+	}
+
+	pruned, err := DeleteActivitiesBefore(now.AddDate(0, 0, -90))
+	testutils.AssertEqual(t, nil, err)
+	testutils.AssertEqual(t, 2, pruned)
+	testutils.AssertEqual(t, 1, Activities.Count(""))
+
+	pruned, err = DeleteActivitiesBefore(now.AddDate(0, 0, -90))
+	testutils.AssertEqual(t, nil, err)
+	testutils.AssertEqual(t, 0, pruned)
+}
@@ -14,9 +14,25 @@ var (
 	Auth = authentication.Manage(DB)
 
 	// Application collections
-	Repositories = database.Manage(DB, new(Repository))
-	Activities   = database.Manage(DB, new(Activity))
-	Settings     = database.Manage(DB, new(Setting))
+	Repositories  = database.Manage(DB, new(Repository))
+	Activities    = database.Manage(DB, new(Activity))
+	Settings      = database.Manage(DB, new(Setting))
+	AccessRecords = database.Manage(DB, new(AccessRecord))
+	MetricMinutes = database.Manage(DB, new(MetricMinute))
+	MetricHours   = database.Manage(DB, new(MetricHour))
+	ScaffoldFiles = database.Manage(DB, new(ScaffoldFile))
+	SafetyPoints  = database.Manage(DB, new(SafetyPoint))
+
+	StorageLocations   = database.Manage(DB, new(StorageLocation))
+	WorkspaceTemplates = database.Manage(DB, new(WorkspaceTemplate))
+	SSHKeys            = database.Manage(DB, new(SSHKey))
+	GitCredentials     = database.Manage(DB, new(GitCredential))
+	Sessions           = database.Manage(DB, new(Session))
+	PortForwards       = database.Manage(DB, new(PortForward))
+	Notifications      = database.Manage(DB, new(Notification))
+	RepoTemplates      = database.Manage(DB, new(RepoTemplate))
+	Shares             = database.Manage(DB, new(Share))
+	Migrations         = database.Manage(DB, new(AppliedMigration))
 )
 
 func init() {
@@ -36,6 +52,41 @@ func createIndexes() {
 	
 	// Repository management
 	Repositories.Index("CreatedAt") // For ordering repositories
+
+	// Access auditing
+	AccessRecords.Index("Timestamp")  // For date range filters and pruning
+	AccessRecords.Index("Repository") // For per-repository filters
+
+	// Metrics history
+	MetricMinutes.Index("Bucket") // For time range queries and pruning
+	MetricHours.Index("Bucket")
+
+	// Safety points
+	SafetyPoints.Index("Repository") // For per-repository listing and pruning
+
+	// Storage locations
+	Repositories.Index("StorageLocationID") // For blocking deletion of locations in use
+
+	// Named SSH keys
+	SSHKeys.Index("Name") // For lookups by key name
+
+	// HTTPS access tokens
+	GitCredentials.Index("Host") // For lookups by host
+
+	// Signed-in sessions
+	Sessions.Index("TokenHash") // For the check on every authenticated request
+
+	// Port forwards
+	PortForwards.Index("PathPrefix") // For the lookup on every proxied request
+
+	// In-app notifications
+	Notifications.Index("CreatedAt") // For listing the newest and pruning the oldest
+
+	// Starter repository templates
+	RepoTemplates.Index("Name") // For lookups by template name
+
+	// Share links
+	Shares.Index("TokenHash") // For the lookup on every shared view
 }
 
 // InitializeForTesting reinitializes the global repositories with a test database
@@ -45,4 +96,19 @@ func InitializeForTesting(testDB *database.DynamicDB) {
 	Repositories = database.Manage(testDB, new(Repository))
 	Activities = database.Manage(testDB, new(Activity))
 	Settings = database.Manage(testDB, new(Setting))
-}
\ No newline at end of file
+	AccessRecords = database.Manage(testDB, new(AccessRecord))
+	MetricMinutes = database.Manage(testDB, new(MetricMinute))
+	MetricHours = database.Manage(testDB, new(MetricHour))
+	ScaffoldFiles = database.Manage(testDB, new(ScaffoldFile))
+	SafetyPoints = database.Manage(testDB, new(SafetyPoint))
+	StorageLocations = database.Manage(testDB, new(StorageLocation))
+	WorkspaceTemplates = database.Manage(testDB, new(WorkspaceTemplate))
+	SSHKeys = database.Manage(testDB, new(SSHKey))
+	GitCredentials = database.Manage(testDB, new(GitCredential))
+	Sessions = database.Manage(testDB, new(Session))
+	PortForwards = database.Manage(testDB, new(PortForward))
+	Notifications = database.Manage(testDB, new(Notification))
+	RepoTemplates = database.Manage(testDB, new(RepoTemplate))
+	Shares = database.Manage(testDB, new(Share))
+	Migrations = database.Manage(testDB, new(AppliedMigration))
+}
@@ -0,0 +1,22 @@
+package models
+
+import (
+	"github.com/The-Skyscape/devtools/pkg/application"
+)
+
+// GitCredential is a personal access token for cloning and pulling
+// private HTTPS repositories from a host. The workbench writes the saved
+// credentials to ~/.git-credentials in the coder container, where git's
+// store helper picks them up.
+type GitCredential struct {
+	application.Model
+	Host     string // Unique, e.g. "gitlab.example.com"
+	Username string // Username sent with the token; providers often accept any
+	Token    string // Encrypted with a key derived from the server secret
+}
+
+// Table returns the database table name for the GitCredential model.
+// Required by the devtools ORM for database operations.
+func (*GitCredential) Table() string {
+	return "git_credentials"
+}
@@ -0,0 +1,51 @@
+package models
+
+import (
+	"time"
+
+	"github.com/The-Skyscape/devtools/pkg/application"
+)
+
+// MetricMinute is a one-minute downsample of system metrics.
+// Retained for the metrics_retention setting, 7 days by default, to back
+// dashboard charts across restarts.
+type MetricMinute struct {
+	application.Model
+	Bucket      time.Time // Start of the minute (UTC)
+	Samples     int       // Raw samples the downsample was derived from
+	CPUAvg      float64
+	CPUMax      float64
+	MemoryAvg   float64
+	MemoryMax   float64
+	LoadAvg     float64
+	LoadMax     float64
+	DiskPercent float64 // Data directory usage at the end of the bucket
+	DataUsed    uint64  // Data directory bytes used at the end of the bucket
+}
+
+// Table returns the database table name for the MetricMinute model.
+// Required by the devtools ORM for database operations.
+func (*MetricMinute) Table() string {
+	return "metric_minutes"
+}
+
+// MetricHour is an hourly rollup of MetricMinute rows, retained for 30 days.
+type MetricHour struct {
+	application.Model
+	Bucket      time.Time // Start of the hour (UTC)
+	Samples     int       // Raw samples across all rolled-up minutes
+	CPUAvg      float64
+	CPUMax      float64
+	MemoryAvg   float64
+	MemoryMax   float64
+	LoadAvg     float64
+	LoadMax     float64
+	DiskPercent float64
+	DataUsed    uint64
+}
+
+// Table returns the database table name for the MetricHour model.
+// Required by the devtools ORM for database operations.
+func (*MetricHour) Table() string {
+	return "metric_hours"
+}
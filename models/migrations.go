@@ -0,0 +1,278 @@
+package models
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/The-Skyscape/devtools/pkg/application"
+	"github.com/The-Skyscape/devtools/pkg/database"
+)
+
+// Migration is a change to the database that the ORM can't make by
+// itself, such as giving a new column a default in existing rows or
+// copying data out of a renamed field. Each is applied once, at startup,
+// in the order of migrations.
+type Migration struct {
+	ID          string // Unique and never changed, e.g. "0001_repository_defaults"
+	Description string
+	Up          func() error
+}
+
+// AppliedMigration records a migration applied to this database.
+type AppliedMigration struct {
+	application.Model
+	MigrationID string
+	Description string
+	AppliedAt   time.Time
+	DurationMS  int64 // How long Up took
+}
+
+// Table returns the database table name for the AppliedMigration model.
+// Required by the devtools ORM for database operations.
+func (*AppliedMigration) Table() string {
+	return "migrations"
+}
+
+// MigrationStatus is a migration and whether it was applied, as GET
+// /api/system/migrations lists it.
+type MigrationStatus struct {
+	ID          string    `json:"id"`
+	Description string    `json:"description"`
+	Applied     bool      `json:"applied"`
+	AppliedAt   time.Time `json:"applied_at,omitzero"`
+	DurationMS  int64     `json:"duration_ms,omitempty"`
+}
+
+// migrations are the database's migrations, oldest first. Add new ones at
+// the end; never reorder, rename, or remove one that has shipped.
+var migrations = []Migration{
+	{
+		ID:          "0001_repository_defaults",
+		Description: "Give repository columns added since the first release their defaults in existing rows",
+		Up:          migrateRepositoryDefaults,
+	},
+	{
+		ID:          "0002_activity_timestamps",
+		Description: "Set the Timestamp of activities recorded without one to when they were created",
+		Up:          migrateActivityTimestamps,
+	},
+	{
+		ID:          "0003_settings_unique_key",
+		Description: "Keep the newest row of each setting saved twice and make setting keys unique",
+		Up:          migrateSettingsUniqueKey,
+	},
+}
+
+// tableColumns returns the names of a table's columns, none when the
+// table doesn't exist yet.
+func tableColumns(table string) ([]string, error) {
+	var columns []string
+	err := DB.Query("SELECT name FROM pragma_table_info(?)", table).All(func(scan database.ScanFunc) error {
+		var name string
+		if err := scan(&name); err != nil {
+			return err
+		}
+		columns = append(columns, name)
+		return nil
+	})
+	return columns, err
+}
+
+func execSQL(query string, args ...any) error {
+	return DB.Query(query, args...).Exec()
+}
+
+func appliedMigrations() ([]*AppliedMigration, error) {
+	return Migrations.Search("ORDER BY AppliedAt")
+}
+
+func recordMigration(applied *AppliedMigration) error {
+	_, err := Migrations.Insert(applied)
+	return err
+}
+
+// Migrate applies the migrations this database hasn't had yet, in order,
+// recording each as it succeeds. It stops at the first failure, leaving
+// that migration and the ones after it to be tried on the next start.
+func Migrate() error {
+	return runMigrations(migrations)
+}
+
+func runMigrations(list []Migration) error {
+	done, err := appliedMigrations()
+	if err != nil {
+		return fmt.Errorf("failed to read the applied migrations: %w", err)
+	}
+	applied := map[string]bool{}
+	for _, migration := range done {
+		applied[migration.MigrationID] = true
+	}
+
+	for _, migration := range list {
+		if applied[migration.ID] {
+			continue
+		}
+		start := time.Now()
+		if err := migration.Up(); err != nil {
+			return fmt.Errorf("database migration %s (%s) failed: %w", migration.ID, migration.Description, err)
+		}
+		took := time.Since(start)
+		err := recordMigration(&AppliedMigration{
+			MigrationID: migration.ID,
+			Description: migration.Description,
+			AppliedAt:   time.Now(),
+			DurationMS:  took.Milliseconds(),
+		})
+		if err != nil {
+			return fmt.Errorf("database migration %s was applied but couldn't be recorded: %w", migration.ID, err)
+		}
+		slog.Info("applied database migration", "id", migration.ID, "duration", took)
+	}
+	return nil
+}
+
+// ListMigrations returns every migration in order with whether and when
+// it was applied. Migrations recorded by a newer version of the workbench
+// are listed after them.
+func ListMigrations() ([]MigrationStatus, error) {
+	done, err := appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+	byID := map[string]*AppliedMigration{}
+	for _, applied := range done {
+		byID[applied.MigrationID] = applied
+	}
+
+	statuses := make([]MigrationStatus, 0, len(migrations))
+	for _, migration := range migrations {
+		status := MigrationStatus{ID: migration.ID, Description: migration.Description}
+		if applied, ok := byID[migration.ID]; ok {
+			status.Applied, status.AppliedAt, status.DurationMS = true, applied.AppliedAt, applied.DurationMS
+			delete(byID, migration.ID)
+		}
+		statuses = append(statuses, status)
+	}
+	for _, applied := range done {
+		if _, unknown := byID[applied.MigrationID]; unknown {
+			statuses = append(statuses, MigrationStatus{ID: applied.MigrationID, Description: applied.Description,
+				Applied: true, AppliedAt: applied.AppliedAt, DurationMS: applied.DurationMS})
+		}
+	}
+	return statuses, nil
+}
+
+// column is a column a migration makes sure a table has.
+type column struct {
+	Name    string
+	Type    string // SQLite type, e.g. TEXT or INTEGER
+	Default any    // Also set in rows where the column is NULL
+}
+
+// addColumns adds the columns table is missing, with their defaults, and
+// sets the defaults in rows where the ORM added a column as NULL. A
+// table that doesn't exist yet is left for the ORM to create.
+func addColumns(table string, columns []column) error {
+	existing, err := tableColumns(table)
+	if err != nil {
+		return fmt.Errorf("failed to read the columns of %s: %w", table, err)
+	}
+	if len(existing) == 0 {
+		return nil
+	}
+	for _, col := range columns {
+		if !slices.ContainsFunc(existing, func(name string) bool { return strings.EqualFold(name, col.Name) }) {
+			add := fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s NOT NULL DEFAULT %s", table, col.Name, col.Type, sqlLiteral(col.Default))
+			if err := execSQL(add); err != nil {
+				return fmt.Errorf("failed to add %s.%s: %w", table, col.Name, err)
+			}
+			continue
+		}
+		if err := execSQL(fmt.Sprintf("UPDATE %s SET %s = ? WHERE %s IS NULL", table, col.Name, col.Name), col.Default); err != nil {
+			return fmt.Errorf("failed to set the default of %s.%s: %w", table, col.Name, err)
+		}
+	}
+	return nil
+}
+
+// sqliteTimeFormat is how the SQLite driver stores a time.Time.
+const sqliteTimeFormat = "2006-01-02 15:04:05.999999999-07:00"
+
+// sqlLiteral formats a column default, which can't be a bound parameter.
+func sqlLiteral(value any) string {
+	switch v := value.(type) {
+	case time.Time:
+		return "'" + v.Format(sqliteTimeFormat) + "'"
+	case string:
+		return "'" + strings.ReplaceAll(v, "'", "''") + "'"
+	case bool:
+		if v {
+			return "1"
+		}
+		return "0"
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// migrateRepositoryDefaults gives the repository columns added over time,
+// such as the clone status, default branch, and lock, their defaults in
+// rows saved before they existed, which the ORM would otherwise leave
+// NULL.
+func migrateRepositoryDefaults() error {
+	return addColumns((*Repository)(nil).Table(), []column{
+		{"Missing", "INTEGER", false},
+		{"WebhookSecret", "TEXT", ""},
+		{"CloneStatus", "TEXT", CloneReady},
+		{"CloneError", "TEXT", ""},
+		{"GroupName", "TEXT", ""},
+		{"Pinned", "INTEGER", false},
+		{"Locked", "INTEGER", false},
+		{"LFS", "INTEGER", false},
+		{"LFSStubs", "INTEGER", false},
+		{"DefaultBranch", "TEXT", ""},
+		{"Language", "TEXT", ""},
+		{"Stars", "INTEGER", 0},
+		{"LastFetchedMeta", "DATETIME", time.Time{}}, // Never read, so the next visit reads it
+	})
+}
+
+// migrateActivityTimestamps sets the Timestamp of activities recorded
+// without one, which list and export with a zero time, to their
+// CreatedAt. Zero times are stored as text starting 0001-01-01.
+func migrateActivityTimestamps() error {
+	table := (*Activity)(nil).Table()
+	existing, err := tableColumns(table)
+	if err != nil {
+		return fmt.Errorf("failed to read the columns of %s: %w", table, err)
+	}
+	if !slices.Contains(existing, "Timestamp") {
+		return nil // A fresh database, with no activities yet
+	}
+	return execSQL(fmt.Sprintf("UPDATE %s SET Timestamp = CreatedAt WHERE Timestamp IS NULL OR Timestamp = '' OR Timestamp LIKE '0001-01-01%%'", table))
+}
+
+// migrateSettingsUniqueKey removes the duplicate setting rows concurrent
+// saves could leave before SetSetting was serialized, then adds a unique
+// index on Key so no save can add one again.
+func migrateSettingsUniqueKey() error {
+	table := (*Setting)(nil).Table()
+	existing, err := tableColumns(table)
+	if err != nil {
+		return fmt.Errorf("failed to read the columns of %s: %w", table, err)
+	}
+	if len(existing) == 0 {
+		return nil // A fresh database, with no settings yet
+	}
+	removed, err := DeduplicateSettings()
+	if err != nil {
+		return fmt.Errorf("failed to remove duplicate settings: %w", err)
+	}
+	if removed > 0 {
+		slog.Info("removed duplicate settings", "count", removed)
+	}
+	return execSQL(fmt.Sprintf("CREATE UNIQUE INDEX IF NOT EXISTS %s_key_unique ON %s(Key)", table, table))
+}
@@ -0,0 +1,179 @@
+package models
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/The-Skyscape/devtools/pkg/testutils"
+)
+
+func appliedIDs(t *testing.T) []string {
+	t.Helper()
+	applied, err := appliedMigrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, migration := range applied {
+		ids = append(ids, migration.MigrationID)
+	}
+	return ids
+}
+
+func migrationIDs(list []Migration) []string {
+	var ids []string
+	for _, migration := range list {
+		ids = append(ids, migration.ID)
+	}
+	return ids
+}
+
+// mustExec runs statements against the test database.
+func mustExec(t *testing.T, statements ...string) {
+	t.Helper()
+	for _, statement := range statements {
+		if err := execSQL(statement); err != nil {
+			t.Fatalf("%s: %v", statement, err)
+		}
+	}
+}
+
+func TestMigrateCurrentSchema(t *testing.T) {
+	testDatabase(t)
+
+	testutils.AssertEqual(t, nil, Migrate())
+	testutils.AssertEqual(t, migrationIDs(migrations), appliedIDs(t))
+
+	// Applied once
+	testutils.AssertEqual(t, nil, Migrate())
+	testutils.AssertEqual(t, len(migrations), len(appliedIDs(t)))
+}
+
+func TestMigrateRepositoryDefaults(t *testing.T) {
+	testDatabase(t)
+	// A repositories table from before Missing and everything after it,
+	// with one row, and Locked added by the ORM as NULL
+	mustExec(t,
+		"DROP TABLE repositories",
+		"CREATE TABLE repositories (ID TEXT PRIMARY KEY, CreatedAt DATETIME, UpdatedAt DATETIME, Name TEXT, URL TEXT, LocalPath TEXT, "+
+			"Description TEXT, IsPrivate INTEGER, Host TEXT, StorageLocationID TEXT, Locked INTEGER)",
+		"INSERT INTO repositories (ID, Name, URL, LocalPath) VALUES ('repo-1', 'api', 'https://github.com/acme/api', '/home/coder/repos/api')",
+	)
+
+	testutils.AssertEqual(t, nil, migrateRepositoryDefaults())
+	columns, err := tableColumns("repositories")
+	testutils.AssertEqual(t, nil, err)
+	for _, name := range []string{"Missing", "CloneStatus", "Locked", "Stars", "LastFetchedMeta"} {
+		testutils.AssertEqual(t, true, slices.Contains(columns, name))
+	}
+
+	repo, err := Repositories.Get("repo-1")
+	testutils.AssertEqual(t, nil, err)
+	testutils.AssertEqual(t, CloneReady, repo.CloneStatus)
+	testutils.AssertEqual(t, false, repo.Locked)
+	testutils.AssertEqual(t, false, repo.Missing)
+	testutils.AssertEqual(t, true, repo.LastFetchedMeta.IsZero())
+
+	// Running it again changes nothing
+	testutils.AssertEqual(t, nil, migrateRepositoryDefaults())
+}
+
+func TestMigrateActivityTimestamps(t *testing.T) {
+	testDatabase(t)
+	at := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	insertActivityAt(t, "repo_pull", "api", at)
+	mustExec(t, "UPDATE activities SET Timestamp = '"+time.Time{}.Format(sqliteTimeFormat)+"'") // Recorded without one
+	insertActivityAt(t, "repo_push", "api", at.Add(time.Hour))
+
+	testutils.AssertEqual(t, nil, migrateActivityTimestamps())
+	activities, err := Activities.Search("ORDER BY Type ASC")
+	testutils.AssertEqual(t, nil, err)
+	testutils.AssertEqual(t, 2, len(activities))
+	testutils.AssertEqual(t, true, activities[0].Timestamp.Equal(at))
+	testutils.AssertEqual(t, true, activities[1].Timestamp.Equal(at.Add(time.Hour)))
+}
+
+func TestMigrateSettingsUniqueKey(t *testing.T) {
+	testDatabase(t)
+	for _, value := range []string{"old", "new"} {
+		if _, err := Settings.Insert(&Setting{Key: "theme", Value: value}); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond) // Distinct CreatedAt
+	}
+
+	testutils.AssertEqual(t, nil, migrateSettingsUniqueKey())
+	settings, err := Settings.Search("WHERE Key = ?", "theme")
+	testutils.AssertEqual(t, nil, err)
+	testutils.AssertEqual(t, 1, len(settings))
+	testutils.AssertEqual(t, "new", settings[0].Value)
+
+	_, err = Settings.Insert(&Setting{Key: "theme", Value: "again"})
+	testutils.AssertEqual(t, true, err != nil)
+}
+
+func TestMigrateFailureStops(t *testing.T) {
+	testDatabase(t)
+	fail := true
+	var ran []string
+	step := func(id string) func() error {
+		return func() error {
+			ran = append(ran, id)
+			if id == "0002_flaky" && fail {
+				return errors.New("database is locked")
+			}
+			return nil
+		}
+	}
+	list := []Migration{
+		{ID: "0001_first", Description: "First", Up: step("0001_first")},
+		{ID: "0002_flaky", Description: "Flaky", Up: step("0002_flaky")},
+		{ID: "0003_last", Description: "Last", Up: step("0003_last")},
+	}
+
+	err := runMigrations(list)
+	testutils.AssertEqual(t, true, err != nil && strings.Contains(err.Error(), "0002_flaky"))
+	testutils.AssertEqual(t, true, strings.Contains(err.Error(), "database is locked"))
+	testutils.AssertEqual(t, []string{"0001_first"}, appliedIDs(t))
+	testutils.AssertEqual(t, []string{"0001_first", "0002_flaky"}, ran) // Later migrations don't run
+
+	// Tried again on the next start, without the one that succeeded
+	fail, ran = false, nil
+	testutils.AssertEqual(t, nil, runMigrations(list))
+	testutils.AssertEqual(t, []string{"0002_flaky", "0003_last"}, ran)
+	testutils.AssertEqual(t, migrationIDs(list), appliedIDs(t))
+}
+
+func TestListMigrations(t *testing.T) {
+	testDatabase(t)
+	for _, id := range []string{migrations[0].ID, "9999_from_a_newer_version"} {
+		if err := recordMigration(&AppliedMigration{MigrationID: id, AppliedAt: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	statuses, err := ListMigrations()
+	testutils.AssertEqual(t, nil, err)
+	testutils.AssertEqual(t, len(migrations)+1, len(statuses))
+	testutils.AssertEqual(t, true, statuses[0].Applied)
+	testutils.AssertEqual(t, false, statuses[1].Applied)
+	testutils.AssertEqual(t, "9999_from_a_newer_version", statuses[len(statuses)-1].ID)
+}
+
+func TestMigrationIDsAreUnique(t *testing.T) {
+	seen := map[string]bool{}
+	for _, migration := range migrations {
+		testutils.AssertEqual(t, false, seen[migration.ID])
+		seen[migration.ID] = true
+	}
+}
+
+func TestSQLLiteral(t *testing.T) {
+	testutils.AssertEqual(t, "'it''s'", sqlLiteral("it's"))
+	testutils.AssertEqual(t, "1", sqlLiteral(true))
+	testutils.AssertEqual(t, "0", sqlLiteral(0))
+	testutils.AssertEqual(t, "'0001-01-01 00:00:00+00:00'", sqlLiteral(time.Time{}))
+}
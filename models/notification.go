@@ -0,0 +1,26 @@
+package models
+
+import (
+	"time"
+
+	"github.com/The-Skyscape/devtools/pkg/application"
+)
+
+// Notification is an in-app notification shown under the bell in the
+// header until it is marked read. The same events can also go out by
+// webhook and email; only the in-app channel is stored.
+type Notification struct {
+	application.Model
+	Type        string // Activity type that raised it, e.g. system_alert
+	Repository  string // Repository name if applicable
+	Description string
+	Level       string // critical, warning, or info
+	Timestamp   time.Time
+	Read        bool
+}
+
+// Table returns the database table name for the Notification model.
+// Required by the devtools ORM for database operations.
+func (*Notification) Table() string {
+	return "notifications"
+}
@@ -0,0 +1,19 @@
+package models
+
+import "github.com/The-Skyscape/devtools/pkg/application"
+
+// PortForward exposes a port inside the coder container, such as a dev
+// server started from the VS Code terminal, at /apps/{PathPrefix}/.
+type PortForward struct {
+	application.Model
+	Name       string
+	TargetPort int    // Port listening inside the coder container
+	PathPrefix string // URL segment after /apps/, e.g. "web"
+	Enabled    bool   // Paused forwards answer 404
+}
+
+// Table returns the database table name for the PortForward model.
+// Required by the devtools ORM for database operations.
+func (*PortForward) Table() string {
+	return "port_forwards"
+}
@@ -0,0 +1,20 @@
+package models
+
+import "github.com/The-Skyscape/devtools/pkg/application"
+
+// RepoTemplate is a starter repository that new projects are created from,
+// such as a team's service skeleton.
+type RepoTemplate struct {
+	application.Model
+	Name          string
+	SourceURL     string
+	DefaultBranch string // Branch to copy; empty uses the remote's default
+	Description   string
+	Variables     string // JSON []internal.TemplateVariable from the template.json last read
+}
+
+// Table returns the database table name for the RepoTemplate model.
+// Required by the devtools ORM for database operations.
+func (*RepoTemplate) Table() string {
+	return "repo_templates"
+}
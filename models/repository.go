@@ -5,6 +5,7 @@ package models
 import (
 	"fmt"
 	"strings"
+	"time"
 	"workbench/services"
 
 	"github.com/The-Skyscape/devtools/pkg/application"
@@ -12,7 +13,8 @@ import (
 
 // Repository represents a cloned Git repository in the workbench.
 // Tracks both the remote URL and local filesystem path within the container.
-// The LocalPath is typically /home/coder/repos/{name} in the VS Code container.
+// The LocalPath is typically /home/coder/repos/{name} in the VS Code container,
+// or {name} under the ContainerPath of the repository's storage location.
 type Repository struct {
 	application.Model
 	Name        string
@@ -20,20 +22,74 @@ type Repository struct {
 	LocalPath   string
 	Description string
 	IsPrivate   bool
+	Host        string // Git host derived from URL, e.g. "github.com"; "other" if unknown
+
+	StorageLocationID string // Empty for the standard repos directory
+	Missing           bool   // Directory found missing and not yet re-cloned
+	WebhookSecret     string // Shared secret push webhooks are verified with; empty when none
+
+	CloneStatus string // CloneCloning or CloneFailed until the first clone succeeds; empty once ready
+	CloneError  string // Why the clone failed, while CloneStatus is CloneFailed
+
+	GroupName string // Dashboard group the repository is listed under; empty when ungrouped
+	Pinned    bool   // Listed first on the dashboard, above the groups
+	Locked    bool   // Refuses deletion, renaming, and destructive git actions until unlocked
+
+	LFS      bool // Tracks files with Git LFS, per its .gitattributes
+	LFSStubs bool // Some LFS files are still pointer stubs, until an LFS pull downloads them
+
+	DefaultBranch   string    // Default branch on the provider, e.g. "main"
+	Language        string    // Primary language detected by the provider
+	Stars           int       // Star count on the provider
+	LastFetchedMeta time.Time // When the provider metadata was last read; zero if never
 }
 
+// Clone states of a repository. Repositories cloned before clones ran in
+// the background have an empty status, the same as CloneReady.
+const (
+	CloneCloning = "cloning"
+	CloneReady   = ""
+	CloneFailed  = "failed"
+)
+
 // Table returns the database table name for the Repository model.
 // Required by the devtools ORM for database operations.
 func (*Repository) Table() string {
 	return "repositories"
 }
 
-// GetRepositorySize calculates the total disk usage of a repository.
+// IsLocal returns true for repositories created in the workbench that
+// have no remote yet, so there is nothing to pull from.
+func (repo *Repository) IsLocal() bool {
+	return repo.URL == ""
+}
+
+// IsCloning reports whether the repository's first clone is still running.
+func (repo *Repository) IsCloning() bool {
+	return repo.CloneStatus == CloneCloning
+}
+
+// IsCloneFailed reports whether the repository's first clone failed,
+// leaving a record with no working copy.
+func (repo *Repository) IsCloneFailed() bool {
+	return repo.CloneStatus == CloneFailed
+}
+
+// Size calculates the total disk usage of a repository.
 // Uses the 'du' command in the container to get accurate size including
-// all files, git history, and working tree.
+// all files, git history, and working tree. Fails if the directory is missing.
+// LFS objects are included even when lfs.storage keeps them outside it.
 func (repo *Repository) Size() (int64, error) {
-	// Get size using du command in coder container
-	cmd := fmt.Sprintf("du -sb %s | cut -f1", repo.LocalPath)
+	// Get size using du command in coder container; no pipe, so a missing
+	// directory fails instead of reporting zero
+	dir := services.ShellQuote(repo.LocalPath)
+	cmd := fmt.Sprintf("du -sb %s", dir)
+	if repo.LFS {
+		// du counts a directory given twice once, so the total is right
+		// wherever the objects are; pipefail keeps a missing directory failing
+		cmd = fmt.Sprintf(`set -o pipefail; lfs=$(git -C %s lfs env 2>/dev/null | sed -n 's/^LocalMediaDir=//p'); du -sbc %s ${lfs:+"$lfs"} | tail -n 1`,
+			dir, dir)
+	}
 	output, err := services.CoderExec(cmd)
 	if err != nil {
 		return 0, err
@@ -0,0 +1,26 @@
+package models
+
+import (
+	"time"
+
+	"github.com/The-Skyscape/devtools/pkg/application"
+)
+
+// SafetyPoint records uncommitted work captured before a potentially
+// destructive git operation started by the workbench, so it can be
+// restored if the operation goes wrong.
+type SafetyPoint struct {
+	application.Model
+	Repository string    // Repository name
+	Operation  string    // Operation that was about to run, e.g. "pull"
+	Kind       string    // "stash" or "diff"
+	Ref        string    // Stash commit hash, or diff file path relative to the repository
+	Timestamp  time.Time // When the work was captured (UTC)
+	ExpiresAt  time.Time // Set when the operation succeeded; zero keeps the point until pruned
+}
+
+// Table returns the database table name for the SafetyPoint model.
+// Required by the devtools ORM for database operations.
+func (*SafetyPoint) Table() string {
+	return "safety_points"
+}
@@ -0,0 +1,21 @@
+package models
+
+import (
+	"github.com/The-Skyscape/devtools/pkg/application"
+)
+
+// ScaffoldFile is a user-defined file added to every new project created
+// from a template, such as a LICENSE or .editorconfig. User files replace
+// built-in template files with the same path.
+type ScaffoldFile struct {
+	application.Model
+	Path     string // Repository-relative path, e.g. "LICENSE"
+	Content  string // File content
+	Template string // Template ID this file applies to, empty for all templates
+}
+
+// Table returns the database table name for the ScaffoldFile model.
+// Required by the devtools ORM for database operations.
+func (*ScaffoldFile) Table() string {
+	return "scaffold_files"
+}
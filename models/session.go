@@ -0,0 +1,26 @@
+package models
+
+import (
+	"time"
+
+	"github.com/The-Skyscape/devtools/pkg/application"
+)
+
+// Session is a signed-in browser. It is identified by a hash of its auth
+// cookie, so the database never holds a usable token. Revoked sessions are
+// kept until they would have expired, so their cookie stays rejected.
+type Session struct {
+	application.Model
+	TokenHash string    // Hex SHA-256 of the auth cookie's value
+	ClientIP  string    // Address of the most recent request
+	UserAgent string    // Truncated User-Agent of the most recent request
+	LastSeen  time.Time // Most recent authenticated request (UTC)
+	ExpiresAt time.Time // When the cookie expires; the row is pruned after
+	Revoked   bool      // Rejected on its next request
+}
+
+// Table returns the database table name for the Session model.
+// Required by the devtools ORM for database operations.
+func (*Session) Table() string {
+	return "sessions"
+}
@@ -1,6 +1,14 @@
 package models
 
 import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
 	"github.com/The-Skyscape/devtools/pkg/application"
 )
 
@@ -17,32 +25,329 @@ func (*Setting) Table() string {
 	return "settings"
 }
 
+// Reading and saving setting rows. Replaced in tests.
+var (
+	findSetting = func(key string) (*Setting, error) {
+		return Settings.Find("WHERE Key = ? ORDER BY CreatedAt DESC LIMIT 1", key)
+	}
+	insertSetting = func(setting *Setting) (*Setting, error) { return Settings.Insert(setting) }
+	updateSetting = func(setting *Setting) error { return Settings.Update(setting) }
+)
+
+// settingsMu serializes SetSetting, so two saves of a new key can't both
+// create a row for it.
+var settingsMu sync.Mutex
+
 // GetSetting retrieves a setting by key
 func GetSetting(key string) (string, error) {
-	setting, err := Settings.Find("WHERE Key = ?", key)
+	setting, err := findSetting(key)
 	if err != nil {
 		return "", err
 	}
 	return setting.Value, nil
 }
 
-// SetSetting creates or updates a setting
+// GetSettingOrDefault returns a setting's stored value, or fallback when
+// it is absent or empty. Registered settings should use SettingOrDefault,
+// which also validates the value.
+func GetSettingOrDefault(key, fallback string) string {
+	if value, err := settingValue(key); err == nil && value != "" {
+		return value
+	}
+	return fallback
+}
+
+// SetSetting creates or updates a setting. Registered settings are
+// validated and stored in their canonical form; unregistered keys are
+// saved as given and logged so they can be added to the registry.
 func SetSetting(key, value, settingType string) (*Setting, error) {
-	setting, err := Settings.Find("WHERE Key = ? LIMIT 1", key)
+	value, err := NormalizeSetting(key, value)
 	if err != nil {
 		return nil, err
 	}
+	if LookupSetting(key) == nil {
+		slog.Warn("saving unregistered setting", "key", key)
+	}
 
-	if setting != nil {
-		// Update existing
+	settingsMu.Lock()
+	defer settingsMu.Unlock()
+
+	// Find returns an error when no row matches, so only an existing
+	// setting is updated; anything else falls through to create it
+	setting, err := findSetting(key)
+	if err == nil && setting != nil {
 		setting.Value = value
-		return setting, Settings.Update(setting)
+		return setting, updateSetting(setting)
 	}
 
 	// Create new
-	return Settings.Insert(&Setting{
+	created, err := insertSetting(&Setting{
 		Key:   key,
 		Value: value,
 		Type:  settingType,
 	})
+	if err != nil {
+		// Saved meanwhile, e.g. by another process: update that row instead
+		if setting, findErr := findSetting(key); findErr == nil && setting != nil {
+			setting.Value = value
+			return setting, updateSetting(setting)
+		}
+		return nil, err
+	}
+	return created, nil
+}
+
+// DeduplicateSettings deletes all but the newest row of any key saved more
+// than once, which concurrent saves could do before SetSetting was
+// serialized. Run by the 0003_settings_unique_key migration before it
+// makes keys unique. Returns the number of rows deleted.
+func DeduplicateSettings() (int, error) {
+	settings, err := Settings.Search("ORDER BY CreatedAt DESC")
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, setting := range duplicateSettings(settings) {
+		if err := Settings.Delete(setting); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// duplicateSettings returns every row after the first of each key, given
+// rows newest first.
+func duplicateSettings(settings []*Setting) []*Setting {
+	seen := map[string]bool{}
+	var duplicates []*Setting
+	for _, setting := range settings {
+		if seen[setting.Key] {
+			duplicates = append(duplicates, setting)
+		}
+		seen[setting.Key] = true
+	}
+	return duplicates
+}
+
+// Setting value types in the registry
+const (
+	SettingBool     = "bool"
+	SettingInt      = "int"
+	SettingEnum     = "enum"
+	SettingString   = "string"
+	SettingDuration = "duration"
+)
+
+// SettingDef describes a known setting: how its value is validated, what
+// it defaults to, and how the settings page presents it.
+type SettingDef struct {
+	Key         string // Exact key, or a prefix ending in * for per-repository and per-host keys
+	Type        string
+	Default     string // Used when the setting is absent, empty, or invalid
+	Category    string
+	Description string
+
+	Options []string           // Allowed values of an enum
+	Min     int                // Bounds of an int or duration, in Units, applied when Max > Min
+	Max     int                //
+	Unit    time.Duration      // What a bare number means for a duration, stored as a count of Units
+	Check   func(string) error // Extra validation of the stored form; the error text is not shown
+	Rule    string             // Shown when a value is rejected, e.g. "must be an http(s) URL"
+	Hidden  bool               // Kept by the system or secret, so left off the settings page
+}
+
+// SettingError is returned when a value breaks a registered setting's rule.
+type SettingError struct {
+	Key  string
+	Rule string
+}
+
+func (e *SettingError) Error() string {
+	return fmt.Sprintf("%s %s", e.Key, e.Rule)
+}
+
+var registry = struct {
+	sync.RWMutex
+	defs []*SettingDef
+}{}
+
+// RegisterSettings adds settings to the registry, replacing any with the
+// same key.
+func RegisterSettings(defs ...*SettingDef) {
+	registry.Lock()
+	defer registry.Unlock()
+	for _, def := range defs {
+		registry.defs = slices.DeleteFunc(registry.defs, func(d *SettingDef) bool { return d.Key == def.Key })
+		registry.defs = append(registry.defs, def)
+	}
+}
+
+// RegisteredSettings returns every registered setting in registration order.
+func RegisteredSettings() []*SettingDef {
+	registry.RLock()
+	defer registry.RUnlock()
+	return slices.Clone(registry.defs)
+}
+
+// LookupSetting returns the definition of a key, matching prefixes for
+// keys like provider_token_github, or nil when it is not registered.
+func LookupSetting(key string) *SettingDef {
+	registry.RLock()
+	defer registry.RUnlock()
+	for _, def := range registry.defs {
+		if def.Key == key {
+			return def
+		}
+	}
+	for _, def := range registry.defs {
+		if prefix, ok := strings.CutSuffix(def.Key, "*"); ok && strings.HasPrefix(key, prefix) {
+			return def
+		}
+	}
+	return nil
+}
+
+// NormalizeSetting validates a value for a key and returns it in the form
+// it is stored in: true/false for booleans and a count of Units for
+// durations. An empty value clears the setting back to its default.
+// Unregistered keys are returned unchanged.
+func NormalizeSetting(key, value string) (string, error) {
+	def := LookupSetting(key)
+	if def == nil {
+		return value, nil
+	}
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return "", nil
+	}
+	normalized, ok := def.normalize(value)
+	if !ok {
+		return "", &SettingError{Key: key, Rule: def.rule()}
+	}
+	return normalized, nil
+}
+
+func (def *SettingDef) normalize(value string) (string, bool) {
+	value, ok := def.parse(value)
+	if ok && def.Check != nil {
+		ok = def.Check(value) == nil
+	}
+	return value, ok
+}
+
+// parse checks a value against the setting's type and bounds.
+func (def *SettingDef) parse(value string) (string, bool) {
+	switch def.Type {
+	case SettingBool:
+		switch strings.ToLower(value) {
+		case "true", "1", "on", "yes":
+			return "true", true
+		case "false", "0", "off", "no":
+			return "false", true
+		}
+		return "", false
+	case SettingInt:
+		n, err := strconv.Atoi(value)
+		return strconv.Itoa(n), err == nil && def.inBounds(n)
+	case SettingDuration:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			d, err := time.ParseDuration(value)
+			if err != nil || def.Unit <= 0 || d%def.Unit != 0 {
+				return "", false
+			}
+			n = int(d / def.Unit)
+		}
+		return strconv.Itoa(n), def.inBounds(n)
+	case SettingEnum:
+		return value, slices.Contains(def.Options, value)
+	default:
+		return value, true
+	}
+}
+
+func (def *SettingDef) inBounds(n int) bool {
+	return def.Max <= def.Min || (n >= def.Min && n <= def.Max)
+}
+
+// rule returns the declared Rule, or describes the type's constraint.
+func (def *SettingDef) rule() string {
+	if def.Rule != "" {
+		return def.Rule
+	}
+	switch def.Type {
+	case SettingBool:
+		return "must be true or false"
+	case SettingEnum:
+		return "must be one of " + strings.Join(def.Options, ", ")
+	case SettingInt:
+		if def.Max > def.Min {
+			return fmt.Sprintf("must be a whole number from %d to %d", def.Min, def.Max)
+		}
+		return "must be a whole number"
+	case SettingDuration:
+		if def.Max > def.Min {
+			return fmt.Sprintf("must be from %d to %d %s", def.Min, def.Max, def.UnitName())
+		}
+		return "must be a whole number of " + def.UnitName()
+	default:
+		return "is not valid"
+	}
+}
+
+// UnitName names what a duration setting's number counts, e.g. "minutes".
+func (def *SettingDef) UnitName() string {
+	switch def.Unit {
+	case time.Second:
+		return "seconds"
+	case time.Minute:
+		return "minutes"
+	case time.Hour:
+		return "hours"
+	case 24 * time.Hour:
+		return "days"
+	}
+	return def.Unit.String()
+}
+
+// settingValue reads stored values for the typed accessors; tests replace it.
+var settingValue = GetSetting
+
+// SettingOrDefault returns a registered setting's stored value, or its
+// default when the setting is absent or no longer valid.
+func SettingOrDefault(key string) string {
+	def := LookupSetting(key)
+	if def == nil {
+		value, _ := settingValue(key)
+		return value
+	}
+	if value, err := settingValue(key); err == nil && value != "" {
+		if normalized, ok := def.normalize(strings.TrimSpace(value)); ok {
+			return normalized
+		}
+		slog.Warn("ignoring invalid value for setting", "key", key)
+	}
+	return def.Default
+}
+
+// GetBoolSetting returns a boolean setting, or its registered default.
+func GetBoolSetting(key string) bool {
+	return SettingOrDefault(key) == "true"
+}
+
+// GetIntSetting returns an int setting, or its registered default.
+func GetIntSetting(key string) int {
+	n, _ := strconv.Atoi(SettingOrDefault(key))
+	return n
+}
+
+// GetDurationSetting returns a duration setting, or its registered default.
+func GetDurationSetting(key string) time.Duration {
+	def := LookupSetting(key)
+	if def == nil {
+		return 0
+	}
+	n, _ := strconv.Atoi(SettingOrDefault(key))
+	return time.Duration(n) * def.Unit
 }
@@ -0,0 +1,236 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/The-Skyscape/devtools/pkg/testutils"
+)
+
+// fakeSettings registers test settings and serves stored values from a
+// map for the duration of a test.
+func fakeSettings(t *testing.T, stored map[string]string) {
+	lookup := settingValue
+	t.Cleanup(func() { settingValue = lookup })
+	settingValue = func(key string) (string, error) {
+		value, ok := stored[key]
+		if !ok {
+			return "", errors.New("record not found")
+		}
+		return value, nil
+	}
+
+	RegisterSettings(
+		&SettingDef{Key: "test_enabled", Type: SettingBool, Default: "true"},
+		&SettingDef{Key: "test_retries", Type: SettingInt, Default: "3", Min: 0, Max: 10},
+		&SettingDef{Key: "test_mode", Type: SettingEnum, Default: "fast", Options: []string{"fast", "safe"}},
+		&SettingDef{Key: "test_timeout", Type: SettingDuration, Unit: time.Second, Default: "30", Min: 1, Max: 3600},
+		&SettingDef{Key: "test_url", Type: SettingString, Rule: "must be an http(s) URL", Check: func(v string) error {
+			if len(v) < 7 || v[:4] != "http" {
+				return errors.New("not http")
+			}
+			return nil
+		}},
+		&SettingDef{Key: "test_token:*", Type: SettingString},
+	)
+}
+
+func TestNormalizeSettingCoercesTypes(t *testing.T) {
+	fakeSettings(t, nil)
+
+	testCases := []struct {
+		key, value, expected string
+	}{
+		{"test_enabled", "on", "true"},
+		{"test_enabled", "No", "false"},
+		{"test_enabled", "1", "true"},
+		{"test_retries", " 7 ", "7"},
+		{"test_retries", "007", "7"},
+		{"test_timeout", "90", "90"},
+		{"test_timeout", "2m", "120"},
+		{"test_timeout", "1h", "3600"},
+		{"test_mode", "safe", "safe"},
+		{"test_url", "https://example.com", "https://example.com"},
+		{"test_token:github.com", "anything", "anything"},
+		{"test_retries", "", ""}, // Cleared back to the default
+		{"unregistered_key", " as given ", " as given "},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.key+"="+tc.value, func(t *testing.T) {
+			normalized, err := NormalizeSetting(tc.key, tc.value)
+			testutils.AssertEqual(t, nil, err)
+			testutils.AssertEqual(t, tc.expected, normalized)
+		})
+	}
+}
+
+func TestNormalizeSettingRejectsInvalidValues(t *testing.T) {
+	fakeSettings(t, nil)
+
+	testCases := []struct {
+		key, value, expected string
+	}{
+		{"test_enabled", "maybe", "test_enabled must be true or false"},
+		{"test_retries", "three", "test_retries must be a whole number from 0 to 10"},
+		{"test_retries", "11", "test_retries must be a whole number from 0 to 10"},
+		{"test_mode", "FAST", "test_mode must be one of fast, safe"},
+		{"test_timeout", "1500ms", "test_timeout must be from 1 to 3600 seconds"},
+		{"test_timeout", "2h", "test_timeout must be from 1 to 3600 seconds"},
+		{"test_url", "ftp://example.com", "test_url must be an http(s) URL"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.key+"="+tc.value, func(t *testing.T) {
+			_, err := NormalizeSetting(tc.key, tc.value)
+			var invalid *SettingError
+			testutils.AssertEqual(t, true, errors.As(err, &invalid))
+			testutils.AssertEqual(t, tc.expected, err.Error())
+		})
+	}
+}
+
+func TestTypedSettingsFallBackToDefaults(t *testing.T) {
+	fakeSettings(t, map[string]string{
+		"test_mode":    "safe",
+		"test_retries": "99", // Out of bounds since it was saved
+		"test_enabled": "",
+	})
+
+	// Absent, empty, and invalid values all give the default
+	testutils.AssertEqual(t, true, GetBoolSetting("test_enabled"))
+	testutils.AssertEqual(t, 3, GetIntSetting("test_retries"))
+	testutils.AssertEqual(t, 30*time.Second, GetDurationSetting("test_timeout"))
+	testutils.AssertEqual(t, "safe", SettingOrDefault("test_mode"))
+	testutils.AssertEqual(t, "", SettingOrDefault("test_url"))
+
+	// Unregistered keys have no default
+	testutils.AssertEqual(t, 0, GetIntSetting("unregistered_key"))
+	testutils.AssertEqual(t, time.Duration(0), GetDurationSetting("unregistered_key"))
+}
+
+func TestLookupSettingPrefersExactKeys(t *testing.T) {
+	fakeSettings(t, nil)
+	RegisterSettings(&SettingDef{Key: "test_token:special", Type: SettingBool})
+
+	testutils.AssertEqual(t, SettingBool, LookupSetting("test_token:special").Type)
+	testutils.AssertEqual(t, "test_token:*", LookupSetting("test_token:gitlab.com").Key)
+	testutils.AssertEqual(t, true, LookupSetting("test_tokens") == nil)
+}
+
+// fakeSettingStore keeps setting rows in memory for the duration of a
+// test. Like a unique index on Key, it refuses a second row for a key.
+// The rows are returned by key.
+func fakeSettingStore(t *testing.T) map[string]*Setting {
+	var mu sync.Mutex
+	rows := map[string]*Setting{}
+	find, insert, update := findSetting, insertSetting, updateSetting
+	t.Cleanup(func() { findSetting, insertSetting, updateSetting = find, insert, update })
+
+	findSetting = func(key string) (*Setting, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if row, ok := rows[key]; ok {
+			copied := *row
+			return &copied, nil
+		}
+		return nil, errors.New("record not found")
+	}
+	insertSetting = func(setting *Setting) (*Setting, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if _, ok := rows[setting.Key]; ok {
+			return nil, errors.New("UNIQUE constraint failed: settings.Key")
+		}
+		copied := *setting
+		rows[setting.Key] = &copied
+		return setting, nil
+	}
+	updateSetting = func(setting *Setting) error {
+		mu.Lock()
+		defer mu.Unlock()
+		copied := *setting
+		rows[setting.Key] = &copied
+		return nil
+	}
+	return rows
+}
+
+func TestSetSettingCreatesMissingKey(t *testing.T) {
+	rows := fakeSettingStore(t)
+
+	_, err := SetSetting("git_user_email", "dev@example.com", "git_config")
+	testutils.AssertEqual(t, nil, err)
+	value, err := GetSetting("git_user_email")
+	testutils.AssertEqual(t, nil, err)
+	testutils.AssertEqual(t, "dev@example.com", value)
+	testutils.AssertEqual(t, "git_config", rows["git_user_email"].Type)
+}
+
+func TestSetSettingUpdatesExistingKey(t *testing.T) {
+	rows := fakeSettingStore(t)
+
+	SetSetting("git_user_email", "old@example.com", "git_config")
+	_, err := SetSetting("git_user_email", "new@example.com", "git_config")
+	testutils.AssertEqual(t, nil, err)
+	testutils.AssertEqual(t, 1, len(rows))
+	testutils.AssertEqual(t, "new@example.com", rows["git_user_email"].Value)
+}
+
+func TestSetSettingConcurrently(t *testing.T) {
+	rows := fakeSettingStore(t)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := SetSetting("concurrent_key", fmt.Sprint(i), "preference")
+			errs <- err
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		testutils.AssertEqual(t, nil, err)
+	}
+	testutils.AssertEqual(t, 1, len(rows))
+}
+
+func TestSetSettingRetriesInsertAsUpdate(t *testing.T) {
+	rows := fakeSettingStore(t)
+
+	// Another process saves the key between the lookup and the insert
+	insert := insertSetting
+	insertSetting = func(setting *Setting) (*Setting, error) {
+		insert(&Setting{Key: setting.Key, Value: "theirs"})
+		return insert(setting)
+	}
+
+	_, err := SetSetting("race_key", "ours", "preference")
+	testutils.AssertEqual(t, nil, err)
+	testutils.AssertEqual(t, "ours", rows["race_key"].Value)
+}
+
+func TestGetSettingOrDefault(t *testing.T) {
+	fakeSettings(t, map[string]string{"theme_name": "dark", "empty_key": ""})
+
+	testutils.AssertEqual(t, "dark", GetSettingOrDefault("theme_name", "light"))
+	testutils.AssertEqual(t, "light", GetSettingOrDefault("empty_key", "light"))
+	testutils.AssertEqual(t, "light", GetSettingOrDefault("missing_key", "light"))
+}
+
+func TestDuplicateSettings(t *testing.T) {
+	newest := &Setting{Key: "locale", Value: "de"}
+	older := &Setting{Key: "locale", Value: "en"}
+	other := &Setting{Key: "timezone", Value: "UTC"}
+
+	duplicates := duplicateSettings([]*Setting{newest, other, older})
+	testutils.AssertEqual(t, 1, len(duplicates))
+	testutils.AssertEqual(t, older, duplicates[0])
+}
@@ -0,0 +1,37 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/The-Skyscape/devtools/pkg/application"
+)
+
+// Share is a read-only link to one file, diff, or README of a repository,
+// opened at /shared/{token} without signing in. As with sessions, only a
+// hash of the token is stored. Revoked and expired shares are kept for a
+// while so the owner can see what was shared.
+type Share struct {
+	application.Model
+	TokenHash    string    // Hex SHA-256 of the token in the link
+	ResourceType string    // "file", "diff", or "readme"
+	Repository   string    // Repository name
+	Path         string    // The file or README; limits a diff to it when set
+	RefRange     string    // Commit a file or README is shown at, or the diff's commit or A..B range, as commit ids
+	ExpiresAt    time.Time // The link answers 404 from then on
+	Revoked      bool
+	Accesses     int       // Views of the link
+	LastAccess   time.Time // Zero until it's first opened
+}
+
+// Table returns the database table name for the Share model.
+// Required by the devtools ORM for database operations.
+func (*Share) Table() string {
+	return "shares"
+}
+
+// CountShareAccess records a view of a share in one statement, so views
+// opened together are each counted, whatever copy of the share they read.
+func CountShareAccess(id string, at time.Time) error {
+	return DB.Query(fmt.Sprintf("UPDATE %s SET Accesses = Accesses + 1, LastAccess = ? WHERE ID = ?", (*Share)(nil).Table()), at, id).Exec()
+}
@@ -0,0 +1,33 @@
+package models
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/The-Skyscape/devtools/pkg/testutils"
+)
+
+func TestCountShareAccess(t *testing.T) {
+	testDatabase(t)
+	share, err := Shares.Insert(&Share{TokenHash: "hash", ResourceType: "readme", Repository: "api", ExpiresAt: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Views opened together are each counted
+	var wg sync.WaitGroup
+	for range 5 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			testutils.AssertEqual(t, nil, CountShareAccess(share.ID, time.Now()))
+		}()
+	}
+	wg.Wait()
+
+	counted, err := Shares.Get(share.ID)
+	testutils.AssertEqual(t, nil, err)
+	testutils.AssertEqual(t, 5, counted.Accesses)
+	testutils.AssertEqual(t, false, counted.LastAccess.IsZero())
+}
@@ -0,0 +1,59 @@
+package models
+
+import (
+	"strings"
+
+	"github.com/The-Skyscape/devtools/pkg/application"
+)
+
+// SSHKey is a named SSH key pair in the coder container. The hosts it is
+// mapped to use it through entries the workbench writes to ~/.ssh/config;
+// other hosts keep using the default id_ed25519 or id_rsa key.
+type SSHKey struct {
+	application.Model
+	Name      string // Unique, e.g. "work"
+	PublicKey string
+	KeyPath   string // Private key in the container, e.g. ~/.ssh/workbench_work
+	Hosts     string // Comma-separated hostnames that use this key
+}
+
+// Table returns the database table name for the SSHKey model.
+// Required by the devtools ORM for database operations.
+func (*SSHKey) Table() string {
+	return "ssh_keys"
+}
+
+// HostList returns the hosts mapped to the key.
+func (k *SSHKey) HostList() []string {
+	var hosts []string
+	for _, host := range strings.Split(k.Hosts, ",") {
+		if host = strings.TrimSpace(host); host != "" {
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts
+}
+
+// KeyType returns the key's algorithm, read from its public key: "Ed25519",
+// "RSA", or "ECDSA", or "" for anything else.
+func (k *SSHKey) KeyType() string {
+	fields := strings.Fields(k.PublicKey)
+	if len(fields) == 0 {
+		return ""
+	}
+	return SSHKeyType(fields[0])
+}
+
+// SSHKeyType names the algorithm of an SSH key type such as "ssh-ed25519"
+// or "ecdsa-sha2-nistp256", as KeyType does.
+func SSHKeyType(keyType string) string {
+	switch {
+	case keyType == "ssh-ed25519":
+		return "Ed25519"
+	case keyType == "ssh-rsa":
+		return "RSA"
+	case strings.HasPrefix(keyType, "ecdsa-sha2-"):
+		return "ECDSA"
+	}
+	return ""
+}
@@ -0,0 +1,19 @@
+package models
+
+import "github.com/The-Skyscape/devtools/pkg/application"
+
+// StorageLocation is a directory inside the coder container where
+// repositories can live instead of the standard repos directory, such as
+// a second disk mounted into the container.
+type StorageLocation struct {
+	application.Model
+	Name          string
+	ContainerPath string // Parent directory for repositories, e.g. /mnt/bulk/repos
+	HostPath      string // Notes on the host mount backing it, for reference only
+}
+
+// Table returns the database table name for the StorageLocation model.
+// Required by the devtools ORM for database operations.
+func (*StorageLocation) Table() string {
+	return "storage_locations"
+}
@@ -0,0 +1,17 @@
+package models
+
+import "github.com/The-Skyscape/devtools/pkg/application"
+
+// WorkspaceTemplate is a named snapshot of the workbench configuration that
+// can be downloaded and applied to another instance.
+type WorkspaceTemplate struct {
+	application.Model
+	Name     string
+	Document string // JSON internal.WorkspaceDocument
+}
+
+// Table returns the database table name for the WorkspaceTemplate model.
+// Required by the devtools ORM for database operations.
+func (*WorkspaceTemplate) Table() string {
+	return "workspace_templates"
+}
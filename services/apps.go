@@ -0,0 +1,206 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// appPrefix names the image and container built from a repository.
+const appPrefix = "workbench-app-"
+
+// appLabel marks app containers with the repository they run.
+const appLabel = "workbench.repo"
+
+// AppMountPath is where an app container sees its repository.
+const AppMountPath = "/workspace"
+
+// AppContainer is the container running a repository's app.
+type AppContainer struct {
+	Name    string
+	State   string   // e.g. "running", "exited"; "missing" when there is none
+	Ports   []string // Published ports as "container->host", e.g. "3000/tcp->32768"
+	Running bool
+}
+
+// The coder container's configured mounts, network, ports, and command,
+// kept before init may swap Coder for the already running container.
+var (
+	coderMounts  = Coder.Mounts
+	coderNetwork = Coder.Network
+	coderPorts   = Coder.Ports
+	coderCommand = Coder.Command
+)
+
+// appNameInvalid matches what Docker image names don't allow.
+var appNameInvalid = regexp.MustCompile(`[^a-z0-9]+`)
+
+// appDocker runs a docker command, writing its combined output to out.
+// Replaced in tests.
+var appDocker = func(ctx context.Context, stdin io.Reader, out io.Writer, args ...string) error {
+	cmd := exec.CommandContext(ctx, "docker", args...)
+	cmd.Stdin = stdin
+	cmd.Stdout = out
+	cmd.Stderr = out
+	return cmd.Run()
+}
+
+// AppName returns the image and container name for a repository's app,
+// workbench-app-{repo} lowercased as Docker requires.
+func AppName(repo string) string {
+	return appPrefix + strings.Trim(appNameInvalid.ReplaceAllString(strings.ToLower(repo), "-"), "-")
+}
+
+// CoderHostPath returns the host directory behind a path in the coder
+// container, from the container's mounts. Reports false for paths that
+// aren't on a mount the workbench made.
+func CoderHostPath(containerPath string) (string, bool) {
+	containerPath = path.Clean(containerPath)
+	best, bestHost := "", ""
+	for host, target := range coderMounts {
+		target = path.Clean(target)
+		if (containerPath == target || strings.HasPrefix(containerPath, target+"/")) && len(target) > len(best) {
+			best, bestHost = target, host
+		}
+	}
+	if best == "" {
+		return "", false
+	}
+	return path.Join(bestHost, strings.TrimPrefix(containerPath, best)), true
+}
+
+// BuildApp builds a repository's app image from contextDir in the coder
+// container, with dockerfile relative to it. The build context is streamed
+// out of the container, so the workbench needs no access to the files.
+// Build output is passed to onLine a line at a time.
+func BuildApp(repo, contextDir, dockerfile string, onLine func(string) error) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	archive := CoderExecReader(ctx, "tar -C "+ShellQuote(contextDir)+" -cf - .")
+	defer archive.Close()
+	return streamDocker(ctx, archive, onLine, "build", "-t", AppName(repo), "-f", dockerfile, "-")
+}
+
+// PullApp pulls image and tags it as a repository's app image, for dev
+// containers that name an image rather than a Dockerfile.
+func PullApp(repo, image string, onLine func(string) error) error {
+	ctx := context.Background()
+	if err := streamDocker(ctx, nil, onLine, "pull", image); err != nil {
+		return err
+	}
+	return streamDocker(ctx, nil, onLine, "tag", image, AppName(repo))
+}
+
+// RunApp starts a repository's app image, replacing any container already
+// running it. The container joins the coder container's network, mounts
+// hostDir at AppMountPath, and publishes the ports its image exposes.
+func RunApp(repo, hostDir string) error {
+	name := AppName(repo)
+	appDocker(context.Background(), nil, io.Discard, "rm", "-f", name)
+
+	var output strings.Builder
+	err := appDocker(context.Background(), nil, &output, "run", "-d",
+		"--name", name,
+		"--label", appLabel+"="+repo,
+		"--network", coderNetwork,
+		"--publish-all",
+		"-v", hostDir+":"+AppMountPath,
+		name)
+	if err != nil {
+		return fmt.Errorf("failed to start %s: %s", name, lastOutputLine(output.String()))
+	}
+	return nil
+}
+
+// StopApp stops and removes a repository's app container. Its image is
+// kept, so running it again doesn't need a build.
+func StopApp(repo string) error {
+	var output strings.Builder
+	if err := appDocker(context.Background(), nil, &output, "rm", "-f", AppName(repo)); err != nil {
+		return fmt.Errorf("failed to stop %s: %s", AppName(repo), lastOutputLine(output.String()))
+	}
+	return nil
+}
+
+// AppStatus returns the state and published ports of a repository's app
+// container.
+func AppStatus(repo string) (*AppContainer, error) {
+	name := AppName(repo)
+	var output strings.Builder
+	err := appDocker(context.Background(), nil, &output, "ps", "-a",
+		"--filter", "name=^/"+name+"$",
+		"--format", "{{.State}}\t{{.Ports}}")
+	if err != nil {
+		return nil, fmt.Errorf("failed to check %s: %s", name, lastOutputLine(output.String()))
+	}
+	return parseAppStatus(name, output.String()), nil
+}
+
+// parseAppStatus parses AppStatus's "state\tports" line; no line means
+// there's no container.
+func parseAppStatus(name, output string) *AppContainer {
+	app := &AppContainer{Name: name, State: "missing"}
+	line := strings.TrimSpace(output)
+	if line == "" {
+		return app
+	}
+	state, ports, _ := strings.Cut(line, "\t")
+	app.State = state
+	app.Running = state == "running"
+	app.Ports = parseAppPorts(ports)
+	return app
+}
+
+// parseAppPorts turns docker ps ports, e.g. "0.0.0.0:32768->3000/tcp,
+// :::32768->3000/tcp", into "3000/tcp->32768" once per port. Exposed ports
+// that aren't published are left out.
+func parseAppPorts(ports string) []string {
+	seen := map[string]bool{}
+	var published []string
+	for _, entry := range strings.Split(ports, ",") {
+		host, container, ok := strings.Cut(strings.TrimSpace(entry), "->")
+		if !ok {
+			continue
+		}
+		port := container + "->" + host[strings.LastIndex(host, ":")+1:]
+		if !seen[port] {
+			seen[port] = true
+			published = append(published, port)
+		}
+	}
+	sort.Strings(published)
+	return published
+}
+
+// streamDocker runs a docker command, passing its output to onLine a line
+// at a time.
+func streamDocker(ctx context.Context, stdin io.Reader, onLine func(string) error, args ...string) error {
+	reader, writer := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		err := appDocker(ctx, stdin, writer, args...)
+		writer.Close()
+		done <- err
+	}()
+
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), DefaultMaxOutput)
+	for scanner.Scan() {
+		onLine(scanner.Text())
+	}
+	io.Copy(io.Discard, reader)
+	return <-done
+}
+
+// ShellQuote wraps a value in single quotes for safe use in a shell
+// command.
+func ShellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,36 @@
+package services
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/The-Skyscape/devtools/pkg/testutils"
+)
+
+func TestAppName(t *testing.T) {
+	testutils.AssertEqual(t, "workbench-app-api", AppName("api"))
+	testutils.AssertEqual(t, "workbench-app-my-site-v2", AppName("My_Site.v2"))
+	testutils.AssertEqual(t, "workbench-app-docs", AppName("-docs-"))
+}
+
+func TestCoderHostPath(t *testing.T) {
+	host, ok := CoderHostPath("/home/coder/repos/api")
+	testutils.AssertEqual(t, true, ok)
+	testutils.AssertEqual(t, "/mnt/data/services/workbench-coder/repos/api", host)
+
+	_, ok = CoderHostPath("/mnt/bulk/repos/api")
+	testutils.AssertEqual(t, false, ok)
+}
+
+func TestParseAppStatus(t *testing.T) {
+	app := parseAppStatus("workbench-app-api", "running\t0.0.0.0:32768->3000/tcp, :::32768->3000/tcp, 9229/tcp\n")
+	testutils.AssertEqual(t, true, app.Running)
+	testutils.AssertEqual(t, "3000/tcp->32768", strings.Join(app.Ports, ","))
+
+	app = parseAppStatus("workbench-app-api", "exited\t\n")
+	testutils.AssertEqual(t, false, app.Running)
+	testutils.AssertEqual(t, "exited", app.State)
+	testutils.AssertEqual(t, 0, len(app.Ports))
+
+	testutils.AssertEqual(t, "missing", parseAppStatus("workbench-app-api", "").State)
+}
@@ -3,11 +3,23 @@
 package services
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"net/http"
+	"net/url"
 	"os"
+	"os/exec"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/The-Skyscape/devtools/pkg/containers"
 )
@@ -20,10 +32,12 @@ import (
 //   - Uses host network for simplicity
 //   - Mounts persistent directories for code and config
 //   - Auto-restarts on failure
+//   - Created from the coder_image_tag setting's tag (DefaultCoderImageTag
+//     until SetCoderImageTag is called); UpgradeCoder moves it to another tag
 var Coder = &containers.Service{
 	Host:          containers.Local(),
 	Name:          "workbench-coder",
-	Image:         "codercom/code-server:latest",
+	Image:         CoderImageRepository + ":" + DefaultCoderImageTag,
 	Command:       "--auth none --bind-addr 0.0.0.0:8080",
 	Network:       "skyscape-internal",
 	RestartPolicy: "always",
@@ -35,97 +49,415 @@ var Coder = &containers.Service{
 	},
 }
 
-// init automatically starts the VS Code server container during package initialization.
-// Skipped during tests to avoid Docker dependencies.
-// Creates necessary directories with proper permissions and launches the container.
-// If container already exists and is running, reuses it.
-func init() {
-	// Skip initialization during tests
-	if strings.HasSuffix(os.Args[0], ".test") {
-		return
+// DefaultMaxOutput is the default limit on command output buffered in memory.
+// Output beyond the limit is dropped and replaced with a truncation marker.
+const DefaultMaxOutput = 1 << 20 // 1MB
+
+// DefaultExecTimeout bounds commands run with CoderExec. Commands that may
+// take longer, such as copies and git network operations, use
+// CoderExecCtx with a deadline of their own.
+const DefaultExecTimeout = 120 * time.Second
+
+// ExecOptions controls how a command is executed in the container.
+type ExecOptions struct {
+	MaxOutput int       // Maximum bytes of output to keep (0 uses DefaultMaxOutput)
+	Stdin     io.Reader // Optional input piped to the command
+	User      string    // Optional user to run as, e.g. "root" (defaults to the container user)
+
+	stream bool     // Output is consumed while the command runs
+	execID string   // Marks the command's processes so a cancel can kill them
+	args   []string // Program and arguments run without a shell, replacing command
+}
+
+// ExecResult is the buffered output of a command.
+type ExecResult struct {
+	Output    string // Combined stdout and stderr, possibly truncated
+	Stdout    string // Each stream on its own, truncated like Output
+	Stderr    string //
+	ExitCode  int    // -1 when the command didn't run to an exit, e.g. it was killed
+	Truncated bool   // Output exceeded MaxOutput
+	Dropped   int64  // Number of bytes dropped by truncation
+}
+
+// execIDVar is the environment variable marking every process a command
+// starts, so killInCoder finds its children too.
+const execIDVar = "WORKBENCH_EXEC_ID"
+
+// runInCoder executes a command in the container with docker exec,
+// writing its output to stdout and stderr. The docker client is killed
+// when ctx is cancelled; runCancellable kills the command itself.
+// Replaced in tests with a fake executor.
+var runInCoder = func(ctx context.Context, command string, opts ExecOptions, stdout, stderr io.Writer) error {
+	if err := checkCoderReady(); err != nil {
+		return err
+	}
+
+	args := []string{"exec"}
+	if opts.Stdin != nil {
+		args = append(args, "-i")
+	}
+	if opts.User != "" {
+		args = append(args, "-u", opts.User)
+	}
+	if opts.execID != "" {
+		args = append(args, "-e", execIDVar+"="+opts.execID)
+	}
+	if len(opts.args) > 0 {
+		args = append(append(args, Coder.Name), opts.args...)
+	} else {
+		args = append(args, Coder.Name, "/bin/bash", "-c", command)
 	}
 
-	log.Println("Initializing Coder service...")
+	cmd := exec.CommandContext(ctx, "docker", args...)
+	cmd.Stdin = opts.Stdin
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	return cmd.Run()
+}
 
-	// Check if container already exists
-	existing := containers.Local().Service("workbench-coder")
-	if existing != nil && existing.IsRunning() {
-		log.Println("Coder service already running")
-		Coder = existing
-		return
+// killInCoder ends every process in the container started by the command
+// with the given exec ID. Killing the docker client alone would leave the
+// command running in the container. Replaced in tests.
+var killInCoder = func(execID string) {
+	script := fmt.Sprintf(`for f in /proc/[0-9]*/environ; do
+		if tr '\0' '\n' < "$f" 2>/dev/null | grep -qx '%s=%s'; then
+			p=${f#/proc/}; kill -TERM "${p%%/environ}" 2>/dev/null
+		fi
+	done; true`, execIDVar, execID)
+	if err := exec.Command("docker", "exec", "-u", "root", Coder.Name, "/bin/bash", "-c", script).Run(); err != nil {
+		slog.Warn("failed to stop cancelled command", "exec_id", execID, "error", err)
 	}
+}
 
-	prepareScript := `
-		mkdir -p /mnt/data/services/workbench-coder
-		mkdir -p /mnt/data/services/workbench-coder/.config
-		mkdir -p /mnt/data/services/workbench-coder/repos
-		chmod -R 777 /mnt/data/services/workbench-coder
-		chown -R 1000:1000 /mnt/data/services/workbench-coder || true
-	`
+// runCancellable runs a command, killing it in the container if ctx ends
+// first. The error is then ctx's, e.g. context.DeadlineExceeded.
+//
+// Each command is logged at debug level with the request ID ctx carries,
+// naming only the program so secrets in arguments stay out of the log.
+func runCancellable(ctx context.Context, command string, opts ExecOptions, stdout, stderr io.Writer) error {
+	opts.execID = newExecID()
+	kill := killInCoder
+	stop := context.AfterFunc(ctx, func() { kill(opts.execID) })
+	defer stop()
 
-	if err := containers.Local().Exec("bash", "-c", prepareScript); err != nil {
-		log.Fatalf("Failed to prepare coder directrories: %v", err)
+	started := time.Now()
+	err := runInCoder(ctx, command, opts, stdout, stderr)
+	if ctxErr := ctx.Err(); err != nil && ctxErr != nil {
+		err = ctxErr
 	}
+	slog.DebugContext(ctx, "coder exec", "exec_id", opts.execID, "program", execProgram(opts),
+		"exit_code", exitCode(err), "duration", time.Since(started).Round(time.Millisecond))
+	return err
+}
 
-	// Launch the container
-	log.Println("Starting Coder container...")
-	if err := containers.Launch(containers.Local(), Coder); err != nil {
-		log.Fatalf("failed to start coder service: %v", err)
+// execProgram names what a command runs for logs: the program, with the
+// subcommand for git, or "bash" for shell commands.
+func execProgram(opts ExecOptions) string {
+	switch {
+	case len(opts.args) == 0:
+		return "bash"
+	case opts.args[0] == "git" && len(opts.args) > 1:
+		return "git " + opts.args[1]
 	}
+	return opts.args[0]
+}
 
-	log.Println("Coder service started successfully")
+func newExecID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// exitCode returns the exit status of a command that ran to an exit, 0 on
+// success, and -1 otherwise.
+func exitCode(err error) int {
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return 0
+	case errors.As(err, &exitErr):
+		return exitErr.ExitCode()
+	}
+	return -1
 }
 
 // CoderExec executes a shell command inside the VS Code server container.
 // Used for Git operations, file management, and system commands.
 // Commands are run with bash -c for proper shell expansion.
+// Output is capped at DefaultMaxOutput; use CoderExecWithOptions to detect
+// truncation or CoderExecStream to process large output incrementally.
 //
 // Parameters:
 //   - command: Shell command to execute
 //
 // Returns:
 //   - Command output (stdout and stderr combined)
-//   - Error if container not running or command fails
+//   - Error if container not running, command fails, or it runs past
+//     DefaultExecTimeout
 func CoderExec(command string) (string, error) {
-	if Coder == nil {
-		return "", fmt.Errorf("coder service not initialized")
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultExecTimeout)
+	defer cancel()
+	result, err := CoderExecCtx(ctx, command)
+	return result.Output, err
+}
+
+// CoderExecCtx executes a shell command, killing it in the container when
+// ctx is cancelled or its deadline passes; the error is then ctx's. The
+// result keeps stdout and stderr apart as well as combined, with the exit
+// code. It is never nil.
+func CoderExecCtx(ctx context.Context, command string) (*ExecResult, error) {
+	return execBuffered(ctx, command, ExecOptions{})
+}
+
+// CoderExecArgs runs a program in the container with the given arguments
+// and no shell, so values such as repository names and URLs are passed as
+// they are rather than interpreted by bash. Otherwise like CoderExec.
+func CoderExecArgs(args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultExecTimeout)
+	defer cancel()
+	result, err := CoderExecArgsCtx(ctx, args...)
+	return result.Output, err
+}
+
+// CoderExecArgsCtx is CoderExecCtx for a program and its arguments, run
+// without a shell.
+func CoderExecArgsCtx(ctx context.Context, args ...string) (*ExecResult, error) {
+	return execBuffered(ctx, argsCommand(args), ExecOptions{args: args})
+}
+
+// argsCommand describes a program and its arguments for logs and fakes.
+func argsCommand(args []string) string {
+	return strings.Join(args, " ")
+}
+
+// writeFileScript writes stdin to $1 with mode $2, expanding a leading ~/
+// to the container user's home directory. The umask keeps the file private
+// until its mode is set.
+const writeFileScript = `p=$1; case $p in "~/"*) p=$HOME/${p#"~/"} ;; esac; umask 077 && mkdir -p "$(dirname "$p")" && cat > "$p" && chmod "$2" "$p"`
+
+// CoderWriteFile writes content to path in the container with the given
+// mode. The content goes over stdin, so secrets such as private keys never
+// appear in a command line, the process list, or shell history. A leading
+// ~/ in path is the container user's home directory.
+func CoderWriteFile(path string, content []byte, mode os.FileMode) error {
+	args := []string{"/bin/bash", "-c", writeFileScript, "write-file", path, fmt.Sprintf("%o", mode.Perm())}
+	result, err := execBuffered(context.Background(), "write "+path, ExecOptions{Stdin: bytes.NewReader(content), args: args})
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %s", path, strings.TrimSpace(result.Output))
 	}
+	return nil
+}
 
-	if !Coder.IsRunning() {
-		return "", fmt.Errorf("coder service not running")
+// CoderExecWithOptions executes a shell command with an output limit and
+// optional stdin. Output beyond the limit is dropped from the result; use
+// CoderExecStream for commands whose output may be too large to hold in
+// memory at once. The returned result is never nil.
+func CoderExecWithOptions(command string, opts ExecOptions) (*ExecResult, error) {
+	return execBuffered(context.Background(), command, opts)
+}
+
+func execBuffered(ctx context.Context, command string, opts ExecOptions) (*ExecResult, error) {
+	if opts.MaxOutput <= 0 {
+		opts.MaxOutput = DefaultMaxOutput
 	}
 
-	// Use containers.Service ExecInContainerWithOutput method
-	return Coder.ExecInContainerWithOutput("/bin/bash", "-c", command)
+	combined := &limitedBuffer{limit: opts.MaxOutput}
+	stdout := &limitedBuffer{limit: opts.MaxOutput}
+	stderr := &limitedBuffer{limit: opts.MaxOutput}
+	err := runCancellable(ctx, command, opts, io.MultiWriter(combined, stdout), io.MultiWriter(combined, stderr))
+
+	result := &ExecResult{
+		Output:    combined.String(),
+		Stdout:    stdout.String(),
+		Stderr:    stderr.String(),
+		ExitCode:  exitCode(err),
+		Truncated: combined.dropped > 0,
+		Dropped:   combined.dropped,
+	}
+	if result.Truncated {
+		result.Output += fmt.Sprintf("\n[output truncated: %d bytes omitted]\n", combined.dropped)
+	}
+	return result, err
 }
 
+// CoderExecStream executes a shell command and delivers its output one
+// line at a time as it is produced, without buffering the whole output.
+// Returning an error from onLine stops reading and kills the command.
+func CoderExecStream(command string, onLine func(line string) error) error {
+	return streamLines(CoderExecReader(context.Background(), command), onLine)
+}
+
+// streamLines delivers a running command's output to onLine one line at
+// a time, closing it once read.
+func streamLines(output io.ReadCloser, onLine func(line string) error) error {
+	defer output.Close()
+
+	scanner := bufio.NewScanner(output)
+	scanner.Buffer(make([]byte, 64*1024), DefaultMaxOutput)
+	for scanner.Scan() {
+		if err := onLine(scanner.Text()); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// CoderExecReader starts a shell command and returns its combined output
+// as it is produced. Reads end with io.EOF when the command succeeds, or
+// with its error, such as an *exec.ExitError carrying the exit code, when
+// it fails. Cancelling ctx or closing the reader kills the command; Close
+// waits for it to exit.
+func CoderExecReader(ctx context.Context, command string) io.ReadCloser {
+	return startReader(ctx, command, ExecOptions{stream: true})
+}
+
+// CoderExecArgsReader is CoderExecReader for a program and its arguments,
+// run without a shell.
+func CoderExecArgsReader(ctx context.Context, args ...string) io.ReadCloser {
+	return startReader(ctx, argsCommand(args), ExecOptions{stream: true, args: args})
+}
+
+func startReader(ctx context.Context, command string, opts ExecOptions) io.ReadCloser {
+	ctx, cancel := context.WithCancel(ctx)
+	reader, writer := io.Pipe()
+
+	done := make(chan struct{})
+	go func() {
+		writer.CloseWithError(runCancellable(ctx, command, opts, writer, writer))
+		close(done)
+	}()
+	return &execReader{PipeReader: reader, cancel: cancel, done: done}
+}
+
+// execReader is the output of a running command.
+type execReader struct {
+	*io.PipeReader
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// Close stops the command, then unblocks any write still in flight and
+// waits for the command to exit.
+func (r *execReader) Close() error {
+	r.cancel()
+	r.PipeReader.Close()
+	<-r.done
+	return nil
+}
+
+// limitedBuffer is an io.Writer that keeps the first limit bytes written
+// and counts the rest. Safe for concurrent writes from stdout and stderr.
+type limitedBuffer struct {
+	mu      sync.Mutex
+	buf     bytes.Buffer
+	limit   int
+	dropped int64
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if room := b.limit - b.buf.Len(); room > 0 {
+		if len(p) <= room {
+			b.buf.Write(p)
+			return len(p), nil
+		}
+		b.buf.Write(p[:room])
+		b.dropped += int64(len(p) - room)
+		return len(p), nil
+	}
+
+	b.dropped += int64(len(p))
+	return len(p), nil
+}
+
+func (b *limitedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// coderURL is where the proxy reaches code-server, published on the host
+// by the Ports mapping above.
+var coderURL = &url.URL{Scheme: "http", Host: "localhost:8080"}
+
 // CoderProxy returns an HTTP reverse proxy to the VS Code server.
 // Forwards requests from /coder/* to the code-server on port 8080.
 // Used to expose VS Code through the workbench with authentication.
-// Returns error handler if service is not initialized.
-func CoderProxy() http.Handler {
-	if Coder == nil {
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			http.Error(w, "Coder service not initialized", http.StatusServiceUnavailable)
-		})
+// Keeps connections to the container alive between requests, streams
+// responses in bufferSize chunks (0 for DefaultProxyBufferSize), and
+// compresses and caches what the browser fetches repeatedly.
+// Answers 503 until the container has started, and while it's paused.
+// Each request counts as use of the container, see TouchCoder.
+func CoderProxy(bufferSize int) http.Handler {
+	proxy := newProxy(coderURL, newProxyTransport(), bufferSize)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if status := CoderState(); status.State != CoderRunning {
+			http.Error(w, coderNotReady(status).Error(), http.StatusServiceUnavailable)
+			return
+		}
+		TouchCoder()
+		proxy.ServeHTTP(w, r)
+	})
+}
+
+// ContainerState is the Docker state of the coder container.
+type ContainerState struct {
+	Status   string // e.g. "running", "exited", "restarting"; "missing" when there's no container
+	ExitCode int    // Exit code of the last run
+	Error    string // Docker's error starting the container, if any
+}
+
+// CoderContainerState inspects the coder container. Works whether or not
+// it's running, so it can explain why it isn't.
+func CoderContainerState() (*ContainerState, error) {
+	name := "workbench-coder"
+	if Coder != nil {
+		name = Coder.Name
 	}
 
-	// Use container's proxy method
-	return Coder.Proxy(8080)
+	output, err := exec.Command("docker", "inspect", "-f",
+		"{{.State.Status}}|{{.State.ExitCode}}|{{.State.Error}}", name).CombinedOutput()
+	if err != nil {
+		if strings.Contains(string(output), "No such") {
+			return &ContainerState{Status: "missing"}, nil
+		}
+		return nil, fmt.Errorf("failed to inspect %s: %s", name, strings.TrimSpace(string(output)))
+	}
+	return parseContainerState(string(output))
+}
+
+// parseContainerState parses "status|exit code|error" from docker inspect.
+func parseContainerState(output string) (*ContainerState, error) {
+	fields := strings.SplitN(strings.TrimSpace(output), "|", 3)
+	if len(fields) != 3 {
+		return nil, fmt.Errorf("unexpected docker inspect output %q", strings.TrimSpace(output))
+	}
+	exitCode, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return nil, fmt.Errorf("unexpected exit code %q", fields[1])
+	}
+	return &ContainerState{Status: fields[0], ExitCode: exitCode, Error: fields[2]}, nil
 }
 
 // CoderRestart performs a graceful restart of the VS Code server container.
 // Stops the container (if running) and starts it again.
 // Used when configuration changes or to recover from issues.
+// Before the container has started, retries starting it instead.
 // Logs all operations for debugging.
 func CoderRestart() error {
 	if Coder == nil {
 		return fmt.Errorf("coder service not initialized")
 	}
+	if status := CoderState(); status.State != CoderRunning {
+		EnsureCoder()
+		return nil
+	}
 
-	log.Println("Restarting Coder service...")
+	slog.Info("restarting coder service")
 	if err := Coder.Stop(); err != nil {
-		log.Printf("Error stopping coder service: %v", err)
+		slog.Error("failed to stop coder service", "error", err)
 	}
 
 	return Coder.Start()
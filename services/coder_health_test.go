@@ -0,0 +1,115 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/The-Skyscape/devtools/pkg/testutils"
+)
+
+// newTestHealthChecker returns a checker whose probe fails while *failing
+// is set, counting restarts and recorded activity types.
+func newTestHealthChecker(failing *bool, restarts *int, recorded *[]string) *coderHealthChecker {
+	return &coderHealthChecker{
+		status:  CoderHealthStatus{Status: CoderHealthUnknown},
+		backoff: coderRestartBackoff,
+		probe: func() error {
+			if *failing {
+				return errors.New("connection refused")
+			}
+			return nil
+		},
+		restart: func() error {
+			*restarts++
+			return nil
+		},
+		record: func(activityType, description string) {
+			*recorded = append(*recorded, activityType)
+		},
+	}
+}
+
+func TestCoderHealthRestartsAfterThreshold(t *testing.T) {
+	failing, restarts, recorded := true, 0, []string{}
+	h := newTestHealthChecker(&failing, &restarts, &recorded)
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	for i := 0; i < CoderHealthFailureThreshold-1; i++ {
+		h.check(start.Add(time.Duration(i) * CoderHealthInterval))
+	}
+	testutils.AssertEqual(t, 0, restarts)
+	testutils.AssertEqual(t, CoderHealthFailing, h.status.Status)
+	testutils.AssertEqual(t, CoderHealthFailureThreshold-1, h.status.ConsecutiveFailures)
+	testutils.AssertEqual(t, "connection refused", h.status.LastError)
+
+	now := start.Add(time.Duration(CoderHealthFailureThreshold) * CoderHealthInterval)
+	h.check(now)
+	testutils.AssertEqual(t, 1, restarts)
+	testutils.AssertEqual(t, "coder_auto_restart", strings.Join(recorded, ","))
+	testutils.AssertEqual(t, now, h.status.LastRestart)
+	testutils.AssertEqual(t, 0, h.status.ConsecutiveFailures)
+	testutils.AssertEqual(t, now.Add(coderRestartBackoff), h.status.NextRestart)
+}
+
+func TestCoderHealthBacksOffBetweenRestarts(t *testing.T) {
+	failing, restarts, recorded := true, 0, []string{}
+	h := newTestHealthChecker(&failing, &restarts, &recorded)
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	// Check every 10 seconds for an hour with code-server never answering:
+	// after the first restart the next ones wait 1, 2, 4, 8, and 16 minutes,
+	// and the wait after that is capped.
+	for i := 0; i < 360; i++ {
+		h.check(now)
+		now = now.Add(10 * time.Second)
+	}
+	testutils.AssertEqual(t, 6, restarts)
+	testutils.AssertEqual(t, coderRestartMaxBackoff, h.backoff)
+}
+
+func TestCoderHealthBackoffIsCapped(t *testing.T) {
+	failing, restarts, recorded := true, 0, []string{}
+	h := newTestHealthChecker(&failing, &restarts, &recorded)
+	h.backoff = coderRestartMaxBackoff
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	for i := 0; i < CoderHealthFailureThreshold; i++ {
+		h.check(now)
+	}
+	testutils.AssertEqual(t, 1, restarts)
+	testutils.AssertEqual(t, coderRestartMaxBackoff, h.backoff)
+}
+
+func TestCoderHealthRecoveryResetsBackoff(t *testing.T) {
+	failing, restarts, recorded := true, 0, []string{}
+	h := newTestHealthChecker(&failing, &restarts, &recorded)
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	for i := 0; i < CoderHealthFailureThreshold; i++ {
+		h.check(now)
+	}
+	testutils.AssertEqual(t, 1, restarts)
+	testutils.AssertEqual(t, 2*coderRestartBackoff, h.backoff)
+
+	failing = false
+	h.check(now.Add(CoderHealthInterval))
+	testutils.AssertEqual(t, CoderHealthHealthy, h.status.Status)
+	testutils.AssertEqual(t, "", h.status.LastError)
+	testutils.AssertEqual(t, coderRestartBackoff, h.backoff)
+	testutils.AssertEqual(t, 1, h.status.Restarts)
+}
+
+func TestCoderHealthRecordsFailedRestart(t *testing.T) {
+	failing, restarts, recorded := true, 0, []string{}
+	h := newTestHealthChecker(&failing, &restarts, &recorded)
+	h.restart = func() error { return errors.New("docker unavailable") }
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	for i := 0; i < CoderHealthFailureThreshold; i++ {
+		h.check(now)
+	}
+	testutils.AssertEqual(t, "coder_restart_failed", strings.Join(recorded, ","))
+	testutils.AssertEqual(t, 1, h.status.Restarts)
+}
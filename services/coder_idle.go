@@ -0,0 +1,106 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+	"time"
+)
+
+// ErrCoderBusy is returned by PauseCoder when the container is starting,
+// upgrading, or already paused.
+var ErrCoderBusy = errors.New("coder container can't be paused now")
+
+// coderLastUsed is when the IDE, a command, or a terminal last used the
+// coder container, in Unix nanoseconds. Starts at startup, so a workbench
+// that was just started isn't idle.
+var coderLastUsed atomic.Int64
+
+func init() {
+	TouchCoder()
+}
+
+// TouchCoder records that the coder container was just used, pushing
+// back an idle pause.
+func TouchCoder() {
+	coderLastUsed.Store(time.Now().UnixNano())
+}
+
+// CoderLastUsed returns when the coder container was last used.
+func CoderLastUsed() time.Time {
+	return time.Unix(0, coderLastUsed.Load())
+}
+
+// CoderIsPaused reports whether PauseCoder stopped the container and
+// nothing has resumed it since.
+func CoderIsPaused() bool {
+	return CoderState().State == CoderPaused
+}
+
+// PauseCoder stops a running coder container to save memory, leaving it
+// for EnsureCoder to start again. Commands and the proxy refuse with
+// ErrCoderNotReady while it's paused, and the health checker leaves it
+// alone.
+func PauseCoder() error {
+	if CoderUpgrading() {
+		return fmt.Errorf("%w - it's being upgraded", ErrCoderBusy)
+	}
+	return coderStart.pause(time.Now())
+}
+
+func (s *coderStarter) pause(now time.Time) error {
+	s.mu.Lock()
+	if s.status.State != CoderRunning || s.looping {
+		s.mu.Unlock()
+		return fmt.Errorf("%w - it's %s", ErrCoderBusy, s.status.State)
+	}
+	// Marked first, so commands started from here on are refused rather
+	// than cut off by the stop
+	running := s.status
+	s.status = CoderStartStatus{State: CoderPaused, Attempts: running.Attempts, PausedAt: now}
+	stop := s.stop
+	s.mu.Unlock()
+
+	if err := stop(); err != nil {
+		s.mu.Lock()
+		s.status = running
+		s.mu.Unlock()
+		return fmt.Errorf("failed to stop the coder container: %w", err)
+	}
+	slog.Info("paused coder service")
+	return nil
+}
+
+// coderReadyPoll is how often WaitForCoder checks on a starting container.
+const coderReadyPoll = time.Second
+
+// WaitForCoder waits until the coder container has started and
+// code-server answers, for a resume that goes on into the IDE. Returns
+// the start's error once EnsureCoder gave up, or an error after timeout.
+func WaitForCoder(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		status := CoderState()
+		switch {
+		case status.State == CoderRunning && probeCoder() == nil:
+			return nil
+		case status.State == CoderFailed && status.NextAttempt.IsZero():
+			return coderNotReady(status)
+		case status.State == CoderPaused:
+			return coderNotReady(status)
+		case time.Now().After(deadline):
+			return fmt.Errorf("VS Code didn't come up within %s", timeout)
+		}
+		time.Sleep(coderReadyPoll)
+	}
+}
+
+// stopCoderContainer stops the coder container, keeping it for the next
+// start.
+func stopCoderContainer() error {
+	if Coder == nil {
+		return fmt.Errorf("coder service not initialized")
+	}
+	return Coder.Stop()
+}
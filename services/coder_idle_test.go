@@ -0,0 +1,51 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/The-Skyscape/devtools/pkg/testutils"
+)
+
+func TestCoderStarterPause(t *testing.T) {
+	var waits []time.Duration
+	s := newTestStarter(nil, &waits)
+	stops := 0
+	s.stop = func() error {
+		stops++
+		return nil
+	}
+
+	// Only a running container can be paused
+	err := s.pause(time.Now())
+	testutils.AssertEqual(t, true, errors.Is(err, ErrCoderBusy))
+	testutils.AssertEqual(t, 0, stops)
+
+	s.status = CoderStartStatus{State: CoderRunning, Attempts: 1}
+	pausedAt := time.Date(2026, 10, 16, 3, 0, 0, 0, time.UTC)
+	testutils.AssertEqual(t, nil, s.pause(pausedAt))
+	testutils.AssertEqual(t, 1, stops)
+	testutils.AssertEqual(t, CoderPaused, s.status.State)
+	testutils.AssertEqual(t, pausedAt, s.status.PausedAt)
+	testutils.AssertEqual(t, "coder service not ready yet - it was paused to save resources; resume it from the dashboard", coderNotReady(s.status).Error())
+
+	// Resuming starts the container again
+	s.ensure()
+	<-s.ready
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	testutils.AssertEqual(t, CoderRunning, s.status.State)
+	testutils.AssertEqual(t, true, s.status.PausedAt.IsZero())
+}
+
+func TestCoderStarterPauseFailure(t *testing.T) {
+	var waits []time.Duration
+	s := newTestStarter(nil, &waits)
+	s.status = CoderStartStatus{State: CoderRunning, Attempts: 1}
+	s.stop = func() error { return errors.New("Cannot connect to the Docker daemon") }
+
+	err := s.pause(time.Now())
+	testutils.AssertEqual(t, "failed to stop the coder container: Cannot connect to the Docker daemon", err.Error())
+	testutils.AssertEqual(t, CoderStartStatus{State: CoderRunning, Attempts: 1}, s.status)
+}
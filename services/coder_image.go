@@ -0,0 +1,350 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// CoderImageRepository is the image the coder container runs.
+const CoderImageRepository = "codercom/code-server"
+
+// DefaultCoderImageTag is the code-server release the coder container is
+// pinned to until the coder_image_tag setting says otherwise. A fixed
+// version, so a restart never moves to a new release on its own.
+const DefaultCoderImageTag = "4.96.4"
+
+// CoderUpgradeHealthTimeout is how long a new coder container has to pass
+// its health check before the upgrade is rolled back.
+const CoderUpgradeHealthTimeout = 2 * time.Minute
+
+// coderPullTimeout bounds pulling a new code-server image.
+const coderPullTimeout = 15 * time.Minute
+
+// coderTagsTTL is how long the newest release found on the registry is
+// reused before the registry is asked again.
+const coderTagsTTL = 6 * time.Hour
+
+// coderPreviousName is what the old coder container is renamed to while
+// its replacement is checked, so a rollback can start it again.
+const coderPreviousName = "workbench-coder-previous"
+
+// ErrCoderUpgradeRunning is returned while another upgrade is running.
+var ErrCoderUpgradeRunning = errors.New("an upgrade of the coder container is already running")
+
+// CoderRollbackError is returned by UpgradeCoder when the new container
+// failed its health check and the previous one was started again.
+type CoderRollbackError struct {
+	Previous string // Image rolled back to
+	Reason   error  // Why the new container was given up on
+}
+
+func (e *CoderRollbackError) Error() string {
+	return fmt.Sprintf("the new coder container failed its health check (%v) - rolled back to %s", e.Reason, e.Previous)
+}
+
+func (e *CoderRollbackError) Unwrap() error { return e.Reason }
+
+// CoderImage describes the image the coder container runs and the newest
+// code-server release on the registry.
+type CoderImage struct {
+	Image            string    // e.g. "codercom/code-server:4.96.4"
+	Tag              string    // e.g. "4.96.4"; "latest" for an unpinned image
+	Digest           string    // Image ID, e.g. "sha256:3f1c..."
+	Latest           string    // Newest release tag, or "" until the registry has answered
+	UpgradeAvailable bool      // Latest is a newer release than Tag
+	CheckedAt        time.Time // When the registry was last asked
+	CheckError       string    // Why the registry couldn't be asked, if it couldn't
+}
+
+var validImageTag = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]{0,127}$`)
+
+// releaseTag matches code-server release tags like 4.96.4.
+var releaseTag = regexp.MustCompile(`^(\d+)\.(\d+)\.(\d+)$`)
+
+// ValidCoderImageTag reports whether tag is a well formed image tag.
+func ValidCoderImageTag(tag string) bool {
+	return validImageTag.MatchString(tag)
+}
+
+// CoderImageName returns the coder image with a tag, e.g.
+// "codercom/code-server:4.96.4".
+func CoderImageName(tag string) string {
+	return CoderImageRepository + ":" + tag
+}
+
+// coderImageTag returns the tag a newly created coder container runs. Set
+// by SetCoderImageTag.
+var coderImageTag = func() string { return DefaultCoderImageTag }
+
+// SetCoderImageTag sets the function that returns the code-server tag
+// the coder container is created from, the coder_image_tag setting. Call
+// it before EnsureCoder.
+func SetCoderImageTag(tag func() string) {
+	coderImageTag = tag
+}
+
+// desiredCoderImage returns the image to create the coder container from,
+// falling back to DefaultCoderImageTag when the tag is invalid.
+func desiredCoderImage() string {
+	tag := coderImageTag()
+	if !ValidCoderImageTag(tag) {
+		slog.Warn("invalid coder image tag, using the default", "tag", tag, "default", DefaultCoderImageTag)
+		tag = DefaultCoderImageTag
+	}
+	return CoderImageName(tag)
+}
+
+// Coder image seams. Replaced in tests.
+var (
+	// coderDocker runs a docker command and returns its combined output
+	coderDocker = func(ctx context.Context, args ...string) (string, error) {
+		output, err := exec.CommandContext(ctx, "docker", args...).CombinedOutput()
+		return string(output), err
+	}
+	coderTagsURL       = "https://hub.docker.com/v2/repositories/" + CoderImageRepository + "/tags?page_size=100&ordering=last_updated"
+	coderProbe         = probeCoder
+	coderProbeInterval = 2 * time.Second
+)
+
+var (
+	coderTagsMu      sync.Mutex
+	coderLatest      string
+	coderTagsChecked time.Time
+	coderTagsError   string
+	coderTagsLoading bool
+
+	// coderUpgrading is set while UpgradeCoder runs, so the health
+	// checker leaves the container alone and upgrades don't overlap.
+	coderUpgrading atomic.Bool
+)
+
+// CoderImageInfo returns the image the coder container runs and whether a
+// newer code-server release is available. The registry is asked in the
+// background at most every six hours, so the first call after that
+// reports the last answer.
+func CoderImageInfo() (*CoderImage, error) {
+	output, err := coderDocker(context.Background(), "inspect", "--format", "{{.Config.Image}}\t{{.Image}}", coderName())
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect the coder container: %s", lastOutputLine(output))
+	}
+	image, digest, _ := strings.Cut(strings.TrimSpace(output), "\t")
+	info := &CoderImage{Image: image, Tag: imageTag(image), Digest: digest}
+
+	coderTagsMu.Lock()
+	info.Latest, info.CheckedAt, info.CheckError = coderLatest, coderTagsChecked, coderTagsError
+	if !coderTagsLoading && time.Since(coderTagsChecked) > coderTagsTTL {
+		coderTagsLoading = true
+		go refreshCoderTags()
+	}
+	coderTagsMu.Unlock()
+
+	info.UpgradeAvailable = newerRelease(info.Latest, info.Tag)
+	return info, nil
+}
+
+// imageTag returns the tag of an image reference, "latest" when it has
+// none.
+func imageTag(image string) string {
+	if at := strings.Index(image, "@"); at >= 0 {
+		image = image[:at]
+	}
+	if colon := strings.LastIndex(image, ":"); colon > strings.LastIndex(image, "/") {
+		return image[colon+1:]
+	}
+	return "latest"
+}
+
+// refreshCoderTags asks the registry for the newest code-server release.
+func refreshCoderTags() {
+	latest, err := fetchLatestCoderTag()
+
+	coderTagsMu.Lock()
+	defer coderTagsMu.Unlock()
+	coderTagsLoading = false
+	coderTagsChecked = time.Now()
+	if err != nil {
+		slog.Warn("failed to check for code-server releases", "error", err)
+		coderTagsError = err.Error()
+		return
+	}
+	coderLatest, coderTagsError = latest, ""
+}
+
+// fetchLatestCoderTag returns the newest release tag from the registry's
+// tags endpoint, leaving out tags like latest and 4.96.4-ubuntu.
+func fetchLatestCoderTag() (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, coderTagsURL, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("registry answered %s", resp.Status)
+	}
+
+	var page struct {
+		Results []struct {
+			Name string `json:"name"`
+		} `json:"results"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 4<<20)).Decode(&page); err != nil {
+		return "", fmt.Errorf("unexpected registry response: %w", err)
+	}
+	latest := ""
+	for _, tag := range page.Results {
+		if releaseTag.MatchString(tag.Name) && (latest == "" || newerRelease(tag.Name, latest)) {
+			latest = tag.Name
+		}
+	}
+	if latest == "" {
+		return "", errors.New("no release tags found")
+	}
+	return latest, nil
+}
+
+// newerRelease reports whether release tag a is newer than b. Tags that
+// aren't releases, such as latest, are never newer nor older.
+func newerRelease(a, b string) bool {
+	va, vb := releaseTag.FindStringSubmatch(a), releaseTag.FindStringSubmatch(b)
+	if va == nil || vb == nil {
+		return false
+	}
+	for i := 1; i <= 3; i++ {
+		x, _ := strconv.Atoi(va[i])
+		y, _ := strconv.Atoi(vb[i])
+		if x != y {
+			return x > y
+		}
+	}
+	return false
+}
+
+// UpgradeCoder moves the coder container to another code-server tag. It
+// pulls the image, stops the running container and keeps it aside, and
+// starts a new one with the same name, mounts, network, and ports. If
+// code-server doesn't answer within healthTimeout, the new container is
+// removed and the old one started again, returning a *CoderRollbackError.
+// Returns the image that was running before.
+func UpgradeCoder(tag string, healthTimeout time.Duration) (string, error) {
+	if !ValidCoderImageTag(tag) {
+		return "", fmt.Errorf("invalid image tag %q", tag)
+	}
+	if !coderUpgrading.CompareAndSwap(false, true) {
+		return "", ErrCoderUpgradeRunning
+	}
+	defer coderUpgrading.Store(false)
+
+	name, image := coderName(), CoderImageName(tag)
+	previous, err := coderDocker(context.Background(), "inspect", "--format", "{{.Config.Image}}", name)
+	if err != nil {
+		return "", fmt.Errorf("failed to inspect the coder container: %s", lastOutputLine(previous))
+	}
+	previous = strings.TrimSpace(previous)
+
+	ctx, cancel := context.WithTimeout(context.Background(), coderPullTimeout)
+	defer cancel()
+	slog.Info("pulling coder image", "image", image)
+	if output, err := coderDocker(ctx, "pull", image); err != nil {
+		return previous, fmt.Errorf("failed to pull %s: %s", image, lastOutputLine(output))
+	}
+
+	// Keep the old container until the new one answers
+	coderDocker(context.Background(), "rm", "-f", coderPreviousName)
+	if output, err := coderDocker(context.Background(), "stop", name); err != nil {
+		return previous, fmt.Errorf("failed to stop the coder container: %s", lastOutputLine(output))
+	}
+	if output, err := coderDocker(context.Background(), "rename", name, coderPreviousName); err != nil {
+		coderDocker(context.Background(), "start", name)
+		return previous, fmt.Errorf("failed to set the old coder container aside: %s", lastOutputLine(output))
+	}
+
+	slog.Info("starting upgraded coder container", "image", image, "previous", previous)
+	if output, err := coderDocker(context.Background(), coderRunArgs(name, image)...); err != nil {
+		return previous, rollbackCoder(name, previous, fmt.Errorf("failed to start: %s", lastOutputLine(output)))
+	}
+	if err := waitForCoder(healthTimeout); err != nil {
+		return previous, rollbackCoder(name, previous, err)
+	}
+
+	coderDocker(context.Background(), "rm", "-f", coderPreviousName)
+	if Coder != nil {
+		Coder.Image = image
+	}
+	slog.Info("upgraded coder container", "image", image, "previous", previous)
+	return previous, nil
+}
+
+// coderRunArgs returns the docker run arguments that start the coder
+// container from image, as it's configured in Coder.
+func coderRunArgs(name, image string) []string {
+	args := []string{"run", "-d", "--name", name, "--network", coderNetwork, "--restart", "always"}
+	for host, container := range coderPorts {
+		args = append(args, "-p", fmt.Sprintf("%d:%d", host, container))
+	}
+	for host, container := range coderMounts {
+		args = append(args, "-v", host+":"+container)
+	}
+	args = append(args, image)
+	return append(args, strings.Fields(coderCommand)...)
+}
+
+// waitForCoder polls code-server until it answers or timeout passes,
+// returning the last reason it didn't answer.
+func waitForCoder(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		err := coderProbe()
+		if err == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("no answer within %s: %w", timeout, err)
+		}
+		time.Sleep(coderProbeInterval)
+	}
+}
+
+// rollbackCoder removes the new coder container and starts the old one
+// again under its own name.
+func rollbackCoder(name, previous string, reason error) error {
+	slog.Error("rolling back coder upgrade", "previous", previous, "error", reason)
+	coderDocker(context.Background(), "rm", "-f", name)
+	if output, err := coderDocker(context.Background(), "rename", coderPreviousName, name); err != nil {
+		return fmt.Errorf("upgrade failed (%v) and rolling back failed too: %s", reason, lastOutputLine(output))
+	}
+	if output, err := coderDocker(context.Background(), "start", name); err != nil {
+		return fmt.Errorf("upgrade failed (%v) and the old coder container didn't start: %s", reason, lastOutputLine(output))
+	}
+	return &CoderRollbackError{Previous: previous, Reason: reason}
+}
+
+// CoderUpgrading reports whether an upgrade of the coder container is
+// running.
+func CoderUpgrading() bool {
+	return coderUpgrading.Load()
+}
+
+func coderName() string {
+	if Coder != nil {
+		return Coder.Name
+	}
+	return "workbench-coder"
+}
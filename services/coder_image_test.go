@@ -0,0 +1,126 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/The-Skyscape/devtools/pkg/testutils"
+)
+
+// fakeCoderDocker records docker commands, failing those starting with a
+// prefix in failing, and answers code-server's health check with probe.
+func fakeCoderDocker(t *testing.T, failing []string, probe func() error) *[]string {
+	var commands []string
+	docker, healthy, interval, image := coderDocker, coderProbe, coderProbeInterval, Coder.Image
+	coderDocker = func(ctx context.Context, args ...string) (string, error) {
+		command := strings.Join(args, " ")
+		commands = append(commands, command)
+		for _, prefix := range failing {
+			if strings.HasPrefix(command, prefix) {
+				return "Error: " + prefix + " failed\n", errors.New("exit status 1")
+			}
+		}
+		if args[0] == "inspect" {
+			return "codercom/code-server:4.96.4\n", nil
+		}
+		return "", nil
+	}
+	coderProbe = probe
+	coderProbeInterval = time.Millisecond
+	t.Cleanup(func() { coderDocker, coderProbe, coderProbeInterval, Coder.Image = docker, healthy, interval, image })
+	return &commands
+}
+
+func TestNewerRelease(t *testing.T) {
+	testutils.AssertEqual(t, true, newerRelease("4.100.0", "4.96.4"))
+	testutils.AssertEqual(t, true, newerRelease("5.0.0", "4.96.4"))
+	testutils.AssertEqual(t, false, newerRelease("4.96.4", "4.96.4"))
+	testutils.AssertEqual(t, false, newerRelease("4.9.10", "4.96.4"))
+	testutils.AssertEqual(t, false, newerRelease("4.100.0", "latest"))
+	testutils.AssertEqual(t, false, newerRelease("", "4.96.4"))
+}
+
+func TestImageTag(t *testing.T) {
+	testutils.AssertEqual(t, "4.96.4", imageTag("codercom/code-server:4.96.4"))
+	testutils.AssertEqual(t, "latest", imageTag("codercom/code-server"))
+	testutils.AssertEqual(t, "latest", imageTag("registry:5000/code-server"))
+	testutils.AssertEqual(t, "4.96.4", imageTag("codercom/code-server:4.96.4@sha256:abc"))
+}
+
+func TestFetchLatestCoderTag(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"results": [{"name": "latest"}, {"name": "4.96.4"}, {"name": "4.100.1-ubuntu"}, {"name": "4.100.0"}, {"name": "4.99.3"}]}`))
+	}))
+	defer server.Close()
+	url := coderTagsURL
+	coderTagsURL = server.URL
+	defer func() { coderTagsURL = url }()
+
+	latest, err := fetchLatestCoderTag()
+	testutils.AssertEqual(t, nil, err)
+	testutils.AssertEqual(t, "4.100.0", latest)
+}
+
+func TestUpgradeCoder(t *testing.T) {
+	commands := fakeCoderDocker(t, nil, func() error { return nil })
+
+	previous, err := UpgradeCoder("4.100.0", time.Second)
+	testutils.AssertEqual(t, nil, err)
+	testutils.AssertEqual(t, "codercom/code-server:4.96.4", previous)
+	testutils.AssertEqual(t, "codercom/code-server:4.100.0", Coder.Image)
+
+	run := ""
+	for _, command := range *commands {
+		if strings.HasPrefix(command, "run ") {
+			run = command
+		}
+	}
+	testutils.AssertEqual(t, true, strings.Contains(run, "--name workbench-coder --network skyscape-internal"))
+	testutils.AssertEqual(t, true, strings.Contains(run, "-v /mnt/data/services/workbench-coder/:/home/coder"))
+	testutils.AssertEqual(t, true, strings.HasSuffix(run, "codercom/code-server:4.100.0 --auth none --bind-addr 0.0.0.0:8080"))
+	testutils.AssertEqual(t, "rm -f workbench-coder-previous", (*commands)[len(*commands)-1])
+}
+
+func TestUpgradeCoderRollsBack(t *testing.T) {
+	commands := fakeCoderDocker(t, nil, func() error { return errors.New("connection refused") })
+
+	_, err := UpgradeCoder("4.100.0", 10*time.Millisecond)
+	var rollback *CoderRollbackError
+	testutils.AssertEqual(t, true, errors.As(err, &rollback))
+	testutils.AssertEqual(t, "codercom/code-server:4.96.4", rollback.Previous)
+	testutils.AssertEqual(t, "codercom/code-server:4.96.4", Coder.Image)
+	testutils.AssertEqual(t, strings.Join([]string{
+		"rm -f workbench-coder",
+		"rename workbench-coder-previous workbench-coder",
+		"start workbench-coder",
+	}, "\n"), strings.Join((*commands)[len(*commands)-3:], "\n"))
+}
+
+func TestUpgradeCoderKeepsRunningWhenPullFails(t *testing.T) {
+	commands := fakeCoderDocker(t, []string{"pull"}, func() error { return nil })
+
+	_, err := UpgradeCoder("9.9.9", time.Second)
+	testutils.AssertEqual(t, "failed to pull codercom/code-server:9.9.9: Error: pull failed", err.Error())
+	for _, command := range *commands {
+		testutils.AssertEqual(t, false, strings.HasPrefix(command, "stop"))
+	}
+
+	_, err = UpgradeCoder("4.96; rm -rf /", time.Second)
+	testutils.AssertEqual(t, true, err != nil && strings.HasPrefix(err.Error(), "invalid image tag"))
+}
+
+func TestDesiredCoderImage(t *testing.T) {
+	original := coderImageTag
+	t.Cleanup(func() { coderImageTag = original })
+
+	testutils.AssertEqual(t, "codercom/code-server:"+DefaultCoderImageTag, desiredCoderImage())
+	SetCoderImageTag(func() string { return "4.100.0" })
+	testutils.AssertEqual(t, "codercom/code-server:4.100.0", desiredCoderImage())
+	SetCoderImageTag(func() string { return "latest; rm -rf /" })
+	testutils.AssertEqual(t, "codercom/code-server:"+DefaultCoderImageTag, desiredCoderImage())
+}
@@ -0,0 +1,56 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// gitLFSPackageScript installs git-lfs from the image's package lists,
+// falling back to the Git LFS project's package repository when the
+// distribution doesn't carry it. Runs as root.
+const gitLFSPackageScript = `set -e
+if command -v git-lfs >/dev/null 2>&1; then
+	echo "git-lfs is already installed"
+	exit 0
+fi
+export DEBIAN_FRONTEND=noninteractive
+apt-get update
+if ! apt-get install -y git-lfs; then
+	echo "git-lfs isn't in the distribution's packages - adding the Git LFS package repository"
+	curl -fsSL https://packagecloud.io/install/repositories/github/git-lfs/script.deb.sh | bash
+	apt-get install -y git-lfs
+fi`
+
+// Seams for installing Git LFS. Replaced in tests.
+var (
+	lfsRootStream = func(command string, onLine func(line string) error) error {
+		return streamLines(startReader(context.Background(), command, ExecOptions{stream: true, User: "root"}), onLine)
+	}
+	lfsExec = CoderExec
+)
+
+// GitLFSInstalled reports whether git-lfs is installed in the coder
+// container and its filters are registered, so clones and pulls download
+// LFS files instead of leaving pointer stubs.
+func GitLFSInstalled() bool {
+	_, err := lfsExec("git lfs version >/dev/null 2>&1 && git config --get filter.lfs.process >/dev/null")
+	return err == nil
+}
+
+// InstallGitLFS installs git-lfs in the coder container as root, then
+// registers its filters for the container user with git lfs install.
+// Output is delivered a line at a time as it is produced.
+func InstallGitLFS(onLine func(line string) error) error {
+	if err := lfsRootStream(gitLFSPackageScript, onLine); err != nil {
+		return fmt.Errorf("failed to install git-lfs: %w", err)
+	}
+	output, err := lfsExec("git lfs install 2>&1")
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		onLine(line)
+	}
+	if err != nil {
+		return fmt.Errorf("git lfs install failed: %s", lastOutputLine(output))
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package services
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/The-Skyscape/devtools/pkg/testutils"
+)
+
+// fakeLFSInstall answers the root install script with installErr and
+// git lfs install with output and err.
+func fakeLFSInstall(t *testing.T, installErr error, output string, err error) {
+	stream, exec := lfsRootStream, lfsExec
+	t.Cleanup(func() { lfsRootStream, lfsExec = stream, exec })
+
+	lfsRootStream = func(command string, onLine func(string) error) error {
+		onLine("Setting up git-lfs (3.4.1-1) ...")
+		return installErr
+	}
+	lfsExec = func(string) (string, error) { return output, err }
+}
+
+func TestInstallGitLFS(t *testing.T) {
+	fakeLFSInstall(t, nil, "Git LFS initialized.\n", nil)
+
+	var lines []string
+	err := InstallGitLFS(func(line string) error {
+		lines = append(lines, line)
+		return nil
+	})
+	testutils.AssertEqual(t, nil, err)
+	testutils.AssertEqual(t, []string{"Setting up git-lfs (3.4.1-1) ...", "Git LFS initialized."}, lines)
+}
+
+func TestInstallGitLFSFailures(t *testing.T) {
+	ignore := func(string) error { return nil }
+
+	fakeLFSInstall(t, errors.New("exit status 100"), "", nil)
+	testutils.AssertEqual(t, "failed to install git-lfs: exit status 100", InstallGitLFS(ignore).Error())
+
+	fakeLFSInstall(t, nil, "warning: something\nerror: could not write config file\n", errors.New("exit status 2"))
+	testutils.AssertEqual(t, "git lfs install failed: error: could not write config file", InstallGitLFS(ignore).Error())
+}
@@ -0,0 +1,161 @@
+package services
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// PTY is an interactive command running in the coder container on a
+// pseudo-terminal, as with docker exec -it. Reads return what the
+// command prints and writes are typed into it.
+type PTY struct {
+	master *os.File
+	cmd    *exec.Cmd
+	execID string
+
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+// ptyCommand returns the docker client that runs args in the container
+// with a terminal, marked with execID for killInCoder. Replaced in tests.
+var ptyCommand = func(execID string, args []string) (*exec.Cmd, error) {
+	if err := checkCoderReady(); err != nil {
+		return nil, err
+	}
+	dockerArgs := []string{"exec", "-it", "-e", execIDVar + "=" + execID, "-e", "TERM=xterm-256color", "-w", "/home/coder", Coder.Name}
+	return exec.Command("docker", append(dockerArgs, args...)...), nil
+}
+
+// CoderExecPTY starts args, e.g. bash -l, in the container on a new
+// pseudo-terminal of the given size. Close ends the command in the
+// container too, not only the docker client, so it must be called however
+// the session ends.
+func CoderExecPTY(args []string, cols, rows int) (*PTY, error) {
+	master, slave, err := openPTY()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open a terminal: %w", err)
+	}
+	defer slave.Close()
+
+	p := &PTY{master: master, execID: newExecID(), done: make(chan struct{})}
+	if err := p.Resize(cols, rows); err != nil {
+		master.Close()
+		return nil, err
+	}
+
+	p.cmd, err = ptyCommand(p.execID, args)
+	if err != nil {
+		master.Close()
+		return nil, err
+	}
+	p.cmd.Stdin, p.cmd.Stdout, p.cmd.Stderr = slave, slave, slave
+	// The terminal is the client's controlling terminal, so resizing it
+	// signals docker, which passes the size on to the container.
+	p.cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true, Setctty: true, Ctty: 0}
+	if err := p.cmd.Start(); err != nil {
+		master.Close()
+		return nil, fmt.Errorf("failed to start terminal: %w", err)
+	}
+	go func() {
+		p.cmd.Wait()
+		close(p.done)
+	}()
+	return p, nil
+}
+
+// Read reads output of the command. It returns an error once the command
+// has exited or the terminal was closed.
+func (p *PTY) Read(b []byte) (int, error) {
+	return p.master.Read(b)
+}
+
+// Write types input into the command.
+func (p *PTY) Write(b []byte) (int, error) {
+	return p.master.Write(b)
+}
+
+// Done is closed when the docker client exits, normally because the
+// command in the container did.
+func (p *PTY) Done() <-chan struct{} {
+	return p.done
+}
+
+// Resize sets the terminal size in characters. Sizes out of range are
+// refused.
+func (p *PTY) Resize(cols, rows int) error {
+	if cols < 1 || rows < 1 || cols > 1000 || rows > 1000 {
+		return fmt.Errorf("terminal size %dx%d is out of range", cols, rows)
+	}
+	size := struct{ rows, cols, x, y uint16 }{uint16(rows), uint16(cols), 0, 0}
+	return ptyControl(p.master, syscall.TIOCSWINSZ, unsafe.Pointer(&size))
+}
+
+// Close ends the command in the container and the docker client, and
+// waits for the client to exit. It is safe to call more than once.
+func (p *PTY) Close() error {
+	p.closeOnce.Do(func() {
+		killInCoder(p.execID)
+		if p.cmd.Process != nil {
+			p.cmd.Process.Kill()
+		}
+		<-p.done
+		p.master.Close()
+	})
+	return nil
+}
+
+// openPTY allocates a pseudo-terminal pair from /dev/ptmx. The master is
+// non-blocking, so Close interrupts a pending Read.
+func openPTY() (master, slave *os.File, err error) {
+	fd, err := syscall.Open("/dev/ptmx", syscall.O_RDWR|syscall.O_NOCTTY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := syscall.SetNonblock(fd, true); err != nil {
+		syscall.Close(fd)
+		return nil, nil, err
+	}
+	master = os.NewFile(uintptr(fd), "/dev/ptmx")
+
+	var unlock int32
+	if err := ptyControl(master, syscall.TIOCSPTLCK, unsafe.Pointer(&unlock)); err != nil {
+		master.Close()
+		return nil, nil, err
+	}
+	var n uint32
+	if err := ptyControl(master, syscall.TIOCGPTN, unsafe.Pointer(&n)); err != nil {
+		master.Close()
+		return nil, nil, err
+	}
+	slave, err = os.OpenFile("/dev/pts/"+strconv.Itoa(int(n)), os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		return nil, nil, err
+	}
+	return master, slave, nil
+}
+
+// ptyControl runs an ioctl on f without switching it to blocking mode, as
+// f.Fd() would.
+func ptyControl(f *os.File, request uintptr, arg unsafe.Pointer) error {
+	conn, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var errno syscall.Errno
+	if err := conn.Control(func(fd uintptr) {
+		_, _, errno = syscall.Syscall(syscall.SYS_IOCTL, fd, request, uintptr(arg))
+	}); err != nil {
+		return err
+	}
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
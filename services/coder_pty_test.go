@@ -0,0 +1,94 @@
+package services
+
+import (
+	"bufio"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/The-Skyscape/devtools/pkg/testutils"
+)
+
+// localPTY runs terminal commands on the host instead of in the container.
+// The exec IDs of closed terminals are sent on killed.
+func localPTY(t *testing.T) (killed <-chan string) {
+	original, originalKill := ptyCommand, killInCoder
+	kills := make(chan string, 4)
+	ptyCommand = func(execID string, args []string) (*exec.Cmd, error) {
+		return exec.Command(args[0], args[1:]...), nil
+	}
+	killInCoder = func(execID string) { kills <- execID }
+	t.Cleanup(func() { ptyCommand, killInCoder = original, originalKill })
+	return kills
+}
+
+// readUntil reads terminal output until a line containing want and
+// returns the line from want on, without escape sequences before it.
+func readUntil(t *testing.T, r *bufio.Reader, want string) string {
+	t.Helper()
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("terminal ended before %q: %v", want, err)
+		}
+		if i := strings.Index(line, want); i >= 0 {
+			return strings.TrimSpace(line[i:])
+		}
+	}
+}
+
+func TestCoderExecPTYRelaysInput(t *testing.T) {
+	localPTY(t)
+	pty, err := CoderExecPTY([]string{"bash", "--norc", "-i"}, 80, 24)
+	testutils.AssertEqual(t, nil, err)
+	defer pty.Close()
+
+	pty.Write([]byte("echo hello-$((6*7))\n"))
+	testutils.AssertEqual(t, "hello-42", readUntil(t, bufio.NewReader(pty), "hello-42"))
+}
+
+func TestCoderExecPTYResizes(t *testing.T) {
+	localPTY(t)
+	pty, err := CoderExecPTY([]string{"bash", "--norc", "-i"}, 80, 24)
+	testutils.AssertEqual(t, nil, err)
+	defer pty.Close()
+
+	testutils.AssertEqual(t, nil, pty.Resize(132, 40))
+	pty.Write([]byte("echo size-$(stty size)\n"))
+	testutils.AssertEqual(t, "size-40 132", readUntil(t, bufio.NewReader(pty), "size-4"))
+
+	testutils.AssertEqual(t, true, pty.Resize(0, 40) != nil)
+}
+
+func TestCoderExecPTYCloseEndsTheCommand(t *testing.T) {
+	killed := localPTY(t)
+	pty, err := CoderExecPTY([]string{"sleep", "60"}, 80, 24)
+	testutils.AssertEqual(t, nil, err)
+
+	pty.Close()
+	pty.Close()
+	select {
+	case <-pty.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("the command kept running")
+	}
+	testutils.AssertEqual(t, pty.execID, <-killed)
+	testutils.AssertEqual(t, 0, len(killed))
+
+	_, err = pty.Read(make([]byte, 16))
+	testutils.AssertEqual(t, true, err != nil)
+}
+
+func TestCoderExecPTYEndsWithTheCommand(t *testing.T) {
+	localPTY(t)
+	pty, err := CoderExecPTY([]string{"true"}, 80, 24)
+	testutils.AssertEqual(t, nil, err)
+	defer pty.Close()
+
+	select {
+	case <-pty.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Done wasn't closed when the command exited")
+	}
+}
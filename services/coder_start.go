@@ -0,0 +1,208 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/The-Skyscape/devtools/pkg/containers"
+)
+
+// Coder startup states reported by CoderState.
+const (
+	CoderStarting = "starting" // The container is being prepared and launched
+	CoderRunning  = "running"  // The container started; CoderHealth watches it from here
+	CoderFailed   = "failed"   // The last attempt failed, see LastError
+	CoderPaused   = "paused"   // Stopped on purpose by PauseCoder; EnsureCoder resumes it
+)
+
+const (
+	coderStartBackoff    = 5 * time.Second // Wait after the first failed attempt
+	coderStartMaxBackoff = 5 * time.Minute // Cap on the doubling wait
+	coderStartAttempts   = 12              // Attempts before EnsureCoder gives up until it's called again
+)
+
+// ErrCoderNotReady is returned by commands and the proxy until the coder
+// container has started.
+var ErrCoderNotReady = errors.New("coder service not ready yet")
+
+// coderPrepareScript creates the directories mounted into the coder
+// container, owned by its user.
+const coderPrepareScript = `
+	mkdir -p /mnt/data/services/workbench-coder
+	mkdir -p /mnt/data/services/workbench-coder/.config
+	mkdir -p /mnt/data/services/workbench-coder/repos
+	chown -R 1000:1000 /mnt/data/services/workbench-coder || true
+	chmod -R go-w /mnt/data/services/workbench-coder || true
+	chmod 755 /mnt/data/services/workbench-coder /mnt/data/services/workbench-coder/repos
+	chmod 700 /mnt/data/services/workbench-coder/.config
+`
+
+// CoderStartStatus is a snapshot of bringing up the coder container.
+type CoderStartStatus struct {
+	State       string    `json:"state"`
+	LastError   string    `json:"last_error,omitempty"` // Why the last attempt failed
+	Attempts    int       `json:"attempts"`
+	NextAttempt time.Time `json:"next_attempt,omitzero"` // When a failed start is retried; zero once EnsureCoder gave up
+	PausedAt    time.Time `json:"paused_at,omitzero"`    // When PauseCoder stopped the container, while paused
+}
+
+// coderStarter starts the coder container, retrying with a doubling wait,
+// and pauses it. The start, stop, and wait hooks are fields so retries can
+// be tested without Docker or sleeping.
+type coderStarter struct {
+	mu        sync.Mutex
+	status    CoderStartStatus
+	looping   bool          // An attempt is running or waiting
+	retry     chan struct{} // Cuts the wait before the next attempt short
+	ready     chan struct{} // Closed once the container has started
+	readyOnce sync.Once
+	start     func() error
+	stop      func() error
+	wait      func(time.Duration) <-chan time.Time
+}
+
+func newCoderStarter(start func() error) *coderStarter {
+	return &coderStarter{
+		status: CoderStartStatus{State: CoderStarting},
+		retry:  make(chan struct{}, 1),
+		ready:  make(chan struct{}),
+		start:  start,
+		stop:   stopCoderContainer,
+		wait:   time.After,
+	}
+}
+
+var coderStart = newCoderStarter(startCoderContainer)
+
+// CoderState returns how far starting the coder container has got.
+func CoderState() CoderStartStatus {
+	coderStart.mu.Lock()
+	defer coderStart.mu.Unlock()
+	return coderStart.status
+}
+
+// CoderReady returns a channel closed once the coder container has
+// started, for startup work that runs commands in it.
+func CoderReady() <-chan struct{} {
+	return coderStart.ready
+}
+
+// EnsureCoder starts the coder container in the background, reusing it
+// when it's already running, and retries failures with a doubling wait
+// up to 5 minutes. Calling it again retries a waiting attempt right away,
+// or starts over once it gave up or was paused; it does nothing once the
+// container runs.
+func EnsureCoder() {
+	coderStart.ensure()
+}
+
+func (s *coderStarter) ensure() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case s.status.State == CoderRunning:
+	case s.looping:
+		select {
+		case s.retry <- struct{}{}:
+		default:
+		}
+	default:
+		select {
+		case <-s.retry:
+		default:
+		}
+		s.looping = true
+		s.status.State, s.status.Attempts, s.status.NextAttempt, s.status.PausedAt = CoderStarting, 0, time.Time{}, time.Time{}
+		go s.run()
+	}
+}
+
+// run makes attempts until one succeeds or coderStartAttempts have failed.
+func (s *coderStarter) run() {
+	backoff := coderStartBackoff
+	for attempt := 1; ; attempt++ {
+		s.mu.Lock()
+		s.status.State, s.status.Attempts, s.status.NextAttempt = CoderStarting, attempt, time.Time{}
+		s.mu.Unlock()
+
+		err := s.start()
+
+		s.mu.Lock()
+		if err == nil {
+			s.status = CoderStartStatus{State: CoderRunning, Attempts: attempt}
+			s.looping = false
+			s.mu.Unlock()
+			s.readyOnce.Do(func() { close(s.ready) })
+			slog.Info("coder service started", "attempts", attempt)
+			return
+		}
+		s.status.State, s.status.LastError = CoderFailed, err.Error()
+		if attempt >= coderStartAttempts {
+			s.looping = false
+			s.mu.Unlock()
+			slog.Error("gave up starting coder service", "attempts", attempt, "error", err)
+			return
+		}
+		s.status.NextAttempt = time.Now().Add(backoff)
+		s.mu.Unlock()
+		slog.Warn("failed to start coder service", "attempt", attempt, "retry_in", backoff, "error", err)
+
+		select {
+		case <-s.wait(backoff):
+		case <-s.retry:
+		}
+		backoff = min(backoff*2, coderStartMaxBackoff)
+	}
+}
+
+// coderNotReady explains why the coder container can't be used yet.
+func coderNotReady(status CoderStartStatus) error {
+	switch status.State {
+	case CoderFailed:
+		return fmt.Errorf("%w - starting it failed: %s", ErrCoderNotReady, status.LastError)
+	case CoderPaused:
+		return fmt.Errorf("%w - it was paused to save resources; resume it from the dashboard", ErrCoderNotReady)
+	}
+	return fmt.Errorf("%w - the VS Code server is still starting", ErrCoderNotReady)
+}
+
+// checkCoderReady returns an error unless the coder container has started
+// and is running.
+func checkCoderReady() error {
+	if status := CoderState(); status.State != CoderRunning {
+		return coderNotReady(status)
+	}
+	if Coder == nil {
+		return fmt.Errorf("coder service not initialized")
+	}
+	if !Coder.IsRunning() {
+		return fmt.Errorf("coder service not running")
+	}
+	return nil
+}
+
+// startCoderContainer prepares the mounted directories and launches the
+// coder container from the coder_image_tag image. A container that's
+// already running is reused.
+func startCoderContainer() error {
+	existing := containers.Local().Service("workbench-coder")
+	if existing != nil && existing.IsRunning() {
+		slog.Info("coder service already running")
+		Coder = existing
+		return nil
+	}
+
+	if err := containers.Local().Exec("bash", "-c", coderPrepareScript); err != nil {
+		return fmt.Errorf("failed to prepare the coder directories: %w", err)
+	}
+
+	Coder.Image = desiredCoderImage()
+	slog.Info("starting coder container", "image", Coder.Image)
+	if err := containers.Launch(containers.Local(), Coder); err != nil {
+		return fmt.Errorf("failed to launch the coder container: %w", err)
+	}
+	return nil
+}
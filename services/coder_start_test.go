@@ -0,0 +1,100 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/The-Skyscape/devtools/pkg/testutils"
+)
+
+// newTestStarter returns a starter whose attempts return errs in turn and
+// then succeed, recording each wait instead of sleeping.
+func newTestStarter(errs []error, waits *[]time.Duration) *coderStarter {
+	attempt := 0
+	s := newCoderStarter(func() error {
+		attempt++
+		if attempt <= len(errs) {
+			return errs[attempt-1]
+		}
+		return nil
+	})
+	s.wait = func(d time.Duration) <-chan time.Time {
+		*waits = append(*waits, d)
+		done := make(chan time.Time, 1)
+		done <- time.Time{}
+		return done
+	}
+	return s
+}
+
+func TestCoderStarterRetriesWithBackoff(t *testing.T) {
+	var waits []time.Duration
+	s := newTestStarter([]error{errors.New("Cannot connect to the Docker daemon"), errors.New("Cannot connect to the Docker daemon")}, &waits)
+
+	s.looping = true
+	s.run()
+
+	testutils.AssertEqual(t, []time.Duration{5 * time.Second, 10 * time.Second}, waits)
+	testutils.AssertEqual(t, CoderRunning, s.status.State)
+	testutils.AssertEqual(t, 3, s.status.Attempts)
+	testutils.AssertEqual(t, "", s.status.LastError)
+	testutils.AssertEqual(t, false, s.looping)
+	select {
+	case <-s.ready:
+	default:
+		t.Fatal("ready should be closed once the container started")
+	}
+}
+
+func TestCoderStarterGivesUp(t *testing.T) {
+	var waits []time.Duration
+	errs := make([]error, coderStartAttempts)
+	for i := range errs {
+		errs[i] = errors.New("failed to prepare the coder directories: permission denied")
+	}
+	s := newTestStarter(errs, &waits)
+
+	s.looping = true
+	s.run()
+
+	testutils.AssertEqual(t, coderStartAttempts-1, len(waits))
+	testutils.AssertEqual(t, coderStartMaxBackoff, waits[len(waits)-1])
+	testutils.AssertEqual(t, CoderFailed, s.status.State)
+	testutils.AssertEqual(t, "failed to prepare the coder directories: permission denied", s.status.LastError)
+	testutils.AssertEqual(t, true, s.status.NextAttempt.IsZero())
+	testutils.AssertEqual(t, false, s.looping)
+	select {
+	case <-s.ready:
+		t.Fatal("ready should stay open while the container hasn't started")
+	default:
+	}
+}
+
+func TestCoderStarterEnsure(t *testing.T) {
+	var waits []time.Duration
+	s := newTestStarter(nil, &waits)
+
+	// A waiting attempt is cut short rather than a second loop started
+	s.looping = true
+	s.ensure()
+	s.ensure()
+	testutils.AssertEqual(t, 1, len(s.retry))
+
+	// Nothing happens once the container runs
+	<-s.retry
+	s.looping = false
+	s.status.State = CoderRunning
+	s.ensure()
+	testutils.AssertEqual(t, 0, len(s.retry))
+	testutils.AssertEqual(t, false, s.looping)
+}
+
+func TestCoderNotReady(t *testing.T) {
+	err := coderNotReady(CoderStartStatus{State: CoderStarting})
+	testutils.AssertEqual(t, true, errors.Is(err, ErrCoderNotReady))
+	testutils.AssertEqual(t, "coder service not ready yet - the VS Code server is still starting", err.Error())
+
+	err = coderNotReady(CoderStartStatus{State: CoderFailed, LastError: "failed to launch the coder container: no such image"})
+	testutils.AssertEqual(t, "coder service not ready yet - starting it failed: failed to launch the coder container: no such image", err.Error())
+}
@@ -0,0 +1,149 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// coderStatsTTL is how long a docker stats reading is reused. docker stats
+// takes about a second, and several dashboard partials poll at once.
+const coderStatsTTL = 2 * time.Second
+
+// ContainerStats is a resource usage reading of the coder container.
+type ContainerStats struct {
+	Running       bool    // False when the container is stopped or missing; other fields are zero
+	CPUPercent    float64 // Of one core, so can exceed 100 on multi-core hosts
+	MemoryUsage   uint64  // Bytes
+	MemoryLimit   uint64  // Bytes; the host's memory when the container has no limit
+	MemoryPercent float64
+	NetworkRx     uint64 // Bytes received since the container started
+	NetworkTx     uint64 // Bytes sent since the container started
+	PIDs          int
+	At            time.Time
+}
+
+var (
+	// coderStatsExec runs docker stats for a container. Replaced in tests.
+	coderStatsExec = func(name string) (string, error) {
+		output, err := exec.Command("docker", "stats", "--no-stream", "--format", "{{json .}}", name).CombinedOutput()
+		return string(output), err
+	}
+	coderStatsNow = time.Now
+
+	coderStatsMu     sync.Mutex
+	coderStatsCached *ContainerStats
+)
+
+// CoderStats returns the coder container's CPU, memory, network, and
+// process usage, reusing a reading for up to two seconds. A stopped or
+// missing container gives zeroed stats with Running false, not an error.
+func CoderStats() (ContainerStats, error) {
+	coderStatsMu.Lock()
+	defer coderStatsMu.Unlock()
+	now := coderStatsNow()
+	if coderStatsCached != nil && now.Sub(coderStatsCached.At) < coderStatsTTL {
+		return *coderStatsCached, nil
+	}
+
+	name := "workbench-coder"
+	if Coder != nil {
+		name = Coder.Name
+	}
+	output, err := coderStatsExec(name)
+	var stats *ContainerStats
+	switch {
+	case err != nil && strings.Contains(output, "No such container"):
+		stats = &ContainerStats{}
+	case err != nil:
+		return ContainerStats{}, fmt.Errorf("failed to read stats for %s: %s", name, strings.TrimSpace(output))
+	default:
+		if stats, err = parseContainerStats(output); err != nil {
+			return ContainerStats{}, err
+		}
+	}
+
+	stats.At = now
+	coderStatsCached = stats
+	return *stats, nil
+}
+
+// parseContainerStats parses one line of docker stats --format '{{json .}}'.
+// A stopped container reports no processes and is returned zeroed.
+func parseContainerStats(output string) (*ContainerStats, error) {
+	var raw struct {
+		CPUPerc  string
+		MemUsage string
+		MemPerc  string
+		NetIO    string
+		PIDs     string
+	}
+	if err := json.Unmarshal([]byte(strings.TrimSpace(output)), &raw); err != nil {
+		return nil, fmt.Errorf("unexpected docker stats output %q", strings.TrimSpace(output))
+	}
+
+	pids, _ := strconv.Atoi(raw.PIDs)
+	if pids == 0 {
+		return &ContainerStats{}, nil
+	}
+	stats := &ContainerStats{Running: true, PIDs: pids}
+	stats.CPUPercent, _ = strconv.ParseFloat(strings.TrimSuffix(raw.CPUPerc, "%"), 64)
+	stats.MemoryPercent, _ = strconv.ParseFloat(strings.TrimSuffix(raw.MemPerc, "%"), 64)
+
+	var err error
+	if stats.MemoryUsage, stats.MemoryLimit, err = parseSizePair(raw.MemUsage); err != nil {
+		return nil, err
+	}
+	if stats.NetworkRx, stats.NetworkTx, err = parseSizePair(raw.NetIO); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// parseSizePair parses docker's "used / total" or "in / out" sizes.
+func parseSizePair(s string) (uint64, uint64, error) {
+	first, second, found := strings.Cut(s, "/")
+	if !found {
+		return 0, 0, fmt.Errorf("unexpected docker size pair %q", s)
+	}
+	a, err := parseDockerSize(first)
+	if err != nil {
+		return 0, 0, err
+	}
+	b, err := parseDockerSize(second)
+	if err != nil {
+		return 0, 0, err
+	}
+	return a, b, nil
+}
+
+// dockerSizeUnits are the suffixes docker stats prints: decimal for network
+// and block IO, binary for memory.
+var dockerSizeUnits = []struct {
+	suffix     string
+	multiplier float64
+}{
+	// Multi-letter suffixes first, so "MiB" isn't read as bytes
+	{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30}, {"TiB", 1 << 40},
+	{"kB", 1e3}, {"KB", 1e3}, {"MB", 1e6}, {"GB", 1e9}, {"TB", 1e12},
+	{"B", 1},
+}
+
+// parseDockerSize parses a size such as "98.5MiB" or "1.2kB" into bytes.
+func parseDockerSize(s string) (uint64, error) {
+	s = strings.TrimSpace(s)
+	for _, unit := range dockerSizeUnits {
+		if number, ok := strings.CutSuffix(s, unit.suffix); ok {
+			n, err := strconv.ParseFloat(number, 64)
+			if err != nil {
+				break
+			}
+			return uint64(n * unit.multiplier), nil
+		}
+	}
+	return 0, fmt.Errorf("unexpected docker size %q", s)
+}
@@ -0,0 +1,115 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/The-Skyscape/devtools/pkg/testutils"
+)
+
+func TestParseContainerStats(t *testing.T) {
+	stats, err := parseContainerStats(`{"BlockIO":"0B / 0B","CPUPerc":"12.50%","Container":"workbench-coder","MemPerc":"6.25%","MemUsage":"512MiB / 8GiB","Name":"workbench-coder","NetIO":"1.5kB / 648B","PIDs":"21"}` + "\n")
+	testutils.AssertEqual(t, nil, err)
+	testutils.AssertEqual(t, true, stats.Running)
+	testutils.AssertEqual(t, 12.5, stats.CPUPercent)
+	testutils.AssertEqual(t, uint64(512<<20), stats.MemoryUsage)
+	testutils.AssertEqual(t, uint64(8<<30), stats.MemoryLimit)
+	testutils.AssertEqual(t, 6.25, stats.MemoryPercent)
+	testutils.AssertEqual(t, uint64(1500), stats.NetworkRx)
+	testutils.AssertEqual(t, uint64(648), stats.NetworkTx)
+	testutils.AssertEqual(t, 21, stats.PIDs)
+}
+
+func TestParseContainerStatsStopped(t *testing.T) {
+	stats, err := parseContainerStats(`{"CPUPerc":"0.00%","MemPerc":"0.00%","MemUsage":"0B / 0B","NetIO":"0B / 0B","PIDs":"0"}`)
+	testutils.AssertEqual(t, nil, err)
+	testutils.AssertEqual(t, false, stats.Running)
+	testutils.AssertEqual(t, uint64(0), stats.MemoryLimit)
+}
+
+func TestParseContainerStatsRejectsGarbage(t *testing.T) {
+	_, err := parseContainerStats("Cannot connect to the Docker daemon")
+	testutils.AssertEqual(t, true, err != nil)
+
+	_, err = parseContainerStats(`{"CPUPerc":"1%","MemUsage":"lots","NetIO":"0B / 0B","PIDs":"3"}`)
+	testutils.AssertEqual(t, true, err != nil)
+}
+
+func TestParseDockerSize(t *testing.T) {
+	testCases := []struct {
+		size     string
+		expected uint64
+	}{
+		{"0B", 0},
+		{"648B", 648},
+		{"1.5kB", 1500},
+		{"2KiB", 2048},
+		{"98.5MiB", 103284736},
+		{"3MB", 3000000},
+		{" 1GiB ", 1 << 30},
+		{"1.2GB", 1200000000},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.size, func(t *testing.T) {
+			size, err := parseDockerSize(tc.size)
+			testutils.AssertEqual(t, nil, err)
+			testutils.AssertEqual(t, tc.expected, size)
+		})
+	}
+}
+
+// fakeCoderStats replaces docker stats and the clock, counting calls.
+func fakeCoderStats(t *testing.T, output string, err error, now *time.Time) *int {
+	calls := 0
+	originalExec, originalNow := coderStatsExec, coderStatsNow
+	coderStatsExec = func(name string) (string, error) {
+		calls++
+		return output, err
+	}
+	coderStatsNow = func() time.Time { return *now }
+	coderStatsCached = nil
+	t.Cleanup(func() {
+		coderStatsExec, coderStatsNow = originalExec, originalNow
+		coderStatsCached = nil
+	})
+	return &calls
+}
+
+func TestCoderStatsCachesReadings(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	calls := fakeCoderStats(t, `{"CPUPerc":"5%","MemPerc":"1%","MemUsage":"1MiB / 1GiB","NetIO":"0B / 0B","PIDs":"4"}`, nil, &now)
+
+	CoderStats()
+	now = now.Add(time.Second)
+	stats, err := CoderStats()
+	testutils.AssertEqual(t, nil, err)
+	testutils.AssertEqual(t, 1, *calls)
+	testutils.AssertEqual(t, 5.0, stats.CPUPercent)
+
+	now = now.Add(coderStatsTTL)
+	CoderStats()
+	testutils.AssertEqual(t, 2, *calls)
+}
+
+func TestCoderStatsMissingContainer(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	fakeCoderStats(t, "Error response from daemon: No such container: workbench-coder", errors.New("exit status 1"), &now)
+
+	stats, err := CoderStats()
+	testutils.AssertEqual(t, nil, err)
+	testutils.AssertEqual(t, false, stats.Running)
+	testutils.AssertEqual(t, now, stats.At)
+}
+
+func TestCoderStatsDockerFailure(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	calls := fakeCoderStats(t, "Cannot connect to the Docker daemon", errors.New("exit status 1"), &now)
+
+	_, err := CoderStats()
+	testutils.AssertEqual(t, true, err != nil)
+	// Failures aren't cached, so the next refresh tries again
+	CoderStats()
+	testutils.AssertEqual(t, 2, *calls)
+}
@@ -0,0 +1,23 @@
+package services
+
+import "context"
+
+// requestIDKey is the context key of the request an operation serves.
+type requestIDKey struct{}
+
+// WithRequestID returns ctx carrying the ID of the request it serves, so
+// log lines of the operations it starts, such as commands run in the
+// coder container, can be matched to the request.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	if id == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the request ID carried by ctx, or "" when there is
+// none.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
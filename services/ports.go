@@ -0,0 +1,147 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// errCoderUnreachable means the coder container has no address to dial,
+// usually because it isn't running.
+var errCoderUnreachable = errors.New("coder container has no network address")
+
+// coderAddress looks up the coder container's IP address on its docker
+// network. Ports other than code-server's aren't published on the host,
+// so forwards dial the container directly. Replaced in tests.
+var coderAddress = func() (string, error) {
+	name := "workbench-coder"
+	if Coder != nil {
+		name = Coder.Name
+	}
+	output, err := exec.Command("docker", "inspect", "-f",
+		"{{range .NetworkSettings.Networks}}{{.IPAddress}} {{end}}", name).Output()
+	if err != nil {
+		return "", errCoderUnreachable
+	}
+	fields := strings.Fields(string(output))
+	if len(fields) == 0 {
+		return "", errCoderUnreachable
+	}
+	return fields[0], nil
+}
+
+// coderIP caches coderAddress until a dial fails, since the container gets
+// a new address when it's recreated.
+var coderIP struct {
+	sync.Mutex
+	address string
+}
+
+func cachedCoderAddress() (string, error) {
+	coderIP.Lock()
+	defer coderIP.Unlock()
+	if coderIP.address == "" {
+		address, err := coderAddress()
+		if err != nil {
+			return "", err
+		}
+		coderIP.address = address
+	}
+	return coderIP.address, nil
+}
+
+func forgetCoderAddress() {
+	coderIP.Lock()
+	coderIP.address = ""
+	coderIP.Unlock()
+}
+
+// portTransport dials the coder container for every forward, whatever
+// host the request URL names, keeping connections alive per port.
+var portTransport = func() *http.Transport {
+	transport := newProxyTransport()
+	dialer := &net.Dialer{Timeout: 5 * time.Second, KeepAlive: 30 * time.Second}
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		_, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		host, err := cachedCoderAddress()
+		if err != nil {
+			return nil, err
+		}
+		conn, err := dialer.DialContext(ctx, network, net.JoinHostPort(host, port))
+		if err != nil {
+			forgetCoderAddress()
+		}
+		return conn, err
+	}
+	return transport
+}()
+
+// PortProxy returns a reverse proxy to a port inside the coder container.
+// Requests arrive with the forward's prefix already stripped. The Host
+// header names localhost, which dev servers accept by default, and
+// WebSocket upgrades pass through for hot reloading. When nothing answers
+// on the port, the browser gets a page saying so instead of a bare 502.
+func PortProxy(port int) http.Handler {
+	target := &url.URL{Scheme: "http", Host: "localhost:" + strconv.Itoa(port)}
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(r *httputil.ProxyRequest) {
+			r.SetURL(target)
+			r.SetXForwarded()
+		},
+		Transport:     portTransport,
+		BufferPool:    newBufferPool(DefaultProxyBufferSize),
+		FlushInterval: -1, // Event streams and HMR pings arrive as they're sent
+		ErrorHandler:  portProxyError(port),
+	}
+	return compressResponses(proxy)
+}
+
+// portProxyError explains why a forward couldn't reach its port.
+func portProxyError(port int) func(http.ResponseWriter, *http.Request, error) {
+	return func(w http.ResponseWriter, r *http.Request, err error) {
+		var opErr *net.OpError
+		switch {
+		case errors.Is(err, context.Canceled):
+			return // The browser went away
+		case errors.Is(err, errCoderUnreachable):
+			writeProxyError(w, "The coder container isn't running",
+				fmt.Sprintf("Start it from the dashboard, then start whatever listens on port %d.", port))
+		case errors.As(err, &opErr) && opErr.Op == "dial":
+			writeProxyError(w, fmt.Sprintf("Nothing is listening on port %d", port),
+				"Start your dev server in the VS Code terminal, and make sure it listens on 0.0.0.0 rather than only localhost (e.g. vite --host).")
+		default:
+			writeProxyError(w, fmt.Sprintf("Port %d didn't answer", port), err.Error())
+		}
+	}
+}
+
+var proxyErrorPage = template.Must(template.New("proxy-error").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head><meta charset="utf-8"><meta http-equiv="refresh" content="5"><title>{{.Title}}</title></head>
+<body style="font-family: system-ui, sans-serif; max-width: 40rem; margin: 4rem auto; padding: 0 1rem">
+<h1 style="font-size: 1.5rem">{{.Title}}</h1>
+<p>{{.Hint}}</p>
+<p style="color: #888">This page reloads every few seconds.</p>
+</body>
+</html>
+`))
+
+func writeProxyError(w http.ResponseWriter, title, hint string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusBadGateway)
+	proxyErrorPage.Execute(w, map[string]string{"Title": title, "Hint": hint})
+}
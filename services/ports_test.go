@@ -0,0 +1,115 @@
+package services
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/The-Skyscape/devtools/pkg/testutils"
+)
+
+// localCoder points forwards at this machine, standing in for the coder
+// container's address.
+func localCoder(t *testing.T) {
+	original := coderAddress
+	coderAddress = func() (string, error) { return "127.0.0.1", nil }
+	forgetCoderAddress()
+	t.Cleanup(func() {
+		coderAddress = original
+		forgetCoderAddress()
+	})
+}
+
+// backendPort returns the port a test server listens on.
+func backendPort(t *testing.T, handler http.Handler) int {
+	backend := httptest.NewServer(handler)
+	t.Cleanup(backend.Close)
+	_, port, _ := net.SplitHostPort(strings.TrimPrefix(backend.URL, "http://"))
+	n, _ := strconv.Atoi(port)
+	return n
+}
+
+func TestPortProxyForwardsToPort(t *testing.T) {
+	localCoder(t)
+	var host, prefix string
+	port := backendPort(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, prefix = r.Host, r.Header.Get("X-Forwarded-Prefix")
+		io.WriteString(w, "hello from "+r.URL.Path)
+	}))
+
+	r := httptest.NewRequest(http.MethodGet, "/src/main.ts", nil)
+	r.Header.Set("X-Forwarded-Prefix", "/apps/web")
+	w := httptest.NewRecorder()
+	PortProxy(port).ServeHTTP(w, r)
+
+	testutils.AssertEqual(t, http.StatusOK, w.Code)
+	testutils.AssertEqual(t, "hello from /src/main.ts", w.Body.String())
+	testutils.AssertEqual(t, "localhost:"+strconv.Itoa(port), host)
+	testutils.AssertEqual(t, "/apps/web", prefix)
+}
+
+func TestPortProxyExplainsClosedPort(t *testing.T) {
+	localCoder(t)
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	port := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+
+	w := httptest.NewRecorder()
+	PortProxy(port).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	testutils.AssertEqual(t, http.StatusBadGateway, w.Code)
+	testutils.AssertEqual(t, "text/html; charset=utf-8", w.Header().Get("Content-Type"))
+	testutils.AssertEqual(t, true, strings.Contains(w.Body.String(), "Nothing is listening on port "+strconv.Itoa(port)))
+}
+
+func TestPortProxyExplainsStoppedCoder(t *testing.T) {
+	original := coderAddress
+	coderAddress = func() (string, error) { return "", errCoderUnreachable }
+	forgetCoderAddress()
+	t.Cleanup(func() { coderAddress = original })
+
+	w := httptest.NewRecorder()
+	PortProxy(5173).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	testutils.AssertEqual(t, http.StatusBadGateway, w.Code)
+	testutils.AssertEqual(t, true, strings.Contains(w.Body.String(), "coder container isn&#39;t running"))
+}
+
+func TestPortProxyUpgradesWebSockets(t *testing.T) {
+	localCoder(t)
+	port := backendPort(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") != "websocket" {
+			http.Error(w, "expected an upgrade", http.StatusBadRequest)
+			return
+		}
+		conn, rw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+		rw.Flush()
+		line, _ := rw.ReadString('\n')
+		rw.WriteString("echo " + line)
+		rw.Flush()
+	}))
+	front := httptest.NewServer(PortProxy(port))
+	t.Cleanup(front.Close)
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(front.URL, "http://"))
+	testutils.AssertEqual(t, nil, err)
+	defer conn.Close()
+	io.WriteString(conn, "GET /hmr HTTP/1.1\r\nHost: workbench\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nAccept-Encoding: gzip\r\n\r\n")
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	testutils.AssertEqual(t, nil, err)
+	testutils.AssertEqual(t, http.StatusSwitchingProtocols, resp.StatusCode)
+
+	io.WriteString(conn, "update\n")
+	line, _ := reader.ReadString('\n')
+	testutils.AssertEqual(t, "echo update\n", line)
+}
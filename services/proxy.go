@@ -0,0 +1,193 @@
+package services
+
+import (
+	"compress/gzip"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultProxyBufferSize is how much of a response the coder proxy copies
+// before writing it to the client, matching httputil's default.
+const DefaultProxyBufferSize = 32 << 10
+
+// minCompressSize is the smallest response worth gzipping. Responses of
+// unknown length are always compressed.
+const minCompressSize = 1024
+
+// hashedAsset matches code-server's static assets under a commit-hashed
+// path, e.g. stable-<sha>/static/out/vs/workbench/workbench.web.main.js.
+// Their content never changes for a given path.
+var hashedAsset = regexp.MustCompile(`^/?(stable|insider)-[0-9a-f]{40}/static/`)
+
+// newProxyTransport pools keepalive connections to code-server so asset
+// requests don't each open a new connection. Compression is left to
+// code-server and the client: the Accept-Encoding header passes through
+// and responses are never transparently decompressed.
+func newProxyTransport() *http.Transport {
+	return &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout:   5 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConns:          64,
+		MaxIdleConnsPerHost:   64, // VS Code loads hundreds of assets in parallel
+		IdleConnTimeout:       90 * time.Second,
+		ExpectContinueTimeout: time.Second,
+		DisableCompression:    true,
+	}
+}
+
+// newProxy returns a reverse proxy to target that streams responses in
+// bufferSize chunks, marks hashed static assets immutable, and gzips
+// compressible responses the backend sent uncompressed.
+func newProxy(target *url.URL, transport http.RoundTripper, bufferSize int) http.Handler {
+	if bufferSize <= 0 {
+		bufferSize = DefaultProxyBufferSize
+	}
+
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	proxy.Transport = transport
+	proxy.BufferPool = newBufferPool(bufferSize)
+	proxy.FlushInterval = -1 // Flush after every chunk so downloads stream
+	proxy.ModifyResponse = cacheHashedAssets
+	return compressResponses(proxy)
+}
+
+// cacheHashedAssets lets the browser keep code-server's hashed static
+// assets instead of revalidating them on every load.
+func cacheHashedAssets(resp *http.Response) error {
+	if resp.StatusCode == http.StatusOK && hashedAsset.MatchString(resp.Request.URL.Path) {
+		resp.Header.Set("Cache-Control", "public, max-age=31536000, immutable")
+	}
+	return nil
+}
+
+// bufferPool implements httputil.BufferPool with fixed-size buffers.
+type bufferPool struct {
+	pool sync.Pool
+}
+
+func newBufferPool(size int) *bufferPool {
+	return &bufferPool{pool: sync.Pool{New: func() any { return make([]byte, size) }}}
+}
+
+func (p *bufferPool) Get() []byte  { return p.pool.Get().([]byte) }
+func (p *bufferPool) Put(b []byte) { p.pool.Put(b) }
+
+var gzipWriters = sync.Pool{New: func() any { return gzip.NewWriter(io.Discard) }}
+
+// compressResponses gzips responses for clients that accept it. Responses
+// that already have a Content-Encoding (code-server's own gzip or brotli)
+// pass through untouched, as do upgrades, partial content, and small or
+// binary bodies.
+func compressResponses(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead || r.Header.Get("Upgrade") != "" || !acceptsGzip(r.Header.Get("Accept-Encoding")) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		gw := &gzipResponseWriter{ResponseWriter: w}
+		defer gw.close()
+		next.ServeHTTP(gw, r)
+	})
+}
+
+// acceptsGzip reports whether an Accept-Encoding header allows gzip.
+func acceptsGzip(header string) bool {
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if !strings.EqualFold(strings.TrimSpace(coding), "gzip") {
+			continue
+		}
+		q := strings.ReplaceAll(strings.TrimSpace(params), " ", "")
+		return q != "q=0" && q != "q=0.0" && q != "q=0.00" && q != "q=0.000"
+	}
+	return false
+}
+
+// compressible reports whether a Content-Type benefits from gzip.
+func compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	switch {
+	case strings.HasPrefix(mediaType, "text/"):
+		return true
+	case mediaType == "application/javascript", mediaType == "application/json", mediaType == "application/xml",
+		mediaType == "application/wasm", mediaType == "image/svg+xml", strings.HasSuffix(mediaType, "+json"):
+		return true
+	default:
+		return false
+	}
+}
+
+// gzipResponseWriter decides on the first WriteHeader whether to compress,
+// based on the headers the backend sent.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	gz          *gzip.Writer // Nil unless compressing
+	wroteHeader bool
+}
+
+func (w *gzipResponseWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+
+	h := w.Header()
+	length, lengthErr := strconv.Atoi(h.Get("Content-Length"))
+	if code == http.StatusOK && h.Get("Content-Encoding") == "" && compressible(h.Get("Content-Type")) &&
+		(lengthErr != nil || length >= minCompressSize) {
+		h.Set("Content-Encoding", "gzip")
+		h.Del("Content-Length")
+		h.Add("Vary", "Accept-Encoding")
+		w.gz = gzipWriters.Get().(*gzip.Writer)
+		w.gz.Reset(w.ResponseWriter)
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *gzipResponseWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.gz != nil {
+		return w.gz.Write(p)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *gzipResponseWriter) Flush() {
+	if w.gz != nil {
+		w.gz.Flush()
+	}
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Unwrap lets http.ResponseController reach the connection, which the
+// proxy needs to hijack it for websocket upgrades.
+func (w *gzipResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *gzipResponseWriter) close() {
+	if w.gz == nil {
+		return
+	}
+	w.gz.Close()
+	w.gz.Reset(io.Discard)
+	gzipWriters.Put(w.gz)
+	w.gz = nil
+}
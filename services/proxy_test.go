@@ -0,0 +1,190 @@
+package services
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/The-Skyscape/devtools/pkg/testutils"
+)
+
+// proxyBackend starts a local stand-in for code-server and counts the
+// connections the proxy opens to it.
+func proxyBackend(t testing.TB, handler http.HandlerFunc) (*url.URL, *atomic.Int64) {
+	var conns atomic.Int64
+	backend := httptest.NewUnstartedServer(handler)
+	backend.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	backend.Start()
+	t.Cleanup(backend.Close)
+
+	target, _ := url.Parse(backend.URL)
+	return target, &conns
+}
+
+// proxyGet sends a GET through the proxy and returns the recorded response.
+func proxyGet(proxy http.Handler, path, acceptEncoding string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, path, nil)
+	if acceptEncoding != "" {
+		r.Header.Set("Accept-Encoding", acceptEncoding)
+	}
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, r)
+	return w
+}
+
+var script = strings.Repeat("console.log('hello from the workbench');\n", 200)
+
+func TestProxyReusesConnections(t *testing.T) {
+	target, conns := proxyBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/javascript")
+		io.WriteString(w, script)
+	})
+	proxy := newProxy(target, newProxyTransport(), 0)
+
+	for i := 0; i < 20; i++ {
+		testutils.AssertEqual(t, http.StatusOK, proxyGet(proxy, "/static/app.js", "").Code)
+	}
+	testutils.AssertEqual(t, int64(1), conns.Load())
+}
+
+func TestProxyPassesPrecompressedResponses(t *testing.T) {
+	var forwarded string
+	target, _ := proxyBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		forwarded = r.Header.Get("Accept-Encoding")
+		w.Header().Set("Content-Type", "text/javascript")
+		w.Header().Set("Content-Encoding", "br")
+		w.Write([]byte("pretend brotli bytes"))
+	})
+	proxy := newProxy(target, newProxyTransport(), 0)
+
+	w := proxyGet(proxy, "/static/app.js", "gzip, deflate, br")
+	testutils.AssertEqual(t, "gzip, deflate, br", forwarded)
+	testutils.AssertEqual(t, "br", w.Header().Get("Content-Encoding"))
+	testutils.AssertEqual(t, "pretend brotli bytes", w.Body.String())
+}
+
+func TestProxyCompressesPlainResponses(t *testing.T) {
+	target, _ := proxyBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/logo.png" {
+			w.Header().Set("Content-Type", "image/png")
+		} else {
+			w.Header().Set("Content-Type", "text/javascript; charset=utf-8")
+		}
+		io.WriteString(w, script)
+	})
+	proxy := newProxy(target, newProxyTransport(), 0)
+
+	w := proxyGet(proxy, "/static/app.js", "gzip")
+	testutils.AssertEqual(t, "gzip", w.Header().Get("Content-Encoding"))
+	testutils.AssertEqual(t, "", w.Header().Get("Content-Length"))
+	testutils.AssertEqual(t, true, w.Body.Len() < len(script))
+	reader, err := gzip.NewReader(w.Body)
+	testutils.AssertEqual(t, nil, err)
+	body, _ := io.ReadAll(reader)
+	testutils.AssertEqual(t, script, string(body))
+
+	// Clients without gzip and binary content get the original bytes
+	w = proxyGet(proxy, "/static/app.js", "")
+	testutils.AssertEqual(t, "", w.Header().Get("Content-Encoding"))
+	testutils.AssertEqual(t, script, w.Body.String())
+
+	w = proxyGet(proxy, "/logo.png", "gzip;q=1.0")
+	testutils.AssertEqual(t, "", w.Header().Get("Content-Encoding"))
+
+	w = proxyGet(proxy, "/static/app.js", "gzip;q=0, br")
+	testutils.AssertEqual(t, "", w.Header().Get("Content-Encoding"))
+}
+
+func TestProxyCachesHashedAssets(t *testing.T) {
+	target, _ := proxyBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "missing.js") {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Cache-Control", "no-cache")
+	})
+	proxy := newProxy(target, newProxyTransport(), 0)
+	hashed := "/stable-" + strings.Repeat("a1", 20) + "/static/out/vs/workbench/workbench.web.main.js"
+
+	testCases := []struct {
+		path     string
+		expected string
+	}{
+		{hashed, "public, max-age=31536000, immutable"},
+		{strings.TrimSuffix(hashed, "workbench.web.main.js") + "missing.js", ""},
+		{"/_static/src/browser/media/favicon.ico", "no-cache"},
+		{"/stable-abc/static/out/main.js", "no-cache"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.path, func(t *testing.T) {
+			w := proxyGet(proxy, tc.path, "")
+			testutils.AssertEqual(t, tc.expected, w.Header().Get("Cache-Control"))
+		})
+	}
+}
+
+func TestProxyStreamsLargeDownloads(t *testing.T) {
+	release := make(chan struct{})
+	target, _ := proxyBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write(bytes.Repeat([]byte("x"), 64<<10))
+		w.(http.Flusher).Flush()
+		<-release // The rest of the file isn't ready until the client has the start
+		w.Write(bytes.Repeat([]byte("y"), 64<<10))
+	})
+	server := httptest.NewServer(newProxy(target, newProxyTransport(), 8<<10))
+	t.Cleanup(server.Close)
+
+	resp, err := http.Get(server.URL + "/download/archive.tar")
+	testutils.AssertEqual(t, nil, err)
+	defer resp.Body.Close()
+
+	start := make([]byte, 64<<10)
+	_, err = io.ReadFull(resp.Body, start)
+	testutils.AssertEqual(t, nil, err)
+	close(release)
+	rest, err := io.ReadAll(resp.Body)
+	testutils.AssertEqual(t, nil, err)
+	testutils.AssertEqual(t, 64<<10, len(rest))
+}
+
+// benchmarkProxy fetches an asset through the proxy and reports how many
+// backend connections each request cost.
+func benchmarkProxy(b *testing.B, transport *http.Transport) {
+	target, conns := proxyBackend(b, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/javascript")
+		io.WriteString(w, script)
+	})
+	proxy := newProxy(target, transport, 0)
+	b.Cleanup(transport.CloseIdleConnections)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		proxyGet(proxy, "/static/app.js", "gzip")
+	}
+	b.ReportMetric(float64(conns.Load())/float64(b.N), "conns/op")
+}
+
+// BenchmarkProxyFreshConnections is the proxy before pooling: a new
+// connection to the container for every request.
+func BenchmarkProxyFreshConnections(b *testing.B) {
+	transport := newProxyTransport()
+	transport.DisableKeepAlives = true
+	benchmarkProxy(b, transport)
+}
+
+func BenchmarkProxyPooledConnections(b *testing.B) {
+	benchmarkProxy(b, newProxyTransport())
+}
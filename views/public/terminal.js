@@ -0,0 +1,76 @@
+// Terminal panel: a shell in the coder container over the /api/terminal
+// WebSocket, drawn with xterm.js from /public/xterm/.
+(function() {
+    const encoder = new TextEncoder();
+
+    function openTerminal(panel) {
+        const screen = panel.querySelector('[data-terminal-screen]');
+        const status = panel.querySelector('[data-terminal-status]');
+        const button = panel.querySelector('[data-terminal-open]');
+
+        if (typeof Terminal === 'undefined' || typeof FitAddon === 'undefined') {
+            status.textContent = 'The terminal needs xterm.js in views/public/xterm/.';
+            return;
+        }
+
+        button.disabled = true;
+        status.textContent = 'Connecting...';
+        screen.replaceChildren();
+        screen.classList.remove('hidden');
+
+        const term = new Terminal({ cursorBlink: true, fontSize: 14, convertEol: false });
+        const fit = new FitAddon.FitAddon();
+        term.loadAddon(fit);
+        term.open(screen);
+        fit.fit();
+
+        const url = new URL(panel.dataset.url, window.location.href);
+        url.protocol = url.protocol === 'https:' ? 'wss:' : 'ws:';
+        url.searchParams.set('cols', term.cols);
+        url.searchParams.set('rows', term.rows);
+
+        const socket = new WebSocket(url);
+        socket.binaryType = 'arraybuffer';
+
+        const resize = () => {
+            fit.fit();
+            if (socket.readyState === WebSocket.OPEN) {
+                socket.send(JSON.stringify({ type: 'resize', cols: term.cols, rows: term.rows }));
+            }
+        };
+        const observer = new ResizeObserver(resize);
+
+        socket.onopen = () => {
+            status.textContent = 'Connected';
+            observer.observe(screen);
+            term.focus();
+        };
+        socket.onmessage = (event) => term.write(new Uint8Array(event.data));
+        socket.onclose = (event) => {
+            observer.disconnect();
+            const reason = event.reason || 'Terminal closed';
+            status.textContent = reason;
+            term.write('\r\n\x1b[2m[' + reason + ']\x1b[0m\r\n');
+            term.options.disableStdin = true;
+            button.disabled = false;
+        };
+
+        term.onData((data) => {
+            if (socket.readyState === WebSocket.OPEN) {
+                socket.send(encoder.encode(data));
+            }
+        });
+        term.onBinary((data) => {
+            if (socket.readyState === WebSocket.OPEN) {
+                socket.send(Uint8Array.from(data, (c) => c.charCodeAt(0)));
+            }
+        });
+    }
+
+    document.addEventListener('click', (event) => {
+        const button = event.target.closest('[data-terminal-open]');
+        if (button) {
+            openTerminal(button.closest('[data-terminal]'));
+        }
+    });
+})();